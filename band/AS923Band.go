@@ -0,0 +1,231 @@
+package band
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import "fmt"
+
+// AS923 represents configuration and frequency plan for the AS 923MHz ISM Band. There
+// are four variants of the band where the channels are offset from the AS923-1
+// frequencies [2.7.2/Regional Parameters]. Some countries limit the dwell time
+// to 400ms. The dwell time limits are enabled by default and can be lifted by
+// clearing UplinkDwellTime and DownlinkDwellTime.
+type AS923 struct {
+	configuration Configuration
+	variant       int
+	// UplinkDwellTime is set when uplinks are limited to a 400ms dwell time
+	UplinkDwellTime bool
+	// DownlinkDwellTime is set when downlinks are limited to a 400ms dwell time
+	DownlinkDwellTime bool
+}
+
+// as923Frequency returns the frequency (in MHz) for an AS923-1 frequency (in kHz)
+// with the offset applied. The offset is in kHz.
+func as923Frequency(khz int, offset int) float32 {
+	return float32(khz+offset) / 1000
+}
+
+// newAS923 creates a new AS923 band. The variant is 1-4 and the offset is the
+// frequency offset from AS923-1 in kHz [2.7.2/Regional Parameters].
+func newAS923(variant int, offset int) AS923 {
+	return AS923{
+		variant:           variant,
+		UplinkDwellTime:   true,
+		DownlinkDwellTime: true,
+		configuration: Configuration{
+			ReceiveDelay1:            1,                              // [2.7.8/Regional Parameters]
+			ReceiveDelay2:            2,                              // ReceiveDelay1 + 1 according to [2.7.8/Regional Parameters]
+			JoinAccepDelay1:          5,                              // [2.7.8/Regional Parameters]
+			JoinAccepDelay2:          6,                              // [2.7.8/Regional Parameters]
+			MaxFCntGap:               16384,                          // [2.7.8/Regional Parameters]
+			AdrAckLimit:              64,                             // [2.7.8/Regional Parameters]
+			AdrAckDelay:              32,                             // [2.7.8/Regional Parameters]
+			DefaultTxPower:           16,                             // Default MaxEIRP [2.7.3/Regional Parameters]
+			SupportsJoinAcceptCFList: true,                           // [2.7.4/Regional Parameters]
+			RX2Frequency:             as923Frequency(923200, offset), // [2.7.7/Regional Parameters]
+			RX2DataRate:              2,                              // [2.7.7/Regional Parameters]
+			RX2TxPower:               16,                             // Default MaxEIRP [2.7.3/Regional Parameters]
+			MaxADRDataRate:           5,                              // SF7BW125 [2.7.3/Regional Parameters]
+			MandatoryEndDeviceChannels: []float32{
+				as923Frequency(923200, offset),
+				as923Frequency(923400, offset)}, // [2.7.2/Regional Parameters]
+			JoinReqChannels: []float32{
+				as923Frequency(923200, offset),
+				as923Frequency(923400, offset)}, // [2.7.2/Regional Parameters]
+			AdditionalChannels: []float32{
+				as923Frequency(923600, offset),
+				as923Frequency(923800, offset),
+				as923Frequency(924000, offset),
+				as923Frequency(924200, offset),
+				as923Frequency(924400, offset),
+				as923Frequency(924600, offset)},
+			BeaconDataRate:    3,                                         // SF9BW125 [2.7.9/Regional Parameters]
+			BeaconFrequencies: []float32{as923Frequency(923400, offset)}, // [2.7.9/Regional Parameters]
+			BeaconCommonRFU:   2,                                         // [15.2]
+			BeaconGatewayRFU:  0,                                         // [15.2]
+		},
+	}
+}
+
+// Name returns frequency band name.
+func (b AS923) Name() string {
+	if b.variant == 1 {
+		return "AS 923MHz ISM Band"
+	}
+	return fmt.Sprintf("AS 923MHz ISM Band (AS923-%d)", b.variant)
+}
+
+// Configuration returns parameters for the AS 923MHz ISM Band.
+func (b AS923) Configuration() *Configuration {
+	return &b.configuration
+}
+
+// TxPower returns power in dBm for the AS 923MHz ISM Band, given a TXPower key. The
+// power is relative to the default MaxEIRP [2.7.3/Regional Parameters]
+func (b AS923) TxPower(power uint8) (int8, error) {
+	if power > 7 {
+		return 0, fmt.Errorf("invalid power: %d", power)
+	}
+	return int8(16 - 2*power), nil
+}
+
+// Encoding returns a description of modulation, spread factor and bit rate for the AS 923MHz ISM Band, given a data rate. [2.7.3/Regional Parameters]
+func (b AS923) Encoding(dataRate uint8) (Encoding, error) {
+	switch dataRate {
+	case 0:
+		return Encoding{Modulation: LoRa, SpreadFactor: 12, Bandwidth: 125, BitRate: 250}, nil
+	case 1:
+		return Encoding{Modulation: LoRa, SpreadFactor: 11, Bandwidth: 125, BitRate: 440}, nil
+	case 2:
+		return Encoding{Modulation: LoRa, SpreadFactor: 10, Bandwidth: 125, BitRate: 980}, nil
+	case 3:
+		return Encoding{Modulation: LoRa, SpreadFactor: 9, Bandwidth: 125, BitRate: 1760}, nil
+	case 4:
+		return Encoding{Modulation: LoRa, SpreadFactor: 8, Bandwidth: 125, BitRate: 3125}, nil
+	case 5:
+		return Encoding{Modulation: LoRa, SpreadFactor: 7, Bandwidth: 125, BitRate: 5470}, nil
+	case 6:
+		return Encoding{Modulation: LoRa, SpreadFactor: 7, Bandwidth: 250, BitRate: 11000}, nil
+	case 7:
+		return Encoding{Modulation: FSK, BitRate: 50000}, nil
+	default:
+		return Encoding{}, fmt.Errorf("unable to look up encoding. Invalid data rate :%d", dataRate)
+	}
+}
+
+// MaximumPayload return a maximum payload size, given a data rate.
+// This implementation uses the repeater compatible definition in the LoRaWAN specification. The
+// payload sizes are limited when the downlink dwell time is limited. DR0 and DR1 can't be used
+// when the dwell time is limited. [2.7.6/Regional Parameters]
+func (b AS923) MaximumPayload(dataRate string) (MaximumPayloadSize, error) {
+	dr, err := b.GetDataRate(dataRate)
+	if err != nil {
+		return MaximumPayloadSize{}, err
+	}
+	if b.DownlinkDwellTime {
+		switch dr {
+		case 2:
+			return MaximumPayloadSize{M: 19, N: 11}, nil
+		case 3:
+			return MaximumPayloadSize{M: 61, N: 53}, nil
+		case 4:
+			return MaximumPayloadSize{M: 133, N: 125}, nil
+		case 5, 6, 7:
+			return MaximumPayloadSize{M: 230, N: 222}, nil
+		default:
+			return MaximumPayloadSize{}, fmt.Errorf("unable to look up maximum payload. Data rate %s can't be used with dwell time limits", dataRate)
+		}
+	}
+	switch dr {
+	case 0:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 1:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 2:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 3:
+		return MaximumPayloadSize{M: 123, N: 115}, nil
+	case 4:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 5:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 6:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 7:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	default:
+		return MaximumPayloadSize{}, fmt.Errorf("unable to look up maximum payload. Invalid data rate :%s", dataRate)
+	}
+}
+
+// GetRX1Parameters returns datarate and frequency for downlink in receive window 1, given upstream data rate and RX1DROffset
+func (b AS923) GetRX1Parameters(channel uint8, upstreamFrequency float32, upstreamDataRate uint8, RX1DROffset uint8) (DownlinkParameters, error) {
+	datarate, err := b.downlinkDataRate(upstreamDataRate, RX1DROffset)
+	return DownlinkParameters{DataRate: datarate, Frequency: upstreamFrequency, Power: b.configuration.DefaultTxPower}, err
+}
+
+// GetRX2Parameters returns datarate, frequency and power for downlink in receive window 2.
+func (b AS923) GetRX2Parameters() DownlinkParameters {
+	return DownlinkParameters{DataRate: b.configuration.RX2DataRate, Frequency: b.configuration.RX2Frequency, Power: b.configuration.RX2TxPower}
+}
+
+// downlinkDataRate returns the downlink data rate, given the upstream data rate and RX1DROffset.
+// RX1DROffset 6 and 7 raises the data rate by one and two steps. The lowest data rate is DR2
+// when the downlink dwell time is limited [2.7.7/Regional Parameters]
+func (b AS923) downlinkDataRate(upstreamDataRate uint8, RX1DROffset uint8) (uint8, error) {
+	if upstreamDataRate > 7 {
+		return 0, fmt.Errorf("invalid data rate parameter: %d. Data rate has to be in the interval [0, 7]", upstreamDataRate)
+	}
+	if RX1DROffset > 7 {
+		return 0, fmt.Errorf("invalid RX1DROffset parameter: %d. RX1DROffset has to be in the interval [0, 7]", RX1DROffset)
+	}
+	effectiveOffset := []int{0, 1, 2, 3, 4, 5, -1, -2}[RX1DROffset]
+	minDataRate := 0
+	if b.DownlinkDwellTime {
+		minDataRate = 2
+	}
+	dataRate := int(upstreamDataRate) - effectiveOffset
+	if dataRate < minDataRate {
+		dataRate = minDataRate
+	}
+	if dataRate > 5 {
+		dataRate = 5
+	}
+	return uint8(dataRate), nil
+}
+
+// GetDataRate returns data rate, given gateway representation of configuration
+func (b AS923) GetDataRate(configuration string) (uint8, error) {
+	switch configuration {
+	case "SF12BW125":
+		return 0, nil
+	case "SF11BW125":
+		return 1, nil
+	case "SF10BW125":
+		return 2, nil
+	case "SF9BW125":
+		return 3, nil
+	case "SF8BW125":
+		return 4, nil
+	case "SF7BW125":
+		return 5, nil
+	case "SF7BW250":
+		return 6, nil
+	case "FSKBW500":
+		return 7, nil
+	default:
+		return 0, fmt.Errorf("unable to convert configuration '%s' into data rate", configuration)
+	}
+}
@@ -0,0 +1,243 @@
+package band
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"fmt"
+	"math"
+)
+
+// AU915 represents configuration and frequency plan for the AU 915-928MHz ISM Band.
+type AU915 struct {
+	configuration       Configuration
+	DownstreamDataRates [][]uint8
+	DownstreamChannels  []float32
+}
+
+func newAU915() AU915 {
+	return AU915{
+		configuration: Configuration{
+			ReceiveDelay1:            1,     // [2.5.8/Regional Parameters]
+			ReceiveDelay2:            2,     // ReceiveDelay1 + 1 according to [2.5.8/Regional Parameters]
+			JoinAccepDelay1:          5,     // [2.5.8/Regional Parameters]
+			JoinAccepDelay2:          6,     // [2.5.8/Regional Parameters]
+			MaxFCntGap:               16384, // [2.5.8/Regional Parameters]
+			AdrAckLimit:              64,    // [2.5.8/Regional Parameters]
+			AdrAckDelay:              32,    // [2.5.8/Regional Parameters]
+			DefaultTxPower:           20,    // The band allows up to 30 dBm EIRP [2.5.3/Regional Parameters]
+			SupportsJoinAcceptCFList: false, // [2.5.4/Regional Parameters]
+			SubBandChMask:            true,  // [2.5.5/Regional Parameters]
+			RX2Frequency:             923.3, // [2.5.7/Regional Parameters]
+			RX2DataRate:              8,     // [2.5.7/Regional Parameters]
+			RX2TxPower:               20,    // [2.5.3/Regional Parameters]
+			MaxADRDataRate:           5,     // SF7BW125 [2.5.3/Regional Parameters]
+			BeaconDataRate:           8,     // SF12BW500 [2.5.9/Regional Parameters]
+			BeaconFrequencies: []float32{
+				923.3,
+				923.9,
+				924.5,
+				925.1,
+				925.7,
+				926.3,
+				926.9,
+				927.5}, // [2.5.9/Regional Parameters]
+			BeaconCommonRFU:  5, // [15.2]
+			BeaconGatewayRFU: 3, // [15.2]
+		},
+		DownstreamDataRates: [][]uint8{
+			{8, 8, 8, 8, 8, 8},      // DR0
+			{9, 8, 8, 8, 8, 8},      // DR1
+			{10, 9, 8, 8, 8, 8},     // DR2
+			{11, 10, 9, 8, 8, 8},    // DR3
+			{12, 11, 10, 9, 8, 8},   // DR4
+			{13, 12, 11, 10, 9, 8},  // DR5
+			{13, 13, 12, 11, 10, 9}, // DR6
+		},
+		DownstreamChannels: []float32{
+			923.3,
+			923.9,
+			924.5,
+			925.1,
+			925.7,
+			926.3,
+			926.9,
+			927.5,
+		},
+	}
+}
+
+// Name returns frequency band name.
+func (b AU915) Name() string {
+	return "AU 915-928MHz ISM Band"
+}
+
+// Configuration returns parameters for the AU 915-928MHz ISM Band.
+func (b AU915) Configuration() *Configuration {
+	return &b.configuration
+}
+
+// TxPower returns power in dBm for the AU 915-928MHz ISM Band, given a TXPower [2.5.3/Regional Parameters]
+func (b AU915) TxPower(power uint8) (int8, error) {
+	if power > 10 {
+		return 0, fmt.Errorf("invalid power : %d", power)
+	}
+	return int8(30 - 2*power), nil
+}
+
+// Encoding returns a description of modulation, spread factor and bit rate for the AU 915-928MHz ISM Band, given a data rate. [2.5.3/Regional Parameters]
+func (b AU915) Encoding(dataRate uint8) (Encoding, error) {
+	switch dataRate {
+	case 0:
+		return Encoding{Modulation: LoRa, SpreadFactor: 12, Bandwidth: 125, BitRate: 250}, nil
+	case 1:
+		return Encoding{Modulation: LoRa, SpreadFactor: 11, Bandwidth: 125, BitRate: 440}, nil
+	case 2:
+		return Encoding{Modulation: LoRa, SpreadFactor: 10, Bandwidth: 125, BitRate: 980}, nil
+	case 3:
+		return Encoding{Modulation: LoRa, SpreadFactor: 9, Bandwidth: 125, BitRate: 1760}, nil
+	case 4:
+		return Encoding{Modulation: LoRa, SpreadFactor: 8, Bandwidth: 125, BitRate: 3125}, nil
+	case 5:
+		return Encoding{Modulation: LoRa, SpreadFactor: 7, Bandwidth: 125, BitRate: 5470}, nil
+	case 6:
+		return Encoding{Modulation: LoRa, SpreadFactor: 8, Bandwidth: 500, BitRate: 12500}, nil
+	case 8:
+		return Encoding{Modulation: LoRa, SpreadFactor: 12, Bandwidth: 500, BitRate: 980}, nil
+	case 9:
+		return Encoding{Modulation: LoRa, SpreadFactor: 11, Bandwidth: 500, BitRate: 1760}, nil
+	case 10:
+		return Encoding{Modulation: LoRa, SpreadFactor: 10, Bandwidth: 500, BitRate: 3900}, nil
+	case 11:
+		return Encoding{Modulation: LoRa, SpreadFactor: 9, Bandwidth: 500, BitRate: 7000}, nil
+	case 12:
+		return Encoding{Modulation: LoRa, SpreadFactor: 8, Bandwidth: 500, BitRate: 12500}, nil
+	case 13:
+		return Encoding{Modulation: LoRa, SpreadFactor: 7, Bandwidth: 500, BitRate: 21900}, nil
+	default:
+		return Encoding{}, fmt.Errorf("unable to look up encoding. Invalid data rate :%d (Data rate 7 is RFU)", dataRate)
+	}
+}
+
+// MaximumPayload return a maximum payload size, given a data rate.
+// This implementation uses the repeater compatible definition in the LoRaWAN specification. [2.5.6/Regional Parameters]
+func (b AU915) MaximumPayload(dataRate string) (MaximumPayloadSize, error) {
+	dr, err := b.GetDataRate(dataRate)
+	if err != nil {
+		return MaximumPayloadSize{}, err
+	}
+	switch dr {
+	case 0:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 1:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 2:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 3:
+		return MaximumPayloadSize{M: 123, N: 115}, nil
+	case 4:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 5:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 6:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 8:
+		return MaximumPayloadSize{M: 41, N: 33}, nil
+	case 9:
+		return MaximumPayloadSize{M: 117, N: 109}, nil
+	case 10:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 11:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 12:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 13:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	default:
+		return MaximumPayloadSize{}, fmt.Errorf("unable to look up maximum payload. Invalid data rate:%s (Data rate 7 is RFU)", dataRate)
+	}
+}
+
+// GetRX1Parameters returns datarate and frequency for downlink in receive window 1, given upstream data rate and RX1DROffset.
+// The downlink channel is the upstream channel number modulo 8 [2.5.7/Regional Parameters]. The upstream channel is derived
+// from the upstream frequency if it is set since the channel reported by the gateway is the concentrator's channel.
+func (b AU915) GetRX1Parameters(channel uint8, upstreamFrequency float32, upstreamDataRate uint8, RX1DROffset uint8) (DownlinkParameters, error) {
+	datarate, err := b.downlinkDataRate(upstreamDataRate, RX1DROffset)
+	if upstreamFrequency != 0 {
+		channel = b.upstreamChannel(upstreamFrequency)
+	}
+	return DownlinkParameters{DataRate: datarate, Frequency: b.DownstreamChannels[channel%8], Power: b.configuration.DefaultTxPower}, err
+}
+
+// upstreamChannel returns the upstream channel number for a frequency. Channels 0-63 are 125kHz
+// channels starting at 915.2MHz and channels 64-71 are 500kHz channels starting at 915.9MHz [2.5.2/Regional Parameters]
+func (b AU915) upstreamChannel(frequency float32) uint8 {
+	// Work in kHz to avoid rounding errors
+	khz := int(math.Floor(float64(frequency)*1000 + 0.5))
+	if khz >= 915900 && (khz-915900)%1600 == 0 {
+		return uint8(64 + (khz-915900)/1600)
+	}
+	return uint8(((khz - 915200) + 100) / 200)
+}
+
+// GetRX2Parameters returns datarate, frequency and power for downlink in receive window 2.
+func (b AU915) GetRX2Parameters() DownlinkParameters {
+	return DownlinkParameters{DataRate: b.configuration.RX2DataRate, Frequency: b.configuration.RX2Frequency, Power: b.configuration.RX2TxPower}
+}
+
+// downlinkDataRate returns the downlink data rate, given the upstream data rate and RX1DROffset [2.5.7/Regional Parameters]
+func (b AU915) downlinkDataRate(upstreamDataRate uint8, RX1DROffset uint8) (uint8, error) {
+	if upstreamDataRate > 6 {
+		return 0, fmt.Errorf("invalid data rate parameter: %d. Data rate has to be in the interval [0, 6]", upstreamDataRate)
+	}
+	if RX1DROffset > 5 {
+		return 0, fmt.Errorf("invalid RX1DROffset parameter: %d. RX1DROffset has to be in the interval [0, 5]", RX1DROffset)
+	}
+
+	return b.DownstreamDataRates[upstreamDataRate][RX1DROffset], nil
+}
+
+// GetDataRate returns data rate, given gateway representation of configuration
+// (DR6 is identical to DR12. Defaulting to DR6)
+func (b AU915) GetDataRate(configuration string) (uint8, error) {
+	switch configuration {
+	case "SF12BW125":
+		return 0, nil
+	case "SF11BW125":
+		return 1, nil
+	case "SF10BW125":
+		return 2, nil
+	case "SF9BW125":
+		return 3, nil
+	case "SF8BW125":
+		return 4, nil
+	case "SF7BW125":
+		return 5, nil
+	case "SF8BW500":
+		return 6, nil
+	case "SF12BW500":
+		return 8, nil
+	case "SF11BW500":
+		return 9, nil
+	case "SF10BW500":
+		return 10, nil
+	case "SF9BW500":
+		return 11, nil
+	case "SF7BW500":
+		return 13, nil
+	default:
+		return 0, fmt.Errorf("unknown configuration: %s", configuration)
+	}
+}
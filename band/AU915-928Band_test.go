@@ -0,0 +1,256 @@
+package band
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import "testing"
+
+func TestNameAU(t *testing.T) {
+	b := newAU915()
+	if b.Name() != "AU 915-928MHz ISM Band" {
+		t.Error("Unexpected band name")
+	}
+}
+
+func TestDefaultConfigurationAU(t *testing.T) {
+	b := newAU915()
+
+	if b.Configuration().ReceiveDelay1 != 1 {
+		t.Errorf("Wrong default RECEIVE_DELAY1 for %s [2.5.8]", b.Name())
+	}
+	if b.Configuration().ReceiveDelay2 != b.Configuration().ReceiveDelay1+1 {
+		t.Errorf("Wrong default RECEIVE_DELAY2 for %s [2.5.8]", b.Name())
+	}
+	if b.Configuration().JoinAccepDelay1 != 5 {
+		t.Errorf("Wrong default JOIN_ACCEPT_DELAY1 for %s [2.5.8]", b.Name())
+	}
+	if b.Configuration().JoinAccepDelay2 != 6 {
+		t.Errorf("Wrong default JOIN_ACCEPT_DELAY2 for %s [2.5.8]", b.Name())
+	}
+	if b.Configuration().MaxFCntGap != 16384 {
+		t.Errorf("Wrong default MAX_FCNT_GAP for %s [2.5.8]", b.Name())
+	}
+	if b.Configuration().AdrAckLimit != 64 {
+		t.Errorf("Wrong default ADR_ACK_LIMIT for %s [2.5.8]", b.Name())
+	}
+	if b.Configuration().AdrAckDelay != 32 {
+		t.Errorf("Wrong default ADR_ACK_DELAY for %s [2.5.8]", b.Name())
+	}
+	if b.Configuration().DefaultTxPower != 20 {
+		t.Errorf("Wrong default TXPower for %s [2.5.3]", b.Name())
+	}
+	if b.Configuration().SupportsJoinAcceptCFList {
+		t.Errorf("Wrong default value for SupportsJoinAcceptCFList for %s [2.5.4]", b.Name())
+	}
+	if b.Configuration().RX2Frequency != 923.3 {
+		t.Errorf("Wrong default RX2Frequency for %s [2.5.7]", b.Name())
+	}
+	if b.Configuration().RX2DataRate != 8 {
+		t.Errorf("Wrong default RX2DataRate for %s [2.5.7]", b.Name())
+	}
+}
+
+func TestTxPowerAU(t *testing.T) {
+	b := newAU915()
+
+	testParams := []uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	expectedOutput := []int8{30, 28, 26, 24, 22, 20, 18, 16, 14, 12, 10}
+
+	for i := 0; i < len(testParams); i++ {
+		power, err := b.TxPower(testParams[i])
+		if err != nil {
+			t.Errorf("%s, %s [2.5.3]", err, b.Name())
+		}
+		if power != expectedOutput[i] {
+			t.Errorf("Wrong TxPower configuration for %d, %s [2.5.3]", power, b.Name())
+		}
+	}
+
+	_, err := b.TxPower(42)
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [2.5.3]", b.Name())
+	}
+}
+
+func TestEncodingAU(t *testing.T) {
+	b := newAU915()
+	testParams := []uint8{0, 1, 2, 3, 4, 5, 6, 8, 9, 10, 11, 12, 13}
+	expectedModulations := []ModulationType{LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, LoRa}
+	expectedSpreadFactors := []uint8{12, 11, 10, 9, 8, 7, 8, 12, 11, 10, 9, 8, 7}
+	expectedBandwidths := []uint32{125, 125, 125, 125, 125, 125, 500, 500, 500, 500, 500, 500, 500}
+	expectedBitrates := []uint32{250, 440, 980, 1760, 3125, 5470, 12500, 980, 1760, 3900, 7000, 12500, 21900}
+
+	for i := 0; i < len(testParams); i++ {
+		encoding, err := b.Encoding(testParams[i])
+		if err != nil {
+			t.Errorf("%s, %s [2.5.3]", err, b.Name())
+		}
+		if encoding.Modulation != expectedModulations[i] {
+			t.Errorf("Unexpected modulation (%v) for datarate (%d) %s [2.5.3]", encoding.Modulation, testParams[i], b.Name())
+		}
+		if encoding.SpreadFactor != expectedSpreadFactors[i] {
+			t.Errorf("Unexpected spreadfactor (%v) for datarate (%d) %s [2.5.3]", encoding.SpreadFactor, testParams[i], b.Name())
+		}
+		if encoding.Bandwidth != expectedBandwidths[i] {
+			t.Errorf("Unexpected bandwidth (%v) for datarate (%d) %s [2.5.3]", encoding.Bandwidth, testParams[i], b.Name())
+		}
+		if encoding.BitRate != expectedBitrates[i] {
+			t.Errorf("Unexpected bit rate (%v) for datarate (%d) %s [2.5.3]", encoding.BitRate, testParams[i], b.Name())
+		}
+	}
+
+	_, err := b.Encoding(7)
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [2.5.3]", b.Name())
+	}
+}
+
+func TestMaximumPayloadAU(t *testing.T) {
+	b := newAU915()
+	testParams := []string{"SF12BW125", "SF11BW125", "SF10BW125", "SF9BW125", "SF8BW125", "SF7BW125", "SF8BW500", "SF12BW500", "SF11BW500", "SF10BW500", "SF9BW500", "SF7BW500"}
+	expectedMs := []uint8{59, 59, 59, 123, 230, 230, 230, 41, 117, 230, 230, 230}
+	expectedNs := []uint8{51, 51, 51, 115, 222, 222, 222, 33, 109, 222, 222, 222}
+
+	for i := 0; i < len(testParams); i++ {
+		mp, err := b.MaximumPayload(testParams[i])
+		if err != nil {
+			t.Errorf("%s, %s [2.5.6]", err, b.Name())
+		}
+		if mp.WithoutFOpts() != expectedMs[i] {
+			t.Errorf("Unexpected M (%d) for datarate (%s) %s [2.5.6]", mp.M, testParams[i], b.Name())
+		}
+		if mp.WithFOpts() != expectedNs[i] {
+			t.Errorf("Unexpected N (%d) for datarate (%s) %s [2.5.6]", mp.N, testParams[i], b.Name())
+		}
+	}
+
+	_, err := b.MaximumPayload("SF19BW1")
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [2.5.6]", b.Name())
+	}
+}
+
+func TestDownlinkDataRatesAU(t *testing.T) {
+	expectedRates := map[uint8][]uint8{
+		0: {8, 8, 8, 8, 8, 8},
+		1: {9, 8, 8, 8, 8, 8},
+		2: {10, 9, 8, 8, 8, 8},
+		3: {11, 10, 9, 8, 8, 8},
+		4: {12, 11, 10, 9, 8, 8},
+		5: {13, 12, 11, 10, 9, 8},
+		6: {13, 13, 12, 11, 10, 9},
+	}
+	b := newAU915()
+	for upstreamDataRate, rates := range expectedRates {
+		for RX1DROffset := uint8(0); RX1DROffset < 6; RX1DROffset++ {
+			rate, err := b.downlinkDataRate(upstreamDataRate, RX1DROffset)
+			if err != nil {
+				t.Errorf("%s, %s [2.5.7]", err, b.Name())
+			}
+			if rate != rates[RX1DROffset] {
+				t.Errorf("Unexpected downstream datarate (%d) for given upstream datarate/RX1DROffset (%d/%d) %s [2.5.7]", rate, upstreamDataRate, RX1DROffset, b.Name())
+			}
+		}
+	}
+
+	_, err := b.downlinkDataRate(7, 0)
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [2.5.7]", b.Name())
+	}
+	_, err = b.downlinkDataRate(0, 99)
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [2.5.7]", b.Name())
+	}
+}
+
+func TestGetRX1ParametersAU(t *testing.T) {
+	b := newAU915()
+	tests := []struct {
+		channel           uint8
+		upstreamFrequency float32
+		upstreamDataRate  uint8
+		rx1DROffset       uint8
+		dataRate          uint8
+		frequency         float32
+	}{
+		{0, 915.2, 0, 0, 8, 923.3},
+		{0, 915.9, 6, 0, 13, 923.3},
+		{0, 916.6, 2, 1, 9, 927.5},
+		{0, 917, 3, 0, 11, 923.9},
+		{0, 917.5, 6, 2, 12, 923.9},
+		{3, 0, 5, 0, 13, 925.1},
+	}
+	for _, test := range tests {
+		dlParams, err := b.GetRX1Parameters(test.channel, test.upstreamFrequency, test.upstreamDataRate, test.rx1DROffset)
+		if err != nil {
+			t.Error(err)
+		}
+		if dlParams.DataRate != test.dataRate {
+			t.Errorf("Unexpected data rate : %d (expected %d)", dlParams.DataRate, test.dataRate)
+		}
+		if dlParams.Frequency != test.frequency {
+			t.Errorf("Unexpected frequency: %f (expected %f)", dlParams.Frequency, test.frequency)
+		}
+		if dlParams.Power != 20 {
+			t.Errorf("Unexpected power: %d", dlParams.Power)
+		}
+	}
+
+	_, err := b.GetRX1Parameters(0, 915.2, 30, 0)
+	if err == nil {
+		t.Errorf("Expected invalid data rate.")
+	}
+	_, err = b.GetRX1Parameters(0, 915.2, 0, 30)
+	if err == nil {
+		t.Errorf("Expected invalid data rate offset.")
+	}
+}
+
+func TestGetRX2ParametersAU(t *testing.T) {
+	b := newAU915()
+	dlParams := b.GetRX2Parameters()
+	if dlParams.DataRate != 8 || dlParams.Frequency != 923.3 || dlParams.Power != 20 {
+		t.Errorf("Unexpected RX2 parameters: %+v", dlParams)
+	}
+}
+
+func TestGetDataRateAU(t *testing.T) {
+	b := newAU915()
+	expected := map[string]uint8{
+		"SF12BW125": 0,
+		"SF11BW125": 1,
+		"SF10BW125": 2,
+		"SF9BW125":  3,
+		"SF8BW125":  4,
+		"SF7BW125":  5,
+		"SF8BW500":  6,
+		"SF12BW500": 8,
+		"SF11BW500": 9,
+		"SF10BW500": 10,
+		"SF9BW500":  11,
+		"SF7BW500":  13,
+	}
+	for configuration, dataRate := range expected {
+		dr, err := b.GetDataRate(configuration)
+		if (dr != dataRate) || (err != nil) {
+			t.Errorf("Unexpected data rate or error in lookup of %s: %d. Error: %v", configuration, dr, err)
+		}
+	}
+
+	_, err := b.GetDataRate("XYZZY")
+	if err == nil {
+		t.Error("Expected lookup of XYZZY to fail")
+	}
+}
@@ -0,0 +1,209 @@
+package band
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"fmt"
+	"math"
+)
+
+// CN470 represents configuration and frequency plan for the CN 470-510MHz Band.
+type CN470 struct {
+	configuration       Configuration
+	DownstreamDataRates [][]uint8
+	DownstreamChannels  []float32
+}
+
+func newCN470() CN470 {
+	// 48 downstream channels from 500.3MHz to 509.7MHz [2.6.2/Regional Parameters]
+	downstreamChannels := make([]float32, 48)
+	for i := range downstreamChannels {
+		downstreamChannels[i] = float32(500300+200*i) / 1000
+	}
+	return CN470{
+		configuration: Configuration{
+			ReceiveDelay1:            1,     // [2.6.8/Regional Parameters]
+			ReceiveDelay2:            2,     // ReceiveDelay1 + 1 according to [2.6.8/Regional Parameters]
+			JoinAccepDelay1:          5,     // [2.6.8/Regional Parameters]
+			JoinAccepDelay2:          6,     // [2.6.8/Regional Parameters]
+			MaxFCntGap:               16384, // [2.6.8/Regional Parameters]
+			AdrAckLimit:              64,    // [2.6.8/Regional Parameters]
+			AdrAckDelay:              32,    // [2.6.8/Regional Parameters]
+			DefaultTxPower:           17,    // [2.6.3/Regional Parameters]
+			SupportsJoinAcceptCFList: false, // [2.6.4/Regional Parameters]
+			RX2Frequency:             505.3, // [2.6.7/Regional Parameters]
+			RX2DataRate:              0,     // [2.6.7/Regional Parameters]
+			RX2TxPower:               17,    // [2.6.3/Regional Parameters]
+			MaxADRDataRate:           5,     // SF7BW125 [2.6.3/Regional Parameters]
+			BeaconDataRate:           2,     // SF10BW125 [2.6.9/Regional Parameters]
+			BeaconFrequencies: []float32{
+				508.3,
+				508.5,
+				508.7,
+				508.9,
+				509.1,
+				509.3,
+				509.5,
+				509.7}, // [2.6.9/Regional Parameters]
+			BeaconCommonRFU:  3, // [15.2]
+			BeaconGatewayRFU: 1, // [15.2]
+		},
+		DownstreamDataRates: [][]uint8{
+			{0, 0, 0, 0, 0, 0},
+			{1, 0, 0, 0, 0, 0},
+			{2, 1, 0, 0, 0, 0},
+			{3, 2, 1, 0, 0, 0},
+			{4, 3, 2, 1, 0, 0},
+			{5, 4, 3, 2, 1, 0},
+		},
+		DownstreamChannels: downstreamChannels,
+	}
+}
+
+// Name returns frequency band name.
+func (b CN470) Name() string {
+	return "CN 470-510MHz Band"
+}
+
+// Configuration returns parameters for the CN 470-510MHz Band.
+func (b CN470) Configuration() *Configuration {
+	return &b.configuration
+}
+
+// TxPower returns power in dBm for the CN 470-510MHz Band, given a TXPower key [2.6.3/Regional Parameters]
+func (b CN470) TxPower(power uint8) (int8, error) {
+	switch power {
+	case 0:
+		return 17, nil
+	case 1:
+		return 16, nil
+	case 2:
+		return 14, nil
+	case 3:
+		return 12, nil
+	case 4:
+		return 10, nil
+	case 5:
+		return 7, nil
+	case 6:
+		return 5, nil
+	case 7:
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("invalid power: %d", power)
+	}
+}
+
+// Encoding returns a description of modulation, spread factor and bit rate for the CN 470-510MHz Band, given a data rate. [2.6.3/Regional Parameters]
+func (b CN470) Encoding(dataRate uint8) (Encoding, error) {
+	switch dataRate {
+	case 0:
+		return Encoding{Modulation: LoRa, SpreadFactor: 12, Bandwidth: 125, BitRate: 250}, nil
+	case 1:
+		return Encoding{Modulation: LoRa, SpreadFactor: 11, Bandwidth: 125, BitRate: 440}, nil
+	case 2:
+		return Encoding{Modulation: LoRa, SpreadFactor: 10, Bandwidth: 125, BitRate: 980}, nil
+	case 3:
+		return Encoding{Modulation: LoRa, SpreadFactor: 9, Bandwidth: 125, BitRate: 1760}, nil
+	case 4:
+		return Encoding{Modulation: LoRa, SpreadFactor: 8, Bandwidth: 125, BitRate: 3125}, nil
+	case 5:
+		return Encoding{Modulation: LoRa, SpreadFactor: 7, Bandwidth: 125, BitRate: 5470}, nil
+	default:
+		return Encoding{}, fmt.Errorf("unable to look up encoding. Invalid data rate :%d (Data rates 6-15 are RFU)", dataRate)
+	}
+}
+
+// MaximumPayload return a maximum payload size, given a data rate.
+// This implementation uses the repeater compatible definition in the LoRaWAN specification. [2.6.6/Regional Parameters]
+func (b CN470) MaximumPayload(dataRate string) (MaximumPayloadSize, error) {
+	dr, err := b.GetDataRate(dataRate)
+	if err != nil {
+		return MaximumPayloadSize{}, err
+	}
+	switch dr {
+	case 0:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 1:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 2:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 3:
+		return MaximumPayloadSize{M: 123, N: 115}, nil
+	case 4:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 5:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	default:
+		return MaximumPayloadSize{}, fmt.Errorf("unable to look up maximum payload. Invalid data rate :%s", dataRate)
+	}
+}
+
+// GetRX1Parameters returns datarate and frequency for downlink in receive window 1, given upstream data rate and RX1DROffset.
+// The downlink channel is the upstream channel number modulo 48 [2.6.7/Regional Parameters]. The upstream channel is derived
+// from the upstream frequency if it is set since the channel reported by the gateway is the concentrator's channel.
+func (b CN470) GetRX1Parameters(channel uint8, upstreamFrequency float32, upstreamDataRate uint8, RX1DROffset uint8) (DownlinkParameters, error) {
+	datarate, err := b.downlinkDataRate(upstreamDataRate, RX1DROffset)
+	if upstreamFrequency != 0 {
+		channel = b.upstreamChannel(upstreamFrequency)
+	}
+	return DownlinkParameters{DataRate: datarate, Frequency: b.DownstreamChannels[channel%48], Power: b.configuration.DefaultTxPower}, err
+}
+
+// upstreamChannel returns the upstream channel number for a frequency. Channels 0-95 are 125kHz
+// channels starting at 470.3MHz [2.6.2/Regional Parameters]
+func (b CN470) upstreamChannel(frequency float32) uint8 {
+	// Work in kHz to avoid rounding errors
+	khz := int(math.Floor(float64(frequency)*1000 + 0.5))
+	return uint8(((khz - 470300) + 100) / 200)
+}
+
+// GetRX2Parameters returns datarate, frequency and power for downlink in receive window 2.
+func (b CN470) GetRX2Parameters() DownlinkParameters {
+	return DownlinkParameters{DataRate: b.configuration.RX2DataRate, Frequency: b.configuration.RX2Frequency, Power: b.configuration.RX2TxPower}
+}
+
+// downlinkDataRate returns the downlink data rate, given the upstream data rate and RX1DROffset [2.6.7/Regional Parameters]
+func (b CN470) downlinkDataRate(upstreamDataRate uint8, RX1DROffset uint8) (uint8, error) {
+	if upstreamDataRate > 5 {
+		return 0, fmt.Errorf("invalid data rate parameter: %d. Data rate has to be in the interval [0, 5]", upstreamDataRate)
+	}
+	if RX1DROffset > 5 {
+		return 0, fmt.Errorf("invalid RX1DROffset parameter: %d. RX1DROffset has to be in the interval [0, 5]", RX1DROffset)
+	}
+
+	return b.DownstreamDataRates[upstreamDataRate][RX1DROffset], nil
+}
+
+// GetDataRate returns data rate, given gateway representation of configuration
+func (b CN470) GetDataRate(configuration string) (uint8, error) {
+	switch configuration {
+	case "SF12BW125":
+		return 0, nil
+	case "SF11BW125":
+		return 1, nil
+	case "SF10BW125":
+		return 2, nil
+	case "SF9BW125":
+		return 3, nil
+	case "SF8BW125":
+		return 4, nil
+	case "SF7BW125":
+		return 5, nil
+	default:
+		return 0, fmt.Errorf("unable to convert configuration '%s' into data rate", configuration)
+	}
+}
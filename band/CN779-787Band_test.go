@@ -0,0 +1,249 @@
+package band
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import "testing"
+
+func TestNameCN779(t *testing.T) {
+	b := newCN779()
+	if b.Name() != "CN 779-787MHz ISM Band" {
+		t.Error("Unexpected band name")
+	}
+}
+
+func TestDefaultConfigurationCN779(t *testing.T) {
+	b := newCN779()
+
+	if b.Configuration().ReceiveDelay1 != 1 {
+		t.Errorf("Wrong default RECEIVE_DELAY1 for %s [7.3.8]", b.Name())
+	}
+	if b.Configuration().ReceiveDelay2 != b.Configuration().ReceiveDelay1+1 {
+		t.Errorf("Wrong default RECEIVE_DELAY2 for %s [7.3.8]", b.Name())
+	}
+	if b.Configuration().JoinAccepDelay1 != 5 {
+		t.Errorf("Wrong default JOIN_ACCEPT_DELAY1 for %s [7.3.8]", b.Name())
+	}
+	if b.Configuration().JoinAccepDelay2 != 6 {
+		t.Errorf("Wrong default JOIN_ACCEPT_DELAY2 for %s [7.3.8]", b.Name())
+	}
+	if b.Configuration().MaxFCntGap != 16384 {
+		t.Errorf("Wrong default MAX_FCNT_GAP for %s [7.3.8]", b.Name())
+	}
+	if b.Configuration().AdrAckLimit != 64 {
+		t.Errorf("Wrong default ADR_ACK_LIMIT for %s [7.3.8]", b.Name())
+	}
+	if b.Configuration().AdrAckDelay != 32 {
+		t.Errorf("Wrong default ADR_ACK_DELAY for %s [7.3.8]", b.Name())
+	}
+	if b.Configuration().DefaultTxPower != 10 {
+		t.Errorf("Wrong default TXPower for %s [7.3.3]", b.Name())
+	}
+	if !b.Configuration().SupportsJoinAcceptCFList {
+		t.Errorf("Wrong default value for SupportsJoinAcceptCFList for %s [7.3.4]", b.Name())
+	}
+	if b.Configuration().RX2Frequency != 786.0 {
+		t.Errorf("Wrong default RX2Frequency for %s [7.3.7]", b.Name())
+	}
+	if b.Configuration().RX2DataRate != 0 {
+		t.Errorf("Wrong default RX2DataRate for %s [7.3.7]", b.Name())
+	}
+}
+
+func TestTxPowerCN779(t *testing.T) {
+	b := newCN779()
+
+	testParams := []uint8{0, 1, 2, 3, 4, 5}
+	expectedOutput := []int8{10, 7, 4, 1, -2, -5}
+
+	for i := 0; i < len(testParams); i++ {
+		power, err := b.TxPower(testParams[i])
+		if err != nil {
+			t.Errorf("%s, %s [7.3.3]", err, b.Name())
+		}
+		if power != expectedOutput[i] {
+			t.Errorf("Wrong TxPower configuration for %d, %s [7.3.3]", power, b.Name())
+		}
+	}
+
+	_, err := b.TxPower(42)
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [7.3.3]", b.Name())
+	}
+}
+
+func TestEncodingCN779(t *testing.T) {
+	b := newCN779()
+	testParams := []uint8{0, 1, 2, 3, 4, 5, 6, 7}
+	expectedModulations := []ModulationType{LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, LoRa, FSK}
+	expectedSpreadFactors := []uint8{12, 11, 10, 9, 8, 7, 7, 0}
+	expectedBandwidths := []uint32{125, 125, 125, 125, 125, 125, 250, 0}
+	expectedBitrates := []uint32{250, 440, 980, 1760, 3125, 5470, 11000, 50000}
+
+	for i := 0; i < len(testParams); i++ {
+		encoding, err := b.Encoding(testParams[i])
+		if err != nil {
+			t.Errorf("%s, %s [7.3.3]", err, b.Name())
+		}
+		if encoding.Modulation != expectedModulations[i] {
+			t.Errorf("Unexpected modulation (%v) for datarate (%d) %s [7.3.3]", encoding.Modulation, testParams[i], b.Name())
+		}
+		if encoding.SpreadFactor != expectedSpreadFactors[i] {
+			t.Errorf("Unexpected spreadfactor (%v) for datarate (%d) %s [7.3.3]", encoding.SpreadFactor, testParams[i], b.Name())
+		}
+		if encoding.Bandwidth != expectedBandwidths[i] {
+			t.Errorf("Unexpected bandwidth (%v) for datarate (%d) %s [7.3.3]", encoding.Bandwidth, testParams[i], b.Name())
+		}
+		if encoding.BitRate != expectedBitrates[i] {
+			t.Errorf("Unexpected bit rate (%v) for datarate (%d) %s [7.3.3]", encoding.BitRate, testParams[i], b.Name())
+		}
+	}
+
+	_, err := b.Encoding(8)
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [7.3.3]", b.Name())
+	}
+}
+
+func TestMaximumPayloadCN779(t *testing.T) {
+	b := newCN779()
+	testParams := []string{"SF12BW125", "SF11BW125", "SF10BW125", "SF9BW125", "SF8BW125", "SF7BW125", "SF7BW250", "FSKBW500"}
+	expectedMs := []uint8{59, 59, 59, 123, 230, 230, 230, 230}
+	expectedNs := []uint8{51, 51, 51, 115, 222, 222, 222, 222}
+
+	for i := 0; i < len(testParams); i++ {
+		mp, err := b.MaximumPayload(testParams[i])
+		if err != nil {
+			t.Errorf("%s, %s [7.3.6]", err, b.Name())
+		}
+		if mp.WithoutFOpts() != expectedMs[i] {
+			t.Errorf("Unexpected M (%d) for datarate (%s) %s [7.3.6]", mp.M, testParams[i], b.Name())
+		}
+		if mp.WithFOpts() != expectedNs[i] {
+			t.Errorf("Unexpected N (%d) for datarate (%s) %s [7.3.6]", mp.N, testParams[i], b.Name())
+		}
+	}
+
+	_, err := b.MaximumPayload("SF19BW1")
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [7.3.6]", b.Name())
+	}
+}
+
+func TestDownlinkDataRatesCN779(t *testing.T) {
+	expectedRates := map[uint8][]uint8{
+		0: {0, 0, 0, 0, 0, 0},
+		1: {1, 0, 0, 0, 0, 0},
+		2: {2, 1, 0, 0, 0, 0},
+		3: {3, 2, 1, 0, 0, 0},
+		4: {4, 3, 2, 1, 0, 0},
+		5: {5, 4, 3, 2, 1, 0},
+		6: {6, 5, 4, 3, 2, 1},
+		7: {7, 6, 5, 4, 3, 2},
+	}
+	b := newCN779()
+	for upstreamDataRate, rates := range expectedRates {
+		for RX1DROffset := uint8(0); RX1DROffset < 6; RX1DROffset++ {
+			rate, err := b.downlinkDataRate(upstreamDataRate, RX1DROffset)
+			if err != nil {
+				t.Errorf("%s, %s [7.3.7]", err, b.Name())
+			}
+			if rate != rates[RX1DROffset] {
+				t.Errorf("Unexpected downstream datarate (%d) for given upstream datarate/RX1DROffset (%d/%d) %s [7.3.7]", rate, upstreamDataRate, RX1DROffset, b.Name())
+			}
+		}
+	}
+
+	_, err := b.downlinkDataRate(8, 0)
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [7.3.7]", b.Name())
+	}
+	_, err = b.downlinkDataRate(0, 99)
+	if err == nil {
+		t.Errorf("Invalid parameter should fail, %s [7.3.7]", b.Name())
+	}
+}
+
+func TestGetRX1ParametersCN779(t *testing.T) {
+	b := newCN779()
+	tests := []struct {
+		channel           uint8
+		upstreamFrequency float32
+		upstreamDataRate  uint8
+		rx1DROffset       uint8
+		dataRate          uint8
+		frequency         float32
+	}{
+		{0, 779.5, 3, 3, 0, 779.5},
+		{0, 779.9, 7, 2, 5, 779.9},
+	}
+	for _, test := range tests {
+		dlParams, err := b.GetRX1Parameters(test.channel, test.upstreamFrequency, test.upstreamDataRate, test.rx1DROffset)
+		if err != nil {
+			t.Error(err)
+		}
+		if dlParams.DataRate != test.dataRate {
+			t.Errorf("Unexpected data rate : %d (expected %d)", dlParams.DataRate, test.dataRate)
+		}
+		if dlParams.Frequency != test.frequency {
+			t.Errorf("Unexpected frequency: %f (expected %f)", dlParams.Frequency, test.frequency)
+		}
+		if dlParams.Power != 10 {
+			t.Errorf("Unexpected power: %d", dlParams.Power)
+		}
+	}
+
+	_, err := b.GetRX1Parameters(0, 779.5, 30, 0)
+	if err == nil {
+		t.Errorf("Expected invalid data rate.")
+	}
+	_, err = b.GetRX1Parameters(0, 779.5, 0, 30)
+	if err == nil {
+		t.Errorf("Expected invalid data rate offset.")
+	}
+}
+
+func TestGetRX2ParametersCN779(t *testing.T) {
+	b := newCN779()
+	dlParams := b.GetRX2Parameters()
+	if dlParams.DataRate != 0 || dlParams.Frequency != 786.0 || dlParams.Power != 10 {
+		t.Errorf("Unexpected RX2 parameters: %+v", dlParams)
+	}
+}
+
+func TestGetDataRateCN779(t *testing.T) {
+	b := newCN779()
+	expected := map[string]uint8{
+		"SF12BW125": 0,
+		"SF11BW125": 1,
+		"SF10BW125": 2,
+		"SF9BW125":  3,
+		"SF8BW125":  4,
+		"SF7BW125":  5,
+		"SF7BW250":  6,
+		"FSKBW500":  7,
+	}
+	for configuration, dataRate := range expected {
+		dr, err := b.GetDataRate(configuration)
+		if (dr != dataRate) || (err != nil) {
+			t.Errorf("Unexpected data rate or error in lookup of %s: %d. Error: %v", configuration, dr, err)
+		}
+	}
+
+	_, err := b.GetDataRate("XYZZY")
+	if err == nil {
+		t.Error("Expected lookup of XYZZY to fail")
+	}
+}
@@ -37,6 +37,8 @@ func newEU868() EU868 {
 			SupportsJoinAcceptCFList: true,    // [7.1.4]
 			RX2Frequency:             869.525, // [7.1.7]
 			RX2DataRate:              0,       // [7.1.8]
+			RX2TxPower:               27,      // 869.4-869.65MHz sub-band allows 500 mW ERP [7.1.2]
+			MaxADRDataRate:           5,       // SF7BW125 [7.1.3]
 			MandatoryEndDeviceChannels: []float32{
 				868.1,
 				868.3,
@@ -45,6 +47,16 @@ func newEU868() EU868 {
 				868.1,
 				868.3,
 				868.5}, // [2.1.2/Regional Parameters]
+			AdditionalChannels: []float32{
+				867.1,
+				867.3,
+				867.5,
+				867.7,
+				867.9},
+			BeaconDataRate:    3,                  // SF9BW125 [15.1.1]
+			BeaconFrequencies: []float32{869.525}, // [15.1.1]
+			BeaconCommonRFU:   2,                  // [15.1.1]
+			BeaconGatewayRFU:  0,                  // [15.1.1]
 		},
 		DownstreamDataRates: [][]uint8{
 			{0, 0, 0, 0, 0, 0},
@@ -145,12 +157,12 @@ func (b EU868) MaximumPayload(dataRate string) (MaximumPayloadSize, error) {
 // GetRX1Parameters returns datarate and frequency for downlink in receive window 1, given upstream data rate and RX1DROffset
 func (b EU868) GetRX1Parameters(channel uint8, upstreamFrequency float32, upstreamDataRate uint8, RX1DROffset uint8) (DownlinkParameters, error) {
 	datarate, err := b.downlinkDataRate(upstreamDataRate, RX1DROffset)
-	return DownlinkParameters{DataRate: datarate, Frequency: upstreamFrequency}, err
+	return DownlinkParameters{DataRate: datarate, Frequency: upstreamFrequency, Power: b.configuration.DefaultTxPower}, err
 }
 
-// GetRX2Parameters returns datarate and frequency for downlink in receive window 2.
+// GetRX2Parameters returns datarate, frequency and power for downlink in receive window 2.
 func (b EU868) GetRX2Parameters() DownlinkParameters {
-	return DownlinkParameters{DataRate: b.configuration.RX2DataRate, Frequency: b.configuration.RX2Frequency}
+	return DownlinkParameters{DataRate: b.configuration.RX2DataRate, Frequency: b.configuration.RX2Frequency, Power: b.configuration.RX2TxPower}
 }
 
 // DownlinkDataRate returns the downlink data rate, given the upstream data rate and RX1DROffset [7.1.7]
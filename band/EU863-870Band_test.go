@@ -209,6 +209,9 @@ func TestGetRX1ParametersEU(t *testing.T) {
 	if dlParams.Frequency != 868.1 {
 		t.Errorf("Unexpected frequency: %f", dlParams.Frequency)
 	}
+	if dlParams.Power != 14 {
+		t.Errorf("Unexpected power: %d", dlParams.Power)
+	}
 
 	dlParams, err = b.GetRX1Parameters(0, 868.1, 30, 3)
 	if err == nil {
@@ -220,6 +223,14 @@ func TestGetRX1ParametersEU(t *testing.T) {
 	}
 }
 
+func TestGetRX2ParametersEU(t *testing.T) {
+	b := newEU868()
+	dlParams := b.GetRX2Parameters()
+	if dlParams.DataRate != 0 || dlParams.Frequency != 869.525 || dlParams.Power != 27 {
+		t.Errorf("Unexpected RX2 parameters: %+v", dlParams)
+	}
+}
+
 func TestGetDataRateEU(t *testing.T) {
 	b := newEU868()
 	var dr uint8
@@ -0,0 +1,176 @@
+package band
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import "fmt"
+
+// KR920 represents configuration and frequency plan for the KR 920-923MHz ISM Band.
+type KR920 struct {
+	configuration       Configuration
+	DownstreamDataRates [][]uint8
+}
+
+func newKR920() KR920 {
+	return KR920{
+		configuration: Configuration{
+			ReceiveDelay1:            1,     // [2.8.8/Regional Parameters]
+			ReceiveDelay2:            2,     // ReceiveDelay1 + 1 according to [2.8.8/Regional Parameters]
+			JoinAccepDelay1:          5,     // [2.8.8/Regional Parameters]
+			JoinAccepDelay2:          6,     // [2.8.8/Regional Parameters]
+			MaxFCntGap:               16384, // [2.8.8/Regional Parameters]
+			AdrAckLimit:              64,    // [2.8.8/Regional Parameters]
+			AdrAckDelay:              32,    // [2.8.8/Regional Parameters]
+			DefaultTxPower:           14,    // Default MaxEIRP [2.8.3/Regional Parameters]
+			SupportsJoinAcceptCFList: true,  // [2.8.4/Regional Parameters]
+			RX2Frequency:             921.9, // [2.8.7/Regional Parameters]
+			RX2DataRate:              0,     // [2.8.7/Regional Parameters]
+			RX2TxPower:               14,    // Default MaxEIRP [2.8.3/Regional Parameters]
+			MaxADRDataRate:           5,     // SF7BW125 [2.8.3/Regional Parameters]
+			MandatoryEndDeviceChannels: []float32{
+				922.1,
+				922.3,
+				922.5}, // [2.8.2/Regional Parameters]
+			JoinReqChannels: []float32{
+				922.1,
+				922.3,
+				922.5}, // [2.8.2/Regional Parameters]
+			AdditionalChannels: []float32{
+				922.7,
+				922.9,
+				923.1,
+				923.3},
+			BeaconDataRate:    3,                // SF9BW125 [2.8.9/Regional Parameters]
+			BeaconFrequencies: []float32{923.1}, // [2.8.9/Regional Parameters]
+			BeaconCommonRFU:   2,                // [15.2]
+			BeaconGatewayRFU:  0,                // [15.2]
+		},
+		DownstreamDataRates: [][]uint8{
+			{0, 0, 0, 0, 0, 0},
+			{1, 0, 0, 0, 0, 0},
+			{2, 1, 0, 0, 0, 0},
+			{3, 2, 1, 0, 0, 0},
+			{4, 3, 2, 1, 0, 0},
+			{5, 4, 3, 2, 1, 0},
+		},
+	}
+}
+
+// Name returns frequency band name.
+func (b KR920) Name() string {
+	return "KR 920-923MHz ISM Band"
+}
+
+// Configuration returns parameters for the KR 920-923MHz ISM Band.
+func (b KR920) Configuration() *Configuration {
+	return &b.configuration
+}
+
+// TxPower returns power in dBm for the KR 920-923MHz ISM Band, given a TXPower key. The
+// power is relative to the default MaxEIRP [2.8.3/Regional Parameters]
+func (b KR920) TxPower(power uint8) (int8, error) {
+	if power > 7 {
+		return 0, fmt.Errorf("invalid power: %d", power)
+	}
+	return int8(14 - 2*power), nil
+}
+
+// Encoding returns a description of modulation, spread factor and bit rate for the KR 920-923MHz ISM Band, given a data rate. [2.8.3/Regional Parameters]
+func (b KR920) Encoding(dataRate uint8) (Encoding, error) {
+	switch dataRate {
+	case 0:
+		return Encoding{Modulation: LoRa, SpreadFactor: 12, Bandwidth: 125, BitRate: 250}, nil
+	case 1:
+		return Encoding{Modulation: LoRa, SpreadFactor: 11, Bandwidth: 125, BitRate: 440}, nil
+	case 2:
+		return Encoding{Modulation: LoRa, SpreadFactor: 10, Bandwidth: 125, BitRate: 980}, nil
+	case 3:
+		return Encoding{Modulation: LoRa, SpreadFactor: 9, Bandwidth: 125, BitRate: 1760}, nil
+	case 4:
+		return Encoding{Modulation: LoRa, SpreadFactor: 8, Bandwidth: 125, BitRate: 3125}, nil
+	case 5:
+		return Encoding{Modulation: LoRa, SpreadFactor: 7, Bandwidth: 125, BitRate: 5470}, nil
+	default:
+		return Encoding{}, fmt.Errorf("unable to look up encoding. Invalid data rate :%d (Data rates 6-15 are RFU)", dataRate)
+	}
+}
+
+// MaximumPayload return a maximum payload size, given a data rate.
+// This implementation uses the repeater compatible definition in the LoRaWAN specification. [2.8.6/Regional Parameters]
+func (b KR920) MaximumPayload(dataRate string) (MaximumPayloadSize, error) {
+	dr, err := b.GetDataRate(dataRate)
+	if err != nil {
+		return MaximumPayloadSize{}, err
+	}
+	switch dr {
+	case 0:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 1:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 2:
+		return MaximumPayloadSize{M: 59, N: 51}, nil
+	case 3:
+		return MaximumPayloadSize{M: 123, N: 115}, nil
+	case 4:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	case 5:
+		return MaximumPayloadSize{M: 230, N: 222}, nil
+	default:
+		return MaximumPayloadSize{}, fmt.Errorf("unable to look up maximum payload. Invalid data rate :%s", dataRate)
+	}
+}
+
+// GetRX1Parameters returns datarate and frequency for downlink in receive window 1, given upstream data rate and RX1DROffset
+func (b KR920) GetRX1Parameters(channel uint8, upstreamFrequency float32, upstreamDataRate uint8, RX1DROffset uint8) (DownlinkParameters, error) {
+	datarate, err := b.downlinkDataRate(upstreamDataRate, RX1DROffset)
+	return DownlinkParameters{DataRate: datarate, Frequency: upstreamFrequency, Power: b.configuration.DefaultTxPower}, err
+}
+
+// GetRX2Parameters returns datarate, frequency and power for downlink in receive window 2.
+func (b KR920) GetRX2Parameters() DownlinkParameters {
+	return DownlinkParameters{DataRate: b.configuration.RX2DataRate, Frequency: b.configuration.RX2Frequency, Power: b.configuration.RX2TxPower}
+}
+
+// downlinkDataRate returns the downlink data rate, given the upstream data rate and RX1DROffset [2.8.7/Regional Parameters]
+func (b KR920) downlinkDataRate(upstreamDataRate uint8, RX1DROffset uint8) (uint8, error) {
+	if upstreamDataRate > 5 {
+		return 0, fmt.Errorf("invalid data rate parameter: %d. Data rate has to be in the interval [0, 5]", upstreamDataRate)
+	}
+	if RX1DROffset > 5 {
+		return 0, fmt.Errorf("invalid RX1DROffset parameter: %d. RX1DROffset has to be in the interval [0, 5]", RX1DROffset)
+	}
+
+	return b.DownstreamDataRates[upstreamDataRate][RX1DROffset], nil
+}
+
+// GetDataRate returns data rate, given gateway representation of configuration
+func (b KR920) GetDataRate(configuration string) (uint8, error) {
+	switch configuration {
+	case "SF12BW125":
+		return 0, nil
+	case "SF11BW125":
+		return 1, nil
+	case "SF10BW125":
+		return 2, nil
+	case "SF9BW125":
+		return 3, nil
+	case "SF8BW125":
+		return 4, nil
+	case "SF7BW125":
+		return 5, nil
+	default:
+		return 0, fmt.Errorf("unable to convert configuration '%s' into data rate", configuration)
+	}
+}
@@ -15,7 +15,10 @@ package band
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 // US902 represents configuration and frequency plan for the US 902-928MHz ISM Band.
 type US902 struct {
@@ -36,8 +39,23 @@ func newUS902() US902 {
 			AdrAckDelay:              32,    // [7.2.8]
 			DefaultTxPower:           20,    // or a) 30 dBm for 125kHz BW (max 400ms), or b) 26 dBm for 500kHz BW [7.2.1]
 			SupportsJoinAcceptCFList: false, // [7.2.4]
+			SubBandChMask:            true,  // [7.2.5]
 			RX2Frequency:             923.3, // [7.2.7]
 			RX2DataRate:              8,     // [7.2.7]
+			RX2TxPower:               20,    // [7.2.2]
+			MaxADRDataRate:           3,     // SF7BW125 [7.2.3]
+			BeaconDataRate:           8,     // SF12BW500 [15.1.2]
+			BeaconFrequencies: []float32{
+				923.3,
+				923.9,
+				924.5,
+				925.1,
+				925.7,
+				926.3,
+				926.9,
+				927.5}, // [15.1.2]
+			BeaconCommonRFU:  5, // [15.1.2]
+			BeaconGatewayRFU: 3, // [15.1.2]
 		},
 		DownstreamDataRates: [][]uint8{
 			{10, 9, 8, 8},    // DR0
@@ -153,15 +171,31 @@ func (b US902) MaximumPayload(dataRate string) (MaximumPayloadSize, error) {
 	}
 }
 
-// GetRX1Parameters returns datarate and frequency for downlink in receive window 1, given upstream data rate and RX1DROffset
+// GetRX1Parameters returns datarate and frequency for downlink in receive window 1, given upstream data rate and RX1DROffset.
+// The downlink channel is the upstream channel number modulo 8 [7.2.7]. The upstream channel is derived from the
+// upstream frequency if it is set since the channel reported by the gateway is the concentrator's channel.
 func (b US902) GetRX1Parameters(channel uint8, upstreamFrequency float32, upstreamDataRate uint8, RX1DROffset uint8) (DownlinkParameters, error) {
 	datarate, err := b.downlinkDataRate(upstreamDataRate, RX1DROffset)
-	return DownlinkParameters{DataRate: datarate, Frequency: b.DownstreamChannels[channel%8]}, err
+	if upstreamFrequency != 0 {
+		channel = b.upstreamChannel(upstreamFrequency)
+	}
+	return DownlinkParameters{DataRate: datarate, Frequency: b.DownstreamChannels[channel%8], Power: b.configuration.DefaultTxPower}, err
+}
+
+// upstreamChannel returns the upstream channel number for a frequency. Channels 0-63 are 125kHz
+// channels starting at 902.3MHz and channels 64-71 are 500kHz channels starting at 903.0MHz [7.2.2]
+func (b US902) upstreamChannel(frequency float32) uint8 {
+	// Work in kHz to avoid rounding errors
+	khz := int(math.Floor(float64(frequency)*1000 + 0.5))
+	if khz >= 903000 && (khz-903000)%1600 == 0 {
+		return uint8(64 + (khz-903000)/1600)
+	}
+	return uint8(((khz - 902300) + 100) / 200)
 }
 
-// GetRX2Parameters returns datarate and frequency for downlink in receive window 2.
+// GetRX2Parameters returns datarate, frequency and power for downlink in receive window 2.
 func (b US902) GetRX2Parameters() DownlinkParameters {
-	return DownlinkParameters{DataRate: b.configuration.RX2DataRate, Frequency: b.configuration.RX2Frequency}
+	return DownlinkParameters{DataRate: b.configuration.RX2DataRate, Frequency: b.configuration.RX2Frequency, Power: b.configuration.RX2TxPower}
 }
 
 // DownlinkDataRate returns the downlink data rate, given the upstream data rate and RX1DROffset [7.2.7]
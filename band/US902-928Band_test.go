@@ -244,6 +244,38 @@ func TestGetRX1ParametersUS(t *testing.T) {
 	if err == nil {
 		t.Errorf("Expected invalid data rate offset.")
 	}
+
+	// The frequency takes precedence over the concentrator channel
+	frequencies := map[float32]float32{
+		902.3: 923.3, // Channel 0
+		903.7: 927.5, // Channel 7
+		903.9: 923.3, // Channel 8
+		904.1: 923.9, // Channel 9
+		914.9: 927.5, // Channel 63
+		903.0: 923.3, // Channel 64
+		904.6: 923.9, // Channel 65
+		914.2: 927.5, // Channel 71
+	}
+	for up, down := range frequencies {
+		dlParams, err = b.GetRX1Parameters(3, up, 3, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dlParams.Frequency != down {
+			t.Errorf("Expected %f for uplink on %f but got %f", down, up, dlParams.Frequency)
+		}
+		if dlParams.Power != b.Configuration().DefaultTxPower {
+			t.Errorf("Unexpected power: %d", dlParams.Power)
+		}
+	}
+}
+
+func TestGetRX2ParametersUS(t *testing.T) {
+	b := newUS902()
+	dlParams := b.GetRX2Parameters()
+	if dlParams.DataRate != 8 || dlParams.Frequency != 923.3 || dlParams.Power != 20 {
+		t.Errorf("Unexpected RX2 parameters: %+v", dlParams)
+	}
 }
 
 func TestGetDataRateUS(t *testing.T) {
@@ -15,13 +15,12 @@ package band
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
-// BUG(hjg) No LinkAdrReq support yet.
-// BUG(hjg) No CFList support yet.
-// BUG(hjg) No NewChannelReq support yet.
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -61,8 +60,84 @@ const (
 	CN780Band
 	// EU433Band is the EU 433MHz ISM Band
 	EU433Band
+	// AU915Band is the AU 915-928MHz ISM Band
+	AU915Band
+	// CN470Band is the CN 470-510MHz Band
+	CN470Band
+	// AS923Band is the AS 923MHz ISM Band (AS923-1)
+	AS923Band
+	// AS923Band2 is the AS923-2 variant of the AS 923MHz ISM Band. The
+	// frequencies are offset by -1.8MHz
+	AS923Band2
+	// AS923Band3 is the AS923-3 variant of the AS 923MHz ISM Band. The
+	// frequencies are offset by -6.6MHz
+	AS923Band3
+	// AS923Band4 is the AS923-4 variant of the AS 923MHz ISM Band. The
+	// frequencies are offset by -5.9MHz
+	AS923Band4
+	// KR920Band is the KR 920-923MHz ISM Band
+	KR920Band
+	// IN865Band is the IN 865-867MHz ISM Band
+	IN865Band
 )
 
+// CN779Band is the China 779-787MHz ISM Band. This is the same band as CN780Band.
+const CN779Band = CN780Band
+
+// bandNames holds the channel plan common names from the Regional Parameters
+var bandNames = map[FrequencyBandType]string{
+	EU868Band:  "EU868",
+	US915Band:  "US915",
+	CN780Band:  "CN779",
+	EU433Band:  "EU433",
+	AU915Band:  "AU915",
+	CN470Band:  "CN470",
+	AS923Band:  "AS923-1",
+	AS923Band2: "AS923-2",
+	AS923Band3: "AS923-3",
+	AS923Band4: "AS923-4",
+	KR920Band:  "KR920",
+	IN865Band:  "IN865",
+}
+
+// String returns the common name of the band, f.e. "EU868"
+func (f FrequencyBandType) String() string {
+	name, ok := bandNames[f]
+	if !ok {
+		return fmt.Sprintf("FrequencyBandType(%d)", uint8(f))
+	}
+	return name
+}
+
+// SubBands returns the number of sub-bands with 8 uplink channels for bands
+// with a fixed channel plan. Gateways in these bands usually only listen to
+// one of the sub-bands. Bands without sub-bands return 0.
+func (f FrequencyBandType) SubBands() uint8 {
+	switch f {
+	case US915Band, AU915Band:
+		return 8
+	case CN470Band:
+		return 12
+	default:
+		return 0
+	}
+}
+
+// ParseBandType returns the band type for a band name. The names are
+// case insensitive and "AS923" is the same as "AS923-1".
+func ParseBandType(name string) (FrequencyBandType, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "AS923" {
+		return AS923Band, nil
+	}
+	for bandType, bandName := range bandNames {
+		if bandName == name {
+			return bandType, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown band: %s", name)
+}
+
 // Encoding holds the data rate specific spread factor, frequency and bit rate parameters
 type Encoding struct {
 	// Modulation is LoRa or FSK
@@ -75,6 +150,76 @@ type Encoding struct {
 	Bandwidth uint32
 }
 
+// DemodulationFloor returns the minimum SNR (in dB) required to demodulate a
+// LoRa signal with the given spread factor. The values are from the SX1276
+// data sheet.
+func DemodulationFloor(spreadFactor uint8) (float32, error) {
+	switch spreadFactor {
+	case 6:
+		return -5.0, nil
+	case 7:
+		return -7.5, nil
+	case 8:
+		return -10.0, nil
+	case 9:
+		return -12.5, nil
+	case 10:
+		return -15.0, nil
+	case 11:
+		return -17.5, nil
+	case 12:
+		return -20.0, nil
+	default:
+		return 0, fmt.Errorf("invalid spread factor: %d", spreadFactor)
+	}
+}
+
+// RequiredSNR returns the minimum SNR (in dB) required to demodulate a frame
+// with this encoding. FSK frames have no demodulation floor and return an
+// error.
+func (e Encoding) RequiredSNR() (float32, error) {
+	if e.Modulation != LoRa {
+		return 0, fmt.Errorf("no demodulation floor for modulation type %d", e.Modulation)
+	}
+	return DemodulationFloor(e.SpreadFactor)
+}
+
+// TimeOnAir returns the time it takes to transmit a frame with the given
+// length (in bytes). LoRa frames are assumed to use an explicit header, coding
+// rate 4/5, CRC and an 8 symbol preamble. The calculation is from the SX1276
+// data sheet.
+func (e Encoding) TimeOnAir(length int) time.Duration {
+	if e.Modulation != LoRa {
+		// Preamble (5 bytes), sync word (3 bytes), length (1 byte) and CRC (2 bytes)
+		bits := float64((5 + 3 + 1 + length + 2) * 8)
+		return time.Duration(bits / float64(e.BitRate) * float64(time.Second))
+	}
+	const preambleLength = 8
+	const codingRate = 1
+	sf := float64(e.SpreadFactor)
+	symbolTime := math.Pow(2, sf) / float64(e.Bandwidth*1000)
+	lowDataRateOptimize := 0.0
+	if symbolTime > 0.016 {
+		lowDataRateOptimize = 1.0
+	}
+	payloadSymbols := 8 + math.Max(math.Ceil((8*float64(length)-4*sf+28+16)/(4*(sf-2*lowDataRateOptimize)))*(codingRate+4), 0)
+	seconds := (preambleLength+4.25)*symbolTime + payloadSymbols*symbolTime
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// DataRateIdentifier returns the data rate identifier the gateways use for the
+// data rate, f.e. "SF7BW125". FSK data rates aren't supported.
+func DataRateIdentifier(plan FrequencyPlan, dataRate uint8) (string, error) {
+	encoding, err := plan.Encoding(dataRate)
+	if err != nil {
+		return "", err
+	}
+	if encoding.Modulation != LoRa {
+		return "", fmt.Errorf("no identifier for data rate %d with modulation type %d", dataRate, encoding.Modulation)
+	}
+	return fmt.Sprintf("SF%dBW%d", encoding.SpreadFactor, encoding.Bandwidth), nil
+}
+
 // MaximumPayloadSize defines max payload size
 type MaximumPayloadSize struct {
 	// M is max payload length if FOpts is present.
@@ -93,12 +238,14 @@ func (m *MaximumPayloadSize) WithoutFOpts() uint8 {
 	return m.M
 }
 
-// DownlinkParameters contains datarate and frequency
+// DownlinkParameters contains datarate, frequency and transmit power
 type DownlinkParameters struct {
 	// DataRate used for downlink
 	DataRate uint8
 	// Frequency used for downlink
 	Frequency float32
+	// Power is the radiated transmit output power (in dBm) used for downlink
+	Power uint8
 }
 
 // Configuration represents frequency band specific LoRa parameters.
@@ -125,14 +272,69 @@ type Configuration struct {
 	// SupportsJoinAcceptCFList indicates if the band support the optional list of channel freequencies for
 	// the network the end-device is joining [Band sub-chapters in 7].
 	SupportsJoinAcceptCFList bool
+	// SubBandChMask indicates that the band has sub-bands with eight 125 kHz
+	// channels and one 500 kHz channel. The sub-bands are enabled through
+	// the channel mask in the JoinAccept CFList and LinkADRReq
+	// [2.5.4, 2.5.5/Regional Parameters].
+	SubBandChMask bool
 	// RX2Frequency is the default band frequency for the second receive window [Band sub-chapters in 7].
 	RX2Frequency float32
 	// RX2DataRate is the default data rate for the second receive window [Band sub-chapters in 7].
 	RX2DataRate uint8
+	// RX2TxPower is the transmit output power in dBm for the second receive window. Some
+	// bands allow a higher power on the RX2 frequency than on the uplink channels [Band sub-chapters in 7].
+	RX2TxPower uint8
+	// MaxADRDataRate is the highest uplink data rate the network server will
+	// request through ADR. This is the fastest data rate that uses the default
+	// uplink channels.
+	MaxADRDataRate uint8
 
 	MandatoryEndDeviceChannels []float32
 	JoinReqChannels            []float32
 	DownLinkFrequencies        []float32
+	// AdditionalChannels is the default set of channels that the network
+	// server adds to the mandatory channels through the JoinAccept CFList or
+	// NewChannelReq commands. Bands with a fixed channel plan leave this empty.
+	AdditionalChannels []float32
+	// BeaconDataRate is the data rate used for class B beacons and the
+	// default data rate for ping slots [15.1].
+	BeaconDataRate uint8
+	// BeaconFrequencies is the list of frequencies used for class B beacons and
+	// ping slots. Bands with more than one frequency hop between them
+	// [15.1].
+	BeaconFrequencies []float32
+	// BeaconCommonRFU is the number of RFU bytes in the network common part
+	// of the beacon frame [15.2].
+	BeaconCommonRFU int
+	// BeaconGatewayRFU is the number of RFU bytes in the gateway specific part
+	// of the beacon frame [15.2].
+	BeaconGatewayRFU int
+}
+
+// BeaconChannel returns the index of the beacon channel for a beacon.
+// beaconTime is the GPS time (in seconds) of the beacon [15.1].
+func (c *Configuration) BeaconChannel(beaconTime uint32) uint8 {
+	if len(c.BeaconFrequencies) == 0 {
+		return 0
+	}
+	return uint8((beaconTime / 128) % uint32(len(c.BeaconFrequencies)))
+}
+
+// BeaconFrequency returns the frequency for a beacon.
+func (c *Configuration) BeaconFrequency(beaconTime uint32) float32 {
+	if len(c.BeaconFrequencies) == 0 {
+		return c.RX2Frequency
+	}
+	return c.BeaconFrequencies[c.BeaconChannel(beaconTime)]
+}
+
+// PingSlotFrequency returns the default ping slot frequency for a device in the
+// beacon period that starts at beaconTime [15.1].
+func (c *Configuration) PingSlotFrequency(beaconTime uint32, devAddr uint32) float32 {
+	if len(c.BeaconFrequencies) == 0 {
+		return c.RX2Frequency
+	}
+	return c.BeaconFrequencies[(beaconTime/128+devAddr)%uint32(len(c.BeaconFrequencies))]
 }
 
 //AckTimeout is the max delay limit (in seconds after the second receive window) for when then the network can send a frame with the
@@ -154,9 +356,9 @@ type FrequencyPlan interface {
 	TxPower(power uint8) (int8, error)
 	// DownlinkDataRate returns the downlink data rate, given the upstream data rate and RX1DROffset
 
-	// GetRX1Parameters returns datarate and frequency for downlink in receive window 1, given upstream data rate and RX1DROffset
+	// GetRX1Parameters returns datarate, frequency and power for downlink in receive window 1, given upstream data rate and RX1DROffset
 	GetRX1Parameters(channel uint8, upstreamFrequency float32, upstreamDataRate uint8, RX1DROffset uint8) (DownlinkParameters, error)
-	// GetRX2Parameters returns datarate and frequency for downlink in receive window 2.
+	// GetRX2Parameters returns datarate, frequency and power for downlink in receive window 2.
 	GetRX2Parameters() DownlinkParameters
 	// GetDataRate returns data rate, given gateway representation of configuration
 	GetDataRate(configuration string) (uint8, error)
@@ -171,7 +373,27 @@ func NewBand(band FrequencyBandType) (FrequencyPlan, error) {
 		return newEU868(), nil
 	case US915Band:
 		return newUS902(), nil
+	case CN780Band:
+		return newCN779(), nil
+	case EU433Band:
+		return newEU433(), nil
+	case AU915Band:
+		return newAU915(), nil
+	case CN470Band:
+		return newCN470(), nil
+	case AS923Band:
+		return newAS923(1, 0), nil
+	case AS923Band2:
+		return newAS923(2, -1800), nil
+	case AS923Band3:
+		return newAS923(3, -6600), nil
+	case AS923Band4:
+		return newAS923(4, -5900), nil
+	case KR920Band:
+		return newKR920(), nil
+	case IN865Band:
+		return newIN865(), nil
 	default:
-		return nil, fmt.Errorf("unknown band: %v. Valid arguments are: EU868, US915 (CN780 and EU433 are not implemented yet)", band)
+		return nil, fmt.Errorf("unknown band: %v", band)
 	}
 }
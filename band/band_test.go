@@ -15,7 +15,10 @@ package band
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestBandFactory(t *testing.T) {
 	eu, err := NewBand(EU868Band)
@@ -32,12 +35,58 @@ func TestBandFactory(t *testing.T) {
 	if us.Name() != "US 902-928MHz ISM Band" {
 		t.Errorf("Unexpected band name : %s", us.Name())
 	}
-	_, err3 := NewBand(CN780Band)
+	bands := map[FrequencyBandType]string{
+		CN779Band:  "CN 779-787MHz ISM Band",
+		EU433Band:  "EU 433MHz ISM Band",
+		AU915Band:  "AU 915-928MHz ISM Band",
+		CN470Band:  "CN 470-510MHz Band",
+		AS923Band:  "AS 923MHz ISM Band",
+		AS923Band2: "AS 923MHz ISM Band (AS923-2)",
+		AS923Band3: "AS 923MHz ISM Band (AS923-3)",
+		AS923Band4: "AS 923MHz ISM Band (AS923-4)",
+		KR920Band:  "KR 920-923MHz ISM Band",
+		IN865Band:  "IN 865-867MHz ISM Band",
+	}
+	for bandType, name := range bands {
+		b, err := NewBand(bandType)
+		if err != nil {
+			t.Errorf("Got error creating %s: %v", name, err)
+			continue
+		}
+		if b.Name() != name {
+			t.Errorf("Unexpected band name : %s", b.Name())
+		}
+	}
+	_, err3 := NewBand(FrequencyBandType(99))
 	if err3 == nil {
 		t.Error("Did not expect this band to be implemented.")
 	}
 }
 
+func TestBandNames(t *testing.T) {
+	for bandType := range bandNames {
+		parsed, err := ParseBandType(bandType.String())
+		if err != nil || parsed != bandType {
+			t.Errorf("%s parsed as %s (err=%v)", bandType, parsed, err)
+		}
+	}
+	if b, err := ParseBandType("as923"); err != nil || b != AS923Band {
+		t.Errorf("Expected AS923 to be AS923-1 but got %s (err=%v)", b, err)
+	}
+	if b, err := ParseBandType(" us915 "); err != nil || b != US915Band {
+		t.Errorf("Expected US915 but got %s (err=%v)", b, err)
+	}
+	if _, err := ParseBandType("XY123"); err == nil {
+		t.Error("Expected error for unknown band")
+	}
+	if FrequencyBandType(99).String() != "FrequencyBandType(99)" {
+		t.Errorf("Unexpected name for unknown band: %s", FrequencyBandType(99))
+	}
+	if US915Band.SubBands() != 8 || CN470Band.SubBands() != 12 || EU868Band.SubBands() != 0 {
+		t.Error("Unexpected number of sub-bands")
+	}
+}
+
 func TestFOptsLookup(t *testing.T) {
 	mp := MaximumPayloadSize{N: 1, M: 8}
 
@@ -49,3 +98,96 @@ func TestFOptsLookup(t *testing.T) {
 	}
 
 }
+
+func TestDemodulationFloor(t *testing.T) {
+	for sf := uint8(7); sf < 12; sf++ {
+		a, err := DemodulationFloor(sf)
+		if err != nil {
+			t.Fatalf("Got error for SF%d: %v", sf, err)
+		}
+		b, err := DemodulationFloor(sf + 1)
+		if err != nil {
+			t.Fatalf("Got error for SF%d: %v", sf+1, err)
+		}
+		if b >= a {
+			t.Errorf("SF%d should have a lower floor than SF%d (%f >= %f)", sf+1, sf, b, a)
+		}
+	}
+	if _, err := DemodulationFloor(13); err == nil {
+		t.Error("Expected error for SF13")
+	}
+
+	eu := newEU868()
+	enc, _ := eu.Encoding(0)
+	if snr, err := enc.RequiredSNR(); err != nil || snr != -20.0 {
+		t.Errorf("Expected -20 dB for DR0 but got %f (err=%v)", snr, err)
+	}
+	enc, _ = eu.Encoding(7)
+	if _, err := enc.RequiredSNR(); err == nil {
+		t.Error("Expected error for FSK encoding")
+	}
+}
+
+func TestDataRateIdentifier(t *testing.T) {
+	eu := newEU868()
+	for dr := uint8(0); dr < 7; dr++ {
+		id, err := DataRateIdentifier(eu, dr)
+		if err != nil {
+			t.Fatalf("Got error for DR%d: %v", dr, err)
+		}
+		if back, err := eu.GetDataRate(id); err != nil || back != dr {
+			t.Errorf("DR%d maps to %s which maps to DR%d (err=%v)", dr, id, back, err)
+		}
+	}
+	if _, err := DataRateIdentifier(eu, 7); err == nil {
+		t.Error("Expected error for FSK data rate")
+	}
+	us := newUS902()
+	if id, err := DataRateIdentifier(us, 8); err != nil || id != "SF12BW500" {
+		t.Errorf("Expected SF12BW500 for US DR8 but got %s (err=%v)", id, err)
+	}
+}
+
+func TestTimeOnAir(t *testing.T) {
+	// Reference values are from the Semtech LoRa calculator
+	tests := []struct {
+		encoding Encoding
+		length   int
+		expected time.Duration
+	}{
+		{Encoding{Modulation: LoRa, SpreadFactor: 7, Bandwidth: 125}, 10, 41216 * time.Microsecond},
+		{Encoding{Modulation: LoRa, SpreadFactor: 12, Bandwidth: 125}, 10, 991232 * time.Microsecond},
+		{Encoding{Modulation: LoRa, SpreadFactor: 9, Bandwidth: 125}, 51, 328704 * time.Microsecond},
+		{Encoding{Modulation: FSK, BitRate: 50000}, 9, 3200 * time.Microsecond},
+	}
+	for _, test := range tests {
+		toa := test.encoding.TimeOnAir(test.length)
+		if diff := toa - test.expected; diff > time.Microsecond || diff < -time.Microsecond {
+			t.Errorf("Expected %v time on air for %d bytes with %+v but got %v", test.expected, test.length, test.encoding, toa)
+		}
+	}
+}
+
+func TestBeaconFrequencies(t *testing.T) {
+	eu, _ := NewBand(EU868Band)
+	for _, bt := range []uint32{0, 128, 1261872000} {
+		if f := eu.Configuration().BeaconFrequency(bt); f != 869.525 {
+			t.Fatalf("Incorrect EU beacon frequency: %f", f)
+		}
+		if f := eu.Configuration().PingSlotFrequency(bt, 0x01020304); f != 869.525 {
+			t.Fatalf("Incorrect EU ping slot frequency: %f", f)
+		}
+	}
+
+	us, _ := NewBand(US915Band)
+	conf := us.Configuration()
+	if conf.BeaconChannel(0) != 0 || conf.BeaconChannel(128) != 1 || conf.BeaconChannel(8*128) != 0 {
+		t.Fatal("US beacon channel doesn't hop")
+	}
+	if f := conf.BeaconFrequency(128 * 3); f != 925.1 {
+		t.Fatalf("Incorrect US beacon frequency: %f", f)
+	}
+	if f := conf.PingSlotFrequency(128, 2); f != 925.1 {
+		t.Fatalf("Incorrect US ping slot frequency: %f", f)
+	}
+}
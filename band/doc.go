@@ -1,6 +1,8 @@
 /*Package band defines the frequency bands used by the LoRaWAN package.
 
-Currently only the EU868 and US915 bands are defined.
+The EU868, US915, CN779, EU433, AU915, CN470, AS923 (all four variants),
+KR920 and IN865 bands are defined. The AS923 bands limit the dwell time to
+400ms by default.
 */
 package band
 
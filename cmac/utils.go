@@ -42,14 +42,15 @@ func xor(buf1 []byte, buf2 []byte) []byte {
 	return ret
 }
 
-// pad buffer with a single bit + zero up to the maximum
+// pad buffer with a single bit + zero up to the maximum. The padding is added
+// to a copy of the buffer since the buffer might be a slice of a larger array.
 func padblock(buf []byte, n int) []byte {
 	missing := n - len(buf)
 	if missing <= 0 {
 		return buf
 	}
-	if missing == 1 {
-		return append(buf, 0x80) // 0x80 = 10000000b
-	}
-	return append(append(buf, 0x80), make([]byte, missing-1)...)
+	ret := make([]byte, n)
+	copy(ret, buf)
+	ret[len(buf)] = 0x80 // 0x80 = 10000000b
+	return ret
 }
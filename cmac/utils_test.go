@@ -98,3 +98,14 @@ func TestPadding(t *testing.T) {
 		testNPadding(i, t)
 	}
 }
+
+func TestPaddingKeepsBuffer(t *testing.T) {
+	buf := bytes.Repeat([]byte{0xFF}, 16)
+	padded := padblock(buf[:10], 16)
+	if !bytes.Equal(buf, bytes.Repeat([]byte{0xFF}, 16)) {
+		t.Errorf("Padding modified the underlying buffer: %v", buf)
+	}
+	if padded[10] != 0x80 || padded[15] != 0 {
+		t.Errorf("Unexpected padding: %v", padded)
+	}
+}
@@ -17,6 +17,7 @@ package main
 //
 import (
 	"errors"
+	"fmt"
 
 	"github.com/ExploratoryEngineering/congress/gateway"
 	"github.com/ExploratoryEngineering/congress/monitoring"
@@ -94,6 +95,12 @@ func NewServer(config *server.Configuration) (*Server, error) {
 		return nil, errors.New("unable to create key generator")
 	}
 	frameOutput := server.NewFrameOutputBuffer()
+	uplinkHistory := server.NewUplinkHistory(processor.ADRHistoryLength)
+	downlinks := server.NewDownlinkNotifier()
+	txAcks := server.NewTXAckNotifier()
+	gpsGateways := server.NewGPSGateways()
+	activeGateways := server.NewActiveGateways()
+	gatewayBands := server.NewGatewayBands()
 
 	appRouter := pubsub.NewEventRouter(5)
 	gwEventRouter := pubsub.NewEventRouter(5)
@@ -106,10 +113,34 @@ func NewServer(config *server.Configuration) (*Server, error) {
 		GwEventRouter: &gwEventRouter,
 		AppRouter:     &appRouter,
 		AppOutput:     server.NewAppOutputManager(&appRouter),
+		UplinkHistory: &uplinkHistory,
+		Downlinks:     &downlinks,
+		GPSGateways:   &gpsGateways,
+		Gateways:      &activeGateways,
+		GatewayBands:  &gatewayBands,
+		TXAcks:        &txAcks,
+		JoinServer:    server.NewLocalJoinServer(&datastore),
 	}
+	if config.JoinServerURL != "" {
+		logging.Info("Using external join server at %s", config.JoinServerURL)
+		c.context.JoinServer = server.NewHTTPJoinServer(config.JoinServerURL)
+	}
+	c.context.FUOTA = server.NewFUOTAManager(c.context)
 
-	logging.Info("Launching generic packet forwarder on port %d...", config.GatewayPort)
-	c.forwarder = gateway.NewGenericPacketForwarder(c.config.GatewayPort, datastore.Gateway, c.context)
+	gatewayPorts, err := config.GatewayPorts()
+	if err != nil {
+		return nil, err
+	}
+	mux := processor.NewForwarderMux(c.context)
+	for _, port := range gatewayPorts {
+		logging.Info("Launching generic packet forwarder on port %d...", port)
+		mux.Add(fmt.Sprintf("udp-%d", port), gateway.NewGenericPacketForwarder(port, datastore.Gateway, c.context))
+	}
+	if config.StationPort != 0 {
+		logging.Info("Launching Basics Station forwarder on port %d...", config.StationPort)
+		mux.Add(fmt.Sprintf("station-%d", config.StationPort), gateway.NewBasicsStation(c.config.StationPort, datastore.Gateway, c.context))
+	}
+	c.forwarder = mux
 	c.pipeline = processor.NewPipeline(c.context, c.forwarder)
 	c.restapi, err = restapi.NewServer(config.OnlyLoopback, c.context, c.config)
 	if err != nil {
@@ -144,6 +175,9 @@ func (c *Server) Start() error {
 	logging.Debug("Launching outputs")
 	go c.context.AppOutput.LoadOutputs(c.context.Storage.AppOutput)
 
+	logging.Debug("Launching FUOTA campaigns")
+	go c.context.FUOTA.LoadCampaigns()
+
 	logging.Debug("Launching http server")
 	if err := c.restapi.Start(); err != nil {
 		logging.Error("Unable to start REST API endpoint: %v", err)
@@ -156,6 +190,8 @@ func (c *Server) Start() error {
 
 // Shutdown stops the Congress server.
 func (c *Server) Shutdown() error {
+	c.context.FUOTA.Shutdown()
+	c.pipeline.Stop()
 	c.forwarder.Stop()
 	c.restapi.Shutdown()
 	c.monitoring.Shutdown()
@@ -41,7 +41,25 @@ func NewRx(data string) GwEvent {
 	return GwEvent{gwEventType("Rx"), data}
 }
 
+// NewTxFailed creates a new TxFailed event for the gateway. The data is the
+// TX_ACK sent by the gateway when it rejects a downlink.
+func NewTxFailed(data string) GwEvent {
+	return GwEvent{gwEventType("TxFailed"), data}
+}
+
 // NewTx creates a new Tx event for the gateway
 func NewTx(data string) GwEvent {
 	return GwEvent{gwEventType("Tx"), data}
 }
+
+// NewTxConfirmed creates a new TxConfirmed event for the gateway. The data is
+// the confirmation sent by the gateway when the downlink has been sent.
+func NewTxConfirmed(data string) GwEvent {
+	return GwEvent{gwEventType("TxConfirmed"), data}
+}
+
+// NewStatus creates a new Status event for the gateway. The data is the status
+// report sent by the gateway.
+func NewStatus(data string) GwEvent {
+	return GwEvent{gwEventType("Status"), data}
+}
@@ -22,4 +22,7 @@ func TestEventCreation(t *testing.T) {
 	NewKeepAlive()
 	NewTx("some data")
 	NewRx("some data")
+	NewTxFailed("some data")
+	NewTxConfirmed("some data")
+	NewStatus("some data")
 }
@@ -0,0 +1,137 @@
+package frequency
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+// Channel plan management. The channel plan for a device is the band's
+// mandatory channels followed by the application's channels or the band's
+// additional channels if the application doesn't specify any.
+
+import (
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// Steps converts a frequency in MHz to the 100 Hz steps used by the CFList and
+// the MAC commands [5.4], [5.6]
+func Steps(mhz float32) uint32 {
+	return uint32(mhz*10000 + 0.5)
+}
+
+// mandatoryChannels returns the default channels for the band
+func mandatoryChannels(plan band.FrequencyPlan) model.ChannelList {
+	var ret model.ChannelList
+	for _, v := range plan.Configuration().MandatoryEndDeviceChannels {
+		ret = append(ret, model.Channel{Frequency: v})
+	}
+	return ret
+}
+
+// ChannelPlan returns the channels that the devices in the application should
+// use. Bands without support for CFList and NewChannelReq only use the
+// mandatory channels.
+func ChannelPlan(plan band.FrequencyPlan, app model.Application) model.ChannelList {
+	ret := mandatoryChannels(plan)
+	if !plan.Configuration().SupportsJoinAcceptCFList {
+		return ret
+	}
+	if len(app.Channels) > 0 {
+		ret = append(ret, app.Channels...)
+	} else {
+		for _, v := range plan.Configuration().AdditionalChannels {
+			ret = append(ret, model.Channel{Frequency: v})
+		}
+	}
+	if len(ret) > model.MaxChannels {
+		ret = ret[:model.MaxChannels]
+	}
+	return ret
+}
+
+// DeviceChannels returns the channels the device is using. Devices without a
+// channel list use the band's mandatory channels.
+func DeviceChannels(plan band.FrequencyPlan, device model.Device) model.ChannelList {
+	if len(device.Channels) == 0 {
+		return mandatoryChannels(plan)
+	}
+	return device.Channels
+}
+
+// CFList builds the CFList for the JoinAccept message from the channel plan.
+// The returned channel list is the channels the device will use after the
+// join. Channels that don't fit into the CFList are set up with NewChannelReq
+// commands later on.
+func CFList(plan band.FrequencyPlan, channels model.ChannelList) (protocol.CFList, model.ChannelList) {
+	ret := protocol.CFList{}
+	active := mandatoryChannels(plan)
+	if !plan.Configuration().SupportsJoinAcceptCFList {
+		return ret, active
+	}
+	first := len(active)
+	for i := 0; i < protocol.CFListChannels && first+i < len(channels); i++ {
+		ret.Frequencies[i] = Steps(channels[first+i].Frequency)
+	}
+	if ret.Empty() {
+		return ret, active
+	}
+	for i := 0; i < protocol.CFListChannels && first+i < len(channels); i++ {
+		// The CFList doesn't include the downlink frequencies so the device
+		// will use the uplink frequency for RX1.
+		active = append(active, model.Channel{Frequency: channels[first+i].Frequency})
+	}
+	return ret, active
+}
+
+// SubBandChMaskCntl is the ChMaskCntl value in LinkADRReq where each bit in
+// the channel mask enables one of the sub-bands and all other channels are
+// disabled [2.5.5/Regional Parameters]
+const SubBandChMaskCntl = 5
+
+// Bands with sub-bands have 8 sub-bands with 8 125 kHz channels each,
+// followed by 8 500 kHz channels. Sub-band 1 is channel 0-7 and channel 64.
+const (
+	subBandCount    = 8
+	subBandChannels = 8
+	wideChannel     = 64
+)
+
+// SubBandChMask returns the channel mask for LinkADRReq that enables the
+// gateway's sub-band. The mask is used with SubBandChMaskCntl. The flag is
+// false if the band doesn't have sub-bands or if the sub-band is 0, ie the
+// gateway listens to all of the channels.
+func SubBandChMask(plan band.FrequencyPlan, subBand uint8) (uint16, bool) {
+	if subBand == 0 || subBand > subBandCount || !plan.Configuration().SubBandChMask {
+		return 0, false
+	}
+	return 1 << (subBand - 1), true
+}
+
+// SubBandCFList returns the CFList for the JoinAccept message that enables the
+// 125 kHz channels in the gateway's sub-band and the corresponding 500 kHz
+// channel. The flag is false if the band doesn't have sub-bands or if the
+// sub-band is 0.
+func SubBandCFList(plan band.FrequencyPlan, subBand uint8) (protocol.CFList, bool) {
+	ret := protocol.CFList{Type: protocol.CFListChMask}
+	if _, ok := SubBandChMask(plan, subBand); !ok {
+		return ret, false
+	}
+	first := int(subBand-1) * subBandChannels
+	ret.ChMask[first/16] = 0xFF << uint(first%16)
+	wide := wideChannel + int(subBand-1)
+	ret.ChMask[wide/16] = 1 << uint(wide%16)
+	return ret, true
+}
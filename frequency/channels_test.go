@@ -0,0 +1,115 @@
+package frequency
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"testing"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func TestChannelPlan(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	app := model.NewApplication()
+
+	channels := ChannelPlan(eu, app)
+	if len(channels) != 8 || channels[0].Frequency != 868.1 || channels[3].Frequency != 867.1 {
+		t.Fatalf("Expected default channels for band but got %v", channels)
+	}
+
+	app.Channels = model.ChannelList{{Frequency: 869.1, Downlink: 869.5}}
+	channels = ChannelPlan(eu, app)
+	if len(channels) != 4 || channels[3] != app.Channels[0] {
+		t.Fatalf("Expected application channels but got %v", channels)
+	}
+
+	us, _ := band.NewBand(band.US915Band)
+	channels = ChannelPlan(us, app)
+	if len(channels) != len(us.Configuration().MandatoryEndDeviceChannels) {
+		t.Fatalf("Expected only mandatory channels for US band but got %v", channels)
+	}
+
+	device := model.NewDevice()
+	if len(DeviceChannels(eu, device)) != 3 {
+		t.Fatalf("Expected mandatory channels for new device")
+	}
+}
+
+func TestCFList(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	app := model.NewApplication()
+
+	cfList, active := CFList(eu, ChannelPlan(eu, app))
+	if cfList.Frequencies[0] != 8671000 || cfList.Frequencies[4] != 8679000 {
+		t.Fatalf("Unexpected CFList: %v", cfList)
+	}
+	if len(active) != 8 || active[7].Frequency != 867.9 {
+		t.Fatalf("Unexpected active channels: %v", active)
+	}
+
+	// Channels after the first five are skipped and downlink frequencies are ignored
+	app.Channels = model.ChannelList{{Frequency: 867.1, Downlink: 869.1}, {}, {Frequency: 867.5}, {}, {}, {Frequency: 867.9}}
+	cfList, active = CFList(eu, ChannelPlan(eu, app))
+	if cfList.Frequencies[1] != 0 || cfList.Frequencies[2] != 8675000 {
+		t.Fatalf("Unexpected CFList: %v", cfList)
+	}
+	if len(active) != 8 || active[3] != (model.Channel{Frequency: 867.1}) {
+		t.Fatalf("Unexpected active channels: %v", active)
+	}
+
+	// Only mandatory channels when there's nothing to add
+	app.Channels = model.ChannelList{{}}
+	cfList, active = CFList(eu, ChannelPlan(eu, app))
+	if !cfList.Empty() || len(active) != 3 {
+		t.Fatalf("Expected empty CFList but got %v (%v)", cfList, active)
+	}
+}
+
+func TestSubBand(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	us, _ := band.NewBand(band.US915Band)
+	au, _ := band.NewBand(band.AU915Band)
+
+	if _, ok := SubBandChMask(eu, 2); ok {
+		t.Fatal("EU868 doesn't have sub-bands")
+	}
+	if _, ok := SubBandChMask(us, 0); ok {
+		t.Fatal("Sub-band 0 uses all channels")
+	}
+	if _, ok := SubBandChMask(us, 9); ok {
+		t.Fatal("There are only 8 sub-bands")
+	}
+	if mask, ok := SubBandChMask(au, 2); !ok || mask != 0x0002 {
+		t.Fatalf("Unexpected channel mask for sub-band 2: %04x", mask)
+	}
+
+	// Sub-band 2 is channel 8-15 and channel 65
+	cfList, ok := SubBandCFList(us, 2)
+	if !ok || cfList.Type != protocol.CFListChMask || cfList.ChMask != [protocol.CFListChMasks]uint16{0xFF00, 0, 0, 0, 0x0002} {
+		t.Fatalf("Unexpected CFList for sub-band 2: %+v", cfList)
+	}
+	// Sub-band 7 is channel 48-55 and channel 70
+	cfList, ok = SubBandCFList(us, 7)
+	if !ok || cfList.ChMask != [protocol.CFListChMasks]uint16{0, 0, 0, 0x00FF, 0x0040} {
+		t.Fatalf("Unexpected CFList for sub-band 7: %+v", cfList)
+	}
+	if _, ok := SubBandCFList(eu, 1); ok {
+		t.Fatal("Did not expect CFList for EU868")
+	}
+}
@@ -0,0 +1,153 @@
+package fuota
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Commands in the Application Layer Clock Synchronization package. The
+// commands are sent on ClockSyncPort.
+const (
+	ClockPackageVersion      uint8 = 0x00
+	AppTime                  uint8 = 0x01
+	DeviceAppTimePeriodicity uint8 = 0x02
+	ForceDeviceResync        uint8 = 0x03
+)
+
+// ClockSyncPort is the port used by the clock synchronization package
+const ClockSyncPort = 202
+
+// Package identifier and version for the clock synchronization package
+const (
+	ClockSyncPackageID      = 1
+	ClockSyncPackageVersion = 1
+)
+
+// AppTimeReq is sent by the device with its current time. The server answers
+// with an AppTimeAns if AnsRequired is set or the device clock is off.
+type AppTimeReq struct {
+	DeviceTime  uint32 // Device time in seconds since the GPS epoch (modulo 2^32)
+	TokenReq    uint8  // Token (0-15) that is echoed in the answer
+	AnsRequired bool   // The device expects an answer even if its clock is correct
+}
+
+// Encode encodes the request into a byte buffer
+func (r AppTimeReq) Encode() []byte {
+	ret := make([]byte, 6)
+	ret[0] = AppTime
+	binary.LittleEndian.PutUint32(ret[1:], r.DeviceTime)
+	ret[5] = r.TokenReq & 0x0F
+	if r.AnsRequired {
+		ret[5] |= 0x10
+	}
+	return ret
+}
+
+// AppTimeAns is the correction the device should apply to its clock
+type AppTimeAns struct {
+	TimeCorrection int32 // Correction in seconds
+	TokenAns       uint8 // The token from the request
+}
+
+// Encode encodes the answer into a byte buffer
+func (a AppTimeAns) Encode() []byte {
+	ret := make([]byte, 6)
+	ret[0] = AppTime
+	binary.LittleEndian.PutUint32(ret[1:], uint32(a.TimeCorrection))
+	ret[5] = a.TokenAns & 0x0F
+	return ret
+}
+
+// DeviceAppTimePeriodicityReq sets the interval for AppTimeReq requests from
+// the device. The interval is 128*2^Period seconds.
+type DeviceAppTimePeriodicityReq struct {
+	Period uint8
+}
+
+// Encode encodes the request into a byte buffer
+func (r DeviceAppTimePeriodicityReq) Encode() []byte {
+	return []byte{DeviceAppTimePeriodicity, r.Period & 0x0F}
+}
+
+// DeviceAppTimePeriodicityAns is the device's answer to the
+// DeviceAppTimePeriodicityReq command. Time is the device's current time.
+type DeviceAppTimePeriodicityAns struct {
+	NotSupported bool
+	Time         uint32
+}
+
+// Err returns an error if the device doesn't support the periodicity
+func (a *DeviceAppTimePeriodicityAns) Err() error {
+	if a.NotSupported {
+		return errors.New("periodicity not supported")
+	}
+	return nil
+}
+
+// ForceDeviceResyncReq makes the device send NbTransmissions AppTimeReq
+// requests (0-7) to synchronize its clock.
+type ForceDeviceResyncReq struct {
+	NbTransmissions uint8
+}
+
+// Encode encodes the request into a byte buffer
+func (r ForceDeviceResyncReq) Encode() []byte {
+	return []byte{ForceDeviceResync, r.NbTransmissions & 0x07}
+}
+
+// DecodeClockSyncUplink decodes the commands sent by a device on
+// ClockSyncPort. The returned commands are either requests (*AppTimeReq) or
+// answers (*PackageVersionAns or *DeviceAppTimePeriodicityAns).
+func DecodeClockSyncUplink(payload []byte) ([]interface{}, error) {
+	var ret []interface{}
+	for pos := 0; pos < len(payload); {
+		cmd := payload[pos]
+		pos++
+		switch cmd {
+		case ClockPackageVersion:
+			if len(payload)-pos < 2 {
+				return ret, errTruncated
+			}
+			ret = append(ret, &PackageVersionAns{Package: payload[pos], Version: payload[pos+1]})
+			pos += 2
+		case AppTime:
+			if len(payload)-pos < 5 {
+				return ret, errTruncated
+			}
+			ret = append(ret, &AppTimeReq{
+				DeviceTime:  binary.LittleEndian.Uint32(payload[pos:]),
+				TokenReq:    payload[pos+4] & 0x0F,
+				AnsRequired: payload[pos+4]&0x10 != 0,
+			})
+			pos += 5
+		case DeviceAppTimePeriodicity:
+			if len(payload)-pos < 5 {
+				return ret, errTruncated
+			}
+			ret = append(ret, &DeviceAppTimePeriodicityAns{
+				NotSupported: payload[pos]&0x01 != 0,
+				Time:         binary.LittleEndian.Uint32(payload[pos+1:]),
+			})
+			pos += 5
+		default:
+			return ret, fmt.Errorf("unknown clock synchronization command: 0x%02x", cmd)
+		}
+	}
+	return ret, nil
+}
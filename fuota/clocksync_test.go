@@ -0,0 +1,65 @@
+package fuota
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"bytes"
+	"testing"
+)
+
+func TestClockSyncEncoding(t *testing.T) {
+	req := AppTimeReq{DeviceTime: 0x01020304, TokenReq: 5, AnsRequired: true}
+	if buf := req.Encode(); !bytes.Equal(buf, []byte{0x01, 0x04, 0x03, 0x02, 0x01, 0x15}) {
+		t.Fatalf("Unexpected AppTimeReq encoding: %v", buf)
+	}
+	ans := AppTimeAns{TimeCorrection: -2, TokenAns: 5}
+	if buf := ans.Encode(); !bytes.Equal(buf, []byte{0x01, 0xFE, 0xFF, 0xFF, 0xFF, 0x05}) {
+		t.Fatalf("Unexpected AppTimeAns encoding: %v", buf)
+	}
+	if buf := (DeviceAppTimePeriodicityReq{Period: 3}).Encode(); !bytes.Equal(buf, []byte{0x02, 0x03}) {
+		t.Fatalf("Unexpected DeviceAppTimePeriodicityReq encoding: %v", buf)
+	}
+	if buf := (ForceDeviceResyncReq{NbTransmissions: 9}).Encode(); !bytes.Equal(buf, []byte{0x03, 0x01}) {
+		t.Fatalf("Unexpected ForceDeviceResyncReq encoding: %v", buf)
+	}
+}
+
+func TestDecodeClockSyncUplink(t *testing.T) {
+	payload := AppTimeReq{DeviceTime: 1000, TokenReq: 3}.Encode()
+	payload = append(payload, ClockPackageVersion, ClockSyncPackageID, ClockSyncPackageVersion)
+	payload = append(payload, DeviceAppTimePeriodicity, 0x01, 0, 0, 0, 0)
+	cmds, err := DecodeClockSyncUplink(payload)
+	if err != nil || len(cmds) != 3 {
+		t.Fatalf("Expected 3 commands but got %d (err=%v)", len(cmds), err)
+	}
+	req, ok := cmds[0].(*AppTimeReq)
+	if !ok || req.DeviceTime != 1000 || req.TokenReq != 3 || req.AnsRequired {
+		t.Fatalf("Unexpected AppTimeReq: %+v", cmds[0])
+	}
+	if ver, ok := cmds[1].(*PackageVersionAns); !ok || ver.Package != ClockSyncPackageID {
+		t.Fatalf("Unexpected PackageVersionAns: %+v", cmds[1])
+	}
+	if ans, ok := cmds[2].(*DeviceAppTimePeriodicityAns); !ok || ans.Err() == nil {
+		t.Fatalf("Expected DeviceAppTimePeriodicityAns with error: %+v", cmds[2])
+	}
+
+	if _, err := DecodeClockSyncUplink([]byte{AppTime, 1, 2}); err == nil {
+		t.Fatal("Expected error with truncated payload")
+	}
+	if _, err := DecodeClockSyncUplink([]byte{0x7F}); err == nil {
+		t.Fatal("Expected error with unknown command")
+	}
+}
@@ -0,0 +1,296 @@
+package fuota
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Commands in the Fragmented Data Block Transport package. The commands are
+// sent on FragmentationPort.
+const (
+	FragPackageVersion uint8 = 0x00
+	FragSessionStatus  uint8 = 0x01
+	FragSessionSetup   uint8 = 0x02
+	FragSessionDelete  uint8 = 0x03
+	DataFragment       uint8 = 0x08
+)
+
+// FragmentationPort is the port used by the fragmentation package
+const FragmentationPort = 201
+
+// MaxFragments is the largest number of fragments (uncoded and coded) in a
+// fragmentation session. The fragment index is a 14-bit value.
+const MaxFragments = 1<<14 - 1
+
+// FragSessionSetupReq sets up a fragmentation session on the device. The
+// fragments are sent to the multicast groups in McGroupMask.
+type FragSessionSetupReq struct {
+	FragIndex     uint8  // Fragmentation session index (0-3)
+	McGroupMask   uint8  // Multicast groups (bit mask) that will receive the fragments
+	NbFrag        uint16 // Number of uncoded fragments
+	FragSize      uint8  // Size of each fragment
+	BlockAckDelay uint8  // Random delay exponent for the answers (0-7)
+	Padding       uint8  // Number of padding bytes in the last uncoded fragment
+	Descriptor    uint32 // Free-form description of the data block, ie the firmware version
+}
+
+// Encode encodes the request into a byte buffer
+func (r FragSessionSetupReq) Encode() []byte {
+	ret := make([]byte, 11)
+	ret[0] = FragSessionSetup
+	ret[1] = (r.FragIndex&0x03)<<4 | r.McGroupMask&0x0F
+	binary.LittleEndian.PutUint16(ret[2:], r.NbFrag)
+	ret[4] = r.FragSize
+	// The fragmentation matrix is always 0
+	ret[5] = r.BlockAckDelay & 0x07
+	ret[6] = r.Padding
+	binary.LittleEndian.PutUint32(ret[7:], r.Descriptor)
+	return ret
+}
+
+// FragSessionStatusReq asks the devices for the status of the fragmentation
+// session. If AllParticipants is false only the devices missing fragments
+// will answer.
+type FragSessionStatusReq struct {
+	FragIndex       uint8
+	AllParticipants bool
+}
+
+// Encode encodes the request into a byte buffer
+func (r FragSessionStatusReq) Encode() []byte {
+	ret := []byte{FragSessionStatus, (r.FragIndex & 0x03) << 1}
+	if r.AllParticipants {
+		ret[1] |= 0x01
+	}
+	return ret
+}
+
+// FragSessionDeleteReq removes a fragmentation session from the device
+type FragSessionDeleteReq struct {
+	FragIndex uint8
+}
+
+// Encode encodes the request into a byte buffer
+func (r FragSessionDeleteReq) Encode() []byte {
+	return []byte{FragSessionDelete, r.FragIndex & 0x03}
+}
+
+// EncodeDataFragment encodes a single fragment. The fragment number n starts
+// at 1.
+func EncodeDataFragment(fragIndex uint8, n uint16, fragment []byte) []byte {
+	ret := make([]byte, 3+len(fragment))
+	ret[0] = DataFragment
+	binary.LittleEndian.PutUint16(ret[1:], uint16(fragIndex&0x03)<<14|n&0x3FFF)
+	copy(ret[3:], fragment)
+	return ret
+}
+
+// FragSessionSetupAns is the device's answer to FragSessionSetupReq
+type FragSessionSetupAns struct {
+	FragIndex           uint8
+	EncodingUnsupported bool
+	NotEnoughMemory     bool
+	IndexNotSupported   bool
+	WrongDescriptor     bool
+}
+
+// Err returns an error if the device rejected the session
+func (a *FragSessionSetupAns) Err() error {
+	switch {
+	case a.EncodingUnsupported:
+		return errors.New("fragmentation encoding not supported")
+	case a.NotEnoughMemory:
+		return errors.New("not enough memory for the fragmentation session")
+	case a.IndexNotSupported:
+		return fmt.Errorf("fragmentation session index %d not supported", a.FragIndex)
+	case a.WrongDescriptor:
+		return errors.New("wrong descriptor")
+	}
+	return nil
+}
+
+// FragSessionStatusAns is the device's status for a fragmentation session
+type FragSessionStatusAns struct {
+	FragIndex             uint8
+	NbFragReceived        uint16 // Number of fragments received, including coded fragments
+	MissingFrag           uint8  // Number of fragments needed to reconstruct the data block
+	NotEnoughMatrixMemory bool
+}
+
+// Err returns an error if the device can't reconstruct the data block
+func (a *FragSessionStatusAns) Err() error {
+	if a.NotEnoughMatrixMemory {
+		return errors.New("not enough memory to reconstruct the data block")
+	}
+	return nil
+}
+
+// FragSessionDeleteAns is the device's answer to FragSessionDeleteReq
+type FragSessionDeleteAns struct {
+	FragIndex       uint8
+	SessionNotFound bool
+}
+
+// Err returns an error if the session didn't exist on the device
+func (a *FragSessionDeleteAns) Err() error {
+	if a.SessionNotFound {
+		return fmt.Errorf("fragmentation session %d does not exist", a.FragIndex)
+	}
+	return nil
+}
+
+// PackageVersionAns is the device's answer to the PackageVersionReq command
+type PackageVersionAns struct {
+	Package uint8
+	Version uint8
+}
+
+// Err always returns nil
+func (a *PackageVersionAns) Err() error {
+	return nil
+}
+
+// Answer is an answer from a device. Err returns a non-nil error if the device
+// rejected the request.
+type Answer interface {
+	Err() error
+}
+
+// errTruncated is returned when the answer is shorter than expected
+var errTruncated = errors.New("answer is truncated")
+
+// DecodeFragmentationAnswers decodes the answers sent by a device on
+// FragmentationPort. A device may send several answers in a single frame.
+func DecodeFragmentationAnswers(payload []byte) ([]Answer, error) {
+	var ret []Answer
+	for pos := 0; pos < len(payload); {
+		cmd := payload[pos]
+		pos++
+		switch cmd {
+		case FragPackageVersion:
+			if len(payload)-pos < 2 {
+				return ret, errTruncated
+			}
+			ret = append(ret, &PackageVersionAns{Package: payload[pos], Version: payload[pos+1]})
+			pos += 2
+		case FragSessionStatus:
+			if len(payload)-pos < 4 {
+				return ret, errTruncated
+			}
+			received := binary.LittleEndian.Uint16(payload[pos:])
+			ret = append(ret, &FragSessionStatusAns{
+				FragIndex:             uint8(received >> 14),
+				NbFragReceived:        received & 0x3FFF,
+				MissingFrag:           payload[pos+2],
+				NotEnoughMatrixMemory: payload[pos+3]&0x01 != 0,
+			})
+			pos += 4
+		case FragSessionSetup:
+			if len(payload)-pos < 1 {
+				return ret, errTruncated
+			}
+			status := payload[pos]
+			ret = append(ret, &FragSessionSetupAns{
+				FragIndex:           status >> 6,
+				WrongDescriptor:     status&0x08 != 0,
+				IndexNotSupported:   status&0x04 != 0,
+				NotEnoughMemory:     status&0x02 != 0,
+				EncodingUnsupported: status&0x01 != 0,
+			})
+			pos++
+		case FragSessionDelete:
+			if len(payload)-pos < 1 {
+				return ret, errTruncated
+			}
+			ret = append(ret, &FragSessionDeleteAns{
+				FragIndex:       payload[pos] & 0x03,
+				SessionNotFound: payload[pos]&0x04 != 0,
+			})
+			pos++
+		default:
+			return ret, fmt.Errorf("unknown fragmentation command: 0x%02x", cmd)
+		}
+	}
+	return ret, nil
+}
+
+// prbs23 is the pseudo-random generator used for the parity matrix
+func prbs23(x uint32) uint32 {
+	b0 := x & 1
+	b1 := (x & 32) >> 5
+	return (x >> 1) + ((b0 ^ b1) << 22)
+}
+
+// matrixLine returns line n (starting at 1) of the parity matrix for m
+// uncoded fragments. Coded fragment n is the XOR of the uncoded fragments
+// that are set in the line.
+func matrixLine(n, m int) []bool {
+	line := make([]bool, m)
+	mm := 0
+	if m&(m-1) == 0 {
+		// Powers of two would give a bias with the modulo below
+		mm = 1
+	}
+	x := uint32(1 + 1001*n)
+	for coeff := 0; coeff < m/2; coeff++ {
+		r := 1 << 16
+		for r >= m {
+			x = prbs23(x)
+			r = int(x % uint32(m+mm))
+		}
+		line[r] = true
+	}
+	return line
+}
+
+// Fragments splits the data block into fragments of fragSize bytes followed
+// by redundancy coded fragments. The devices can reconstruct the data block
+// from any set of (slightly more than) NbFrag fragments. The last uncoded
+// fragment is padded with zeros. The number of uncoded fragments and the
+// number of padding bytes are returned with the fragments.
+func Fragments(data []byte, fragSize int, redundancy int) (fragments [][]byte, nbFrag int, padding int, err error) {
+	if fragSize <= 0 || len(data) == 0 {
+		return nil, 0, 0, errors.New("data and fragment size must be greater than zero")
+	}
+	nbFrag = (len(data) + fragSize - 1) / fragSize
+	padding = nbFrag*fragSize - len(data)
+	if nbFrag+redundancy > MaxFragments {
+		return nil, 0, 0, fmt.Errorf("too many fragments (%d)", nbFrag+redundancy)
+	}
+	padded := make([]byte, nbFrag*fragSize)
+	copy(padded, data)
+
+	fragments = make([][]byte, 0, nbFrag+redundancy)
+	for i := 0; i < nbFrag; i++ {
+		fragments = append(fragments, padded[i*fragSize:(i+1)*fragSize])
+	}
+	for n := 1; n <= redundancy; n++ {
+		coded := make([]byte, fragSize)
+		for i, set := range matrixLine(n, nbFrag) {
+			if !set {
+				continue
+			}
+			for j := range coded {
+				coded[j] ^= fragments[i][j]
+			}
+		}
+		fragments = append(fragments, coded)
+	}
+	return fragments, nbFrag, padding, nil
+}
@@ -0,0 +1,183 @@
+package fuota
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"bytes"
+	"testing"
+)
+
+// decodeFragments reconstructs the data block from the received fragments
+// with Gaussian elimination. Fragment numbers start at 1.
+func decodeFragments(t *testing.T, received map[int][]byte, nbFrag int, fragSize int) []byte {
+	type row struct {
+		coeff []bool
+		data  []byte
+	}
+	var rows []row
+	for n, frag := range received {
+		r := row{coeff: make([]bool, nbFrag), data: append([]byte{}, frag...)}
+		if n <= nbFrag {
+			r.coeff[n-1] = true
+		} else {
+			r.coeff = matrixLine(n-nbFrag, nbFrag)
+		}
+		rows = append(rows, r)
+	}
+	ret := make([]byte, nbFrag*fragSize)
+	for col := 0; col < nbFrag; col++ {
+		pivot := -1
+		for i := col; i < len(rows); i++ {
+			if rows[i].coeff[col] {
+				pivot = i
+				break
+			}
+		}
+		if pivot < 0 {
+			t.Fatalf("Can't reconstruct fragment %d", col+1)
+		}
+		rows[col], rows[pivot] = rows[pivot], rows[col]
+		for i := range rows {
+			if i == col || !rows[i].coeff[col] {
+				continue
+			}
+			for j := range rows[i].coeff {
+				rows[i].coeff[j] = rows[i].coeff[j] != rows[col].coeff[j]
+			}
+			for j := range rows[i].data {
+				rows[i].data[j] ^= rows[col].data[j]
+			}
+		}
+	}
+	for col := 0; col < nbFrag; col++ {
+		copy(ret[col*fragSize:], rows[col].data)
+	}
+	return ret
+}
+
+func TestFragments(t *testing.T) {
+	data := make([]byte, 195)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	fragments, nbFrag, padding, err := Fragments(data, 20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nbFrag != 10 || padding != 5 || len(fragments) != 20 {
+		t.Fatalf("Unexpected fragmentation: nbFrag=%d padding=%d fragments=%d", nbFrag, padding, len(fragments))
+	}
+	for _, f := range fragments {
+		if len(f) != 20 {
+			t.Fatalf("Fragment has wrong size: %d", len(f))
+		}
+	}
+
+	// Drop a few of the uncoded and coded fragments. The data block can be
+	// reconstructed from the remaining fragments.
+	received := make(map[int][]byte)
+	for i, f := range fragments {
+		n := i + 1
+		if n == 2 || n == 5 || n == 9 || n == 13 {
+			continue
+		}
+		received[n] = f
+	}
+	decoded := decodeFragments(t, received, nbFrag, 20)
+	if !bytes.Equal(decoded[:len(data)], data) {
+		t.Fatal("Reconstructed data does not match")
+	}
+	if !bytes.Equal(decoded[len(data):], make([]byte, padding)) {
+		t.Fatal("Padding isn't zeroes")
+	}
+
+	if _, _, _, err := Fragments(data, 0, 1); err == nil {
+		t.Fatal("Expected error with zero fragment size")
+	}
+	if _, _, _, err := Fragments(nil, 10, 1); err == nil {
+		t.Fatal("Expected error with no data")
+	}
+	if _, _, _, err := Fragments(make([]byte, MaxFragments+1), 1, 0); err == nil {
+		t.Fatal("Expected error with too many fragments")
+	}
+}
+
+func TestMatrixLine(t *testing.T) {
+	for _, m := range []int{2, 7, 16, 100} {
+		for n := 1; n < 10; n++ {
+			count := 0
+			for _, v := range matrixLine(n, m) {
+				if v {
+					count++
+				}
+			}
+			if count == 0 || count > m/2 {
+				t.Fatalf("Line %d for m=%d has %d coefficients", n, m, count)
+			}
+		}
+	}
+}
+
+func TestFragmentationEncoding(t *testing.T) {
+	req := FragSessionSetupReq{FragIndex: 1, McGroupMask: 0x01, NbFrag: 0x0102, FragSize: 50, BlockAckDelay: 3, Padding: 7, Descriptor: 0x04030201}
+	expected := []byte{0x02, 0x11, 0x02, 0x01, 50, 0x03, 7, 0x01, 0x02, 0x03, 0x04}
+	if buf := req.Encode(); !bytes.Equal(buf, expected) {
+		t.Fatalf("FragSessionSetupReq encoded as %x, expected %x", buf, expected)
+	}
+	if buf := (FragSessionStatusReq{FragIndex: 2, AllParticipants: true}).Encode(); !bytes.Equal(buf, []byte{0x01, 0x05}) {
+		t.Fatalf("FragSessionStatusReq encoded as %x", buf)
+	}
+	if buf := (FragSessionDeleteReq{FragIndex: 3}).Encode(); !bytes.Equal(buf, []byte{0x03, 0x03}) {
+		t.Fatalf("FragSessionDeleteReq encoded as %x", buf)
+	}
+	if buf := EncodeDataFragment(1, 3, []byte{0xAA, 0xBB}); !bytes.Equal(buf, []byte{0x08, 0x03, 0x40, 0xAA, 0xBB}) {
+		t.Fatalf("DataFragment encoded as %x", buf)
+	}
+}
+
+func TestFragmentationAnswers(t *testing.T) {
+	answers, err := DecodeFragmentationAnswers([]byte{
+		0x00, 0x03, 0x01, // PackageVersionAns
+		0x02, 0x44, // FragSessionSetupAns, index 1, index not supported
+		0x01, 0x0A, 0x40, 0x00, 0x00, // FragSessionStatusAns, index 1, 10 received
+		0x03, 0x04, // FragSessionDeleteAns, session not found
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != 4 {
+		t.Fatalf("Expected 4 answers but got %d", len(answers))
+	}
+	if v, ok := answers[0].(*PackageVersionAns); !ok || v.Package != 3 || v.Version != 1 || v.Err() != nil {
+		t.Fatalf("Unexpected package version answer: %+v", answers[0])
+	}
+	if v, ok := answers[1].(*FragSessionSetupAns); !ok || v.FragIndex != 1 || !v.IndexNotSupported || v.Err() == nil {
+		t.Fatalf("Unexpected setup answer: %+v", answers[1])
+	}
+	if v, ok := answers[2].(*FragSessionStatusAns); !ok || v.FragIndex != 1 || v.NbFragReceived != 10 || v.MissingFrag != 0 || v.Err() != nil {
+		t.Fatalf("Unexpected status answer: %+v", answers[2])
+	}
+	if v, ok := answers[3].(*FragSessionDeleteAns); !ok || !v.SessionNotFound || v.Err() == nil {
+		t.Fatalf("Unexpected delete answer: %+v", answers[3])
+	}
+
+	if _, err := DecodeFragmentationAnswers([]byte{0x01, 0x00}); err == nil {
+		t.Fatal("Expected error with truncated answer")
+	}
+	if _, err := DecodeFragmentationAnswers([]byte{0x08}); err == nil {
+		t.Fatal("Expected error with unknown answer")
+	}
+}
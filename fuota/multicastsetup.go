@@ -0,0 +1,295 @@
+package fuota
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// Commands in the Remote Multicast Setup package. The commands are sent on
+// MulticastSetupPort.
+const (
+	McPackageVersion uint8 = 0x00
+	McGroupStatus    uint8 = 0x01
+	McGroupSetup     uint8 = 0x02
+	McGroupDelete    uint8 = 0x03
+	McClassCSession  uint8 = 0x04
+	McClassBSession  uint8 = 0x05
+)
+
+// MulticastSetupPort is the port used by the multicast setup package
+const MulticastSetupPort = 200
+
+// MaxMcGroupID is the largest multicast group ID a device supports
+const MaxMcGroupID = 3
+
+// ErrNoRootKey is returned when the device doesn't have a key to derive the
+// McRootKey from
+var ErrNoRootKey = errors.New("device has no GenAppKey or AppKey for the multicast keys")
+
+// McRootKey derives the root key for the multicast keys. LoRaWAN 1.0 devices
+// derive it from the GenAppKey and LoRaWAN 1.1 devices from the AppKey.
+func McRootKey(genAppKey, appKey protocol.AESKey, version protocol.MACVersion) (protocol.AESKey, error) {
+	key, prefix := genAppKey, byte(0x00)
+	if version == protocol.MACVersion11 {
+		key, prefix = appKey, 0x20
+	}
+	if key == (protocol.AESKey{}) {
+		return protocol.AESKey{}, ErrNoRootKey
+	}
+	return deriveKey(key, []byte{prefix})
+}
+
+// EncryptMcKey encrypts the multicast key with the key encryption key that is
+// derived from the device's McRootKey.
+func EncryptMcKey(mcRootKey protocol.AESKey, mcKey protocol.AESKey) (protocol.AESKey, error) {
+	mcKEKey, err := deriveKey(mcRootKey, []byte{0x00})
+	if err != nil {
+		return protocol.AESKey{}, err
+	}
+	cipher, err := aes.NewCipher(mcKEKey.Key[:])
+	if err != nil {
+		return protocol.AESKey{}, err
+	}
+	ret := protocol.AESKey{}
+	cipher.Decrypt(ret.Key[:], mcKey.Key[:])
+	return ret, nil
+}
+
+// MulticastSessionKeys derives the network and application session keys for
+// a multicast group from the multicast key.
+func MulticastSessionKeys(mcKey protocol.AESKey, mcAddr protocol.DevAddr) (mcNwkSKey protocol.AESKey, mcAppSKey protocol.AESKey, err error) {
+	buf := make([]byte, 5)
+	binary.LittleEndian.PutUint32(buf[1:], mcAddr.ToUint32())
+	buf[0] = 0x01
+	if mcAppSKey, err = deriveKey(mcKey, buf); err != nil {
+		return
+	}
+	buf[0] = 0x02
+	mcNwkSKey, err = deriveKey(mcKey, buf)
+	return
+}
+
+// deriveKey encrypts the (zero padded) block with the key
+func deriveKey(key protocol.AESKey, block []byte) (protocol.AESKey, error) {
+	cipher, err := aes.NewCipher(key.Key[:])
+	if err != nil {
+		return protocol.AESKey{}, err
+	}
+	buf := make([]byte, 16)
+	copy(buf, block)
+	ret := protocol.AESKey{}
+	cipher.Encrypt(ret.Key[:], buf)
+	return ret, nil
+}
+
+// McGroupSetupReq sets up a multicast group on the device. The multicast key
+// is encrypted with EncryptMcKey.
+type McGroupSetupReq struct {
+	GroupID        uint8
+	McAddr         protocol.DevAddr
+	McKeyEncrypted protocol.AESKey
+	MinFCnt        uint32 // The first frame counter the device accepts
+	MaxFCnt        uint32 // The last frame counter the device accepts
+}
+
+// Encode encodes the request into a byte buffer
+func (r McGroupSetupReq) Encode() []byte {
+	ret := make([]byte, 30)
+	ret[0] = McGroupSetup
+	ret[1] = r.GroupID & 0x03
+	binary.LittleEndian.PutUint32(ret[2:], r.McAddr.ToUint32())
+	copy(ret[6:], r.McKeyEncrypted.Key[:])
+	binary.LittleEndian.PutUint32(ret[22:], r.MinFCnt)
+	binary.LittleEndian.PutUint32(ret[26:], r.MaxFCnt)
+	return ret
+}
+
+// McGroupDeleteReq removes a multicast group from the device
+type McGroupDeleteReq struct {
+	GroupID uint8
+}
+
+// Encode encodes the request into a byte buffer
+func (r McGroupDeleteReq) Encode() []byte {
+	return []byte{McGroupDelete, r.GroupID & 0x03}
+}
+
+// McSessionReq starts a class B or C multicast session on the device. The
+// session starts at SessionTime (GPS time in seconds) and lasts for 2^TimeOut
+// seconds for class C sessions and 2^TimeOut beacon periods for class B
+// sessions. Class B sessions must start at a beacon.
+type McSessionReq struct {
+	ClassB      bool
+	GroupID     uint8
+	SessionTime uint32
+	TimeOut     uint8   // Session timeout exponent (0-15)
+	Periodicity uint8   // Ping slot periodicity for class B sessions
+	Frequency   float32 // Downlink frequency in MHz
+	DataRate    uint8
+}
+
+// Encode encodes the request into a byte buffer
+func (r McSessionReq) Encode() []byte {
+	ret := make([]byte, 11)
+	ret[0] = McClassCSession
+	ret[6] = r.TimeOut & 0x0F
+	if r.ClassB {
+		ret[0] = McClassBSession
+		ret[6] |= (r.Periodicity & 0x07) << 4
+	}
+	ret[1] = r.GroupID & 0x03
+	binary.LittleEndian.PutUint32(ret[2:], r.SessionTime)
+	freq := frequency.Steps(r.Frequency)
+	ret[7] = byte(freq)
+	ret[8] = byte(freq >> 8)
+	ret[9] = byte(freq >> 16)
+	ret[10] = r.DataRate
+	return ret
+}
+
+// MaxSessionTimeOut is the largest session timeout exponent
+const MaxSessionTimeOut = 15
+
+// SessionTimeOut returns the smallest session timeout exponent that gives a
+// session of at least the given length. Class B sessions are measured in
+// beacon periods and class C sessions in seconds. The returned value is
+// capped at MaxSessionTimeOut.
+func SessionTimeOut(length time.Duration, classB bool) uint8 {
+	unit := time.Second
+	if classB {
+		unit = protocol.BeaconPeriod
+	}
+	ret := uint8(0)
+	for ret < MaxSessionTimeOut && time.Duration(1<<ret)*unit < length {
+		ret++
+	}
+	return ret
+}
+
+// McGroupSetupAns is the device's answer to McGroupSetupReq
+type McGroupSetupAns struct {
+	GroupID uint8
+	IDError bool
+}
+
+// Err returns an error if the device rejected the group
+func (a *McGroupSetupAns) Err() error {
+	if a.IDError {
+		return fmt.Errorf("multicast group ID %d not supported", a.GroupID)
+	}
+	return nil
+}
+
+// McGroupDeleteAns is the device's answer to McGroupDeleteReq
+type McGroupDeleteAns struct {
+	GroupID        uint8
+	GroupUndefined bool
+}
+
+// Err returns an error if the group didn't exist on the device
+func (a *McGroupDeleteAns) Err() error {
+	if a.GroupUndefined {
+		return fmt.Errorf("multicast group %d is undefined", a.GroupID)
+	}
+	return nil
+}
+
+// McSessionAns is the device's answer to the class B and class C session
+// requests.
+type McSessionAns struct {
+	ClassB         bool
+	GroupID        uint8
+	DataRateError  bool
+	FrequencyError bool
+	GroupUndefined bool
+	TimeToStart    uint32 // Seconds until the session starts. Only set if the session is accepted
+}
+
+// Err returns an error if the device rejected the session
+func (a *McSessionAns) Err() error {
+	switch {
+	case a.GroupUndefined:
+		return fmt.Errorf("multicast group %d is undefined", a.GroupID)
+	case a.FrequencyError:
+		return errors.New("multicast frequency not supported")
+	case a.DataRateError:
+		return errors.New("multicast data rate not supported")
+	}
+	return nil
+}
+
+// DecodeMulticastSetupAnswers decodes the answers sent by a device on
+// MulticastSetupPort. A device may send several answers in a single frame.
+// McGroupStatusAns isn't supported since the status request is never sent.
+func DecodeMulticastSetupAnswers(payload []byte) ([]Answer, error) {
+	var ret []Answer
+	for pos := 0; pos < len(payload); {
+		cmd := payload[pos]
+		pos++
+		switch cmd {
+		case McPackageVersion:
+			if len(payload)-pos < 2 {
+				return ret, errTruncated
+			}
+			ret = append(ret, &PackageVersionAns{Package: payload[pos], Version: payload[pos+1]})
+			pos += 2
+		case McGroupSetup:
+			if len(payload)-pos < 1 {
+				return ret, errTruncated
+			}
+			ret = append(ret, &McGroupSetupAns{GroupID: payload[pos] & 0x03, IDError: payload[pos]&0x04 != 0})
+			pos++
+		case McGroupDelete:
+			if len(payload)-pos < 1 {
+				return ret, errTruncated
+			}
+			ret = append(ret, &McGroupDeleteAns{GroupID: payload[pos] & 0x03, GroupUndefined: payload[pos]&0x04 != 0})
+			pos++
+		case McClassCSession, McClassBSession:
+			if len(payload)-pos < 1 {
+				return ret, errTruncated
+			}
+			status := payload[pos]
+			ans := &McSessionAns{
+				ClassB:         cmd == McClassBSession,
+				GroupID:        status & 0x03,
+				DataRateError:  status&0x04 != 0,
+				FrequencyError: status&0x08 != 0,
+				GroupUndefined: status&0x10 != 0,
+			}
+			pos++
+			if ans.Err() == nil {
+				if len(payload)-pos < 3 {
+					return ret, errTruncated
+				}
+				ans.TimeToStart = uint32(payload[pos]) | uint32(payload[pos+1])<<8 | uint32(payload[pos+2])<<16
+				pos += 3
+			}
+			ret = append(ret, ans)
+		default:
+			return ret, fmt.Errorf("unknown multicast setup command: 0x%02x", cmd)
+		}
+	}
+	return ret, nil
+}
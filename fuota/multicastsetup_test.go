@@ -0,0 +1,157 @@
+package fuota
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"bytes"
+	"crypto/aes"
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func TestMulticastKeys(t *testing.T) {
+	genAppKey, _ := protocol.NewAESKey()
+	appKey, _ := protocol.NewAESKey()
+	mcKey, _ := protocol.NewAESKey()
+
+	rootKey10, err := McRootKey(genAppKey, appKey, protocol.MACVersion10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := deriveKey(genAppKey, []byte{0x00}); rootKey10 != expected {
+		t.Fatal("Expected the 1.0 root key to be derived from the GenAppKey")
+	}
+	rootKey11, err := McRootKey(genAppKey, appKey, protocol.MACVersion11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := deriveKey(appKey, []byte{0x20}); rootKey11 != expected {
+		t.Fatal("Expected the 1.1 root key to be derived from the AppKey")
+	}
+	if _, err := McRootKey(protocol.AESKey{}, appKey, protocol.MACVersion10); err != ErrNoRootKey {
+		t.Fatal("Expected error when the 1.0 device has no GenAppKey")
+	}
+	if _, err := McRootKey(genAppKey, protocol.AESKey{}, protocol.MACVersion11); err != ErrNoRootKey {
+		t.Fatal("Expected error when the 1.1 device has no AppKey")
+	}
+
+	// The device decrypts the key by encrypting it with McKEKey
+	encrypted, err := EncryptMcKey(rootKey10, mcKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcKEKey, _ := deriveKey(rootKey10, []byte{0})
+	cipher, _ := aes.NewCipher(mcKEKey.Key[:])
+	decrypted := protocol.AESKey{}
+	cipher.Encrypt(decrypted.Key[:], encrypted.Key[:])
+	if decrypted != mcKey {
+		t.Fatal("Device can't decrypt the multicast key")
+	}
+
+	nwkSKey, appSKey, err := MulticastSessionKeys(mcKey, protocol.DevAddrFromUint32(0x01020304))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nwkSKey == appSKey || nwkSKey == mcKey {
+		t.Fatal("Session keys aren't derived properly")
+	}
+}
+
+func TestMulticastSetupEncoding(t *testing.T) {
+	key := protocol.AESKey{}
+	for i := range key.Key {
+		key.Key[i] = byte(i)
+	}
+	req := McGroupSetupReq{GroupID: 1, McAddr: protocol.DevAddrFromUint32(0x01020304), McKeyEncrypted: key, MinFCnt: 1, MaxFCnt: 0x100}
+	buf := req.Encode()
+	if len(buf) != 30 || buf[0] != McGroupSetup || buf[1] != 1 {
+		t.Fatalf("McGroupSetupReq encoded incorrectly: %x", buf)
+	}
+	if !bytes.Equal(buf[2:6], []byte{4, 3, 2, 1}) || !bytes.Equal(buf[6:22], key.Key[:]) ||
+		!bytes.Equal(buf[22:30], []byte{1, 0, 0, 0, 0, 1, 0, 0}) {
+		t.Fatalf("McGroupSetupReq fields encoded incorrectly: %x", buf)
+	}
+
+	session := McSessionReq{GroupID: 2, SessionTime: 0x01020304, TimeOut: 10, Frequency: 869.525, DataRate: 3}
+	expected := []byte{McClassCSession, 0x02, 0x04, 0x03, 0x02, 0x01, 0x0A, 0xD2, 0xAD, 0x84, 0x03}
+	if buf := session.Encode(); !bytes.Equal(buf, expected) {
+		t.Fatalf("McClassCSessionReq encoded as %x, expected %x", buf, expected)
+	}
+	session.ClassB = true
+	session.Periodicity = 5
+	expected[0] = McClassBSession
+	expected[6] = 0x5A
+	if buf := session.Encode(); !bytes.Equal(buf, expected) {
+		t.Fatalf("McClassBSessionReq encoded as %x, expected %x", buf, expected)
+	}
+	if buf := (McGroupDeleteReq{GroupID: 3}).Encode(); !bytes.Equal(buf, []byte{McGroupDelete, 3}) {
+		t.Fatalf("McGroupDeleteReq encoded as %x", buf)
+	}
+}
+
+func TestMulticastSetupAnswers(t *testing.T) {
+	answers, err := DecodeMulticastSetupAnswers([]byte{
+		0x02, 0x01, // McGroupSetupAns, group 1
+		0x04, 0x00, 0x10, 0x00, 0x00, // McClassCSessionAns, group 0, starts in 16 seconds
+		0x05, 0x0A, // McClassBSessionAns, group 2, frequency error
+		0x03, 0x07, // McGroupDeleteAns, group 3 undefined
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != 4 {
+		t.Fatalf("Expected 4 answers but got %d", len(answers))
+	}
+	if v, ok := answers[0].(*McGroupSetupAns); !ok || v.GroupID != 1 || v.Err() != nil {
+		t.Fatalf("Unexpected group setup answer: %+v", answers[0])
+	}
+	if v, ok := answers[1].(*McSessionAns); !ok || v.ClassB || v.TimeToStart != 16 || v.Err() != nil {
+		t.Fatalf("Unexpected class C session answer: %+v", answers[1])
+	}
+	if v, ok := answers[2].(*McSessionAns); !ok || !v.ClassB || v.GroupID != 2 || !v.FrequencyError || v.Err() == nil {
+		t.Fatalf("Unexpected class B session answer: %+v", answers[2])
+	}
+	if v, ok := answers[3].(*McGroupDeleteAns); !ok || v.GroupID != 3 || v.Err() == nil {
+		t.Fatalf("Unexpected group delete answer: %+v", answers[3])
+	}
+
+	if _, err := DecodeMulticastSetupAnswers([]byte{0x04, 0x00, 0x01}); err == nil {
+		t.Fatal("Expected error with truncated answer")
+	}
+	if _, err := DecodeMulticastSetupAnswers([]byte{0x01, 0x00}); err == nil {
+		t.Fatal("Expected error with unsupported answer")
+	}
+}
+
+func TestSessionTimeOut(t *testing.T) {
+	if v := SessionTimeOut(0, false); v != 0 {
+		t.Fatalf("Expected 0 for empty session but got %d", v)
+	}
+	if v := SessionTimeOut(1000*time.Second, false); v != 10 {
+		t.Fatalf("Expected 10 for 1000 seconds but got %d", v)
+	}
+	if v := SessionTimeOut(1024*time.Second, false); v != 10 {
+		t.Fatalf("Expected 10 for 1024 seconds but got %d", v)
+	}
+	if v := SessionTimeOut(1000*time.Second, true); v != 3 {
+		t.Fatalf("Expected 3 for 1000 seconds in class B but got %d", v)
+	}
+	if v := SessionTimeOut(1000*time.Hour, false); v != MaxSessionTimeOut {
+		t.Fatalf("Expected timeout to be capped but got %d", v)
+	}
+}
@@ -90,6 +90,13 @@ func (pkt *GwPacket) UnmarshalBinary(data []byte) error {
 
 	case 5:
 		pkt.Identifier = TxAck
+		// Version 2 of the protocol includes the gateway EUI
+		if len(data) >= 12 {
+			val := binary.BigEndian.Uint64(data[4:12])
+			pkt.GatewayEUI = protocol.EUIFromUint64(val)
+			pkt.JSONString = string(data[12:])
+			break
+		}
 		if len(data) > 4 {
 			pkt.JSONString = string(data[4:])
 		}
@@ -70,6 +70,15 @@ func TestBinaryMarshal(t *testing.T) {
 		t.Fatal("Couldn't unmarshal TX_ACK")
 	}
 
+	// TX_ACK with gateway EUI (version 2)
+	buffer = append([]byte{2, 0x11, 0x22, 5, 1, 2, 3, 4, 5, 6, 7, 8}, []byte(`{"txpk_ack":{"error":"TOO_LATE"}}`)...)
+	if pkt.UnmarshalBinary(buffer) != nil {
+		t.Fatal("Couldn't unmarshal TX_ACK")
+	}
+	if pkt.GatewayEUI != protocol.EUIFromUint64(0x0102030405060708) || pkt.JSONString != `{"txpk_ack":{"error":"TOO_LATE"}}` {
+		t.Fatalf("Unexpected TX_ACK contents: %+v", pkt)
+	}
+
 	// Unknown type
 	buffer = []byte{0, 0x11, 0x22, 99}
 	if pkt.UnmarshalBinary(buffer) == nil {
@@ -19,6 +19,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
 	"net"
 	"time"
@@ -36,16 +37,6 @@ import (
 	"github.com/ExploratoryEngineering/logging"
 )
 
-var defaultBand band.FrequencyPlan
-
-func init() {
-	var err error
-	defaultBand, err = band.NewBand(band.EU868Band)
-	if err != nil {
-		logging.Error("Unable to create EU868 band instance: %v", err)
-	}
-}
-
 // GenericPacketForwarder is the generic packet forwarder provided by
 // Semtech. It has its weak points but it is the smallest common
 // denominator for all gateways on the market.
@@ -60,6 +51,7 @@ type GenericPacketForwarder struct {
 	context      *server.Context
 	mutex        *sync.Mutex    // Mutex for pullAckPort map
 	pullAckPorts map[string]int // Map of port <-> gateway
+	sent         *sentPackets   // Packets waiting for TX_ACK
 }
 
 // Start launches the generic packet forwarder. It does not return until the
@@ -119,6 +111,7 @@ func NewGenericPacketForwarder(serverPort int, storage storage.GatewayStorage, c
 		context:      context,
 		mutex:        &sync.Mutex{},
 		pullAckPorts: make(map[string]int),
+		sent:         newSentPackets(),
 	}
 }
 
@@ -242,21 +235,14 @@ func (p *GenericPacketForwarder) mainLoop(serverConn *net.UDPConn) {
 
 			case PushData:
 				logging.Debug("PUSH_DATA received from %s: %s", val.GatewayEUI, val.JSONString)
-				if !p.context.Config.DisableGatewayChecks {
-					gw, err := p.storage.Get(val.GatewayEUI, model.SystemUserID)
-					if err != nil {
-						logging.Info("Unable to locate gateway with EUI %s: %v", val.GatewayEUI, err)
-						continue
-					}
-					if gw.StrictIP && gw.IP.String() != val.Host {
-						logging.Warning("IP mismatch for gateway with EUI %s: %s (should be %s)", val.GatewayEUI, gw.IP, val.Host)
-						continue
-					}
+				plan, subBand, ok := p.gatewayBand(val)
+				if !ok {
+					continue
 				}
 				p.context.GwEventRouter.Publish(val.GatewayEUI, gwevents.NewRx(val.JSONString))
 
 				// Send PushAck with same version and token
-				p.decodeReceivedJSON(val)
+				p.decodeReceivedJSON(val, plan, subBand)
 				p.udpOutput <- GwPacket{
 					Identifier:      PushAck,
 					Token:           val.Token,
@@ -267,7 +253,7 @@ func (p *GenericPacketForwarder) mainLoop(serverConn *net.UDPConn) {
 				}
 				monitoring.GatewayIn.Increment()
 			case TxAck:
-				// Ignore (for now)
+				p.handleTxAck(val)
 			default:
 				logging.Info("Don't know how to handle input with identifier=%d from gateway", val.Identifier)
 			}
@@ -275,6 +261,74 @@ func (p *GenericPacketForwarder) mainLoop(serverConn *net.UDPConn) {
 	}
 }
 
+// gatewayBand looks up the gateway that sent the packet and returns the band
+// and sub-band it uses. The flag is false if the packet should be rejected.
+// Unknown gateways use the default band (EU868) when the gateway checks are
+// disabled.
+func (p *GenericPacketForwarder) gatewayBand(val GwPacket) (band.FrequencyPlan, uint8, bool) {
+	gw, plan, ok := lookupGateway(p.storage, p.context, val.GatewayEUI, val.Host)
+	return plan, gw.SubBand, ok
+}
+
+// lookupGateway looks up a gateway and the band it uses. The host is the
+// address the gateway connected from. The flag is false if the gateway should
+// be rejected. Unknown gateways use the default settings when the gateway
+// checks are disabled. The gateway and the band are cached until the gateway
+// is changed through the API.
+func lookupGateway(storage storage.GatewayStorage, context *server.Context, eui protocol.EUI, host string) (model.Gateway, band.FrequencyPlan, bool) {
+	checks := !context.Config.DisableGatewayChecks
+	gw, plan, cached := context.GatewayBands.Get(eui)
+	if !cached {
+		var err error
+		gw, err = storage.Get(eui, model.SystemUserID)
+		if err != nil {
+			if checks {
+				logging.Info("Unable to locate gateway with EUI %s: %v", eui, err)
+				return gw, nil, false
+			}
+			gw = model.NewGateway()
+			gw.GatewayEUI = eui
+		}
+		if plan, err = band.NewBand(gw.Band); err != nil {
+			logging.Warning("Unable to create band %s for gateway with EUI %s: %v", gw.Band, eui, err)
+			return gw, nil, false
+		}
+		context.GatewayBands.Put(gw, plan)
+	}
+	if checks && gw.StrictIP && gw.IP.String() != host {
+		logging.Warning("IP mismatch for gateway with EUI %s: %s (should be %s)", eui, gw.IP, host)
+		return gw, nil, false
+	}
+	return gw, plan, true
+}
+
+// handleTxAck handles TX_ACK packets from the gateway. The packets are only
+// sent by version 2 of the packet forwarder. If the gateway has rejected the
+// downlink a gateway event is published and the downlink is forwarded to the
+// processor.
+func (p *GenericPacketForwarder) handleTxAck(val GwPacket) {
+	packet, found := p.sent.remove(val.GatewayEUI, val.Token)
+	// The JSON object is optional and an empty object means no error
+	ack := TxAckData{}
+	if val.JSONString != "" {
+		if err := json.Unmarshal([]byte(val.JSONString), &ack); err != nil {
+			logging.Info("Unable to unmarshal TX_ACK from gateway %s: %v (json=%s)", val.GatewayEUI, err, val.JSONString)
+			return
+		}
+	}
+	if ack.Ack.Error == "" || ack.Ack.Error == TxAckNone {
+		return
+	}
+	logging.Warning("Gateway %s rejected downlink: %s", val.GatewayEUI, ack.Ack.Error)
+	monitoring.DownlinkFailed.Increment()
+	p.context.GwEventRouter.Publish(val.GatewayEUI, gwevents.NewTxFailed(val.JSONString))
+	if !found {
+		logging.Info("Unknown token %04x in TX_ACK from gateway %s", val.Token, val.GatewayEUI)
+		return
+	}
+	p.context.TXAcks.Notify(server.TXAck{Packet: packet, Error: ack.Ack.Error})
+}
+
 // This is the default setup for the Semtech packet forwarder/EU868 band config.
 func (p *GenericPacketForwarder) lookupFrequency(rfchain uint8, channel uint8) float32 {
 	switch channel {
@@ -301,8 +355,9 @@ func (p *GenericPacketForwarder) lookupFrequency(rfchain uint8, channel uint8) f
 
 }
 
-// Unmarshal and forward JSON from gateway
-func (p *GenericPacketForwarder) decodeReceivedJSON(val GwPacket) {
+// Unmarshal and forward JSON from gateway. The plan and sub-band are the
+// gateway's band and sub-band.
+func (p *GenericPacketForwarder) decodeReceivedJSON(val GwPacket, plan band.FrequencyPlan, subBand uint8) {
 	rxData := RXData{}
 
 	incomingTimer := monitoring.NewTimer()
@@ -315,15 +370,16 @@ func (p *GenericPacketForwarder) decodeReceivedJSON(val GwPacket) {
 		logging.Info("Unable to unmarshal JSON from %s:%d: %v (json=%s)", val.Host, val.Port, err, val.JSONString)
 		return
 	}
+	p.handleStats(val, rxData)
 
 	for _, packet := range rxData.Data {
 		gwPacket := server.GatewayPacket{
 			Radio: server.RadioContext{
-				Frequency: p.lookupFrequency(packet.ConcentratorRFChain, packet.ConcentratorChannel),
+				Frequency: packet.Frequency,
 				DataRate:  packet.DataRateID,
 				Channel:   packet.ConcentratorChannel,
 				RFChain:   packet.ConcentratorRFChain,
-				Band:      defaultBand,
+				Band:      plan,
 				RX1Delay:  0,
 				RX2Delay:  0,
 				RSSI:      packet.RSSI,
@@ -335,15 +391,28 @@ func (p *GenericPacketForwarder) decodeReceivedJSON(val GwPacket) {
 				GatewayPort:     val.Port,
 				GatewayClock:    packet.Timestamp,
 				ProtocolVersion: val.ProtocolVersion,
+				SubBand:         subBand,
 			},
 			ReceivedAt:   time.Now(),
 			SectionTimer: timer,
 			InTimer:      incomingTimer,
 		}
+		if gwPacket.Radio.Frequency == 0 {
+			gwPacket.Radio.Frequency = p.lookupFrequency(packet.ConcentratorRFChain, packet.ConcentratorChannel)
+		}
 		if gwPacket.RawMessage, err = base64.StdEncoding.DecodeString(packet.RFPackets); err != nil {
 			logging.Info("Unable to convert base64 string into bytes: %v (source=%s)", err, packet.RFPackets)
 			return
 		}
+		p.context.Gateways.Update(gwPacket.Gateway, gwPacket.Radio.Band)
+		if packet.Time != "" {
+			// The packet forwarder only includes the time when the
+			// gateway's clock is synchronized with GPS
+			p.context.GPSGateways.Update(gwPacket.Gateway, gwPacket.Radio.Band)
+			if gwPacket.GatewayTime, err = time.Parse(time.RFC3339Nano, packet.Time); err != nil {
+				logging.Debug("Unable to parse time from gateway %s: %v (time=%s)", val.GatewayEUI, err, packet.Time)
+			}
+		}
 		gwPacket.SectionTimer.End()
 		monitoring.Stopwatch(monitoring.GatewayChannelOut, func() {
 			p.output <- gwPacket
@@ -351,15 +420,110 @@ func (p *GenericPacketForwarder) decodeReceivedJSON(val GwPacket) {
 	}
 }
 
+// handleStats stores the status reports included in the PUSH_DATA packet. The
+// gateway's location is updated if the report includes a GPS position. Reports
+// are only stored for gateways that exist.
+func (p *GenericPacketForwarder) handleStats(val GwPacket, rxData RXData) {
+	stats, err := rxData.Stats()
+	if err != nil {
+		logging.Info("Unable to decode status report from gateway %s: %v (stat=%s)", val.GatewayEUI, err, string(rxData.Stat))
+		return
+	}
+	if len(stats) == 0 {
+		return
+	}
+	gw, err := p.storage.Get(val.GatewayEUI, model.SystemUserID)
+	if err != nil {
+		// Unknown gateways are allowed when the gateway checks are disabled
+		return
+	}
+	now := time.Now()
+	for _, stat := range stats {
+		status := model.GatewayStatus{
+			Received:         now,
+			RXReceived:       stat.RXReceived,
+			RXOK:             stat.RXOK,
+			RXForwarded:      stat.RXForwarded,
+			ACKRatio:         stat.ACKRatio,
+			DownlinkReceived: stat.DownlinkReceived,
+			TXEmitted:        stat.TXEmitted,
+		}
+		if stat.Time != "" {
+			gwTime, err := time.Parse(StatTimeFormat, stat.Time)
+			if err != nil {
+				logging.Debug("Unable to parse status time from gateway %s: %v (time=%s)", val.GatewayEUI, err, stat.Time)
+			} else {
+				status.GatewayTime = gwTime.UTC()
+			}
+		}
+		// Gateways without a GPS fix might report 0,0 as the position
+		if stat.Latitude != nil && stat.Longitude != nil && (*stat.Latitude != 0 || *stat.Longitude != 0) {
+			status.HasLocation = true
+			status.Latitude = *stat.Latitude
+			status.Longitude = *stat.Longitude
+			if stat.Altitude != nil {
+				status.Altitude = *stat.Altitude
+			}
+			gw = p.updateLocation(gw, stat)
+		}
+		if err := p.storage.PutStatus(val.GatewayEUI, status); err != nil {
+			logging.Warning("Unable to store status report from gateway %s: %v", val.GatewayEUI, err)
+		}
+	}
+	if err := p.storage.DeleteStatus(val.GatewayEUI, now.Add(-gatewayStatusRetention)); err != nil {
+		logging.Warning("Unable to remove old status reports from gateway %s: %v", val.GatewayEUI, err)
+	}
+	p.context.GwEventRouter.Publish(val.GatewayEUI, gwevents.NewStatus(string(rxData.Stat)))
+}
+
+// gatewayStatusRetention is how long the status reports are kept. The packet
+// forwarder sends a report every 30 seconds by default.
+const gatewayStatusRetention = 24 * time.Hour
+
+// GPS positions vary slightly between reports. Changes below these limits
+// aren't written to the storage. 0.0001 degrees is roughly 11 meters.
+const (
+	locationJitterDegrees = 0.0001
+	altitudeJitterMeters  = 10
+)
+
+// updateLocation updates the stored location of the gateway with the GPS
+// position from the status report. Only the location is written and small
+// changes are ignored. The altitude is only updated if it is reported. The
+// returned gateway has the location that is stored.
+func (p *GenericPacketForwarder) updateLocation(gw model.Gateway, stat Stat) model.Gateway {
+	moved := math.Abs(float64(gw.Latitude-*stat.Latitude)) >= locationJitterDegrees ||
+		math.Abs(float64(gw.Longitude-*stat.Longitude)) >= locationJitterDegrees ||
+		(stat.Altitude != nil && math.Abs(float64(gw.Altitude-*stat.Altitude)) >= altitudeJitterMeters)
+	if !moved {
+		return gw
+	}
+	if err := p.storage.UpdateLocation(gw.GatewayEUI, *stat.Latitude, *stat.Longitude, stat.Altitude); err != nil {
+		logging.Warning("Unable to update location for gateway %s: %v", gw.GatewayEUI, err)
+		return gw
+	}
+	gw.Latitude = *stat.Latitude
+	gw.Longitude = *stat.Longitude
+	if stat.Altitude != nil {
+		gw.Altitude = *stat.Altitude
+	}
+	return gw
+}
+
 // Encode and send data as JSON to gateway
 func (p *GenericPacketForwarder) encodeAndSend(packet server.GatewayPacket) {
 	// Create a PULL_RESP packet for the gateway
-	// Timestamp is in us; use precomputed RXDelay value
-	timestamp := packet.Gateway.GatewayClock + 1000000*uint32(packet.Radio.RX1Delay)
+	// Timestamp is in us; use the delay for the receive window set by the scheduler
+	timestamp := packet.Gateway.GatewayClock + uint32(packet.Radio.RXDelay()/time.Microsecond)
+	power := packet.Radio.TXPower
+	if power == 0 && packet.Radio.Band != nil {
+		power = packet.Radio.Band.Configuration().DefaultTxPower
+	}
 	outputPkt := Txpk{
 		Timestamp:    timestamp,              // us clock
 		Frequency:    packet.Radio.Frequency, // packet.TransmitFrequency,
-		RFChain:      0,                      // This is the default for the packet forwarder
+		RFChain:      packet.Radio.RFChain,
+		TxPower:      uint32(power),
 		Data:         base64.StdEncoding.EncodeToString(packet.RawMessage),
 		Modulation:   "LORA",
 		EccCoding:    "4/5",
@@ -367,6 +531,23 @@ func (p *GenericPacketForwarder) encodeAndSend(packet server.GatewayPacket) {
 		PayloadSize:  len(packet.RawMessage),
 		LoRaDataRate: packet.Radio.DataRate,
 	}
+	if packet.Immediate {
+		// Class C downlinks are sent as soon as the gateway gets them
+		outputPkt.Timestamp = 0
+		outputPkt.Immediate = true
+	}
+	if !packet.TXTime.IsZero() {
+		// Beacons and class B downlinks are sent at an absolute time
+		outputPkt.Timestamp = 0
+		outputPkt.Time = packet.TXTime.UTC().Format(time.RFC3339Nano)
+	}
+	if packet.Beacon {
+		// Beacons use a 10 symbol preamble, no CRC and no inverted
+		// polarity [15.1]
+		outputPkt.LoraInvPol = false
+		outputPkt.NoCRC = true
+		outputPkt.RfPreamble = 10
+	}
 	outputStruct := TXData{Data: outputPkt}
 
 	buffer, err := json.Marshal(outputStruct)
@@ -374,21 +555,26 @@ func (p *GenericPacketForwarder) encodeAndSend(packet server.GatewayPacket) {
 		logging.Info("Unable to marshal JSON for txpk: %v", err)
 		return
 	}
+	token := uint16(rand.Int() & 0xFFFF) // This is unused in v1
+	p.sent.add(packet.Gateway.GatewayEUI, token, packet)
 	p.udpOutput <- GwPacket{
 		Identifier:      PullResp,
-		Token:           uint16(rand.Int() & 0xFFFF), // This is unused in v1
+		Token:           token,
 		Host:            packet.Gateway.GatewayHost,
 		Port:            p.getPullAckPort(packet.Gateway.GatewayEUI),
 		ProtocolVersion: packet.Gateway.ProtocolVersion,
 		GatewayEUI:      packet.Gateway.GatewayEUI,
 		JSONString:      string(buffer),
 	}
+	checkDeadline(packet)
+}
+
+// checkDeadline logs class A downlinks that are sent too late for the
+// receive window.
+func checkDeadline(packet server.GatewayPacket) {
 	timeToProcess := time.Now().Sub(packet.ReceivedAt)
-	const assumedLatency = 0.2
-	// Assume 100ms latency between gateway and
-	// Congress. This is roughly what we can expect in Europe. Norway -> Ireland
-	// is about 50 ms; further south is is easily 100ms (or more).
-	if timeToProcess.Seconds() > (packet.Deadline - assumedLatency) {
+	assumedLatency := server.AssumedGatewayLatency.Seconds()
+	if !packet.Immediate && packet.TXTime.IsZero() && timeToProcess.Seconds() > (packet.Deadline-assumedLatency) {
 		logging.Error("Packet to %s missed deadline of %.2f seconds with assumedLatency of %.2f (took %.2f s)",
 			packet.Gateway.GatewayEUI, packet.Deadline, assumedLatency, timeToProcess.Seconds())
 		monitoring.MissedDeadline.Increment()
@@ -15,6 +15,8 @@ package gateway
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
+import "encoding/json"
+
 // Rxpk is a (JSON) struct used by the Semtech packet forwarder. It is sent from the gateway to the server.
 type Rxpk struct {
 	Time                string  `json:"time"` // Time stamp (unix-) for the gateway
@@ -51,10 +53,68 @@ type Txpk struct {
 
 }
 
+// Error codes for TX_ACK packets. NONE (or an empty JSON object) means the
+// packet is scheduled for transmission.
+const (
+	TxAckNone            = "NONE"             // Packet has been programmed for downlink
+	TxAckTooLate         = "TOO_LATE"         // Rejected because it was already too late to program this packet for downlink
+	TxAckTooEarly        = "TOO_EARLY"        // Rejected because downlink packet timestamp is too much in advance
+	TxAckCollisionPacket = "COLLISION_PACKET" // Rejected because there was already a packet programmed in requested timeframe
+	TxAckCollisionBeacon = "COLLISION_BEACON" // Rejected because there was already a beacon planned in requested timeframe
+	TxAckTxFreq          = "TX_FREQ"          // Rejected because requested frequency is not supported by TX RF chain
+	TxAckTxPower         = "TX_POWER"         // Rejected because requested power is not supported by gateway
+	TxAckGPSUnlocked     = "GPS_UNLOCKED"     // Rejected because GPS is unlocked, so GPS timestamp cannot be used
+)
+
+// TxAckData is the (optional) JSON object sent by the gateway in TX_ACK packets.
+type TxAckData struct {
+	Ack TxAckError `json:"txpk_ack"`
+}
+
+// TxAckError holds the error code for TX_ACK packets
+type TxAckError struct {
+	Error string `json:"error"`
+}
+
+// Stat is the status report sent periodically by the packet forwarder. The
+// location is only included if the gateway has a GPS.
+type Stat struct {
+	Time             string   `json:"time"` // UTC system time of the gateway, f.e. "2014-01-12 08:59:28 GMT"
+	Latitude         *float32 `json:"lati"` // GPS latitude of the gateway in degrees (float, N is +)
+	Longitude        *float32 `json:"long"` // GPS longitude of the gateway in degrees (float, E is +)
+	Altitude         *float32 `json:"alti"` // GPS altitude of the gateway in meters
+	RXReceived       uint32   `json:"rxnb"` // Number of radio packets received
+	RXOK             uint32   `json:"rxok"` // Number of radio packets received with a valid PHY CRC
+	RXForwarded      uint32   `json:"rxfw"` // Number of radio packets forwarded
+	ACKRatio         float32  `json:"ackr"` // Percentage of upstream datagrams that were acknowledged
+	DownlinkReceived uint32   `json:"dwnb"` // Number of downlink datagrams received
+	TXEmitted        uint32   `json:"txnb"` // Number of packets emitted
+}
+
+// StatTimeFormat is the time format used in status reports
+const StatTimeFormat = "2006-01-02 15:04:05 MST"
+
 // RXData contains device payload in "Data" and also (possibly) gateway status in "Stat". Both contain JSON
 type RXData struct {
-	Data []Rxpk `json:"rxpk"`
-	//	Stat statv2 `json:"stat"` // this might be an array or a single value, depending on configuration.
+	Data []Rxpk          `json:"rxpk"`
+	Stat json.RawMessage `json:"stat"` // this might be an array or a single value, depending on configuration.
+}
+
+// Stats returns the status reports in the data. The packet forwarder sends
+// either a single report or an array of reports.
+func (r RXData) Stats() ([]Stat, error) {
+	if len(r.Stat) == 0 || string(r.Stat) == "null" {
+		return nil, nil
+	}
+	var list []Stat
+	if err := json.Unmarshal(r.Stat, &list); err == nil {
+		return list, nil
+	}
+	var single Stat
+	if err := json.Unmarshal(r.Stat, &single); err != nil {
+		return nil, err
+	}
+	return []Stat{single}, nil
 }
 
 // TXData is the struct used when transmitting data to the gateway
@@ -24,6 +24,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/events/gwevents"
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
@@ -287,3 +289,265 @@ func TestEUIFilteringOnEUI(t *testing.T) {
 	}
 
 }
+
+func TestGPSSynchronizedGateways(t *testing.T) {
+	gps := server.NewGPSGateways()
+	context := server.Context{Config: &server.Configuration{}, GPSGateways: &gps}
+	forwarder := NewGenericPacketForwarder(0, gwStorage, &context)
+	eu, _ := band.NewBand(band.EU868Band)
+
+	eui := protocol.EUIFromUint64(0x0102030405060709)
+	rxdata := RXData{Data: []Rxpk{getValidRxPk(base64.StdEncoding.EncodeToString([]byte("data")))}}
+	rxdata.Data[0].Time = ""
+	jsonBuffer, _ := json.Marshal(rxdata)
+	go forwarder.decodeReceivedJSON(GwPacket{GatewayEUI: eui, JSONString: string(jsonBuffer)}, eu, 0)
+	packet := <-forwarder.Output()
+	if gps.Synchronized(eui) {
+		t.Fatal("Gateway without time stamps should not be GPS synchronized")
+	}
+	if !packet.GatewayTime.IsZero() || packet.UplinkTime() != packet.ReceivedAt {
+		t.Fatal("Expected server time for packets without time stamps")
+	}
+
+	rxdata.Data[0].Time = "2017-02-01T23:55:55.233Z"
+	jsonBuffer, _ = json.Marshal(rxdata)
+	go forwarder.decodeReceivedJSON(GwPacket{GatewayEUI: eui, JSONString: string(jsonBuffer)}, eu, 0)
+	packet = <-forwarder.Output()
+	if !gps.Synchronized(eui) {
+		t.Fatal("Gateway with time stamps should be GPS synchronized")
+	}
+	if !packet.UplinkTime().Equal(time.Date(2017, time.February, 1, 23, 55, 55, 233000000, time.UTC)) {
+		t.Fatalf("Expected gateway time for packet but got %v", packet.UplinkTime())
+	}
+
+	// Send a beacon at a fixed time
+	txTime := time.Date(2018, time.March, 1, 12, 0, 0, 0, time.UTC)
+	go forwarder.encodeAndSend(server.GatewayPacket{
+		RawMessage: []byte("beacon"),
+		Gateway:    server.GatewayContext{GatewayEUI: eui, GatewayClock: 1000},
+		TXTime:     txTime,
+		Beacon:     true,
+	})
+	out := <-forwarder.udpOutput
+	txData := TXData{}
+	if err := json.Unmarshal([]byte(out.JSONString), &txData); err != nil {
+		t.Fatal(err)
+	}
+	if txData.Data.Timestamp != 0 || txData.Data.Immediate || txData.Data.Time != "2018-03-01T12:00:00Z" {
+		t.Fatalf("Packet isn't sent at a fixed time: %+v", txData.Data)
+	}
+	if txData.Data.LoraInvPol || !txData.Data.NoCRC || txData.Data.RfPreamble != 10 {
+		t.Fatalf("Incorrect beacon parameters: %+v", txData.Data)
+	}
+}
+
+func TestGatewayBand(t *testing.T) {
+	context := server.Context{Config: &server.Configuration{}}
+	forwarder := NewGenericPacketForwarder(0, gwStorage, &context)
+
+	euEUI := protocol.EUIFromUint64(0x0102030405060711)
+	usEUI := protocol.EUIFromUint64(0x0102030405060712)
+	unknownEUI := protocol.EUIFromUint64(0x0102030405060713)
+	gwStorage.Put(model.Gateway{GatewayEUI: euEUI, Tags: model.NewTags()}, model.SystemUserID)
+	gwStorage.Put(model.Gateway{GatewayEUI: usEUI, Tags: model.NewTags(), Band: band.US915Band, SubBand: 2}, model.SystemUserID)
+
+	// Gateways in different bands can use the same server
+	if plan, _, ok := forwarder.gatewayBand(GwPacket{GatewayEUI: euEUI}); !ok || plan.Name() != "EU 863-870MHz ISM Band" {
+		t.Fatalf("Expected EU868 band for gateway (ok=%t)", ok)
+	}
+	if plan, subBand, ok := forwarder.gatewayBand(GwPacket{GatewayEUI: usEUI}); !ok || plan.Name() != "US 902-928MHz ISM Band" || subBand != 2 {
+		t.Fatalf("Expected US915 band and sub-band 2 for gateway (ok=%t, sub-band=%d)", ok, subBand)
+	}
+	if _, _, ok := forwarder.gatewayBand(GwPacket{GatewayEUI: unknownEUI}); ok {
+		t.Fatal("Expected unknown gateway to be rejected")
+	}
+
+	// Unknown gateways use EU868 when the checks are disabled
+	context.Config.DisableGatewayChecks = true
+	if plan, _, ok := forwarder.gatewayBand(GwPacket{GatewayEUI: unknownEUI}); !ok || plan.Name() != "EU 863-870MHz ISM Band" {
+		t.Fatalf("Expected EU868 band for unknown gateway (ok=%t)", ok)
+	}
+
+	// The band is cached until the gateway is removed from the cache
+	bands := server.NewGatewayBands()
+	context.GatewayBands = &bands
+	if plan, _, ok := forwarder.gatewayBand(GwPacket{GatewayEUI: usEUI}); !ok || plan.Name() != "US 902-928MHz ISM Band" {
+		t.Fatalf("Expected US915 band for gateway (ok=%t)", ok)
+	}
+	gwStorage.Update(model.Gateway{GatewayEUI: usEUI, Tags: model.NewTags(), Band: band.EU868Band}, model.SystemUserID)
+	if plan, _, ok := forwarder.gatewayBand(GwPacket{GatewayEUI: usEUI}); !ok || plan.Name() != "US 902-928MHz ISM Band" {
+		t.Fatalf("Expected cached US915 band for gateway (ok=%t)", ok)
+	}
+	bands.Remove(usEUI)
+	if plan, _, ok := forwarder.gatewayBand(GwPacket{GatewayEUI: usEUI}); !ok || plan.Name() != "EU 863-870MHz ISM Band" {
+		t.Fatalf("Expected updated EU868 band for gateway (ok=%t)", ok)
+	}
+}
+
+func TestDownlinkRXWindow(t *testing.T) {
+	context := server.Context{Config: &server.Configuration{}}
+	forwarder := NewGenericPacketForwarder(0, gwStorage, &context)
+	eu, _ := band.NewBand(band.EU868Band)
+
+	packet := server.GatewayPacket{
+		RawMessage: []byte("downlink"),
+		Radio:      server.RadioContext{Band: eu, Frequency: 868.5, DataRate: "SF9BW125", RX1Delay: 1, RX2Delay: 2},
+		Gateway:    server.GatewayContext{GatewayClock: 1000},
+		ReceivedAt: time.Now(),
+		Deadline:   1,
+	}
+	send := func() Txpk {
+		go forwarder.encodeAndSend(packet)
+		out := <-forwarder.udpOutput
+		txData := TXData{}
+		if err := json.Unmarshal([]byte(out.JSONString), &txData); err != nil {
+			t.Fatal(err)
+		}
+		return txData.Data
+	}
+
+	// The band's default power is used if the power isn't set
+	txpk := send()
+	if txpk.Timestamp != 1001000 || txpk.TxPower != 14 || txpk.Frequency != 868.5 || txpk.LoRaDataRate != "SF9BW125" {
+		t.Fatalf("Unexpected RX1 parameters: %+v", txpk)
+	}
+
+	packet.Radio.RXWindow = band.RX2
+	packet.Radio.Frequency = 869.525
+	packet.Radio.DataRate = "SF12BW125"
+	packet.Radio.TXPower = 27
+	packet.Radio.RFChain = 1
+	packet.Deadline = 2
+	txpk = send()
+	if txpk.Timestamp != 2001000 || txpk.TxPower != 27 || txpk.RFChain != 1 || txpk.Frequency != 869.525 {
+		t.Fatalf("Unexpected RX2 parameters: %+v", txpk)
+	}
+}
+
+func TestTxAck(t *testing.T) {
+	router := pubsub.NewEventRouter(5)
+	txAcks := server.NewTXAckNotifier()
+	context := server.Context{GwEventRouter: &router, Config: &server.Configuration{}, TXAcks: &txAcks}
+	forwarder := NewGenericPacketForwarder(0, gwStorage, &context)
+	eui := protocol.EUIFromUint64(0x0102030405060710)
+	events := router.Subscribe(eui)
+
+	send := func() GwPacket {
+		go forwarder.encodeAndSend(server.GatewayPacket{
+			RawMessage: []byte("downlink"),
+			Gateway:    server.GatewayContext{GatewayEUI: eui},
+			Immediate:  true,
+		})
+		return <-forwarder.udpOutput
+	}
+
+	// Successful downlinks aren't forwarded
+	out := send()
+	forwarder.handleTxAck(GwPacket{Identifier: TxAck, GatewayEUI: eui, Token: out.Token, JSONString: `{"txpk_ack":{"error":"NONE"}}`})
+	out = send()
+	forwarder.handleTxAck(GwPacket{Identifier: TxAck, GatewayEUI: eui, Token: out.Token})
+	select {
+	case ack := <-txAcks.Acks():
+		t.Fatalf("Did not expect ack for successful downlink: %+v", ack)
+	default:
+	}
+
+	// ...but rejected downlinks are
+	out = send()
+	forwarder.handleTxAck(GwPacket{Identifier: TxAck, GatewayEUI: eui, Token: out.Token, JSONString: `{"txpk_ack":{"error":"COLLISION_PACKET"}}`})
+	select {
+	case ack := <-txAcks.Acks():
+		if ack.Error != TxAckCollisionPacket || string(ack.Packet.RawMessage) != "downlink" {
+			t.Fatalf("Unexpected ack: %+v", ack)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Expected ack for rejected downlink")
+	}
+	select {
+	case ev := <-events:
+		if ev.(gwevents.GwEvent).Type != "TxFailed" {
+			t.Fatalf("Expected TxFailed event but got %+v", ev)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Expected gateway event")
+	}
+
+	// Unknown tokens are ignored
+	forwarder.handleTxAck(GwPacket{Identifier: TxAck, GatewayEUI: eui, Token: out.Token, JSONString: `{"txpk_ack":{"error":"TOO_LATE"}}`})
+	select {
+	case ack := <-txAcks.Acks():
+		t.Fatalf("Did not expect ack for unknown token: %+v", ack)
+	default:
+	}
+}
+
+func TestGatewayStatus(t *testing.T) {
+	router := pubsub.NewEventRouter(5)
+	context := server.Context{GwEventRouter: &router, Config: &server.Configuration{}}
+	forwarder := NewGenericPacketForwarder(0, gwStorage, &context)
+	eu, _ := band.NewBand(band.EU868Band)
+
+	eui := protocol.EUIFromUint64(0x0102030405060714)
+	gwStorage.Put(model.Gateway{GatewayEUI: eui, Tags: model.NewTags(), Altitude: 12}, model.SystemUserID)
+	events := router.Subscribe(eui)
+
+	// A single report without a location
+	forwarder.decodeReceivedJSON(GwPacket{GatewayEUI: eui, JSONString: `{"stat":{"time":"2018-03-01 12:00:00 GMT","rxnb":4,"rxok":3,"rxfw":2,"ackr":100.0,"dwnb":1,"txnb":1}}`}, eu, 0)
+	reports, _ := gwStorage.GetStatus(eui)
+	if len(reports) != 1 {
+		t.Fatalf("Expected 1 report but got %d", len(reports))
+	}
+	if reports[0].RXReceived != 4 || reports[0].RXOK != 3 || reports[0].RXForwarded != 2 || reports[0].ACKRatio != 100 ||
+		reports[0].DownlinkReceived != 1 || reports[0].TXEmitted != 1 || reports[0].HasLocation {
+		t.Fatalf("Unexpected report: %+v", reports[0])
+	}
+	if !reports[0].GatewayTime.Equal(time.Date(2018, time.March, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected gateway time: %v", reports[0].GatewayTime)
+	}
+	select {
+	case ev := <-events:
+		if ev.(gwevents.GwEvent).Type != "Status" {
+			t.Fatalf("Expected Status event but got %+v", ev)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Expected gateway event")
+	}
+
+	// Reports with a GPS position update the gateway's location but a 0,0
+	// position is ignored.
+	forwarder.decodeReceivedJSON(GwPacket{GatewayEUI: eui, JSONString: `{"stat":[{"lati":0,"long":0,"rxnb":1},{"lati":63.43,"long":10.39,"rxnb":2}]}`}, eu, 0)
+	reports, _ = gwStorage.GetStatus(eui)
+	if len(reports) != 3 || reports[1].HasLocation || !reports[2].HasLocation || reports[2].RXReceived != 2 {
+		t.Fatalf("Unexpected reports: %+v", reports)
+	}
+	gw, err := gwStorage.Get(eui, model.SystemUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gw.Latitude != 63.43 || gw.Longitude != 10.39 || gw.Altitude != 12 {
+		t.Fatalf("Location isn't updated: %+v", gw)
+	}
+
+	// Small changes are GPS jitter and are ignored
+	forwarder.decodeReceivedJSON(GwPacket{GatewayEUI: eui, JSONString: `{"stat":{"lati":63.43004,"long":10.39,"alti":15}}`}, eu, 0)
+	if gw, _ = gwStorage.Get(eui, model.SystemUserID); gw.Latitude != 63.43 || gw.Altitude != 12 {
+		t.Fatalf("Location should not change: %+v", gw)
+	}
+	forwarder.decodeReceivedJSON(GwPacket{GatewayEUI: eui, JSONString: `{"stat":{"lati":63.44,"long":10.39,"alti":15}}`}, eu, 0)
+	if gw, _ = gwStorage.Get(eui, model.SystemUserID); gw.Latitude != 63.44 || gw.Altitude != 15 {
+		t.Fatalf("Location isn't updated: %+v", gw)
+	}
+
+	// Invalid reports are ignored
+	forwarder.decodeReceivedJSON(GwPacket{GatewayEUI: eui, JSONString: `{"stat":"invalid"}`}, eu, 0)
+	if reports, _ = gwStorage.GetStatus(eui); len(reports) != 5 {
+		t.Fatal("Invalid report should be ignored")
+	}
+
+	// Reports from unknown gateways aren't stored
+	unknownEUI := protocol.EUIFromUint64(0x0102030405060715)
+	forwarder.decodeReceivedJSON(GwPacket{GatewayEUI: unknownEUI, JSONString: `{"stat":{"rxnb":1}}`}, eu, 0)
+	if reports, _ = gwStorage.GetStatus(unknownEUI); len(reports) != 0 {
+		t.Fatal("Reports from unknown gateways should be ignored")
+	}
+}
@@ -0,0 +1,550 @@
+package gateway
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/events/gwevents"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/monitoring"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+	"golang.org/x/net/websocket"
+)
+
+// Endpoints for the Basics Station LNS protocol. The stations query the
+// router info endpoint for the traffic endpoint and connect to it.
+const (
+	stationInfoPath    = "/router-info"
+	stationTrafficPath = "/traffic/"
+)
+
+// stationMuxsID is the ID6 the server reports to the stations
+const stationMuxsID = "::0"
+
+// stationConn is a connected station
+type stationConn struct {
+	ws      *websocket.Conn
+	gateway model.Gateway
+	plan    band.FrequencyPlan
+	host    string
+	port    int
+}
+
+// BasicsStation is the forwarder for gateways running the LoRa Basics Station.
+// The stations connect to the server through websockets and use the LNS
+// protocol. The server uses TLS if the certificate and key is set in the
+// configuration and the stations must send the gateway's station token in the
+// Authorization header.
+type BasicsStation struct {
+	input      chan server.GatewayPacket // Input to the gateways, ie data that should be sent to the gateways
+	output     chan server.GatewayPacket // Output from the gateways, ie data received from the gateways
+	serverPort int                       // Server port to listen on
+	terminate  chan bool                 // Closed when the forwarder stops
+	storage    storage.GatewayStorage
+	context    *server.Context
+	srv        *http.Server
+	mutex      *sync.Mutex                   // Mutex for the stations map and the stopped flag
+	stations   map[protocol.EUI]*stationConn // Connected stations
+	stopped    bool                          // Set when the forwarder stops. No new stations are accepted
+	handlers   *sync.WaitGroup               // Running connection handlers
+	sent       *sentPackets                  // Packets waiting for dntxed
+}
+
+// NewBasicsStation creates a new Basics Station forwarder listening on a
+// port.
+func NewBasicsStation(serverPort int, storage storage.GatewayStorage, context *server.Context) *BasicsStation {
+	ret := &BasicsStation{
+		input:      make(chan server.GatewayPacket),
+		output:     make(chan server.GatewayPacket),
+		serverPort: serverPort,
+		terminate:  make(chan bool),
+		storage:    storage,
+		context:    context,
+		mutex:      &sync.Mutex{},
+		stations:   make(map[protocol.EUI]*stationConn),
+		handlers:   &sync.WaitGroup{},
+		sent:       newSentPackets(),
+	}
+	mux := http.NewServeMux()
+	// The default websocket handler rejects requests without an Origin
+	// header. The stations don't send one.
+	mux.Handle(stationInfoPath, websocket.Server{Handler: ret.routerInfo})
+	mux.Handle(stationTrafficPath, ret.authenticate(websocket.Server{Handler: ret.traffic}))
+	ret.srv = &http.Server{Handler: mux}
+	return ret
+}
+
+// Start launches the forwarder. It does not return until the forwarder is
+// stopped.
+func (s *BasicsStation) Start() {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.serverPort))
+	if err != nil {
+		logging.Error("Unable to listen on TCP port %d: %v", s.serverPort, err)
+		return
+	}
+	logging.Info("Basics Station forwarder listening on port %d", s.serverPort)
+	go func() {
+		var err error
+		if s.useTLS() {
+			err = s.srv.ServeTLS(listener, s.context.Config.TLSCertFile, s.context.Config.TLSKeyFile)
+		} else {
+			err = s.srv.Serve(listener)
+		}
+		if err != http.ErrServerClosed {
+			logging.Error("Basics Station server returned error: %v", err)
+		}
+	}()
+	s.mainLoop()
+}
+
+// Stop stops the forwarder and closes the channels
+func (s *BasicsStation) Stop() {
+	close(s.input)
+}
+
+// Output returns the output channel for the forwarder. A message will be sent
+// on this channel every time a station has sent an uplink.
+func (s *BasicsStation) Output() <-chan server.GatewayPacket {
+	return s.output
+}
+
+// Input returns the input channel for the forwarder. Messages sent on this
+// channel are forwarded to the station.
+func (s *BasicsStation) Input() chan<- server.GatewayPacket {
+	return s.input
+}
+
+func (s *BasicsStation) useTLS() bool {
+	return s.context.Config.TLSCertFile != "" && s.context.Config.TLSKeyFile != ""
+}
+
+// mainLoop sends the downlinks to the stations until the input channel is
+// closed.
+func (s *BasicsStation) mainLoop() {
+	for val := range s.input {
+		val.SectionTimer.Begin(monitoring.TimeGatewaySend)
+		s.sendDownlink(val)
+		val.OutTimer.End()
+		val.SectionTimer.End()
+	}
+	logging.Debug("Input channel for Basics Station forwarder closed. Terminating")
+	s.mutex.Lock()
+	s.stopped = true
+	for _, conn := range s.stations {
+		conn.ws.Close()
+	}
+	s.mutex.Unlock()
+	close(s.terminate)
+	s.srv.Close()
+	s.handlers.Wait()
+	close(s.output)
+}
+
+// authorized checks the station token for the gateway. The stations send the
+// contents of their key file as the Authorization header. Stations for
+// unknown gateways are only accepted when the gateway checks are disabled.
+func (s *BasicsStation) authorized(r *http.Request, eui protocol.EUI) bool {
+	gw, err := s.storage.Get(eui, model.SystemUserID)
+	if err == storage.ErrNotFound {
+		return s.context.Config.DisableGatewayChecks
+	}
+	if err != nil {
+		logging.Warning("Unable to look up gateway with EUI %s: %v", eui, err)
+		return false
+	}
+	auth := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if gw.StationToken == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(gw.StationToken)) != 1 {
+		logging.Warning("Invalid token from Basics Station %s at %s", eui, r.RemoteAddr)
+		return false
+	}
+	return true
+}
+
+// authenticate checks the station token for the gateway in the traffic path
+// before the websocket handshake.
+func (s *BasicsStation) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eui, err := protocol.EUIFromString(strings.TrimPrefix(r.URL.Path, stationTrafficPath))
+		if err != nil {
+			http.Error(w, "Invalid EUI", http.StatusBadRequest)
+			return
+		}
+		if !s.authorized(r, eui) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteAddr returns the host and port the station connected from
+func remoteAddr(r *http.Request) (string, int) {
+	host, portStr, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// routerInfo handles the discovery requests from the stations. The response
+// is the URI for the traffic endpoint.
+func (s *BasicsStation) routerInfo(ws *websocket.Conn) {
+	defer ws.Close()
+	req := stationRouterInfoRequest{}
+	if err := websocket.JSON.Receive(ws, &req); err != nil {
+		logging.Info("Unable to read router info request: %v", err)
+		return
+	}
+	resp := stationRouterInfo{Router: strings.Trim(string(req.Router), "\"")}
+	host, _ := remoteAddr(ws.Request())
+	eui, err := parseStationID(req.Router)
+	switch {
+	case err != nil:
+		resp.Error = err.Error()
+	case !s.authorized(ws.Request(), eui):
+		resp.Error = "unauthorized"
+	default:
+		if _, _, ok := lookupGateway(s.storage, s.context, eui, host); !ok {
+			resp.Error = fmt.Sprintf("unknown gateway: %s", eui)
+			break
+		}
+		scheme := "ws"
+		if s.useTLS() {
+			scheme = "wss"
+		}
+		resp.Router = stationID6(eui)
+		resp.Muxs = stationMuxsID
+		resp.URI = fmt.Sprintf("%s://%s%s%s", scheme, ws.Request().Host, stationTrafficPath, eui)
+	}
+	if err := websocket.JSON.Send(ws, resp); err != nil {
+		logging.Info("Unable to send router info to %s: %v", host, err)
+	}
+}
+
+// addStation registers a connected station. An existing connection for the
+// station is closed. Returns false if the forwarder is stopping.
+func (s *BasicsStation) addStation(conn *stationConn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return false
+	}
+	if old, ok := s.stations[conn.gateway.GatewayEUI]; ok {
+		logging.Info("Basics Station %s reconnected. Closing old connection", conn.gateway.GatewayEUI)
+		old.ws.Close()
+	}
+	s.stations[conn.gateway.GatewayEUI] = conn
+	s.handlers.Add(1)
+	return true
+}
+
+// removeStation removes the station if it is the current connection.
+func (s *BasicsStation) removeStation(conn *stationConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stations[conn.gateway.GatewayEUI] == conn {
+		delete(s.stations, conn.gateway.GatewayEUI)
+	}
+	s.handlers.Done()
+}
+
+// HasGateway returns true if the station is connected.
+func (s *BasicsStation) HasGateway(eui protocol.EUI) bool {
+	return s.station(eui) != nil
+}
+
+func (s *BasicsStation) station(eui protocol.EUI) *stationConn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stations[eui]
+}
+
+// traffic handles the traffic endpoint for a station. The gateway EUI is the
+// last part of the path.
+func (s *BasicsStation) traffic(ws *websocket.Conn) {
+	defer ws.Close()
+	eui, err := protocol.EUIFromString(strings.TrimPrefix(ws.Request().URL.Path, stationTrafficPath))
+	if err != nil {
+		logging.Info("Invalid EUI in Basics Station path %s", ws.Request().URL.Path)
+		return
+	}
+	conn := &stationConn{ws: ws}
+	conn.host, conn.port = remoteAddr(ws.Request())
+	var ok bool
+	if conn.gateway, conn.plan, ok = lookupGateway(s.storage, s.context, eui, conn.host); !ok {
+		return
+	}
+	if !s.addStation(conn) {
+		return
+	}
+	defer s.removeStation(conn)
+	logging.Info("Basics Station %s connected from %s", eui, conn.host)
+
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			logging.Info("Basics Station %s disconnected: %v", eui, err)
+			s.context.GwEventRouter.Publish(eui, gwevents.NewInactive())
+			return
+		}
+		s.handleMessage(conn, []byte(msg))
+	}
+}
+
+// handleMessage handles a message from a station
+func (s *BasicsStation) handleMessage(conn *stationConn, msg []byte) {
+	eui := conn.gateway.GatewayEUI
+	header := stationMessage{}
+	if err := json.Unmarshal(msg, &header); err != nil {
+		logging.Info("Unable to unmarshal message from Basics Station %s: %v (json=%s)", eui, err, string(msg))
+		return
+	}
+	switch header.MsgType {
+	case stationVersionMsg:
+		version := stationVersion{}
+		if err := json.Unmarshal(msg, &version); err != nil {
+			logging.Info("Unable to unmarshal version from Basics Station %s: %v", eui, err)
+			return
+		}
+		logging.Debug("Basics Station %s is running %s (model=%s, protocol=%d)", eui, version.Station, version.Model, version.Protocol)
+		s.sendRouterConfig(conn)
+		s.context.GwEventRouter.Publish(eui, gwevents.NewKeepAlive())
+
+	case stationUplinkMsg:
+		uplink := stationUplink{}
+		if err := json.Unmarshal(msg, &uplink); err != nil {
+			logging.Info("Unable to unmarshal uplink from Basics Station %s: %v (json=%s)", eui, err, string(msg))
+			return
+		}
+		buf, err := uplink.phyPayload()
+		if err != nil {
+			logging.Info("Invalid uplink from Basics Station %s: %v (json=%s)", eui, err, string(msg))
+			return
+		}
+		s.context.GwEventRouter.Publish(eui, gwevents.NewRx(string(msg)))
+		s.forwardUplink(conn, buf, uplink.DR, uplink.Freq, uplink.UpInfo)
+
+	case stationJoinRequestMsg:
+		joinRequest := stationJoinRequest{}
+		if err := json.Unmarshal(msg, &joinRequest); err != nil {
+			logging.Info("Unable to unmarshal join request from Basics Station %s: %v (json=%s)", eui, err, string(msg))
+			return
+		}
+		buf, err := joinRequest.phyPayload()
+		if err != nil {
+			logging.Info("Invalid join request from Basics Station %s: %v (json=%s)", eui, err, string(msg))
+			return
+		}
+		s.context.GwEventRouter.Publish(eui, gwevents.NewRx(string(msg)))
+		s.forwardUplink(conn, buf, joinRequest.DR, joinRequest.Freq, joinRequest.UpInfo)
+
+	case stationTxConfirmMsg:
+		confirmation := stationTxConfirmation{}
+		if err := json.Unmarshal(msg, &confirmation); err != nil {
+			logging.Info("Unable to unmarshal dntxed from Basics Station %s: %v (json=%s)", eui, err, string(msg))
+			return
+		}
+		if _, found := s.sent.remove(eui, confirmation.DIID); !found {
+			logging.Info("Unknown diid %d in dntxed from Basics Station %s", confirmation.DIID, eui)
+		}
+		s.context.GwEventRouter.Publish(eui, gwevents.NewTxConfirmed(string(msg)))
+
+	case stationTimeSyncMsg:
+		timeSync := stationTimeSync{}
+		if err := json.Unmarshal(msg, &timeSync); err != nil {
+			logging.Info("Unable to unmarshal timesync from Basics Station %s: %v", eui, err)
+			return
+		}
+		timeSync.GPSTime = int64(protocol.GPSTime(time.Now()) / time.Microsecond)
+		s.send(conn, timeSync)
+
+	default:
+		logging.Debug("Ignoring %s message from Basics Station %s", header.MsgType, eui)
+	}
+}
+
+// send marshals and sends a message to the station. Returns the JSON sent.
+func (s *BasicsStation) send(conn *stationConn, msg interface{}) (string, error) {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	if err := websocket.Message.Send(conn.ws, string(buf)); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// sendRouterConfig sends the channel configuration to the station
+func (s *BasicsStation) sendRouterConfig(conn *stationConn) {
+	config, err := newRouterConfig(conn.gateway, conn.plan)
+	if err != nil {
+		logging.Warning("Unable to create router config for Basics Station %s: %v", conn.gateway.GatewayEUI, err)
+		return
+	}
+	if _, err := s.send(conn, config); err != nil {
+		logging.Warning("Unable to send router config to Basics Station %s: %v", conn.gateway.GatewayEUI, err)
+	}
+}
+
+// forwardUplink forwards an uplink from the station to the output channel.
+func (s *BasicsStation) forwardUplink(conn *stationConn, buf []byte, dataRate uint8, freq uint32, info stationUpInfo) {
+	incomingTimer := monitoring.NewTimer()
+	incomingTimer.Begin(monitoring.TimeIncoming)
+
+	timer := monitoring.NewTimer()
+	timer.Begin(monitoring.TimeGatewayReceive)
+
+	eui := conn.gateway.GatewayEUI
+	dr, err := band.DataRateIdentifier(conn.plan, dataRate)
+	if err != nil {
+		logging.Info("Invalid data rate from Basics Station %s: %v", eui, err)
+		return
+	}
+	gwPacket := server.GatewayPacket{
+		RawMessage: buf,
+		Radio: server.RadioContext{
+			Frequency: stationMHz(freq),
+			DataRate:  dr,
+			Band:      conn.plan,
+			RSSI:      int32(info.RSSI),
+			SNR:       info.SNR,
+		},
+		Gateway: server.GatewayContext{
+			GatewayEUI:   eui,
+			GatewayHost:  conn.host,
+			GatewayPort:  conn.port,
+			GatewayClock: uint32(info.XTime),
+			XTime:        info.XTime,
+			RCtx:         info.RCtx,
+			SubBand:      conn.gateway.SubBand,
+		},
+		ReceivedAt:   time.Now(),
+		SectionTimer: timer,
+		InTimer:      incomingTimer,
+	}
+	s.context.Gateways.Update(gwPacket.Gateway, conn.plan)
+	if info.GPSTime != 0 {
+		// The station only reports the GPS time when it has a GPS fix
+		s.context.GPSGateways.Update(gwPacket.Gateway, conn.plan)
+		gwPacket.GatewayTime = protocol.TimeFromGPS(time.Duration(info.GPSTime) * time.Microsecond)
+	}
+	gwPacket.SectionTimer.End()
+	monitoring.GatewayIn.Increment()
+	monitoring.Stopwatch(monitoring.GatewayChannelOut, func() {
+		select {
+		case s.output <- gwPacket:
+		case <-s.terminate:
+		}
+	})
+}
+
+// newStationDownlink creates the dnmsg message for a downlink. Class A
+// downlinks are sent in the receive window set by the scheduler.
+func newStationDownlink(packet server.GatewayPacket, diid uint16) (stationDownlink, error) {
+	plan := packet.Radio.Band
+	if plan == nil {
+		return stationDownlink{}, fmt.Errorf("no band for downlink to gateway %s", packet.Gateway.GatewayEUI)
+	}
+	dataRate, err := plan.GetDataRate(packet.Radio.DataRate)
+	if err != nil {
+		return stationDownlink{}, err
+	}
+	freq := stationHz(packet.Radio.Frequency)
+	ret := stationDownlink{
+		MsgType: stationDownlinkMsg,
+		DevEUI:  protocol.EUI{}.String(),
+		DIID:    diid,
+		PDU:     hex.EncodeToString(packet.RawMessage),
+		RCtx:    packet.Gateway.RCtx,
+	}
+	if packet.Fallback != nil {
+		ret.DevEUI = packet.Fallback.DeviceEUI.String()
+	}
+	switch {
+	case !packet.TXTime.IsZero():
+		ret.DeviceClass = stationClassB
+		ret.DR = &dataRate
+		ret.Freq = freq
+		ret.GPSTime = int64(protocol.GPSTime(packet.TXTime) / time.Microsecond)
+	case packet.Immediate:
+		ret.DeviceClass = stationClassC
+		ret.RX2DR = &dataRate
+		ret.RX2Freq = freq
+		ret.XTime = packet.Gateway.XTime
+	default:
+		// The station schedules RX2 one second after RX1
+		ret.DeviceClass = stationClassA
+		ret.XTime = packet.Gateway.XTime
+		ret.RxDelay = packet.Radio.RX1Delay
+		if packet.Radio.RXWindow == band.RX2 {
+			ret.RX2DR = &dataRate
+			ret.RX2Freq = freq
+		} else {
+			ret.RX1DR = &dataRate
+			ret.RX1Freq = freq
+		}
+	}
+	return ret, nil
+}
+
+// sendDownlink sends a downlink to the station. The stations send their own
+// beacons so beacons are dropped. Downlinks to stations that aren't connected
+// are reported as rejected.
+func (s *BasicsStation) sendDownlink(packet server.GatewayPacket) {
+	eui := packet.Gateway.GatewayEUI
+	if packet.Beacon {
+		return
+	}
+	conn := s.station(eui)
+	if conn == nil {
+		logging.Warning("Basics Station %s isn't connected. Can't send downlink", eui)
+		monitoring.DownlinkFailed.Increment()
+		s.context.TXAcks.Notify(server.TXAck{Packet: packet, Error: stationNotConnected})
+		return
+	}
+	diid := uint16(rand.Int() & 0xFFFF)
+	msg, err := newStationDownlink(packet, diid)
+	if err != nil {
+		logging.Warning("Unable to create downlink for Basics Station %s: %v", eui, err)
+		return
+	}
+	s.sent.add(eui, diid, packet)
+	data, err := s.send(conn, msg)
+	if err != nil {
+		logging.Warning("Unable to send downlink to Basics Station %s: %v", eui, err)
+		return
+	}
+	s.context.GwEventRouter.Publish(eui, gwevents.NewTx(data))
+	monitoring.GatewayOut.Increment()
+	checkDeadline(packet)
+}
@@ -0,0 +1,213 @@
+package gateway
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// stationRegion is the region name and frequency range the station uses
+// for a band.
+type stationRegion struct {
+	name    string
+	minFreq uint32
+	maxFreq uint32
+}
+
+var stationRegions = map[band.FrequencyBandType]stationRegion{
+	band.EU868Band:  {"EU863", 863000000, 870000000},
+	band.US915Band:  {"US902", 902000000, 928000000},
+	band.CN779Band:  {"CN779", 779000000, 787000000},
+	band.EU433Band:  {"EU433", 433050000, 434790000},
+	band.AU915Band:  {"AU915", 915000000, 928000000},
+	band.CN470Band:  {"CN470", 470000000, 510000000},
+	band.AS923Band:  {"AS923-1", 915000000, 928000000},
+	band.AS923Band2: {"AS923-2", 920000000, 923000000},
+	band.AS923Band3: {"AS923-3", 915000000, 921000000},
+	band.AS923Band4: {"AS923-4", 917000000, 920000000},
+	band.KR920Band:  {"KR920", 920900000, 923300000},
+	band.IN865Band:  {"IN865", 865000000, 867000000},
+}
+
+// The SX1301 has two radios and eight multi-SF channels. The channels must
+// be within 400kHz of the radio's center frequency.
+const (
+	sx1301Radios   = 2
+	sx1301Channels = 8
+	sx1301MaxIF    = 400000
+)
+
+// stationHz converts a frequency in MHz to Hz. The frequency is rounded to
+// 100Hz to get rid of float32 rounding errors.
+func stationHz(freq float32) uint32 {
+	return uint32(math.Round(float64(freq)*1e4)) * 100
+}
+
+// stationMHz converts a frequency in Hz to MHz
+func stationMHz(freq uint32) float32 {
+	return float32(float64(freq) / 1e6)
+}
+
+// newRouterConfig creates the router_config message for a gateway. The
+// channels are set up from the gateway's band and sub-band.
+func newRouterConfig(gw model.Gateway, plan band.FrequencyPlan) (stationRouterConfig, error) {
+	region, ok := stationRegions[gw.Band]
+	if !ok {
+		return stationRouterConfig{}, fmt.Errorf("no station region for band %s", gw.Band)
+	}
+	ret := stationRouterConfig{
+		MsgType:   stationRouterConfigMsg,
+		Region:    region.name,
+		HWSpec:    "sx1301/1",
+		FreqRange: []uint32{region.minFreq, region.maxFreq},
+	}
+
+	// Bands with a fixed channel plan use DR8 and up for downlinks only
+	for dr := uint8(0); dr < 16; dr++ {
+		encoding, err := plan.Encoding(dr)
+		switch {
+		case err != nil:
+			ret.DRs = append(ret.DRs, [3]int{-1, 0, 0})
+		case encoding.Modulation == band.FSK:
+			ret.DRs = append(ret.DRs, [3]int{0, 0, 0})
+		default:
+			dnOnly := 0
+			if gw.Band.SubBands() > 0 && dr >= 8 {
+				dnOnly = 1
+			}
+			ret.DRs = append(ret.DRs, [3]int{int(encoding.SpreadFactor), int(encoding.Bandwidth), dnOnly})
+		}
+	}
+
+	channels, wideChannel := uplinkChannels(gw, plan)
+	ret.SX1301Conf = []map[string]interface{}{newSX1301Conf(channels, wideChannel)}
+
+	config := plan.Configuration()
+	ret.Beaconing = &stationBeaconing{
+		DR: config.BeaconDataRate,
+		// The time and CRC is followed by the info descriptor, coordinates,
+		// the gateway specific RFU bytes and a CRC [15.2]
+		Layout: [3]int{
+			config.BeaconCommonRFU,
+			config.BeaconCommonRFU + 6,
+			config.BeaconCommonRFU + 6 + 7 + config.BeaconGatewayRFU + 2},
+	}
+	for _, f := range config.BeaconFrequencies {
+		ret.Beaconing.Freqs = append(ret.Beaconing.Freqs, stationHz(f))
+	}
+	if len(ret.Beaconing.Freqs) == 0 {
+		ret.Beaconing.Freqs = []uint32{stationHz(config.RX2Frequency)}
+	}
+	return ret, nil
+}
+
+// uplinkChannels returns the uplink channels for the gateway and the 500kHz
+// channel. Bands with a fixed channel plan use the channels in the gateway's
+// sub-band and the 500kHz channel in the sub-band if there is one. Sub-band 0
+// is the same as the first sub-band. The other bands use the default channels
+// and no 500kHz channel (ie 0).
+func uplinkChannels(gw model.Gateway, plan band.FrequencyPlan) ([]uint32, uint32) {
+	subBand := uint32(gw.SubBand)
+	if subBand > 0 {
+		subBand--
+	}
+	var first, step, std uint32
+	switch gw.Band {
+	case band.US915Band:
+		// [7.2.2]
+		first, step, std = 902300000, 200000, 903000000
+	case band.AU915Band:
+		// [2.5.2/Regional Parameters]
+		first, step, std = 915200000, 200000, 915900000
+	case band.CN470Band:
+		// [2.6.2/Regional Parameters]
+		first, step = 470300000, 200000
+	default:
+		config := plan.Configuration()
+		var ret []uint32
+		for _, f := range config.MandatoryEndDeviceChannels {
+			ret = append(ret, stationHz(f))
+		}
+		for _, f := range config.AdditionalChannels {
+			ret = append(ret, stationHz(f))
+		}
+		return ret, 0
+	}
+	var ret []uint32
+	for i := uint32(0); i < sx1301Channels; i++ {
+		ret = append(ret, first+(subBand*sx1301Channels+i)*step)
+	}
+	if std == 0 {
+		return ret, 0
+	}
+	// The 500kHz channels are spaced 1.6MHz apart
+	return ret, std + subBand*1600000
+}
+
+// newSX1301Conf creates the SX1301 configuration for the channels. The
+// channels are split between the two radios and channels that don't fit are
+// left out. The 500kHz channel (if not 0) is assigned to the radio that covers
+// it.
+func newSX1301Conf(channels []uint32, wideChannel uint32) map[string]interface{} {
+	sorted := append([]uint32{}, channels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	ret := make(map[string]interface{})
+	var centers []uint32
+	n := 0
+	for radio := 0; radio < sx1301Radios; radio++ {
+		if n >= len(sorted) || n >= sx1301Channels {
+			ret[fmt.Sprintf("radio_%d", radio)] = sx1301Radio{}
+			continue
+		}
+		last := n
+		for last+1 < len(sorted) && last+1 < sx1301Channels && sorted[last+1]-sorted[n] <= 2*sx1301MaxIF {
+			last++
+		}
+		center := (sorted[n] + sorted[last]) / 2
+		centers = append(centers, center)
+		ret[fmt.Sprintf("radio_%d", radio)] = sx1301Radio{Enable: true, Freq: center}
+		for ; n <= last; n++ {
+			ret[fmt.Sprintf("chan_multiSF_%d", n)] = sx1301Channel{Enable: true, Radio: radio, IF: int32(sorted[n]) - int32(center)}
+		}
+	}
+	if n < len(sorted) {
+		logging.Warning("Only %d of %d uplink channels fit the SX1301 radios", n, len(sorted))
+	}
+	for i := n; i < sx1301Channels; i++ {
+		ret[fmt.Sprintf("chan_multiSF_%d", i)] = sx1301Channel{}
+	}
+
+	ret["chan_Lora_std"] = sx1301Channel{}
+	for radio, center := range centers {
+		if wideChannel == 0 {
+			break
+		}
+		offset := int32(wideChannel) - int32(center)
+		if offset >= -sx1301MaxIF && offset <= sx1301MaxIF {
+			ret["chan_Lora_std"] = sx1301Channel{Enable: true, Radio: radio, IF: offset, Bandwidth: 500000, SpreadFactor: 8}
+			break
+		}
+	}
+	ret["chan_FSK"] = sx1301Channel{}
+	return ret
+}
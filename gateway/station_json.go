@@ -0,0 +1,325 @@
+package gateway
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// Message types for the Basics Station LNS protocol
+const (
+	stationVersionMsg      = "version"
+	stationRouterConfigMsg = "router_config"
+	stationUplinkMsg       = "updf"
+	stationJoinRequestMsg  = "jreq"
+	stationDownlinkMsg     = "dnmsg"
+	stationTxConfirmMsg    = "dntxed"
+	stationTimeSyncMsg     = "timesync"
+)
+
+// Device classes for dnmsg messages
+const (
+	stationClassA uint8 = 0
+	stationClassB uint8 = 1
+	stationClassC uint8 = 2
+)
+
+// stationNotConnected is the error reported for downlinks to stations that
+// aren't connected.
+const stationNotConnected = "NOT_CONNECTED"
+
+// stationMessage holds the message type field common to all of the messages.
+type stationMessage struct {
+	MsgType string `json:"msgtype"`
+}
+
+// stationRouterInfoRequest is sent by the station to the discovery endpoint.
+// The router ID is an integer, an EUI or an ID6 string.
+type stationRouterInfoRequest struct {
+	Router json.RawMessage `json:"router"`
+}
+
+// stationRouterInfo is the response to the router info request. The station
+// connects to the URI if there's no error.
+type stationRouterInfo struct {
+	Router string `json:"router"`
+	Muxs   string `json:"muxs,omitempty"`
+	URI    string `json:"uri,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// stationVersion is the first message the station sends on the traffic
+// endpoint.
+type stationVersion struct {
+	Station  string `json:"station"`
+	Firmware string `json:"firmware"`
+	Package  string `json:"package"`
+	Model    string `json:"model"`
+	Protocol int    `json:"protocol"`
+	Features string `json:"features"`
+}
+
+// stationUpInfo holds the radio metadata for uplinks
+type stationUpInfo struct {
+	RCtx    int64   `json:"rctx"`
+	XTime   int64   `json:"xtime"`
+	GPSTime int64   `json:"gpstime"` // Microseconds since the GPS epoch. 0 if the station has no GPS time
+	RSSI    float32 `json:"rssi"`
+	SNR     float32 `json:"snr"`
+	RxTime  float64 `json:"rxtime"`
+}
+
+// stationUplink is an uplink data frame (updf). The station sends the frame
+// fields rather than the raw frame.
+type stationUplink struct {
+	MHdr       uint8         `json:"MHdr"`
+	DevAddr    int32         `json:"DevAddr"`
+	FCtrl      uint8         `json:"FCtrl"`
+	FCnt       uint16        `json:"FCnt"`
+	FOpts      string        `json:"FOpts"`
+	FPort      int           `json:"FPort"` // -1 if there's no port
+	FRMPayload string        `json:"FRMPayload"`
+	MIC        int32         `json:"MIC"`
+	DR         uint8         `json:"DR"`
+	Freq       uint32        `json:"Freq"`
+	UpInfo     stationUpInfo `json:"upinfo"`
+}
+
+// phyPayload returns the PHYPayload for the uplink frame
+func (u stationUplink) phyPayload() ([]byte, error) {
+	fopts, err := hex.DecodeString(u.FOpts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FOpts: %v", err)
+	}
+	payload, err := hex.DecodeString(u.FRMPayload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FRMPayload: %v", err)
+	}
+	if u.FPort < 0 && len(payload) > 0 {
+		return nil, errors.New("payload without port")
+	}
+	buf := make([]byte, 0, 13+len(fopts)+len(payload))
+	buf = append(buf, u.MHdr)
+	buf = appendUint32(buf, uint32(u.DevAddr))
+	buf = append(buf, u.FCtrl)
+	buf = appendUint16(buf, u.FCnt)
+	buf = append(buf, fopts...)
+	if u.FPort >= 0 {
+		buf = append(buf, uint8(u.FPort))
+		buf = append(buf, payload...)
+	}
+	return appendUint32(buf, uint32(u.MIC)), nil
+}
+
+// stationJoinRequest is a join request (jreq)
+type stationJoinRequest struct {
+	MHdr     uint8         `json:"MHdr"`
+	JoinEUI  string        `json:"JoinEui"`
+	DevEUI   string        `json:"DevEui"`
+	DevNonce uint16        `json:"DevNonce"`
+	MIC      int32         `json:"MIC"`
+	DR       uint8         `json:"DR"`
+	Freq     uint32        `json:"Freq"`
+	UpInfo   stationUpInfo `json:"upinfo"`
+}
+
+// phyPayload returns the PHYPayload for the join request. The EUIs are sent
+// in little endian order [6.2.4].
+func (j stationJoinRequest) phyPayload() ([]byte, error) {
+	joinEUI, err := protocol.EUIFromString(j.JoinEUI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JoinEui: %v", err)
+	}
+	devEUI, err := protocol.EUIFromString(j.DevEUI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DevEui: %v", err)
+	}
+	buf := make([]byte, 0, 23)
+	buf = append(buf, j.MHdr)
+	buf = appendUint64(buf, joinEUI.ToUint64())
+	buf = appendUint64(buf, devEUI.ToUint64())
+	buf = appendUint16(buf, j.DevNonce)
+	return appendUint32(buf, uint32(j.MIC)), nil
+}
+
+// stationDownlink is a downlink message (dnmsg). Class A downlinks are
+// scheduled relative to the uplink's xtime, class B downlinks at a GPS time
+// and class C downlinks are sent immediately.
+type stationDownlink struct {
+	MsgType     string `json:"msgtype"`
+	DevEUI      string `json:"DevEui"`
+	DeviceClass uint8  `json:"dC"`
+	DIID        uint16 `json:"diid"`
+	PDU         string `json:"pdu"`
+	Priority    uint8  `json:"priority"`
+	RxDelay     uint8  `json:"RxDelay,omitempty"`
+	RX1DR       *uint8 `json:"RX1DR,omitempty"`
+	RX1Freq     uint32 `json:"RX1Freq,omitempty"`
+	RX2DR       *uint8 `json:"RX2DR,omitempty"`
+	RX2Freq     uint32 `json:"RX2Freq,omitempty"`
+	DR          *uint8 `json:"DR,omitempty"`
+	Freq        uint32 `json:"Freq,omitempty"`
+	GPSTime     int64  `json:"gpstime,omitempty"`
+	XTime       int64  `json:"xtime,omitempty"`
+	RCtx        int64  `json:"rctx"`
+}
+
+// stationTxConfirmation is sent by the station when a downlink has been sent
+// (dntxed).
+type stationTxConfirmation struct {
+	DIID    uint16  `json:"diid"`
+	DevEUI  string  `json:"DevEui"`
+	RCtx    int64   `json:"rctx"`
+	XTime   int64   `json:"xtime"`
+	TxTime  float64 `json:"txtime"`
+	GPSTime int64   `json:"gpstime"`
+}
+
+// stationTimeSync is sent by the station to get the GPS time. The server
+// responds with the same message and the GPS time in microseconds.
+type stationTimeSync struct {
+	MsgType string  `json:"msgtype"`
+	TxTime  float64 `json:"txtime"`
+	GPSTime int64   `json:"gpstime,omitempty"`
+}
+
+// stationRouterConfig is the configuration the server sends to the station
+// after the version message.
+type stationRouterConfig struct {
+	MsgType    string                   `json:"msgtype"`
+	NetID      []uint32                 `json:"NetID"`
+	JoinEUI    [][]uint64               `json:"JoinEui"`
+	Region     string                   `json:"region"`
+	HWSpec     string                   `json:"hwspec"`
+	FreqRange  []uint32                 `json:"freq_range"`
+	DRs        [][3]int                 `json:"DRs"`
+	SX1301Conf []map[string]interface{} `json:"sx1301_conf"`
+	Beaconing  *stationBeaconing        `json:"bcning,omitempty"`
+}
+
+// stationBeaconing is the class B beacon configuration for the station. The
+// layout is the offset of the time field, the offset of the info descriptor
+// and the length of the beacon.
+type stationBeaconing struct {
+	DR     uint8    `json:"DR"`
+	Layout [3]int   `json:"layout"`
+	Freqs  []uint32 `json:"freqs"`
+}
+
+// sx1301Radio is the configuration for one of the SX1301 radios
+type sx1301Radio struct {
+	Enable bool   `json:"enable"`
+	Freq   uint32 `json:"freq"`
+}
+
+// sx1301Channel is the configuration for one of the SX1301 channels. The IF
+// is the offset from the radio frequency in Hz.
+type sx1301Channel struct {
+	Enable       bool   `json:"enable"`
+	Radio        int    `json:"radio"`
+	IF           int32  `json:"if"`
+	Bandwidth    uint32 `json:"bandwidth,omitempty"`
+	SpreadFactor uint8  `json:"spread_factor,omitempty"`
+}
+
+// parseStationID parses the router ID sent by the station. The ID is either
+// an integer, an EUI string ("01-02-03-04-05-06-07-08") or an ID6 string
+// ("102:304:506:708").
+func parseStationID(raw json.RawMessage) (protocol.EUI, error) {
+	var val uint64
+	if err := json.Unmarshal(raw, &val); err == nil {
+		return protocol.EUIFromUint64(val), nil
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return protocol.EUI{}, fmt.Errorf("invalid router ID: %s", string(raw))
+	}
+	if strings.Contains(str, ":") {
+		return euiFromID6(str)
+	}
+	return protocol.EUIFromString(str)
+}
+
+// euiFromID6 converts an ID6 string to an EUI. ID6 strings use the IPv6
+// notation with four groups of 16 bits and "::" for a range of zero groups.
+func euiFromID6(id6 string) (protocol.EUI, error) {
+	halves := strings.Split(id6, "::")
+	if len(halves) > 2 {
+		return protocol.EUI{}, fmt.Errorf("invalid ID6: %s", id6)
+	}
+	groups := id6Groups(halves[0])
+	if len(halves) == 2 {
+		tail := id6Groups(halves[1])
+		if len(groups)+len(tail) > 3 {
+			return protocol.EUI{}, fmt.Errorf("invalid ID6: %s", id6)
+		}
+		for len(groups)+len(tail) < 4 {
+			groups = append(groups, "0")
+		}
+		groups = append(groups, tail...)
+	}
+	if len(groups) != 4 {
+		return protocol.EUI{}, fmt.Errorf("invalid ID6: %s", id6)
+	}
+	var val uint64
+	for _, g := range groups {
+		v, err := strconv.ParseUint(g, 16, 16)
+		if err != nil {
+			return protocol.EUI{}, fmt.Errorf("invalid ID6: %s", id6)
+		}
+		val = val<<16 | v
+	}
+	return protocol.EUIFromUint64(val), nil
+}
+
+func id6Groups(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ":")
+}
+
+// stationID6 returns the ID6 representation of an EUI
+func stationID6(eui protocol.EUI) string {
+	val := eui.ToUint64()
+	return fmt.Sprintf("%x:%x:%x:%x", val>>48, (val>>32)&0xFFFF, (val>>16)&0xFFFF, val&0xFFFF)
+}
+
+func appendUint16(buf []byte, val uint16) []byte {
+	tmp := make([]byte, 2)
+	binary.LittleEndian.PutUint16(tmp, val)
+	return append(buf, tmp...)
+}
+
+func appendUint32(buf []byte, val uint32) []byte {
+	tmp := make([]byte, 4)
+	binary.LittleEndian.PutUint32(tmp, val)
+	return append(buf, tmp...)
+}
+
+func appendUint64(buf []byte, val uint64) []byte {
+	tmp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(tmp, val)
+	return append(buf, tmp...)
+}
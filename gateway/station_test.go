@@ -0,0 +1,337 @@
+package gateway
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/utils"
+	"github.com/ExploratoryEngineering/pubsub"
+	"golang.org/x/net/websocket"
+)
+
+func TestStationID(t *testing.T) {
+	expected := protocol.EUIFromUint64(0x0102030405060708)
+	for _, v := range []string{`72623859790382856`, `"01-02-03-04-05-06-07-08"`, `"0102030405060708"`, `"102:304:506:708"`} {
+		eui, err := parseStationID(json.RawMessage(v))
+		if err != nil || eui != expected {
+			t.Fatalf("Expected %s for %s but got %s (err=%v)", expected, v, eui, err)
+		}
+	}
+	compressed := map[string]uint64{`"::1"`: 1, `"1::"`: 0x0001000000000000, `"1:2::3"`: 0x0001000200000003, `"::"`: 0}
+	for v, val := range compressed {
+		eui, err := parseStationID(json.RawMessage(v))
+		if err != nil || eui.ToUint64() != val {
+			t.Fatalf("Expected %x for %s but got %s (err=%v)", val, v, eui, err)
+		}
+	}
+	for _, v := range []string{`"1:2:3"`, `"1:2:3:4:5"`, `"1::2::3"`, `"1:2::3:4:5"`, `"x::1"`, `"10000::1"`, `{}`, `"01-02"`} {
+		if _, err := parseStationID(json.RawMessage(v)); err == nil {
+			t.Fatalf("Expected error for %s", v)
+		}
+	}
+	if id := stationID6(expected); id != "102:304:506:708" {
+		t.Fatalf("Unexpected ID6: %s", id)
+	}
+}
+
+func TestStationUplinkPayload(t *testing.T) {
+	uplink := stationUplink{MHdr: 0x40, DevAddr: 0x01020304, FCtrl: 0x81, FCnt: 0x0102, FOpts: "02", FPort: 1, FRMPayload: "aabb", MIC: 0x11223344}
+	buf, err := uplink.phyPayload()
+	if err != nil {
+		t.Fatal("Got error creating payload: ", err)
+	}
+	expected := []byte{0x40, 0x04, 0x03, 0x02, 0x01, 0x81, 0x02, 0x01, 0x02, 0x01, 0xaa, 0xbb, 0x44, 0x33, 0x22, 0x11}
+	if !bytes.Equal(buf, expected) {
+		t.Fatalf("Unexpected payload: %x", buf)
+	}
+
+	// Frames without a port has no payload
+	uplink.FPort = -1
+	uplink.FRMPayload = ""
+	if buf, err = uplink.phyPayload(); err != nil || len(buf) != 13 {
+		t.Fatalf("Unexpected payload without port: %x (err=%v)", buf, err)
+	}
+	uplink.FRMPayload = "aa"
+	if _, err := uplink.phyPayload(); err == nil {
+		t.Fatal("Expected error with payload but no port")
+	}
+	uplink.FOpts = "x"
+	if _, err := uplink.phyPayload(); err == nil {
+		t.Fatal("Expected error with invalid FOpts")
+	}
+
+	joinRequest := stationJoinRequest{JoinEUI: "01-02-03-04-05-06-07-08", DevEUI: "11-12-13-14-15-16-17-18", DevNonce: 0x0102, MIC: -1}
+	buf, err = joinRequest.phyPayload()
+	if err != nil {
+		t.Fatal("Got error creating join request: ", err)
+	}
+	payload := protocol.NewPHYPayload(protocol.JoinRequest)
+	if err := payload.UnmarshalBinary(buf); err != nil {
+		t.Fatal("Unable to decode join request: ", err)
+	}
+	if payload.JoinRequestPayload.AppEUI.String() != joinRequest.JoinEUI ||
+		payload.JoinRequestPayload.DevEUI.String() != joinRequest.DevEUI ||
+		payload.MIC != 0xFFFFFFFF {
+		t.Fatalf("Unexpected join request: %+v", payload)
+	}
+	// The station reports the DevNonce as it is sent on the air
+	if buf[17] != 0x02 || buf[18] != 0x01 {
+		t.Fatalf("Unexpected DevNonce in join request: %x", buf)
+	}
+	joinRequest.DevEUI = "01"
+	if _, err := joinRequest.phyPayload(); err == nil {
+		t.Fatal("Expected error with invalid DevEUI")
+	}
+}
+
+func TestRouterConfig(t *testing.T) {
+	euGateway := model.Gateway{Band: band.EU868Band}
+	euPlan, _ := band.NewBand(band.EU868Band)
+	config, err := newRouterConfig(euGateway, euPlan)
+	if err != nil {
+		t.Fatal("Got error creating EU868 config: ", err)
+	}
+	if config.Region != "EU863" || config.DRs[0] != [3]int{12, 125, 0} || config.DRs[7] != [3]int{0, 0, 0} || config.DRs[8] != [3]int{-1, 0, 0} {
+		t.Fatalf("Unexpected EU868 config: %+v", config)
+	}
+	conf := config.SX1301Conf[0]
+	if conf["radio_0"] != (sx1301Radio{Enable: true, Freq: 867500000}) || conf["radio_1"] != (sx1301Radio{Enable: true, Freq: 868300000}) {
+		t.Fatalf("Unexpected EU868 radios: %+v", conf)
+	}
+	if conf["chan_multiSF_0"] != (sx1301Channel{Enable: true, Radio: 0, IF: -400000}) || conf["chan_multiSF_7"] != (sx1301Channel{Enable: true, Radio: 1, IF: 200000}) {
+		t.Fatalf("Unexpected EU868 channels: %+v", conf)
+	}
+	if conf["chan_Lora_std"] != (sx1301Channel{}) {
+		t.Fatalf("Did not expect 500kHz channel for EU868: %+v", conf["chan_Lora_std"])
+	}
+	if config.Beaconing.Layout != [3]int{2, 8, 17} || config.Beaconing.Freqs[0] != 869525000 {
+		t.Fatalf("Unexpected EU868 beacon config: %+v", config.Beaconing)
+	}
+
+	usGateway := model.Gateway{Band: band.US915Band, SubBand: 2}
+	usPlan, _ := band.NewBand(band.US915Band)
+	config, err = newRouterConfig(usGateway, usPlan)
+	if err != nil {
+		t.Fatal("Got error creating US915 config: ", err)
+	}
+	if config.Region != "US902" || config.DRs[0] != [3]int{10, 125, 0} || config.DRs[8] != [3]int{12, 500, 1} {
+		t.Fatalf("Unexpected US915 config: %+v", config)
+	}
+	conf = config.SX1301Conf[0]
+	if conf["radio_0"] != (sx1301Radio{Enable: true, Freq: 904300000}) || conf["radio_1"] != (sx1301Radio{Enable: true, Freq: 905100000}) {
+		t.Fatalf("Unexpected US915 radios: %+v", conf)
+	}
+	if conf["chan_Lora_std"] != (sx1301Channel{Enable: true, Radio: 0, IF: 300000, Bandwidth: 500000, SpreadFactor: 8}) {
+		t.Fatalf("Unexpected US915 500kHz channel: %+v", conf["chan_Lora_std"])
+	}
+	if config.Beaconing.Layout != [3]int{5, 11, 23} || len(config.Beaconing.Freqs) != 8 {
+		t.Fatalf("Unexpected US915 beacon config: %+v", config.Beaconing)
+	}
+
+	if _, err := newRouterConfig(model.Gateway{Band: band.FrequencyBandType(99)}, euPlan); err == nil {
+		t.Fatal("Expected error with unknown band")
+	}
+}
+
+func TestStationDownlink(t *testing.T) {
+	plan, _ := band.NewBand(band.EU868Band)
+	packet := server.GatewayPacket{
+		RawMessage: []byte{1, 2, 3},
+		Radio:      server.RadioContext{Band: plan, DataRate: "SF9BW125", Frequency: 868.1, RX1Delay: 1},
+		Gateway:    server.GatewayContext{XTime: 1000, RCtx: 2},
+		Fallback:   &server.TXFallback{DeviceEUI: protocol.EUIFromUint64(1)},
+	}
+	msg, err := newStationDownlink(packet, 12)
+	if err != nil {
+		t.Fatal("Got error creating downlink: ", err)
+	}
+	if msg.DeviceClass != stationClassA || msg.RX1DR == nil || *msg.RX1DR != 3 || msg.RX1Freq != 868100000 || msg.RX2DR != nil ||
+		msg.XTime != 1000 || msg.RCtx != 2 || msg.RxDelay != 1 || msg.PDU != "010203" || msg.DIID != 12 || msg.DevEUI != "00-00-00-00-00-00-00-01" {
+		t.Fatalf("Unexpected RX1 downlink: %+v", msg)
+	}
+
+	packet.Radio.RXWindow = band.RX2
+	packet.Radio.DataRate = "SF12BW125"
+	packet.Radio.Frequency = 869.525
+	if msg, err = newStationDownlink(packet, 12); err != nil || msg.RX1DR != nil || msg.RX2DR == nil || *msg.RX2DR != 0 || msg.RX2Freq != 869525000 {
+		t.Fatalf("Unexpected RX2 downlink: %+v (err=%v)", msg, err)
+	}
+
+	packet.Immediate = true
+	if msg, err = newStationDownlink(packet, 12); err != nil || msg.DeviceClass != stationClassC || msg.RX2DR == nil || msg.RX2Freq != 869525000 {
+		t.Fatalf("Unexpected class C downlink: %+v (err=%v)", msg, err)
+	}
+
+	packet.Immediate = false
+	packet.TXTime = protocol.GPSEpoch.Add(time.Hour)
+	if msg, err = newStationDownlink(packet, 12); err != nil || msg.DeviceClass != stationClassB || msg.DR == nil || msg.Freq != 869525000 ||
+		msg.GPSTime != int64(protocol.GPSTime(packet.TXTime)/time.Microsecond) {
+		t.Fatalf("Unexpected class B downlink: %+v (err=%v)", msg, err)
+	}
+
+	packet.Radio.DataRate = "SF7BW500"
+	if _, err := newStationDownlink(packet, 12); err == nil {
+		t.Fatal("Expected error with invalid data rate")
+	}
+	packet.Radio.Band = nil
+	if _, err := newStationDownlink(packet, 12); err == nil {
+		t.Fatal("Expected error without band")
+	}
+}
+
+func dialStation(t *testing.T, url string, token string) (*websocket.Conn, error) {
+	config, err := websocket.NewConfig(url, "http://localhost/")
+	if err != nil {
+		t.Fatal("Unable to create websocket config: ", err)
+	}
+	if token != "" {
+		config.Header = http.Header{}
+		config.Header.Set("Authorization", "Bearer "+token)
+	}
+	return websocket.DialConfig(config)
+}
+
+func receiveStationMessage(t *testing.T, ws *websocket.Conn, msg interface{}) {
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if err := websocket.JSON.Receive(ws, msg); err != nil {
+		t.Fatal("Unable to receive message from server: ", err)
+	}
+}
+
+func TestBasicsStation(t *testing.T) {
+	port, err := utils.FreePort()
+	if err != nil {
+		t.Fatal("Could not allocate free port: ", err)
+	}
+	router := pubsub.NewEventRouter(5)
+	context := server.Context{GwEventRouter: &router, Config: &server.Configuration{}}
+	forwarder := NewBasicsStation(port, gwStorage, &context)
+	go forwarder.Start()
+	defer forwarder.Stop()
+
+	eui := protocol.EUIFromUint64(0x0102030405060721)
+	gwStorage.Put(model.Gateway{GatewayEUI: eui, Tags: model.NewTags(), StationToken: "secret"}, model.SystemUserID)
+	otherEUI := protocol.EUIFromUint64(0x0102030405060722)
+	gwStorage.Put(model.Gateway{GatewayEUI: otherEUI, Tags: model.NewTags(), StationToken: "other"}, model.SystemUserID)
+
+	infoURL := fmt.Sprintf("ws://localhost:%d%s", port, stationInfoPath)
+	var ws *websocket.Conn
+	for i := 0; i < 10; i++ {
+		if ws, err = dialStation(t, infoURL, "secret"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal("Unable to connect to router info: ", err)
+	}
+	// Unknown gateways get an error
+	websocket.JSON.Send(ws, map[string]interface{}{"router": "1::"})
+	info := stationRouterInfo{}
+	receiveStationMessage(t, ws, &info)
+	if info.Error == "" || info.URI != "" {
+		t.Fatalf("Expected error for unknown gateway: %+v", info)
+	}
+	ws.Close()
+
+	// The token must match the gateway's token. Another gateway's token
+	// doesn't work.
+	ws, err = dialStation(t, infoURL, "other")
+	if err != nil {
+		t.Fatal("Unable to connect to router info: ", err)
+	}
+	websocket.JSON.Send(ws, map[string]interface{}{"router": stationID6(eui)})
+	info = stationRouterInfo{}
+	receiveStationMessage(t, ws, &info)
+	ws.Close()
+	if info.Error == "" || info.URI != "" {
+		t.Fatalf("Expected error for invalid token: %+v", info)
+	}
+
+	ws, err = dialStation(t, infoURL, "secret")
+	if err != nil {
+		t.Fatal("Unable to connect to router info: ", err)
+	}
+	websocket.JSON.Send(ws, map[string]interface{}{"router": stationID6(eui)})
+	info = stationRouterInfo{}
+	receiveStationMessage(t, ws, &info)
+	ws.Close()
+	if info.Error != "" || info.URI != fmt.Sprintf("ws://localhost:%d/traffic/%s", port, eui) {
+		t.Fatalf("Unexpected router info: %+v", info)
+	}
+
+	for _, token := range []string{"", "wrong", "other"} {
+		if _, err := dialStation(t, info.URI, token); err == nil {
+			t.Fatalf("Expected connection with token %q to fail", token)
+		}
+	}
+	ws, err = dialStation(t, info.URI, "secret")
+	if err != nil {
+		t.Fatal("Unable to connect to traffic endpoint: ", err)
+	}
+	defer ws.Close()
+	websocket.JSON.Send(ws, map[string]interface{}{"msgtype": "version", "station": "2.0.6", "protocol": 2})
+	config := stationRouterConfig{}
+	receiveStationMessage(t, ws, &config)
+	if config.MsgType != stationRouterConfigMsg || config.Region != "EU863" {
+		t.Fatalf("Unexpected router config: %+v", config)
+	}
+
+	websocket.JSON.Send(ws, map[string]interface{}{
+		"msgtype": "updf", "MHdr": 0x40, "DevAddr": 1, "FCtrl": 0, "FCnt": 1, "FOpts": "", "FPort": 1,
+		"FRMPayload": "aa", "MIC": 2, "DR": 5, "Freq": 868100000,
+		"upinfo": map[string]interface{}{"rctx": 3, "xtime": 4000, "rssi": -50, "snr": 9.5}})
+	var uplink server.GatewayPacket
+	select {
+	case uplink = <-forwarder.Output():
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive uplink from forwarder")
+	}
+	if uplink.Gateway.GatewayEUI != eui || uplink.Gateway.XTime != 4000 || uplink.Gateway.RCtx != 3 ||
+		uplink.Radio.DataRate != "SF7BW125" || uplink.Radio.Frequency != 868.1 || uplink.Radio.RSSI != -50 || len(uplink.RawMessage) != 14 {
+		t.Fatalf("Unexpected uplink: %+v", uplink)
+	}
+
+	uplink.Radio.RX1Delay = 1
+	forwarder.Input() <- uplink
+	downlink := stationDownlink{}
+	receiveStationMessage(t, ws, &downlink)
+	if downlink.MsgType != stationDownlinkMsg || downlink.XTime != 4000 || downlink.RCtx != 3 || downlink.RX1Freq != 868100000 {
+		t.Fatalf("Unexpected downlink: %+v", downlink)
+	}
+	websocket.JSON.Send(ws, map[string]interface{}{"msgtype": "dntxed", "diid": downlink.DIID, "rctx": 3})
+
+	websocket.JSON.Send(ws, map[string]interface{}{"msgtype": "timesync", "txtime": 1.5})
+	timeSync := stationTimeSync{}
+	receiveStationMessage(t, ws, &timeSync)
+	if timeSync.TxTime != 1.5 || timeSync.GPSTime == 0 {
+		t.Fatalf("Unexpected timesync response: %+v", timeSync)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, found := forwarder.sent.remove(eui, downlink.DIID); found {
+		t.Fatal("Expected dntxed to remove the sent packet")
+	}
+}
@@ -0,0 +1,74 @@
+package gateway
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+)
+
+// txAckTimeout is how long the forwarder waits for a TX_ACK from the gateway.
+// The gateway sends the TX_ACK as soon as it gets the PULL_RESP.
+const txAckTimeout = 30 * time.Second
+
+type txKey struct {
+	eui   protocol.EUI
+	token uint16
+}
+
+type sentPacket struct {
+	packet  server.GatewayPacket
+	expires time.Time
+}
+
+// sentPackets keeps track of the packets sent to the gateways until they are
+// acknowledged. The PULL_RESP token is used to correlate the packets with the
+// TX_ACK packets from the gateway.
+type sentPackets struct {
+	mutex   *sync.Mutex
+	packets map[txKey]sentPacket
+}
+
+func newSentPackets() *sentPackets {
+	return &sentPackets{mutex: &sync.Mutex{}, packets: make(map[txKey]sentPacket)}
+}
+
+// add adds a packet. Packets that haven't been acknowledged in time are
+// removed.
+func (s *sentPackets) add(eui protocol.EUI, token uint16, packet server.GatewayPacket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for k, v := range s.packets {
+		if v.expires.Before(now) {
+			delete(s.packets, k)
+		}
+	}
+	s.packets[txKey{eui, token}] = sentPacket{packet, now.Add(txAckTimeout)}
+}
+
+// remove removes the packet sent to the gateway with the token. Returns false
+// if the packet isn't found.
+func (s *sentPackets) remove(eui protocol.EUI, token uint16) (server.GatewayPacket, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret, ok := s.packets[txKey{eui, token}]
+	delete(s.packets, txKey{eui, token})
+	return ret.packet, ok
+}
@@ -30,11 +30,13 @@ var config = server.NewDefaultConfig()
 
 func init() {
 	flag.IntVar(&config.GatewayPort, "gwport", server.DefaultGatewayPort, "Port for gateway listener")
+	flag.StringVar(&config.ExtraGatewayPorts, "gwports", "", "Comma-separated list of additional ports for gateway listeners")
 	flag.IntVar(&config.HTTPServerPort, "http", server.DefaultHTTPPort, "HTTP port to listen on")
 	flag.UintVar(&config.NetworkID, "netid", server.DefaultNetworkID, "The Network ID to use")
 	flag.StringVar(&config.MA, "ma", server.DefaultMA, "MA to use when generating new EUIs")
 	flag.StringVar(&config.DBConnectionString, "connectionstring", "", "Database connection string")
 	flag.BoolVar(&config.PrintSchema, "printschema", false, "Print schema definition")
+	flag.BoolVar(&config.PrintMigration, "printmigration", false, "Print commands to upgrade an existing schema")
 	flag.BoolVar(&config.Syslog, "syslog", false, "Send logs to syslog")
 	flag.BoolVar(&config.DisableGatewayChecks, "disablegwcheck", false, "Disable ALL gateway checks")
 	flag.StringVar(&config.ConnectHost, "connect-host", server.DefaultConnectHost, "CONNECT ID host")
@@ -58,9 +60,14 @@ func init() {
 	flag.IntVar(&config.DBMaxConnections, "db-max-connections", server.DefaultMaxConns, "Maximum DB connections")
 	flag.IntVar(&config.DBIdleConnections, "db-max-idle-connections", server.DefaultIdleConns, "Maximum idle DB connections")
 	flag.DurationVar(&config.DBConnLifetime, "db-max-lifetime-connections", server.DefaultConnLifetime, "Maximum life time of DB connections")
+	flag.DurationVar(&config.DedupWindow, "dedup-window", server.DefaultDedupWindow, "Time to wait for copies of a frame from other gateways")
+	flag.IntVar(&config.DownlinkRetries, "downlink-retries", server.DefaultDownlinkRetries, "Number of times an unacknowledged downlink is sent again")
+	flag.DurationVar(&config.DownlinkExpiry, "downlink-expiry", server.DefaultDownlinkExpiry, "Time before undelivered downlinks expire. 0 means never")
+	flag.StringVar(&config.JoinServerURL, "join-server-url", "", "URL for external join server. The built-in join server is used if this is empty")
 	flag.BoolVar(&config.ACMECert, "acme-cert", false, "Enable Let's Encrypt certificates. Requires host name")
 	flag.StringVar(&config.ACMEHost, "acme-hostname", "", "Host name to use when requesting certificates from Let's Encrypt")
 	flag.StringVar(&config.ACMESecretDir, "acme-secret-dir", "secret-dir", "Directory for ACME certificate secrets")
+	flag.IntVar(&config.StationPort, "station-port", 0, "Port for the Basics Station LNS endpoint. 0 disables the endpoint")
 	flag.Parse()
 }
 
@@ -69,6 +76,10 @@ func main() {
 		fmt.Println(dbstore.DBSchema)
 		return
 	}
+	if config.PrintMigration {
+		fmt.Print(dbstore.DBMigration)
+		return
+	}
 	logging.SetLogLevel(config.LogLevel)
 	congress, err := NewServer(config)
 	if err != nil {
@@ -0,0 +1,109 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"encoding/json"
+	"math"
+)
+
+// MaxChannels is the maximum number of uplink channels for a device. The
+// channel index in the LinkADRReq mask is limited to 16 channels [5.2]
+const MaxChannels = 16
+
+// Channel is an uplink channel for a device [5.6], [5.8]
+type Channel struct {
+	Frequency float32 `json:"frequency"`          // Uplink frequency (in MHz). 0 means the channel is disabled
+	Downlink  float32 `json:"downlink,omitempty"` // Frequency (in MHz) for the first receive window. 0 means the uplink frequency is used
+}
+
+// DownlinkFrequency returns the frequency for the first receive window on
+// the channel.
+func (c Channel) DownlinkFrequency() float32 {
+	if c.Downlink == 0 {
+		return c.Frequency
+	}
+	return c.Downlink
+}
+
+// ChannelList is a list of channels for a device. The position in the list is
+// the channel index.
+type ChannelList []Channel
+
+// Equals returns true if both lists have the same channels.
+func (c ChannelList) Equals(other ChannelList) bool {
+	if len(c) != len(other) {
+		return false
+	}
+	for i := range c {
+		if c[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Mask returns the channel mask for the LinkADRReq command, ie one bit set
+// for each enabled channel.
+func (c ChannelList) Mask() uint16 {
+	var ret uint16
+	for i, v := range c {
+		if i < MaxChannels && v.Frequency != 0 {
+			ret |= 1 << uint(i)
+		}
+	}
+	return ret
+}
+
+// Find returns the channel with the specified uplink frequency. The boolean
+// flag is false if there's no matching channel.
+func (c ChannelList) Find(frequency float32) (Channel, bool) {
+	if i, ok := c.Index(frequency); ok {
+		return c[i], true
+	}
+	return Channel{}, false
+}
+
+// Index returns the index of the channel with the specified uplink
+// frequency. The boolean flag is false if there's no matching channel.
+func (c ChannelList) Index(frequency float32) (int, bool) {
+	for i, v := range c {
+		// Anything closer than the 100 Hz resolution is the same frequency
+		if v.Frequency != 0 && math.Abs(float64(v.Frequency-frequency)) < 0.0001 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// JSON returns the channel list formatted as a JSON array
+func (c ChannelList) JSON() []byte {
+	buf, _ := json.Marshal(c)
+	return buf
+}
+
+// NewChannelListFromBuffer unmarshals a JSON array into a channel list
+func NewChannelListFromBuffer(buf []byte) (ChannelList, error) {
+	var ret ChannelList
+	if len(buf) == 0 {
+		return ret, nil
+	}
+	if err := json.Unmarshal(buf, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,61 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import "testing"
+
+func TestChannelList(t *testing.T) {
+	list := ChannelList{{Frequency: 868.1}, {Frequency: 868.3}, {Frequency: 868.5}, {}, {Frequency: 867.3, Downlink: 869.1}}
+
+	if list.Mask() != 0x17 {
+		t.Fatalf("Expected mask to be 0x17 but it is 0x%04x", list.Mask())
+	}
+
+	ch, ok := list.Find(867.3)
+	if !ok || ch.DownlinkFrequency() != 869.1 {
+		t.Fatalf("Expected to find channel with downlink frequency but got %+v (%t)", ch, ok)
+	}
+	ch, ok = list.Find(868.3)
+	if !ok || ch.DownlinkFrequency() != 868.3 {
+		t.Fatalf("Expected to find channel but got %+v (%t)", ch, ok)
+	}
+	if _, ok := list.Find(0); ok {
+		t.Fatal("Disabled channels shouldn't match")
+	}
+	if i, ok := list.Index(867.3); !ok || i != 4 {
+		t.Fatalf("Expected index 4 for channel but got %d (%t)", i, ok)
+	}
+
+	other, err := NewChannelListFromBuffer(list.JSON())
+	if err != nil {
+		t.Fatalf("Got error unmarshaling list: %v", err)
+	}
+	if !list.Equals(other) {
+		t.Fatalf("Lists aren't equal: %v != %v", list, other)
+	}
+	if list.Equals(other[:3]) {
+		t.Fatal("Lists should be different")
+	}
+
+	empty, err := NewChannelListFromBuffer(nil)
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("Expected empty list from empty buffer but got %v (%v)", empty, err)
+	}
+	if _, err := NewChannelListFromBuffer([]byte("{")); err == nil {
+		t.Fatal("Expected error with invalid JSON")
+	}
+}
@@ -0,0 +1,72 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	downstreamIDMutex sync.Mutex
+	lastDownstreamID  uint64
+)
+
+// newDownstreamID returns a new ID for a downstream message. The IDs are
+// based on the time in microseconds and increase for each new message. This
+// keeps the IDs unique for each device and they will fit into a JavaScript
+// number.
+func newDownstreamID() uint64 {
+	downstreamIDMutex.Lock()
+	defer downstreamIDMutex.Unlock()
+	id := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	if id <= lastDownstreamID {
+		id = lastDownstreamID + 1
+	}
+	lastDownstreamID = id
+	return id
+}
+
+// queueRank is the message's rank in the queue. A confirmed message that is
+// sent but not acknowledged stays at the head of the queue until it is
+// completed, then messages that aren't completed.
+func (d *DownstreamMessage) queueRank() int {
+	switch {
+	case d.State() == SentState && d.Ack:
+		return 0
+	case !d.IsComplete():
+		return 1
+	default:
+		return 2
+	}
+}
+
+// SortDownstreamQueue sorts the device's downstream messages in the order
+// they are sent. Unacknowledged confirmed messages and messages that aren't
+// completed are sent first, then by priority and finally in the order they
+// were created. The first message is the head of the queue.
+func SortDownstreamQueue(queue []DownstreamMessage) {
+	sort.SliceStable(queue, func(i, j int) bool {
+		if ri, rj := queue[i].queueRank(), queue[j].queueRank(); ri != rj {
+			return ri < rj
+		}
+		if queue[i].Priority != queue[j].Priority {
+			return queue[i].Priority > queue[j].Priority
+		}
+		return queue[i].ID < queue[j].ID
+	})
+}
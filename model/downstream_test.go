@@ -0,0 +1,68 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func TestDownstreamID(t *testing.T) {
+	eui := protocol.EUIFromUint64(1)
+	ids := make(map[uint64]bool)
+	var last uint64
+	for i := 0; i < 1000; i++ {
+		msg := NewDownstreamMessage(eui, 1)
+		if msg.ID == 0 || msg.ID <= last || ids[msg.ID] {
+			t.Fatalf("ID %d isn't unique or increasing (last = %d)", msg.ID, last)
+		}
+		ids[msg.ID] = true
+		last = msg.ID
+	}
+	// IDs must be exact in JavaScript
+	if last > 1<<53 {
+		t.Fatalf("ID %d won't fit into a JavaScript number", last)
+	}
+}
+
+func TestSortDownstreamQueue(t *testing.T) {
+	eui := protocol.EUIFromUint64(1)
+	sent := NewDownstreamMessage(eui, 1)
+	sent.SentTime = time.Now().Unix()
+	first := NewDownstreamMessage(eui, 2)
+	second := NewDownstreamMessage(eui, 3)
+	urgent := NewDownstreamMessage(eui, 4)
+	urgent.Priority = 10
+	unacked := NewDownstreamMessage(eui, 5)
+	unacked.Ack = true
+	unacked.SentTime = time.Now().Unix()
+
+	queue := []DownstreamMessage{sent, second, urgent, first, unacked}
+	SortDownstreamQueue(queue)
+
+	var ports []uint8
+	for _, v := range queue {
+		ports = append(ports, v.Port)
+	}
+	expected := []uint8{5, 4, 2, 3, 1}
+	for i := range expected {
+		if ports[i] != expected[i] {
+			t.Fatalf("Expected queue order %v but got %v", expected, ports)
+		}
+	}
+}
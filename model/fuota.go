@@ -0,0 +1,152 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// FUOTAState is the state of a firmware update campaign
+type FUOTAState uint8
+
+// States for the firmware update campaigns. The devices are set up first,
+// then the fragments are sent to the multicast group and finally the devices
+// report their status.
+const (
+	FUOTASetup FUOTAState = iota
+	FUOTAFragmenting
+	FUOTAStatus
+	FUOTAComplete
+	FUOTACancelled
+	FUOTAFailed
+)
+
+// String returns the string representation of the state
+func (s FUOTAState) String() string {
+	switch s {
+	case FUOTASetup:
+		return "SETUP"
+	case FUOTAFragmenting:
+		return "FRAGMENTING"
+	case FUOTAStatus:
+		return "STATUS"
+	case FUOTAComplete:
+		return "COMPLETE"
+	case FUOTACancelled:
+		return "CANCELLED"
+	case FUOTAFailed:
+		return "FAILED"
+	default:
+		logging.Warning("Unknown FUOTA state: %d", s)
+		return "FAILED"
+	}
+}
+
+// IsActive returns true if the campaign is running
+func (s FUOTAState) IsActive() bool {
+	return s == FUOTASetup || s == FUOTAFragmenting || s == FUOTAStatus
+}
+
+// FUOTACampaign is a firmware update campaign for a set of devices in an
+// application. The devices are set up with the Remote Multicast Setup and
+// Fragmented Data Block Transport messages and the firmware is sent as coded
+// fragments to the multicast group.
+type FUOTACampaign struct {
+	CampaignEUI   protocol.EUI    // Unique identifier for the campaign
+	AppEUI        protocol.EUI    // The application the campaign belongs to
+	GroupEUI      protocol.EUI    // The multicast group used for the fragments
+	McKey         protocol.AESKey // Multicast key for the group. The group's session keys are derived from this
+	GroupID       uint8           // Multicast group ID on the devices (0-3)
+	FragIndex     uint8           // Fragmentation session index on the devices (0-3)
+	Firmware      []byte          // The firmware image
+	FragSize      uint8           // Size of each fragment
+	Redundancy    uint16          // Number of coded fragments sent after the firmware
+	Descriptor    uint32          // Free-form descriptor sent to the devices
+	Interval      uint16          // Seconds between each fragment
+	SessionTime   int64           // Start of the multicast session (Unix time in seconds)
+	TimeOut       uint8           // Multicast session timeout exponent
+	State         FUOTAState      // Current state of the campaign
+	FragmentsSent uint16          // Number of fragments sent to the group
+	CreatedTime   int64           // Creation time (Unix time in seconds)
+}
+
+// NewFUOTACampaign creates a new campaign
+func NewFUOTACampaign() FUOTACampaign {
+	return FUOTACampaign{State: FUOTASetup, CreatedTime: time.Now().Unix()}
+}
+
+// SessionEnd returns the time the multicast session ends on the devices.
+// Class B sessions last 2^TimeOut beacon periods and class C sessions last
+// 2^TimeOut seconds.
+func (c *FUOTACampaign) SessionEnd(class DeviceClass) time.Time {
+	length := time.Duration(1<<c.TimeOut) * time.Second
+	if class == ClassB {
+		length = time.Duration(1<<c.TimeOut) * protocol.BeaconPeriod
+	}
+	return time.Unix(c.SessionTime, 0).Add(length)
+}
+
+// FUOTADeviceState is the state of a single device in a campaign
+type FUOTADeviceState uint8
+
+// States for the devices in a campaign. The device moves through the setup
+// states as it answers the setup requests.
+const (
+	FUOTADeviceGroupSetup FUOTADeviceState = iota
+	FUOTADeviceFragSetup
+	FUOTADeviceSessionSetup
+	FUOTADeviceReady
+	FUOTADeviceComplete
+	FUOTADeviceIncomplete
+	FUOTADeviceFailed
+)
+
+// String returns the string representation of the state
+func (s FUOTADeviceState) String() string {
+	switch s {
+	case FUOTADeviceGroupSetup:
+		return "GROUP_SETUP"
+	case FUOTADeviceFragSetup:
+		return "FRAG_SETUP"
+	case FUOTADeviceSessionSetup:
+		return "SESSION_SETUP"
+	case FUOTADeviceReady:
+		return "READY"
+	case FUOTADeviceComplete:
+		return "COMPLETE"
+	case FUOTADeviceIncomplete:
+		return "INCOMPLETE"
+	case FUOTADeviceFailed:
+		return "FAILED"
+	default:
+		logging.Warning("Unknown FUOTA device state: %d", s)
+		return "FAILED"
+	}
+}
+
+// FUOTADevice is the status for a single device in a campaign
+type FUOTADevice struct {
+	CampaignEUI    protocol.EUI
+	DeviceEUI      protocol.EUI
+	State          FUOTADeviceState
+	NbFragReceived uint16 // Number of fragments the device has received
+	MissingFrag    uint8  // Number of fragments the device is missing
+	Error          string // The reason the device failed
+	UpdatedTime    int64  // Time of the last update (Unix time in seconds)
+}
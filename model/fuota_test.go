@@ -0,0 +1,56 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func TestFUOTAState(t *testing.T) {
+	active := map[FUOTAState]bool{
+		FUOTASetup: true, FUOTAFragmenting: true, FUOTAStatus: true,
+		FUOTAComplete: false, FUOTACancelled: false, FUOTAFailed: false,
+	}
+	for state, expected := range active {
+		if state.IsActive() != expected {
+			t.Fatalf("Expected IsActive() to be %t for %s", expected, state)
+		}
+	}
+	if FUOTAState(99).String() != "FAILED" || FUOTADeviceState(99).String() != "FAILED" {
+		t.Fatal("Unknown states should be reported as failed")
+	}
+	if FUOTADeviceReady.String() != "READY" {
+		t.Fatal("Unexpected string for device state")
+	}
+}
+
+func TestFUOTASessionEnd(t *testing.T) {
+	c := NewFUOTACampaign()
+	if c.State != FUOTASetup || c.CreatedTime == 0 {
+		t.Fatalf("Campaign isn't initialized: %+v", c)
+	}
+	c.SessionTime = 1000
+	c.TimeOut = 4
+	if end := c.SessionEnd(ClassC); !end.Equal(time.Unix(1016, 0)) {
+		t.Fatalf("Class C session ends at %v", end)
+	}
+	if end := c.SessionEnd(ClassB); !end.Equal(time.Unix(1000, 0).Add(16 * protocol.BeaconPeriod)) {
+		t.Fatalf("Class B session ends at %v", end)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/logging"
 )
@@ -41,7 +42,17 @@ type User struct {
 
 // Application represents a LoRa application instance.
 type Application struct {
-	AppEUI protocol.EUI // Application EUI
+	AppEUI               protocol.EUI  // Application EUI
+	DeviceStatusInterval time.Duration // Interval between DevStatusReq commands to devices. 0 disables the requests.
+	Channels             ChannelList   // Channels added to the band's mandatory channels for devices. The band's additional channels are used if the list is empty.
+
+	// EndToEnd is set when payloads are encrypted end-to-end, ie the server
+	// doesn't store the AppSKey for the application's devices. The join
+	// server wraps the AppSKey with the key encryption key (KEK) and the
+	// wrapped key is sent to the application when a device joins. The server
+	// only knows the label for the KEK.
+	EndToEnd bool
+	KEKLabel string
 	Tags
 }
 
@@ -49,6 +60,10 @@ type Application struct {
 // ...Equals
 func (a *Application) Equals(other Application) bool {
 	return a.AppEUI == other.AppEUI &&
+		a.DeviceStatusInterval == other.DeviceStatusInterval &&
+		a.Channels.Equals(other.Channels) &&
+		a.EndToEnd == other.EndToEnd &&
+		a.KEKLabel == other.KEKLabel &&
 		a.Tags.Equals(other.Tags)
 }
 
@@ -108,38 +123,173 @@ func DeviceStateFromString(str string) (DeviceState, error) {
 	}
 }
 
+// DeviceClass is the LoRaWAN device class. All devices support class A.
+type DeviceClass uint8
+
+// Device classes. Class A devices only listen for downlinks after an uplink,
+// class B devices listen in scheduled ping slots synchronized by beacons and
+// class C devices listen on the second receive window parameters whenever they
+// aren't transmitting [2.1].
+const (
+	ClassA DeviceClass = 0
+	ClassB DeviceClass = 1
+	ClassC DeviceClass = 2
+)
+
+// String returns the class as a string, ie "A", "B" or "C"
+func (c DeviceClass) String() string {
+	switch c {
+	case ClassA:
+		return "A"
+	case ClassB:
+		return "B"
+	case ClassC:
+		return "C"
+	default:
+		logging.Warning("Unknown device class: %d", c)
+		return "A"
+	}
+}
+
+// DeviceClassFromString converts a string ("A", "B" or "C") into a DeviceClass
+// value. Conversion is not case sensitive. White space is trimmed.
+func DeviceClassFromString(str string) (DeviceClass, error) {
+	switch strings.TrimSpace(strings.ToUpper(str)) {
+	case "A":
+		return ClassA, nil
+	case "B":
+		return ClassB, nil
+	case "C":
+		return ClassC, nil
+	default:
+		return ClassA, fmt.Errorf("unknown device class: %s", str)
+	}
+}
+
+// RXSettings is the receive window settings for a device [3.3], [5.4], [5.7]
+type RXSettings struct {
+	RX1Delay     uint8   // Delay (in seconds) between the end of the uplink and the first receive window
+	RX1DROffset  uint8   // Offset between the uplink data rate and the data rate in the first receive window
+	RX2DataRate  uint8   // Data rate for the second receive window. Not used if RX2Frequency is 0
+	RX2Frequency float32 // Frequency (in MHz) for the second receive window. 0 means the band's default frequency and data rate
+}
+
+// DefaultRXSettings returns the receive window settings the devices use
+// after a join or a reset.
+func DefaultRXSettings() RXSettings {
+	return RXSettings{RX1Delay: 1}
+}
+
+// RX1Window returns the delay for the first receive window. A delay of 0
+// seconds is the same as 1 second [5.7]
+func (r RXSettings) RX1Window() time.Duration {
+	if r.RX1Delay == 0 {
+		return time.Second
+	}
+	return time.Duration(r.RX1Delay) * time.Second
+}
+
+// RX2Window returns the delay for the second receive window. The second window
+// opens one second after the first [3.3.2]
+func (r RXSettings) RX2Window() time.Duration {
+	return r.RX1Window() + time.Second
+}
+
+// RX2Parameters returns the data rate, frequency and power for the second
+// receive window. The band's default power is used when the device has a
+// custom RX2 frequency since the higher RX2 power might not be allowed on it.
+func (r RXSettings) RX2Parameters(plan band.FrequencyPlan) band.DownlinkParameters {
+	if r.RX2Frequency == 0 {
+		return plan.GetRX2Parameters()
+	}
+	return band.DownlinkParameters{DataRate: r.RX2DataRate, Frequency: r.RX2Frequency, Power: plan.Configuration().DefaultTxPower}
+}
+
+// PingSlotSettings is the class B ping slot settings for a device
+// [14.1], [14.3]
+type PingSlotSettings struct {
+	Periodicity uint8   // Ping slot periodicity. The device opens 2^(7-Periodicity) ping slots per beacon period. Set by the device
+	DataRate    uint8   // Data rate for the ping slots. Not used if Frequency is 0
+	Frequency   float32 // Frequency (in MHz) for the ping slots. 0 means the band's default frequency and data rate
+}
+
+// Parameters returns the data rate and frequency for the ping slots in the
+// beacon period that starts at beaconTime (GPS time in seconds).
+func (p PingSlotSettings) Parameters(plan band.FrequencyPlan, beaconTime uint32, devAddr protocol.DevAddr) band.DownlinkParameters {
+	if p.Frequency == 0 {
+		conf := plan.Configuration()
+		return band.DownlinkParameters{
+			DataRate:  conf.BeaconDataRate,
+			Frequency: conf.PingSlotFrequency(beaconTime, devAddr.ToUint32()),
+		}
+	}
+	return band.DownlinkParameters{DataRate: p.DataRate, Frequency: p.Frequency}
+}
+
 // Device represents a device. Devices are associated with one and only one Application
 type Device struct {
-	DeviceEUI       protocol.EUI     // EUI for device
-	DevAddr         protocol.DevAddr // Device address
-	AppKey          protocol.AESKey  // AES key for application
-	AppSKey         protocol.AESKey  // Application session key
-	NwkSKey         protocol.AESKey  // Network session key
-	AppEUI          protocol.EUI     // The application associated with the device. Set by storage backend
-	State           DeviceState      // Current state of the device
-	FCntUp          uint16           // Frame counter up (from device)
-	FCntDn          uint16           // Frame counter down (to device)
-	RelaxedCounter  bool             // Relaxed frame count checks
-	DevNonceHistory []uint16         // Log of DevNonces sent from the device
-	KeyWarning      bool             // Duplicate key warning flag
+	DeviceEUI       protocol.EUI        // EUI for device
+	DevAddr         protocol.DevAddr    // Device address
+	AppKey          protocol.AESKey     // AES key for application
+	AppSKey         protocol.AESKey     // Application session key
+	NwkSKey         protocol.AESKey     // Network session key. This is the FNwkSIntKey for LoRaWAN 1.1 devices
+	NwkKey          protocol.AESKey     // Network root key for LoRaWAN 1.1 devices
+	SNwkSIntKey     protocol.AESKey     // Serving network session integrity key for LoRaWAN 1.1 devices
+	NwkSEncKey      protocol.AESKey     // Network session encryption key for LoRaWAN 1.1 devices
+	MACVersion      protocol.MACVersion // LoRaWAN version implemented by the device
+	JoinNonce       uint32              // The last JoinNonce sent to the device. LoRaWAN 1.1 devices requires an increasing nonce
+	RJCount0        uint16              // Next expected RJcount0 in type 0 and 2 Rejoin-requests. Reset when the device joins
+	RJCount1        uint16              // Next expected RJcount1 in type 1 Rejoin-requests
+	AppEUI          protocol.EUI        // The application associated with the device. Set by storage backend
+	State           DeviceState         // Current state of the device
+	Class           DeviceClass         // Device class. Class B devices get downlinks in the next ping slot, class C devices as soon as they are scheduled
+	FCntUp          uint32              // Frame counter up (from device)
+	FCntDn          uint32              // Frame counter down (to device)
+	RelaxedCounter  bool                // Relaxed frame count checks
+	DevNonceHistory []uint16            // Log of DevNonces sent from the device
+	KeyWarning      bool                // Duplicate key warning flag
+	DataRate        uint8               // Uplink data rate. Set when the device acknowledges a LinkADRReq
+	TXPower         uint8               // TX power index. Set when the device acknowledges a LinkADRReq
+	NbTrans         uint8               // Number of transmissions per uplink frame. Set when the device acknowledges a LinkADRReq
+	BatteryLevel    uint8               // Battery level from the last DevStatusAns. See protocol.MACDevStatusAns
+	DeviceMargin    int8                // Demodulation margin (in dB) from the last DevStatusAns
+	StatusTime      int64               // Time of the last DevStatusAns (in ns). 0 if the device hasn't reported its status
+	RXSettings      RXSettings          // Receive window settings used by the device
+	RequestedRX     RXSettings          // Receive window settings requested for the device. Sent to the device when they differ from RXSettings
+	Channels        ChannelList         // Channels used by the device. Empty if the device only uses the band's mandatory channels
+	PingSlot        PingSlotSettings    // Class B ping slot settings used by the device
+	RequestedPing   PingSlotSettings    // Ping slot data rate and frequency requested for the device. Sent to the device when they differ from PingSlot
+	PendingSession  *PendingSession     // Session sent to the device in a JoinAccept for a Rejoin-request. Nil if there's no pending session
 	Tags
+
+	// GenAppKey is the root key for the multicast keys of LoRaWAN 1.0
+	// devices. LoRaWAN 1.1 devices use the AppKey.
+	GenAppKey protocol.AESKey
 }
 
 // NewDevice creates a new device
 func NewDevice() Device {
-	return Device{Tags: NewTags()}
+	return Device{NbTrans: 1, RXSettings: DefaultRXSettings(), RequestedRX: DefaultRXSettings(), Tags: NewTags()}
 }
 
 // GetRX1Window returns the 1st receive window for the device
-// BUG(stlaehd): Returns constant. Should be set based on device settings and frequency plan.
 func (d *Device) GetRX1Window() time.Duration {
-	return time.Second * 1
+	return d.RXSettings.RX1Window()
 }
 
 // GetRX2Window returns the 2nd receive window for the device
-// BUG(stalehd): Returns a constant. Should be set based on frequency plan (EU, US, CN)
 func (d *Device) GetRX2Window() time.Duration {
-	return time.Second * 2
+	return d.RXSettings.RX2Window()
+}
+
+// BatteryPercent returns the battery level (in percent) from the last status
+// report. The boolean flag is false if the device hasn't reported its status,
+// uses an external power source or is unable to measure the battery level.
+func (d *Device) BatteryPercent() (int, bool) {
+	if d.StatusTime == 0 || d.BatteryLevel == protocol.BatteryExternalPower || d.BatteryLevel == protocol.BatteryUnavailable {
+		return 0, false
+	}
+	return int(d.BatteryLevel) * 100 / 254, true
 }
 
 // HasDevNonce returns true if the specified nonce exists in the nonce history
@@ -197,6 +347,17 @@ type Gateway struct {
 	Longitude  float32      // Longitude, in decimal degrees, positive E [-180-180>
 	Altitude   float32      // Altitude, meters
 	Tags
+
+	// Band is the frequency plan the gateway uses. SubBand is the sub-band
+	// (1-8 or 1-12) for bands with a fixed channel plan. 0 means all channels.
+	// Devices joining through US915 and AU915 gateways are limited to the
+	// gateway's sub-band.
+	Band    band.FrequencyBandType
+	SubBand uint8
+
+	// StationToken is the token a LoRa Basics Station must send when it
+	// connects as the gateway. Stations can't connect if it is empty.
+	StationToken string
 }
 
 // NewGateway creates a new gateway
@@ -212,9 +373,31 @@ func (g *Gateway) Equals(other Gateway) bool {
 		g.Latitude == other.Latitude &&
 		g.Longitude == other.Longitude &&
 		g.StrictIP == other.StrictIP &&
+		g.Band == other.Band &&
+		g.SubBand == other.SubBand &&
+		g.StationToken == other.StationToken &&
 		g.Tags.Equals(other.Tags)
 }
 
+// GatewayStatus is a status report from a gateway. The counters are for the
+// interval since the previous report.
+type GatewayStatus struct {
+	Received         time.Time // The time the server received the report
+	GatewayTime      time.Time // The gateway's system time. Zero if it isn't reported
+	RXReceived       uint32    // Number of radio packets received
+	RXOK             uint32    // Number of radio packets received with a valid CRC
+	RXForwarded      uint32    // Number of radio packets forwarded to the server
+	ACKRatio         float32   // Percentage of upstream datagrams that were acknowledged
+	DownlinkReceived uint32    // Number of downlinks received by the gateway
+	TXEmitted        uint32    // Number of packets sent by the gateway
+
+	// The location is only reported by gateways with a GPS
+	HasLocation bool
+	Latitude    float32 // Latitude, in decimal degrees, positive N
+	Longitude   float32 // Longitude, in decimal degrees, positive E
+	Altitude    float32 // Altitude, meters
+}
+
 // APIToken represents an API token that the users can use to access the
 // API. There are two basic roles -- "read" and "write", set by the
 // ReadOnly flag.
@@ -338,27 +521,78 @@ const (
 	UnsentState DownstreamMessageState = iota
 	SentState
 	AcknowledgedState
+	ExpiredState
+	FailedState
 )
 
+// String converts the message state into a human-readable string representation.
+func (s DownstreamMessageState) String() string {
+	switch s {
+	case UnsentState:
+		return "UNSENT"
+	case SentState:
+		return "SENT"
+	case AcknowledgedState:
+		return "ACKNOWLEDGED"
+	case ExpiredState:
+		return "EXPIRED"
+	case FailedState:
+		return "FAILED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
 // DownstreamMessage is messages sent downstream (ie to devices from the server).
+// Each device has a queue of downstream messages. The ID identifies the
+// message in the queue.
 type DownstreamMessage struct {
+	ID        uint64
 	DeviceEUI protocol.EUI
 	Data      string
 	Port      uint8
+	// FCnt is the frame counter the payload is encrypted with when the
+	// application encrypts the payloads end-to-end.
+	FCnt uint32
+	// Priority is the message's priority in the queue. Messages with a
+	// higher priority are sent first.
+	Priority uint8
 
 	Ack         bool
 	CreatedTime int64
 	SentTime    int64
 	AckTime     int64
+	// TXError is the error reported by the gateway the last time the
+	// message couldn't be sent. It is empty if there's no error.
+	TXError string
+	// Attempts is the number of times the message has been sent to the
+	// device without being acknowledged.
+	Attempts uint8
+	// ExpiresTime is the time when the message expires. The message isn't
+	// sent after this. The message never expires if this is 0.
+	ExpiresTime int64
+	// FailedTime is the time the server gave up sending the message, either
+	// because it expired or because the device never acknowledged it.
+	FailedTime int64
+	// RXWindow is the receive window (1 or 2) the message was last sent in.
+	// It is 0 if the message hasn't been sent.
+	RXWindow uint8
 }
 
 // NewDownstreamMessage creates a new DownstreamMessage
 func NewDownstreamMessage(deviceEUI protocol.EUI, port uint8) DownstreamMessage {
-	return DownstreamMessage{deviceEUI, "", port, false, time.Now().Unix(), 0, 0}
+	return DownstreamMessage{newDownstreamID(), deviceEUI, "", port, 0, 0, false, time.Now().Unix(), 0, 0, "", 0, 0, 0, 0}
 }
 
 // State returns the message's state based on the value of the time stamps
 func (d *DownstreamMessage) State() DownstreamMessageState {
+	// The server has given up on the message
+	if d.FailedTime != 0 {
+		if d.ExpiresTime != 0 && d.FailedTime >= d.ExpiresTime {
+			return ExpiredState
+		}
+		return FailedState
+	}
 	// Sent time isn't updated => message is still pending
 	if d.SentTime == 0 {
 		return UnsentState
@@ -371,6 +605,11 @@ func (d *DownstreamMessage) State() DownstreamMessageState {
 	return AcknowledgedState
 }
 
+// HasExpired returns true if the message has an expiry time and it has passed
+func (d *DownstreamMessage) HasExpired(now time.Time) bool {
+	return d.ExpiresTime != 0 && now.Unix() >= d.ExpiresTime
+}
+
 // Payload returns the payload as a byte array. If there's an error decoding the
 // data it will return an empty byte array
 func (d *DownstreamMessage) Payload() []byte {
@@ -385,8 +624,12 @@ func (d *DownstreamMessage) Payload() []byte {
 // IsComplete returns true if the message processing is completed. If the ack
 // flag isn't set the message would only have to be sent to the device. If the
 // ack flag is set the device must acknowledge the message before it is
-// considered completed.
+// considered completed. Expired and failed messages are also completed.
 func (d *DownstreamMessage) IsComplete() bool {
+	// Message have expired or failed
+	if d.FailedTime != 0 {
+		return true
+	}
 	// Message haven't been sent yet
 	if d.SentTime == 0 {
 		return false
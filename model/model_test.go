@@ -22,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/protocol"
 )
 
@@ -49,13 +50,47 @@ func TestDeviceStateConversion(t *testing.T) {
 }
 
 func TestRXWindows(t *testing.T) {
-	// These values are hard coded. The *real* test will use the device's settings
+	// Devices without settings should use the default 1 second delay
 	device := Device{}
 	if device.GetRX1Window() != (time.Second * 1) {
-		t.Error("Someone must have fixed the GetRX1Window func but not the test")
+		t.Error("Expected 1 second delay for RX1 with no settings")
 	}
 	if device.GetRX2Window() != (time.Second * 2) {
-		t.Error("Someone must have fixed the GetRX2Window func but not the test")
+		t.Error("Expected 2 second delay for RX2 with no settings")
+	}
+
+	device.RXSettings.RX1Delay = 5
+	if device.GetRX1Window() != (time.Second*5) || device.GetRX2Window() != (time.Second*6) {
+		t.Errorf("Unexpected RX windows: %v, %v", device.GetRX1Window(), device.GetRX2Window())
+	}
+
+	eu, _ := band.NewBand(band.EU868Band)
+	if p := device.RXSettings.RX2Parameters(eu); p != eu.GetRX2Parameters() {
+		t.Errorf("Expected band defaults for RX2 but got %+v", p)
+	}
+	device.RXSettings.RX2Frequency = 869.1
+	device.RXSettings.RX2DataRate = 3
+	if p := device.RXSettings.RX2Parameters(eu); p.Frequency != 869.1 || p.DataRate != 3 {
+		t.Errorf("Expected device settings for RX2 but got %+v", p)
+	}
+}
+
+func TestPingSlotParameters(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	device := NewDevice()
+	if p := device.PingSlot.Parameters(eu, 0, device.DevAddr); p.Frequency != 869.525 || p.DataRate != 3 {
+		t.Errorf("Expected band defaults for ping slots but got %+v", p)
+	}
+	device.PingSlot.Frequency = 869.1
+	device.PingSlot.DataRate = 2
+	if p := device.PingSlot.Parameters(eu, 0, device.DevAddr); p.Frequency != 869.1 || p.DataRate != 2 {
+		t.Errorf("Expected device settings for ping slots but got %+v", p)
+	}
+
+	for _, c := range []DeviceClass{ClassA, ClassB, ClassC} {
+		if n, err := DeviceClassFromString(c.String()); err != nil || n != c {
+			t.Errorf("Could not convert class %s: %v", c, err)
+		}
 	}
 }
 
@@ -71,6 +106,25 @@ func TestDevNonce(t *testing.T) {
 	}
 }
 
+func TestBatteryPercent(t *testing.T) {
+	d := Device{BatteryLevel: 127}
+	if _, ok := d.BatteryPercent(); ok {
+		t.Fatal("Device without status should not report battery level")
+	}
+	d.StatusTime = 1
+	if p, ok := d.BatteryPercent(); !ok || p != 50 {
+		t.Fatalf("Expected 50%% but got %d (ok=%t)", p, ok)
+	}
+	d.BatteryLevel = protocol.BatteryExternalPower
+	if _, ok := d.BatteryPercent(); ok {
+		t.Fatal("Device on external power should not report battery level")
+	}
+	d.BatteryLevel = protocol.BatteryUnavailable
+	if _, ok := d.BatteryPercent(); ok {
+		t.Fatal("Device that can't measure battery level should not report battery level")
+	}
+}
+
 func TestDeviceDataCompare(t *testing.T) {
 	d1 := DeviceData{DeviceEUI: protocol.EUIFromUint64(0), Data: []byte{1, 2, 3}, Frequency: 99.0, GatewayEUI: protocol.EUIFromUint64(1)}
 	d2 := DeviceData{DeviceEUI: protocol.EUIFromUint64(1), Data: []byte{1, 2, 3}, Frequency: 98.0, GatewayEUI: protocol.EUIFromUint64(1)}
@@ -207,6 +261,33 @@ func TestDownstreamMessage(t *testing.T) {
 		t.Fatal("Expected message to be completed and acknowledged state")
 	}
 
+	if msg.HasExpired(time.Now()) {
+		t.Fatal("Message without expiry time should never expire")
+	}
+
+	// Message is given up before it expires: failed
+	msg.AckTime = 0
+	msg.ExpiresTime = time.Now().Add(time.Hour).Unix()
+	msg.FailedTime = time.Now().Unix()
+	if !msg.IsComplete() || msg.State() != FailedState {
+		t.Fatal("Expected message to be completed and in failed state")
+	}
+	if msg.HasExpired(time.Now()) || !msg.HasExpired(time.Now().Add(2*time.Hour)) {
+		t.Fatal("Expiry time isn't checked")
+	}
+
+	// Message is given up after it expires: expired
+	msg.FailedTime = msg.ExpiresTime
+	if !msg.IsComplete() || msg.State() != ExpiredState {
+		t.Fatal("Expected message to be completed and in expired state")
+	}
+
+	for _, s := range []DownstreamMessageState{UnsentState, SentState, AcknowledgedState, ExpiredState, FailedState} {
+		if s.String() == "UNKNOWN" {
+			t.Fatalf("State %d has no string representation", s)
+		}
+	}
+
 	msg.Data = "010203040506070809"
 	if !reflect.DeepEqual(msg.Payload(), []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}) {
 		t.Fatal("Not the payload I expected")
@@ -0,0 +1,109 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// MulticastGroup is a group of devices that share a multicast address and a
+// set of session keys. Downlinks to the group are sent once through each of
+// the group's gateways in the class B ping slots or the class C receive window
+// for the group. The devices in the group are set up by the application,
+// typically with the Remote Multicast Setup messages.
+type MulticastGroup struct {
+	GroupEUI    protocol.EUI     // Unique identifier for the group
+	AppEUI      protocol.EUI     // The application the group belongs to
+	McAddr      protocol.DevAddr // Multicast address
+	McNwkSKey   protocol.AESKey  // Multicast network session key
+	McAppSKey   protocol.AESKey  // Multicast application session key
+	FCntDn      uint32           // Frame counter for the next downlink to the group
+	Class       DeviceClass      // Class B or class C. Class A groups can't receive downlinks
+	DataRate    uint8            // Data rate for the downlinks. Not used if Frequency is 0
+	Frequency   float32          // Frequency (in MHz) for the downlinks. 0 means the band's default frequency and data rate
+	Periodicity uint8            // Ping slot periodicity for class B groups
+	Gateways    []protocol.EUI   // The gateways that send the downlinks to the group
+	Tags
+}
+
+// NewMulticastGroup creates a new multicast group
+func NewMulticastGroup() MulticastGroup {
+	return MulticastGroup{Class: ClassC, Gateways: make([]protocol.EUI, 0), Tags: NewTags()}
+}
+
+// Parameters returns the data rate and frequency for a downlink to the group.
+// Class C groups use the second receive window parameters and class B groups
+// use the ping slot parameters for the beacon period that starts at
+// beaconTime (GPS time in seconds).
+func (g *MulticastGroup) Parameters(plan band.FrequencyPlan, beaconTime uint32) band.DownlinkParameters {
+	if g.Class == ClassB {
+		settings := PingSlotSettings{Periodicity: g.Periodicity, DataRate: g.DataRate, Frequency: g.Frequency}
+		return settings.Parameters(plan, beaconTime, g.McAddr)
+	}
+	settings := RXSettings{RX2DataRate: g.DataRate, RX2Frequency: g.Frequency}
+	return settings.RX2Parameters(plan)
+}
+
+// MulticastMessage is a downlink message to a multicast group. Multicast
+// messages are never acknowledged.
+type MulticastMessage struct {
+	GroupEUI    protocol.EUI
+	Data        string
+	Port        uint8
+	CreatedTime int64
+	SentTime    int64
+}
+
+// NewMulticastMessage creates a new MulticastMessage
+func NewMulticastMessage(groupEUI protocol.EUI, port uint8) MulticastMessage {
+	return MulticastMessage{GroupEUI: groupEUI, Port: port, CreatedTime: time.Now().Unix()}
+}
+
+// Payload returns the payload as a byte array. If there's an error decoding the
+// data it will return an empty byte array
+func (m *MulticastMessage) Payload() []byte {
+	ret, err := hex.DecodeString(m.Data)
+	if err != nil {
+		logging.Warning("Unable to decode data to be sent to multicast group %s (data=%s). Ignoring it.", m.GroupEUI, m.Data)
+		return []byte{}
+	}
+	return ret
+}
+
+// IsComplete returns true if the message has been sent to the group
+func (m *MulticastMessage) IsComplete() bool {
+	return m.SentTime != 0
+}
+
+// MulticastDelivery is a single attempt at sending a multicast message
+// through one of the group's gateways.
+type MulticastDelivery struct {
+	GroupEUI   protocol.EUI // The multicast group
+	GatewayEUI protocol.EUI // The gateway used for the downlink
+	FCnt       uint32       // Frame counter for the downlink
+	Time       int64        // Time of the attempt (in ns)
+	Error      string       // Reason the message couldn't be sent. Empty if the message was sent
+}
+
+// Sent returns true if the message was sent to the gateway
+func (d *MulticastDelivery) Sent() bool {
+	return d.Error == ""
+}
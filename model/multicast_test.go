@@ -0,0 +1,71 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func TestMulticastParameters(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	group := NewMulticastGroup()
+	if p := group.Parameters(eu, 0); p != eu.GetRX2Parameters() {
+		t.Errorf("Expected RX2 defaults for class C group but got %+v", p)
+	}
+	group.Class = ClassB
+	if p := group.Parameters(eu, 0); p.Frequency != 869.525 || p.DataRate != 3 {
+		t.Errorf("Expected ping slot defaults for class B group but got %+v", p)
+	}
+	group.Frequency = 869.1
+	group.DataRate = 2
+	for _, c := range []DeviceClass{ClassB, ClassC} {
+		group.Class = c
+		if p := group.Parameters(eu, 0); p.Frequency != 869.1 || p.DataRate != 2 {
+			t.Errorf("Expected group settings for class %s but got %+v", c, p)
+		}
+	}
+}
+
+func TestMulticastMessage(t *testing.T) {
+	msg := NewMulticastMessage(protocol.EUIFromUint64(1), 42)
+	msg.Data = "010203"
+	if msg.IsComplete() {
+		t.Error("New message shouldn't be complete")
+	}
+	if p := msg.Payload(); len(p) != 3 || p[2] != 3 {
+		t.Errorf("Unexpected payload: %v", p)
+	}
+	msg.SentTime = 1
+	if !msg.IsComplete() {
+		t.Error("Sent message should be complete")
+	}
+	msg.Data = "zz"
+	if len(msg.Payload()) != 0 {
+		t.Error("Expected empty payload for invalid data")
+	}
+
+	delivery := MulticastDelivery{}
+	if !delivery.Sent() {
+		t.Error("Expected delivery without error to be sent")
+	}
+	delivery.Error = "busy"
+	if delivery.Sent() {
+		t.Error("Expected delivery with error to fail")
+	}
+}
@@ -0,0 +1,158 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/json"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// PendingSession is the security context sent to a LoRaWAN 1.1 device in a
+// JoinAccept message for a Rejoin-request. The device keeps using the current
+// context until it receives the JoinAccept so both are kept until the first
+// uplink that verifies with the new keys [6.2.4.4]. Type 0 and 1
+// Rejoin-requests reset the radio settings as well.
+type PendingSession struct {
+	NwkSKey     protocol.AESKey // FNwkSIntKey
+	SNwkSIntKey protocol.AESKey
+	NwkSEncKey  protocol.AESKey
+	AppSKey     protocol.AESKey
+
+	// Radio settings. Only used when ResetRadio is set.
+	ResetRadio bool
+	DataRate   uint8
+	TXPower    uint8
+	NbTrans    uint8
+	RXSettings RXSettings
+	Channels   ChannelList
+}
+
+// NewPendingSession creates a pending session with the keys and radio
+// settings of the device.
+func NewPendingSession(device Device, resetRadio bool) *PendingSession {
+	ret := &PendingSession{
+		NwkSKey:     device.NwkSKey,
+		SNwkSIntKey: device.SNwkSIntKey,
+		NwkSEncKey:  device.NwkSEncKey,
+		AppSKey:     device.AppSKey,
+		ResetRadio:  resetRadio,
+	}
+	if resetRadio {
+		ret.DataRate = device.DataRate
+		ret.TXPower = device.TXPower
+		ret.NbTrans = device.NbTrans
+		ret.RXSettings = device.RXSettings
+		ret.Channels = append(ChannelList(nil), device.Channels...)
+	}
+	return ret
+}
+
+// pendingSessionJSON is the JSON representation of the pending session. The
+// keys are stored as hex strings, like the other device keys.
+type pendingSessionJSON struct {
+	NwkSKey     string      `json:"nwkSKey"`
+	SNwkSIntKey string      `json:"sNwkSIntKey"`
+	NwkSEncKey  string      `json:"nwkSEncKey"`
+	AppSKey     string      `json:"appSKey"`
+	ResetRadio  bool        `json:"resetRadio"`
+	DataRate    uint8       `json:"dataRate"`
+	TXPower     uint8       `json:"txPower"`
+	NbTrans     uint8       `json:"nbTrans"`
+	RXSettings  RXSettings  `json:"rxSettings"`
+	Channels    ChannelList `json:"channels"`
+}
+
+// JSON returns the pending session formatted as JSON. Nil sessions are
+// returned as nil.
+func (p *PendingSession) JSON() []byte {
+	if p == nil {
+		return nil
+	}
+	buf, _ := json.Marshal(pendingSessionJSON{
+		NwkSKey:     p.NwkSKey.String(),
+		SNwkSIntKey: p.SNwkSIntKey.String(),
+		NwkSEncKey:  p.NwkSEncKey.String(),
+		AppSKey:     p.AppSKey.String(),
+		ResetRadio:  p.ResetRadio,
+		DataRate:    p.DataRate,
+		TXPower:     p.TXPower,
+		NbTrans:     p.NbTrans,
+		RXSettings:  p.RXSettings,
+		Channels:    p.Channels,
+	})
+	return buf
+}
+
+// NewPendingSessionFromBuffer unmarshals a JSON object into a pending session.
+// Empty buffers return nil.
+func NewPendingSessionFromBuffer(buf []byte) (*PendingSession, error) {
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	var tmp pendingSessionJSON
+	if err := json.Unmarshal(buf, &tmp); err != nil {
+		return nil, err
+	}
+	ret := &PendingSession{
+		ResetRadio: tmp.ResetRadio,
+		DataRate:   tmp.DataRate,
+		TXPower:    tmp.TXPower,
+		NbTrans:    tmp.NbTrans,
+		RXSettings: tmp.RXSettings,
+		Channels:   tmp.Channels,
+	}
+	var err error
+	if ret.NwkSKey, err = protocol.AESKeyFromString(tmp.NwkSKey); err != nil {
+		return nil, err
+	}
+	if ret.SNwkSIntKey, err = protocol.AESKeyFromString(tmp.SNwkSIntKey); err != nil {
+		return nil, err
+	}
+	if ret.NwkSEncKey, err = protocol.AESKeyFromString(tmp.NwkSEncKey); err != nil {
+		return nil, err
+	}
+	if ret.AppSKey, err = protocol.AESKeyFromString(tmp.AppSKey); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// WithPendingSession returns a copy of the device that uses the pending
+// session. The frame counters and the RJcount0 are reset, as when the device
+// joins. The flag is false if the device has no pending session.
+func (d Device) WithPendingSession() (Device, bool) {
+	p := d.PendingSession
+	if p == nil {
+		return d, false
+	}
+	d.NwkSKey = p.NwkSKey
+	d.SNwkSIntKey = p.SNwkSIntKey
+	d.NwkSEncKey = p.NwkSEncKey
+	d.AppSKey = p.AppSKey
+	d.FCntUp = 0
+	d.FCntDn = 0
+	d.RJCount0 = 0
+	if p.ResetRadio {
+		d.DataRate = p.DataRate
+		d.TXPower = p.TXPower
+		d.NbTrans = p.NbTrans
+		d.RXSettings = p.RXSettings
+		d.Channels = append(ChannelList(nil), p.Channels...)
+	}
+	d.PendingSession = nil
+	return d, true
+}
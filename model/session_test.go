@@ -0,0 +1,79 @@
+package model
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"testing"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func TestPendingSession(t *testing.T) {
+	device := NewDevice()
+	device.NwkSKey = protocol.AESKey{Key: [16]byte{1}}
+	device.SNwkSIntKey = protocol.AESKey{Key: [16]byte{2}}
+	device.NwkSEncKey = protocol.AESKey{Key: [16]byte{3}}
+	device.AppSKey = protocol.AESKey{Key: [16]byte{4}}
+	device.DataRate = 3
+	device.Channels = ChannelList{{Frequency: 868.1}}
+
+	if _, ok := device.WithPendingSession(); ok {
+		t.Fatal("Did not expect pending session")
+	}
+	if device.PendingSession.JSON() != nil {
+		t.Fatal("Expected nil JSON for nil session")
+	}
+	if p, err := NewPendingSessionFromBuffer(nil); p != nil || err != nil {
+		t.Fatalf("Expected nil session for empty buffer: %v", err)
+	}
+
+	pending := NewPendingSession(device, true)
+	p, err := NewPendingSessionFromBuffer(pending.JSON())
+	if err != nil {
+		t.Fatal("Unable to decode pending session: ", err)
+	}
+	if p.NwkSKey != device.NwkSKey || p.SNwkSIntKey != device.SNwkSIntKey || p.NwkSEncKey != device.NwkSEncKey ||
+		p.AppSKey != device.AppSKey || !p.ResetRadio || p.DataRate != 3 || !p.Channels.Equals(device.Channels) {
+		t.Fatalf("Unexpected pending session: %+v", p)
+	}
+	if _, err := NewPendingSessionFromBuffer([]byte(`{"nwkSKey":"xyz"}`)); err == nil {
+		t.Fatal("Expected error with invalid key")
+	}
+
+	current := NewDevice()
+	current.FCntUp = 10
+	current.FCntDn = 20
+	current.RJCount0 = 2
+	current.DataRate = 5
+	current.PendingSession = p
+	switched, ok := current.WithPendingSession()
+	if !ok || switched.PendingSession != nil || switched.NwkSKey != device.NwkSKey || switched.AppSKey != device.AppSKey {
+		t.Fatalf("Expected device with new session: %+v", switched)
+	}
+	if switched.FCntUp != 0 || switched.FCntDn != 0 || switched.RJCount0 != 0 || switched.DataRate != 3 {
+		t.Fatalf("Expected reset counters and radio settings: %+v", switched)
+	}
+	if current.PendingSession == nil || current.FCntUp != 10 {
+		t.Fatal("The original device should not change")
+	}
+
+	// The radio settings are kept for type 2 Rejoin-requests
+	current.PendingSession = NewPendingSession(device, false)
+	if switched, _ = current.WithPendingSession(); switched.DataRate != 5 {
+		t.Fatalf("Expected radio settings to be kept: %+v", switched)
+	}
+}
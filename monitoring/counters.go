@@ -17,6 +17,7 @@ package monitoring
 //
 import (
 	"expvar"
+	"time"
 )
 
 type timeseriesCounter struct {
@@ -57,6 +58,12 @@ func (h *histogramCounter) Add(value float64) {
 	h.gauge.Add(value)
 }
 
+// Average returns the average of the recent samples as a duration. The
+// timing counters are in microseconds.
+func (h *histogramCounter) Average() time.Duration {
+	return time.Duration(h.gauge.Calculate().Average * float64(time.Microsecond))
+}
+
 func newHistogramCounter(name string) *histogramCounter {
 	ret := &histogramCounter{name, NewHistogram(), NewAverageGauge(1000)}
 	ret.init()
@@ -82,9 +89,13 @@ var (
 	LoRaJoinRequest     *timeseriesCounter // Received by server
 	LoRaJoinAccept      *timeseriesCounter // Sent by server
 	LoRaCounterFailed   *timeseriesCounter // Rejected frame counter
+	DownlinkRX1         *timeseriesCounter // Sent in the first receive window
+	DownlinkRX2         *timeseriesCounter // Sent in the second receive window
+	DownlinkFailed      *timeseriesCounter // Rejected by the gateway
 	GatewayIn           *timeseriesCounter
 	GatewayOut          *timeseriesCounter
 	Decoder             *timeseriesCounter
+	Deduplicator        *timeseriesCounter
 	Decrypter           *timeseriesCounter
 	MACProcessor        *timeseriesCounter
 	SchedulerIn         *timeseriesCounter
@@ -92,7 +103,8 @@ var (
 	Encoder             *timeseriesCounter
 
 	GatewayChannelOut      *histogramCounter // Time to send message to decoder
-	DecoderChannelOut      *histogramCounter // Time to send message to decrypter
+	DecoderChannelOut      *histogramCounter // Time to send message to deduplicator
+	DeduplicatorChannelOut *histogramCounter // Time to send message to decrypter
 	DecrypterChannelOut    *histogramCounter // Time to send message to MAC processor
 	MACProcessorChannelOut *histogramCounter // Time to send message to scheduler
 	SchedulerChannelOut    *histogramCounter // Time to send message to encoder
@@ -131,9 +143,13 @@ func init() {
 	LoRaUnconfirmedDown = newTimeseriesCounter("lora.msg.unconfirmeddown")
 	LoRaJoinRequest = newTimeseriesCounter("lora.msg.joinrequest")
 	LoRaJoinAccept = newTimeseriesCounter("lora.msg.joinaccept")
+	DownlinkRX1 = newTimeseriesCounter("lora.downlink.rx1")
+	DownlinkRX2 = newTimeseriesCounter("lora.downlink.rx2")
+	DownlinkFailed = newTimeseriesCounter("lora.downlink.failed")
 	GatewayIn = newTimeseriesCounter("process.gateway.in")
 	GatewayOut = newTimeseriesCounter("process.gateway.out")
 	Decoder = newTimeseriesCounter("process.decoder")
+	Deduplicator = newTimeseriesCounter("process.deduplicator")
 	Decrypter = newTimeseriesCounter("process.decrypter")
 	MACProcessor = newTimeseriesCounter("process.macprocessor")
 	SchedulerIn = newTimeseriesCounter("process.scheduler.in")
@@ -142,6 +158,7 @@ func init() {
 
 	GatewayChannelOut = newHistogramCounter("gwif.channel.send")
 	DecoderChannelOut = newHistogramCounter("decoder.channel.send")
+	DeduplicatorChannelOut = newHistogramCounter("deduplicator.channel.send")
 	DecrypterChannelOut = newHistogramCounter("decrypter.channel.send")
 	MACProcessorChannelOut = newHistogramCounter("macprocessor.channel.send")
 	EncoderChannelOut = newHistogramCounter("encoder.channel.send")
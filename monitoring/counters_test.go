@@ -15,7 +15,10 @@ package monitoring
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 // Run through the test. Unless there are error messages in the test the counter
 // will be available in the application
@@ -37,9 +40,13 @@ func TestCounterIncrement(t *testing.T) {
 	LoRaJoinRequest.Increment()
 	LoRaJoinAccept.Increment()
 	LoRaCounterFailed.Increment()
+	DownlinkRX1.Increment()
+	DownlinkRX2.Increment()
+	DownlinkFailed.Increment()
 	GatewayIn.Increment()
 	GatewayOut.Increment()
 	Decoder.Increment()
+	Deduplicator.Increment()
 	Decrypter.Increment()
 	MACProcessor.Increment()
 	SchedulerIn.Increment()
@@ -48,6 +55,7 @@ func TestCounterIncrement(t *testing.T) {
 
 	GatewayChannelOut.Add(1.0)
 	DecoderChannelOut.Add(1.0)
+	DeduplicatorChannelOut.Add(1.0)
 	DecrypterChannelOut.Add(1.0)
 	MACProcessorChannelOut.Add(1.0)
 	SchedulerChannelOut.Add(1.0)
@@ -65,3 +73,15 @@ func TestCounterIncrement(t *testing.T) {
 	TimeIncoming.Add(3.0)
 	TimeOutgoing.Add(3.0)
 }
+
+func TestHistogramCounterAverage(t *testing.T) {
+	c := newHistogramCounter("test.average")
+	if c.Average() != 0 {
+		t.Fatalf("Expected 0 for an empty counter but got %v", c.Average())
+	}
+	c.Add(1000.0)
+	c.Add(3000.0)
+	if c.Average() != 2*time.Millisecond {
+		t.Fatalf("Expected 2ms but got %v", c.Average())
+	}
+}
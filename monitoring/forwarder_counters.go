@@ -0,0 +1,72 @@
+package monitoring
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"expvar"
+	"sync"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// Internal type to keep track of the gateway forwarder counters. The counters
+// are published through expvar as "forwarders"
+type forwarderCounterList struct {
+	counters map[string]*MessageCounter
+	mutex    *sync.Mutex
+}
+
+// Get returns the counters for a forwarder
+func (f *forwarderCounterList) Get(name string) *MessageCounter {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret, exists := f.counters[name]
+	if !exists {
+		ret = NewMessageCounter(protocol.EUI{})
+		f.counters[name] = ret
+	}
+	return ret
+}
+
+// List returns a copy of all the forwarder counters
+func (f *forwarderCounterList) List() map[string]*MessageCounter {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := make(map[string]*MessageCounter)
+	for k, v := range f.counters {
+		ret[k] = v
+	}
+	return ret
+}
+
+func newForwarderCounterList() forwarderCounterList {
+	return forwarderCounterList{make(map[string]*MessageCounter), &sync.Mutex{}}
+}
+
+var fwdCounters = newForwarderCounterList()
+
+func init() {
+	expvar.Publish("forwarders", expvar.Func(func() interface{} {
+		return fwdCounters.List()
+	}))
+}
+
+// GetForwarderCounters returns the counters for the gateway forwarder with
+// the name. MessagesIn is the number of uplinks received from the forwarder
+// and MessagesOut is the number of downlinks sent to it.
+func GetForwarderCounters(name string) *MessageCounter {
+	return fwdCounters.Get(name)
+}
@@ -0,0 +1,42 @@
+package monitoring
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestForwarderCounters(t *testing.T) {
+	c1 := GetForwarderCounters("udp-1")
+	c2 := GetForwarderCounters("udp-2")
+	if c1 == c2 {
+		t.Fatal("Expected different counters for forwarders")
+	}
+	if GetForwarderCounters("udp-1") != c1 {
+		t.Fatal("Expected the same counters for the same forwarder")
+	}
+	c1.MessagesIn.Increment()
+
+	data := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(expvar.Get("forwarders").String()), &data); err != nil {
+		t.Fatalf("Couldn't unmarshal forwarder counters: %v", err)
+	}
+	if _, ok := data["udp-1"]; !ok {
+		t.Fatalf("Forwarder counters are missing (data=%v)", data)
+	}
+}
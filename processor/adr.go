@@ -0,0 +1,257 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"errors"
+	"sync"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+const (
+	// ADRHistoryLength is the number of uplinks the ADR engine uses when
+	// calculating new settings for a device.
+	ADRHistoryLength = 20
+	// adrInstallationMargin is the margin (in dB) kept on top of the
+	// demodulation floor.
+	adrInstallationMargin = 10.0
+	// adrStepSize is the margin (in dB) for each data rate or TX power step.
+	adrStepSize = 3.0
+	// adrPendingLimit is the number of uplinks to wait for a LinkADRAns before
+	// the settings are calculated again.
+	adrPendingLimit = 4
+	// adrChMaskCntlAllOn is the ChMaskCntl value that keeps all of the defined
+	// channels enabled [7.1.5], [7.2.5]
+	adrChMaskCntlAllOn = 6
+)
+
+// adrSettings is the data rate, TX power and number of transmissions used by a device.
+type adrSettings struct {
+	DataRate uint8
+	TXPower  uint8
+	NbTrans  uint8
+}
+
+// pendingADR is a LinkADRReq sent to the device that hasn't been answered yet.
+type pendingADR struct {
+	settings adrSettings
+	uplinks  int
+}
+
+// maxTXPowerIndex returns the highest TX power index for the band. Higher
+// indexes means lower power.
+func maxTXPowerIndex(plan band.FrequencyPlan) uint8 {
+	var i uint8
+	for ; i < 0x0F; i++ {
+		if _, err := plan.TxPower(i + 1); err != nil {
+			return i
+		}
+	}
+	return i
+}
+
+// packetLoss returns the ratio of lost frames in the samples, based on the
+// frame counters.
+func packetLoss(samples []server.UplinkSample) float32 {
+	if len(samples) < 2 {
+		return 0
+	}
+	expected := samples[len(samples)-1].FCnt - samples[0].FCnt + 1
+	if int(expected) <= len(samples) {
+		return 0
+	}
+	return 1 - float32(len(samples))/float32(expected)
+}
+
+// calculateADR calculates new ADR settings for a device. The margin is
+// calculated from the best SNR in the samples that use the same data rate as
+// the last sample. Each step of 3 dB increases the data rate until the
+// maximum is reached, then lowers the TX power. A negative margin raises the
+// TX power. The number of transmissions is based on the packet loss.
+func calculateADR(plan band.FrequencyPlan, samples []server.UplinkSample, current adrSettings) (adrSettings, error) {
+	if len(samples) == 0 {
+		return current, errors.New("no samples")
+	}
+	last := samples[len(samples)-1]
+	dataRate, err := plan.GetDataRate(last.DataRate)
+	if err != nil {
+		return current, err
+	}
+	encoding, err := plan.Encoding(dataRate)
+	if err != nil {
+		return current, err
+	}
+	floor, err := encoding.RequiredSNR()
+	if err != nil {
+		return current, err
+	}
+
+	maxSNR := last.SNR
+	for _, v := range samples {
+		if v.DataRate == last.DataRate && v.SNR > maxSNR {
+			maxSNR = v.SNR
+		}
+	}
+
+	ret := adrSettings{DataRate: dataRate, TXPower: current.TXPower, NbTrans: 1}
+	maxTXPower := maxTXPowerIndex(plan)
+	if ret.TXPower > maxTXPower {
+		ret.TXPower = maxTXPower
+	}
+
+	steps := int((maxSNR - floor - adrInstallationMargin) / adrStepSize)
+	maxDataRate := plan.Configuration().MaxADRDataRate
+	for steps > 0 && ret.DataRate < maxDataRate {
+		ret.DataRate++
+		steps--
+	}
+	for steps > 0 && ret.TXPower < maxTXPower {
+		ret.TXPower++
+		steps--
+	}
+	for steps < 0 && ret.TXPower > 0 {
+		ret.TXPower--
+		steps++
+	}
+
+	loss := packetLoss(samples)
+	switch {
+	case loss < 0.05:
+		ret.NbTrans = 1
+	case loss < 0.10:
+		ret.NbTrans = 2
+	default:
+		ret.NbTrans = 3
+	}
+	return ret, nil
+}
+
+// adrEngine adjusts the data rate, TX power and number of transmissions for
+// devices that have ADR enabled. It keeps track of the LinkADRReq commands
+// sent to devices until they are answered.
+type adrEngine struct {
+	context *server.Context
+	pending map[protocol.EUI]pendingADR
+	mutex   *sync.Mutex
+}
+
+func newADREngine(context *server.Context) *adrEngine {
+	return &adrEngine{
+		context: context,
+		pending: make(map[protocol.EUI]pendingADR),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// processUplink checks the uplink history for the device and schedules a
+// LinkADRReq if the device should change its settings. Devices that set the
+// ADRACKReq flag will get a LinkADRReq regardless of the length of the history.
+func (a *adrEngine) processUplink(msg server.LoRaMessage) {
+	fctrl := msg.Payload.MACPayload.FHDR.FCtrl
+	if !fctrl.ADR {
+		return
+	}
+	device := msg.FrameContext.Device
+	a.context.FrameOutput.SetADRFlag(device.DeviceEUI, true)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if p, exists := a.pending[device.DeviceEUI]; exists {
+		p.uplinks++
+		if p.uplinks < adrPendingLimit && !fctrl.ADRACKReq {
+			a.pending[device.DeviceEUI] = p
+			return
+		}
+		logging.Info("No LinkADRAns from device %s after %d uplinks", device.DeviceEUI, p.uplinks)
+		delete(a.pending, device.DeviceEUI)
+	}
+
+	samples := a.context.UplinkHistory.Samples(device.DeviceEUI)
+	if len(samples) == 0 || (len(samples) < ADRHistoryLength && !fctrl.ADRACKReq) {
+		return
+	}
+
+	current := adrSettings{DataRate: device.DataRate, TXPower: device.TXPower, NbTrans: device.NbTrans}
+	settings, err := calculateADR(msg.FrameContext.GatewayContext.Radio.Band, samples, current)
+	if err != nil {
+		logging.Info("Unable to calculate ADR settings for device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	if settings == current && !fctrl.ADRACKReq {
+		return
+	}
+
+	cmd := protocol.NewDownlinkMACCommand(protocol.LinkADRReq).(*protocol.MACLinkADRReq)
+	cmd.DataRate = settings.DataRate
+	cmd.TXPower = settings.TXPower
+	cmd.ChMask = 0x00FF
+	cmd.Redundancy = adrChMaskCntlAllOn<<4 | (settings.NbTrans & 0x0F)
+	// Bands with sub-bands only enable the channels in the gateway's sub-band
+	if chMask, ok := frequency.SubBandChMask(msg.FrameContext.GatewayContext.Radio.Band, msg.FrameContext.GatewayContext.Gateway.SubBand); ok {
+		cmd.ChMask = chMask
+		cmd.Redundancy = frequency.SubBandChMaskCntl<<4 | (settings.NbTrans & 0x0F)
+	}
+	if err := a.context.FrameOutput.AddMACCommand(device.DeviceEUI, cmd); err != nil {
+		logging.Warning("Unable to schedule LinkADRReq for device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	logging.Debug("Scheduled LinkADRReq for device %s (DR=%d, TXPower=%d, NbTrans=%d)",
+		device.DeviceEUI, settings.DataRate, settings.TXPower, settings.NbTrans)
+	a.pending[device.DeviceEUI] = pendingADR{settings: settings}
+}
+
+// processAnswer handles the LinkADRAns from the device. The new settings are
+// stored on the device if all of the ack bits are set.
+func (a *adrEngine) processAnswer(device model.Device, ans *protocol.MACLinkADRAns) {
+	a.mutex.Lock()
+	p, exists := a.pending[device.DeviceEUI]
+	delete(a.pending, device.DeviceEUI)
+	a.mutex.Unlock()
+
+	if !exists {
+		logging.Info("Got LinkADRAns from device %s but there's no pending LinkADRReq", device.DeviceEUI)
+		return
+	}
+
+	// The samples in the history are for the old settings. Start over.
+	a.context.UplinkHistory.Clear(device.DeviceEUI)
+
+	if !ans.PowerACK || !ans.DataRateACK || !ans.ChannelMaskACK {
+		logging.Info("Device %s rejected LinkADRReq (power ack=%t, data rate ack=%t, channel mask ack=%t)",
+			device.DeviceEUI, ans.PowerACK, ans.DataRateACK, ans.ChannelMaskACK)
+		return
+	}
+
+	stored, err := a.context.Storage.Device.GetByEUI(device.DeviceEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	stored.DataRate = p.settings.DataRate
+	stored.TXPower = p.settings.TXPower
+	stored.NbTrans = p.settings.NbTrans
+	if err := a.context.Storage.Device.Update(stored); err != nil {
+		logging.Warning("Unable to update ADR settings for device %s: %v", device.DeviceEUI, err)
+	}
+}
@@ -0,0 +1,211 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func makeSamples(dataRate string, snr float32, fcnts ...uint32) []server.UplinkSample {
+	var ret []server.UplinkSample
+	for _, v := range fcnts {
+		ret = append(ret, server.UplinkSample{FCnt: v, DataRate: dataRate, SNR: snr, Gateways: 1})
+	}
+	return ret
+}
+
+func sequence(from, to uint32) []uint32 {
+	var ret []uint32
+	for i := from; i <= to; i++ {
+		ret = append(ret, i)
+	}
+	return ret
+}
+
+func TestCalculateADR(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+
+	// Good link at SF12. Data rate should go to the max, remaining steps lowers the TX power
+	settings, err := calculateADR(eu, makeSamples("SF12BW125", 10, sequence(0, 19)...), adrSettings{})
+	if err != nil {
+		t.Fatal("Got error calculating ADR: ", err)
+	}
+	if settings.DataRate != 5 || settings.TXPower != 1 || settings.NbTrans != 1 {
+		t.Fatalf("Unexpected settings: %+v", settings)
+	}
+
+	// Bad link at SF7. TX power should be increased
+	settings, err = calculateADR(eu, makeSamples("SF7BW125", -10, sequence(0, 19)...), adrSettings{DataRate: 5, TXPower: 3})
+	if err != nil {
+		t.Fatal("Got error calculating ADR: ", err)
+	}
+	if settings.DataRate != 5 || settings.TXPower != 0 {
+		t.Fatalf("Unexpected settings: %+v", settings)
+	}
+
+	// Lost frames should increase the number of transmissions
+	settings, _ = calculateADR(eu, makeSamples("SF7BW125", 0, append(sequence(0, 15), 20, 22, 23, 24)...), adrSettings{DataRate: 5})
+	if settings.NbTrans != 3 {
+		t.Fatalf("Expected NbTrans = 3 but got %d", settings.NbTrans)
+	}
+
+	// Frame counters that pass the 16-bit boundary should work
+	settings, _ = calculateADR(eu, makeSamples("SF7BW125", 0, 0xFFFE, 0xFFFF, 0x10000, 0x10001), adrSettings{DataRate: 5})
+	if settings.NbTrans != 1 {
+		t.Fatalf("Expected NbTrans = 1 but got %d", settings.NbTrans)
+	}
+
+	if _, err := calculateADR(eu, nil, adrSettings{}); err == nil {
+		t.Fatal("Expected error with no samples")
+	}
+	if _, err := calculateADR(eu, makeSamples("FSKBW500", 10, 1, 2, 3), adrSettings{}); err == nil {
+		t.Fatal("Expected error with FSK data rate")
+	}
+}
+
+func TestADREngine(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	frameOutput := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	context := &server.Context{Storage: &store, FrameOutput: &frameOutput, UplinkHistory: &history}
+
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(1)
+	store.Application.Put(app, model.SystemUserID)
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	device.DevAddr = protocol.DevAddrFromUint32(0x01020304)
+	store.Device.Put(device, app.AppEUI)
+
+	eu, _ := band.NewBand(band.EU868Band)
+	radio := server.RadioContext{Band: eu, DataRate: "SF12BW125", SNR: 10}
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device:         device,
+			GatewayContext: server.GatewayPacket{Radio: radio},
+		},
+	}
+	msg.Payload.MACPayload.FHDR.FCtrl.ADR = true
+
+	engine := newADREngine(context)
+
+	// Not enough samples. Nothing should be scheduled
+	for i := 0; i < ADRHistoryLength-1; i++ {
+		history.Add(device.DeviceEUI, uint32(i), server.GatewayPacket{Radio: radio, ReceivedAt: time.Now()})
+	}
+	engine.processUplink(msg)
+	if _, err := frameOutput.GetPHYPayloadForDevice(&device, &msg.FrameContext); err == nil {
+		t.Fatal("Did not expect output with short history")
+	}
+
+	history.Add(device.DeviceEUI, ADRHistoryLength, server.GatewayPacket{Radio: radio, ReceivedAt: time.Now()})
+	engine.processUplink(msg)
+	payload, err := frameOutput.GetPHYPayloadForDevice(&device, &msg.FrameContext)
+	if err != nil {
+		t.Fatal("Expected output for device: ", err)
+	}
+	if !payload.MACPayload.FHDR.FCtrl.ADR {
+		t.Fatal("Expected ADR flag to be set")
+	}
+	cmds := payload.MACPayload.MACCommands.List()
+	if len(cmds) != 1 || cmds[0].ID() != protocol.LinkADRReq {
+		t.Fatalf("Expected LinkADRReq but got %v", cmds)
+	}
+	req := cmds[0].(*protocol.MACLinkADRReq)
+	if req.DataRate != 5 || req.TXPower != 1 || req.Redundancy&0x0F != 1 {
+		t.Fatalf("Unexpected LinkADRReq: %+v", req)
+	}
+
+	// A second uplink should not trigger a new request while one is pending
+	engine.processUplink(msg)
+	if payload, err := frameOutput.GetPHYPayloadForDevice(&device, &msg.FrameContext); err == nil && payload.MACPayload.MACCommands.Size() > 0 {
+		t.Fatal("Did not expect a new LinkADRReq")
+	}
+
+	// Rejecting the request leaves the device as is
+	engine.processAnswer(device, &protocol.MACLinkADRAns{PowerACK: true, DataRateACK: false, ChannelMaskACK: true})
+	stored, _ := store.Device.GetByEUI(device.DeviceEUI)
+	if stored.DataRate != 0 || stored.TXPower != 0 {
+		t.Fatalf("Device settings should not change when request is rejected: %+v", stored)
+	}
+	if len(history.Samples(device.DeviceEUI)) != 0 {
+		t.Fatal("History should be cleared after LinkADRAns")
+	}
+
+	// Send a new request and accept it
+	for i := 0; i < ADRHistoryLength; i++ {
+		history.Add(device.DeviceEUI, uint32(i+100), server.GatewayPacket{Radio: radio, ReceivedAt: time.Now()})
+	}
+	engine.processUplink(msg)
+	engine.processAnswer(device, &protocol.MACLinkADRAns{PowerACK: true, DataRateACK: true, ChannelMaskACK: true})
+	stored, _ = store.Device.GetByEUI(device.DeviceEUI)
+	if stored.DataRate != 5 || stored.TXPower != 1 || stored.NbTrans != 1 {
+		t.Fatalf("Device settings wasn't updated: %+v", stored)
+	}
+
+	// Answers without a pending request are ignored
+	engine.processAnswer(device, &protocol.MACLinkADRAns{PowerACK: true, DataRateACK: true, ChannelMaskACK: true})
+}
+
+// Devices in bands with sub-bands only use the channels in the gateway's
+// sub-band.
+func TestADRSubBand(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	frameOutput := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	context := &server.Context{Storage: &store, FrameOutput: &frameOutput, UplinkHistory: &history}
+
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	device.DevAddr = protocol.DevAddrFromUint32(0x01020304)
+
+	us, _ := band.NewBand(band.US915Band)
+	radio := server.RadioContext{Band: us, DataRate: "SF10BW125", SNR: 10}
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device:         device,
+			GatewayContext: server.GatewayPacket{Radio: radio, Gateway: server.GatewayContext{SubBand: 2}},
+		},
+	}
+	msg.Payload.MACPayload.FHDR.FCtrl.ADR = true
+	msg.Payload.MACPayload.FHDR.FCtrl.ADRACKReq = true
+	history.Add(device.DeviceEUI, 1, server.GatewayPacket{Radio: radio, ReceivedAt: time.Now()})
+
+	newADREngine(context).processUplink(msg)
+	payload, err := frameOutput.GetPHYPayloadForDevice(&device, &msg.FrameContext)
+	if err != nil {
+		t.Fatal("Expected output for device: ", err)
+	}
+	cmds := payload.MACPayload.MACCommands.List()
+	if len(cmds) != 1 || cmds[0].ID() != protocol.LinkADRReq {
+		t.Fatalf("Expected LinkADRReq but got %v", cmds)
+	}
+	req := cmds[0].(*protocol.MACLinkADRReq)
+	if req.ChMask != 0x0002 || req.Redundancy>>4 != frequency.SubBandChMaskCntl {
+		t.Fatalf("Expected channel mask for sub-band 2: %+v", req)
+	}
+}
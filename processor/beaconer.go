@@ -0,0 +1,127 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/monitoring"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// beaconLeadTime is the time before the beacon is transmitted that it is sent
+// to the gateways.
+const beaconLeadTime = time.Second
+
+// Beaconer sends class B beacons through the GPS synchronized gateways at the
+// start of every beacon period [15].
+type Beaconer struct {
+	context   *server.Context
+	occupancy *txOccupancy
+	output    chan<- server.GatewayPacket
+	terminate chan bool
+}
+
+// NewBeaconer creates a new beaconer. The beacons are sent on the output
+// channel. The beacons are registered in the scheduler's transmitter
+// occupancy.
+func NewBeaconer(context *server.Context, scheduler *Scheduler, output chan<- server.GatewayPacket) *Beaconer {
+	return &Beaconer{
+		context:   context,
+		occupancy: scheduler.occupancy,
+		output:    output,
+		terminate: make(chan bool),
+	}
+}
+
+// Start launches the beaconer. It runs until Stop is called.
+func (b *Beaconer) Start() {
+	for {
+		next := protocol.NextBeacon(time.Now().Add(beaconLeadTime))
+		select {
+		case <-time.After(time.Until(next.Add(-beaconLeadTime))):
+			b.sendBeacons(next)
+		case <-b.terminate:
+			logging.Debug("Beaconer terminated")
+			return
+		}
+	}
+}
+
+// Stop stops the beaconer. No beacons are sent after Stop returns.
+func (b *Beaconer) Stop() {
+	b.terminate <- true
+}
+
+// newBeacon creates the beacon packet for a gateway
+func (b *Beaconer) newBeacon(gw server.GPSGateway, beaconStart time.Time) (server.GatewayPacket, error) {
+	conf := gw.Band.Configuration()
+	beaconTime := uint32(protocol.GPSTime(beaconStart) / time.Second)
+	beacon := protocol.Beacon{Time: beaconTime}
+	if b.context.Storage != nil {
+		if gateway, err := b.context.Storage.Gateway.Get(gw.Gateway.GatewayEUI, model.SystemUserID); err == nil {
+			beacon.Latitude = gateway.Latitude
+			beacon.Longitude = gateway.Longitude
+		}
+	}
+	buffer, err := beacon.Encode(conf.BeaconCommonRFU, conf.BeaconGatewayRFU)
+	if err != nil {
+		return server.GatewayPacket{}, err
+	}
+	dataRate, err := band.DataRateIdentifier(gw.Band, conf.BeaconDataRate)
+	if err != nil {
+		return server.GatewayPacket{}, err
+	}
+	return server.GatewayPacket{
+		RawMessage: buffer,
+		Radio: server.RadioContext{
+			Band:      gw.Band,
+			DataRate:  dataRate,
+			Frequency: conf.BeaconFrequency(beaconTime),
+		},
+		Gateway:      gw.Gateway,
+		ReceivedAt:   time.Now(),
+		SectionTimer: monitoring.NewTimer(),
+		InTimer:      monitoring.NewTimer(),
+		OutTimer:     monitoring.NewTimer(),
+		TXTime:       beaconStart,
+		Beacon:       true,
+	}, nil
+}
+
+// sendBeacons sends the beacon for the beacon period to all of the GPS
+// synchronized gateways.
+func (b *Beaconer) sendBeacons(beaconStart time.Time) {
+	for _, gw := range b.context.GPSGateways.List() {
+		if gw.Band == nil {
+			continue
+		}
+		packet, err := b.newBeacon(gw, beaconStart)
+		if err != nil {
+			logging.Warning("Unable to create beacon for gateway %s: %v", gw.Gateway.GatewayEUI, err)
+			continue
+		}
+		encoding, err := gw.Band.Encoding(gw.Band.Configuration().BeaconDataRate)
+		if err == nil && !b.occupancy.reserve(gw.Gateway.GatewayEUI, beaconStart, encoding.TimeOnAir(len(packet.RawMessage))) {
+			logging.Info("Gateway %s is busy when the beacon is sent", gw.Gateway.GatewayEUI)
+		}
+		b.output <- packet
+	}
+}
@@ -0,0 +1,75 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func TestBeaconer(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	gps := server.NewGPSGateways()
+	context := &server.Context{Storage: &store, GPSGateways: &gps}
+
+	gateway := model.NewGateway()
+	gateway.GatewayEUI = protocol.EUIFromUint64(1)
+	gateway.Latitude = 63.43
+	gateway.Longitude = 10.39
+	store.Gateway.Put(gateway, model.SystemUserID)
+
+	eu, _ := band.NewBand(band.EU868Band)
+	gps.Update(server.GatewayContext{GatewayEUI: gateway.GatewayEUI}, eu)
+
+	output := make(chan server.GatewayPacket)
+	beaconer := NewBeaconer(context, NewScheduler(context, nil), output)
+
+	beaconStart := protocol.NextBeacon(time.Now())
+	go beaconer.sendBeacons(beaconStart)
+	select {
+	case packet := <-output:
+		if !packet.Beacon || !packet.TXTime.Equal(beaconStart) || packet.Gateway.GatewayEUI != gateway.GatewayEUI {
+			t.Fatalf("Incorrect beacon packet: %+v", packet)
+		}
+		if packet.Radio.Frequency != 869.525 || packet.Radio.DataRate != "SF9BW125" {
+			t.Fatalf("Incorrect beacon radio settings: %+v", packet.Radio)
+		}
+		expected, _ := (&protocol.Beacon{
+			Time:      uint32(protocol.GPSTime(beaconStart) / time.Second),
+			Latitude:  gateway.Latitude,
+			Longitude: gateway.Longitude,
+		}).Encode(2, 0)
+		if string(packet.RawMessage) != string(expected) {
+			t.Fatalf("Incorrect beacon payload: %v != %v", packet.RawMessage, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not get a beacon")
+	}
+
+	// The beacon is registered in the gateway's transmitter occupancy
+	if beaconer.occupancy.reserve(gateway.GatewayEUI, beaconStart, time.Millisecond) {
+		t.Fatal("Expected gateway to be busy when the beacon is sent")
+	}
+
+	go beaconer.Start()
+	beaconer.Stop()
+}
@@ -0,0 +1,230 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"sync"
+
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// channelPendingLimit is the number of uplinks to wait for a NewChannelAns or
+// DlChannelAns before the request is sent again.
+const channelPendingLimit = 4
+
+// pendingChannel is a channel request sent to a device that hasn't been
+// answered yet.
+type pendingChannel struct {
+	index    int
+	downlink bool              // DlChannelReq is sent, not NewChannelReq
+	channels model.ChannelList // The device's channels when the request is acknowledged
+	uplinks  int
+}
+
+// channelScheduler sends NewChannelReq and DlChannelReq commands to devices
+// when the channels they use differ from the application's channel plan. A
+// downlink can only hold one command of each kind so the channels are set up
+// one at a time. The device's channel list is updated when the device
+// acknowledges the command.
+type channelScheduler struct {
+	context  *server.Context
+	pending  map[protocol.EUI]pendingChannel
+	rejected map[protocol.EUI]uint16 // Channels the device has rejected. These won't be requested again.
+	mutex    *sync.Mutex
+}
+
+func newChannelScheduler(context *server.Context) *channelScheduler {
+	return &channelScheduler{
+		context:  context,
+		pending:  make(map[protocol.EUI]pendingChannel),
+		rejected: make(map[protocol.EUI]uint16),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// nextChannel returns the index of the first channel that differs between the
+// current and the requested channel list. The downlink flag is set if only
+// the downlink frequency differs.
+func nextChannel(current, requested model.ChannelList, rejected uint16) (int, bool, bool) {
+	count := len(current)
+	if len(requested) > count {
+		count = len(requested)
+	}
+	for i := 0; i < count && i < model.MaxChannels; i++ {
+		if rejected&(1<<uint(i)) != 0 {
+			continue
+		}
+		var have, want model.Channel
+		if i < len(current) {
+			have = current[i]
+		}
+		if i < len(requested) {
+			want = requested[i]
+		}
+		if have.Frequency != want.Frequency {
+			return i, false, true
+		}
+		if want.Frequency != 0 && have.DownlinkFrequency() != want.DownlinkFrequency() {
+			return i, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// processUplink schedules a NewChannelReq or DlChannelReq for the device if
+// its channels differ from the channel plan.
+func (c *channelScheduler) processUplink(msg server.LoRaMessage) {
+	mtype := msg.Payload.MHDR.MType
+	if mtype != protocol.UnconfirmedDataUp && mtype != protocol.ConfirmedDataUp {
+		return
+	}
+	plan := msg.FrameContext.GatewayContext.Radio.Band
+	if !plan.Configuration().SupportsJoinAcceptCFList {
+		// Bands with fixed channel plans doesn't support NewChannelReq
+		return
+	}
+	device := msg.FrameContext.Device
+	current := frequency.DeviceChannels(plan, device)
+	requested := frequency.ChannelPlan(plan, msg.FrameContext.Application)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	index, downlink, found := nextChannel(current, requested, c.rejected[device.DeviceEUI])
+	if !found {
+		delete(c.pending, device.DeviceEUI)
+		return
+	}
+
+	if p, exists := c.pending[device.DeviceEUI]; exists {
+		p.uplinks++
+		if p.uplinks < channelPendingLimit {
+			c.pending[device.DeviceEUI] = p
+			return
+		}
+		logging.Info("No answer to channel request from device %s after %d uplinks", device.DeviceEUI, p.uplinks)
+	}
+
+	var want model.Channel
+	if index < len(requested) {
+		want = requested[index]
+	}
+	channels := append(model.ChannelList(nil), current...)
+	for len(channels) <= index {
+		channels = append(channels, model.Channel{})
+	}
+
+	var cmd protocol.MACCommand
+	if downlink {
+		req := protocol.NewDownlinkMACCommand(protocol.DlChannelReq).(*protocol.MACDlChannelReq)
+		req.ChIndex = uint8(index)
+		req.Freq = frequency.Steps(want.DownlinkFrequency())
+		channels[index].Downlink = want.Downlink
+		cmd = req
+	} else {
+		// A new channel uses the uplink frequency for RX1 until a
+		// DlChannelReq is acknowledged [5.6]
+		req := protocol.NewDownlinkMACCommand(protocol.NewChannelReq).(*protocol.MACNewChannelReq)
+		req.ChIndex = uint8(index)
+		req.Freq = frequency.Steps(want.Frequency)
+		if want.Frequency != 0 {
+			req.MinDR = 0
+			req.MaxDR = plan.Configuration().MaxADRDataRate
+		}
+		channels[index] = model.Channel{Frequency: want.Frequency}
+		cmd = req
+	}
+	if err := c.context.FrameOutput.AddMACCommand(device.DeviceEUI, cmd); err != nil {
+		logging.Warning("Unable to schedule channel request for device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	logging.Debug("Scheduled channel request for device %s (channel %d: %+v)", device.DeviceEUI, index, channels[index])
+	c.pending[device.DeviceEUI] = pendingChannel{index: index, downlink: downlink, channels: channels}
+}
+
+// completeRequest removes the pending request and returns it. The boolean flag
+// is false if there's no matching request.
+func (c *channelScheduler) completeRequest(eui protocol.EUI, downlink bool, ok bool) (pendingChannel, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, exists := c.pending[eui]
+	if !exists || p.downlink != downlink {
+		return pendingChannel{}, false
+	}
+	delete(c.pending, eui)
+	if !ok {
+		c.rejected[eui] |= 1 << uint(p.index)
+	}
+	return p, true
+}
+
+// updateDevice stores the new channel list for the device. The device in the
+// frame context is updated as well since the downlink that follows uses the
+// new channels.
+func (c *channelScheduler) updateDevice(device *model.Device, channels model.ChannelList) {
+	device.Channels = channels
+
+	stored, err := c.context.Storage.Device.GetByEUI(device.DeviceEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	stored.Channels = channels
+	if err := c.context.Storage.Device.Update(stored); err != nil {
+		logging.Warning("Unable to update channels for device %s: %v", device.DeviceEUI, err)
+	}
+}
+
+// processNewChannelAnswer handles the NewChannelAns from the device. The
+// channel is only changed when both of the ack bits are set [5.6]. Rejected
+// channels aren't requested again until the server restarts.
+func (c *channelScheduler) processNewChannelAnswer(device *model.Device, ans *protocol.MACNewChannelAns) {
+	ok := ans.DataRangeOK && ans.ChannelFrequencyOK
+	p, exists := c.completeRequest(device.DeviceEUI, false, ok)
+	if !exists {
+		logging.Info("Got NewChannelAns from device %s but there's no pending NewChannelReq", device.DeviceEUI)
+		return
+	}
+	if !ok {
+		logging.Warning("Device %s rejected NewChannelReq for channel %d (data rate ok=%t, frequency ok=%t)",
+			device.DeviceEUI, p.index, ans.DataRangeOK, ans.ChannelFrequencyOK)
+		return
+	}
+	c.updateDevice(device, p.channels)
+}
+
+// processDlChannelAnswer handles the DlChannelAns from the device. The
+// downlink frequency is only changed when both of the ack bits are set [5.8]
+func (c *channelScheduler) processDlChannelAnswer(device *model.Device, ans *protocol.MACDlChannelAns) {
+	ok := ans.UplinkFrequencyExists && ans.ChannelFrequencyOK
+	p, exists := c.completeRequest(device.DeviceEUI, true, ok)
+	if !exists {
+		logging.Info("Got DlChannelAns from device %s but there's no pending DlChannelReq", device.DeviceEUI)
+		return
+	}
+	if !ok {
+		logging.Warning("Device %s rejected DlChannelReq for channel %d (uplink frequency exists=%t, frequency ok=%t)",
+			device.DeviceEUI, p.index, ans.UplinkFrequencyExists, ans.ChannelFrequencyOK)
+		return
+	}
+	c.updateDevice(device, p.channels)
+}
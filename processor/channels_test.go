@@ -0,0 +1,142 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"testing"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func TestChannelScheduler(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	frameOutput := server.NewFrameOutputBuffer()
+	context := &server.Context{Storage: &store, FrameOutput: &frameOutput}
+
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(1)
+	store.Application.Put(app, model.SystemUserID)
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	store.Device.Put(device, app.AppEUI)
+
+	eu, _ := band.NewBand(band.EU868Band)
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device:         device,
+			Application:    app,
+			GatewayContext: server.GatewayPacket{Radio: server.RadioContext{Band: eu, DataRate: "SF7BW125", Frequency: 867.3}},
+		},
+	}
+
+	getCommands := func() map[protocol.CID]protocol.MACCommand {
+		ret := make(map[protocol.CID]protocol.MACCommand)
+		payload, err := frameOutput.GetPHYPayloadForDevice(&device, &msg.FrameContext)
+		if err != nil {
+			return ret
+		}
+		for _, v := range payload.MACPayload.MACCommands.List() {
+			ret[v.ID()] = v
+		}
+		return ret
+	}
+
+	scheduler := newChannelScheduler(context)
+
+	// The device only uses the mandatory channels. The first of the band's
+	// additional channels is sent.
+	scheduler.processUplink(msg)
+	cmds := getCommands()
+	req, ok := cmds[protocol.NewChannelReq].(*protocol.MACNewChannelReq)
+	if !ok || req.ChIndex != 3 || req.Freq != 8671000 || req.MinDR != 0 || req.MaxDR != 5 {
+		t.Fatalf("Expected NewChannelReq for channel 3 but got %v", cmds)
+	}
+
+	// Request is pending. Nothing new should be sent.
+	scheduler.processUplink(msg)
+	if len(getCommands()) != 0 {
+		t.Fatal("Did not expect commands while the request is pending")
+	}
+
+	scheduler.processNewChannelAnswer(&msg.FrameContext.Device, &protocol.MACNewChannelAns{DataRangeOK: true, ChannelFrequencyOK: true})
+	if len(msg.FrameContext.Device.Channels) != 4 {
+		t.Fatalf("Expected channels to be updated in message: %v", msg.FrameContext.Device.Channels)
+	}
+	stored, _ := store.Device.GetByEUI(device.DeviceEUI)
+	if len(stored.Channels) != 4 || stored.Channels[3].Frequency != 867.1 {
+		t.Fatalf("Unexpected channels for stored device: %v", stored.Channels)
+	}
+
+	// Answers without a request are ignored
+	scheduler.processNewChannelAnswer(&msg.FrameContext.Device, &protocol.MACNewChannelAns{DataRangeOK: true, ChannelFrequencyOK: true})
+
+	// The device rejects the next channel. It isn't requested again.
+	scheduler.processUplink(msg)
+	req, ok = getCommands()[protocol.NewChannelReq].(*protocol.MACNewChannelReq)
+	if !ok || req.ChIndex != 4 {
+		t.Fatalf("Expected NewChannelReq for channel 4 but got %v", req)
+	}
+	scheduler.processNewChannelAnswer(&msg.FrameContext.Device, &protocol.MACNewChannelAns{DataRangeOK: true, ChannelFrequencyOK: false})
+	scheduler.processUplink(msg)
+	req, ok = getCommands()[protocol.NewChannelReq].(*protocol.MACNewChannelReq)
+	if !ok || req.ChIndex != 5 {
+		t.Fatalf("Expected NewChannelReq for channel 5 but got %v", req)
+	}
+
+	// Use a different downlink frequency for channel 3 in the application
+	msg.FrameContext.Application.Channels = model.ChannelList{{Frequency: 867.1, Downlink: 869.1}}
+	msg.FrameContext.Device.Channels = frequency.ChannelPlan(eu, app)[:4]
+	store.Device.Update(msg.FrameContext.Device)
+	scheduler = newChannelScheduler(context)
+	scheduler.processUplink(msg)
+	dlReq, ok := getCommands()[protocol.DlChannelReq].(*protocol.MACDlChannelReq)
+	if !ok || dlReq.ChIndex != 3 || dlReq.Freq != 8691000 {
+		t.Fatalf("Expected DlChannelReq for channel 3 but got %v", dlReq)
+	}
+	scheduler.processDlChannelAnswer(&msg.FrameContext.Device, &protocol.MACDlChannelAns{UplinkFrequencyExists: true, ChannelFrequencyOK: true})
+	stored, _ = store.Device.GetByEUI(device.DeviceEUI)
+	if len(stored.Channels) != 4 || stored.Channels[3].Downlink != 869.1 {
+		t.Fatalf("Unexpected channels for stored device: %v", stored.Channels)
+	}
+
+	// The device uses the new frequency for RX1 on the channel
+	msg.FrameContext.GatewayContext.Radio.Frequency = 867.1
+	if radio := downlinkRadio(msg); radio.Frequency != 869.1 {
+		t.Fatalf("Expected RX1 frequency to be 869.1 but got %+v", radio)
+	}
+
+	// Everything is set up. Nothing more to send.
+	scheduler.processUplink(msg)
+	if len(getCommands()) != 0 {
+		t.Fatal("Did not expect commands when the channels are set up")
+	}
+
+	// Bands with fixed channel plans doesn't use channel requests
+	us, _ := band.NewBand(band.US915Band)
+	msg.FrameContext.Device.Channels = nil
+	msg.FrameContext.GatewayContext.Radio.Band = us
+	scheduler.processUplink(msg)
+	if len(getCommands()) != 0 {
+		t.Fatal("Did not expect commands for the US band")
+	}
+}
@@ -0,0 +1,62 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/fuota"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// maxClockDrift is the largest clock error (in seconds) that is accepted
+// without answering an AppTimeReq. Devices that set the AnsRequired flag will
+// always get an answer.
+const maxClockDrift = 1
+
+// clockSyncAnswer processes the uplink payload sent by a device on the clock
+// synchronization port and returns the payload that should be sent back to
+// the device. The returned payload is nil if no answer is required. The
+// received time is the time the uplink was received.
+func clockSyncAnswer(deviceEUI protocol.EUI, payload []byte, received time.Time) []byte {
+	cmds, err := fuota.DecodeClockSyncUplink(payload)
+	if err != nil {
+		logging.Info("Unable to decode clock synchronization payload from device %s: %v", deviceEUI, err)
+	}
+	var ret []byte
+	for _, v := range cmds {
+		switch cmd := v.(type) {
+		case *fuota.AppTimeReq:
+			// The times are modulo 2^32 so the correction will wrap around
+			// the same way
+			now := uint32(protocol.GPSTime(received) / time.Second)
+			correction := int32(now - cmd.DeviceTime)
+			if !cmd.AnsRequired && correction <= maxClockDrift && correction >= -maxClockDrift {
+				continue
+			}
+			logging.Debug("Device %s clock is off by %d seconds", deviceEUI, correction)
+			ret = append(ret, fuota.AppTimeAns{TimeCorrection: correction, TokenAns: cmd.TokenReq}.Encode()...)
+		case *fuota.PackageVersionAns:
+			logging.Info("Device %s supports clock synchronization package %d, version %d", deviceEUI, cmd.Package, cmd.Version)
+		case *fuota.DeviceAppTimePeriodicityAns:
+			if err := cmd.Err(); err != nil {
+				logging.Info("Device %s rejected DeviceAppTimePeriodicityReq: %v", deviceEUI, err)
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,53 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/fuota"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func TestClockSyncAnswer(t *testing.T) {
+	eui := protocol.EUIFromUint64(1)
+	now := time.Now()
+	gpsNow := uint32(protocol.GPSTime(now) / time.Second)
+
+	// Synchronized devices don't get an answer unless they ask for it
+	req := fuota.AppTimeReq{DeviceTime: gpsNow, TokenReq: 2}
+	if ans := clockSyncAnswer(eui, req.Encode(), now); ans != nil {
+		t.Fatalf("Did not expect an answer but got %v", ans)
+	}
+	req.AnsRequired = true
+	ans := clockSyncAnswer(eui, req.Encode(), now)
+	if len(ans) != 6 || ans[0] != fuota.AppTime || ans[5] != 2 {
+		t.Fatalf("Expected AppTimeAns but got %v", ans)
+	}
+
+	// Devices with drifting clocks get the correction
+	req = fuota.AppTimeReq{DeviceTime: gpsNow + 100, TokenReq: 7}
+	ans = clockSyncAnswer(eui, req.Encode(), now)
+	expected := fuota.AppTimeAns{TimeCorrection: -100, TokenAns: 7}.Encode()
+	if string(ans) != string(expected) {
+		t.Fatalf("Expected %v but got %v", expected, ans)
+	}
+
+	if ans := clockSyncAnswer(eui, []byte{0x7F}, now); ans != nil {
+		t.Fatalf("Did not expect an answer to invalid payload but got %v", ans)
+	}
+}
@@ -18,12 +18,13 @@ package processor
 import (
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/fuota"
 	"github.com/ExploratoryEngineering/congress/monitoring"
 
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
-	"github.com/ExploratoryEngineering/congress/storage"
 	"github.com/ExploratoryEngineering/logging"
 )
 
@@ -36,19 +37,53 @@ type Decrypter struct {
 	context   *server.Context
 }
 
+// defaultMaxFCntGap is the frame counter gap used when there's no band in the
+// frame context. All of the supported bands use the same value.
+const defaultMaxFCntGap = 16384
+
+// maxFCntGap returns the MAX_FCNT_GAP setting for the band the message was
+// received on.
+func maxFCntGap(decoded server.LoRaMessage) uint32 {
+	if decoded.FrameContext.GatewayContext.Radio.Band == nil {
+		return defaultMaxFCntGap
+	}
+	return decoded.FrameContext.GatewayContext.Radio.Band.Configuration().MaxFCntGap
+}
+
+// inferFrameCounter reconstructs the full 32-bit frame counter from the 16
+// least significant bits sent by the device. The most significant bits are
+// taken from the expected frame counter. If the transmitted value is less than
+// the expected value but within maxGap of it after a rollover of the 16-bit
+// counter the most significant bits are incremented [4.3.1.5].
+func inferFrameCounter(expected uint32, fcnt uint16, maxGap uint32) uint32 {
+	full := expected&0xFFFF0000 | uint32(fcnt)
+	if full < expected && full+0x10000-expected < maxGap {
+		full += 0x10000
+	}
+	return full
+}
+
 func (d *Decrypter) validFrameCounter(device *model.Device, decoded server.LoRaMessage) bool {
 	// Ignore frame counter for JoinRequest messages since that will be reset
 	// when the device have joined.
 	if decoded.Payload.MHDR.MType == protocol.JoinRequest {
 		return true
 	}
+	fcnt := decoded.Payload.MACPayload.FHDR.FullFCnt()
 	// Ensure frame counters are valid if it has strict checks
 	if !device.RelaxedCounter {
 		// Ignore frame counters that are less than the stored value. Bigger ones
 		// means that we've lost one or more message from the device.
-		if device.FCntUp > decoded.Payload.MACPayload.FHDR.FCnt {
+		if device.FCntUp > fcnt {
 			logging.Info("Frame counter check failed for device %s. Expected %d but got %d. Ignoring message.",
-				device.DeviceEUI, device.FCntUp, decoded.Payload.MACPayload.FHDR.FCnt)
+				device.DeviceEUI, device.FCntUp, fcnt)
+			monitoring.LoRaCounterFailed.Increment()
+			return false
+		}
+		// ...but if too many frames are lost the frame is discarded [4.3.1.5]
+		if gap := maxFCntGap(decoded); fcnt-device.FCntUp >= gap {
+			logging.Info("Frame counter gap for device %s is too big. Expected %d but got %d (max gap is %d). Ignoring message.",
+				device.DeviceEUI, device.FCntUp, fcnt, gap)
 			monitoring.LoRaCounterFailed.Increment()
 			return false
 		}
@@ -56,9 +91,9 @@ func (d *Decrypter) validFrameCounter(device *model.Device, decoded server.LoRaM
 
 	// Issue debug warning if there's a mismatch between expected and actual frame counter
 	// but process the message. This warning will be issued for all mismatchs.
-	if device.FCntUp != decoded.Payload.MACPayload.FHDR.FCnt {
+	if device.FCntUp != fcnt {
 		logging.Debug("Frame counter will be adjusted. Expected %d but got %d for device with EUI %s",
-			device.FCntUp, decoded.Payload.MACPayload.FHDR.FCnt, device.DeviceEUI)
+			device.FCntUp, fcnt, device.DeviceEUI)
 	}
 	return true
 }
@@ -68,6 +103,24 @@ func (d *Decrypter) processMessage(device *model.Device, decoded server.LoRaMess
 	// Frame counters are tricky if there's more than one device since two (or more) devices
 	// will send different frame counters. But this will be treated like any other message. With strict checks in place you *will* loose messages.
 
+	// The device only sends the 16 least significant bits of the frame counter.
+	fhdr := &decoded.Payload.MACPayload.FHDR
+	fhdr.SetFullFCnt(inferFrameCounter(device.FCntUp, fhdr.FCnt, maxFCntGap(decoded)))
+
+	// Keep the radio metrics for the ADR engine and the best gateway for class
+	// C downlinks. Duplicates received by other gateways after the
+	// deduplication window will have the previous frame counter at this point.
+	fcnt := fhdr.FullFCnt()
+	if fcnt >= device.FCntUp || fcnt+1 == device.FCntUp {
+		receptions := decoded.FrameContext.Receptions
+		if len(receptions) == 0 {
+			receptions = []server.GatewayPacket{decoded.FrameContext.GatewayContext}
+		}
+		for _, v := range receptions {
+			d.context.UplinkHistory.Add(device.DeviceEUI, fcnt, v)
+		}
+	}
+
 	// Frame counter checks does not apply for JoinRequest messages
 	if !d.validFrameCounter(device, decoded) {
 		return
@@ -86,13 +139,32 @@ func (d *Decrypter) processMessage(device *model.Device, decoded server.LoRaMess
 	}
 
 	// Update frame counter with the next expected message.
-	if decoded.Payload.MACPayload.FHDR.FCnt >= device.FCntUp {
-		device.FCntUp = decoded.Payload.MACPayload.FHDR.FCnt + 1
+	if fcnt >= device.FCntUp {
+		device.FCntUp = fcnt + 1
 		if err := d.context.Storage.Device.UpdateState(*device); err != nil {
 			logging.Warning("Unable to update frame counters for device with EUI %s: %v", device.DeviceEUI, err)
 		}
 	}
-	decoded.Payload.Decrypt(device.NwkSKey, device.AppSKey)
+	application, err := d.context.Storage.Application.GetByEUI(device.AppEUI, model.SystemUserID)
+	if err != nil {
+		logging.Warning("Unable to retrieve application with EUI %s: %v", device.AppEUI, err)
+		return
+	}
+
+	// The server doesn't have the AppSKey when the payloads are encrypted
+	// end-to-end. These are passed on to the application as is. MAC commands
+	// on port 0 are encrypted with the network key.
+	encrypted := application.EndToEnd && decoded.Payload.MACPayload.FPort != 0
+	nwkSEncKey := device.NwkSKey
+	if device.MACVersion == protocol.MACVersion11 {
+		if err := decoded.Payload.DecryptFOpts(device.NwkSEncKey); err != nil {
+			logging.Warning("Unable to decrypt FOpts from device %s: %v", device.DeviceEUI, err)
+		}
+		nwkSEncKey = device.NwkSEncKey
+	}
+	if !encrypted {
+		decoded.Payload.Decrypt(nwkSEncKey, device.AppSKey)
+	}
 
 	deviceData := model.DeviceData{
 		DeviceEUI:  device.DeviceEUI,
@@ -111,12 +183,6 @@ func (d *Decrypter) processMessage(device *model.Device, decoded server.LoRaMess
 		return
 	}
 
-	application, err := d.context.Storage.Application.GetByEUI(device.AppEUI, model.SystemUserID)
-	if err != nil {
-		logging.Warning("Unable to retrieve application with EUI %s: %v", device.AppEUI, err)
-		return
-	}
-
 	decoded.FrameContext.Application = application
 	decoded.FrameContext.Device = *device
 
@@ -124,34 +190,43 @@ func (d *Decrypter) processMessage(device *model.Device, decoded server.LoRaMess
 		d.context.FrameOutput.SetMessageAckFlag(device.DeviceEUI, true)
 	}
 
-	msg, err := d.context.Storage.DeviceData.GetDownstream(device.DeviceEUI)
+	pending := false
+	queue, err := d.context.Storage.DeviceData.ListDownstream(device.DeviceEUI)
 	if err == nil {
-		// Update state of message -- note that this could cause some inconsistent
-		// behaviour if you create a message (with ack) and replaces it with a new
-		// message after it has been sent but before it has been acked:
-		//
-		// t=0  : Create new downstream message with ack flag set
-		// t=1  : Device sends upstream message, server responds with downstream message
-		// t=1.1: Downstream message is removed and replaced with a new message
-		// t=1.2: New downstream message with ack flag set
-		// t=2  : Device acknowledges message from t=1
-		// Since the message from t=1.2 isn't sent yet the ack will be ignored.
-		if decoded.Payload.MACPayload.FHDR.FCtrl.ACK && msg.State() == model.SentState && msg.Ack {
-			msg.AckTime = time.Now().Unix()
-			if err := d.context.Storage.DeviceData.UpdateDownstream(device.DeviceEUI, msg.SentTime, msg.AckTime); err != nil {
-				logging.Warning("Unable to update downstream message: %v", err)
+		// Update state of message -- a confirmed message stays at the head of
+		// the queue until it is acknowledged so the ack is for the message
+		// that is sent but not acknowledged. Note that if the message is
+		// removed from the queue after it has been sent the ack will be
+		// ignored.
+		for i, msg := range queue {
+			if decoded.Payload.MACPayload.FHDR.FCtrl.ACK && msg.State() == model.SentState && msg.Ack {
+				queue[i].AckTime = time.Now().Unix()
+				if err := d.context.Storage.DeviceData.UpdateDownstream(device.DeviceEUI, msg.ID, msg.SentTime, queue[i].AckTime); err != nil {
+					logging.Warning("Unable to update downstream message: %v", err)
+				}
 			}
 		}
-		if !msg.IsComplete() {
-			logging.Debug("Setting downstream message payload (%v) for device %s", msg.Payload(), device.DeviceEUI)
-			d.context.FrameOutput.SetPayload(device.DeviceEUI, msg.Payload(), msg.Port, msg.Ack)
-		}
+		pending = prepareDownstream(d.context, *device, application, queue)
 	}
 
-	if err != nil && err != storage.ErrNotFound {
+	if err != nil {
 		logging.Warning("Unable to retrieve downstream message: %v", err)
 	}
 
+	// Clock synchronization requests are answered in the same receive window
+	// unless the application's own downstream message is sent. The device will
+	// repeat the request if it doesn't get an answer. The request can't be
+	// read if the payload is encrypted end-to-end.
+	if !encrypted && decoded.Payload.MACPayload.FPort == fuota.ClockSyncPort {
+		ans := clockSyncAnswer(device.DeviceEUI, decoded.Payload.MACPayload.FRMPayload, decoded.FrameContext.GatewayContext.UplinkTime())
+		if ans != nil && pending {
+			logging.Info("Device %s has a pending downstream message. Skipping clock synchronization answer", device.DeviceEUI)
+		}
+		if ans != nil && !pending {
+			d.context.FrameOutput.SetPayload(device.DeviceEUI, ans, fuota.ClockSyncPort, false)
+		}
+	}
+
 	decoded.FrameContext.GatewayContext.SectionTimer.End()
 	monitoring.Stopwatch(monitoring.DecrypterChannelOut, func() {
 		d.macOutput <- decoded
@@ -159,6 +234,9 @@ func (d *Decrypter) processMessage(device *model.Device, decoded server.LoRaMess
 
 	d.context.AppRouter.Publish(application.AppEUI, &server.PayloadMessage{
 		Payload:      decoded.Payload.MACPayload.FRMPayload,
+		Encrypted:    encrypted,
+		Port:         decoded.Payload.MACPayload.FPort,
+		FCnt:         fcnt,
 		Device:       *device,
 		Application:  application,
 		FrameContext: decoded.FrameContext,
@@ -168,6 +246,32 @@ func (d *Decrypter) processMessage(device *model.Device, decoded server.LoRaMess
 
 }
 
+// calculateUplinkMIC calculates the MIC for an uplink message. LoRaWAN 1.1
+// devices include the data rate and channel used for the uplink and the
+// frame counter of an acknowledged downlink in the MIC [4.4].
+func calculateUplinkMIC(dev model.Device, decoded server.LoRaMessage, message []byte) (uint32, error) {
+	if dev.MACVersion != protocol.MACVersion11 {
+		return decoded.Payload.CalculateMIC(dev.NwkSKey, message)
+	}
+	var confFCnt uint16
+	if decoded.Payload.MACPayload.FHDR.FCtrl.ACK {
+		confFCnt = uint16(dev.FCntDn - 1)
+	}
+	var txDR, txCh uint8
+	radio := decoded.FrameContext.GatewayContext.Radio
+	if radio.Band != nil {
+		dr, err := radio.Band.GetDataRate(radio.DataRate)
+		if err != nil {
+			return 0, err
+		}
+		txDR = dr
+		if ch, ok := frequency.DeviceChannels(radio.Band, dev).Index(radio.Frequency); ok {
+			txCh = uint8(ch)
+		}
+	}
+	return decoded.Payload.CalculateUplinkMIC11(dev.NwkSKey, dev.SNwkSIntKey, confFCnt, txDR, txCh, message)
+}
+
 func (d *Decrypter) verifyAndDecryptMessage(decoded server.LoRaMessage) {
 	logging.Debug("Verifying message from device with DevAddr %s", decoded.Payload.MACPayload.FHDR.DevAddr)
 	deviceChan, err := d.context.Storage.Device.GetByDevAddr(decoded.Payload.MACPayload.FHDR.DevAddr)
@@ -192,13 +296,20 @@ func (d *Decrypter) verifyAndDecryptMessage(decoded server.LoRaMessage) {
 	for dev := range deviceChan {
 		checked++
 		logging.Debug("Testing MIC for device %s", dev.DeviceEUI)
-		mic, err := decoded.Payload.CalculateMIC(dev.NwkSKey, rawMessage[0:len(rawMessage)-4])
+		// The MIC is calculated with the full 32-bit frame counter
+		fhdr := &decoded.Payload.MACPayload.FHDR
+		fhdr.SetFullFCnt(inferFrameCounter(dev.FCntUp, fhdr.FCnt, maxFCntGap(decoded)))
+		mic, err := calculateUplinkMIC(dev, decoded, rawMessage[0:len(rawMessage)-4])
 		if err != nil {
 			logging.Info("Unable to calculate MIC for payload: %v (payload=%v) ", err, decoded.Payload)
 			continue
 		}
 		if mic == decoded.Payload.MIC {
 			matchingDevices = append(matchingDevices, dev)
+			continue
+		}
+		if switched, ok := d.switchToPendingSession(dev, decoded, rawMessage); ok {
+			matchingDevices = append(matchingDevices, switched)
 		}
 	}
 	if len(matchingDevices) == 0 && checked > 0 {
@@ -213,6 +324,29 @@ func (d *Decrypter) verifyAndDecryptMessage(decoded server.LoRaMessage) {
 	}
 }
 
+// switchToPendingSession checks the MIC with the device's pending session, if
+// it has one. The device switches to the pending session when the first
+// uplink verifies with the new keys [6.2.4.4]. Returns false if the MIC
+// doesn't match.
+func (d *Decrypter) switchToPendingSession(dev model.Device, decoded server.LoRaMessage, rawMessage []byte) (model.Device, bool) {
+	switched, ok := dev.WithPendingSession()
+	if !ok {
+		return dev, false
+	}
+	fhdr := &decoded.Payload.MACPayload.FHDR
+	fhdr.SetFullFCnt(inferFrameCounter(switched.FCntUp, fhdr.FCnt, maxFCntGap(decoded)))
+	mic, err := calculateUplinkMIC(switched, decoded, rawMessage[0:len(rawMessage)-4])
+	if err != nil || mic != decoded.Payload.MIC {
+		return dev, false
+	}
+	if err := d.context.Storage.Device.Update(switched); err != nil {
+		logging.Warning("Unable to switch device with EUI %s to the new session: %v", dev.DeviceEUI, err)
+		return dev, false
+	}
+	logging.Debug("Device %s switched to the session from the Rejoin-request", dev.DeviceEUI)
+	return switched, true
+}
+
 // Start launches the decrypter. It will loop forever until the input
 // channel closes. The output channel will be closed upon return.
 // BUG(stalehd): Doesn't do what it says -- decrypt
@@ -237,6 +371,14 @@ func (d *Decrypter) Start() {
 				}()
 				return
 			}
+			if decoded.Payload.MHDR.MType == protocol.RejoinRequest {
+				go func() {
+					if !d.processRejoinRequest(decoded) {
+						decoded.FrameContext.GatewayContext.SectionTimer.End()
+					}
+				}()
+				return
+			}
 
 			d.verifyAndDecryptMessage(decoded)
 		}(m)
@@ -19,6 +19,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
 	"github.com/ExploratoryEngineering/pubsub"
@@ -50,7 +52,8 @@ func TestDecrypterProcessing(t *testing.T) {
 
 	s := NewStorageTestContext()
 	router := pubsub.NewEventRouter(5)
-	context := server.Context{Storage: &s, AppRouter: &router}
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	context := server.Context{Storage: &s, AppRouter: &router, UplinkHistory: &history}
 
 	input := make(chan server.LoRaMessage)
 
@@ -103,3 +106,83 @@ func TestDecrypterProcessing(t *testing.T) {
 	}
 	close(input)
 }
+
+func TestInferFrameCounter(t *testing.T) {
+	tests := []struct {
+		expected uint32
+		fcnt     uint16
+		result   uint32
+	}{
+		{0, 0, 0},
+		{10, 12, 12},
+		{10, 9, 9}, // Old frame
+		{0xFFFE, 0xFFFF, 0xFFFF},
+		{0xFFFE, 1, 0x10001}, // Rollover
+		{0x1FFFF, 2, 0x20002},
+		{0xFFFE, 0x4000, 0x4000}, // Too far off for a rollover
+		{0x10005, 0x0004, 0x10004},
+	}
+	for _, test := range tests {
+		if r := inferFrameCounter(test.expected, test.fcnt, 16384); r != test.result {
+			t.Errorf("Expected %x for expected=%x fcnt=%x but got %x", test.result, test.expected, test.fcnt, r)
+		}
+	}
+}
+
+func TestValidFrameCounter(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	d := Decrypter{}
+	msg := server.LoRaMessage{
+		Payload:      protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{GatewayContext: server.GatewayPacket{Radio: server.RadioContext{Band: eu}}},
+	}
+	device := model.NewDevice()
+	device.FCntUp = 0x10000
+
+	msg.Payload.MACPayload.FHDR.SetFullFCnt(0x10001)
+	if !d.validFrameCounter(&device, msg) {
+		t.Fatal("Expected frame counter above 65535 to be valid")
+	}
+	msg.Payload.MACPayload.FHDR.SetFullFCnt(0xFFFF)
+	if d.validFrameCounter(&device, msg) {
+		t.Fatal("Expected old frame counter to be rejected")
+	}
+	msg.Payload.MACPayload.FHDR.SetFullFCnt(0x10000 + eu.Configuration().MaxFCntGap)
+	if d.validFrameCounter(&device, msg) {
+		t.Fatal("Expected frame counter outside MaxFCntGap to be rejected")
+	}
+	device.RelaxedCounter = true
+	if !d.validFrameCounter(&device, msg) {
+		t.Fatal("Expected relaxed counter to accept any frame counter")
+	}
+}
+
+func TestCalculateUplinkMIC(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	msg := server.LoRaMessage{
+		Payload: createEncryptedTestMessage(),
+		FrameContext: server.FrameContext{GatewayContext: server.GatewayPacket{
+			Radio: server.RadioContext{Band: eu, DataRate: "SF9BW125", Frequency: 868.3},
+		}},
+	}
+	raw := []byte{1, 2, 3, 4}
+	device := model.NewDevice()
+	device.NwkSKey = protocol.AESKey{Key: [16]byte{1}}
+	device.SNwkSIntKey = protocol.AESKey{Key: [16]byte{2}}
+	device.FCntDn = 11
+
+	mic, _ := calculateUplinkMIC(device, msg, raw)
+	expected, _ := msg.Payload.CalculateMIC(device.NwkSKey, raw)
+	if mic != expected {
+		t.Fatalf("Expected LoRaWAN 1.0 MIC for device (%08x != %08x)", mic, expected)
+	}
+
+	// SF9BW125 is DR3 and 868.3 is the second channel
+	device.MACVersion = protocol.MACVersion11
+	msg.Payload.MACPayload.FHDR.FCtrl.ACK = true
+	mic, _ = calculateUplinkMIC(device, msg, raw)
+	expected, _ = msg.Payload.CalculateUplinkMIC11(device.NwkSKey, device.SNwkSIntKey, 10, 3, 1, raw)
+	if mic != expected {
+		t.Fatalf("Expected LoRaWAN 1.1 MIC for device (%08x != %08x)", mic, expected)
+	}
+}
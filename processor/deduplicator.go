@@ -0,0 +1,151 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/monitoring"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// dedupKey identifies a single frame sent by a device. Data frames are
+// identified by the DevAddr, frame counter and MIC. Join- and Rejoin-requests
+// are identified by the DevEUI, nonce or rejoin counter and MIC.
+type dedupKey struct {
+	mtype   protocol.MType
+	devAddr protocol.DevAddr
+	eui     protocol.EUI
+	counter uint16
+	mic     uint32
+}
+
+func newDedupKey(payload protocol.PHYPayload) dedupKey {
+	ret := dedupKey{mtype: payload.MHDR.MType, mic: payload.MIC}
+	switch payload.MHDR.MType {
+	case protocol.JoinRequest:
+		ret.eui = payload.JoinRequestPayload.DevEUI
+		ret.counter = payload.JoinRequestPayload.DevNonce
+	case protocol.RejoinRequest:
+		ret.eui = payload.RejoinRequestPayload.DevEUI
+		ret.counter = payload.RejoinRequestPayload.RJCount
+	default:
+		ret.devAddr = payload.MACPayload.FHDR.DevAddr
+		ret.counter = payload.MACPayload.FHDR.FCnt
+	}
+	return ret
+}
+
+// Deduplicator collects the copies of a frame received by different gateways.
+// The first copy opens a window and when the window closes a single message
+// is forwarded with all of the receptions. The gateway with the best link
+// quality is used for the message's gateway context.
+type Deduplicator struct {
+	input   <-chan server.LoRaMessage
+	output  chan server.LoRaMessage
+	context *server.Context
+	window  time.Duration
+	pending map[dedupKey]*server.LoRaMessage
+	mutex   *sync.Mutex
+	wg      *sync.WaitGroup
+}
+
+// sortReceptions sorts the receptions by link quality, best reception first.
+// The SNR is used first and then the RSSI.
+func sortReceptions(receptions []server.GatewayPacket) {
+	sort.SliceStable(receptions, func(i, j int) bool {
+		if receptions[i].Radio.SNR != receptions[j].Radio.SNR {
+			return receptions[i].Radio.SNR > receptions[j].Radio.SNR
+		}
+		return receptions[i].Radio.RSSI > receptions[j].Radio.RSSI
+	})
+}
+
+// add adds the message to the pending messages. Returns true if this is the
+// first copy of the frame.
+func (d *Deduplicator) add(key dedupKey, msg server.LoRaMessage) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	existing, ok := d.pending[key]
+	if ok {
+		logging.Debug("Frame from %s also received by gateway %s", msg.Payload.MACPayload.FHDR.DevAddr, msg.FrameContext.GatewayContext.Gateway.GatewayEUI)
+		existing.FrameContext.Receptions = append(existing.FrameContext.Receptions, msg.FrameContext.GatewayContext)
+		return false
+	}
+	msg.FrameContext.Receptions = []server.GatewayPacket{msg.FrameContext.GatewayContext}
+	d.pending[key] = &msg
+	return true
+}
+
+// forward waits until the window closes and forwards the frame
+func (d *Deduplicator) forward(key dedupKey) {
+	defer d.wg.Done()
+	time.Sleep(d.window)
+
+	d.mutex.Lock()
+	msg := d.pending[key]
+	delete(d.pending, key)
+	d.mutex.Unlock()
+
+	sortReceptions(msg.FrameContext.Receptions)
+	msg.FrameContext.GatewayContext = msg.FrameContext.Receptions[0]
+	monitoring.Stopwatch(monitoring.DeduplicatorChannelOut, func() {
+		d.output <- *msg
+	})
+	monitoring.Deduplicator.Increment()
+}
+
+// Start launches the deduplicator. It will terminate when the input channel
+// is closed and all of the pending frames are forwarded. On exit the output
+// channel will be closed.
+func (d *Deduplicator) Start() {
+	for msg := range d.input {
+		key := newDedupKey(msg.Payload)
+		if d.add(key, msg) {
+			d.wg.Add(1)
+			go d.forward(key)
+		}
+	}
+	d.wg.Wait()
+	logging.Debug("Input channel for deduplicator closed. Terminating")
+	close(d.output)
+}
+
+// Output returns the output channel from the deduplicator. There's one
+// message for each frame regardless of the number of gateways that received
+// it.
+func (d *Deduplicator) Output() <-chan server.LoRaMessage {
+	return d.output
+}
+
+// NewDeduplicator creates a new deduplicator. Copies of a frame received
+// within the window are merged.
+func NewDeduplicator(context *server.Context, input <-chan server.LoRaMessage, window time.Duration) *Deduplicator {
+	return &Deduplicator{
+		input:   input,
+		output:  make(chan server.LoRaMessage),
+		context: context,
+		window:  window,
+		pending: make(map[dedupKey]*server.LoRaMessage),
+		mutex:   &sync.Mutex{},
+		wg:      &sync.WaitGroup{},
+	}
+}
@@ -0,0 +1,90 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+)
+
+func newDedupMessage(devAddr uint32, fcnt uint16, gateway uint64, snr float32) server.LoRaMessage {
+	ret := server.LoRaMessage{Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp)}
+	ret.Payload.MACPayload.FHDR.DevAddr = protocol.DevAddrFromUint32(devAddr)
+	ret.Payload.MACPayload.FHDR.FCnt = fcnt
+	ret.Payload.MIC = uint32(fcnt) * 17
+	ret.FrameContext.GatewayContext.Gateway.GatewayEUI = protocol.EUIFromUint64(gateway)
+	ret.FrameContext.GatewayContext.Radio.SNR = snr
+	ret.FrameContext.GatewayContext.ReceivedAt = time.Now()
+	return ret
+}
+
+func TestDeduplicator(t *testing.T) {
+	input := make(chan server.LoRaMessage)
+	dedup := NewDeduplicator(&server.Context{}, input, 50*time.Millisecond)
+	go dedup.Start()
+
+	// Three copies of one frame and a different frame from the same device
+	input <- newDedupMessage(1, 1, 1, -5)
+	input <- newDedupMessage(1, 1, 2, 7.5)
+	input <- newDedupMessage(1, 1, 3, 2)
+	input <- newDedupMessage(1, 2, 1, -5)
+
+	received := make(map[uint16]server.LoRaMessage)
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-dedup.Output():
+			received[msg.Payload.MACPayload.FHDR.FCnt] = msg
+		case <-time.After(time.Second):
+			t.Fatal("Did not get deduplicated frame")
+		}
+	}
+
+	msg := received[1]
+	if len(msg.FrameContext.Receptions) != 3 {
+		t.Fatalf("Expected 3 receptions but got %d", len(msg.FrameContext.Receptions))
+	}
+	if msg.FrameContext.GatewayContext.Gateway.GatewayEUI != protocol.EUIFromUint64(2) {
+		t.Fatalf("Expected the gateway with the best SNR but got %s", msg.FrameContext.GatewayContext.Gateway.GatewayEUI)
+	}
+	if msg.FrameContext.Receptions[1].Radio.SNR != 2 || msg.FrameContext.Receptions[2].Radio.SNR != -5 {
+		t.Fatalf("Receptions aren't sorted by SNR: %+v", msg.FrameContext.Receptions)
+	}
+	if len(received[2].FrameContext.Receptions) != 1 {
+		t.Fatalf("Expected a single reception for the second frame but got %d", len(received[2].FrameContext.Receptions))
+	}
+
+	// Join requests are deduplicated on the DevEUI and nonce
+	join := server.LoRaMessage{Payload: protocol.NewPHYPayload(protocol.JoinRequest)}
+	join.Payload.JoinRequestPayload.DevEUI = protocol.EUIFromUint64(1)
+	otherJoin := join
+	otherJoin.Payload.JoinRequestPayload.DevNonce = 1
+	if newDedupKey(join.Payload) == newDedupKey(otherJoin.Payload) {
+		t.Fatal("Expected different keys for Join-requests with different nonces")
+	}
+
+	close(input)
+	select {
+	case _, ok := <-dedup.Output():
+		if ok {
+			t.Fatal("Did not expect any more output")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected output channel to be closed")
+	}
+}
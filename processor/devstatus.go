@@ -0,0 +1,102 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// devStatusPendingLimit is the number of uplinks to wait for a DevStatusAns
+// before the DevStatusReq is sent again.
+const devStatusPendingLimit = 4
+
+// devStatusScheduler schedules DevStatusReq commands for devices at the
+// interval set on the application and stores the answers on the device.
+type devStatusScheduler struct {
+	context *server.Context
+	pending map[protocol.EUI]int // Number of uplinks since the request was sent
+	mutex   *sync.Mutex
+}
+
+func newDevStatusScheduler(context *server.Context) *devStatusScheduler {
+	return &devStatusScheduler{
+		context: context,
+		pending: make(map[protocol.EUI]int),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// processUplink schedules a DevStatusReq for the device if the last status
+// is older than the application's interval.
+func (d *devStatusScheduler) processUplink(msg server.LoRaMessage) {
+	mtype := msg.Payload.MHDR.MType
+	if mtype != protocol.UnconfirmedDataUp && mtype != protocol.ConfirmedDataUp {
+		return
+	}
+	interval := msg.FrameContext.Application.DeviceStatusInterval
+	if interval <= 0 {
+		return
+	}
+	device := msg.FrameContext.Device
+	if device.StatusTime != 0 && time.Since(time.Unix(0, device.StatusTime)) < interval {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if uplinks, exists := d.pending[device.DeviceEUI]; exists {
+		uplinks++
+		if uplinks < devStatusPendingLimit {
+			d.pending[device.DeviceEUI] = uplinks
+			return
+		}
+		logging.Info("No DevStatusAns from device %s after %d uplinks", device.DeviceEUI, uplinks)
+	}
+
+	if err := d.context.FrameOutput.AddMACCommand(device.DeviceEUI, protocol.NewDownlinkMACCommand(protocol.DevStatusReq)); err != nil {
+		logging.Warning("Unable to schedule DevStatusReq for device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	d.pending[device.DeviceEUI] = 0
+}
+
+// processAnswer stores the battery level and margin from the DevStatusAns on
+// the device.
+func (d *devStatusScheduler) processAnswer(device model.Device, ans *protocol.MACDevStatusAns) {
+	d.mutex.Lock()
+	delete(d.pending, device.DeviceEUI)
+	d.mutex.Unlock()
+
+	stored, err := d.context.Storage.Device.GetByEUI(device.DeviceEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	stored.BatteryLevel = ans.Battery
+	stored.DeviceMargin = ans.SignedMargin()
+	stored.StatusTime = time.Now().UnixNano()
+	if err := d.context.Storage.Device.Update(stored); err != nil {
+		logging.Warning("Unable to update device status for device %s: %v", device.DeviceEUI, err)
+	}
+}
@@ -0,0 +1,110 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func TestDevStatusScheduler(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	frameOutput := server.NewFrameOutputBuffer()
+	context := &server.Context{Storage: &store, FrameOutput: &frameOutput}
+
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(1)
+	store.Application.Put(app, model.SystemUserID)
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	store.Device.Put(device, app.AppEUI)
+
+	eu, _ := band.NewBand(band.EU868Band)
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device:         device,
+			Application:    app,
+			GatewayContext: server.GatewayPacket{Radio: server.RadioContext{Band: eu, DataRate: "SF7BW125"}},
+		},
+	}
+
+	hasStatusReq := func() bool {
+		payload, err := frameOutput.GetPHYPayloadForDevice(&device, &msg.FrameContext)
+		if err != nil {
+			return false
+		}
+		for _, v := range payload.MACPayload.MACCommands.List() {
+			if v.ID() == protocol.DevStatusReq {
+				return true
+			}
+		}
+		return false
+	}
+
+	scheduler := newDevStatusScheduler(context)
+
+	// Interval isn't set for the application. No requests.
+	scheduler.processUplink(msg)
+	if hasStatusReq() {
+		t.Fatal("Did not expect DevStatusReq when interval isn't set")
+	}
+
+	msg.FrameContext.Application.DeviceStatusInterval = time.Hour
+	scheduler.processUplink(msg)
+	if !hasStatusReq() {
+		t.Fatal("Expected DevStatusReq for device without status")
+	}
+
+	// Request is pending. Wait a few uplinks before sending a new one.
+	scheduler.processUplink(msg)
+	if hasStatusReq() {
+		t.Fatal("Did not expect a new DevStatusReq while one is pending")
+	}
+	for i := 0; i < devStatusPendingLimit; i++ {
+		scheduler.processUplink(msg)
+	}
+	if !hasStatusReq() {
+		t.Fatal("Expected a new DevStatusReq after the pending limit")
+	}
+
+	scheduler.processAnswer(device, &protocol.MACDevStatusAns{Battery: 127, Margin: 0x3E})
+	stored, _ := store.Device.GetByEUI(device.DeviceEUI)
+	if stored.BatteryLevel != 127 || stored.DeviceMargin != -2 || stored.StatusTime == 0 {
+		t.Fatalf("Device status wasn't stored: %+v", stored)
+	}
+
+	// The status is fresh. No new requests.
+	msg.FrameContext.Device = stored
+	scheduler.processUplink(msg)
+	if hasStatusReq() {
+		t.Fatal("Did not expect DevStatusReq when status is recent")
+	}
+
+	// ...until the status is older than the interval
+	msg.FrameContext.Device.StatusTime = time.Now().Add(-2 * time.Hour).UnixNano()
+	scheduler.processUplink(msg)
+	if !hasStatusReq() {
+		t.Fatal("Expected DevStatusReq when status is old")
+	}
+}
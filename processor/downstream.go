@@ -0,0 +1,111 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// prepareDownstream adds the message at the head of the device's downstream
+// queue to the frame output. Confirmed messages are sent again on the
+// following uplinks until the device acknowledges them. The server gives up
+// on a message when it expires or when it has been sent more than the
+// configured number of retries and continues with the next message in the
+// queue. The attempts are counted when the message is sent. Payloads
+// encrypted end-to-end can't be sent once the device's frame counter has
+// passed the frame counter the payload is encrypted with. This means that
+// these messages are only sent once. The frame pending flag is set if there
+// are more messages waiting. Returns true if a message is added to the
+// output.
+func prepareDownstream(context *server.Context, device model.Device, application model.Application, queue []model.DownstreamMessage) bool {
+	now := time.Now()
+	for i, msg := range queue {
+		if msg.IsComplete() {
+			continue
+		}
+		if msg.HasExpired(now) || int(msg.Attempts) > context.Config.DownlinkRetries {
+			failDownstream(context, device, application, msg, now)
+			continue
+		}
+		if application.EndToEnd && msg.FCnt < device.FCntDn {
+			failDownstream(context, device, application, msg, now)
+			continue
+		}
+		logging.Debug("Setting downstream message payload (%v) for device %s (attempt %d)", msg.Payload(), device.DeviceEUI, msg.Attempts+1)
+		context.FrameOutput.SetDownstreamMessage(msg)
+		// The queue is sorted with the completed messages last
+		context.FrameOutput.SetFramePendingFlag(device.DeviceEUI, i+1 < len(queue) && !queue[i+1].IsComplete())
+		return true
+	}
+	return false
+}
+
+// downstreamSent records that the downstream message is sent to the device.
+// The sent time and the receive window are updated and the attempt is counted.
+func downstreamSent(context *server.Context, msg *model.DownstreamMessage, window band.RXWindowType, now time.Time) {
+	msg.SentTime = now.Unix()
+	msg.Attempts++
+	msg.RXWindow = rxWindowNumber(window)
+	if err := context.Storage.DeviceData.UpdateDownstream(msg.DeviceEUI, msg.ID, msg.SentTime, 0); err != nil {
+		if err != storage.ErrNotFound {
+			logging.Warning("Unable to update downstream message for device %s: %v", msg.DeviceEUI, err)
+		}
+		return
+	}
+	if err := context.Storage.DeviceData.UpdateDownstreamAttempts(msg.DeviceEUI, msg.ID, msg.Attempts, 0); err != nil {
+		logging.Warning("Unable to update downstream attempts for device %s: %v", msg.DeviceEUI, err)
+	}
+	downstreamWindow(context, msg, window)
+}
+
+// downstreamWindow records the receive window the downstream message is sent
+// in. The window changes if the gateway rejects the message and it is sent
+// again in the second receive window.
+func downstreamWindow(context *server.Context, msg *model.DownstreamMessage, window band.RXWindowType) {
+	msg.RXWindow = rxWindowNumber(window)
+	if err := context.Storage.DeviceData.UpdateDownstreamWindow(msg.DeviceEUI, msg.ID, msg.RXWindow); err != nil && err != storage.ErrNotFound {
+		logging.Warning("Unable to update downstream window for device %s: %v", msg.DeviceEUI, err)
+	}
+}
+
+// rxWindowNumber returns the number (1 or 2) for the receive window
+func rxWindowNumber(window band.RXWindowType) uint8 {
+	if window == band.RX2 {
+		return 2
+	}
+	return 1
+}
+
+// failDownstream gives up on the downstream message and notifies the
+// application.
+func failDownstream(context *server.Context, device model.Device, application model.Application, msg model.DownstreamMessage, now time.Time) {
+	msg.FailedTime = now.Unix()
+	logging.Info("Giving up downstream message %d to device %s after %d attempts (%s)", msg.ID, device.DeviceEUI, msg.Attempts, msg.State())
+	if err := context.Storage.DeviceData.UpdateDownstreamAttempts(device.DeviceEUI, msg.ID, msg.Attempts, msg.FailedTime); err != nil {
+		logging.Warning("Unable to update downstream message for device %s: %v", device.DeviceEUI, err)
+	}
+	context.AppRouter.Publish(application.AppEUI, &server.DownstreamStatus{
+		Device:      device,
+		Application: application,
+		Message:     msg,
+	})
+}
@@ -0,0 +1,196 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+	"github.com/ExploratoryEngineering/pubsub"
+)
+
+func TestPrepareDownstream(t *testing.T) {
+	s := memstore.CreateMemoryStorage(0, 0)
+	router := pubsub.NewEventRouter(5)
+	frameOutput := server.NewFrameOutputBuffer()
+	config := server.NewDefaultConfig()
+	config.DownlinkRetries = 2
+	context := &server.Context{Storage: &s, AppRouter: &router, FrameOutput: &frameOutput, Config: config}
+
+	application := model.NewApplication()
+	application.AppEUI = protocol.EUIFromUint64(1)
+	device := model.NewDevice()
+	device.AppEUI = application.AppEUI
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+
+	appOutput := router.Subscribe(application.AppEUI)
+	defer router.Unsubscribe(appOutput)
+
+	queue := func() []model.DownstreamMessage {
+		ret, err := s.DeviceData.ListDownstream(device.DeviceEUI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+
+	msg := model.NewDownstreamMessage(device.DeviceEUI, 1)
+	msg.Data = "aabbcc"
+	msg.Ack = true
+	if err := s.DeviceData.PutDownstream(device.DeviceEUI, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message is sent on the first uplink and the next two retries. The
+	// attempts and the receive window are recorded when the message is sent.
+	windows := []band.RXWindowType{band.RX1, band.RX2, band.RX1}
+	for i := 1; i <= 3; i++ {
+		if !prepareDownstream(context, device, application, queue()) {
+			t.Fatalf("Expected message to be sent on attempt %d", i)
+		}
+		msg = queue()[0]
+		if msg.Attempts != uint8(i-1) {
+			t.Fatalf("Expected %d attempts before sending but got %d", i-1, msg.Attempts)
+		}
+		downstreamSent(context, &msg, windows[i-1], time.Now())
+		if msg = queue()[0]; msg.Attempts != uint8(i) || msg.State() != model.SentState || msg.RXWindow != uint8(windows[i-1])+1 {
+			t.Fatalf("Expected %d attempts after sending but got %+v", i, msg)
+		}
+	}
+
+	// ...and the server gives up on the fourth
+	if prepareDownstream(context, device, application, queue()) {
+		t.Fatal("Did not expect message to be sent after the retries")
+	}
+	msg = queue()[0]
+	if msg.State() != model.FailedState {
+		t.Fatalf("Expected message to fail but state is %s", msg.State())
+	}
+	select {
+	case p := <-appOutput:
+		status, ok := p.(*server.DownstreamStatus)
+		if !ok || status.Message.State() != model.FailedState || status.Device.DeviceEUI != device.DeviceEUI {
+			t.Fatalf("Unexpected message on application output: %+v", p)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("No status on application output")
+	}
+
+	// Failed messages are completed and won't be sent again
+	if prepareDownstream(context, device, application, queue()) {
+		t.Fatal("Did not expect failed message to be sent")
+	}
+
+	// Expired messages are never sent. The next message in the queue is
+	// sent instead.
+	s.DeviceData.DeleteDownstream(device.DeviceEUI)
+	expired := model.NewDownstreamMessage(device.DeviceEUI, 1)
+	expired.Data = "aabbcc"
+	expired.ExpiresTime = time.Now().Add(-time.Minute).Unix()
+	s.DeviceData.PutDownstream(device.DeviceEUI, expired)
+	next := model.NewDownstreamMessage(device.DeviceEUI, 2)
+	next.Data = "ddeeff"
+	s.DeviceData.PutDownstream(device.DeviceEUI, next)
+	last := model.NewDownstreamMessage(device.DeviceEUI, 3)
+	last.Data = "ddeeff"
+	s.DeviceData.PutDownstream(device.DeviceEUI, last)
+
+	if !prepareDownstream(context, device, application, queue()) {
+		t.Fatal("Expected the next message to be sent")
+	}
+	for _, v := range queue() {
+		if v.ID == expired.ID && (v.State() != model.ExpiredState || v.Attempts != 0) {
+			t.Fatalf("Expected message to expire without attempts: %+v", v)
+		}
+		if v.ID == next.ID && v.IsComplete() {
+			t.Fatalf("Expected next message to be sent: %+v", v)
+		}
+	}
+
+	// The frame pending flag is set since there's another message waiting
+	frameContext := server.FrameContext{}
+	frameContext.GatewayContext.Radio.Band = euBand
+	frameContext.GatewayContext.Radio.DataRate = "SF7BW125"
+	payload, err := frameOutput.GetPHYPayloadForDevice(&device, &frameContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !payload.MACPayload.FHDR.FCtrl.FPending || payload.MACPayload.FPort != 2 {
+		t.Fatalf("Expected message on port 2 with frame pending flag: %+v", payload.MACPayload)
+	}
+	if frameContext.Downstream == nil || frameContext.Downstream.ID != next.ID {
+		t.Fatalf("Expected downstream message in frame context but got %+v", frameContext.Downstream)
+	}
+
+	// The last message doesn't set the flag
+	s.DeviceData.UpdateDownstream(device.DeviceEUI, next.ID, time.Now().Unix(), 0)
+	if !prepareDownstream(context, device, application, queue()) {
+		t.Fatal("Expected the last message to be sent")
+	}
+	frameContext.Downstream = nil
+	payload, _ = frameOutput.GetPHYPayloadForDevice(&device, &frameContext)
+	if payload.MACPayload.FHDR.FCtrl.FPending || payload.MACPayload.FPort != 3 || frameContext.Downstream.ID != last.ID {
+		t.Fatalf("Expected last message without frame pending flag: %+v", payload.MACPayload)
+	}
+}
+
+// Payloads encrypted end-to-end can't be sent once the device's frame counter
+// has passed the message's frame counter.
+func TestPrepareEncryptedDownstream(t *testing.T) {
+	s := memstore.CreateMemoryStorage(0, 0)
+	router := pubsub.NewEventRouter(5)
+	frameOutput := server.NewFrameOutputBuffer()
+	context := &server.Context{Storage: &s, AppRouter: &router, FrameOutput: &frameOutput, Config: server.NewDefaultConfig()}
+
+	application := model.NewApplication()
+	application.AppEUI = protocol.EUIFromUint64(1)
+	application.EndToEnd = true
+	device := model.NewDevice()
+	device.AppEUI = application.AppEUI
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	device.FCntDn = 10
+
+	appOutput := router.Subscribe(application.AppEUI)
+	defer router.Unsubscribe(appOutput)
+
+	old := model.NewDownstreamMessage(device.DeviceEUI, 1)
+	old.Data = "aabbcc"
+	old.FCnt = 9
+	s.DeviceData.PutDownstream(device.DeviceEUI, old)
+	next := model.NewDownstreamMessage(device.DeviceEUI, 2)
+	next.Data = "ddeeff"
+	next.FCnt = 10
+	s.DeviceData.PutDownstream(device.DeviceEUI, next)
+
+	queue, _ := s.DeviceData.ListDownstream(device.DeviceEUI)
+	if !prepareDownstream(context, device, application, queue) {
+		t.Fatal("Expected the message with the current frame counter to be sent")
+	}
+	select {
+	case p := <-appOutput:
+		status, ok := p.(*server.DownstreamStatus)
+		if !ok || status.Message.ID != old.ID || status.Message.State() != model.FailedState {
+			t.Fatalf("Expected the old message to fail but got %+v", p)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("No status on application output")
+	}
+}
@@ -21,7 +21,6 @@ import (
 	"github.com/ExploratoryEngineering/congress/monitoring"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
-	"github.com/ExploratoryEngineering/congress/storage"
 	"github.com/ExploratoryEngineering/logging"
 )
 
@@ -34,6 +33,36 @@ type Encoder struct {
 	context *server.Context
 }
 
+// encryptedDownstream returns true if the payload is a downstream message
+// the application has encrypted with the AppSKey.
+func encryptedDownstream(packet server.LoRaMessage) bool {
+	return packet.FrameContext.Application.EndToEnd &&
+		packet.FrameContext.Downstream != nil &&
+		packet.Payload.MACPayload.FPort != 0
+}
+
+// encodeMessage encodes a data message. The MIC for LoRaWAN 1.1 devices
+// includes the frame counter of the uplink if the message is an
+// acknowledgement [4.4].
+func encodeMessage(packet server.LoRaMessage) ([]byte, error) {
+	device := packet.FrameContext.Device
+	encrypted := encryptedDownstream(packet)
+	if device.MACVersion != protocol.MACVersion11 {
+		if encrypted {
+			return packet.Payload.EncodeEncryptedMessage(device.NwkSKey)
+		}
+		return packet.Payload.EncodeMessage(device.NwkSKey, device.AppSKey)
+	}
+	var confFCnt uint16
+	if packet.Payload.MACPayload.FHDR.FCtrl.ACK {
+		confFCnt = uint16(device.FCntUp - 1)
+	}
+	if encrypted {
+		return packet.Payload.EncodeEncryptedMessage11(device.SNwkSIntKey, device.NwkSEncKey, confFCnt)
+	}
+	return packet.Payload.EncodeMessage11(device.SNwkSIntKey, device.NwkSEncKey, device.AppSKey, confFCnt)
+}
+
 func (e *Encoder) processMessage(packet server.LoRaMessage) {
 	packet.FrameContext.GatewayContext.SectionTimer.Begin(monitoring.TimeEncoder)
 	var buffer []byte
@@ -50,8 +79,8 @@ func (e *Encoder) processMessage(packet server.LoRaMessage) {
 	case protocol.ConfirmedDataUp:
 		logging.Warning("Unsupported encoding: ConfirmedDataUp (context=%v)", packet.FrameContext)
 
-	case protocol.RFU:
-		logging.Warning("Unsupported encoding: RFU(context=%v)", packet.FrameContext)
+	case protocol.RejoinRequest:
+		logging.Warning("Unsupported encoding: RejoinRequest (context=%v)", packet.FrameContext)
 
 	case protocol.Proprietary:
 		logging.Warning("Unsupported encoding: Proprietary message (context=%v)", packet.FrameContext)
@@ -65,20 +94,29 @@ func (e *Encoder) processMessage(packet server.LoRaMessage) {
 			return
 		}
 
-		buffer, err = packet.Payload.EncodeJoinAccept(packet.FrameContext.Device.AppKey)
-		if err != nil {
-			logging.Warning("Unable to encode JoinAccept message for device with EUI %s (DevAddr=%s): %v",
+		// The JoinAccept message is encrypted by the join server
+		buffer = packet.FrameContext.JoinAccept
+		if buffer == nil {
+			logging.Warning("Missing JoinAccept message for device with EUI %s (DevAddr=%s)",
 				packet.FrameContext.Device.DeviceEUI,
-				packet.FrameContext.Device.DevAddr,
-				err)
+				packet.FrameContext.Device.DevAddr)
 			return
 		}
-		packet.FrameContext.GatewayContext.Radio.RX1Delay = 5
-		packet.FrameContext.GatewayContext.Deadline = 5
 
 	default:
-		packet.Payload.MACPayload.FHDR.FCnt = packet.FrameContext.Device.FCntDn
-		buffer, err = packet.Payload.EncodeMessage(packet.FrameContext.Device.NwkSKey, packet.FrameContext.Device.AppSKey)
+		// The application has encrypted the payload with its own frame
+		// counter. The device's frame counter skips ahead to it.
+		if encryptedDownstream(packet) {
+			fcnt := packet.FrameContext.Downstream.FCnt
+			if fcnt < packet.FrameContext.Device.FCntDn {
+				logging.Warning("Frame counter for encrypted downstream message to device %s is %d but the device's frame counter is %d. Ignoring message.",
+					packet.FrameContext.Device.DeviceEUI, fcnt, packet.FrameContext.Device.FCntDn)
+				return
+			}
+			packet.FrameContext.Device.FCntDn = fcnt
+		}
+		packet.Payload.MACPayload.FHDR.SetFullFCnt(packet.FrameContext.Device.FCntDn)
+		buffer, err = encodeMessage(packet)
 		if err != nil {
 			logging.Error("Unable to encode message for device with EUI %s: %v. (DevAddr=%s)",
 				packet.FrameContext.Device.DeviceEUI,
@@ -87,10 +125,13 @@ func (e *Encoder) processMessage(packet server.LoRaMessage) {
 			return
 		}
 
-		// Update the sent time for the message
-		sentTime := time.Now().Unix()
-		if err := e.context.Storage.DeviceData.UpdateDownstream(packet.FrameContext.Device.DeviceEUI, sentTime, 0); err != nil && err != storage.ErrNotFound {
-			logging.Warning("Unable to update downstream message for device %s: %v", packet.FrameContext.Device.DeviceEUI, err)
+		// Update the sent time and the attempts for the message
+		if downstream := packet.FrameContext.Downstream; downstream != nil {
+			downstreamSent(e.context, downstream, packet.FrameContext.GatewayContext.Radio.RXWindow, time.Now())
+			// The message is marked as failed if the gateway rejects it
+			if fallback := packet.FrameContext.GatewayContext.Fallback; fallback != nil {
+				fallback.Downstream = downstream
+			}
 		}
 
 		// Increase the frame counter after the message is sent. New devices will get 0,1,2...
@@ -100,9 +141,10 @@ func (e *Encoder) processMessage(packet server.LoRaMessage) {
 				packet.FrameContext.Device.DeviceEUI,
 				err)
 		}
-		packet.FrameContext.GatewayContext.Radio.RX1Delay = 1
-		packet.FrameContext.GatewayContext.Deadline = 1
 	}
+	// The scheduler has set the receive window for the message. The gateway
+	// must send the message before the window opens.
+	packet.FrameContext.GatewayContext.Deadline = packet.FrameContext.GatewayContext.Radio.RXDelay().Seconds()
 
 	if len(buffer) == 0 {
 		return
@@ -119,6 +161,9 @@ func (e *Encoder) processMessage(packet server.LoRaMessage) {
 			OutTimer:     packet.FrameContext.GatewayContext.OutTimer,
 			ReceivedAt:   packet.FrameContext.GatewayContext.ReceivedAt,
 			Deadline:     packet.FrameContext.GatewayContext.Deadline,
+			Immediate:    packet.FrameContext.GatewayContext.Immediate,
+			TXTime:       packet.FrameContext.GatewayContext.TXTime,
+			Fallback:     packet.FrameContext.GatewayContext.Fallback,
 		}
 	})
 	monitoring.Encoder.Increment()
@@ -16,6 +16,8 @@ package processor
 //limitations under the License.
 //
 import (
+	"bytes"
+	"encoding/binary"
 	"testing"
 	"time"
 
@@ -109,7 +111,7 @@ func TestEncoderUnknownPackets(t *testing.T) {
 	input <- makeMessage(protocol.ConfirmedDataUp)
 	ensureNoOutput()
 
-	input <- makeMessage(protocol.RFU)
+	input <- makeMessage(protocol.RejoinRequest)
 	ensureNoOutput()
 
 	input <- makeMessage(protocol.Proprietary)
@@ -135,6 +137,8 @@ func TestJoinAcceptEncoder(t *testing.T) {
 
 	go encoder.Start()
 
+	// The JoinAccept message is encrypted by the join server
+	encrypted := []byte{0x20, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
 	payload := protocol.NewPHYPayload(protocol.JoinAccept)
 	input <- server.LoRaMessage{
 		Payload: payload,
@@ -142,13 +146,132 @@ func TestJoinAcceptEncoder(t *testing.T) {
 			Device:         d,
 			Application:    a,
 			GatewayContext: server.GatewayPacket{},
+			JoinAccept:     encrypted,
 		},
 	}
 
 	select {
-	case <-output:
-		// OK - no message received
+	case p := <-output:
+		if !bytes.Equal(p.RawMessage, encrypted) {
+			t.Fatalf("Expected the encrypted JoinAccept but got %v", p.RawMessage)
+		}
 	case <-time.After(10 * time.Millisecond):
 		t.Fatalf("Message timed out")
 	}
+
+	// Nothing is sent if the JoinAccept message is missing
+	input <- server.LoRaMessage{
+		Payload:      payload,
+		FrameContext: server.FrameContext{Device: d, Application: a},
+	}
+	select {
+	case <-output:
+		t.Fatal("Did not expect output without JoinAccept message")
+	case <-time.After(10 * time.Millisecond):
+		// OK
+	}
+}
+
+// LoRaWAN 1.1 devices use different keys and MIC calculations
+func TestEncoderLoRaWAN11(t *testing.T) {
+	d := model.NewDevice()
+	d.DeviceEUI = protocol.EUIFromUint64(1)
+	d.AppEUI = protocol.EUIFromUint64(2)
+	d.DevAddr = protocol.DevAddr{NwkID: 1, NwkAddr: 2}
+	d.MACVersion = protocol.MACVersion11
+	d.NwkKey = protocol.AESKey{Key: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}}
+	d.SNwkSIntKey = protocol.AESKey{Key: [16]byte{2}}
+	d.NwkSEncKey = protocol.AESKey{Key: [16]byte{3}}
+	d.AppSKey = protocol.AESKey{Key: [16]byte{4}}
+	d.FCntUp = 0x10
+	d.FCntDn = 0x20
+
+	packet := server.LoRaMessage{FrameContext: server.FrameContext{Device: d}}
+	packet.Payload = protocol.NewPHYPayload(protocol.UnconfirmedDataDown)
+	packet.Payload.MACPayload.FHDR.DevAddr = d.DevAddr
+	packet.Payload.MACPayload.FHDR.FCtrl.ACK = true
+	packet.Payload.MACPayload.FPort = 1
+	packet.Payload.MACPayload.FRMPayload = []byte{1, 2, 3}
+	packet.Payload.MACPayload.FHDR.SetFullFCnt(d.FCntDn)
+	buf, err := encodeMessage(packet)
+	if err != nil {
+		t.Fatal("Unable to encode message: ", err)
+	}
+	mic, _ := packet.Payload.CalculateDownlinkMIC11(d.SNwkSIntKey, 0x0F, buf[0:len(buf)-4])
+	if sent := binary.LittleEndian.Uint32(buf[len(buf)-4:]); mic != sent {
+		t.Fatalf("MIC for downlink should include the uplink frame counter (%08x != %08x)", mic, sent)
+	}
+}
+
+// Payloads encrypted end-to-end are sent with the application's frame counter
+func TestEncoderEndToEnd(t *testing.T) {
+	s := NewStorageTestContext()
+	a := model.NewApplication()
+	a.AppEUI = TestAppEUI
+	a.EndToEnd = true
+	s.Application.Put(a, model.SystemUserID)
+	d := model.NewDevice()
+	d.DeviceEUI = protocol.EUIFromUint64(1)
+	d.DevAddr = protocol.DevAddr{NwkID: 1, NwkAddr: 2}
+	d.NwkSKey = protocol.AESKey{Key: [16]byte{1}}
+	d.FCntDn = 5
+	s.Device.Put(d, TestAppEUI)
+
+	// The application encrypts the payload with the AppSKey
+	appSKey := protocol.AESKey{Key: [16]byte{2}}
+	encrypted := protocol.NewPHYPayload(protocol.UnconfirmedDataDown)
+	encrypted.MACPayload.FHDR.DevAddr = d.DevAddr
+	encrypted.MACPayload.FHDR.SetFullFCnt(9)
+	encrypted.MACPayload.FPort = 1
+	encrypted.MACPayload.FRMPayload = []byte{1, 2, 3}
+	encrypted.Decrypt(d.NwkSKey, appSKey)
+
+	context := server.Context{Storage: &s}
+	input := make(chan server.LoRaMessage)
+	output := make(chan server.GatewayPacket)
+	go NewEncoder(&context, input, output).Start()
+	defer close(input)
+
+	send := func(fcnt uint32) {
+		msg := model.NewDownstreamMessage(d.DeviceEUI, 1)
+		msg.FCnt = fcnt
+		payload := protocol.NewPHYPayload(protocol.UnconfirmedDataDown)
+		payload.MACPayload.FHDR.DevAddr = d.DevAddr
+		payload.MACPayload.FPort = 1
+		payload.MACPayload.FRMPayload = encrypted.MACPayload.FRMPayload
+		input <- server.LoRaMessage{
+			Payload:      payload,
+			FrameContext: server.FrameContext{Device: d, Application: a, Downstream: &msg},
+		}
+	}
+
+	send(9)
+	select {
+	case p := <-output:
+		received := protocol.NewPHYPayload(protocol.UnconfirmedDataDown)
+		if err := received.UnmarshalBinary(p.RawMessage); err != nil {
+			t.Fatal("Unable to decode message: ", err)
+		}
+		if received.MACPayload.FHDR.FCnt != 9 {
+			t.Fatalf("Expected frame counter 9 but got %d", received.MACPayload.FHDR.FCnt)
+		}
+		received.Decrypt(d.NwkSKey, appSKey)
+		if !bytes.Equal(received.MACPayload.FRMPayload, []byte{1, 2, 3}) {
+			t.Fatalf("Payload doesn't decrypt with the AppSKey: %v", received.MACPayload.FRMPayload)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Got timeout reading output channel")
+	}
+	if stored, _ := s.Device.GetByEUI(d.DeviceEUI); stored.FCntDn != 10 {
+		t.Fatalf("Expected frame counter to be 10 but it is %d", stored.FCntDn)
+	}
+
+	// The frame counter can't go backwards
+	send(3)
+	select {
+	case <-output:
+		t.Fatal("Did not expect message with an old frame counter to be sent")
+	case <-time.After(50 * time.Millisecond):
+		// OK
+	}
 }
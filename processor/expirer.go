@@ -0,0 +1,88 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// downstreamExpiryInterval is the time between each check for expired
+// downstream messages.
+const downstreamExpiryInterval = time.Minute
+
+// DownstreamExpirer gives up on downstream messages when they expire. The
+// queue is otherwise only checked when the device sends an uplink or when
+// there's a class B or C downlink so the applications wouldn't be notified
+// for devices that are silent.
+type DownstreamExpirer struct {
+	context   *server.Context
+	terminate chan bool
+}
+
+// NewDownstreamExpirer creates a new downstream expirer.
+func NewDownstreamExpirer(context *server.Context) *DownstreamExpirer {
+	return &DownstreamExpirer{
+		context:   context,
+		terminate: make(chan bool),
+	}
+}
+
+// Start launches the downstream expirer. It runs until Stop is called.
+func (e *DownstreamExpirer) Start() {
+	ticker := time.NewTicker(downstreamExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			e.expireDownstream(now)
+		case <-e.terminate:
+			logging.Debug("Downstream expirer terminated")
+			return
+		}
+	}
+}
+
+// Stop stops the downstream expirer.
+func (e *DownstreamExpirer) Stop() {
+	e.terminate <- true
+}
+
+// expireDownstream fails the messages that have expired and notifies the
+// applications.
+func (e *DownstreamExpirer) expireDownstream(now time.Time) {
+	expired, err := e.context.Storage.DeviceData.ListExpiredDownstream(now.Unix())
+	if err != nil {
+		logging.Warning("Unable to list expired downstream messages: %v", err)
+		return
+	}
+	for _, msg := range expired {
+		device, err := e.context.Storage.Device.GetByEUI(msg.DeviceEUI)
+		if err != nil {
+			logging.Warning("Unable to retrieve device %s for expired downstream message: %v", msg.DeviceEUI, err)
+			continue
+		}
+		application, err := e.context.Storage.Application.GetByEUI(device.AppEUI, model.SystemUserID)
+		if err != nil {
+			logging.Warning("Unable to retrieve application %s for expired downstream message: %v", device.AppEUI, err)
+			continue
+		}
+		failDownstream(e.context, device, application, msg, now)
+	}
+}
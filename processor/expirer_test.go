@@ -0,0 +1,85 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+	"github.com/ExploratoryEngineering/pubsub"
+)
+
+func TestDownstreamExpirer(t *testing.T) {
+	s := memstore.CreateMemoryStorage(0, 0)
+	router := pubsub.NewEventRouter(5)
+	context := &server.Context{Storage: &s, AppRouter: &router, Config: server.NewDefaultConfig()}
+	expirer := NewDownstreamExpirer(context)
+
+	application := model.NewApplication()
+	application.AppEUI = protocol.EUIFromUint64(1)
+	s.Application.Put(application, model.SystemUserID)
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	device.DevAddr = protocol.DevAddrFromUint32(0x01020304)
+	s.Device.Put(device, application.AppEUI)
+
+	appOutput := router.Subscribe(application.AppEUI)
+	defer router.Unsubscribe(appOutput)
+
+	now := time.Now()
+	expired := model.NewDownstreamMessage(device.DeviceEUI, 1)
+	expired.Data = "aabbcc"
+	expired.ExpiresTime = now.Add(-time.Minute).Unix()
+	s.DeviceData.PutDownstream(device.DeviceEUI, expired)
+	pending := model.NewDownstreamMessage(device.DeviceEUI, 2)
+	pending.Data = "ddeeff"
+	pending.ExpiresTime = now.Add(time.Hour).Unix()
+	s.DeviceData.PutDownstream(device.DeviceEUI, pending)
+
+	expirer.expireDownstream(now)
+
+	select {
+	case p := <-appOutput:
+		status, ok := p.(*server.DownstreamStatus)
+		if !ok || status.Message.ID != expired.ID || status.Message.State() != model.ExpiredState {
+			t.Fatalf("Expected the message to expire but got %+v", p)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("No status on application output")
+	}
+
+	queue, _ := s.DeviceData.ListDownstream(device.DeviceEUI)
+	for _, v := range queue {
+		if v.ID == expired.ID && v.State() != model.ExpiredState {
+			t.Fatalf("Expected message to expire: %+v", v)
+		}
+		if v.ID == pending.ID && v.State() != model.UnsentState {
+			t.Fatalf("Expected message to stay in the queue: %+v", v)
+		}
+	}
+
+	// Messages are only expired once
+	expirer.expireDownstream(now)
+	select {
+	case p := <-appOutput:
+		t.Fatalf("Did not expect another status: %+v", p)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,219 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sync"
+
+	"github.com/ExploratoryEngineering/congress/monitoring"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// muxQueueSize is the number of downlinks that can be waiting for each
+// forwarder.
+const muxQueueSize = 100
+
+// Errors reported through the TX acks for downlinks the multiplexer drops
+const (
+	muxNoForwarder = "NO_FORWARDER"
+	muxQueueFull   = "QUEUE_FULL"
+)
+
+// muxForwarder is one of the forwarders in the multiplexer. Each forwarder
+// has its own downlink queue so a slow forwarder won't block the others.
+type muxForwarder struct {
+	name      string
+	forwarder GwForwarder
+	counters  *monitoring.MessageCounter
+	queue     chan server.GatewayPacket
+}
+
+// ForwarderMux multiplexes several gateway forwarders into one. Uplinks from
+// all of the forwarders are merged into the output channel. Downlinks are
+// routed to the forwarder the gateway is connected to or the forwarder that
+// received the last uplink from the gateway. Downlinks that can't be routed
+// are reported as rejected through the TX acks. The mux implements the
+// GwForwarder interface so it can be used as a regular forwarder in the
+// pipeline.
+type ForwarderMux struct {
+	context    *server.Context
+	input      chan server.GatewayPacket
+	output     chan server.GatewayPacket
+	terminate  chan bool
+	forwarders []muxForwarder
+	mutex      *sync.Mutex
+	routes     map[protocol.EUI]*muxForwarder // The forwarder for each gateway
+}
+
+// NewForwarderMux creates a new multiplexer without any forwarders. Dropped
+// downlinks are reported through the context's TX acks.
+func NewForwarderMux(context *server.Context) *ForwarderMux {
+	return &ForwarderMux{
+		context:   context,
+		input:     make(chan server.GatewayPacket),
+		output:    make(chan server.GatewayPacket),
+		terminate: make(chan bool),
+		mutex:     &sync.Mutex{},
+		routes:    make(map[protocol.EUI]*muxForwarder),
+	}
+}
+
+// Add adds a forwarder to the multiplexer. The name is used for the metrics
+// and must be unique. The forwarders must be added before the multiplexer is
+// started.
+func (m *ForwarderMux) Add(name string, forwarder GwForwarder) {
+	m.forwarders = append(m.forwarders, muxForwarder{
+		name:      name,
+		forwarder: forwarder,
+		counters:  monitoring.GetForwarderCounters(name),
+		queue:     make(chan server.GatewayPacket, muxQueueSize),
+	})
+}
+
+// Start launches all of the forwarders. It does not return until the
+// multiplexer is stopped.
+func (m *ForwarderMux) Start() {
+	uplinks := &sync.WaitGroup{}
+	downlinks := &sync.WaitGroup{}
+	for i := range m.forwarders {
+		f := &m.forwarders[i]
+		go f.forwarder.Start()
+		uplinks.Add(1)
+		go func() {
+			defer uplinks.Done()
+			m.readUplinks(f)
+		}()
+		downlinks.Add(1)
+		go func() {
+			defer downlinks.Done()
+			m.sendDownlinks(f)
+		}()
+	}
+	m.mainLoop()
+
+	logging.Debug("Input channel for forwarder mux closed. Terminating")
+	close(m.terminate)
+	for _, f := range m.forwarders {
+		close(f.queue)
+	}
+	downlinks.Wait()
+	for _, f := range m.forwarders {
+		f.forwarder.Stop()
+	}
+	uplinks.Wait()
+	close(m.output)
+}
+
+// Stop stops the multiplexer and all of the forwarders.
+func (m *ForwarderMux) Stop() {
+	close(m.input)
+}
+
+// Input returns the input channel for the multiplexer. The packets are
+// forwarded to the forwarder for the gateway.
+func (m *ForwarderMux) Input() chan<- server.GatewayPacket {
+	return m.input
+}
+
+// Output returns the output channel for the multiplexer. The uplinks from all
+// of the forwarders are sent on this channel.
+func (m *ForwarderMux) Output() <-chan server.GatewayPacket {
+	return m.output
+}
+
+// readUplinks forwards the uplinks from a forwarder until the forwarder's
+// output channel is closed. The uplinks are dropped when the multiplexer is
+// stopping.
+func (m *ForwarderMux) readUplinks(f *muxForwarder) {
+	for packet := range f.forwarder.Output() {
+		m.setRoute(packet.Gateway.GatewayEUI, f)
+		f.counters.MessagesIn.Increment()
+		select {
+		case m.output <- packet:
+		case <-m.terminate:
+		}
+	}
+}
+
+// sendDownlinks sends the queued downlinks to the forwarder until the queue
+// is closed. The remaining downlinks are dropped when the multiplexer is
+// stopping.
+func (m *ForwarderMux) sendDownlinks(f *muxForwarder) {
+	for packet := range f.queue {
+		select {
+		case f.forwarder.Input() <- packet:
+		case <-m.terminate:
+			return
+		}
+	}
+}
+
+func (m *ForwarderMux) setRoute(eui protocol.EUI, f *muxForwarder) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if old, ok := m.routes[eui]; ok && old != f {
+		logging.Info("Gateway %s moved from forwarder %s to %s", eui, old.name, f.name)
+	}
+	m.routes[eui] = f
+}
+
+// route returns the forwarder for a gateway. Forwarders that know which
+// gateways are connected to them are asked first, then the forwarder that
+// received the last uplink from the gateway is used. Gateways that haven't
+// sent an uplink use the first forwarder if there's only one.
+func (m *ForwarderMux) route(eui protocol.EUI) *muxForwarder {
+	for i := range m.forwarders {
+		if owner, ok := m.forwarders[i].forwarder.(GatewayOwner); ok && owner.HasGateway(eui) {
+			return &m.forwarders[i]
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if f, ok := m.routes[eui]; ok {
+		return f
+	}
+	if len(m.forwarders) == 1 {
+		return &m.forwarders[0]
+	}
+	return nil
+}
+
+// drop drops the downlink and reports it as rejected.
+func (m *ForwarderMux) drop(packet server.GatewayPacket, reason string) {
+	monitoring.DownlinkFailed.Increment()
+	m.context.TXAcks.Notify(server.TXAck{Packet: packet, Error: reason})
+}
+
+// mainLoop routes the downlinks until the input channel is closed.
+func (m *ForwarderMux) mainLoop() {
+	for packet := range m.input {
+		f := m.route(packet.Gateway.GatewayEUI)
+		if f == nil {
+			logging.Warning("No forwarder for gateway %s. Dropping downlink", packet.Gateway.GatewayEUI)
+			m.drop(packet, muxNoForwarder)
+			continue
+		}
+		select {
+		case f.queue <- packet:
+			f.counters.MessagesOut.Increment()
+		default:
+			logging.Warning("Downlink queue for forwarder %s is full. Dropping downlink to gateway %s", f.name, packet.Gateway.GatewayEUI)
+			m.drop(packet, muxQueueFull)
+		}
+	}
+}
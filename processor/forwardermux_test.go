@@ -0,0 +1,167 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/monitoring"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+)
+
+func countSum(series *monitoring.TimeSeries) uint32 {
+	ret := uint32(0)
+	for _, v := range series.GetCounts() {
+		ret += v
+	}
+	return ret
+}
+
+func TestForwarderMux(t *testing.T) {
+	f1 := newTestForwarder()
+	f2 := newTestForwarder()
+	txAcks := server.NewTXAckNotifier()
+	mux := NewForwarderMux(&server.Context{TXAcks: &txAcks})
+	mux.Add("test-1", f1)
+	mux.Add("test-2", f2)
+	go mux.Start()
+
+	gw1 := protocol.EUIFromUint64(1)
+	gw2 := protocol.EUIFromUint64(2)
+	in1 := countSum(monitoring.GetForwarderCounters("test-1").MessagesIn)
+	out2 := countSum(monitoring.GetForwarderCounters("test-2").MessagesOut)
+
+	// Uplinks from both forwarders are merged
+	for _, v := range []struct {
+		forwarder *testForwarder
+		eui       protocol.EUI
+	}{{f1, gw1}, {f2, gw2}} {
+		v.forwarder.injectMessage(server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: v.eui}})
+		select {
+		case p := <-mux.Output():
+			if p.Gateway.GatewayEUI != v.eui {
+				t.Fatalf("Expected uplink from %s but got %s", v.eui, p.Gateway.GatewayEUI)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not get uplink from %s", v.eui)
+		}
+	}
+
+	// Downlinks are routed to the forwarder for the gateway
+	mux.Input() <- server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: gw2}}
+	if p := f2.grabMessage(time.Second); p == nil || p.Gateway.GatewayEUI != gw2 {
+		t.Fatal("Expected downlink on second forwarder")
+	}
+	mux.Input() <- server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: gw1}}
+	if p := f1.grabMessage(time.Second); p == nil || p.Gateway.GatewayEUI != gw1 {
+		t.Fatal("Expected downlink on first forwarder")
+	}
+
+	// Downlinks to unknown gateways are dropped and reported through the
+	// TX acks
+	mux.Input() <- server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(3)}}
+	if f1.grabMessage(10*time.Millisecond) != nil || f2.grabMessage(10*time.Millisecond) != nil {
+		t.Fatal("Did not expect downlink to unknown gateway")
+	}
+	select {
+	case ack := <-txAcks.Acks():
+		if ack.Error != muxNoForwarder || ack.Packet.Gateway.GatewayEUI != protocol.EUIFromUint64(3) {
+			t.Fatalf("Unexpected TX ack: %+v", ack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected TX ack for dropped downlink")
+	}
+
+	if countSum(monitoring.GetForwarderCounters("test-1").MessagesIn) != in1+1 ||
+		countSum(monitoring.GetForwarderCounters("test-2").MessagesOut) != out2+1 {
+		t.Fatal("Forwarder counters aren't updated")
+	}
+
+	// Stopping the mux stops the forwarders and closes the output
+	mux.Stop()
+	select {
+	case _, ok := <-mux.Output():
+		if ok {
+			t.Fatal("Expected output channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Output channel isn't closed")
+	}
+}
+
+func TestForwarderMuxSingleForwarder(t *testing.T) {
+	f := newTestForwarder()
+	mux := NewForwarderMux(&server.Context{})
+	mux.Add("single", f)
+	go mux.Start()
+	defer mux.Stop()
+
+	// Gateways that haven't sent an uplink use the only forwarder
+	mux.Input() <- server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(1)}}
+	if f.grabMessage(time.Second) == nil {
+		t.Fatal("Expected downlink on the forwarder")
+	}
+}
+
+// ownerForwarder is a test forwarder that knows which gateways are connected
+type ownerForwarder struct {
+	*testForwarder
+	gateways map[protocol.EUI]bool
+}
+
+func (o *ownerForwarder) HasGateway(eui protocol.EUI) bool {
+	return o.gateways[eui]
+}
+
+func TestForwarderMuxGatewayOwner(t *testing.T) {
+	udp := newTestForwarder()
+	gw := protocol.EUIFromUint64(1)
+	station := &ownerForwarder{newTestForwarder(), map[protocol.EUI]bool{gw: true}}
+	txAcks := server.NewTXAckNotifier()
+	mux := NewForwarderMux(&server.Context{TXAcks: &txAcks})
+	mux.Add("owner-udp", udp)
+	mux.Add("owner-station", station)
+	go mux.Start()
+	defer mux.Stop()
+
+	// Connected gateways get downlinks before they have sent an uplink
+	mux.Input() <- server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: gw}}
+	if p := station.grabMessage(time.Second); p == nil || p.Gateway.GatewayEUI != gw {
+		t.Fatal("Expected downlink on the forwarder that owns the gateway")
+	}
+
+	// A forwarder that doesn't read its input doesn't block the others
+	blocked := protocol.EUIFromUint64(2)
+	udp.injectMessage(server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: blocked}})
+	<-mux.Output()
+	for i := 0; i < muxQueueSize+2; i++ {
+		mux.Input() <- server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: blocked}}
+	}
+	select {
+	case ack := <-txAcks.Acks():
+		if ack.Error != muxQueueFull {
+			t.Fatalf("Unexpected TX ack: %+v", ack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected TX ack when the queue is full")
+	}
+	mux.Input() <- server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: gw}}
+	if station.grabMessage(time.Second) == nil {
+		t.Fatal("Expected downlink on the station forwarder")
+	}
+}
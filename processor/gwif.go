@@ -17,6 +17,7 @@ package processor
 //
 import (
 	"github.com/ExploratoryEngineering/congress/gateway"
+	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
 )
 
@@ -34,6 +35,15 @@ type GwForwarder interface {
 	Output() <-chan server.GatewayPacket
 }
 
+// GatewayOwner is implemented by forwarders that know which gateways are
+// connected to them, f.e. forwarders where the gateways keep a connection
+// open. The forwarder multiplexer uses this to route downlinks to gateways
+// that haven't sent an uplink yet.
+type GatewayOwner interface {
+	// HasGateway returns true if the gateway is connected to the forwarder
+	HasGateway(eui protocol.EUI) bool
+}
+
 // NewGwForwarder creates a new gateway forwarder instance. The input and
 // output channels
 func NewGwForwarder(port int, context *server.Context) GwForwarder {
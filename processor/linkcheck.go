@@ -0,0 +1,87 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// maxLinkMargin is the highest margin that can be reported in a
+// LinkCheckAns. The values 255 and up are reserved [5.1]
+const maxLinkMargin = 254
+
+// linkMargin returns the demodulation margin (in dB) for a frame received
+// with the specified SNR and data rate. Negative margins are reported as 0.
+func linkMargin(plan band.FrequencyPlan, dataRate string, snr float32) (uint8, error) {
+	dr, err := plan.GetDataRate(dataRate)
+	if err != nil {
+		return 0, err
+	}
+	encoding, err := plan.Encoding(dr)
+	if err != nil {
+		return 0, err
+	}
+	floor, err := encoding.RequiredSNR()
+	if err != nil {
+		return 0, err
+	}
+	margin := snr - floor
+	switch {
+	case margin < 0:
+		return 0, nil
+	case margin > maxLinkMargin:
+		return maxLinkMargin, nil
+	default:
+		return uint8(margin), nil
+	}
+}
+
+// processLinkCheckReq schedules a LinkCheckAns for the device. The answer
+// uses the best SNR for the frame and the number of gateways that have
+// received the frame when the request is processed.
+func (m *MACProcessor) processLinkCheckReq(msg server.LoRaMessage) {
+	device := msg.FrameContext.Device
+	radio := msg.FrameContext.GatewayContext.Radio
+
+	snr := radio.SNR
+	gwCount := 1
+	if last, ok := m.context.UplinkHistory.Last(device.DeviceEUI); ok && last.FCnt == msg.Payload.MACPayload.FHDR.FullFCnt() {
+		snr = last.SNR
+		gwCount = last.Gateways
+	}
+	if gwCount > 0xFF {
+		gwCount = 0xFF
+	}
+
+	margin, err := linkMargin(radio.Band, radio.DataRate, snr)
+	if err != nil {
+		logging.Warning("Unable to calculate link margin for device %s: %v", device.DeviceEUI, err)
+		return
+	}
+
+	ans := protocol.NewDownlinkMACCommand(protocol.LinkCheckAns).(*protocol.MACLinkCheckAns)
+	ans.Margin = margin
+	ans.GwCnt = uint8(gwCount)
+	if err := m.context.FrameOutput.AddMACCommand(device.DeviceEUI, ans); err != nil {
+		logging.Warning("Unable to schedule LinkCheckAns for device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	logging.Debug("Scheduled LinkCheckAns for device %s (margin=%d, gateways=%d)", device.DeviceEUI, margin, gwCount)
+}
@@ -25,43 +25,138 @@ import (
 
 // MACProcessor is the process responsible for processing the MAC commands.
 type MACProcessor struct {
-	input    <-chan server.LoRaMessage // Input from decoder; receives decoded, deduped and valid frame
-	notifier chan server.LoRaMessage   // Notifier output; notifies scheduler about new RX
-	context  *server.Context           // Server context
+	input     <-chan server.LoRaMessage // Input from decoder; receives decoded, deduped and valid frame
+	notifier  chan server.LoRaMessage   // Notifier output; notifies scheduler about new RX
+	context   *server.Context           // Server context
+	adr       *adrEngine                // ADR engine
+	status    *devStatusScheduler       // Device status scheduler
+	rx        *rxSettingsScheduler      // Receive window settings
+	channels  *channelScheduler         // Channel plan
+	pingSlots *pingSlotScheduler        // Class B ping slots
 }
 
-func processMACCommand(cmd protocol.MACCommand) {
+func (m *MACProcessor) processMACCommand(msg *server.LoRaMessage, cmd protocol.MACCommand) {
 	switch cmd.ID() {
 	case protocol.LinkCheckReq:
 		// Initiated by the end device
-		logging.Warning("LinkCheckReq support not implemented")
+		m.processLinkCheckReq(*msg)
 	case protocol.LinkADRAns:
-		logging.Warning("LinkADRAns support not implemented")
+		ans, ok := cmd.(*protocol.MACLinkADRAns)
+		if !ok {
+			logging.Warning("Unexpected type for LinkADRAns: %T", cmd)
+			return
+		}
+		m.adr.processAnswer(msg.FrameContext.Device, ans)
 	case protocol.DutyCycleAns:
 		logging.Warning("DutyCycleAns support not implemented")
 	case protocol.RXParamSetupAns:
-		logging.Warning("RXParamSetupAns support not implemented")
+		ans, ok := cmd.(*protocol.MACRXParamSetupAns)
+		if !ok {
+			logging.Warning("Unexpected type for RXParamSetupAns: %T", cmd)
+			return
+		}
+		m.rx.processParamAnswer(&msg.FrameContext.Device, ans)
 	case protocol.DevStatusAns:
-		logging.Warning("DevStatusAns support not implemented")
+		ans, ok := cmd.(*protocol.MACDevStatusAns)
+		if !ok {
+			logging.Warning("Unexpected type for DevStatusAns: %T", cmd)
+			return
+		}
+		m.status.processAnswer(msg.FrameContext.Device, ans)
 	case protocol.NewChannelAns:
-		logging.Warning("NewChannelAns support not implemented")
+		ans, ok := cmd.(*protocol.MACNewChannelAns)
+		if !ok {
+			logging.Warning("Unexpected type for NewChannelAns: %T", cmd)
+			return
+		}
+		m.channels.processNewChannelAnswer(&msg.FrameContext.Device, ans)
+	case protocol.DlChannelAns:
+		ans, ok := cmd.(*protocol.MACDlChannelAns)
+		if !ok {
+			logging.Warning("Unexpected type for DlChannelAns: %T", cmd)
+			return
+		}
+		m.channels.processDlChannelAnswer(&msg.FrameContext.Device, ans)
 	case protocol.RXTimingSetupAns:
-		logging.Warning("RXTimingSetupAns support not implemented")
+		m.rx.processTimingAnswer(&msg.FrameContext.Device)
 	case protocol.PingSlotInfoReq:
 		// Initiated by the end device
-		logging.Warning("PingSlotInfoReq support not implemented")
+		req, ok := cmd.(*protocol.MACPingSlotInfoReq)
+		if !ok {
+			logging.Warning("Unexpected type for PingSlotInfoReq: %T", cmd)
+			return
+		}
+		m.pingSlots.processInfoRequest(&msg.FrameContext.Device, req)
 	case protocol.BeaconTimingReq:
 		// Initiated by the end device
-		logging.Warning("BeaconTimingReq support not implemented")
+		m.pingSlots.processBeaconTimingRequest(*msg)
 	case protocol.PingSlotFreqAns:
-		logging.Warning("PingSlotFreqAns support not implemented")
+		ans, ok := cmd.(*protocol.MACPingSlotFreqAns)
+		if !ok {
+			logging.Warning("Unexpected type for PingSlotFreqAns: %T", cmd)
+			return
+		}
+		m.pingSlots.processFreqAnswer(&msg.FrameContext.Device, ans)
 	case protocol.BeaconFreqAns:
-		logging.Warning("BeaconFreqAns support not implemented")
+		// The beacon frequency is fixed so BeaconFreqReq is never sent
+		logging.Info("Got unexpected BeaconFreqAns from device %s", msg.FrameContext.Device.DeviceEUI)
+	case protocol.RekeyInd:
+		// Initiated by the end device
+		ind, ok := cmd.(*protocol.MACRekeyInd)
+		if !ok {
+			logging.Warning("Unexpected type for RekeyInd: %T", cmd)
+			return
+		}
+		m.processRekeyInd(*msg, ind)
+	case protocol.DeviceTimeReq:
+		// Initiated by the end device
+		m.processDeviceTimeReq(*msg)
+	case protocol.RejoinParamSetupAns:
+		ans, ok := cmd.(*protocol.MACRejoinParamSetupAns)
+		if !ok {
+			logging.Warning("Unexpected type for RejoinParamSetupAns: %T", cmd)
+			return
+		}
+		if !ans.TimeOK {
+			logging.Warning("Device %s accepted the rejoin count but not the rejoin time", msg.FrameContext.Device.DeviceEUI)
+			return
+		}
+		logging.Info("Device %s accepted RejoinParamSetupReq", msg.FrameContext.Device.DeviceEUI)
 	default:
 		logging.Warning("Unknown MAC command: %d", cmd.ID())
 	}
 }
 
+// processRekeyInd confirms the security context for LoRaWAN 1.1 devices. The
+// answer holds the version the server and device both support [5.10].
+func (m *MACProcessor) processRekeyInd(msg server.LoRaMessage, ind *protocol.MACRekeyInd) {
+	device := msg.FrameContext.Device
+	if device.MACVersion != protocol.MACVersion11 {
+		logging.Warning("Got RekeyInd from LoRaWAN %s device %s. Ignoring it.", device.MACVersion, device.DeviceEUI)
+		return
+	}
+	conf := protocol.NewDownlinkMACCommand(protocol.RekeyConf).(*protocol.MACRekeyConf)
+	conf.Version = ind.Version
+	if conf.Version > protocol.MACVersion11 {
+		conf.Version = protocol.MACVersion11
+	}
+	if err := m.context.FrameOutput.AddMACCommand(device.DeviceEUI, conf); err != nil {
+		logging.Warning("Unable to schedule RekeyConf for device %s: %v", device.DeviceEUI, err)
+	}
+}
+
+// processDeviceTimeReq answers a DeviceTimeReq with the GPS time the uplink
+// was received. The server's time is used if the gateway isn't GPS
+// synchronized.
+func (m *MACProcessor) processDeviceTimeReq(msg server.LoRaMessage) {
+	device := msg.FrameContext.Device
+	ans := protocol.NewDownlinkMACCommand(protocol.DeviceTimeAns).(*protocol.MACDeviceTimeAns)
+	ans.SetGPSTime(protocol.GPSTime(msg.FrameContext.GatewayContext.UplinkTime()))
+	if err := m.context.FrameOutput.AddMACCommand(device.DeviceEUI, ans); err != nil {
+		logging.Warning("Unable to schedule DeviceTimeAns for device %s: %v", device.DeviceEUI, err)
+	}
+}
+
 // Start launches the MAC processor. When the input channel is closed the
 // method will stop and the notifier channel will be closed.
 func (m *MACProcessor) Start() {
@@ -69,11 +164,16 @@ func (m *MACProcessor) Start() {
 		go func(val server.LoRaMessage) {
 			val.FrameContext.GatewayContext.SectionTimer.Begin(monitoring.TimeMACProcessor)
 			for _, cmd := range val.Payload.MACPayload.MACCommands.List() {
-				processMACCommand(cmd)
+				m.processMACCommand(&val, cmd)
 			}
 			for _, cmd := range val.Payload.MACPayload.FHDR.FOpts.List() {
-				processMACCommand(cmd)
+				m.processMACCommand(&val, cmd)
 			}
+			m.adr.processUplink(val)
+			m.status.processUplink(val)
+			m.rx.processUplink(val)
+			m.channels.processUplink(val)
+			m.pingSlots.processUplink(val)
 			val.FrameContext.GatewayContext.SectionTimer.End()
 			monitoring.Stopwatch(monitoring.MACProcessorChannelOut, func() {
 				m.notifier <- val
@@ -95,8 +195,13 @@ func (m *MACProcessor) CommandNotifier() <-chan server.LoRaMessage {
 // NewMACProcessor creates a new MAC processor instance.
 func NewMACProcessor(context *server.Context, input <-chan server.LoRaMessage) *MACProcessor {
 	return &MACProcessor{
-		context:  context,
-		input:    input,
-		notifier: make(chan server.LoRaMessage),
+		context:   context,
+		input:     input,
+		notifier:  make(chan server.LoRaMessage),
+		adr:       newADREngine(context),
+		status:    newDevStatusScheduler(context),
+		rx:        newRXSettingsScheduler(context),
+		channels:  newChannelScheduler(context),
+		pingSlots: newPingSlotScheduler(context),
 	}
 }
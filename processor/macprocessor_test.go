@@ -20,8 +20,12 @@ import (
 
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
 )
 
 func TestMacprocessorChannels(t *testing.T) {
@@ -43,6 +47,8 @@ func TestMacprocessorChannels(t *testing.T) {
 
 func makeLoRaMessage(uplink bool, mType protocol.MType, fopts []protocol.MACCommand, payload []protocol.MACCommand) server.LoRaMessage {
 	ret := server.LoRaMessage{Payload: protocol.NewPHYPayload(mType)}
+	ret.FrameContext.GatewayContext.Radio.Band, _ = band.NewBand(band.EU868Band)
+	ret.FrameContext.GatewayContext.Radio.DataRate = "SF7BW125"
 	for _, v := range fopts {
 		ret.Payload.MACPayload.FHDR.FOpts.Add(v)
 	}
@@ -57,7 +63,10 @@ func makeLoRaMessage(uplink bool, mType protocol.MType, fopts []protocol.MACComm
 // a new LoRaMessage arrives. Ensure it does that while throwing all different
 // sorts of MAC commands at it.
 func TestMacprocessorForwarding(t *testing.T) {
-	context := server.Context{}
+	frameOutput := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	store := memstore.CreateMemoryStorage(0, 0)
+	context := server.Context{Storage: &store, FrameOutput: &frameOutput, UplinkHistory: &history}
 	input := make(chan server.LoRaMessage)
 
 	defer close(input)
@@ -90,3 +99,138 @@ func TestMacprocessorForwarding(t *testing.T) {
 		// OK - got message
 	}
 }
+
+func TestMacprocessorLinkCheck(t *testing.T) {
+	frameOutput := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	context := server.Context{FrameOutput: &frameOutput, UplinkHistory: &history}
+	input := make(chan server.LoRaMessage)
+
+	defer close(input)
+
+	macprocessor := NewMACProcessor(&context, input)
+
+	go macprocessor.Start()
+
+	msg := makeLoRaMessage(true, protocol.UnconfirmedDataUp,
+		[]protocol.MACCommand{protocol.NewUplinkMACCommand(protocol.LinkCheckReq)}, nil)
+	msg.FrameContext.Device = model.NewDevice()
+	msg.FrameContext.Device.DeviceEUI = protocol.EUIFromUint64(1)
+	msg.FrameContext.Device.Channels = frequency.ChannelPlan(msg.FrameContext.GatewayContext.Radio.Band, msg.FrameContext.Application)
+	msg.Payload.MACPayload.FHDR.FCnt = 10
+
+	// Frame received by three gateways. The best SNR is 5 dB at SF7 which
+	// gives a 12.5 dB margin
+	radio := msg.FrameContext.GatewayContext.Radio
+	for _, snr := range []float32{-2, 5, 1} {
+		radio.SNR = snr
+		history.Add(msg.FrameContext.Device.DeviceEUI, 10, server.GatewayPacket{Radio: radio, ReceivedAt: time.Now()})
+	}
+
+	input <- msg
+	select {
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not get a notification for new command after 100ms")
+	case <-macprocessor.CommandNotifier():
+	}
+
+	payload, err := frameOutput.GetPHYPayloadForDevice(&msg.FrameContext.Device, &msg.FrameContext)
+	if err != nil {
+		t.Fatal("Expected LinkCheckAns for device: ", err)
+	}
+	cmds := payload.MACPayload.MACCommands.List()
+	if len(cmds) != 1 || cmds[0].ID() != protocol.LinkCheckAns {
+		t.Fatalf("Expected LinkCheckAns but got %v", cmds)
+	}
+	ans := cmds[0].(*protocol.MACLinkCheckAns)
+	if ans.Margin != 12 || ans.GwCnt != 3 {
+		t.Fatalf("Unexpected LinkCheckAns: %+v", ans)
+	}
+}
+
+func TestLinkMargin(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	if m, err := linkMargin(eu, "SF12BW125", -25); err != nil || m != 0 {
+		t.Errorf("Expected 0 dB margin below the floor but got %d (err=%v)", m, err)
+	}
+	if m, err := linkMargin(eu, "SF12BW125", -10); err != nil || m != 10 {
+		t.Errorf("Expected 10 dB margin but got %d (err=%v)", m, err)
+	}
+	if _, err := linkMargin(eu, "SF13BW125", 0); err == nil {
+		t.Error("Expected error with invalid data rate")
+	}
+}
+
+func TestMacprocessorRekey(t *testing.T) {
+	frameOutput := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	context := server.Context{FrameOutput: &frameOutput, UplinkHistory: &history}
+	macprocessor := NewMACProcessor(&context, nil)
+
+	ind := protocol.NewUplinkMACCommand(protocol.RekeyInd).(*protocol.MACRekeyInd)
+	ind.Version = 3
+	msg := makeLoRaMessage(true, protocol.UnconfirmedDataUp, []protocol.MACCommand{ind}, nil)
+	msg.FrameContext.Device = model.NewDevice()
+	msg.FrameContext.Device.DeviceEUI = protocol.EUIFromUint64(1)
+
+	// LoRaWAN 1.0 devices shouldn't send RekeyInd
+	macprocessor.processMACCommand(&msg, ind)
+	if _, err := frameOutput.GetPHYPayloadForDevice(&msg.FrameContext.Device, &msg.FrameContext); err == nil {
+		t.Fatal("Did not expect RekeyConf for LoRaWAN 1.0 device")
+	}
+
+	msg.FrameContext.Device.MACVersion = protocol.MACVersion11
+	macprocessor.processMACCommand(&msg, ind)
+	payload, err := frameOutput.GetPHYPayloadForDevice(&msg.FrameContext.Device, &msg.FrameContext)
+	if err != nil {
+		t.Fatal("Expected RekeyConf for device: ", err)
+	}
+	cmds := payload.MACPayload.MACCommands.List()
+	if len(cmds) != 1 || cmds[0].ID() != protocol.RekeyConf {
+		t.Fatalf("Expected RekeyConf but got %v", cmds)
+	}
+	if conf := cmds[0].(*protocol.MACRekeyConf); conf.Version != protocol.MACVersion11 {
+		t.Fatalf("Unexpected version in RekeyConf: %d", conf.Version)
+	}
+}
+
+func TestMacprocessorDeviceTime(t *testing.T) {
+	frameOutput := server.NewFrameOutputBuffer()
+	context := server.Context{FrameOutput: &frameOutput}
+	macprocessor := NewMACProcessor(&context, nil)
+
+	req := protocol.NewUplinkMACCommand(protocol.DeviceTimeReq)
+	msg := makeLoRaMessage(true, protocol.UnconfirmedDataUp, []protocol.MACCommand{req}, nil)
+	msg.FrameContext.Device = model.NewDevice()
+	msg.FrameContext.Device.DeviceEUI = protocol.EUIFromUint64(1)
+	msg.FrameContext.GatewayContext.ReceivedAt = time.Now()
+	msg.FrameContext.GatewayContext.GatewayTime = time.Date(2018, time.March, 1, 12, 0, 0, 250000000, time.UTC)
+
+	// The gateway's time is used when it is available
+	macprocessor.processMACCommand(&msg, req)
+	payload, err := frameOutput.GetPHYPayloadForDevice(&msg.FrameContext.Device, &msg.FrameContext)
+	if err != nil {
+		t.Fatal("Expected DeviceTimeAns for device: ", err)
+	}
+	cmds := payload.MACPayload.MACCommands.List()
+	if len(cmds) != 1 || cmds[0].ID() != protocol.DeviceTimeAns {
+		t.Fatalf("Expected DeviceTimeAns but got %v", cmds)
+	}
+	ans := cmds[0].(*protocol.MACDeviceTimeAns)
+	expected := protocol.GPSTime(msg.FrameContext.GatewayContext.GatewayTime)
+	if ans.Seconds != uint32(expected/time.Second) || ans.Fraction != 64 {
+		t.Fatalf("Unexpected time in DeviceTimeAns: %d + %d/256", ans.Seconds, ans.Fraction)
+	}
+
+	// ...and the server's time when the gateway isn't synchronized
+	msg.FrameContext.GatewayContext.GatewayTime = time.Time{}
+	macprocessor.processMACCommand(&msg, req)
+	payload, err = frameOutput.GetPHYPayloadForDevice(&msg.FrameContext.Device, &msg.FrameContext)
+	if err != nil {
+		t.Fatal("Expected DeviceTimeAns for device: ", err)
+	}
+	ans = payload.MACPayload.MACCommands.List()[0].(*protocol.MACDeviceTimeAns)
+	if ans.Seconds != uint32(protocol.GPSTime(msg.FrameContext.GatewayContext.ReceivedAt)/time.Second) {
+		t.Fatalf("Expected server time in DeviceTimeAns but got %d", ans.Seconds)
+	}
+}
@@ -0,0 +1,243 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/monitoring"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// MulticastScheduler sends downlink messages to multicast groups. The message
+// is sent once through each of the group's gateways. Class C groups get the
+// message as soon as the gateway's transmitter is idle and class B groups get
+// the message in the next ping slot for the multicast address. Every attempt
+// is recorded in the group's delivery log.
+type MulticastScheduler struct {
+	context   *server.Context
+	occupancy *txOccupancy
+	output    chan<- server.GatewayPacket
+	scheduled map[protocol.EUI]bool
+	completed chan protocol.EUI
+	terminate chan bool
+}
+
+// NewMulticastScheduler creates a new multicast scheduler. The downlinks are
+// sent on the output channel. The downlinks are registered in the scheduler's
+// transmitter occupancy.
+func NewMulticastScheduler(context *server.Context, scheduler *Scheduler, output chan<- server.GatewayPacket) *MulticastScheduler {
+	return &MulticastScheduler{
+		context:   context,
+		occupancy: scheduler.occupancy,
+		output:    output,
+		scheduled: make(map[protocol.EUI]bool),
+		completed: make(chan protocol.EUI),
+		terminate: make(chan bool),
+	}
+}
+
+// Start launches the multicast scheduler. It runs until Stop is called.
+func (m *MulticastScheduler) Start() {
+	for {
+		select {
+		case eui := <-m.context.Downlinks.MulticastNotifications():
+			// The message is sent once. If the group is already scheduled
+			// the message is picked up by the running schedule.
+			if m.scheduled[eui] {
+				continue
+			}
+			m.scheduled[eui] = true
+			go m.sendMulticast(eui, m.completed)
+
+		case eui := <-m.completed:
+			delete(m.scheduled, eui)
+
+		case <-m.terminate:
+			logging.Debug("Multicast scheduler terminated")
+			return
+		}
+	}
+}
+
+// Stop stops the multicast scheduler.
+func (m *MulticastScheduler) Stop() {
+	m.terminate <- true
+}
+
+// encodeMulticast encodes the downlink message for the group. Multicast
+// downlinks are unconfirmed, without MAC commands and use the group's
+// session keys [11.2]
+func encodeMulticast(group model.MulticastGroup, message model.MulticastMessage) ([]byte, error) {
+	payload := protocol.NewPHYPayload(protocol.UnconfirmedDataDown)
+	payload.MACPayload.FHDR.DevAddr = group.McAddr
+	payload.MACPayload.FHDR.SetFullFCnt(group.FCntDn)
+	payload.MACPayload.FPort = message.Port
+	payload.MACPayload.FRMPayload = message.Payload()
+	return payload.EncodeMessage(group.McNwkSKey, group.McAppSKey)
+}
+
+// sendMulticast sends the scheduled downlink message to the group through
+// all of the group's gateways.
+func (m *MulticastScheduler) sendMulticast(groupEUI protocol.EUI, doneChannel chan protocol.EUI) {
+	defer func() {
+		doneChannel <- groupEUI
+	}()
+
+	group, err := m.context.Storage.Multicast.GetByEUI(groupEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve multicast group %s: %v", groupEUI, err)
+		return
+	}
+	message, err := m.context.Storage.Multicast.GetDownstream(groupEUI)
+	if err != nil || message.IsComplete() {
+		logging.Info("No downstream message for multicast group %s", groupEUI)
+		return
+	}
+	if group.Class != model.ClassB && group.Class != model.ClassC {
+		logging.Warning("Multicast group %s is a class %s group. Can't send downlink.", groupEUI, group.Class)
+		return
+	}
+	buffer, err := encodeMulticast(group, message)
+	if err != nil {
+		logging.Warning("Unable to encode message for multicast group %s: %v", groupEUI, err)
+		return
+	}
+
+	var slot pingSlot
+	if group.Class == model.ClassB {
+		slot, err = firstPingSlot(groupEUI, group.McAddr, group.Periodicity, time.Now().Add(downlinkLeadTime))
+		if err != nil {
+			logging.Warning("Unable to calculate ping slot for multicast group %s: %v", groupEUI, err)
+			return
+		}
+		time.Sleep(time.Until(slot.start.Add(-downlinkLeadTime)))
+	}
+
+	// Class C downlinks might have to wait for the gateways so the gateways
+	// are handled in parallel.
+	deliveries := make([]model.MulticastDelivery, len(group.Gateways))
+	wg := &sync.WaitGroup{}
+	for i, gatewayEUI := range group.Gateways {
+		deliveries[i] = model.MulticastDelivery{GroupEUI: groupEUI, GatewayEUI: gatewayEUI, FCnt: group.FCntDn}
+		wg.Add(1)
+		go func(delivery *model.MulticastDelivery) {
+			defer wg.Done()
+			if err := m.sendToGateway(group, delivery.GatewayEUI, buffer, slot); err != nil {
+				logging.Info("Unable to send downlink to multicast group %s through gateway %s: %v", groupEUI, delivery.GatewayEUI, err)
+				delivery.Error = err.Error()
+			}
+			delivery.Time = time.Now().UnixNano()
+		}(&deliveries[i])
+	}
+	wg.Wait()
+
+	sent := false
+	for _, v := range deliveries {
+		if err := m.context.Storage.Multicast.AddDelivery(v); err != nil {
+			logging.Warning("Unable to store delivery for multicast group %s: %v", groupEUI, err)
+		}
+		sent = sent || v.Sent()
+	}
+	// The frame counter is increased even if the message isn't sent since
+	// the frame counter can't be reused.
+	if err := m.context.Storage.Multicast.UpdateFCnt(groupEUI, group.FCntDn+1); err != nil {
+		logging.Error("Unable to update frame counter for multicast group %s: %v", groupEUI, err)
+	}
+	if !sent {
+		logging.Warning("Downlink to multicast group %s wasn't sent through any gateway", groupEUI)
+		return
+	}
+	if err := m.context.Storage.Multicast.UpdateDownstream(groupEUI, time.Now().Unix()); err != nil {
+		logging.Warning("Unable to update downstream message for multicast group %s: %v", groupEUI, err)
+	}
+	monitoring.LoRaUnconfirmedDown.Increment()
+}
+
+// sendToGateway sends the encoded downlink to the group through a single
+// gateway. Class B downlinks are sent in the ping slot and requires a GPS
+// synchronized gateway.
+func (m *MulticastScheduler) sendToGateway(group model.MulticastGroup, gatewayEUI protocol.EUI, buffer []byte, slot pingSlot) error {
+	gw, ok := m.context.Gateways.Get(gatewayEUI)
+	if !ok || gw.Band == nil {
+		return errors.New("gateway hasn't forwarded any uplinks")
+	}
+	var beaconTime uint32
+	if group.Class == model.ClassB {
+		if !m.context.GPSGateways.Synchronized(gatewayEUI) {
+			return errors.New("gateway isn't GPS synchronized")
+		}
+		beaconTime = uint32(protocol.GPSTime(slot.beacon) / time.Second)
+	}
+	params := group.Parameters(gw.Band, beaconTime)
+	dataRate, err := band.DataRateIdentifier(gw.Band, params.DataRate)
+	if err != nil {
+		return err
+	}
+	maxSize, err := gw.Band.MaximumPayload(dataRate)
+	if err != nil {
+		return err
+	}
+	// The frame has a 13 byte overhead (MHDR, FHDR, FPort and MIC)
+	if len(buffer)-13 > int(maxSize.WithoutFOpts()) {
+		return fmt.Errorf("payload is too large for data rate %s", dataRate)
+	}
+	encoding, err := gw.Band.Encoding(params.DataRate)
+	if err != nil {
+		return err
+	}
+	airtime := encoding.TimeOnAir(len(buffer))
+
+	packet := server.GatewayPacket{
+		RawMessage: buffer,
+		Radio: server.RadioContext{
+			Band:      gw.Band,
+			DataRate:  dataRate,
+			Frequency: params.Frequency,
+			TXPower:   params.Power,
+		},
+		Gateway:      gw.Gateway,
+		ReceivedAt:   time.Now(),
+		SectionTimer: monitoring.NewTimer(),
+		InTimer:      monitoring.NewTimer(),
+		OutTimer:     monitoring.NewTimer(),
+	}
+	if group.Class == model.ClassB {
+		if m.occupancy.busy(gatewayEUI, slot.start, airtime) {
+			return errors.New("gateway is busy in the ping slot")
+		}
+		m.occupancy.reserve(gatewayEUI, slot.start, airtime)
+		packet.TXTime = slot.start
+	} else {
+		start := m.occupancy.reserveFirstAvailable(gatewayEUI, time.Now(), airtime)
+		if wait := time.Until(start); wait > 0 {
+			logging.Debug("Gateway %s is busy. Delaying downlink to multicast group %s with %v", gatewayEUI, group.GroupEUI, wait)
+			time.Sleep(wait)
+		}
+		packet.Immediate = true
+	}
+	m.output <- packet
+	monitoring.GetGatewayCounters(gatewayEUI).MessagesOut.Increment()
+	monitoring.GetAppCounters(group.AppEUI).MessagesOut.Increment()
+	return nil
+}
@@ -0,0 +1,151 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func TestMulticastScheduler(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	downlinks := server.NewDownlinkNotifier()
+	gateways := server.NewActiveGateways()
+	gps := server.NewGPSGateways()
+	context := &server.Context{Storage: &store, Downlinks: &downlinks, Gateways: &gateways, GPSGateways: &gps}
+
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(1)
+	store.Application.Put(app, model.SystemUserID)
+
+	gateway := server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(2)}
+	gateways.Update(gateway, euBand)
+	unknownGateway := protocol.EUIFromUint64(3)
+
+	group := model.NewMulticastGroup()
+	group.GroupEUI = protocol.EUIFromUint64(4)
+	group.AppEUI = app.AppEUI
+	group.McAddr = protocol.DevAddrFromUint32(0x01020304)
+	group.McNwkSKey = protocol.AESKey{Key: [16]byte{1}}
+	group.McAppSKey = protocol.AESKey{Key: [16]byte{2}}
+	group.FCntDn = 7
+	group.Gateways = []protocol.EUI{gateway.GatewayEUI, unknownGateway}
+	store.Multicast.Put(group)
+	msg := model.NewMulticastMessage(group.GroupEUI, 10)
+	msg.Data = "010203"
+	store.Multicast.PutDownstream(group.GroupEUI, msg)
+
+	output := make(chan server.GatewayPacket)
+	multicast := NewMulticastScheduler(context, NewScheduler(context, nil), output)
+	go multicast.Start()
+	defer multicast.Stop()
+
+	downlinks.NotifyMulticast(group.GroupEUI)
+	select {
+	case packet := <-output:
+		if !packet.Immediate || packet.Gateway != gateway {
+			t.Fatalf("Expected immediate downlink through the gateway: %+v", packet)
+		}
+		if packet.Radio.Frequency != 869.525 || packet.Radio.DataRate != "SF12BW125" {
+			t.Fatalf("Expected downlink with RX2 parameters: %+v", packet.Radio)
+		}
+		payload := protocol.NewPHYPayload(protocol.UnconfirmedDataDown)
+		if err := payload.UnmarshalBinary(packet.RawMessage); err != nil {
+			t.Fatal("Unable to decode downlink: ", err)
+		}
+		mic, _ := payload.CalculateMIC(group.McNwkSKey, packet.RawMessage[:len(packet.RawMessage)-4])
+		if payload.MIC != mic {
+			t.Fatal("Downlink doesn't use the group's network session key")
+		}
+		payload.Decrypt(group.McNwkSKey, group.McAppSKey)
+		mac := payload.MACPayload
+		if mac.FHDR.DevAddr != group.McAddr || mac.FHDR.FCnt != 7 || mac.FPort != 10 || string(mac.FRMPayload) != "\x01\x02\x03" {
+			t.Fatalf("Unexpected downlink payload: %+v", mac)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not get a downlink")
+	}
+
+	// Wait for the delivery log
+	var deliveries []model.MulticastDelivery
+	for i := 0; i < 20 && len(deliveries) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		deliveries = nil
+		ch, _ := store.Multicast.GetDeliveries(group.GroupEUI, 10)
+		for v := range ch {
+			deliveries = append(deliveries, v)
+		}
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("Expected 2 delivery attempts but got %+v", deliveries)
+	}
+	for _, v := range deliveries {
+		if v.FCnt != 7 || v.Sent() != (v.GatewayEUI == gateway.GatewayEUI) {
+			t.Fatalf("Unexpected delivery attempt: %+v", v)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if stored, _ := store.Multicast.GetDownstream(group.GroupEUI); stored.IsComplete() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stored, _ := store.Multicast.GetDownstream(group.GroupEUI); !stored.IsComplete() {
+		t.Fatal("Message should be sent")
+	}
+	if stored, _ := store.Multicast.GetByEUI(group.GroupEUI); stored.FCntDn != 8 {
+		t.Fatalf("Expected frame counter to be increased but it is %d", stored.FCntDn)
+	}
+
+	// Class B groups need a GPS synchronized gateway
+	group.Class = model.ClassB
+	slot, _ := firstPingSlot(group.GroupEUI, group.McAddr, 0, time.Now().Add(5*time.Second))
+	if err := multicast.sendToGateway(group, gateway.GatewayEUI, []byte{1}, slot); err == nil {
+		t.Fatal("Did not expect class B downlink through gateway without GPS")
+	}
+	gps.Update(gateway, euBand)
+	go func() {
+		if err := multicast.sendToGateway(group, gateway.GatewayEUI, []byte{1}, slot); err != nil {
+			t.Errorf("Got error sending class B downlink: %v", err)
+		}
+	}()
+	select {
+	case packet := <-output:
+		if packet.Immediate || !packet.TXTime.Equal(slot.start) {
+			t.Fatalf("Expected downlink in the ping slot: %+v", packet)
+		}
+		if packet.Radio.Frequency != 869.525 || packet.Radio.DataRate != "SF9BW125" {
+			t.Fatalf("Expected downlink with default ping slot parameters: %+v", packet.Radio)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not get a class B downlink")
+	}
+
+	// The gateway is busy in the ping slot now. The failed downlink
+	// doesn't reserve the slot.
+	reserved := len(multicast.occupancy.slots[gateway.GatewayEUI])
+	if err := multicast.sendToGateway(group, gateway.GatewayEUI, []byte{1}, slot); err == nil {
+		t.Fatal("Expected error when the gateway is busy in the ping slot")
+	}
+	if slots := len(multicast.occupancy.slots[gateway.GatewayEUI]); slots != reserved {
+		t.Fatalf("Expected %d reserved slots but got %d", reserved, slots)
+	}
+}
@@ -16,6 +16,10 @@ package processor
 //limitations under the License.
 //
 import (
+	"errors"
+	"fmt"
+
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/frequency"
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/monitoring"
@@ -24,6 +28,56 @@ import (
 	"github.com/ExploratoryEngineering/logging"
 )
 
+// join sends the request to the join server and sets the session keys for
+// the device from the answer. The join server keeps track of the nonces. The
+// built-in join server stores them with the device so these are reloaded
+// before the device is updated. Applications with end-to-end encryption
+// need a join server that wraps the AppSKey with the application's KEK. The
+// AppSKey isn't stored for these applications.
+func (d *Decrypter) join(device *model.Device, app model.Application, decoded server.LoRaMessage, joinAccept protocol.JoinAcceptPayload) (server.JoinAnswer, error) {
+	answer, err := d.context.JoinServer.Join(server.JoinRequest{
+		NetID:      uint32(d.context.Config.NetworkID),
+		MACVersion: device.MACVersion,
+		PHYPayload: decoded.FrameContext.GatewayContext.RawMessage,
+		DevEUI:     device.DeviceEUI,
+		JoinEUI:    device.AppEUI,
+		DevAddr:    joinAccept.DevAddr,
+		DLSettings: joinAccept.DLSettings,
+		RxDelay:    joinAccept.RxDelay,
+		CFList:     joinAccept.CFList,
+	})
+	if err != nil {
+		if err == server.ErrMICFailed {
+			monitoring.LoRaMICFailed.Increment()
+		}
+		return answer, err
+	}
+	if answer.Wrapped() && !app.EndToEnd {
+		return answer, errors.New("join server returned a wrapped AppSKey but the application doesn't use end-to-end encryption")
+	}
+	if app.EndToEnd && !answer.Wrapped() {
+		return answer, errors.New("join server returned an unwrapped AppSKey for an application with end-to-end encryption")
+	}
+	if app.EndToEnd && answer.KEKLabel != app.KEKLabel {
+		return answer, fmt.Errorf("join server wrapped the AppSKey with the KEK %q but the application uses %q", answer.KEKLabel, app.KEKLabel)
+	}
+	stored, err := d.context.Storage.Device.GetByEUI(device.DeviceEUI)
+	if err != nil {
+		return answer, err
+	}
+	device.JoinNonce = stored.JoinNonce
+	device.DevNonceHistory = stored.DevNonceHistory
+
+	// The NwkSKey field holds the FNwkSIntKey for LoRaWAN 1.1 devices
+	device.NwkSKey = answer.NwkSKey
+	device.AppSKey = answer.AppSKey
+	if device.MACVersion == protocol.MACVersion11 {
+		device.SNwkSIntKey = answer.SNwkSIntKey
+		device.NwkSEncKey = answer.NwkSEncKey
+	}
+	return answer, nil
+}
+
 // Process the join request. Returns false if it failed.
 func (d *Decrypter) processJoinRequest(decoded server.LoRaMessage) bool {
 	monitoring.LoRaJoinRequest.Increment()
@@ -40,14 +94,6 @@ func (d *Decrypter) processJoinRequest(decoded server.LoRaMessage) bool {
 		return false
 	}
 
-	// Check if DevNonce have been used by the device in an earlier request.
-	// If so the request should be ignored. [6.2.4].
-	if device.HasDevNonce(joinRequest.DevNonce) {
-		logging.Warning("Device %s has already used nonce 0x%04x. Ignoring it.",
-			joinRequest.DevEUI, joinRequest.DevNonce)
-		return false
-	}
-
 	// Retrieve the application
 	app, err := d.context.Storage.Application.GetByEUI(joinRequest.AppEUI, model.SystemUserID)
 	if err != nil {
@@ -62,52 +108,87 @@ func (d *Decrypter) processJoinRequest(decoded server.LoRaMessage) bool {
 
 	// DevAddr is already assigned to the device. It is a function of the EUI.
 
-	// Update the device with new keys and DevNonce
-	if err := d.context.Storage.Device.AddDevNonce(device, joinRequest.DevNonce); err != nil {
-		logging.Warning("Unable to update DevNonce on device with EUI: %s: %v",
-			device.DeviceEUI, err)
-	}
+	plan := decoded.FrameContext.GatewayContext.Radio.Band
+	cfList := resetDeviceSettings(&device, plan, decoded.FrameContext.GatewayContext.Gateway.SubBand, app)
+	joinAccept := d.newJoinAccept(device, plan, cfList)
 
-	// Generate app nonce, generate keys, store keys
-	appNonce, err := app.GenerateAppNonce()
+	// The join server checks the MIC and the DevNonce and generates the
+	// session keys.
+	answer, err := d.join(&device, app, decoded, joinAccept)
 	if err != nil {
-		logging.Warning("Unable to generate app nonce: %v (devEUI: %s, appEUI: %s). Ignoring JoinRequest",
+		logging.Warning("Join server rejected JoinRequest: %v (devEUI: %s, appEUI: %s). Ignoring JoinRequest",
 			err, joinRequest.DevEUI, joinRequest.AppEUI)
 		return false
 	}
-	nwkSKey, err := protocol.NwkSKeyFromNonces(device.AppKey, appNonce, uint32(d.context.Config.NetworkID), joinRequest.DevNonce)
-	if err != nil {
-		logging.Error("Unable to generate NwkSKey for device with EUI %s: %v", device.DeviceEUI, err)
-		return false
-	}
-	appSKey, err := protocol.AppSKeyFromNonces(device.AppKey, appNonce, uint32(d.context.Config.NetworkID), joinRequest.DevNonce)
-	if err != nil {
-		logging.Error("Unable to generate AppSKey for device with EUI %s: %v", device.DeviceEUI, err)
-		return false
-	}
-	device.NwkSKey = nwkSKey
-	device.AppSKey = appSKey
 	device.FCntDn = 0
 	device.FCntUp = 0
+	// The device resets the RJcount0 counter when it joins
+	device.RJCount0 = 0
+	device.PendingSession = nil
 	if err := d.context.Storage.Device.Update(device); err != nil {
 		logging.Error("Unable to update device with EUI %s: %v", device.DeviceEUI, err)
 		return false
 	}
 
-	// Invariant. Everything is OK - make a JoinAccept response and schedule
-	// the output.
-	joinAccept := protocol.JoinAcceptPayload{
-		AppNonce:   appNonce,
+	// Invariant. Everything is OK - schedule the JoinAccept response.
+	d.sendJoinAccept(decoded, device, joinAccept, answer)
+	return true
+}
+
+// resetDeviceSettings reverts the device to its default settings and returns
+// the CFList for the JoinAccept message. The receive window settings are
+// sent in the JoinAccept message, except for the RX2 frequency. A
+// RXParamSetupReq will be sent later on if the RX2 frequency is set for the
+// device. Bands with sub-bands get a channel mask that enables the gateway's
+// sub-band.
+func resetDeviceSettings(device *model.Device, plan band.FrequencyPlan, subBand uint8, app model.Application) protocol.CFList {
+	device.DataRate = 0
+	device.TXPower = 0
+	device.NbTrans = 1
+	device.RXSettings = device.RequestedRX
+	if device.RXSettings.RX2Frequency != 0 {
+		device.RXSettings.RX2Frequency = 0
+		device.RXSettings.RX2DataRate = 0
+	}
+	if device.RXSettings.RX1Delay == 0 {
+		device.RXSettings.RX1Delay = 1
+	}
+	// The CFList holds the first channels from the application's channel plan.
+	// Any remaining channels are set up with NewChannelReq commands.
+	cfList, channels := frequency.CFList(plan, frequency.ChannelPlan(plan, app))
+	device.Channels = channels
+	if chMaskList, ok := frequency.SubBandCFList(plan, subBand); ok {
+		return chMaskList
+	}
+	return cfList
+}
+
+// newJoinAccept creates the JoinAccept payload for the device. The nonce is
+// set by the join server.
+func (d *Decrypter) newJoinAccept(device model.Device, plan band.FrequencyPlan, cfList protocol.CFList) protocol.JoinAcceptPayload {
+	dlSettings := protocol.DLSettings{
+		RX1DRoffset: device.RXSettings.RX1DROffset,
+		RX2DataRate: device.RXSettings.RX2Parameters(plan).DataRate,
+		OptNeg:      device.MACVersion == protocol.MACVersion11,
+	}
+	return protocol.JoinAcceptPayload{
 		NetID:      uint32(d.context.Config.NetworkID),
 		DevAddr:    device.DevAddr,
-		DLSettings: frequency.GetDLSettingsOTAA(),
-		RxDelay:    frequency.GetRxDelayOTAA(),
-		CFList:     frequency.GetCFListOTAA(),
+		DLSettings: dlSettings,
+		RxDelay:    device.RXSettings.RX1Delay,
+		CFList:     cfList,
 	}
+}
 
-	d.context.FrameOutput.SetJoinAcceptPayload(device.DeviceEUI, joinAccept)
+// sendJoinAccept schedules the JoinAccept message for the device and
+// forwards the request to the MAC processor. The wrapped AppSKey is sent to
+// the application outputs for applications with end-to-end encryption.
+func (d *Decrypter) sendJoinAccept(decoded server.LoRaMessage, device model.Device, joinAccept protocol.JoinAcceptPayload, answer server.JoinAnswer) {
+	decoded.FrameContext.Device = device
+	d.context.FrameOutput.SetJoinAcceptPayload(device.DeviceEUI, joinAccept, answer.PHYPayload)
+	d.context.UplinkHistory.Clear(device.DeviceEUI)
 
-	logging.Debug("JoinAccept sent to %s. DevAddr=%$", device.DeviceEUI, joinAccept.DevAddr)
+	logging.Debug("JoinAccept sent to %s. DevAddr=%s", device.DeviceEUI, joinAccept.DevAddr)
 
 	// The incoming message doesn't have a DevAddr set but schedule an empty
 	// message for it. TODO (stalehd): this is butt ugly. Needs redesign.
@@ -116,5 +197,13 @@ func (d *Decrypter) processJoinRequest(decoded server.LoRaMessage) bool {
 	decoded.FrameContext.GatewayContext.SectionTimer.End()
 	d.macOutput <- decoded
 	monitoring.LoRaJoinAccept.Increment()
-	return true
+
+	if answer.Wrapped() {
+		d.context.AppRouter.Publish(device.AppEUI, &server.JoinMessage{
+			Device:         device,
+			Application:    decoded.FrameContext.Application,
+			KEKLabel:       answer.KEKLabel,
+			WrappedAppSKey: answer.WrappedAppSKey,
+		})
+	}
 }
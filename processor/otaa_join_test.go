@@ -19,6 +19,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
@@ -43,6 +44,8 @@ func TestOTAAJoinRequestProcessing(t *testing.T) {
 		FCntDn:          100,
 		RelaxedCounter:  false,
 		DevNonceHistory: make([]uint16, 0),
+		AppKey:          protocol.AESKey{Key: [16]byte{1, 2, 3}},
+		RequestedRX:     model.RXSettings{RX1Delay: 3, RX1DROffset: 2, RX2DataRate: 3, RX2Frequency: 869.1},
 	}
 
 	store.Application.Put(application, model.SystemUserID)
@@ -51,29 +54,41 @@ func TestOTAAJoinRequestProcessing(t *testing.T) {
 	inputChan := make(chan server.LoRaMessage)
 
 	foBuffer := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
 	decrypter := NewDecrypter(&server.Context{
-		Storage:     &store,
-		FrameOutput: &foBuffer,
-		Config:      &server.Configuration{},
+		Storage:       &store,
+		FrameOutput:   &foBuffer,
+		Config:        &server.Configuration{},
+		UplinkHistory: &history,
+		JoinServer:    server.NewLocalJoinServer(&store),
 	}, inputChan)
 
+	eu, _ := band.NewBand(band.EU868Band)
 	payload := protocol.NewPHYPayload(protocol.JoinRequest)
 	payload.JoinRequestPayload = protocol.JoinRequestPayload{
 		DevEUI:   deviceEUI,
 		AppEUI:   appEUI,
 		DevNonce: 0x0102,
 	}
+	buf, _ := payload.EncodeJoinRequest(device.AppKey)
 	input := server.LoRaMessage{
 		Payload: payload,
 		FrameContext: server.FrameContext{
 			Device:         model.NewDevice(),
 			Application:    model.NewApplication(),
-			GatewayContext: server.GatewayPacket{},
+			GatewayContext: server.GatewayPacket{RawMessage: buf, Radio: server.RadioContext{Band: eu}},
 		},
 	}
 
 	close(inputChan)
 
+	// JoinRequests with an invalid MIC are rejected by the join server
+	invalid := input
+	invalid.FrameContext.GatewayContext.RawMessage, _ = payload.EncodeJoinRequest(protocol.AESKey{})
+	if decrypter.processJoinRequest(invalid) {
+		t.Fatal("Expected JoinRequest with invalid MIC to fail")
+	}
+
 	// This should result in an output message
 	go decrypter.processJoinRequest(input)
 
@@ -83,4 +98,215 @@ func TestOTAAJoinRequestProcessing(t *testing.T) {
 	case <-time.After(100 * time.Millisecond):
 		t.Fatal("Did not get output on output channel!")
 	}
+
+	// The JoinAccept should contain the requested RX settings. The RX2
+	// frequency can't be sent in the JoinAccept so the device will use the
+	// band's defaults for RX2.
+	joined, _ := store.Device.GetByEUI(deviceEUI)
+	joinAccept, err := foBuffer.GetPHYPayloadForDevice(&joined, &input.FrameContext)
+	if err != nil {
+		t.Fatal("Expected JoinAccept for device: ", err)
+	}
+	ja := joinAccept.JoinAcceptPayload
+	if ja.RxDelay != 3 || ja.DLSettings.RX1DRoffset != 2 || ja.DLSettings.RX2DataRate != eu.GetRX2Parameters().DataRate {
+		t.Fatalf("Unexpected settings in JoinAccept: %+v", ja)
+	}
+	expected := model.RXSettings{RX1Delay: 3, RX1DROffset: 2}
+	if joined.RXSettings != expected {
+		t.Fatalf("Unexpected RX settings after join: %+v", joined.RXSettings)
+	}
+
+	// The band's additional channels are sent in the CFList
+	if ja.CFList.Frequencies[0] != 8671000 || ja.CFList.Frequencies[4] != 8679000 {
+		t.Fatalf("Unexpected CFList in JoinAccept: %+v", ja.CFList)
+	}
+	if len(joined.Channels) != 8 || joined.Channels[7].Frequency != 867.9 {
+		t.Fatalf("Unexpected channels after join: %v", joined.Channels)
+	}
+
+	// The encrypted JoinAccept message is passed on to the encoder and the
+	// device gets the same session keys as the server
+	decoded := protocol.NewPHYPayload(protocol.JoinAccept)
+	if err := decoded.DecodeJoinAccept(device.AppKey, input.FrameContext.JoinAccept); err != nil {
+		t.Fatal("Unable to decode JoinAccept: ", err)
+	}
+	appNonce := decoded.JoinAcceptPayload.AppNonce
+	nwkSKey, _ := protocol.NwkSKeyFromNonces(device.AppKey, appNonce, 0, 0x0102)
+	appSKey, _ := protocol.AppSKeyFromNonces(device.AppKey, appNonce, 0, 0x0102)
+	if joined.NwkSKey != nwkSKey || joined.AppSKey != appSKey || decoded.JoinAcceptPayload.RxDelay != 3 {
+		t.Fatalf("Unexpected session keys for device: %+v", joined)
+	}
+
+	// The DevNonce can't be reused
+	if decrypter.processJoinRequest(input) {
+		t.Fatal("Expected JoinRequest with used DevNonce to fail")
+	}
+}
+
+func TestOTAAJoinRequestLoRaWAN11(t *testing.T) {
+	deviceEUI := protocol.EUIFromUint64(1)
+	appEUI := protocol.EUIFromUint64(2)
+
+	store := memstore.CreateMemoryStorage(0, 0)
+	application := model.NewApplication()
+	application.AppEUI = appEUI
+	device := model.NewDevice()
+	device.DeviceEUI = deviceEUI
+	device.AppEUI = appEUI
+	device.State = model.OverTheAirDevice
+	device.MACVersion = protocol.MACVersion11
+	device.AppKey = protocol.AESKey{Key: [16]byte{1}}
+	device.NwkKey = protocol.AESKey{Key: [16]byte{2}}
+	device.JoinNonce = 41
+
+	store.Application.Put(application, model.SystemUserID)
+	store.Device.Put(device, appEUI)
+
+	foBuffer := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	decrypter := NewDecrypter(&server.Context{
+		Storage:       &store,
+		FrameOutput:   &foBuffer,
+		Config:        &server.Configuration{},
+		UplinkHistory: &history,
+		JoinServer:    server.NewLocalJoinServer(&store),
+	}, make(chan server.LoRaMessage))
+
+	eu, _ := band.NewBand(band.EU868Band)
+	payload := protocol.NewPHYPayload(protocol.JoinRequest)
+	payload.JoinRequestPayload = protocol.JoinRequestPayload{
+		DevEUI:   deviceEUI,
+		AppEUI:   appEUI,
+		DevNonce: 0x0102,
+	}
+	// LoRaWAN 1.1 devices sign the JoinRequest with the NwkKey
+	buf, _ := payload.EncodeJoinRequest(device.NwkKey)
+	input := server.LoRaMessage{
+		Payload: payload,
+		FrameContext: server.FrameContext{
+			GatewayContext: server.GatewayPacket{RawMessage: buf, Radio: server.RadioContext{Band: eu}},
+		},
+	}
+
+	go decrypter.processJoinRequest(input)
+
+	var output server.LoRaMessage
+	select {
+	case output = <-decrypter.Output():
+		// OK
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not get output on output channel!")
+	}
+	// The DevNonce is stored by the join server
+	history11 := output.FrameContext.Device.DevNonceHistory
+	if len(history11) != 1 || history11[0] != 0x0102 {
+		t.Fatalf("Expected DevNonce in frame context: %v", history11)
+	}
+
+	joined, _ := store.Device.GetByEUI(deviceEUI)
+	if joined.JoinNonce != 42 {
+		t.Fatalf("Expected JoinNonce to be incremented but it is %d", joined.JoinNonce)
+	}
+	joinNonce := [3]byte{42, 0, 0}
+	fNwkSIntKey, _ := protocol.FNwkSIntKeyFromJoinNonce(device.NwkKey, joinNonce, appEUI, 0x0102)
+	sNwkSIntKey, _ := protocol.SNwkSIntKeyFromJoinNonce(device.NwkKey, joinNonce, appEUI, 0x0102)
+	nwkSEncKey, _ := protocol.NwkSEncKeyFromJoinNonce(device.NwkKey, joinNonce, appEUI, 0x0102)
+	appSKey, _ := protocol.AppSKeyFromJoinNonce(device.AppKey, joinNonce, appEUI, 0x0102)
+	if joined.NwkSKey != fNwkSIntKey || joined.SNwkSIntKey != sNwkSIntKey || joined.NwkSEncKey != nwkSEncKey || joined.AppSKey != appSKey {
+		t.Fatalf("Unexpected session keys for device: %+v", joined)
+	}
+
+	joinAccept, err := foBuffer.GetPHYPayloadForDevice(&joined, &input.FrameContext)
+	if err != nil {
+		t.Fatal("Expected JoinAccept for device: ", err)
+	}
+	if !joinAccept.JoinAcceptPayload.DLSettings.OptNeg {
+		t.Fatalf("Unexpected JoinAccept for LoRaWAN 1.1 device: %+v", joinAccept.JoinAcceptPayload)
+	}
+
+	// The JoinAccept is encrypted with the NwkKey and signed with the JSIntKey
+	jsIntKey, _ := protocol.JSIntKeyFromDevEUI(device.NwkKey, deviceEUI)
+	decoded := protocol.NewPHYPayload(protocol.JoinAccept)
+	if err := decoded.DecodeJoinAccept11(device.NwkKey, jsIntKey, appEUI, 0x0102, input.FrameContext.JoinAccept); err != nil {
+		t.Fatal("Unable to decode JoinAccept: ", err)
+	}
+	if ja := decoded.JoinAcceptPayload; !ja.DLSettings.OptNeg || ja.AppNonce != joinNonce || ja.DevAddr != joined.DevAddr {
+		t.Fatalf("Unexpected JoinAccept for LoRaWAN 1.1 device: %+v", ja)
+	}
+}
+
+// Devices joining through a gateway with a sub-band get a CFList with the
+// channel mask for the sub-band.
+func TestResetDeviceSettingsSubBand(t *testing.T) {
+	us, _ := band.NewBand(band.US915Band)
+	eu, _ := band.NewBand(band.EU868Band)
+	app := model.NewApplication()
+
+	device := model.NewDevice()
+	cfList := resetDeviceSettings(&device, us, 2, app)
+	if cfList.Type != protocol.CFListChMask || cfList.ChMask[0] != 0xFF00 || cfList.ChMask[4] != 0x0002 {
+		t.Fatalf("Expected channel mask for sub-band 2: %+v", cfList)
+	}
+	if cfList = resetDeviceSettings(&device, us, 0, app); !cfList.Empty() {
+		t.Fatalf("Expected empty CFList when the gateway uses all channels: %+v", cfList)
+	}
+	if cfList = resetDeviceSettings(&device, eu, 2, app); cfList.Type != protocol.CFListFrequencies || cfList.Empty() {
+		t.Fatalf("Expected frequencies in CFList for EU868: %+v", cfList)
+	}
+}
+
+// fixedJoinServer returns the same answer for every request
+type fixedJoinServer struct {
+	answer server.JoinAnswer
+}
+
+func (f *fixedJoinServer) Join(req server.JoinRequest) (server.JoinAnswer, error) {
+	return f.answer, nil
+}
+
+// Applications with end-to-end encryption only accept AppSKeys that the
+// join server has wrapped with the application's KEK.
+func TestJoinEndToEnd(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(2)
+	app.EndToEnd = true
+	app.KEKLabel = "kek"
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(1)
+	device.AppEUI = app.AppEUI
+	device.State = model.OverTheAirDevice
+	store.Application.Put(app, model.SystemUserID)
+	store.Device.Put(device, app.AppEUI)
+
+	joinServer := &fixedJoinServer{}
+	decrypter := NewDecrypter(&server.Context{
+		Storage:    &store,
+		Config:     &server.Configuration{},
+		JoinServer: joinServer,
+	}, make(chan server.LoRaMessage))
+
+	appSKey := protocol.AESKey{Key: [16]byte{1}}
+	joinServer.answer = server.JoinAnswer{AppSKey: appSKey}
+	if _, err := decrypter.join(&device, app, server.LoRaMessage{}, protocol.JoinAcceptPayload{}); err == nil {
+		t.Fatal("Expected error for unwrapped AppSKey")
+	}
+
+	joinServer.answer.WrapAppSKey("other", protocol.AESKey{Key: [16]byte{2}})
+	if _, err := decrypter.join(&device, app, server.LoRaMessage{}, protocol.JoinAcceptPayload{}); err == nil {
+		t.Fatal("Expected error for AppSKey wrapped with another KEK")
+	}
+
+	joinServer.answer = server.JoinAnswer{AppSKey: appSKey}
+	joinServer.answer.WrapAppSKey("kek", protocol.AESKey{Key: [16]byte{2}})
+	answer, err := decrypter.join(&device, app, server.LoRaMessage{}, protocol.JoinAcceptPayload{})
+	if err != nil || !answer.Wrapped() || device.AppSKey != (protocol.AESKey{}) {
+		t.Fatalf("Expected wrapped AppSKey: %+v (err=%v)", answer, err)
+	}
+
+	// Wrapped keys are rejected for other applications
+	app.EndToEnd = false
+	if _, err := decrypter.join(&device, app, server.LoRaMessage{}, protocol.JoinAcceptPayload{}); err == nil {
+		t.Fatal("Expected error for wrapped AppSKey")
+	}
 }
@@ -0,0 +1,205 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// pingSlotPendingLimit is the number of uplinks to wait for an answer before
+// the PingSlotChannelReq command is sent again.
+const pingSlotPendingLimit = 4
+
+// pingSlot is a ping slot for a class B device
+type pingSlot struct {
+	deviceEUI protocol.EUI
+	start     time.Time // Start of the ping slot
+	beacon    time.Time // Start of the beacon period the slot is in
+}
+
+// nextPingSlot returns the device's first ping slot after the time stamp
+// [13.2]
+func nextPingSlot(device model.Device, after time.Time) (pingSlot, error) {
+	return firstPingSlot(device.DeviceEUI, device.DevAddr, device.PingSlot.Periodicity, after)
+}
+
+// firstPingSlot returns the first ping slot for the address after the time
+// stamp. Multicast groups use the multicast address to calculate the ping
+// slots [13.2]
+func firstPingSlot(eui protocol.EUI, devAddr protocol.DevAddr, periodicity uint8, after time.Time) (pingSlot, error) {
+	beacon := protocol.NextBeacon(after).Add(-protocol.BeaconPeriod)
+	for i := 0; i < 2; i++ {
+		slots, err := protocol.PingSlots(beacon, devAddr, periodicity)
+		if err != nil {
+			return pingSlot{}, err
+		}
+		for _, start := range slots {
+			if start.After(after) {
+				return pingSlot{deviceEUI: eui, start: start, beacon: beacon}, nil
+			}
+		}
+		beacon = beacon.Add(protocol.BeaconPeriod)
+	}
+	return pingSlot{}, errors.New("no ping slot found")
+}
+
+// pingSlotScheduler handles the class B MAC commands. PingSlotChannelReq
+// commands are sent to devices when the requested ping slot data rate or
+// frequency differ from the settings the device uses.
+type pingSlotScheduler struct {
+	context *server.Context
+	pending map[protocol.EUI]pendingPingSlot
+	mutex   *sync.Mutex
+}
+
+// pendingPingSlot is the ping slot settings sent to a device that hasn't been
+// answered yet.
+type pendingPingSlot struct {
+	settings model.PingSlotSettings
+	uplinks  int
+}
+
+func newPingSlotScheduler(context *server.Context) *pingSlotScheduler {
+	return &pingSlotScheduler{
+		context: context,
+		pending: make(map[protocol.EUI]pendingPingSlot),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// processUplink schedules a PingSlotChannelReq for the device if the ping slot
+// settings have changed.
+func (p *pingSlotScheduler) processUplink(msg server.LoRaMessage) {
+	mtype := msg.Payload.MHDR.MType
+	if mtype != protocol.UnconfirmedDataUp && mtype != protocol.ConfirmedDataUp {
+		return
+	}
+	device := msg.FrameContext.Device
+	current := device.PingSlot
+	requested := device.RequestedPing
+	if current.DataRate == requested.DataRate && current.Frequency == requested.Frequency {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if pending, exists := p.pending[device.DeviceEUI]; exists {
+		pending.uplinks++
+		if pending.uplinks < pingSlotPendingLimit {
+			p.pending[device.DeviceEUI] = pending
+			return
+		}
+		logging.Info("No answer to PingSlotChannelReq from device %s after %d uplinks", device.DeviceEUI, pending.uplinks)
+	}
+
+	cmd := protocol.NewDownlinkMACCommand(protocol.PingSlotChannelReq).(*protocol.MACPingSlotChannelReq)
+	// A frequency of 0 tells the device to use the default frequency plan.
+	// The data rate is in the lower 4 bits [14.3]
+	cmd.Frequency = frequency.Steps(requested.Frequency)
+	cmd.MinDR = requested.DataRate
+	if err := p.context.FrameOutput.AddMACCommand(device.DeviceEUI, cmd); err != nil {
+		logging.Warning("Unable to schedule PingSlotChannelReq for device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	p.pending[device.DeviceEUI] = pendingPingSlot{settings: requested}
+}
+
+// updateDevice applies the changes to the stored device and the device in
+// the frame context.
+func (p *pingSlotScheduler) updateDevice(device *model.Device, apply func(settings *model.PingSlotSettings)) {
+	apply(&device.PingSlot)
+
+	stored, err := p.context.Storage.Device.GetByEUI(device.DeviceEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	apply(&stored.PingSlot)
+	if err := p.context.Storage.Device.Update(stored); err != nil {
+		logging.Warning("Unable to update ping slot settings for device %s: %v", device.DeviceEUI, err)
+	}
+}
+
+// processInfoRequest handles the PingSlotInfoReq from the device. The device
+// sends this to inform the server of its ping slot periodicity [14.1].
+func (p *pingSlotScheduler) processInfoRequest(device *model.Device, req *protocol.MACPingSlotInfoReq) {
+	p.updateDevice(device, func(s *model.PingSlotSettings) {
+		s.Periodicity = req.Periodicity
+	})
+	if err := p.context.FrameOutput.AddMACCommand(device.DeviceEUI, protocol.NewDownlinkMACCommand(protocol.PingSlotInfoAns)); err != nil {
+		logging.Warning("Unable to schedule PingSlotInfoAns for device %s: %v", device.DeviceEUI, err)
+	}
+}
+
+// processFreqAnswer handles the PingSlotFreqAns from the device. The settings
+// are only changed when both ack bits are set [14.3]. If the device
+// rejects the settings the requested settings are reverted.
+func (p *pingSlotScheduler) processFreqAnswer(device *model.Device, ans *protocol.MACPingSlotFreqAns) {
+	p.mutex.Lock()
+	pending, exists := p.pending[device.DeviceEUI]
+	delete(p.pending, device.DeviceEUI)
+	p.mutex.Unlock()
+
+	if !exists {
+		logging.Info("Got PingSlotFreqAns from device %s but there's no pending PingSlotChannelReq", device.DeviceEUI)
+		return
+	}
+	if !ans.DataRangeOK || !ans.ChannelFrequencyOK {
+		logging.Warning("Device %s rejected PingSlotChannelReq (data rate ok=%t, frequency ok=%t). Reverting to current settings.",
+			device.DeviceEUI, ans.DataRangeOK, ans.ChannelFrequencyOK)
+		stored, err := p.context.Storage.Device.GetByEUI(device.DeviceEUI)
+		if err != nil {
+			logging.Warning("Unable to retrieve device %s: %v", device.DeviceEUI, err)
+			return
+		}
+		stored.RequestedPing.DataRate = stored.PingSlot.DataRate
+		stored.RequestedPing.Frequency = stored.PingSlot.Frequency
+		if err := p.context.Storage.Device.Update(stored); err != nil {
+			logging.Warning("Unable to update ping slot settings for device %s: %v", device.DeviceEUI, err)
+		}
+		return
+	}
+	p.updateDevice(device, func(s *model.PingSlotSettings) {
+		s.DataRate = pending.settings.DataRate
+		s.Frequency = pending.settings.Frequency
+	})
+}
+
+// processBeaconTimingRequest answers a BeaconTimingReq with the time until
+// the next beacon (in 30 ms units) and the beacon channel [14.4].
+func (p *pingSlotScheduler) processBeaconTimingRequest(msg server.LoRaMessage) {
+	device := msg.FrameContext.Device
+	receivedAt := msg.FrameContext.GatewayContext.ReceivedAt
+	next := protocol.NextBeacon(receivedAt)
+
+	ans := protocol.NewDownlinkMACCommand(protocol.BeaconTimingAns).(*protocol.MACBeaconTimingAns)
+	ans.Delay = uint16(next.Sub(receivedAt) / protocol.PingSlotLength)
+	if plan := msg.FrameContext.GatewayContext.Radio.Band; plan != nil {
+		ans.Channel = plan.Configuration().BeaconChannel(uint32(protocol.GPSTime(next) / time.Second))
+	}
+	if err := p.context.FrameOutput.AddMACCommand(device.DeviceEUI, ans); err != nil {
+		logging.Warning("Unable to schedule BeaconTimingAns for device %s: %v", device.DeviceEUI, err)
+	}
+}
@@ -0,0 +1,177 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func TestNextPingSlot(t *testing.T) {
+	device := model.NewDevice()
+	device.DevAddr = protocol.DevAddr{NwkID: 1, NwkAddr: 0x4321}
+	device.PingSlot.Periodicity = 7
+
+	now := time.Now()
+	slot, err := nextPingSlot(device, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slot.start.After(now) || slot.start.Sub(now) > 2*protocol.BeaconPeriod {
+		t.Fatalf("Slot %v is too far from %v", slot.start, now)
+	}
+	if slot.start.Before(slot.beacon) || slot.start.Sub(slot.beacon) > protocol.BeaconPeriod {
+		t.Fatalf("Slot %v isn't in beacon period starting at %v", slot.start, slot.beacon)
+	}
+	next, err := nextPingSlot(device, slot.start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.start.After(slot.start) || !next.beacon.Equal(slot.beacon.Add(protocol.BeaconPeriod)) {
+		t.Fatalf("Expected next slot in next beacon period with periodicity 7: %v (previous was %v)", next.start, slot.start)
+	}
+
+	device.PingSlot.Periodicity = 0
+	next, err = nextPingSlot(device, slot.start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.start.Sub(slot.start) > 2*time.Second+protocol.BeaconReserved+protocol.BeaconGuard {
+		t.Fatalf("Expected slot within a few seconds with periodicity 0: %v (previous was %v)", next.start, slot.start)
+	}
+
+	device.PingSlot.Periodicity = 8
+	if _, err := nextPingSlot(device, now); err == nil {
+		t.Fatal("Expected error with invalid periodicity")
+	}
+}
+
+func TestPingSlotScheduler(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	frameOutput := server.NewFrameOutputBuffer()
+	context := &server.Context{Storage: &store, FrameOutput: &frameOutput}
+
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(1)
+	store.Application.Put(app, model.SystemUserID)
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	device.Class = model.ClassB
+	store.Device.Put(device, app.AppEUI)
+
+	eu, _ := band.NewBand(band.EU868Band)
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device: device,
+			GatewayContext: server.GatewayPacket{
+				Radio:      server.RadioContext{Band: eu, DataRate: "SF7BW125"},
+				ReceivedAt: time.Now(),
+			},
+		},
+	}
+
+	getCommands := func() map[protocol.CID]protocol.MACCommand {
+		ret := make(map[protocol.CID]protocol.MACCommand)
+		payload, err := frameOutput.GetPHYPayloadForDevice(&device, &msg.FrameContext)
+		if err != nil {
+			return ret
+		}
+		for _, v := range payload.MACPayload.MACCommands.List() {
+			ret[v.ID()] = v
+		}
+		return ret
+	}
+
+	scheduler := newPingSlotScheduler(context)
+
+	// The device reports its periodicity
+	scheduler.processInfoRequest(&msg.FrameContext.Device, &protocol.MACPingSlotInfoReq{Periodicity: 5})
+	if msg.FrameContext.Device.PingSlot.Periodicity != 5 {
+		t.Fatalf("Expected periodicity to be updated in message: %+v", msg.FrameContext.Device.PingSlot)
+	}
+	stored, _ := store.Device.GetByEUI(device.DeviceEUI)
+	if stored.PingSlot.Periodicity != 5 {
+		t.Fatalf("Expected periodicity to be stored: %+v", stored.PingSlot)
+	}
+	if _, ok := getCommands()[protocol.PingSlotInfoAns]; !ok {
+		t.Fatal("Expected PingSlotInfoAns")
+	}
+
+	// Settings are the same. Nothing to send.
+	msg.FrameContext.Device = stored
+	scheduler.processUplink(msg)
+	if len(getCommands()) != 0 {
+		t.Fatal("Did not expect any commands when settings are unchanged")
+	}
+
+	stored.RequestedPing = model.PingSlotSettings{DataRate: 5, Frequency: 869.1}
+	store.Device.Update(stored)
+	msg.FrameContext.Device = stored
+	scheduler.processUplink(msg)
+	req, ok := getCommands()[protocol.PingSlotChannelReq].(*protocol.MACPingSlotChannelReq)
+	if !ok || req.Frequency != 8691000 || req.MinDR != 5 {
+		t.Fatalf("Expected PingSlotChannelReq but got %v", req)
+	}
+
+	// Request is pending. Nothing new should be sent.
+	scheduler.processUplink(msg)
+	if len(getCommands()) != 0 {
+		t.Fatal("Did not expect commands while the request is pending")
+	}
+
+	// Device rejects the frequency. Requested settings are reverted.
+	scheduler.processFreqAnswer(&msg.FrameContext.Device, &protocol.MACPingSlotFreqAns{DataRangeOK: true, ChannelFrequencyOK: false})
+	stored, _ = store.Device.GetByEUI(device.DeviceEUI)
+	if stored.PingSlot.Frequency != 0 || stored.RequestedPing.Frequency != 0 || stored.RequestedPing.DataRate != 0 {
+		t.Fatalf("Expected requested settings to be reverted: %+v", stored)
+	}
+
+	// Answers without a request are ignored
+	scheduler.processFreqAnswer(&msg.FrameContext.Device, &protocol.MACPingSlotFreqAns{DataRangeOK: true, ChannelFrequencyOK: true})
+
+	// Send a new request and accept it
+	stored.RequestedPing = model.PingSlotSettings{DataRate: 2, Frequency: 869.3}
+	store.Device.Update(stored)
+	msg.FrameContext.Device = stored
+	scheduler.processUplink(msg)
+	if _, ok := getCommands()[protocol.PingSlotChannelReq]; !ok {
+		t.Fatal("Expected PingSlotChannelReq")
+	}
+	scheduler.processFreqAnswer(&msg.FrameContext.Device, &protocol.MACPingSlotFreqAns{DataRangeOK: true, ChannelFrequencyOK: true})
+	stored, _ = store.Device.GetByEUI(device.DeviceEUI)
+	if stored.PingSlot.DataRate != 2 || stored.PingSlot.Frequency != 869.3 || stored.PingSlot.Periodicity != 5 {
+		t.Fatalf("Expected ping slot settings to be updated: %+v", stored.PingSlot)
+	}
+
+	// Beacon timing
+	scheduler.processBeaconTimingRequest(msg)
+	ans, ok := getCommands()[protocol.BeaconTimingAns].(*protocol.MACBeaconTimingAns)
+	if !ok {
+		t.Fatal("Expected BeaconTimingAns")
+	}
+	next := protocol.NextBeacon(msg.FrameContext.GatewayContext.ReceivedAt)
+	delay := time.Duration(ans.Delay) * protocol.PingSlotLength
+	if arrival := msg.FrameContext.GatewayContext.ReceivedAt.Add(delay); next.Sub(arrival) < 0 || next.Sub(arrival) > protocol.PingSlotLength || ans.Channel != 0 {
+		t.Fatalf("Incorrect beacon timing: %+v", ans)
+	}
+}
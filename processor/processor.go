@@ -29,36 +29,62 @@ import (
 //
 // The pipeline is roughly built like this:
 //
-//    GW Forwarder -> Decoder -> Decrypter -> MAC Processor
-//          => Scheduler => Encoder -> GW Forwarder
+//    GW Forwarder -> Decoder -> Deduplicator -> Decrypter
+//          -> MAC Processor => Scheduler => Encoder -> GW Forwarder
 //
+// The beaconer sends class B beacons and the multicast scheduler sends
+// multicast downlinks directly to the GW Forwarder. Downlinks rejected by the
+// gateways are retried by the TX ack handler and the downstream expirer gives
+// up on queued messages when they expire.
 type Pipeline struct {
 	Decoder      *Decoder
+	Deduplicator *Deduplicator
 	Decrypter    *Decrypter
 	MACProcessor *MACProcessor
 	Scheduler    *Scheduler
 	Encoder      *Encoder
+	Beaconer     *Beaconer
+	Multicast    *MulticastScheduler
+	TXAcks       *TXAckHandler
+	Expirer      *DownstreamExpirer
 }
 
 // Start launches the pipeline
 func (p *Pipeline) Start() {
 	go p.Decoder.Start()
+	go p.Deduplicator.Start()
 	go p.Decrypter.Start()
 	go p.MACProcessor.Start()
 	go p.Scheduler.Start()
 	go p.Encoder.Start()
+	go p.Beaconer.Start()
+	go p.Multicast.Start()
+	go p.TXAcks.Start()
+	go p.Expirer.Start()
+}
+
+// Stop stops the parts of the pipeline that aren't stopped by the forwarder.
+// This must be called before the forwarder is stopped.
+func (p *Pipeline) Stop() {
+	p.Beaconer.Stop()
+	p.Multicast.Stop()
+	p.TXAcks.Stop()
+	p.Expirer.Stop()
 }
 
 // NewPipeline creates a new pipeline. The pipeline will stop automatically
-// when the forwarder is terminated
+// when the forwarder is terminated but Stop must be called first.
 func NewPipeline(context *server.Context, forwarder GwForwarder) *Pipeline {
 	ret := Pipeline{}
 
 	logging.Debug("Creating decoder...")
 	ret.Decoder = NewDecoder(context, forwarder.Output())
 
+	logging.Debug("Creating deduplicator...")
+	ret.Deduplicator = NewDeduplicator(context, ret.Decoder.Output(), context.Config.DedupWindow)
+
 	logging.Debug("Creating decrypter...")
-	ret.Decrypter = NewDecrypter(context, ret.Decoder.Output())
+	ret.Decrypter = NewDecrypter(context, ret.Deduplicator.Output())
 
 	logging.Debug("Creating MAC processor...")
 	ret.MACProcessor = NewMACProcessor(context, ret.Decrypter.Output())
@@ -69,5 +95,17 @@ func NewPipeline(context *server.Context, forwarder GwForwarder) *Pipeline {
 	logging.Debug("Creating encoder...")
 	ret.Encoder = NewEncoder(context, ret.Scheduler.Output(), forwarder.Input())
 
+	logging.Debug("Creating beaconer...")
+	ret.Beaconer = NewBeaconer(context, ret.Scheduler, forwarder.Input())
+
+	logging.Debug("Creating multicast scheduler...")
+	ret.Multicast = NewMulticastScheduler(context, ret.Scheduler, forwarder.Input())
+
+	logging.Debug("Creating TX ack handler...")
+	ret.TXAcks = NewTXAckHandler(context, ret.Scheduler, forwarder.Input())
+
+	logging.Debug("Creating downstream expirer...")
+	ret.Expirer = NewDownstreamExpirer(context)
+
 	return &ret
 }
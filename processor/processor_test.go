@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/frequency"
 	"github.com/ExploratoryEngineering/pubsub"
 
 	"github.com/ExploratoryEngineering/congress/model"
@@ -170,8 +171,11 @@ func newTestContext(t *testing.T) testContext {
 	ret := testContext{t: t}
 	ret.config = server.NewDefaultConfig()
 	ret.config.MemoryDB = true
+	// Each frame is received by a single gateway
+	ret.config.DedupWindow = 0
 	ret.datastore = memstore.CreateMemoryStorage(0, 0)
 	frameOutput := server.NewFrameOutputBuffer()
+	uplinkHistory := server.NewUplinkHistory(ADRHistoryLength)
 	keyGenerator, _ := server.NewEUIKeyGenerator(ret.config.RootMA(), uint32(ret.config.NetworkID), ret.datastore.Sequence)
 
 	appRouter := pubsub.NewEventRouter(5)
@@ -185,6 +189,8 @@ func newTestContext(t *testing.T) testContext {
 		GwEventRouter: &gwEventRouter,
 		AppRouter:     &appRouter,
 		AppOutput:     server.NewAppOutputManager(&appRouter),
+		UplinkHistory: &uplinkHistory,
+		JoinServer:    server.NewLocalJoinServer(&ret.datastore),
 	}
 	ret.forwarder = newTestForwarder()
 	ret.pipeline = NewPipeline(ret.context, ret.forwarder)
@@ -203,6 +209,10 @@ func newTestContext(t *testing.T) testContext {
 	ret.device.NwkSKey, _ = protocol.NewAESKey()
 	ret.device.State = model.PersonalizedDevice
 	ret.device.RelaxedCounter = true
+	// The device is set up with the full channel plan. This keeps the channel
+	// requests out of the downlinks.
+	eu, _ := band.NewBand(band.EU868Band)
+	ret.device.Channels = frequency.ChannelPlan(eu, ret.app)
 
 	ret.datastore.Device.Put(ret.device, ret.app.AppEUI)
 
@@ -395,6 +405,7 @@ func TestProcessingPipeline(t *testing.T) {
 			t.Fatalf("Expected ConfirmedDataDown but didn't get it. Got %v.", p.MHDR.MType)
 		}
 	})
+	c.pipeline.Stop()
 	c.forwarder.Stop()
 
 	updatedMsg, err := c.datastore.DeviceData.GetDownstream(c.device.DeviceEUI)
@@ -439,6 +450,7 @@ func TestProcessingPipeline(t *testing.T) {
 		t.Fatalf("Unexpected state for downstream message. Expected SentState but got %v", updatedMsg.State())
 	}
 
+	c.pipeline.Stop()
 	c.forwarder.Stop()
 }
 
@@ -447,6 +459,7 @@ func TestPipelineUpDown(t *testing.T) {
 	c := newTestContext(t)
 	c.pipeline.Start()
 	<-time.After(100 * time.Millisecond)
+	c.pipeline.Stop()
 	c.forwarder.Stop()
 	<-time.After(100 * time.Millisecond)
 
@@ -551,6 +564,7 @@ func TestDuplicateDevAddr(t *testing.T) {
 	}
 
 	<-time.After(100 * time.Millisecond)
+	c.pipeline.Stop()
 	c.forwarder.Stop()
 }
 
@@ -568,5 +582,6 @@ func TestInvalidMIC(t *testing.T) {
 	if msg := c.forwarder.grabMessage(timeToWaitForNoMessage); msg != nil {
 		t.Fatalf("Did not expect an ack message %v", msg)
 	}
+	c.pipeline.Stop()
 	c.forwarder.Stop()
 }
@@ -0,0 +1,135 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/monitoring"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// validRejoinRequest verifies the MIC and the RJcount in the Rejoin-request.
+// Type 0 and 2 requests are signed with the SNwkSIntKey. Type 1 requests are
+// signed with the JSIntKey and are verified by the join server [6.2.4.4].
+func (d *Decrypter) validRejoinRequest(device model.Device, decoded server.LoRaMessage) bool {
+	rejoin := decoded.Payload.RejoinRequestPayload
+	expected := device.RJCount0
+	if rejoin.RejoinType == protocol.RejoinType1 {
+		expected = device.RJCount1
+	} else if !d.validRejoinMIC(device, decoded) {
+		return false
+	}
+
+	// The RJcount is incremented for every request. Old values are replays.
+	if rejoin.RJCount < expected {
+		logging.Warning("Device %s has already used RJcount%d %d. Ignoring it.",
+			device.DeviceEUI, rejoin.RejoinType&0x01, rejoin.RJCount)
+		monitoring.LoRaCounterFailed.Increment()
+		return false
+	}
+	return true
+}
+
+// validRejoinMIC checks the network ID and the MIC for type 0 and 2
+// Rejoin-requests.
+func (d *Decrypter) validRejoinMIC(device model.Device, decoded server.LoRaMessage) bool {
+	if rejoin := decoded.Payload.RejoinRequestPayload; rejoin.NetID != uint32(d.context.Config.NetworkID) {
+		logging.Info("Rejoin-request from device %s is for network %06x. Ignoring it.", device.DeviceEUI, rejoin.NetID)
+		return false
+	}
+
+	rawMessage := decoded.FrameContext.GatewayContext.RawMessage
+	mic, err := decoded.Payload.CalculateRejoinRequestMIC(device.SNwkSIntKey, rawMessage[0:len(rawMessage)-4])
+	if err != nil {
+		logging.Warning("Unable to calculate MIC for Rejoin-request from device %s: %v", device.DeviceEUI, err)
+		return false
+	}
+	if mic != decoded.Payload.MIC {
+		monitoring.LoRaMICFailed.Increment()
+		logging.Info("MIC validation failed for Rejoin-request from device %s", device.DeviceEUI)
+		return false
+	}
+	return true
+}
+
+// processRejoinRequest handles Rejoin-requests from LoRaWAN 1.1 devices. All
+// rejoin types generate a new set of session keys and reset the frame
+// counters. Type 0 and 1 requests reset the radio settings as well while type
+// 2 requests keep them [6.2.4.2]. The new session is kept as a pending session
+// and the device keeps the current one until an uplink verifies with the new
+// keys since the JoinAccept might be lost [6.2.4.4]. Returns false if it
+// failed.
+func (d *Decrypter) processRejoinRequest(decoded server.LoRaMessage) bool {
+	monitoring.LoRaJoinRequest.Increment()
+	rejoin := decoded.Payload.RejoinRequestPayload
+
+	device, err := d.context.Storage.Device.GetByEUI(rejoin.DevEUI)
+	if err != nil {
+		logging.Info("Unknown device attempting Rejoin-request: %s", rejoin.DevEUI)
+		return false
+	}
+	if device.MACVersion != protocol.MACVersion11 || device.State != model.OverTheAirDevice {
+		logging.Warning("Device %s isn't a LoRaWAN 1.1 OTAA device. Ignoring Rejoin-request.", device.DeviceEUI)
+		return false
+	}
+	if !d.validRejoinRequest(device, decoded) {
+		return false
+	}
+
+	app, err := d.context.Storage.Application.GetByEUI(device.AppEUI, model.SystemUserID)
+	if err != nil {
+		logging.Warning("Unable to retrieve application with EUI %s. Ignoring Rejoin-request from device with EUI %s",
+			device.AppEUI, device.DeviceEUI)
+		return false
+	}
+	decoded.FrameContext.Application = app
+
+	// The new session is set up on a copy of the device
+	plan := decoded.FrameContext.GatewayContext.Radio.Band
+	session := device
+	var cfList protocol.CFList
+	resetRadio := rejoin.RejoinType != protocol.RejoinType2
+	if resetRadio {
+		cfList = resetDeviceSettings(&session, plan, decoded.FrameContext.GatewayContext.Gateway.SubBand, app)
+	}
+	joinAccept := d.newJoinAccept(session, plan, cfList)
+
+	answer, err := d.join(&session, app, decoded, joinAccept)
+	if err != nil {
+		logging.Warning("Join server rejected Rejoin-request: %v (devEUI: %s). Ignoring Rejoin-request",
+			err, device.DeviceEUI)
+		return false
+	}
+	device.JoinNonce = session.JoinNonce
+	device.DevNonceHistory = session.DevNonceHistory
+	device.PendingSession = model.NewPendingSession(session, resetRadio)
+	if rejoin.RejoinType == protocol.RejoinType1 {
+		device.RJCount1 = rejoin.RJCount + 1
+	} else {
+		device.RJCount0 = rejoin.RJCount + 1
+	}
+
+	if err := d.context.Storage.Device.Update(device); err != nil {
+		logging.Error("Unable to update device with EUI %s: %v", device.DeviceEUI, err)
+		return false
+	}
+
+	decoded.FrameContext.Rejoin = &rejoin
+	d.sendJoinAccept(decoded, device, joinAccept, answer)
+	return true
+}
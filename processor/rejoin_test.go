@@ -0,0 +1,206 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func TestRejoinRequest(t *testing.T) {
+	deviceEUI := protocol.EUIFromUint64(1)
+	appEUI := protocol.EUIFromUint64(2)
+
+	store := memstore.CreateMemoryStorage(0, 0)
+	application := model.NewApplication()
+	application.AppEUI = appEUI
+	device := model.NewDevice()
+	device.DeviceEUI = deviceEUI
+	device.AppEUI = appEUI
+	device.State = model.OverTheAirDevice
+	device.MACVersion = protocol.MACVersion11
+	device.AppKey = protocol.AESKey{Key: [16]byte{1}}
+	device.NwkKey = protocol.AESKey{Key: [16]byte{2}}
+	device.SNwkSIntKey = protocol.AESKey{Key: [16]byte{3}}
+	device.FCntUp = 100
+	device.FCntDn = 200
+	device.DataRate = 5
+	device.RJCount0 = 10
+
+	store.Application.Put(application, model.SystemUserID)
+	store.Device.Put(device, appEUI)
+
+	foBuffer := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	decrypter := NewDecrypter(&server.Context{
+		Storage:       &store,
+		FrameOutput:   &foBuffer,
+		Config:        &server.Configuration{NetworkID: 0x13},
+		UplinkHistory: &history,
+		JoinServer:    server.NewLocalJoinServer(&store),
+	}, make(chan server.LoRaMessage))
+
+	eu, _ := band.NewBand(band.EU868Band)
+	newRejoin := func(rejoinType byte, netID uint32, rjCount uint16, key protocol.AESKey) server.LoRaMessage {
+		payload := protocol.NewPHYPayload(protocol.RejoinRequest)
+		payload.RejoinRequestPayload = protocol.RejoinRequestPayload{
+			RejoinType: rejoinType,
+			NetID:      netID,
+			JoinEUI:    appEUI,
+			DevEUI:     deviceEUI,
+			RJCount:    rjCount,
+		}
+		buf, err := payload.EncodeRejoinRequest(key)
+		if err != nil {
+			t.Fatal("Unable to encode Rejoin-request: ", err)
+		}
+		return server.LoRaMessage{
+			Payload: payload,
+			FrameContext: server.FrameContext{
+				GatewayContext: server.GatewayPacket{RawMessage: buf, Radio: server.RadioContext{Band: eu}},
+			},
+		}
+	}
+
+	// Wrong network, wrong key and old RJcount0 are rejected
+	if decrypter.processRejoinRequest(newRejoin(protocol.RejoinType0, 0x14, 10, device.SNwkSIntKey)) {
+		t.Fatal("Expected Rejoin-request for other network to fail")
+	}
+	if decrypter.processRejoinRequest(newRejoin(protocol.RejoinType0, 0x13, 10, device.NwkKey)) {
+		t.Fatal("Expected Rejoin-request with invalid MIC to fail")
+	}
+	if decrypter.processRejoinRequest(newRejoin(protocol.RejoinType0, 0x13, 9, device.SNwkSIntKey)) {
+		t.Fatal("Expected Rejoin-request with old RJcount0 to fail")
+	}
+
+	// Type 2 keeps the radio settings
+	input := newRejoin(protocol.RejoinType2, 0x13, 10, device.SNwkSIntKey)
+	go decrypter.processRejoinRequest(input)
+
+	var output server.LoRaMessage
+	select {
+	case output = <-decrypter.Output():
+		// OK
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not get output on output channel!")
+	}
+	if output.FrameContext.Rejoin == nil || output.FrameContext.Rejoin.RJCount != 10 {
+		t.Fatalf("Expected Rejoin-request in frame context: %+v", output.FrameContext.Rejoin)
+	}
+	// The device keeps the current session until it uses the new one. The
+	// RJcount0 is advanced.
+	rejoined, _ := store.Device.GetByEUI(deviceEUI)
+	if rejoined.FCntUp != 100 || rejoined.FCntDn != 200 || rejoined.RJCount0 != 11 || rejoined.SNwkSIntKey != device.SNwkSIntKey {
+		t.Fatalf("Expected current session to be kept after type 2 Rejoin-request: %+v", rejoined)
+	}
+	pending := rejoined.PendingSession
+	if pending == nil || pending.SNwkSIntKey == device.SNwkSIntKey || pending.ResetRadio || rejoined.JoinNonce != 1 {
+		t.Fatalf("Expected pending session after Rejoin-request: %+v", rejoined)
+	}
+	// Replays of the request are rejected and the pending session is kept
+	if decrypter.processRejoinRequest(newRejoin(protocol.RejoinType2, 0x13, 10, device.SNwkSIntKey)) {
+		t.Fatal("Expected replayed Rejoin-request to fail")
+	}
+	if replayed, _ := store.Device.GetByEUI(deviceEUI); replayed.JoinNonce != 1 || replayed.PendingSession == nil ||
+		replayed.PendingSession.SNwkSIntKey != pending.SNwkSIntKey {
+		t.Fatalf("Replayed Rejoin-request changed the device: %+v", replayed)
+	}
+	joinAccept, err := foBuffer.GetPHYPayloadForDevice(&rejoined, &input.FrameContext)
+	if err != nil {
+		t.Fatal("Expected JoinAccept for device: ", err)
+	}
+	if !joinAccept.JoinAcceptPayload.CFList.Empty() {
+		t.Fatalf("Did not expect CFList in JoinAccept for type 2 Rejoin-request: %+v", joinAccept.JoinAcceptPayload)
+	}
+
+	// JoinAccepts for Rejoin-requests are encrypted with the JSEncKey
+	jsIntKey, _ := protocol.JSIntKeyFromDevEUI(device.NwkKey, deviceEUI)
+	jsEncKey, _ := protocol.JSEncKeyFromDevEUI(device.NwkKey, deviceEUI)
+	decoded := protocol.NewPHYPayload(protocol.JoinAccept)
+	if err := decoded.DecodeRejoinAccept(jsEncKey, jsIntKey, protocol.RejoinType2, appEUI, 10, input.FrameContext.JoinAccept); err != nil {
+		t.Fatal("Unable to decode JoinAccept for Rejoin-request: ", err)
+	}
+
+	// Uplinks with the old keys keep the current session...
+	newUplink := func(dev model.Device) (server.LoRaMessage, []byte) {
+		payload := protocol.NewPHYPayload(protocol.UnconfirmedDataUp)
+		payload.MACPayload.FHDR.DevAddr = dev.DevAddr
+		payload.MACPayload.FHDR.FCnt = uint16(dev.FCntUp)
+		payload.MACPayload.FPort = 1
+		payload.MACPayload.FRMPayload = []byte{1, 2, 3}
+		msg := server.LoRaMessage{
+			Payload: payload,
+			FrameContext: server.FrameContext{
+				GatewayContext: server.GatewayPacket{Radio: server.RadioContext{Band: eu, DataRate: "SF12BW125", Frequency: 868.1}},
+			},
+		}
+		buf, _ := payload.MarshalBinary()
+		msg.Payload.MIC, _ = calculateUplinkMIC(dev, msg, buf[0:len(buf)-4])
+		buf, _ = msg.Payload.MarshalBinary()
+		return msg, buf
+	}
+	uplink, buf := newUplink(rejoined)
+	if _, ok := decrypter.switchToPendingSession(rejoined, uplink, buf); ok {
+		t.Fatal("Did not expect switch to new session with old keys")
+	}
+
+	// ...and the first uplink with the new keys switches to the new session
+	switched, _ := rejoined.WithPendingSession()
+	uplink, buf = newUplink(switched)
+	if _, ok := decrypter.switchToPendingSession(rejoined, uplink, buf); !ok {
+		t.Fatal("Expected switch to new session")
+	}
+	rejoined, _ = store.Device.GetByEUI(deviceEUI)
+	if rejoined.PendingSession != nil || rejoined.SNwkSIntKey != pending.SNwkSIntKey || rejoined.FCntUp != 0 || rejoined.FCntDn != 0 || rejoined.RJCount0 != 0 || rejoined.DataRate != 5 {
+		t.Fatalf("Unexpected device after switch to new session: %+v", rejoined)
+	}
+
+	// Type 1 uses the JSIntKey and the JoinEUI and resets the radio settings.
+	// The join server checks the MIC.
+	if decrypter.processRejoinRequest(newRejoin(protocol.RejoinType1, 0, 7, device.SNwkSIntKey)) {
+		t.Fatal("Expected type 1 Rejoin-request with invalid MIC to fail")
+	}
+	go decrypter.processRejoinRequest(newRejoin(protocol.RejoinType1, 0, 7, jsIntKey))
+	select {
+	case <-decrypter.Output():
+		// OK
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not get output on output channel!")
+	}
+	rejoined, _ = store.Device.GetByEUI(deviceEUI)
+	if rejoined.RJCount1 != 8 || rejoined.DataRate != 5 || rejoined.JoinNonce != 2 {
+		t.Fatalf("Unexpected device after type 1 Rejoin-request: %+v", rejoined)
+	}
+	if rejoined.PendingSession == nil || !rejoined.PendingSession.ResetRadio || rejoined.PendingSession.DataRate != 0 {
+		t.Fatalf("Expected radio settings to be reset in the pending session: %+v", rejoined.PendingSession)
+	}
+	if decrypter.processRejoinRequest(newRejoin(protocol.RejoinType1, 0, 7, jsIntKey)) {
+		t.Fatal("Expected Rejoin-request with old RJcount1 to fail")
+	}
+
+	// LoRaWAN 1.0 devices can't rejoin
+	rejoined.MACVersion = protocol.MACVersion10
+	store.Device.Update(rejoined)
+	if decrypter.processRejoinRequest(newRejoin(protocol.RejoinType0, 0x13, 10, rejoined.SNwkSIntKey)) {
+		t.Fatal("Expected Rejoin-request from LoRaWAN 1.0 device to fail")
+	}
+}
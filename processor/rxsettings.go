@@ -0,0 +1,202 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"sync"
+
+	"github.com/ExploratoryEngineering/congress/frequency"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// rxSettingsPendingLimit is the number of uplinks to wait for an answer
+// before the RXParamSetupReq and RXTimingSetupReq commands are sent again.
+const rxSettingsPendingLimit = 4
+
+// pendingRX is the receive window settings sent to a device that hasn't been
+// answered yet.
+type pendingRX struct {
+	settings model.RXSettings
+	timing   bool // RXTimingSetupReq is sent
+	params   bool // RXParamSetupReq is sent
+	uplinks  int
+}
+
+// rxSettingsScheduler sends RXParamSetupReq and RXTimingSetupReq commands to
+// devices when the requested receive window settings differ from the settings
+// the device uses. The device's settings are updated when the device
+// acknowledges the commands.
+type rxSettingsScheduler struct {
+	context *server.Context
+	pending map[protocol.EUI]pendingRX
+	mutex   *sync.Mutex
+}
+
+func newRXSettingsScheduler(context *server.Context) *rxSettingsScheduler {
+	return &rxSettingsScheduler{
+		context: context,
+		pending: make(map[protocol.EUI]pendingRX),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// processUplink schedules the setup commands for the device if the settings
+// have changed.
+func (r *rxSettingsScheduler) processUplink(msg server.LoRaMessage) {
+	mtype := msg.Payload.MHDR.MType
+	if mtype != protocol.UnconfirmedDataUp && mtype != protocol.ConfirmedDataUp {
+		return
+	}
+	device := msg.FrameContext.Device
+	if device.RXSettings == device.RequestedRX {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if p, exists := r.pending[device.DeviceEUI]; exists {
+		p.uplinks++
+		if p.uplinks < rxSettingsPendingLimit {
+			r.pending[device.DeviceEUI] = p
+			return
+		}
+		logging.Info("No answer to RX settings from device %s after %d uplinks", device.DeviceEUI, p.uplinks)
+	}
+
+	plan := msg.FrameContext.GatewayContext.Radio.Band
+	current := device.RXSettings
+	requested := device.RequestedRX
+	p := pendingRX{settings: requested}
+
+	if current.RX1Window() != requested.RX1Window() {
+		cmd := protocol.NewDownlinkMACCommand(protocol.RXTimingSetupReq).(*protocol.MACRXTimingSetupReq)
+		cmd.Del = requested.RX1Delay
+		if err := r.context.FrameOutput.AddMACCommand(device.DeviceEUI, cmd); err != nil {
+			logging.Warning("Unable to schedule RXTimingSetupReq for device %s: %v", device.DeviceEUI, err)
+			return
+		}
+		p.timing = true
+	}
+
+	rx2 := requested.RX2Parameters(plan)
+	if current.RX1DROffset != requested.RX1DROffset || current.RX2Parameters(plan) != rx2 {
+		cmd := protocol.NewDownlinkMACCommand(protocol.RXParamSetupReq).(*protocol.MACRXParamSetupReq)
+		cmd.RX1DRoffset = requested.RX1DROffset
+		cmd.RX2DataRate = rx2.DataRate
+		cmd.Frequency = frequency.Steps(rx2.Frequency)
+		if err := r.context.FrameOutput.AddMACCommand(device.DeviceEUI, cmd); err != nil {
+			logging.Warning("Unable to schedule RXParamSetupReq for device %s: %v", device.DeviceEUI, err)
+			return
+		}
+		p.params = true
+	}
+
+	if !p.timing && !p.params {
+		// The settings are equivalent. Nothing to send.
+		return
+	}
+	r.pending[device.DeviceEUI] = p
+}
+
+// completeRequest removes the timing or parameter part of the pending request
+// and returns the requested settings.
+func (r *rxSettingsScheduler) completeRequest(eui protocol.EUI, timing bool) (model.RXSettings, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, exists := r.pending[eui]
+	if !exists || (timing && !p.timing) || (!timing && !p.params) {
+		return model.RXSettings{}, false
+	}
+	if timing {
+		p.timing = false
+	} else {
+		p.params = false
+	}
+	if !p.timing && !p.params {
+		delete(r.pending, eui)
+	} else {
+		r.pending[eui] = p
+	}
+	return p.settings, true
+}
+
+// updateDevice applies the changes to the stored device. The device in the
+// frame context is updated as well since the new settings are in effect for
+// the downlink that follows.
+func (r *rxSettingsScheduler) updateDevice(device *model.Device, apply func(settings *model.RXSettings)) {
+	apply(&device.RXSettings)
+
+	stored, err := r.context.Storage.Device.GetByEUI(device.DeviceEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	apply(&stored.RXSettings)
+	if err := r.context.Storage.Device.Update(stored); err != nil {
+		logging.Warning("Unable to update RX settings for device %s: %v", device.DeviceEUI, err)
+	}
+}
+
+// processTimingAnswer handles the RXTimingSetupAns from the device.
+func (r *rxSettingsScheduler) processTimingAnswer(device *model.Device) {
+	settings, ok := r.completeRequest(device.DeviceEUI, true)
+	if !ok {
+		logging.Info("Got RXTimingSetupAns from device %s but there's no pending RXTimingSetupReq", device.DeviceEUI)
+		return
+	}
+	r.updateDevice(device, func(s *model.RXSettings) {
+		s.RX1Delay = settings.RX1Delay
+	})
+}
+
+// processParamAnswer handles the RXParamSetupAns from the device. The
+// settings are only changed when all of the ack bits are set [5.4]. If the
+// device rejects the settings the requested settings are reverted to avoid
+// sending the same request over and over again.
+func (r *rxSettingsScheduler) processParamAnswer(device *model.Device, ans *protocol.MACRXParamSetupAns) {
+	settings, ok := r.completeRequest(device.DeviceEUI, false)
+	if !ok {
+		logging.Info("Got RXParamSetupAns from device %s but there's no pending RXParamSetupReq", device.DeviceEUI)
+		return
+	}
+	if !ans.RX1DRoffsetACK || !ans.RX2DataRateACK || !ans.ChannelACK {
+		logging.Warning("Device %s rejected RXParamSetupReq (rx1 offset ack=%t, rx2 data rate ack=%t, channel ack=%t). Reverting to current settings.",
+			device.DeviceEUI, ans.RX1DRoffsetACK, ans.RX2DataRateACK, ans.ChannelACK)
+		stored, err := r.context.Storage.Device.GetByEUI(device.DeviceEUI)
+		if err != nil {
+			logging.Warning("Unable to retrieve device %s: %v", device.DeviceEUI, err)
+			return
+		}
+		stored.RequestedRX.RX1DROffset = stored.RXSettings.RX1DROffset
+		stored.RequestedRX.RX2DataRate = stored.RXSettings.RX2DataRate
+		stored.RequestedRX.RX2Frequency = stored.RXSettings.RX2Frequency
+		if err := r.context.Storage.Device.Update(stored); err != nil {
+			logging.Warning("Unable to update RX settings for device %s: %v", device.DeviceEUI, err)
+		}
+		return
+	}
+	r.updateDevice(device, func(s *model.RXSettings) {
+		s.RX1DROffset = settings.RX1DROffset
+		s.RX2DataRate = settings.RX2DataRate
+		s.RX2Frequency = settings.RX2Frequency
+	})
+}
@@ -0,0 +1,249 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func TestRXSettingsScheduler(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	frameOutput := server.NewFrameOutputBuffer()
+	context := &server.Context{Storage: &store, FrameOutput: &frameOutput}
+
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(1)
+	store.Application.Put(app, model.SystemUserID)
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	store.Device.Put(device, app.AppEUI)
+
+	eu, _ := band.NewBand(band.EU868Band)
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device:         device,
+			GatewayContext: server.GatewayPacket{Radio: server.RadioContext{Band: eu, DataRate: "SF7BW125"}},
+		},
+	}
+
+	getCommands := func() map[protocol.CID]protocol.MACCommand {
+		ret := make(map[protocol.CID]protocol.MACCommand)
+		payload, err := frameOutput.GetPHYPayloadForDevice(&device, &msg.FrameContext)
+		if err != nil {
+			return ret
+		}
+		for _, v := range payload.MACPayload.MACCommands.List() {
+			ret[v.ID()] = v
+		}
+		return ret
+	}
+
+	scheduler := newRXSettingsScheduler(context)
+
+	// Settings are the same. Nothing to send.
+	scheduler.processUplink(msg)
+	if len(getCommands()) != 0 {
+		t.Fatal("Did not expect any commands when settings are unchanged")
+	}
+
+	device.RequestedRX = model.RXSettings{RX1Delay: 3, RX1DROffset: 1, RX2DataRate: 3, RX2Frequency: 869.1}
+	store.Device.Update(device)
+	msg.FrameContext.Device = device
+	scheduler.processUplink(msg)
+	cmds := getCommands()
+	timing, ok := cmds[protocol.RXTimingSetupReq].(*protocol.MACRXTimingSetupReq)
+	if !ok || timing.Del != 3 {
+		t.Fatalf("Expected RXTimingSetupReq with Del=3 but got %v", cmds)
+	}
+	params, ok := cmds[protocol.RXParamSetupReq].(*protocol.MACRXParamSetupReq)
+	if !ok || params.RX1DRoffset != 1 || params.RX2DataRate != 3 || params.Frequency != 8691000 {
+		t.Fatalf("Expected RXParamSetupReq but got %v", cmds)
+	}
+
+	// Requests are pending. Nothing new should be sent.
+	scheduler.processUplink(msg)
+	if len(getCommands()) != 0 {
+		t.Fatal("Did not expect commands while the requests are pending")
+	}
+
+	// Device accepts the new timing. The device in the message should be
+	// updated as well.
+	scheduler.processTimingAnswer(&msg.FrameContext.Device)
+	if msg.FrameContext.Device.RXSettings.RX1Delay != 3 {
+		t.Fatalf("Expected RX1 delay to be updated in message: %+v", msg.FrameContext.Device.RXSettings)
+	}
+	stored, _ := store.Device.GetByEUI(device.DeviceEUI)
+	if stored.RXSettings.RX1Delay != 3 || stored.RXSettings.RX1DROffset != 0 {
+		t.Fatalf("Unexpected settings for stored device: %+v", stored.RXSettings)
+	}
+
+	// ...but rejects the parameters. The requested settings are reverted.
+	scheduler.processParamAnswer(&msg.FrameContext.Device, &protocol.MACRXParamSetupAns{RX1DRoffsetACK: true, RX2DataRateACK: false, ChannelACK: true})
+	stored, _ = store.Device.GetByEUI(device.DeviceEUI)
+	if stored.RXSettings.RX2Frequency != 0 || stored.RequestedRX.RX2Frequency != 0 || stored.RequestedRX.RX1Delay != 3 {
+		t.Fatalf("Expected requested settings to be reverted: %+v", stored)
+	}
+
+	// Answers without a request are ignored
+	scheduler.processTimingAnswer(&msg.FrameContext.Device)
+
+	// Send a new request and accept it
+	stored.RequestedRX = model.RXSettings{RX1Delay: 3, RX1DROffset: 2}
+	store.Device.Update(stored)
+	msg.FrameContext.Device = stored
+	scheduler.processUplink(msg)
+	cmds = getCommands()
+	if _, ok := cmds[protocol.RXTimingSetupReq]; ok || len(cmds) != 1 {
+		t.Fatalf("Expected just RXParamSetupReq but got %v", cmds)
+	}
+	scheduler.processParamAnswer(&msg.FrameContext.Device, &protocol.MACRXParamSetupAns{RX1DRoffsetACK: true, RX2DataRateACK: true, ChannelACK: true})
+	stored, _ = store.Device.GetByEUI(device.DeviceEUI)
+	if stored.RXSettings.RX1DROffset != 2 {
+		t.Fatalf("Expected RX1 offset to be updated: %+v", stored.RXSettings)
+	}
+}
+
+func TestDownlinkRadio(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device: model.NewDevice(),
+			GatewayContext: server.GatewayPacket{
+				Radio: server.RadioContext{Band: eu, DataRate: "SF7BW125", Frequency: 868.3},
+			},
+		},
+	}
+
+	radio := downlinkRadio(msg)
+	if radio.RX1Delay != 1 || radio.RX2Delay != 2 || radio.DataRate != "SF7BW125" || radio.Frequency != 868.3 {
+		t.Fatalf("Unexpected downlink radio with default settings: %+v", radio)
+	}
+
+	msg.FrameContext.Device.RXSettings = model.RXSettings{RX1Delay: 4, RX1DROffset: 2}
+	radio = downlinkRadio(msg)
+	if radio.RX1Delay != 4 || radio.RX2Delay != 5 || radio.DataRate != "SF9BW125" {
+		t.Fatalf("Unexpected downlink radio with RX settings: %+v", radio)
+	}
+
+	// JoinAccept ignores the settings
+	msg.Payload = protocol.NewPHYPayload(protocol.JoinRequest)
+	radio = downlinkRadio(msg)
+	if radio.RX1Delay != 5 || radio.RX2Delay != 6 || radio.DataRate != "SF7BW125" {
+		t.Fatalf("Unexpected downlink radio for JoinAccept: %+v", radio)
+	}
+
+	// ...and so does type 0 Rejoin-requests. Type 2 keeps the settings.
+	msg.Payload = protocol.NewPHYPayload(protocol.RejoinRequest)
+	radio = downlinkRadio(msg)
+	if radio.RX1Delay != 5 || radio.DataRate != "SF7BW125" {
+		t.Fatalf("Unexpected downlink radio for type 0 Rejoin-request: %+v", radio)
+	}
+	msg.Payload.RejoinRequestPayload.RejoinType = protocol.RejoinType2
+	radio = downlinkRadio(msg)
+	if radio.RX1Delay != 5 || radio.DataRate != "SF9BW125" {
+		t.Fatalf("Unexpected downlink radio for type 2 Rejoin-request: %+v", radio)
+	}
+}
+
+func TestRX2Radio(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device: model.NewDevice(),
+			GatewayContext: server.GatewayPacket{
+				Radio: server.RadioContext{Band: eu, DataRate: "SF7BW125", Frequency: 868.3, RFChain: 1},
+			},
+		},
+	}
+
+	radio := downlinkRadio(msg)
+	if radio.RXWindow != band.RX1 || radio.TXPower != 14 || radio.RFChain != txRFChain {
+		t.Fatalf("Unexpected RX1 radio: %+v", radio)
+	}
+
+	rx2 := rx2Radio(msg)
+	if rx2 == nil {
+		t.Fatal("Expected RX2 radio")
+	}
+	if rx2.RXWindow != band.RX2 || rx2.RX2Delay != 2 || rx2.DataRate != "SF12BW125" || rx2.Frequency != 869.525 || rx2.TXPower != 27 {
+		t.Fatalf("Unexpected RX2 radio with default settings: %+v", rx2)
+	}
+	if rx2.RXDelay() != 2*time.Second {
+		t.Fatalf("Expected RX2 delay but got %v", rx2.RXDelay())
+	}
+
+	msg.FrameContext.Device.RXSettings = model.RXSettings{RX1Delay: 3, RX2DataRate: 3, RX2Frequency: 869.1}
+	rx2 = rx2Radio(msg)
+	if rx2.RX2Delay != 4 || rx2.DataRate != "SF9BW125" || rx2.Frequency != 869.1 || rx2.TXPower != 14 {
+		t.Fatalf("Unexpected RX2 radio with RX settings: %+v", rx2)
+	}
+
+	// JoinAccept uses the default settings
+	msg.Payload = protocol.NewPHYPayload(protocol.JoinRequest)
+	rx2 = rx2Radio(msg)
+	if rx2.RX2Delay != 6 || rx2.DataRate != "SF12BW125" || rx2.Frequency != 869.525 {
+		t.Fatalf("Unexpected RX2 radio for JoinAccept: %+v", rx2)
+	}
+}
+
+func TestSelectWindow(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	msg := server.LoRaMessage{
+		Payload: protocol.NewPHYPayload(protocol.UnconfirmedDataUp),
+		FrameContext: server.FrameContext{
+			Device: model.NewDevice(),
+			GatewayContext: server.GatewayPacket{
+				Radio:      server.RadioContext{Band: eu, DataRate: "SF7BW125", Frequency: 868.3},
+				ReceivedAt: time.Now(),
+			},
+		},
+	}
+	rx2 := rx2Radio(msg)
+	msg.FrameContext.GatewayContext.Radio = downlinkRadio(msg)
+
+	// Plenty of time left for RX1
+	frameContext := msg.FrameContext
+	selectWindow(&frameContext, rx2)
+	if frameContext.GatewayContext.Radio.RXWindow != band.RX1 {
+		t.Fatal("Expected RX1 when there's time left")
+	}
+
+	// Too late for RX1
+	frameContext.GatewayContext.ReceivedAt = time.Now().Add(-900 * time.Millisecond)
+	selectWindow(&frameContext, rx2)
+	if frameContext.GatewayContext.Radio.RXWindow != band.RX2 || frameContext.GatewayContext.Radio.Frequency != 869.525 {
+		t.Fatalf("Expected RX2 when RX1 deadline can't be met: %+v", frameContext.GatewayContext.Radio)
+	}
+
+	// RX1 is kept if there's no RX2 radio
+	frameContext = msg.FrameContext
+	frameContext.GatewayContext.ReceivedAt = time.Now().Add(-900 * time.Millisecond)
+	selectWindow(&frameContext, nil)
+	if frameContext.GatewayContext.Radio.RXWindow != band.RX1 {
+		t.Fatal("Expected RX1 without RX2 radio")
+	}
+}
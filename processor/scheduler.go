@@ -18,6 +18,7 @@ package processor
 import (
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/monitoring"
 
 	"github.com/ExploratoryEngineering/congress/model"
@@ -29,25 +30,34 @@ import (
 // Scheduler is the process that schedules downlink frames. The sceduler reads
 // from a command notifier channel and will schedule a frame to be sent to the
 // device when it receives notification of an uplink. If the frame to be sent
-// is empty it won't generate any output.
+// is empty it won't generate any output. Class C devices get their downlinks
+// as soon as the server is notified of a new downlink message and class B
+// devices get them in the next ping slot.
 type Scheduler struct {
-	notifier     <-chan server.LoRaMessage // Input channel; messages on this channel is received
-	output       chan server.LoRaMessage   // Output channel; message will be sent when put on this channel
-	scheduled    map[protocol.EUI]bool     // Map with devaddr for scheduled devices
-	completed    chan protocol.EUI         // Channel for completed schedules
-	context      *server.Context           // Server context
-	fixedRxDelay time.Duration
+	notifier        <-chan server.LoRaMessage // Input channel; messages on this channel is received
+	output          chan server.LoRaMessage   // Output channel; message will be sent when put on this channel
+	scheduled       map[protocol.EUI]bool     // Map with devaddr for scheduled devices
+	completed       chan protocol.EUI         // Channel for completed schedules
+	context         *server.Context           // Server context
+	rxDelayOverride time.Duration             // Fixed delay used by tests
+	occupancy       *txOccupancy              // Transmissions scheduled on the gateways
+	pingSlots       chan pingSlot             // Upcoming ping slots for class B devices with downlinks
 }
 
-// DefaultRXDelay is the default delay
-const DefaultRXDelay time.Duration = 200 * time.Millisecond
+// downlinkLeadTime is the time before the receive window opens that the
+// scheduler builds the downlink frame. This leaves time for encoding and the
+// round trip to the gateway.
+const downlinkLeadTime = 800 * time.Millisecond
 
-// calculate the rx delay/wait time for the device with the specified DevAddr. Returned
-// value is in us. (note that 1 second is incorrect)
-// TODO(stalehd): Use device method for this.
+// calculate the wait time before the downlink message is built. The wait time
+// is based on the delay for the first receive window.
 func (s *Scheduler) calculateRxDelay(receivedMessage server.LoRaMessage) time.Duration {
+	delay := time.Duration(receivedMessage.FrameContext.GatewayContext.Radio.RX1Delay)*time.Second - downlinkLeadTime
+	if s.rxDelayOverride > 0 {
+		delay = s.rxDelayOverride
+	}
 	spentTime := time.Now().Sub(receivedMessage.FrameContext.GatewayContext.ReceivedAt)
-	delay := s.fixedRxDelay - spentTime
+	delay -= spentTime
 	if delay < 0 {
 		return 0
 	}
@@ -56,7 +66,127 @@ func (s *Scheduler) calculateRxDelay(receivedMessage server.LoRaMessage) time.Du
 
 // SetRXDelay adjusts the RX delay. This is only for testing
 func (s *Scheduler) SetRXDelay(newDelay time.Duration) {
-	s.fixedRxDelay = newDelay
+	s.rxDelayOverride = newDelay
+}
+
+// txRFChain is the gateway RF chain used for downlinks. The reference
+// configurations for the packet forwarder only enable TX on the first radio.
+const txRFChain = 0
+
+// downlinkSettings returns the RX settings used for a downlink to the device
+// and sets the receive window delays for the radio. JoinAccept messages use
+// the join accept delay and the default settings [6.2.5]. Type 2
+// Rejoin-requests keep the device's radio settings [6.2.4.2]. The returned
+// flag is set if the device's settings are used.
+func downlinkSettings(message server.LoRaMessage, radio *server.RadioContext) (model.RXSettings, bool) {
+	plan := radio.Band
+	settings := message.FrameContext.Device.RXSettings
+	mtype := message.Payload.MHDR.MType
+	keepSettings := mtype != protocol.JoinRequest
+	if mtype == protocol.RejoinRequest {
+		keepSettings = message.Payload.RejoinRequestPayload.RejoinType == protocol.RejoinType2
+	}
+	if mtype == protocol.JoinRequest || mtype == protocol.RejoinRequest {
+		if !keepSettings {
+			settings = model.DefaultRXSettings()
+		}
+		radio.RX1Delay = plan.Configuration().JoinAccepDelay1
+		radio.RX2Delay = plan.Configuration().JoinAccepDelay2
+	} else {
+		radio.RX1Delay = uint8(settings.RX1Window() / time.Second)
+		radio.RX2Delay = uint8(settings.RX2Window() / time.Second)
+	}
+	return settings, keepSettings
+}
+
+// downlinkRadio returns the radio settings for a downlink in the first
+// receive window of the device. The data rate, frequency and power are from
+// the band's RX1 parameters. If the data rate or frequency can't be
+// determined the uplink settings are used.
+func downlinkRadio(message server.LoRaMessage) server.RadioContext {
+	ret := message.FrameContext.GatewayContext.Radio
+	plan := ret.Band
+	settings, keepSettings := downlinkSettings(message, &ret)
+	ret.RXWindow = band.RX1
+	ret.RFChain = txRFChain
+	ret.TXPower = plan.Configuration().DefaultTxPower
+
+	uplinkDataRate, err := plan.GetDataRate(ret.DataRate)
+	if err != nil {
+		logging.Warning("Unknown data rate for uplink (%s). Using uplink settings for downlink: %v", ret.DataRate, err)
+		return ret
+	}
+	params, err := plan.GetRX1Parameters(ret.Channel, ret.Frequency, uplinkDataRate, settings.RX1DROffset)
+	if err != nil {
+		logging.Warning("Unable to get RX1 parameters for device %s. Using uplink settings for downlink: %v",
+			message.FrameContext.Device.DeviceEUI, err)
+		return ret
+	}
+	dataRate, err := band.DataRateIdentifier(plan, params.DataRate)
+	if err != nil {
+		logging.Warning("Unable to use data rate %d for downlink to device %s. Using uplink settings for downlink: %v",
+			params.DataRate, message.FrameContext.Device.DeviceEUI, err)
+		return ret
+	}
+	ret.DataRate = dataRate
+	ret.Frequency = params.Frequency
+	ret.TXPower = params.Power
+
+	// The RX1 frequency for the channel might be changed by a DlChannelReq
+	if keepSettings {
+		uplinkFrequency := message.FrameContext.GatewayContext.Radio.Frequency
+		if ch, ok := message.FrameContext.Device.Channels.Find(uplinkFrequency); ok && ch.Downlink != 0 {
+			ret.Frequency = ch.Downlink
+		}
+	}
+	return ret
+}
+
+// rx2Radio returns the radio settings for a downlink in the second receive
+// window of the device. The data rate and frequency are the device's RX2
+// settings or the band's defaults. Returns nil if the data rate can't be
+// used.
+func rx2Radio(message server.LoRaMessage) *server.RadioContext {
+	ret := message.FrameContext.GatewayContext.Radio
+	settings, _ := downlinkSettings(message, &ret)
+	ret.RXWindow = band.RX2
+	ret.RFChain = txRFChain
+
+	params := settings.RX2Parameters(ret.Band)
+	dataRate, err := band.DataRateIdentifier(ret.Band, params.DataRate)
+	if err != nil {
+		logging.Warning("Unable to use data rate %d for RX2 downlink to device %s: %v",
+			params.DataRate, message.FrameContext.Device.DeviceEUI, err)
+		return nil
+	}
+	ret.DataRate = dataRate
+	ret.Frequency = params.Frequency
+	ret.TXPower = params.Power
+	return &ret
+}
+
+// outgoingLatency returns the expected time from the scheduler starts
+// building a downlink until the gateway has received it. The estimate is
+// based on the measured time for the previous downlinks.
+func outgoingLatency() time.Duration {
+	return monitoring.TimeSchedulerSend.Average() + monitoring.TimeOutgoing.Average() + server.AssumedGatewayLatency
+}
+
+// selectWindow switches the downlink to the second receive window if the
+// gateway won't get it before the first receive window opens.
+func selectWindow(frameContext *server.FrameContext, rx2 *server.RadioContext) {
+	if rx2 == nil {
+		return
+	}
+	radio := frameContext.GatewayContext.Radio
+	remaining := time.Until(frameContext.GatewayContext.ReceivedAt.Add(radio.RXDelay()))
+	latency := outgoingLatency()
+	if remaining >= latency {
+		return
+	}
+	logging.Info("Missed RX1 for device %s (%v left, expected latency is %v). Using RX2.",
+		frameContext.Device.DeviceEUI, remaining, latency)
+	frameContext.GatewayContext.Radio = *rx2
 }
 
 // Get the message to be sent from the device aggregator
@@ -72,24 +202,111 @@ func (s *Scheduler) buildMessageToSend(device model.Device, frameContext server.
 	}, err
 }
 
-// sendAt sends a message at a specified time
+// airtime returns the time on air for a downlink message
+func airtime(message server.LoRaMessage) time.Duration {
+	radio := message.FrameContext.GatewayContext.Radio
+	dataRate, err := radio.Band.GetDataRate(radio.DataRate)
+	if err != nil {
+		return 0
+	}
+	encoding, err := radio.Band.Encoding(dataRate)
+	if err != nil {
+		return 0
+	}
+	// MHDR + MIC
+	length := 5
+	if message.Payload.MHDR.MType == protocol.JoinAccept {
+		length += 12
+		if !message.Payload.JoinAcceptPayload.CFList.Empty() {
+			length += 16
+		}
+		return encoding.TimeOnAir(length)
+	}
+	// FHDR + FPort + FRMPayload
+	mac := message.Payload.MACPayload
+	length += 7 + mac.FHDR.FOpts.Size()
+	if len(mac.FRMPayload) > 0 || mac.MACCommands.Size() > 0 {
+		length += 1 + len(mac.FRMPayload) + mac.MACCommands.Size()
+	}
+	return encoding.TimeOnAir(length)
+}
+
+// newTXFallback returns the alternatives for a class A downlink if the
+// gateway rejects it. The alternatives are the second receive window and the
+// other gateways that received the uplink.
+func newTXFallback(frameContext server.FrameContext, rx2 *server.RadioContext) *server.TXFallback {
+	ret := &server.TXFallback{DeviceEUI: frameContext.Device.DeviceEUI}
+	if frameContext.GatewayContext.Radio.RXWindow == band.RX1 {
+		ret.RX2 = rx2
+	}
+	for _, v := range frameContext.Receptions {
+		if v.Gateway.GatewayEUI != frameContext.GatewayContext.Gateway.GatewayEUI {
+			ret.Receptions = append(ret.Receptions, v)
+		}
+	}
+	return ret
+}
+
+// forward sends the message to the encoder
+func (s *Scheduler) forward(output chan<- server.LoRaMessage, payload server.LoRaMessage) {
+	payload.FrameContext.GatewayContext.OutTimer.Begin(monitoring.TimeOutgoing)
+	monitoring.Stopwatch(monitoring.SchedulerChannelOut, func() {
+		output <- payload
+	})
+}
+
+// selectGateway picks the gateway that sends a downlink in the receive
+// window. The receptions are sorted by link quality and the first gateway
+// that is idle when the receive window opens is used. The gateway in the
+// frame context (ie the best reception) is kept if all of them are busy.
+func (s *Scheduler) selectGateway(frameContext *server.FrameContext, duration time.Duration) {
+	rxDelay := frameContext.GatewayContext.Radio.RXDelay()
+	for _, v := range frameContext.Receptions {
+		if s.occupancy.busy(v.Gateway.GatewayEUI, v.ReceivedAt.Add(rxDelay), duration) {
+			continue
+		}
+		if v.Gateway.GatewayEUI != frameContext.GatewayContext.Gateway.GatewayEUI {
+			logging.Debug("Gateway %s is busy. Using gateway %s for downlink to device %s",
+				frameContext.GatewayContext.Gateway.GatewayEUI, v.Gateway.GatewayEUI, frameContext.Device.DeviceEUI)
+		}
+		frameContext.GatewayContext.Gateway = v.Gateway
+		frameContext.GatewayContext.ReceivedAt = v.ReceivedAt
+		frameContext.GatewayContext.GatewayTime = v.GatewayTime
+		return
+	}
+}
+
+// sendAt sends a message at a specified time. The message is sent in the
+// second receive window if there's not enough time left for the first.
 func (s *Scheduler) sendAt(delay time.Duration,
 	device model.Device,
 	output chan<- server.LoRaMessage,
 	frameContext server.FrameContext,
+	rx2 *server.RadioContext,
 	doneChannel chan protocol.EUI) {
 
 	select {
 	case <-time.After(delay):
+		selectWindow(&frameContext, rx2)
 		frameContext.GatewayContext.SectionTimer.Begin(monitoring.TimeSchedulerSend)
 		payload, err := s.buildMessageToSend(device, frameContext)
 		payload.FrameContext.GatewayContext.SectionTimer.End()
 		// If there's an error there's no data to send.
 		if err == nil {
-			payload.FrameContext.GatewayContext.OutTimer.Begin(monitoring.TimeOutgoing)
-			monitoring.Stopwatch(monitoring.SchedulerChannelOut, func() {
-				output <- payload
-			})
+			// The gateway sends the message when the receive window opens.
+			s.selectGateway(&payload.FrameContext, airtime(payload))
+			payload.FrameContext.GatewayContext.Fallback = newTXFallback(payload.FrameContext, rx2)
+			gateway := payload.FrameContext.GatewayContext.Gateway.GatewayEUI
+			txTime := payload.FrameContext.GatewayContext.ReceivedAt.Add(payload.FrameContext.GatewayContext.Radio.RXDelay())
+			if !s.occupancy.reserve(gateway, txTime, airtime(payload)) {
+				logging.Info("Gateway %s is busy when the receive window for device %s opens", gateway, device.DeviceEUI)
+			}
+			s.forward(output, payload)
+			if payload.FrameContext.GatewayContext.Radio.RXWindow == band.RX2 {
+				monitoring.DownlinkRX2.Increment()
+			} else {
+				monitoring.DownlinkRX1.Increment()
+			}
 		}
 		doneChannel <- device.DeviceEUI
 		monitoring.SchedulerOut.Increment()
@@ -102,6 +319,225 @@ func (s *Scheduler) sendAt(delay time.Duration,
 	}
 }
 
+// newDownlinkContext creates the frame context for a downlink that isn't a
+// response to an uplink. The downlink is sent through the gateway with the
+// best reception for the last uplink from the device. The caller sets the
+// radio parameters. Returns false if the downlink can't be sent.
+func (s *Scheduler) newDownlinkContext(device model.Device) (server.FrameContext, bool) {
+	sample, ok := s.context.UplinkHistory.Last(device.DeviceEUI)
+	if !ok || sample.Band == nil {
+		logging.Info("No gateway has received uplinks from class %s device %s. Can't send downlink.", device.Class, device.DeviceEUI)
+		return server.FrameContext{}, false
+	}
+	app, err := s.context.Storage.Application.GetByEUI(device.AppEUI, model.SystemUserID)
+	if err != nil {
+		logging.Warning("Unable to retrieve application %s for device %s: %v", device.AppEUI, device.DeviceEUI, err)
+		return server.FrameContext{}, false
+	}
+	return server.FrameContext{
+		Device:      device,
+		Application: app,
+		GatewayContext: server.GatewayPacket{
+			Radio: server.RadioContext{
+				Band: sample.Band,
+			},
+			Gateway:      sample.Gateway,
+			ReceivedAt:   time.Now(),
+			SectionTimer: monitoring.NewTimer(),
+			InTimer:      monitoring.NewTimer(),
+			OutTimer:     monitoring.NewTimer(),
+		},
+	}, true
+}
+
+// setDownlinkParameters sets the data rate, frequency and power for the
+// downlink.
+// Returns false if the data rate can't be used.
+func setDownlinkParameters(frameContext *server.FrameContext, params band.DownlinkParameters) bool {
+	dataRate, err := band.DataRateIdentifier(frameContext.GatewayContext.Radio.Band, params.DataRate)
+	if err != nil {
+		logging.Warning("Unable to use data rate %d for downlink to device %s: %v", params.DataRate, frameContext.Device.DeviceEUI, err)
+		return false
+	}
+	frameContext.GatewayContext.Radio.DataRate = dataRate
+	frameContext.GatewayContext.Radio.Frequency = params.Frequency
+	frameContext.GatewayContext.Radio.TXPower = params.Power
+	return true
+}
+
+// newClassCContext creates the frame context for a downlink to a class C
+// device. The downlink uses the frequency and data rate for the second
+// receive window [3.5]. Returns false if the downlink can't be sent.
+func (s *Scheduler) newClassCContext(device model.Device) (server.FrameContext, bool) {
+	ret, ok := s.newDownlinkContext(device)
+	if !ok || !setDownlinkParameters(&ret, device.RXSettings.RX2Parameters(ret.GatewayContext.Radio.Band)) {
+		return server.FrameContext{}, false
+	}
+	ret.GatewayContext.Immediate = true
+	return ret, true
+}
+
+// newClassBContext creates the frame context for a downlink in one of a class
+// B device's ping slots. The gateway must be GPS synchronized since the
+// downlink is sent at a fixed time [12]. Returns false if the downlink
+// can't be sent.
+func (s *Scheduler) newClassBContext(device model.Device, slot pingSlot) (server.FrameContext, bool) {
+	ret, ok := s.newDownlinkContext(device)
+	if !ok {
+		return server.FrameContext{}, false
+	}
+	gateway := ret.GatewayContext.Gateway.GatewayEUI
+	if !s.context.GPSGateways.Synchronized(gateway) {
+		logging.Info("Gateway %s isn't GPS synchronized. Can't send downlink to class B device %s.", gateway, device.DeviceEUI)
+		return server.FrameContext{}, false
+	}
+	beaconTime := uint32(protocol.GPSTime(slot.beacon) / time.Second)
+	if !setDownlinkParameters(&ret, device.PingSlot.Parameters(ret.GatewayContext.Radio.Band, beaconTime, device.DevAddr)) {
+		return server.FrameContext{}, false
+	}
+	ret.GatewayContext.TXTime = slot.start
+	return ret, true
+}
+
+// loadDownstream adds the device's scheduled downstream message to the frame
+// output. Returns false if there's no downstream message to send.
+func (s *Scheduler) loadDownstream(device model.Device) bool {
+	queue, err := s.context.Storage.DeviceData.ListDownstream(device.DeviceEUI)
+	if err != nil || len(queue) == 0 {
+		logging.Info("No downstream message for class %s device %s: %v", device.Class, device.DeviceEUI, err)
+		return false
+	}
+	application, err := s.context.Storage.Application.GetByEUI(device.AppEUI, model.SystemUserID)
+	if err != nil {
+		logging.Warning("Unable to retrieve application %s for device %s: %v", device.AppEUI, device.DeviceEUI, err)
+		return false
+	}
+	return prepareDownstream(s.context, device, application, queue)
+}
+
+// countDownlink updates the monitoring counters for a downlink
+func countDownlink(payload server.LoRaMessage) {
+	monitoring.SchedulerOut.Increment()
+	switch payload.Payload.MHDR.MType {
+	case protocol.ConfirmedDataDown:
+		monitoring.LoRaConfirmedDown.Increment()
+	case protocol.UnconfirmedDataDown:
+		monitoring.LoRaUnconfirmedDown.Increment()
+	}
+}
+
+// hasMoreDownlinks returns true if the next message in a class B or class C
+// device's queue can be sent right away. Confirmed messages stay in the queue
+// until the device acknowledges them in an uplink.
+func hasMoreDownlinks(payload server.LoRaMessage) bool {
+	return payload.Payload.MHDR.MType == protocol.UnconfirmedDataDown && payload.Payload.MACPayload.FHDR.FCtrl.FPending
+}
+
+// sendDownlink sends the scheduled downlink message to a class B or class C
+// device. Class C devices get the message as soon as possible while class B
+// devices get the message in the next ping slot.
+func (s *Scheduler) sendDownlink(deviceEUI protocol.EUI, output chan<- server.LoRaMessage, doneChannel chan protocol.EUI) {
+	more := false
+	defer func() {
+		doneChannel <- deviceEUI
+		if more {
+			s.context.Downlinks.Notify(deviceEUI)
+		}
+	}()
+
+	device, err := s.context.Storage.Device.GetByEUI(deviceEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve device %s: %v", deviceEUI, err)
+		return
+	}
+	switch device.Class {
+	case model.ClassB:
+		go s.waitForPingSlot(device, time.Now())
+	case model.ClassC:
+		more = s.sendClassC(device, output)
+	}
+}
+
+// sendClassC sends the scheduled downlink message to a class C device. The
+// message is sent as soon as the gateway's transmitter is idle. Returns true
+// if there are more messages to send.
+func (s *Scheduler) sendClassC(device model.Device, output chan<- server.LoRaMessage) bool {
+	frameContext, ok := s.newClassCContext(device)
+	if !ok || !s.loadDownstream(device) {
+		return false
+	}
+	frameContext.GatewayContext.Fallback = &server.TXFallback{DeviceEUI: device.DeviceEUI}
+
+	frameContext.GatewayContext.SectionTimer.Begin(monitoring.TimeSchedulerSend)
+	payload, err := s.buildMessageToSend(device, frameContext)
+	payload.FrameContext.GatewayContext.SectionTimer.End()
+	if err != nil {
+		return false
+	}
+	gateway := frameContext.GatewayContext.Gateway.GatewayEUI
+	start := s.occupancy.reserveFirstAvailable(gateway, time.Now(), airtime(payload))
+	if wait := time.Until(start); wait > 0 {
+		logging.Debug("Gateway %s is busy. Delaying downlink to class C device %s with %v", gateway, device.DeviceEUI, wait)
+		time.Sleep(wait)
+	}
+	s.forward(output, payload)
+	countDownlink(payload)
+	return hasMoreDownlinks(payload)
+}
+
+// waitForPingSlot waits until just before the device's first ping slot after
+// the time stamp and notifies the scheduler.
+func (s *Scheduler) waitForPingSlot(device model.Device, after time.Time) {
+	slot, err := nextPingSlot(device, after.Add(downlinkLeadTime))
+	if err != nil {
+		logging.Warning("Unable to calculate ping slot for device %s: %v", device.DeviceEUI, err)
+		return
+	}
+	time.Sleep(time.Until(slot.start.Add(-downlinkLeadTime)))
+	s.pingSlots <- slot
+}
+
+// sendClassB sends the scheduled downlink message to a class B device in the
+// ping slot. Ping slots are at a fixed time so the message is sent even if
+// the gateway is busy.
+func (s *Scheduler) sendClassB(slot pingSlot, output chan<- server.LoRaMessage, doneChannel chan protocol.EUI) {
+	more := false
+	defer func() {
+		doneChannel <- slot.deviceEUI
+		if more {
+			s.context.Downlinks.Notify(slot.deviceEUI)
+		}
+	}()
+
+	device, err := s.context.Storage.Device.GetByEUI(slot.deviceEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve device %s: %v", slot.deviceEUI, err)
+		return
+	}
+	if device.Class != model.ClassB {
+		return
+	}
+	frameContext, ok := s.newClassBContext(device, slot)
+	if !ok || !s.loadDownstream(device) {
+		return
+	}
+	frameContext.GatewayContext.Fallback = &server.TXFallback{DeviceEUI: device.DeviceEUI}
+
+	frameContext.GatewayContext.SectionTimer.Begin(monitoring.TimeSchedulerSend)
+	payload, err := s.buildMessageToSend(device, frameContext)
+	payload.FrameContext.GatewayContext.SectionTimer.End()
+	if err != nil {
+		return
+	}
+	gateway := frameContext.GatewayContext.Gateway.GatewayEUI
+	if !s.occupancy.reserve(gateway, slot.start, airtime(payload)) {
+		logging.Info("Gateway %s is busy in the ping slot for device %s", gateway, device.DeviceEUI)
+	}
+	s.forward(output, payload)
+	countDownlink(payload)
+	more = hasMoreDownlinks(payload)
+}
+
 // Start launches the scheduler. When the notifier channel is closed it will stop
 // and the output channel will be closed.
 func (s *Scheduler) Start() {
@@ -114,23 +550,43 @@ func (s *Scheduler) Start() {
 			}
 			message.FrameContext.GatewayContext.SectionTimer.Begin(monitoring.TimeSchedulerProcess)
 			device := message.FrameContext.Device
-			// Check if this message is already scheduled. If so - mark it as
-			// a duplicate and skip it. Messages sent within the same n milliseconds
-			// are assumed to be the same. This assumption is most likely wrong
-			// but other parts of the pipeline will have to more extensive
-			// duplicate/invalid data checks.
+			// Copies of the frame from other gateways are merged by the
+			// deduplicator. If a downlink is already scheduled this is a
+			// copy that arrived after the deduplication window or a new
+			// frame sent before the receive window opened.
 			if s.scheduled[device.DeviceEUI] {
-				logging.Info("Found duplicate message from device with EUI %s", device.DeviceEUI)
+				logging.Info("Downlink already scheduled for device with EUI %s. Ignoring frame", device.DeviceEUI)
 				continue
 			}
 
 			// this isn't a duplicate. Add it
 			s.scheduled[device.DeviceEUI] = true
+			rx2 := rx2Radio(message)
+			message.FrameContext.GatewayContext.Radio = downlinkRadio(message)
 			message.FrameContext.GatewayContext.SectionTimer.End()
 			message.FrameContext.GatewayContext.InTimer.End()
-			go s.sendAt(s.calculateRxDelay(message), device, s.output, message.FrameContext, s.completed)
+			go s.sendAt(s.calculateRxDelay(message), device, s.output, message.FrameContext, rx2, s.completed)
 			monitoring.SchedulerIn.Increment()
 
+		case eui := <-s.context.Downlinks.Notifications():
+			// Class A devices will get the message after the next uplink and
+			// if an uplink is being processed the message will be sent in the
+			// receive window for the uplink.
+			if s.scheduled[eui] {
+				continue
+			}
+			s.scheduled[eui] = true
+			go s.sendDownlink(eui, s.output, s.completed)
+
+		case slot := <-s.pingSlots:
+			// If a class A downlink is in progress it will include the
+			// message.
+			if s.scheduled[slot.deviceEUI] {
+				continue
+			}
+			s.scheduled[slot.deviceEUI] = true
+			go s.sendClassB(slot, s.output, s.completed)
+
 		case eui := <-s.completed:
 			// Message has been sent. Remove it from the map
 			delete(s.scheduled, eui)
@@ -147,11 +603,12 @@ func (s *Scheduler) Output() <-chan server.LoRaMessage {
 // NewScheduler creates a new scheduler.
 func NewScheduler(context *server.Context, commandNotifier <-chan server.LoRaMessage) *Scheduler {
 	return &Scheduler{
-		notifier:     commandNotifier,
-		output:       make(chan server.LoRaMessage),
-		context:      context,
-		completed:    make(chan protocol.EUI),
-		scheduled:    make(map[protocol.EUI]bool),
-		fixedRxDelay: DefaultRXDelay,
+		notifier:  commandNotifier,
+		output:    make(chan server.LoRaMessage),
+		context:   context,
+		completed: make(chan protocol.EUI),
+		scheduled: make(map[protocol.EUI]bool),
+		occupancy: newTXOccupancy(),
+		pingSlots: make(chan pingSlot),
 	}
 }
@@ -24,11 +24,14 @@ import (
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
 	"github.com/ExploratoryEngineering/logging"
 )
 
 var fo = server.NewFrameOutputBuffer()
 
+var euBand, _ = band.NewBand(band.EU868Band)
+
 var context = server.Context{
 	FrameOutput: &fo,
 }
@@ -36,7 +39,7 @@ var context = server.Context{
 var frameContext = server.FrameContext{
 	GatewayContext: server.GatewayPacket{
 		Radio: server.RadioContext{
-			Band:      band.EU868{},
+			Band:      euBand,
 			DataRate:  "SF10BW125",
 			Frequency: 868.1,
 		},
@@ -106,7 +109,7 @@ func newFrameContext(counter uint32) server.FrameContext {
 	return server.FrameContext{
 		GatewayContext: server.GatewayPacket{
 			Radio: server.RadioContext{
-				Band:      band.EU868{},
+				Band:      euBand,
 				DataRate:  "SF10BW125",
 				Frequency: 868.1,
 			},
@@ -198,3 +201,132 @@ func TestSchedulerDuplicate(t *testing.T) {
 		// OK
 	}
 }
+
+func TestSchedulerClassC(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	frameOutput := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	downlinks := server.NewDownlinkNotifier()
+	context := &server.Context{
+		Storage:       &store,
+		FrameOutput:   &frameOutput,
+		UplinkHistory: &history,
+		Downlinks:     &downlinks,
+		Config:        server.NewDefaultConfig(),
+	}
+
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(1)
+	store.Application.Put(app, model.SystemUserID)
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	device.Class = model.ClassC
+	store.Device.Put(device, app.AppEUI)
+	msg := model.NewDownstreamMessage(device.DeviceEUI, 10)
+	msg.Data = "010203"
+	store.DeviceData.PutDownstream(device.DeviceEUI, msg)
+
+	input := make(chan server.LoRaMessage)
+	scheduler := NewScheduler(context, input)
+	go scheduler.Start()
+	defer close(input)
+
+	// No uplinks from the device. The message can't be sent.
+	downlinks.Notify(device.DeviceEUI)
+	select {
+	case <-scheduler.Output():
+		t.Fatal("Did not expect downlink for device without uplinks")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	gateway := server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(3)}
+	history.Add(device.DeviceEUI, 1, server.GatewayPacket{
+		Radio:      server.RadioContext{Band: euBand, DataRate: "SF7BW125", Frequency: 868.3},
+		Gateway:    gateway,
+		ReceivedAt: time.Now(),
+	})
+	downlinks.Notify(device.DeviceEUI)
+	select {
+	case out := <-scheduler.Output():
+		radio := out.FrameContext.GatewayContext.Radio
+		if !out.FrameContext.GatewayContext.Immediate || out.FrameContext.GatewayContext.Gateway != gateway {
+			t.Fatalf("Expected immediate downlink through the gateway: %+v", out.FrameContext.GatewayContext)
+		}
+		if radio.Frequency != 869.525 || radio.DataRate != "SF12BW125" {
+			t.Fatalf("Expected downlink with RX2 parameters: %+v", radio)
+		}
+		if out.Payload.MACPayload.FPort != 10 || len(out.Payload.MACPayload.FRMPayload) != 3 {
+			t.Fatalf("Unexpected payload: %+v", out.Payload.MACPayload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not get downlink for class C device")
+	}
+}
+
+func TestSchedulerClassB(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	frameOutput := server.NewFrameOutputBuffer()
+	history := server.NewUplinkHistory(ADRHistoryLength)
+	downlinks := server.NewDownlinkNotifier()
+	gps := server.NewGPSGateways()
+	context := &server.Context{
+		Storage:       &store,
+		FrameOutput:   &frameOutput,
+		UplinkHistory: &history,
+		Downlinks:     &downlinks,
+		Config:        server.NewDefaultConfig(),
+		GPSGateways:   &gps,
+	}
+
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(1)
+	store.Application.Put(app, model.SystemUserID)
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(2)
+	device.DevAddr = protocol.DevAddr{NwkID: 1, NwkAddr: 2}
+	device.Class = model.ClassB
+	store.Device.Put(device, app.AppEUI)
+	msg := model.NewDownstreamMessage(device.DeviceEUI, 10)
+	msg.Data = "010203"
+	store.DeviceData.PutDownstream(device.DeviceEUI, msg)
+
+	input := make(chan server.LoRaMessage)
+	scheduler := NewScheduler(context, input)
+	go scheduler.Start()
+	defer close(input)
+
+	gateway := server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(3)}
+	history.Add(device.DeviceEUI, 1, server.GatewayPacket{
+		Radio:      server.RadioContext{Band: euBand, DataRate: "SF7BW125", Frequency: 868.3},
+		Gateway:    gateway,
+		ReceivedAt: time.Now(),
+	})
+
+	// The gateway isn't GPS synchronized. The message can't be sent.
+	device, _ = store.Device.GetByEUI(device.DeviceEUI)
+	if _, ok := scheduler.newClassBContext(device, pingSlot{deviceEUI: device.DeviceEUI}); ok {
+		t.Fatal("Did not expect class B downlink through gateway without GPS")
+	}
+
+	gps.Update(gateway, euBand)
+	downlinks.Notify(device.DeviceEUI)
+	select {
+	case out := <-scheduler.Output():
+		ctx := out.FrameContext.GatewayContext
+		if ctx.Immediate || ctx.TXTime.IsZero() || ctx.Gateway != gateway {
+			t.Fatalf("Expected downlink at a fixed time through the gateway: %+v", ctx)
+		}
+		expected, err := nextPingSlot(device, ctx.TXTime.Add(-time.Millisecond))
+		if err != nil || !expected.start.Equal(ctx.TXTime) {
+			t.Fatalf("Downlink isn't sent in a ping slot: %v", ctx.TXTime)
+		}
+		if ctx.Radio.Frequency != 869.525 || ctx.Radio.DataRate != "SF9BW125" {
+			t.Fatalf("Expected downlink with default ping slot parameters: %+v", ctx.Radio)
+		}
+		if out.Payload.MACPayload.FPort != 10 || len(out.Payload.MACPayload.FRMPayload) != 3 {
+			t.Fatalf("Unexpected payload: %+v", out.Payload.MACPayload)
+		}
+	case <-time.After(8 * time.Second):
+		t.Fatal("Did not get downlink for class B device")
+	}
+}
@@ -0,0 +1,175 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/gateway"
+	"github.com/ExploratoryEngineering/congress/monitoring"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// TXAckHandler retries downlinks that the gateways have rejected. Class A
+// downlinks are sent in the second receive window or through one of the
+// other gateways that received the uplink. If the downlink can't be sent the
+// failure is recorded on the device's downstream message and the message is
+// sent again later.
+type TXAckHandler struct {
+	context   *server.Context
+	occupancy *txOccupancy
+	output    chan<- server.GatewayPacket
+	terminate chan bool
+}
+
+// NewTXAckHandler creates a new TX ack handler. The retries are sent on the
+// output channel and are registered in the scheduler's transmitter occupancy.
+func NewTXAckHandler(context *server.Context, scheduler *Scheduler, output chan<- server.GatewayPacket) *TXAckHandler {
+	return &TXAckHandler{
+		context:   context,
+		occupancy: scheduler.occupancy,
+		output:    output,
+		terminate: make(chan bool),
+	}
+}
+
+// Start launches the TX ack handler. It runs until Stop is called.
+func (h *TXAckHandler) Start() {
+	for {
+		select {
+		case ack := <-h.context.TXAcks.Acks():
+			h.processAck(ack)
+		case <-h.terminate:
+			logging.Debug("TX ack handler terminated")
+			return
+		}
+	}
+}
+
+// Stop stops the TX ack handler.
+func (h *TXAckHandler) Stop() {
+	h.terminate <- true
+}
+
+// processAck retries the rejected downlink. If it can't be retried the
+// failure is recorded on the downstream message for the device.
+func (h *TXAckHandler) processAck(ack server.TXAck) {
+	fallback := ack.Packet.Fallback
+	if fallback == nil || len(ack.Packet.RawMessage) == 0 {
+		return
+	}
+	if h.retry(ack) {
+		return
+	}
+	logging.Warning("Unable to send downlink to device %s (%s). No alternatives left.", fallback.DeviceEUI, ack.Error)
+	if fallback.Downstream == nil {
+		return
+	}
+	if err := h.context.Storage.DeviceData.FailDownstream(fallback.DeviceEUI, fallback.Downstream.ID, ack.Error); err != nil && err != storage.ErrNotFound {
+		logging.Warning("Unable to update downstream message for device %s: %v", fallback.DeviceEUI, err)
+	}
+}
+
+// alternatives returns the alternatives for a rejected downlink in the order
+// they should be tried. The second receive window is preferred if the
+// gateway can't use the timing, frequency or power for the first window.
+// Other errors means the gateway is busy and the other gateways are tried
+// first.
+func alternatives(ack server.TXAck) []server.GatewayPacket {
+	packet := ack.Packet
+	fallback := packet.Fallback
+
+	var rx2, others []server.GatewayPacket
+	if fallback.RX2 != nil {
+		p := packet
+		p.Radio = *fallback.RX2
+		p.Fallback = &server.TXFallback{DeviceEUI: fallback.DeviceEUI, Receptions: fallback.Receptions, Downstream: fallback.Downstream}
+		rx2 = append(rx2, p)
+	}
+	for i, v := range fallback.Receptions {
+		p := packet
+		p.Gateway = v.Gateway
+		p.ReceivedAt = v.ReceivedAt
+		p.GatewayTime = v.GatewayTime
+		remaining := append([]server.GatewayPacket{}, fallback.Receptions[:i]...)
+		remaining = append(remaining, fallback.Receptions[i+1:]...)
+		p.Fallback = &server.TXFallback{DeviceEUI: fallback.DeviceEUI, RX2: fallback.RX2, Receptions: remaining, Downstream: fallback.Downstream}
+		others = append(others, p)
+	}
+
+	switch ack.Error {
+	case gateway.TxAckTooLate, gateway.TxAckTxFreq, gateway.TxAckTxPower:
+		return append(rx2, others...)
+	default:
+		return append(others, rx2...)
+	}
+}
+
+// packetAirtime returns the time on air for a packet. The packet is
+// discarded if it is too large for the data rate.
+func packetAirtime(packet server.GatewayPacket) (time.Duration, bool) {
+	plan := packet.Radio.Band
+	if plan == nil {
+		return 0, false
+	}
+	dataRate, err := plan.GetDataRate(packet.Radio.DataRate)
+	if err != nil {
+		return 0, false
+	}
+	// The MHDR and MIC isn't a part of the MAC payload
+	maxSize, err := plan.MaximumPayload(packet.Radio.DataRate)
+	if err != nil || len(packet.RawMessage)-5 > int(maxSize.M) {
+		return 0, false
+	}
+	encoding, err := plan.Encoding(dataRate)
+	if err != nil {
+		return 0, false
+	}
+	return encoding.TimeOnAir(len(packet.RawMessage)), true
+}
+
+// retry sends the first alternative that can be used. Returns false if none
+// of the alternatives can be used.
+func (h *TXAckHandler) retry(ack server.TXAck) bool {
+	for _, p := range alternatives(ack) {
+		txTime := p.ReceivedAt.Add(p.Radio.RXDelay())
+		if time.Until(txTime) < outgoingLatency() {
+			continue
+		}
+		airtime, ok := packetAirtime(p)
+		if !ok {
+			continue
+		}
+		gatewayEUI := p.Gateway.GatewayEUI
+		if !h.occupancy.reserve(gatewayEUI, txTime, airtime) {
+			continue
+		}
+		logging.Info("Gateway %s rejected downlink to device %s (%s). Retrying on gateway %s in RX%d",
+			ack.Packet.Gateway.GatewayEUI, p.Fallback.DeviceEUI, ack.Error, gatewayEUI, rxWindowNumber(p.Radio.RXWindow))
+		if p.Fallback.Downstream != nil && p.Radio.RXWindow != ack.Packet.Radio.RXWindow {
+			downstreamWindow(h.context, p.Fallback.Downstream, p.Radio.RXWindow)
+		}
+		p.Deadline = p.Radio.RXDelay().Seconds()
+		p.SectionTimer = monitoring.NewTimer()
+		p.OutTimer = monitoring.NewTimer()
+		p.OutTimer.Begin(monitoring.TimeOutgoing)
+		h.output <- p
+		return true
+	}
+	return false
+}
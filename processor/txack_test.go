@@ -0,0 +1,123 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/gateway"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+func TestTXAckAlternatives(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	gw1 := server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(1)}
+	gw2 := server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(2)}
+	rx2 := &server.RadioContext{Band: eu, DataRate: "SF12BW125", Frequency: 869.525, RXWindow: band.RX2}
+	ack := server.TXAck{
+		Packet: server.GatewayPacket{
+			Radio:    server.RadioContext{Band: eu, DataRate: "SF7BW125", Frequency: 868.1},
+			Gateway:  gw1,
+			Fallback: &server.TXFallback{RX2: rx2, Receptions: []server.GatewayPacket{{Gateway: gw2}}},
+		},
+		Error: gateway.TxAckTooLate,
+	}
+
+	// Too late for RX1 tries RX2 first
+	alt := alternatives(ack)
+	if len(alt) != 2 || alt[0].Radio.RXWindow != band.RX2 || alt[0].Gateway != gw1 || alt[1].Gateway != gw2 {
+		t.Fatalf("Unexpected alternatives for %s: %+v", ack.Error, alt)
+	}
+	if alt[0].Fallback.RX2 != nil || len(alt[0].Fallback.Receptions) != 1 {
+		t.Fatalf("Unexpected fallback for RX2: %+v", alt[0].Fallback)
+	}
+	if alt[1].Fallback.RX2 != rx2 || len(alt[1].Fallback.Receptions) != 0 {
+		t.Fatalf("Unexpected fallback for other gateway: %+v", alt[1].Fallback)
+	}
+
+	// Collisions try the other gateways first
+	ack.Error = gateway.TxAckCollisionPacket
+	alt = alternatives(ack)
+	if len(alt) != 2 || alt[0].Gateway != gw2 || alt[0].Radio.RXWindow != band.RX1 || alt[1].Radio.RXWindow != band.RX2 {
+		t.Fatalf("Unexpected alternatives for %s: %+v", ack.Error, alt)
+	}
+}
+
+func TestTXAckHandler(t *testing.T) {
+	eu, _ := band.NewBand(band.EU868Band)
+	store := memstore.CreateMemoryStorage(0, 0)
+	txAcks := server.NewTXAckNotifier()
+	context := &server.Context{Storage: &store, TXAcks: &txAcks}
+	output := make(chan server.GatewayPacket)
+	handler := NewTXAckHandler(context, NewScheduler(context, nil), output)
+	go handler.Start()
+	defer handler.Stop()
+
+	device := model.NewDevice()
+	device.DeviceEUI = protocol.EUIFromUint64(0x100)
+	msg := model.NewDownstreamMessage(device.DeviceEUI, 1)
+	msg.SentTime = time.Now().Unix()
+	store.DeviceData.PutDownstream(device.DeviceEUI, msg)
+
+	payload := protocol.NewPHYPayload(protocol.UnconfirmedDataDown)
+	raw := []byte{byte(payload.MHDR.MType) << 5, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	gw1 := server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(1)}
+	rx2 := &server.RadioContext{Band: eu, DataRate: "SF12BW125", Frequency: 869.525, RX1Delay: 1, RX2Delay: 2, RXWindow: band.RX2}
+	txAcks.Notify(server.TXAck{
+		Packet: server.GatewayPacket{
+			RawMessage: raw,
+			Radio:      server.RadioContext{Band: eu, DataRate: "SF7BW125", Frequency: 868.1, RX1Delay: 1, RX2Delay: 2},
+			Gateway:    gw1,
+			ReceivedAt: time.Now(),
+			Fallback:   &server.TXFallback{DeviceEUI: device.DeviceEUI, RX2: rx2, Downstream: &msg},
+		},
+		Error: gateway.TxAckTooLate,
+	})
+
+	// The downlink is sent in RX2
+	var retry server.GatewayPacket
+	select {
+	case retry = <-output:
+		if retry.Radio.RXWindow != band.RX2 || retry.Radio.Frequency != 869.525 || retry.Gateway != gw1 || retry.Deadline != 2 {
+			t.Fatalf("Unexpected retry: %+v", retry)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected retry in RX2")
+	}
+	if stored, _ := store.DeviceData.GetDownstream(device.DeviceEUI); stored.RXWindow != 2 {
+		t.Fatalf("Expected the message to be sent in RX2: %+v", stored)
+	}
+
+	// The RX2 downlink is rejected as well. The failure is recorded
+	txAcks.Notify(server.TXAck{Packet: retry, Error: gateway.TxAckCollisionPacket})
+	select {
+	case p := <-output:
+		t.Fatalf("Did not expect another retry: %+v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+	stored, err := store.DeviceData.GetDownstream(device.DeviceEUI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State() != model.UnsentState || stored.TXError != gateway.TxAckCollisionPacket || stored.RXWindow != 0 {
+		t.Fatalf("Expected failure to be recorded: %+v", stored)
+	}
+}
@@ -0,0 +1,116 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// txSlot is a transmission scheduled on a gateway
+type txSlot struct {
+	start time.Time
+	end   time.Time
+}
+
+// overlaps returns true if the slot overlaps the time between start and end
+func (t txSlot) overlaps(start, end time.Time) bool {
+	return start.Before(t.end) && end.After(t.start)
+}
+
+// txOccupancy keeps track of when the gateways are transmitting. Class A
+// downlinks must be sent at a fixed time while class C downlinks can be moved
+// to a time when the gateway is idle.
+type txOccupancy struct {
+	slots map[protocol.EUI][]txSlot
+	mutex *sync.Mutex
+}
+
+func newTXOccupancy() *txOccupancy {
+	return &txOccupancy{
+		slots: make(map[protocol.EUI][]txSlot),
+		mutex: &sync.Mutex{},
+	}
+}
+
+// removeExpired removes the slots that have ended. The mutex must be locked.
+func (t *txOccupancy) removeExpired(gatewayEUI protocol.EUI, now time.Time) []txSlot {
+	var ret []txSlot
+	for _, slot := range t.slots[gatewayEUI] {
+		if slot.end.After(now) {
+			ret = append(ret, slot)
+		}
+	}
+	return ret
+}
+
+// reserve reserves the gateway's transmitter at a fixed time. The slot is
+// reserved even if the transmitter is busy. Returns false if the slot
+// overlaps another transmission.
+func (t *txOccupancy) reserve(gatewayEUI protocol.EUI, start time.Time, duration time.Duration) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	slots := t.removeExpired(gatewayEUI, time.Now())
+	end := start.Add(duration)
+	available := true
+	for _, slot := range slots {
+		if slot.overlaps(start, end) {
+			available = false
+			break
+		}
+	}
+	t.slots[gatewayEUI] = append(slots, txSlot{start, end})
+	return available
+}
+
+// busy returns true if the gateway's transmitter is busy at some point
+// between the start time and the end of the transmission.
+func (t *txOccupancy) busy(gatewayEUI protocol.EUI, start time.Time, duration time.Duration) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	end := start.Add(duration)
+	for _, slot := range t.slots[gatewayEUI] {
+		if slot.overlaps(start, end) {
+			return true
+		}
+	}
+	return false
+}
+
+// reserveFirstAvailable reserves the first slot at or after the start time
+// where the transmitter is idle. The start time of the reservation is
+// returned.
+func (t *txOccupancy) reserveFirstAvailable(gatewayEUI protocol.EUI, start time.Time, duration time.Duration) time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	slots := t.removeExpired(gatewayEUI, time.Now())
+	for moved := true; moved; {
+		moved = false
+		for _, slot := range slots {
+			if slot.overlaps(start, start.Add(duration)) {
+				start = slot.end
+				moved = true
+			}
+		}
+	}
+	t.slots[gatewayEUI] = append(slots, txSlot{start, start.Add(duration)})
+	return start
+}
@@ -0,0 +1,91 @@
+package processor
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
+)
+
+func TestTXOccupancy(t *testing.T) {
+	occupancy := newTXOccupancy()
+	gw1 := protocol.EUIFromUint64(1)
+	gw2 := protocol.EUIFromUint64(2)
+
+	now := time.Now()
+	if !occupancy.reserve(gw1, now.Add(time.Second), 100*time.Millisecond) {
+		t.Fatal("Expected the gateway to be idle")
+	}
+	if occupancy.reserve(gw1, now.Add(time.Second+50*time.Millisecond), 100*time.Millisecond) {
+		t.Fatal("Expected overlapping slot to fail")
+	}
+	if !occupancy.reserve(gw2, now.Add(time.Second), 100*time.Millisecond) {
+		t.Fatal("Expected other gateway to be idle")
+	}
+
+	// Slots before the reservations are available
+	if start := occupancy.reserveFirstAvailable(gw1, now, 500*time.Millisecond); !start.Equal(now) {
+		t.Fatalf("Expected slot at %v but got %v", now, start)
+	}
+	// ...but the next one is moved after the class A slots
+	start := occupancy.reserveFirstAvailable(gw1, now.Add(400*time.Millisecond), 600*time.Millisecond)
+	if expected := now.Add(time.Second + 150*time.Millisecond); !start.Equal(expected) {
+		t.Fatalf("Expected slot to be moved to %v but got %v", expected, start)
+	}
+}
+
+func TestTXOccupancyBusy(t *testing.T) {
+	occupancy := newTXOccupancy()
+	gw := protocol.EUIFromUint64(1)
+
+	now := time.Now()
+	occupancy.reserve(gw, now.Add(time.Second), 100*time.Millisecond)
+	if !occupancy.busy(gw, now.Add(time.Second+50*time.Millisecond), 10*time.Millisecond) {
+		t.Fatal("Expected gateway to be busy")
+	}
+	if occupancy.busy(gw, now, 500*time.Millisecond) {
+		t.Fatal("Expected gateway to be idle before the reservation")
+	}
+	if occupancy.busy(protocol.EUIFromUint64(2), now.Add(time.Second), time.Second) {
+		t.Fatal("Expected other gateway to be idle")
+	}
+}
+
+func TestSelectGateway(t *testing.T) {
+	context := server.Context{}
+	scheduler := NewScheduler(&context, nil)
+
+	now := time.Now()
+	best := server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(1)}, ReceivedAt: now}
+	other := server.GatewayPacket{Gateway: server.GatewayContext{GatewayEUI: protocol.EUIFromUint64(2)}, ReceivedAt: now}
+	frameContext := server.FrameContext{GatewayContext: best, Receptions: []server.GatewayPacket{best, other}}
+	frameContext.GatewayContext.Radio.RX1Delay = 1
+
+	scheduler.selectGateway(&frameContext, 100*time.Millisecond)
+	if frameContext.GatewayContext.Gateway.GatewayEUI != best.Gateway.GatewayEUI {
+		t.Fatal("Expected the best gateway to be used when it is idle")
+	}
+
+	// The next gateway is used when the best gateway is busy
+	scheduler.occupancy.reserve(best.Gateway.GatewayEUI, now.Add(time.Second), 200*time.Millisecond)
+	scheduler.selectGateway(&frameContext, 100*time.Millisecond)
+	if frameContext.GatewayContext.Gateway.GatewayEUI != other.Gateway.GatewayEUI {
+		t.Fatal("Expected the other gateway to be used when the best gateway is busy")
+	}
+}
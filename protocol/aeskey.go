@@ -18,6 +18,7 @@ package protocol
 import (
 	"crypto/aes"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"strings"
 )
@@ -77,6 +78,73 @@ func keyFromNonce(appKey AESKey, prefix byte, appNonce [3]byte, netID uint32, de
 	return ret, nil
 }
 
+// FNwkSIntKeyFromJoinNonce generates the forwarding network session integrity key
+// for LoRaWAN 1.1 devices. The key is derived from the network key [6.2.3]
+func FNwkSIntKeyFromJoinNonce(nwkKey AESKey, joinNonce [3]byte, joinEUI EUI, devNonce uint16) (AESKey, error) {
+	return keyFromJoinNonce(nwkKey, 0x01, joinNonce, joinEUI, devNonce)
+}
+
+// AppSKeyFromJoinNonce generates the application session key for LoRaWAN 1.1
+// devices. The key is derived from the application key [6.2.3]
+func AppSKeyFromJoinNonce(appKey AESKey, joinNonce [3]byte, joinEUI EUI, devNonce uint16) (AESKey, error) {
+	return keyFromJoinNonce(appKey, 0x02, joinNonce, joinEUI, devNonce)
+}
+
+// SNwkSIntKeyFromJoinNonce generates the serving network session integrity key
+// for LoRaWAN 1.1 devices. The key is derived from the network key [6.2.3]
+func SNwkSIntKeyFromJoinNonce(nwkKey AESKey, joinNonce [3]byte, joinEUI EUI, devNonce uint16) (AESKey, error) {
+	return keyFromJoinNonce(nwkKey, 0x03, joinNonce, joinEUI, devNonce)
+}
+
+// NwkSEncKeyFromJoinNonce generates the network session encryption key for
+// LoRaWAN 1.1 devices. The key is derived from the network key [6.2.3]
+func NwkSEncKeyFromJoinNonce(nwkKey AESKey, joinNonce [3]byte, joinEUI EUI, devNonce uint16) (AESKey, error) {
+	return keyFromJoinNonce(nwkKey, 0x04, joinNonce, joinEUI, devNonce)
+}
+
+// JSEncKeyFromDevEUI generates the key used to encrypt JoinAccept messages
+// triggered by rejoin requests for LoRaWAN 1.1 devices [6.1.1.3]
+func JSEncKeyFromDevEUI(nwkKey AESKey, devEUI EUI) (AESKey, error) {
+	return keyFromDevEUI(nwkKey, 0x05, devEUI)
+}
+
+// JSIntKeyFromDevEUI generates the key used to calculate the JoinAccept MIC for
+// LoRaWAN 1.1 devices [6.1.1.3]
+func JSIntKeyFromDevEUI(nwkKey AESKey, devEUI EUI) (AESKey, error) {
+	return keyFromDevEUI(nwkKey, 0x06, devEUI)
+}
+
+// keyFromJoinNonce derives a LoRaWAN 1.1 session key. The fields use the same
+// byte order as the JoinRequest and JoinAccept messages.
+func keyFromJoinNonce(rootKey AESKey, prefix byte, joinNonce [3]byte, joinEUI EUI, devNonce uint16) (AESKey, error) {
+	buffer := make([]byte, 16)
+	buffer[0] = prefix
+	copy(buffer[1:], joinNonce[:])
+	binary.LittleEndian.PutUint64(buffer[4:], joinEUI.ToUint64())
+	binary.BigEndian.PutUint16(buffer[12:], devNonce)
+	return encryptBlock(rootKey, buffer)
+}
+
+// keyFromDevEUI derives the LoRaWAN 1.1 join server keys
+func keyFromDevEUI(nwkKey AESKey, prefix byte, devEUI EUI) (AESKey, error) {
+	buffer := make([]byte, 16)
+	buffer[0] = prefix
+	binary.LittleEndian.PutUint64(buffer[1:], devEUI.ToUint64())
+	return encryptBlock(nwkKey, buffer)
+}
+
+// encryptBlock encrypts a single block with the key and returns it as a new
+// key.
+func encryptBlock(key AESKey, buffer []byte) (AESKey, error) {
+	aesCipher, err := aes.NewCipher(key.Key[:])
+	if err != nil {
+		return AESKey{}, err
+	}
+	ret := AESKey{}
+	aesCipher.Encrypt(ret.Key[:], buffer)
+	return ret, nil
+}
+
 // NewAESKey creates a new AES key from the secure random generator
 func NewAESKey() (AESKey, error) {
 	ret := AESKey{}
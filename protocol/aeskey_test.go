@@ -15,7 +15,10 @@ package protocol
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
-import "testing"
+import (
+	"crypto/aes"
+	"testing"
+)
 
 func TestInvalidStrings(t *testing.T) {
 	// Empty string
@@ -96,6 +99,46 @@ func TestAppSKeyFromNonces(t *testing.T) {
 	}
 }
 
+func TestLoRaWAN11Keys(t *testing.T) {
+	joinNonce := [3]byte{1, 2, 3}
+	joinEUI := EUIFromUint64(0x0102030405060708)
+	devEUI := EUIFromUint64(0x1112131415161718)
+	devNonce := uint16(0xabcd)
+	nwkKey, _ := AESKeyFromString("0102-0304-0506-0708-0102-0304-0506-0708")
+
+	keys := make(map[AESKey]string)
+	addKey := func(name string, key AESKey, err error) {
+		if err != nil {
+			t.Fatalf("Got error generating %s: %v", name, err)
+		}
+		if other, exists := keys[key]; exists || key == nwkKey {
+			t.Fatalf("%s is the same as %s", name, other)
+		}
+		keys[key] = name
+	}
+	key, err := FNwkSIntKeyFromJoinNonce(nwkKey, joinNonce, joinEUI, devNonce)
+	addKey("FNwkSIntKey", key, err)
+	key, err = SNwkSIntKeyFromJoinNonce(nwkKey, joinNonce, joinEUI, devNonce)
+	addKey("SNwkSIntKey", key, err)
+	key, err = NwkSEncKeyFromJoinNonce(nwkKey, joinNonce, joinEUI, devNonce)
+	addKey("NwkSEncKey", key, err)
+	key, err = AppSKeyFromJoinNonce(nwkKey, joinNonce, joinEUI, devNonce)
+	addKey("AppSKey", key, err)
+	key, err = JSIntKeyFromDevEUI(nwkKey, devEUI)
+	addKey("JSIntKey", key, err)
+	key, err = JSEncKeyFromDevEUI(nwkKey, devEUI)
+	addKey("JSEncKey", key, err)
+
+	// The key is the encrypted block: prefix | JoinNonce | JoinEUI | DevNonce | pad
+	block := []byte{0x01, 1, 2, 3, 8, 7, 6, 5, 4, 3, 2, 1, 0xab, 0xcd, 0, 0}
+	cipher, _ := aes.NewCipher(nwkKey.Key[:])
+	expected := AESKey{}
+	cipher.Encrypt(expected.Key[:], block)
+	if key, _ := FNwkSIntKeyFromJoinNonce(nwkKey, joinNonce, joinEUI, devNonce); key != expected {
+		t.Fatalf("Unexpected FNwkSIntKey: %s (expected %s)", key, expected)
+	}
+}
+
 func TestNewAESKey(t *testing.T) {
 	if _, err := NewAESKey(); err != nil {
 		t.Fatal("Got error creating key. Shouldn't get that.")
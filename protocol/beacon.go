@@ -0,0 +1,169 @@
+package protocol
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Class B timing constants [15]
+const (
+	// BeaconPeriod is the interval between beacons
+	BeaconPeriod = 128 * time.Second
+	// BeaconReserved is the time reserved for the beacon at the start of each period
+	BeaconReserved = 2120 * time.Millisecond
+	// BeaconGuard is the time before each beacon when no ping slots can be used
+	BeaconGuard = 3 * time.Second
+	// PingSlotLength is the length of a single ping slot
+	PingSlotLength = 30 * time.Millisecond
+	// MaxPingPeriodicity is the largest periodicity a device can request. A
+	// periodicity of 7 means one ping slot every 128 seconds.
+	MaxPingPeriodicity = 7
+)
+
+// gpsLeapSeconds is the number of leap seconds GPS time is ahead of UTC. This
+// must be updated whenever a new leap second is introduced.
+const gpsLeapSeconds = 18 * time.Second
+
+// GPSEpoch is the start of GPS time
+var GPSEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+// GPSTime returns the time since the GPS epoch for a UTC time stamp
+func GPSTime(t time.Time) time.Duration {
+	return t.Sub(GPSEpoch) + gpsLeapSeconds
+}
+
+// TimeFromGPS converts a GPS time into a time stamp
+func TimeFromGPS(gpsTime time.Duration) time.Time {
+	return GPSEpoch.Add(gpsTime - gpsLeapSeconds).UTC()
+}
+
+// NextBeacon returns the time of the first beacon after the time stamp. The
+// beacons are sent whenever the GPS time is a multiple of the beacon period.
+func NextBeacon(t time.Time) time.Time {
+	gps := GPSTime(t)
+	return TimeFromGPS(gps - gps%BeaconPeriod + BeaconPeriod)
+}
+
+// Beacon is the class B beacon frame sent by GPS-synchronized gateways. The
+// frame consists of a network common part with the time and a gateway
+// specific part with the gateway's location [15.2]
+type Beacon struct {
+	Time      uint32  // GPS time in seconds (modulo 2^32)
+	Latitude  float32 // Latitude of gateway
+	Longitude float32 // Longitude of gateway
+}
+
+// beaconCRC calculates the CRC-16/CCITT checksum used by the beacon frame
+func beaconCRC(buffer []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range buffer {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// putCoordinate writes a coordinate as a 24-bit signed integer scaled to the range
+func putCoordinate(buffer []byte, value float32, scale float64) {
+	v := int32(math.Round(float64(value) / scale * float64(1<<23)))
+	if v > (1<<23)-1 {
+		v = (1 << 23) - 1
+	}
+	if v < -(1 << 23) {
+		v = -(1 << 23)
+	}
+	buffer[0] = byte(v)
+	buffer[1] = byte(v >> 8)
+	buffer[2] = byte(v >> 16)
+}
+
+// Encode encodes the beacon frame. The number of RFU bytes in the network
+// common part and the gateway specific part depends on the band.
+func (b *Beacon) Encode(commonRFU, gatewayRFU int) ([]byte, error) {
+	if commonRFU < 0 || gatewayRFU < 0 {
+		return nil, ErrParameterOutOfRange
+	}
+	buffer := make([]byte, commonRFU+6+7+gatewayRFU+2)
+	pos := commonRFU
+	binary.LittleEndian.PutUint32(buffer[pos:], b.Time)
+	pos += 4
+	binary.LittleEndian.PutUint16(buffer[pos:], beaconCRC(buffer[:pos]))
+	pos += 2
+
+	gwStart := pos
+	// InfoDesc = 0 means the location is the first antenna's location
+	buffer[pos] = 0
+	pos++
+	putCoordinate(buffer[pos:], b.Latitude, 90)
+	pos += 3
+	putCoordinate(buffer[pos:], b.Longitude, 180)
+	pos += 3
+	pos += gatewayRFU
+	binary.LittleEndian.PutUint16(buffer[pos:], beaconCRC(buffer[gwStart:pos]))
+	return buffer, nil
+}
+
+// PingPeriod returns the number of slots between each ping slot for the
+// periodicity [13.2]
+func PingPeriod(periodicity uint8) int {
+	return 1 << (5 + uint(periodicity&MaxPingPeriodicity))
+}
+
+// PingOffset calculates the (randomized) offset of the first ping slot in
+// a beacon period. The offset is in number of slots after the beacon
+// reserved window [13.2]
+func PingOffset(beaconTime uint32, devAddr DevAddr, periodicity uint8) (int, error) {
+	if periodicity > MaxPingPeriodicity {
+		return 0, ErrParameterOutOfRange
+	}
+	key := make([]byte, 16)
+	aesCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return 0, ErrCryptoError
+	}
+	buffer := make([]byte, 16)
+	binary.LittleEndian.PutUint32(buffer[0:], beaconTime)
+	binary.LittleEndian.PutUint32(buffer[4:], devAddr.ToUint32())
+	rand := make([]byte, 16)
+	aesCipher.Encrypt(rand, buffer)
+	return (int(rand[0]) + int(rand[1])*256) % PingPeriod(periodicity), nil
+}
+
+// PingSlots returns the start of all of the device's ping slots in the beacon
+// period that starts at beaconStart.
+func PingSlots(beaconStart time.Time, devAddr DevAddr, periodicity uint8) ([]time.Time, error) {
+	beaconTime := uint32(GPSTime(beaconStart) / time.Second)
+	offset, err := PingOffset(beaconTime, devAddr, periodicity)
+	if err != nil {
+		return nil, err
+	}
+	period := PingPeriod(periodicity)
+	var ret []time.Time
+	for slot := offset; slot < 4096; slot += period {
+		ret = append(ret, beaconStart.Add(BeaconReserved+time.Duration(slot)*PingSlotLength))
+	}
+	return ret, nil
+}
@@ -0,0 +1,121 @@
+package protocol
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestGPSTime(t *testing.T) {
+	ts := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	gps := GPSTime(ts)
+	if gps != 1261872018*time.Second {
+		t.Fatalf("Incorrect GPS time: %d", gps/time.Second)
+	}
+	if !TimeFromGPS(gps).Equal(ts) {
+		t.Fatalf("Conversion back to time failed: %v", TimeFromGPS(gps))
+	}
+
+	next := NextBeacon(ts)
+	if GPSTime(next)%BeaconPeriod != 0 {
+		t.Fatalf("Next beacon isn't on a beacon period boundary: %v", next)
+	}
+	if !next.After(ts) || next.Sub(ts) > BeaconPeriod {
+		t.Fatalf("Next beacon is outside the beacon period: %v", next)
+	}
+	if !NextBeacon(next).Equal(next.Add(BeaconPeriod)) {
+		t.Fatalf("Beacon on boundary should return the next period")
+	}
+}
+
+func TestBeaconCRC(t *testing.T) {
+	if crc := beaconCRC([]byte("123456789")); crc != 0x31C3 {
+		t.Fatalf("Incorrect CRC: %04x", crc)
+	}
+}
+
+func TestBeaconEncode(t *testing.T) {
+	b := Beacon{Time: 0x01020304, Latitude: 45, Longitude: -90}
+
+	buf, err := b.Encode(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != 17 {
+		t.Fatalf("EU beacon should be 17 bytes but is %d", len(buf))
+	}
+	if binary.LittleEndian.Uint32(buf[2:]) != b.Time {
+		t.Fatalf("Time field is incorrect: %v", buf)
+	}
+	if binary.LittleEndian.Uint16(buf[6:]) != beaconCRC(buf[0:6]) {
+		t.Fatal("Common CRC is incorrect")
+	}
+	if buf[8] != 0x00 {
+		t.Fatalf("InfoDesc is incorrect: %v", buf[8])
+	}
+	if buf[9] != 0x00 || buf[10] != 0x00 || buf[11] != 0x40 {
+		t.Fatalf("Latitude is incorrect: %v", buf[9:12])
+	}
+	if buf[12] != 0x00 || buf[13] != 0x00 || buf[14] != 0xC0 {
+		t.Fatalf("Longitude is incorrect: %v", buf[12:15])
+	}
+	if binary.LittleEndian.Uint16(buf[15:]) != beaconCRC(buf[8:15]) {
+		t.Fatal("Gateway specific CRC is incorrect")
+	}
+
+	buf, err = b.Encode(5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != 23 {
+		t.Fatalf("US beacon should be 23 bytes but is %d", len(buf))
+	}
+	if _, err := b.Encode(-1, 0); err == nil {
+		t.Fatal("Expected error with negative RFU size")
+	}
+}
+
+func TestPingSlots(t *testing.T) {
+	addr := DevAddr{NwkID: 1, NwkAddr: 0x1234}
+	if _, err := PingOffset(0, addr, 8); err == nil {
+		t.Fatal("Expected error with periodicity > 7")
+	}
+
+	beacon := NextBeacon(time.Now())
+	for periodicity := uint8(0); periodicity <= MaxPingPeriodicity; periodicity++ {
+		offset, err := PingOffset(uint32(GPSTime(beacon)/time.Second), addr, periodicity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset < 0 || offset >= PingPeriod(periodicity) {
+			t.Fatalf("Offset %d is outside the ping period %d", offset, PingPeriod(periodicity))
+		}
+		slots, err := PingSlots(beacon, addr, periodicity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(slots) != 1<<(7-periodicity) {
+			t.Fatalf("Expected %d slots but got %d for periodicity %d", 1<<(7-periodicity), len(slots), periodicity)
+		}
+		for _, s := range slots {
+			if s.Before(beacon.Add(BeaconReserved)) || s.After(beacon.Add(BeaconPeriod-BeaconGuard)) {
+				t.Fatalf("Slot %v is outside the ping window", s)
+			}
+		}
+	}
+}
@@ -15,8 +15,126 @@ package protocol
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
-// CFList contains region specific information on frequencies for end-devices.
+//
+import "encoding/binary"
+
+// CFListChannels is the number of channels in a CFList. The channels in the
+// list are channel 3 to 7 for the device.
+const CFListChannels = 5
+
+// CFListLength is the length of an encoded CFList
+const CFListLength = 16
+
+// CFListChMasks is the number of channel masks in a CFList with channel
+// masks. The masks cover channel 0 to 79.
+const CFListChMasks = 5
+
+// The CFList types [2.5.4/Regional Parameters]
+const (
+	// CFListFrequencies is a list of channel frequencies
+	CFListFrequencies uint8 = 0
+	// CFListChMask is a list of channel masks for bands with a fixed channel
+	// plan.
+	CFListChMask uint8 = 1
+)
+
+// CFList contains region specific information on frequencies for end-devices
+// [6.2.5], see [2.1.4] in LoRaWAN Regional Parameters for EU868. The
+// frequencies are in 100 Hz steps, just like the NewChannelReq command.
+// Frequencies set to 0 are unused. Bands with a fixed channel plan (f.e.
+// US915) use a list of channel masks instead of the frequencies. Each mask
+// holds 16 channels, the first mask is channel 0 to 15.
 type CFList struct {
-	// Left empty until further notice. Region specific settings [6.2.5], see
-	// [2.1.4] in LoRaWAN Regional Parameters for EU868
+	Type        uint8
+	Frequencies [CFListChannels]uint32
+	ChMask      [CFListChMasks]uint16
+}
+
+// Empty returns true if the list doesn't contain any frequencies or channel
+// masks. Empty lists are omitted from the JoinAccept message.
+func (c *CFList) Empty() bool {
+	if c.Type == CFListChMask {
+		for _, v := range c.ChMask {
+			if v != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range c.Frequencies {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode the CFList into the buffer. The last byte is the CFListType.
+func (c *CFList) encode(buffer []byte, pos *int) error {
+	if buffer == nil || pos == nil {
+		return ErrNilError
+	}
+	if len(buffer) < (*pos + CFListLength) {
+		return ErrBufferTruncated
+	}
+	if c.Type == CFListChMask {
+		for i, v := range c.ChMask {
+			binary.LittleEndian.PutUint16(buffer[*pos+i*2:], v)
+		}
+		// The rest of the list is RFU
+		for i := CFListChMasks * 2; i < CFListLength-1; i++ {
+			buffer[*pos+i] = 0
+		}
+		*pos += CFListLength - 1
+		buffer[*pos] = CFListChMask
+		*pos++
+		return nil
+	}
+	for _, v := range c.Frequencies {
+		buffer[*pos+0] = byte(v & 0xFF)
+		buffer[*pos+1] = byte((v >> 8) & 0xFF)
+		buffer[*pos+2] = byte((v >> 16) & 0xFF)
+		*pos += 3
+	}
+	buffer[*pos] = CFListFrequencies
+	*pos++
+	return nil
+}
+
+func (c *CFList) decode(buffer []byte, pos *int) error {
+	if buffer == nil || pos == nil {
+		return ErrNilError
+	}
+	if len(buffer) < (*pos + CFListLength) {
+		return ErrBufferTruncated
+	}
+	*c = CFList{Type: buffer[*pos+CFListLength-1]}
+	if c.Type == CFListChMask {
+		for i := range c.ChMask {
+			c.ChMask[i] = binary.LittleEndian.Uint16(buffer[*pos+i*2:])
+		}
+		*pos += CFListLength
+		return nil
+	}
+	for i := range c.Frequencies {
+		c.Frequencies[i] = uint32(buffer[*pos+0]) | uint32(buffer[*pos+1])<<8 | uint32(buffer[*pos+2])<<16
+		*pos += 3
+	}
+	*pos++
+	return nil
+}
+
+// MarshalBinary encodes the list in the same format as the JoinAccept message
+func (c CFList) MarshalBinary() ([]byte, error) {
+	buffer := make([]byte, CFListLength)
+	pos := 0
+	err := c.encode(buffer, &pos)
+	return buffer, err
+}
+
+// UnmarshalBinary decodes the list from the format used in the JoinAccept
+// message
+func (c *CFList) UnmarshalBinary(data []byte) error {
+	pos := 0
+	return c.decode(data, &pos)
 }
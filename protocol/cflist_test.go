@@ -0,0 +1,93 @@
+package protocol
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import "testing"
+
+func TestCFListEncodeDecode(t *testing.T) {
+	c1 := CFList{Frequencies: [CFListChannels]uint32{8671000, 8673000, 8675000, 0, 8679000}}
+	if c1.Empty() {
+		t.Fatal("List shouldn't be empty")
+	}
+	buffer := make([]byte, CFListLength)
+	pos := 0
+	if err := c1.encode(buffer, &pos); err != nil {
+		t.Fatalf("Got error encoding CFList: %v", err)
+	}
+	if pos != CFListLength {
+		t.Fatalf("Expected pos to be %d but it is %d", CFListLength, pos)
+	}
+	// 867.1 MHz = 8671000 = 0x844F18, LSB first
+	if buffer[0] != 0x18 || buffer[1] != 0x4F || buffer[2] != 0x84 || buffer[15] != 0 {
+		t.Fatalf("Unexpected encoding: %v", buffer)
+	}
+
+	c2 := CFList{}
+	if !c2.Empty() {
+		t.Fatal("List should be empty")
+	}
+	pos = 0
+	if err := c2.decode(buffer, &pos); err != nil {
+		t.Fatalf("Got error decoding CFList: %v", err)
+	}
+	if c1 != c2 {
+		t.Fatalf("Encoded and decoded are different: %+v != %+v", c1, c2)
+	}
+}
+
+func TestCFListBufferRange(t *testing.T) {
+	basicDecoderTests(t, &CFList{})
+	basicEncoderTests(t, &CFList{})
+}
+
+func TestCFListMarshal(t *testing.T) {
+	c1 := CFList{Frequencies: [CFListChannels]uint32{8671000, 0, 0, 0, 8679000}}
+	buf, err := c1.MarshalBinary()
+	if err != nil || len(buf) != CFListLength {
+		t.Fatalf("Unexpected encoding: %v (err=%v)", buf, err)
+	}
+	c2 := CFList{}
+	if err := c2.UnmarshalBinary(buf); err != nil || c1 != c2 {
+		t.Fatalf("Encoded and decoded are different: %+v != %+v (err=%v)", c1, c2, err)
+	}
+	if err := c2.UnmarshalBinary(buf[1:]); err == nil {
+		t.Fatal("Expected error with truncated buffer")
+	}
+}
+
+func TestCFListChMask(t *testing.T) {
+	c1 := CFList{Type: CFListChMask}
+	if !c1.Empty() {
+		t.Fatal("List should be empty")
+	}
+	c1.ChMask[0] = 0xFF00
+	c1.ChMask[4] = 0x0002
+	if c1.Empty() {
+		t.Fatal("List shouldn't be empty")
+	}
+	buf, err := c1.MarshalBinary()
+	if err != nil || len(buf) != CFListLength {
+		t.Fatalf("Unexpected encoding: %v (err=%v)", buf, err)
+	}
+	if buf[0] != 0x00 || buf[1] != 0xFF || buf[8] != 0x02 || buf[9] != 0x00 || buf[15] != CFListChMask {
+		t.Fatalf("Unexpected encoding: %v", buf)
+	}
+	c2 := CFList{}
+	if err := c2.UnmarshalBinary(buf); err != nil || c1 != c2 {
+		t.Fatalf("Encoded and decoded are different: %+v != %+v (err=%v)", c1, c2, err)
+	}
+}
@@ -20,6 +20,7 @@ package protocol
 type DLSettings struct {
 	RX1DRoffset byte
 	RX2DataRate byte
+	OptNeg      bool // Set when the network uses the LoRaWAN 1.1 key hierarchy [6.2.3]
 }
 
 // Encode DLSettings type into buffer.
@@ -31,6 +32,9 @@ func (d *DLSettings) encode(buffer []byte, pos *int) error {
 		return ErrBufferTruncated
 	}
 	buffer[*pos] = ((d.RX1DRoffset & 0x07) << 4) | (d.RX2DataRate & 0x0F)
+	if d.OptNeg {
+		buffer[*pos] |= 0x80
+	}
 	*pos++
 	return nil
 }
@@ -42,8 +46,23 @@ func (d *DLSettings) decode(buffer []byte, pos *int) error {
 	if len(buffer) <= *pos {
 		return ErrBufferTruncated
 	}
+	d.OptNeg = buffer[*pos]&0x80 != 0
 	d.RX1DRoffset = (buffer[*pos] & 0x70) >> 4
 	d.RX2DataRate = buffer[*pos] & 0x0F
 	*pos++
 	return nil
 }
+
+// MarshalBinary encodes the DLSettings field as a single byte
+func (d DLSettings) MarshalBinary() ([]byte, error) {
+	buffer := make([]byte, 1)
+	pos := 0
+	err := d.encode(buffer, &pos)
+	return buffer, err
+}
+
+// UnmarshalBinary decodes the DLSettings field from a single byte
+func (d *DLSettings) UnmarshalBinary(data []byte) error {
+	pos := 0
+	return d.decode(data, &pos)
+}
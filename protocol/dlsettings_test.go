@@ -46,7 +46,7 @@ func TestDLSettingsEncoding(t *testing.T) {
 }
 
 func TestDLSettingsEncodeDecode(t *testing.T) {
-	d1 := DLSettings{RX1DRoffset: 0x7, RX2DataRate: 0xF}
+	d1 := DLSettings{OptNeg: true, RX1DRoffset: 0x7, RX2DataRate: 0xF}
 	buffer := make([]byte, 2)
 	pos := 0
 	if err := d1.encode(buffer, &pos); err != nil {
@@ -68,3 +68,18 @@ func TestDLSettingsBufferRange(t *testing.T) {
 	basicDecoderTests(t, &DLSettings{})
 	basicEncoderTests(t, &DLSettings{})
 }
+
+func TestDLSettingsMarshal(t *testing.T) {
+	d1 := DLSettings{OptNeg: true, RX1DRoffset: 2, RX2DataRate: 3}
+	buf, err := d1.MarshalBinary()
+	if err != nil || len(buf) != 1 || buf[0] != 0xA3 {
+		t.Fatalf("Unexpected encoding: %v (err=%v)", buf, err)
+	}
+	d2 := DLSettings{}
+	if err := d2.UnmarshalBinary(buf); err != nil || d1 != d2 {
+		t.Fatalf("Encoded and decoded are different: %+v != %+v (err=%v)", d1, d2, err)
+	}
+	if err := d2.UnmarshalBinary(nil); err == nil {
+		t.Fatal("Expected error with empty buffer")
+	}
+}
@@ -16,6 +16,7 @@ package protocol
 //limitations under the License.
 //
 import (
+	"crypto/aes"
 	"encoding/binary"
 )
 
@@ -24,7 +25,22 @@ type FHDR struct {
 	DevAddr DevAddr       // [6.1.1]
 	FCtrl   FCtrl         // [4.3.1]
 	FCnt    uint16        // [4.3.1.5]
+	FCntMSB uint16        // The 16 most significant bits of the frame counter. These aren't transmitted [4.3.1.5]
 	FOpts   MACCommandSet // MAC Commands in the FOpts structure
+	fOpts   []byte        // The FOpts field as received. LoRaWAN 1.1 devices encrypt this field
+}
+
+// FullFCnt returns the 32 bit frame counter. This is the counter used when
+// calculating the MIC and encrypting the payload [4.3.3], [4.4]
+func (f *FHDR) FullFCnt() uint32 {
+	return uint32(f.FCntMSB)<<16 | uint32(f.FCnt)
+}
+
+// SetFullFCnt sets the 32 bit frame counter. Only the 16 least significant bits
+// are transmitted.
+func (f *FHDR) SetFullFCnt(fcnt uint32) {
+	f.FCnt = uint16(fcnt & 0xFFFF)
+	f.FCntMSB = uint16(fcnt >> 16)
 }
 
 // decodeFHDR extracts Device Address, Frame Control octet, Frame Counter, Frame Options from Frame Header
@@ -43,20 +59,66 @@ func (f *FHDR) decode(octets []byte, pos *int) error {
 	f.FCnt = binary.LittleEndian.Uint16(octets[*pos : *pos+2])
 	*pos += 2
 
+	f.fOpts = nil
 	if f.FCtrl.FOptsLen > 0 {
+		end := *pos + int(f.FCtrl.FOptsLen)
+		if len(octets) < end {
+			return ErrBufferTruncated
+		}
+		f.fOpts = make([]byte, f.FCtrl.FOptsLen)
+		copy(f.fOpts, octets[*pos:end])
 		f.FOpts = NewMACCommandSet(f.FOpts.Message(), int(f.FCtrl.FOptsLen))
-		if err := f.FOpts.decode(octets, pos); err != nil {
-			if err == errUnknownMAC {
-				// Found an unknown MAC command. Skip forward the number of missing bytes
-				*pos += (int(f.FCtrl.FOptsLen) - f.FOpts.EncodedLength())
-				return nil
-			}
+		if err := f.FOpts.decode(octets, pos); err != nil && err != errUnknownMAC {
 			return err
 		}
+		// Skip forward to the end of the field. Unknown MAC commands ends the
+		// decoding early.
+		*pos = end
 	}
 	return nil
 }
 
+// decryptFOpts decrypts the FOpts field and decodes the MAC commands in it.
+// LoRaWAN 1.1 devices encrypts the field with the NwkSEncKey [4.3.1.6].
+func (f *FHDR) decryptFOpts(nwkSEncKey AESKey, uplink bool) error {
+	if len(f.fOpts) == 0 {
+		return nil
+	}
+	buffer := f.cryptFOpts(nwkSEncKey, uplink, f.fOpts)
+	f.FOpts = NewMACCommandSet(f.FOpts.Message(), len(buffer))
+	pos := 0
+	err := f.FOpts.decode(buffer, &pos)
+	if err == errUnknownMAC || (err == ErrBufferTruncated && pos == len(buffer)) {
+		// Unknown commands ends the decoding and the buffer ends after the
+		// last command.
+		return nil
+	}
+	return err
+}
+
+// cryptFOpts encrypts or decrypts the FOpts field. The key stream is
+// generated from the NwkSEncKey, the direction, DevAddr and the frame counter
+// [4.3.1.6]
+func (f *FHDR) cryptFOpts(nwkSEncKey AESKey, uplink bool, fOpts []byte) []byte {
+	a := make([]byte, 16)
+	a[0] = 0x01
+	if !uplink {
+		a[5] = 1
+	}
+	binary.LittleEndian.PutUint32(a[6:], f.DevAddr.ToUint32())
+	binary.LittleEndian.PutUint32(a[10:], f.FullFCnt())
+
+	s := make([]byte, 16)
+	block, _ := aes.NewCipher(nwkSEncKey.Key[:])
+	block.Encrypt(s, a)
+
+	ret := make([]byte, len(fOpts))
+	for i := range fOpts {
+		ret[i] = fOpts[i] ^ s[i]
+	}
+	return ret
+}
+
 func (f *FHDR) encode(buffer []byte, count *int) error {
 	if count == nil {
 		return ErrNilError
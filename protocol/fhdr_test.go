@@ -52,6 +52,17 @@ func TestFHDREncodeDecode(t *testing.T) {
 	}
 }
 
+func TestFHDRFullFCnt(t *testing.T) {
+	f := FHDR{}
+	f.SetFullFCnt(0x00012345)
+	if f.FCnt != 0x2345 || f.FCntMSB != 0x0001 {
+		t.Fatalf("Unexpected frame counter fields: FCnt=%04x FCntMSB=%04x", f.FCnt, f.FCntMSB)
+	}
+	if f.FullFCnt() != 0x00012345 {
+		t.Fatalf("Unexpected full frame counter: %08x", f.FullFCnt())
+	}
+}
+
 func TestFHDRBufferRangeChecks(t *testing.T) {
 	basicEncoderTests(t, &FHDR{})
 	basicDecoderTests(t, &FHDR{})
@@ -62,7 +62,10 @@ func (j *JoinAcceptPayload) encode(buffer []byte, pos *int) error {
 	}
 	buffer[*pos] = j.RxDelay
 	*pos++
-	return nil
+	if j.CFList.Empty() {
+		return nil
+	}
+	return j.CFList.encode(buffer, pos)
 }
 
 func (j *JoinAcceptPayload) decode(buffer []byte, pos *int) error {
@@ -84,5 +87,9 @@ func (j *JoinAcceptPayload) decode(buffer []byte, pos *int) error {
 	}
 	j.RxDelay = buffer[*pos]
 	*pos++
-	return nil
+	j.CFList = CFList{}
+	if len(buffer)-*pos < CFListLength {
+		return nil
+	}
+	return j.CFList.decode(buffer, pos)
 }
@@ -86,7 +86,7 @@ func TestJoinAcceptEncodeDecode(t *testing.T) {
 		AppNonce:   [3]byte{1, 2, 3},
 		NetID:      0x0a0b0c,
 		DevAddr:    DevAddrFromUint32(0x04030201),
-		DLSettings: DLSettings{1, 2},
+		DLSettings: DLSettings{RX1DRoffset: 1, RX2DataRate: 2},
 		RxDelay:    99,
 		CFList:     CFList{},
 	}
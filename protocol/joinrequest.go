@@ -19,6 +19,11 @@ import (
 	"encoding/binary"
 )
 
+// JoinRequestType is the JoinReqType value used in the JoinAccept MIC for
+// LoRaWAN 1.1 devices when the JoinAccept is a response to a JoinRequest
+// message [6.2.3]
+const JoinRequestType byte = 0xFF
+
 // JoinRequestPayload is the payload sent by the device in a JoinRequest
 // message [6.2.4]. The message is not encrypted.
 type JoinRequestPayload struct {
@@ -0,0 +1,86 @@
+package protocol
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+)
+
+// keyWrapIV is the default initial value for the AES key wrap algorithm
+var keyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// WrapKey wraps the key with the key encryption key (KEK) using the AES key
+// wrap algorithm in RFC 3394. This is the format the LoRaWAN backend
+// interfaces use for key envelopes. The wrapped key is 24 bytes long.
+func WrapKey(kek AESKey, key AESKey) ([]byte, error) {
+	block, err := aes.NewCipher(kek.Key[:])
+	if err != nil {
+		return nil, err
+	}
+	const n = 2
+	ret := make([]byte, 8*(n+1))
+	copy(ret, keyWrapIV)
+	copy(ret[8:], key.Key[:])
+
+	b := make([]byte, 16)
+	for j := 0; j <= 5; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, ret[0:8])
+			copy(b[8:], ret[i*8:i*8+8])
+			block.Encrypt(b, b)
+			t := binary.BigEndian.Uint64(b[0:8]) ^ uint64(n*j+i)
+			binary.BigEndian.PutUint64(ret[0:8], t)
+			copy(ret[i*8:], b[8:])
+		}
+	}
+	return ret, nil
+}
+
+// UnwrapKey unwraps a key wrapped with WrapKey. ErrInvalidSource is returned
+// if the integrity check fails, ie the KEK is wrong or the wrapped key is
+// corrupted.
+func UnwrapKey(kek AESKey, wrapped []byte) (AESKey, error) {
+	const n = 2
+	if len(wrapped) != 8*(n+1) {
+		return AESKey{}, ErrInvalidParameterFormat
+	}
+	block, err := aes.NewCipher(kek.Key[:])
+	if err != nil {
+		return AESKey{}, err
+	}
+	buf := make([]byte, len(wrapped))
+	copy(buf, wrapped)
+
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := binary.BigEndian.Uint64(buf[0:8]) ^ uint64(n*j+i)
+			binary.BigEndian.PutUint64(b[0:8], t)
+			copy(b[8:], buf[i*8:i*8+8])
+			block.Decrypt(b, b)
+			copy(buf[0:8], b[0:8])
+			copy(buf[i*8:], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(buf[0:8], keyWrapIV) != 1 {
+		return AESKey{}, ErrInvalidSource
+	}
+	ret := AESKey{}
+	copy(ret.Key[:], buf[8:])
+	return ret, nil
+}
@@ -0,0 +1,53 @@
+package protocol
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vector from RFC 3394 section 4.1
+func TestKeyWrap(t *testing.T) {
+	kek, _ := AESKeyFromString("000102030405060708090A0B0C0D0E0F")
+	key, _ := AESKeyFromString("00112233445566778899AABBCCDDEEFF")
+	expected, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+
+	wrapped, err := WrapKey(kek, key)
+	if err != nil {
+		t.Fatal("Got error wrapping key: ", err)
+	}
+	if !bytes.Equal(wrapped, expected) {
+		t.Fatalf("Wrapped key is %x, expected %x", wrapped, expected)
+	}
+
+	unwrapped, err := UnwrapKey(kek, wrapped)
+	if err != nil {
+		t.Fatal("Got error unwrapping key: ", err)
+	}
+	if unwrapped != key {
+		t.Fatalf("Unwrapped key is %s, expected %s", unwrapped, key)
+	}
+
+	wrongKEK, _ := AESKeyFromString("0F0E0D0C0B0A09080706050403020100")
+	if _, err := UnwrapKey(wrongKEK, wrapped); err != ErrInvalidSource {
+		t.Fatal("Expected integrity check to fail with the wrong KEK but got ", err)
+	}
+	if _, err := UnwrapKey(kek, wrapped[1:]); err == nil {
+		t.Fatal("Expected error with truncated wrapped key")
+	}
+}
@@ -50,6 +50,24 @@ const (
 	RXTimingSetupReq CID = 0x08
 	// RXTimingSetupAns is sent by the end-device to the network (no payload)
 	RXTimingSetupAns CID = 0x08
+	// DlChannelReq is sent by the network to the end-device.
+	DlChannelReq CID = 0x0A
+	// DlChannelAns is sent by the end-device to the network.
+	DlChannelAns CID = 0x0A
+	// RekeyInd is sent by LoRaWAN 1.1 end-devices to the network after a join.
+	RekeyInd CID = 0x0B
+	// RekeyConf is sent by the network to LoRaWAN 1.1 end-devices.
+	RekeyConf CID = 0x0B
+	// DeviceTimeReq is sent by the end-device to the network (no payload).
+	DeviceTimeReq CID = 0x0D
+	// DeviceTimeAns is sent by the network to the end-device.
+	DeviceTimeAns CID = 0x0D
+	// ForceRejoinReq is sent by the network to LoRaWAN 1.1 end-devices.
+	ForceRejoinReq CID = 0x0E
+	// RejoinParamSetupReq is sent by the network to LoRaWAN 1.1 end-devices.
+	RejoinParamSetupReq CID = 0x0F
+	// RejoinParamSetupAns is sent by LoRaWAN 1.1 end-devices to the network.
+	RejoinParamSetupAns CID = 0x0F
 )
 
 // MAC commands for Class B devices
@@ -154,6 +172,14 @@ func NewUplinkMACCommand(id CID) MACCommand {
 		return &MACNewChannelAns{macBase{NewChannelAns, true}, false, false}
 	case RXTimingSetupAns:
 		return &MACRXTimingSetupAns{macBase{RXTimingSetupAns, true}}
+	case DlChannelAns:
+		return &MACDlChannelAns{macBase{DlChannelAns, true}, false, false}
+	case RekeyInd:
+		return &MACRekeyInd{macBase{RekeyInd, true}, 0}
+	case DeviceTimeReq:
+		return &MACDeviceTimeReq{macBase{DeviceTimeReq, true}}
+	case RejoinParamSetupAns:
+		return &MACRejoinParamSetupAns{macBase{RejoinParamSetupAns, true}, false}
 	case PingSlotInfoReq:
 		return &MACPingSlotInfoReq{macBase{PingSlotInfoReq, true}, 0, 0}
 	case PingSlotFreqAns:
@@ -183,6 +209,16 @@ func NewDownlinkMACCommand(id CID) MACCommand {
 		return &MACNewChannelReq{macBase{NewChannelReq, false}, 0, 0, 0, 0}
 	case RXTimingSetupReq:
 		return &MACRXTimingSetupReq{macBase{RXTimingSetupReq, false}, 0}
+	case DlChannelReq:
+		return &MACDlChannelReq{macBase{DlChannelReq, false}, 0, 0}
+	case RekeyConf:
+		return &MACRekeyConf{macBase{RekeyConf, false}, 0}
+	case DeviceTimeAns:
+		return &MACDeviceTimeAns{macBase{DeviceTimeAns, false}, 0, 0}
+	case ForceRejoinReq:
+		return &MACForceRejoinReq{macBase{ForceRejoinReq, false}, 0, 0, 0, 0}
+	case RejoinParamSetupReq:
+		return &MACRejoinParamSetupReq{macBase{RejoinParamSetupReq, false}, 0, 0}
 	case PingSlotInfoAns:
 		return &MACPingSlotInfoAns{macBase{PingSlotInfoAns, false}}
 	case PingSlotChannelReq:
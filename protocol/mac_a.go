@@ -17,6 +17,7 @@ package protocol
 //
 import (
 	"encoding/binary"
+	"time"
 )
 
 // MACLinkCheckReq is sent from the end-device to the network server
@@ -77,7 +78,7 @@ type MACLinkADRReq struct {
 	DataRate   uint8  // Region specific - see [7.1.3], [7.2.3], [7.3.3], [7.4.3]
 	TXPower    uint8  // Region specific - see [7.1.3], [7.2.3], [7.3.3], [7.4.3]
 	ChMask     uint16 // Channel mask - 1 bit per channel
-	Redundancy uint8  // TODO: See [5.2]
+	Redundancy uint8  // ChMaskCntl (bits 6:4) and NbTrans (bits 3:0). See [5.2]
 }
 
 // Length returns the length of the MAC command when encoded into a byte buffer
@@ -350,6 +351,12 @@ func (m *MACDevStatusAns) decode(buffer []byte, pos *int) error {
 	return nil
 }
 
+// SignedMargin returns the demodulation margin in dB. The margin is encoded as
+// a 6-bit signed integer in the range [-32, 31] [5.5].
+func (m *MACDevStatusAns) SignedMargin() int8 {
+	return int8(m.Margin<<2) >> 2
+}
+
 // MACNewChannelReq is sent from the network server to set up a new channel on the device
 type MACNewChannelReq struct {
 	macBase
@@ -480,3 +487,304 @@ func (m *MACRXTimingSetupAns) encode(buffer []byte, pos *int) error {
 func (m *MACRXTimingSetupAns) decode(buffer []byte, pos *int) error {
 	return decodeID(m, buffer, pos)
 }
+
+// MACDlChannelReq is sent by the network server to change the downlink
+// frequency used in the RX1 window for a channel [5.8]
+type MACDlChannelReq struct {
+	macBase
+	ChIndex uint8
+	Freq    uint32
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACDlChannelReq) Length() int {
+	return 5
+}
+
+func (m *MACDlChannelReq) encode(buffer []byte, pos *int) error {
+	if err := encodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	buffer[*pos] = m.ChIndex
+	*pos++
+	buffer[*pos+0] = byte(m.Freq & 0xFF)
+	buffer[*pos+1] = byte((m.Freq >> 8) & 0xFF)
+	buffer[*pos+2] = byte((m.Freq >> 16) & 0xFF)
+	*pos += 3
+	return nil
+}
+
+func (m *MACDlChannelReq) decode(buffer []byte, pos *int) error {
+	if err := decodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	m.ChIndex = buffer[*pos]
+	*pos++
+	m.Freq = uint32(buffer[*pos+0]) | uint32(buffer[*pos+1])<<8 | uint32(buffer[*pos+2])<<16
+	*pos += 3
+	return nil
+}
+
+// MACDlChannelAns is sent by the end-device to the network server as a response
+// to the DlChannelReq command
+type MACDlChannelAns struct {
+	macBase
+	UplinkFrequencyExists bool
+	ChannelFrequencyOK    bool
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACDlChannelAns) Length() int {
+	return 2
+}
+
+func (m *MACDlChannelAns) encode(buffer []byte, pos *int) error {
+	if err := encodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	val := byte(0)
+	if m.UplinkFrequencyExists {
+		val |= (1 << 1)
+	}
+	if m.ChannelFrequencyOK {
+		val |= (1 << 0)
+	}
+	buffer[*pos] = val
+	*pos++
+	return nil
+}
+
+func (m *MACDlChannelAns) decode(buffer []byte, pos *int) error {
+	if err := decodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	m.UplinkFrequencyExists = buffer[*pos]&0x02 != 0
+	m.ChannelFrequencyOK = buffer[*pos]&0x01 != 0
+	*pos++
+	return nil
+}
+
+// MACRekeyInd is sent by LoRaWAN 1.1 end-devices after a successful join to
+// confirm the new security context. The device keeps sending the command
+// until it receives a RekeyConf [5.10].
+type MACRekeyInd struct {
+	macBase
+	Version MACVersion // The minor LoRaWAN version of the device
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACRekeyInd) Length() int {
+	return 2
+}
+
+func (m *MACRekeyInd) encode(buffer []byte, pos *int) error {
+	if err := encodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	buffer[*pos] = byte(m.Version) & 0x0F
+	*pos++
+	return nil
+}
+
+func (m *MACRekeyInd) decode(buffer []byte, pos *int) error {
+	if err := decodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	m.Version = MACVersion(buffer[*pos] & 0x0F)
+	*pos++
+	return nil
+}
+
+// MACRekeyConf is sent by the network server as a response to the RekeyInd
+// command [5.10].
+type MACRekeyConf struct {
+	macBase
+	Version MACVersion // The minor LoRaWAN version of the server
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACRekeyConf) Length() int {
+	return 2
+}
+
+func (m *MACRekeyConf) encode(buffer []byte, pos *int) error {
+	if err := encodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	buffer[*pos] = byte(m.Version) & 0x0F
+	*pos++
+	return nil
+}
+
+func (m *MACRekeyConf) decode(buffer []byte, pos *int) error {
+	if err := decodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	m.Version = MACVersion(buffer[*pos] & 0x0F)
+	*pos++
+	return nil
+}
+
+// MACDeviceTimeReq is sent by the end-device to request the current network
+// time [5.9 in LoRaWAN 1.0.3]
+type MACDeviceTimeReq struct {
+	macBase
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACDeviceTimeReq) Length() int {
+	return 1
+}
+
+func (m *MACDeviceTimeReq) encode(buffer []byte, pos *int) error {
+	return encodeID(m, buffer, pos)
+}
+
+func (m *MACDeviceTimeReq) decode(buffer []byte, pos *int) error {
+	return decodeID(m, buffer, pos)
+}
+
+// MACDeviceTimeAns is sent by the network server as a response to the
+// DeviceTimeReq command. The time is the GPS time at the end of the uplink
+// transmission.
+type MACDeviceTimeAns struct {
+	macBase
+	Seconds  uint32 // Seconds since the GPS epoch (modulo 2^32)
+	Fraction uint8  // Fractional second in 1/256 s steps
+}
+
+// SetGPSTime sets the seconds and fractional seconds from the time since the
+// GPS epoch
+func (m *MACDeviceTimeAns) SetGPSTime(gpsTime time.Duration) {
+	m.Seconds = uint32(gpsTime / time.Second)
+	m.Fraction = uint8((gpsTime % time.Second) * 256 / time.Second)
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACDeviceTimeAns) Length() int {
+	return 6
+}
+
+func (m *MACDeviceTimeAns) encode(buffer []byte, pos *int) error {
+	if err := encodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(buffer[*pos:], m.Seconds)
+	buffer[*pos+4] = m.Fraction
+	*pos += 5
+	return nil
+}
+
+func (m *MACDeviceTimeAns) decode(buffer []byte, pos *int) error {
+	if err := decodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	m.Seconds = binary.LittleEndian.Uint32(buffer[*pos:])
+	m.Fraction = buffer[*pos+4]
+	*pos += 5
+	return nil
+}
+
+// MACForceRejoinReq is sent by the network server to make a LoRaWAN 1.1
+// end-device send a Rejoin-request [5.13].
+type MACForceRejoinReq struct {
+	macBase
+	Period     uint8 // Delay between retransmissions is 32 seconds x 2^Period + random delay
+	MaxRetries uint8 // Number of retransmissions (0-7)
+	RejoinType uint8 // Rejoin type to send (0 or 2)
+	DataRate   uint8 // Data rate for the Rejoin-request
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACForceRejoinReq) Length() int {
+	return 3
+}
+
+func (m *MACForceRejoinReq) encode(buffer []byte, pos *int) error {
+	if err := encodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	val := uint16(m.Period&0x07)<<11 | uint16(m.MaxRetries&0x07)<<8 | uint16(m.RejoinType&0x07)<<4 | uint16(m.DataRate&0x0F)
+	buffer[*pos+0] = byte(val & 0xFF)
+	buffer[*pos+1] = byte(val >> 8)
+	*pos += 2
+	return nil
+}
+
+func (m *MACForceRejoinReq) decode(buffer []byte, pos *int) error {
+	if err := decodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	val := uint16(buffer[*pos+0]) | uint16(buffer[*pos+1])<<8
+	m.Period = uint8(val>>11) & 0x07
+	m.MaxRetries = uint8(val>>8) & 0x07
+	m.RejoinType = uint8(val>>4) & 0x07
+	m.DataRate = uint8(val) & 0x0F
+	*pos += 2
+	return nil
+}
+
+// MACRejoinParamSetupReq is sent by the network server to set up periodic
+// Rejoin-request type 0 messages from LoRaWAN 1.1 end-devices [5.14].
+type MACRejoinParamSetupReq struct {
+	macBase
+	MaxTimeN  uint8 // Send a rejoin at least every 2^(MaxTimeN+10) seconds
+	MaxCountN uint8 // Send a rejoin at least every 2^(MaxCountN+4) uplinks
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACRejoinParamSetupReq) Length() int {
+	return 2
+}
+
+func (m *MACRejoinParamSetupReq) encode(buffer []byte, pos *int) error {
+	if err := encodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	buffer[*pos] = (m.MaxTimeN&0x0F)<<4 | m.MaxCountN&0x0F
+	*pos++
+	return nil
+}
+
+func (m *MACRejoinParamSetupReq) decode(buffer []byte, pos *int) error {
+	if err := decodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	m.MaxTimeN = buffer[*pos] >> 4
+	m.MaxCountN = buffer[*pos] & 0x0F
+	*pos++
+	return nil
+}
+
+// MACRejoinParamSetupAns is sent by the end-device as a response to the
+// RejoinParamSetupReq command [5.14].
+type MACRejoinParamSetupAns struct {
+	macBase
+	TimeOK bool // The device accepted the MaxTimeN setting
+}
+
+// Length returns the length of the MAC command when encoded into a byte buffer
+func (m *MACRejoinParamSetupAns) Length() int {
+	return 2
+}
+
+func (m *MACRejoinParamSetupAns) encode(buffer []byte, pos *int) error {
+	if err := encodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	buffer[*pos] = 0
+	if m.TimeOK {
+		buffer[*pos] = 1
+	}
+	*pos++
+	return nil
+}
+
+func (m *MACRejoinParamSetupAns) decode(buffer []byte, pos *int) error {
+	if err := decodeID(m, buffer, pos); err != nil {
+		return err
+	}
+	m.TimeOK = buffer[*pos]&0x01 != 0
+	*pos++
+	return nil
+}
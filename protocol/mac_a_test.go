@@ -15,7 +15,10 @@ package protocol
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 // Tests for the class A MAC commands
 func macCommandStandardTests(cmd MACCommand, kind CID, t *testing.T) {
@@ -298,6 +301,14 @@ func TestDevStatusAns(t *testing.T) {
 	if dpos != pos {
 		t.Errorf("DevStatusAns decodes different number of bytes (%d != %d)", dpos, pos)
 	}
+
+	margins := map[uint8]int8{0x00: 0, 0x0A: 10, 0x1F: 31, 0x20: -32, 0x3F: -1}
+	for encoded, expected := range margins {
+		p.Margin = encoded
+		if p.SignedMargin() != expected {
+			t.Errorf("Expected margin %d for 0x%02x but got %d", expected, encoded, p.SignedMargin())
+		}
+	}
 }
 
 func TestNewChannelReq(t *testing.T) {
@@ -403,3 +414,231 @@ func TestRXTimingSetupAns(t *testing.T) {
 		t.Errorf("RXTimingSetupAns decodes different number of bytes (%d != %d)", dpos, pos)
 	}
 }
+
+func TestDlChannelReq(t *testing.T) {
+	m := MACDlChannelReq{macBase{DlChannelReq, false}, 0x03, 0x845FC8}
+	macCommandStandardTests(&m, DlChannelReq, t)
+
+	buffer := make([]byte, 5)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode DlChannelReq: ", err)
+	}
+
+	p := MACDlChannelReq{macBase{DlChannelReq, false}, 0, 0}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode DlChannelReq: ", err)
+	}
+
+	if m != p {
+		t.Errorf("Encoded and decoded DlChannelReq are different: %v != %v", p, m)
+	}
+
+	if dpos != pos {
+		t.Errorf("DlChannelReq decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
+
+func TestDlChannelAns(t *testing.T) {
+	m := MACDlChannelAns{macBase{DlChannelAns, true}, false, true}
+	macCommandStandardTests(&m, DlChannelAns, t)
+
+	buffer := make([]byte, 2)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode DlChannelAns: ", err)
+	}
+
+	p := MACDlChannelAns{macBase{DlChannelAns, true}, true, false}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode DlChannelAns: ", err)
+	}
+
+	if p != m {
+		t.Errorf("Encoded and decoded DlChannelAns are different: %v != %v", p, m)
+	}
+
+	if dpos != pos {
+		t.Errorf("DlChannelAns decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
+
+func TestRekeyInd(t *testing.T) {
+	m := MACRekeyInd{macBase{RekeyInd, true}, MACVersion11}
+	macCommandStandardTests(&m, RekeyInd, t)
+
+	buffer := make([]byte, 2)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode RekeyInd: ", err)
+	}
+
+	p := MACRekeyInd{macBase{RekeyInd, true}, MACVersion10}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode RekeyInd: ", err)
+	}
+
+	if p != m {
+		t.Errorf("Encoded and decoded RekeyInd are different: %v != %v", p, m)
+	}
+
+	if dpos != pos {
+		t.Errorf("RekeyInd decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
+
+func TestRekeyConf(t *testing.T) {
+	m := MACRekeyConf{macBase{RekeyConf, false}, MACVersion11}
+	macCommandStandardTests(&m, RekeyConf, t)
+
+	buffer := make([]byte, 2)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode RekeyConf: ", err)
+	}
+
+	p := MACRekeyConf{macBase{RekeyConf, false}, MACVersion10}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode RekeyConf: ", err)
+	}
+
+	if p != m {
+		t.Errorf("Encoded and decoded RekeyConf are different: %v != %v", p, m)
+	}
+
+	if dpos != pos {
+		t.Errorf("RekeyConf decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
+
+func TestDeviceTimeReq(t *testing.T) {
+	m := MACDeviceTimeReq{macBase{DeviceTimeReq, true}}
+	macCommandStandardTests(&m, DeviceTimeReq, t)
+
+	buffer := make([]byte, 1)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode DeviceTimeReq: ", err)
+	}
+	p := MACDeviceTimeReq{macBase{DeviceTimeReq, true}}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode DeviceTimeReq: ", err)
+	}
+	if dpos != pos {
+		t.Errorf("DeviceTimeReq decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
+
+func TestDeviceTimeAns(t *testing.T) {
+	m := MACDeviceTimeAns{macBase{DeviceTimeAns, false}, 0, 0}
+	m.SetGPSTime(1234567890*time.Second + 500*time.Millisecond)
+	if m.Seconds != 1234567890 || m.Fraction != 128 {
+		t.Fatalf("Unexpected time in DeviceTimeAns: %d + %d/256", m.Seconds, m.Fraction)
+	}
+	macCommandStandardTests(&m, DeviceTimeAns, t)
+
+	buffer := make([]byte, 6)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode DeviceTimeAns: ", err)
+	}
+	if buffer[1] != 0xD2 || buffer[4] != 0x49 || buffer[5] != 128 {
+		t.Errorf("Unexpected encoding of DeviceTimeAns: %v", buffer)
+	}
+
+	p := MACDeviceTimeAns{macBase{DeviceTimeAns, false}, 0, 0}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode DeviceTimeAns: ", err)
+	}
+	if p != m {
+		t.Errorf("Encoded and decoded DeviceTimeAns are different: %v != %v", p, m)
+	}
+	if dpos != pos {
+		t.Errorf("DeviceTimeAns decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
+
+func TestForceRejoinReq(t *testing.T) {
+	m := MACForceRejoinReq{macBase{ForceRejoinReq, false}, 5, 3, 2, 4}
+	macCommandStandardTests(&m, ForceRejoinReq, t)
+
+	buffer := make([]byte, 3)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode ForceRejoinReq: ", err)
+	}
+	// Period=5, Max_Retries=3, RejoinType=2, DR=4 => 0b00101011 0b00100100
+	if buffer[1] != 0x24 || buffer[2] != 0x2B {
+		t.Errorf("Unexpected encoding of ForceRejoinReq: %v", buffer)
+	}
+
+	p := MACForceRejoinReq{macBase{ForceRejoinReq, false}, 0, 0, 0, 0}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode ForceRejoinReq: ", err)
+	}
+
+	if p != m {
+		t.Errorf("Encoded and decoded ForceRejoinReq are different: %v != %v", p, m)
+	}
+
+	if dpos != pos {
+		t.Errorf("ForceRejoinReq decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
+
+func TestRejoinParamSetupReq(t *testing.T) {
+	m := MACRejoinParamSetupReq{macBase{RejoinParamSetupReq, false}, 10, 6}
+	macCommandStandardTests(&m, RejoinParamSetupReq, t)
+
+	buffer := make([]byte, 2)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode RejoinParamSetupReq: ", err)
+	}
+
+	p := MACRejoinParamSetupReq{macBase{RejoinParamSetupReq, false}, 0, 0}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode RejoinParamSetupReq: ", err)
+	}
+
+	if p != m {
+		t.Errorf("Encoded and decoded RejoinParamSetupReq are different: %v != %v", p, m)
+	}
+
+	if dpos != pos {
+		t.Errorf("RejoinParamSetupReq decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
+
+func TestRejoinParamSetupAns(t *testing.T) {
+	m := MACRejoinParamSetupAns{macBase{RejoinParamSetupAns, true}, true}
+	macCommandStandardTests(&m, RejoinParamSetupAns, t)
+
+	buffer := make([]byte, 2)
+	pos := 0
+	if err := m.encode(buffer, &pos); err != nil {
+		t.Error("Could not encode RejoinParamSetupAns: ", err)
+	}
+
+	p := MACRejoinParamSetupAns{macBase{RejoinParamSetupAns, true}, false}
+	dpos := 0
+	if err := p.decode(buffer, &dpos); err != nil {
+		t.Error("Could not decode RejoinParamSetupAns: ", err)
+	}
+
+	if p != m {
+		t.Errorf("Encoded and decoded RejoinParamSetupAns are different: %v != %v", p, m)
+	}
+
+	if dpos != pos {
+		t.Errorf("RejoinParamSetupAns decodes different number of bytes (%d != %d)", dpos, pos)
+	}
+}
@@ -15,6 +15,11 @@ package protocol
 //See the License for the specific language governing permissions and
 //limitations under the License.
 //
+import (
+	"fmt"
+	"strings"
+)
+
 // MType is the message type
 type MType uint8
 
@@ -31,8 +36,9 @@ const (
 	ConfirmedDataUp MType = 4
 	// ConfirmedDataDown is sent by the network [4.2.1]
 	ConfirmedDataDown MType = 5
-	// RFU is - surprisingly - Reserved for Future Use [4.2.1]
-	RFU MType = 6
+	// RejoinRequest is sent by LoRaWAN 1.1 end-devices. The message type is
+	// reserved for future use in LoRaWAN 1.0 [4.2.1]
+	RejoinRequest MType = 6
 	// Proprietary is a message type used when implementing proprietary messages [4.2.1]
 	Proprietary MType = 7
 )
@@ -51,18 +57,18 @@ func (m MType) String() string {
 		return "ConfirmedDataUp"
 	case ConfirmedDataDown:
 		return "ConfirmedDataDown"
-	case RFU:
-		return "RFU"
+	case RejoinRequest:
+		return "RejoinRequest"
 	case Proprietary:
 		return "Proprietary"
 	}
 	return "[Unknown type]"
 }
 
-// Uplink returns true if the message type is an uplink. RFU and Proprietary messages
-// are considered uplink messages
+// Uplink returns true if the message type is an uplink. Proprietary messages
+// are not considered uplink messages
 func (t MType) Uplink() bool {
-	return (t == JoinRequest || t == UnconfirmedDataUp || t == ConfirmedDataUp)
+	return (t == JoinRequest || t == RejoinRequest || t == UnconfirmedDataUp || t == ConfirmedDataUp)
 }
 
 const (
@@ -72,6 +78,34 @@ const (
 	MaxSupportedVersion uint8 = LoRaWANR1
 )
 
+// MACVersion is the minor version of the LoRaWAN specification implemented by
+// the device. It is sent in the RekeyInd and RekeyConf commands [5.10].
+type MACVersion uint8
+
+const (
+	// MACVersion10 is LoRaWAN 1.0.x
+	MACVersion10 MACVersion = 0
+	// MACVersion11 is LoRaWAN 1.1
+	MACVersion11 MACVersion = 1
+)
+
+// String returns the version as a string, ie "1.0" or "1.1"
+func (v MACVersion) String() string {
+	return fmt.Sprintf("1.%d", v)
+}
+
+// MACVersionFromString converts a version string ("1.0" or "1.1") into a
+// MACVersion.
+func MACVersionFromString(version string) (MACVersion, error) {
+	switch strings.TrimSpace(version) {
+	case "1.0":
+		return MACVersion10, nil
+	case "1.1":
+		return MACVersion11, nil
+	}
+	return MACVersion10, ErrInvalidLoRaWANVersion
+}
+
 // MHDR is the message header [4.2]
 type MHDR struct {
 	MType        MType // [4.2.1]
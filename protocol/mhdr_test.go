@@ -27,7 +27,7 @@ func TestMHDRMessageTypes(t *testing.T) {
 		UnconfirmedDataUp,
 		ConfirmedDataUp,
 		ConfirmedDataDown,
-		RFU,
+		RejoinRequest,
 		Proprietary}
 	for _, mtype := range messageTypes {
 		pos := 0
@@ -37,7 +37,7 @@ func TestMHDRMessageTypes(t *testing.T) {
 			t.Error("Got error encoding MType ", mtype, ": ", err)
 		}
 		pos = 0
-		if err := mhdr.decode(buf, &pos); (mtype != RFU && mtype != Proprietary) && err != nil {
+		if err := mhdr.decode(buf, &pos); (mtype != RejoinRequest && mtype != Proprietary) && err != nil {
 			t.Error("Got error decoding MType ", mtype, ": ", err)
 		}
 		if mhdr.MType != mtype {
@@ -82,7 +82,19 @@ func TestEncodeDecodeMHDR(t *testing.T) {
 }
 
 func TestMTypeStringer(t *testing.T) {
-	for _, v := range []MType{JoinAccept, JoinRequest, ConfirmedDataDown, ConfirmedDataUp, UnconfirmedDataDown, UnconfirmedDataUp, Proprietary, RFU} {
+	for _, v := range []MType{JoinAccept, JoinRequest, ConfirmedDataDown, ConfirmedDataUp, UnconfirmedDataDown, UnconfirmedDataUp, Proprietary, RejoinRequest} {
 		t.Log(v.String())
 	}
 }
+
+func TestMACVersion(t *testing.T) {
+	for _, v := range []MACVersion{MACVersion10, MACVersion11} {
+		parsed, err := MACVersionFromString(v.String())
+		if err != nil || parsed != v {
+			t.Fatalf("Could not convert %s back to version: %v (got %d)", v, err, parsed)
+		}
+	}
+	if _, err := MACVersionFromString("1.2"); err == nil {
+		t.Fatal("Expected error with unknown version")
+	}
+}
@@ -35,7 +35,7 @@ func (p *PHYPayload) CalculateMIC(nwkSKey AESKey, message []byte) (uint32, error
 		b0[5] = 1
 	}
 	binary.LittleEndian.PutUint32(b0[6:], p.MACPayload.FHDR.DevAddr.ToUint32())
-	binary.LittleEndian.PutUint32(b0[10:], uint32(p.MACPayload.FHDR.FCnt))
+	binary.LittleEndian.PutUint32(b0[10:], p.MACPayload.FHDR.FullFCnt())
 	b0[14] = 0
 	b0[15] = byte(len(message))
 
@@ -44,6 +44,53 @@ func (p *PHYPayload) CalculateMIC(nwkSKey AESKey, message []byte) (uint32, error
 	return p.calculateMICFromBuffer(nwkSKey, fullMessage)
 }
 
+// micBlock returns the B0 (and B1) block used for the LoRaWAN 1.1 MIC
+// calculations [4.4]. The bytes 1-4 are filled in by the caller.
+func (p *PHYPayload) micBlock(message []byte) []byte {
+	b := make([]byte, 16)
+	b[0] = 0x49
+	if !p.MHDR.MType.Uplink() {
+		b[5] = 1
+	}
+	binary.LittleEndian.PutUint32(b[6:], p.MACPayload.FHDR.DevAddr.ToUint32())
+	binary.LittleEndian.PutUint32(b[10:], p.MACPayload.FHDR.FullFCnt())
+	b[15] = byte(len(message))
+	return b
+}
+
+// CalculateUplinkMIC11 calculates the MIC for uplink messages from LoRaWAN 1.1
+// devices. The first half of the MIC is calculated with the SNwkSIntKey and
+// the second half with the FNwkSIntKey. The confFCnt parameter is the frame
+// counter of the acknowledged downlink (or 0 if the ACK flag isn't set). The
+// txDR and txCh parameters are the data rate and channel index used for the
+// uplink [4.4].
+func (p *PHYPayload) CalculateUplinkMIC11(fNwkSIntKey, sNwkSIntKey AESKey, confFCnt uint16, txDR, txCh uint8, message []byte) (uint32, error) {
+	b0 := p.micBlock(message)
+	cmacF, err := cmac.AESCMAC(fNwkSIntKey.Key[:], append(b0, message...))
+	if err != nil {
+		return 0, err
+	}
+
+	b1 := p.micBlock(message)
+	binary.LittleEndian.PutUint16(b1[1:], confFCnt)
+	b1[3] = txDR
+	b1[4] = txCh
+	cmacS, err := cmac.AESCMAC(sNwkSIntKey.Key[:], append(b1, message...))
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32([]byte{cmacS[0], cmacS[1], cmacF[0], cmacF[1]}), nil
+}
+
+// CalculateDownlinkMIC11 calculates the MIC for downlink messages to LoRaWAN
+// 1.1 devices. The confFCnt parameter is the frame counter of the
+// acknowledged uplink (or 0 if the ACK flag isn't set) [4.4].
+func (p *PHYPayload) CalculateDownlinkMIC11(sNwkSIntKey AESKey, confFCnt uint16, message []byte) (uint32, error) {
+	b0 := p.micBlock(message)
+	binary.LittleEndian.PutUint16(b0[1:], confFCnt)
+	return p.calculateMICFromBuffer(sNwkSIntKey, append(b0, message...))
+}
+
 // calculateMICFromBuffer calculates a MIC using the given key and buffer.
 func (p *PHYPayload) calculateMICFromBuffer(key AESKey, payload []byte) (uint32, error) {
 	cmac, err := cmac.AESCMAC(key.Key[0:], payload)
@@ -64,6 +111,26 @@ func (p *PHYPayload) CalculateJoinAcceptMIC(appKey AESKey, payload []byte) (uint
 	return p.calculateMICFromBuffer(appKey, payload)
 }
 
+// CalculateJoinAcceptMIC11 calculates the JoinAccept MIC for LoRaWAN 1.1
+// devices. The MIC is calculated with the JSIntKey and includes the join
+// request type, the JoinEUI and the DevNonce from the request [6.2.3]:
+//    JoinReqType | JoinEUI | DevNonce | MHDR | JoinNonce | NetID | DevAddr | DLSettings | RxDelay | CFList
+func (p *PHYPayload) CalculateJoinAcceptMIC11(jsIntKey AESKey, joinReqType byte, joinEUI EUI, devNonce uint16, payload []byte) (uint32, error) {
+	buffer := make([]byte, 11, 11+len(payload))
+	buffer[0] = joinReqType
+	binary.LittleEndian.PutUint64(buffer[1:], joinEUI.ToUint64())
+	// DevNonce is big endian, as in the JoinRequest
+	binary.BigEndian.PutUint16(buffer[9:], devNonce)
+	return p.calculateMICFromBuffer(jsIntKey, append(buffer, payload...))
+}
+
+// CalculateRejoinRequestMIC calculates the MIC for Rejoin-request messages.
+// The payload is the message without the MIC [6.2.4.4]:
+//     MHDR | RejoinType | NetID or JoinEUI | DevEUI | RJcount
+func (p *PHYPayload) CalculateRejoinRequestMIC(key AESKey, payload []byte) (uint32, error) {
+	return p.calculateMICFromBuffer(key, payload)
+}
+
 // CalculateJoinRequestMIC calculates the JoinRequest MIC. The payload is the same payload as
 // the end-device sends to the network server (6.2.4):
 //     AppEUI | DevEUI | DevNonce
@@ -16,6 +16,7 @@ package protocol
 //limitations under the License.
 //
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"testing"
@@ -34,6 +35,65 @@ func TestVerifyMIC(t *testing.T) {
 	}
 }
 
+// The MIC and the encryption use the full 32 bit frame counter
+func TestMICWithFullFCnt(t *testing.T) {
+	appSKey, _ := AESKeyFromString("E001 2A22 25B8 585E DCEC 7042 4798 C510")
+	nwkSKey, _ := AESKeyFromString("3C5E 5C9F 469E EF3E 02CC D4FF 9531 31BA")
+	m1 := createUnencryptedTestMessage()
+	m1.encrypt(nwkSKey, appSKey)
+
+	m2 := createUnencryptedTestMessage()
+	m2.MACPayload.FHDR.FCntMSB = 1
+	m2.encrypt(nwkSKey, appSKey)
+
+	if m1.MIC == m2.MIC {
+		t.Fatal("Expected MIC to change when the upper 16 bits of the frame counter changes")
+	}
+	if string(m1.MACPayload.FRMPayload) == string(m2.MACPayload.FRMPayload) {
+		t.Fatal("Expected encrypted payload to change when the upper 16 bits of the frame counter changes")
+	}
+	m2.Decrypt(nwkSKey, appSKey)
+	if string(m2.MACPayload.FRMPayload) != string(createUnencryptedTestMessage().MACPayload.FRMPayload) {
+		t.Fatal("Payload didn't decrypt with the full frame counter")
+	}
+}
+
+// The LoRaWAN 1.1 uplink MIC is split in two. The first half is calculated
+// with the SNwkSIntKey and the second half with the FNwkSIntKey.
+func TestUplinkMIC11(t *testing.T) {
+	fNwkSIntKey, _ := AESKeyFromString("3C5E 5C9F 469E EF3E 02CC D4FF 9531 31BA")
+	sNwkSIntKey, _ := AESKeyFromString("E001 2A22 25B8 585E DCEC 7042 4798 C510")
+	m := createUnencryptedTestMessage()
+	buffer, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal("Could not marshal message: ", err)
+	}
+	message := buffer[0 : len(buffer)-4]
+
+	mic1, err := m.CalculateUplinkMIC11(fNwkSIntKey, sNwkSIntKey, 0, 5, 1, message)
+	if err != nil {
+		t.Fatal("Got error calculating MIC: ", err)
+	}
+	// Different data rate changes the first half
+	mic2, _ := m.CalculateUplinkMIC11(fNwkSIntKey, sNwkSIntKey, 0, 4, 1, message)
+	if mic1&0xFFFF == mic2&0xFFFF || mic1>>16 != mic2>>16 {
+		t.Fatalf("Expected just the first half of the MIC to change: %08x vs %08x", mic1, mic2)
+	}
+	// Using the same keys for both gives the same second half as the 1.0 MIC
+	mic10, _ := m.CalculateMIC(fNwkSIntKey, message)
+	if mic1>>16 != mic10&0xFFFF {
+		t.Fatalf("Expected second half of MIC to be the first half of the 1.0 MIC: %08x vs %08x", mic1, mic10)
+	}
+
+	// The downlink MIC includes the acknowledged frame counter
+	m.MHDR.MType = UnconfirmedDataDown
+	dn1, _ := m.CalculateDownlinkMIC11(sNwkSIntKey, 0, message)
+	dn2, _ := m.CalculateDownlinkMIC11(sNwkSIntKey, 1, message)
+	if dn1 == dn2 {
+		t.Fatal("Expected downlink MIC to change with ConfFCnt")
+	}
+}
+
 // Mic should be 0xB08D7C07 (Big endian)
 var joinRequestBytes = []string{
 	"AAgHBgUEAwIBvrrvvr66774RXbCNfAc=",
@@ -80,6 +140,16 @@ func TestVerifyJoinRequestMIC(t *testing.T) {
 		if mic != existingMIC {
 			t.Fatalf("Calculated MIC does not match existing MIC. Got 0x%08x, expected 0x%08x", mic, existingMIC)
 		}
+
+		// Encoding the decoded message yields the same buffer
+		decoded := PHYPayload{}
+		if err := decoded.UnmarshalBinary(buffer); err != nil {
+			t.Fatal("Couldn't unmarshal JoinRequest: ", err)
+		}
+		encoded, err := decoded.EncodeJoinRequest(appKey)
+		if err != nil || !bytes.Equal(encoded, buffer) {
+			t.Fatalf("Encoded JoinRequest is different: %v != %v (err=%v)", encoded, buffer, err)
+		}
 	}
 }
 
@@ -24,11 +24,12 @@ import (
 
 // PHYPayload is the payload in the PHY frame
 type PHYPayload struct {
-	MHDR               MHDR       // [4.2]
-	MACPayload         MACPayload // [4.3]
-	JoinRequestPayload JoinRequestPayload
-	JoinAcceptPayload  JoinAcceptPayload
-	MIC                uint32 // [4.4]
+	MHDR                 MHDR       // [4.2]
+	MACPayload           MACPayload // [4.3]
+	JoinRequestPayload   JoinRequestPayload
+	JoinAcceptPayload    JoinAcceptPayload
+	RejoinRequestPayload RejoinRequestPayload
+	MIC                  uint32 // [4.4]
 }
 
 // MinimumMessageSize is the absolute minimum size for a LoRaWAN message. Messages
@@ -78,6 +79,9 @@ func (p *PHYPayload) UnmarshalBinary(data []byte) error {
 	if p.MHDR.MType == JoinAccept {
 		return p.JoinAcceptPayload.decode(data, &pos)
 	}
+	if p.MHDR.MType == RejoinRequest {
+		return p.RejoinRequestPayload.decode(data, &pos)
+	}
 	return ErrInvalidMessageType
 }
 
@@ -89,10 +93,29 @@ var ErrInvalidMIC = errors.New("invalid MIC")
 // The MIC will be updated and verified against the calculated MIC. If the
 // MIC is incorrect it will return ErrInvalidMIC
 func (p *PHYPayload) DecodeJoinAccept(aesKey AESKey, buffer []byte) error {
+	return p.decodeJoinAccept(aesKey, buffer, func(payload []byte) (uint32, error) {
+		return p.CalculateJoinAcceptMIC(aesKey, payload)
+	})
+}
+
+// DecodeJoinAccept11 decodes a JoinAccept message for a LoRaWAN 1.1 device.
+// The message is encrypted with the NwkKey and the MIC is calculated with the
+// JSIntKey. The JoinEUI and DevNonce parameters are the values sent in the
+// JoinRequest message [6.2.3].
+func (p *PHYPayload) DecodeJoinAccept11(nwkKey, jsIntKey AESKey, joinEUI EUI, devNonce uint16, buffer []byte) error {
+	return p.decodeJoinAccept(nwkKey, buffer, func(payload []byte) (uint32, error) {
+		return p.CalculateJoinAcceptMIC11(jsIntKey, JoinRequestType, joinEUI, devNonce, payload)
+	})
+}
+
+// micFunc calculates the MIC for a JoinAccept message
+type micFunc func(payload []byte) (uint32, error)
+
+func (p *PHYPayload) decodeJoinAccept(key AESKey, buffer []byte, calculateMIC micFunc) error {
 	if p.MHDR.MType != JoinAccept {
 		return ErrInvalidMessageType
 	}
-	cipher, err := aes.NewCipher(aesKey.Key[:])
+	cipher, err := aes.NewCipher(key.Key[:])
 	if err != nil {
 		return err
 	}
@@ -104,7 +127,10 @@ func (p *PHYPayload) DecodeJoinAccept(aesKey AESKey, buffer []byte) error {
 	copy(input, buffer[1:])
 	decrypted := make([]byte, paddedLen)
 
-	cipher.Encrypt(decrypted, input)
+	// The payload is encrypted in ECB mode, one block at a time
+	for i := 0; i < paddedLen; i += aes.BlockSize {
+		cipher.Encrypt(decrypted[i:], input[i:])
+	}
 
 	pos := 0
 	p.JoinAcceptPayload.AppNonce[0] = decrypted[pos+0]
@@ -123,9 +149,18 @@ func (p *PHYPayload) DecodeJoinAccept(aesKey AESKey, buffer []byte) error {
 		return err
 	}
 	p.JoinAcceptPayload.RxDelay = decrypted[pos]
+	pos++
+
+	// The CFList is optional. It is present if there's room for it before the MIC
+	p.JoinAcceptPayload.CFList = CFList{}
+	if len(buffer)-5-pos >= CFListLength {
+		if err := p.JoinAcceptPayload.CFList.decode(decrypted, &pos); err != nil {
+			return err
+		}
+	}
 
 	// The decrypted buffer shouldn't include the MHDR (1 byte) or the MIC (4 byte)
-	p.MIC, err = p.CalculateJoinAcceptMIC(aesKey, append(buffer[0:1], decrypted[0:len(buffer)-5]...))
+	p.MIC, err = calculateMIC(append(buffer[0:1], decrypted[0:len(buffer)-5]...))
 	// Check if the MIC in the decrypted buffer matches the MIC we found
 	bufferMIC := binary.LittleEndian.Uint32(decrypted[len(buffer)-5:])
 	if bufferMIC != p.MIC {
@@ -141,7 +176,7 @@ func (p *PHYPayload) DecodeJoinAccept(aesKey AESKey, buffer []byte) error {
 // as a result of this; most notably the FOptsLen field and the Port field. The payload must
 // be encrypted at this point.
 func (p *PHYPayload) MarshalBinary() ([]byte, error) {
-	if p.MHDR.MType == JoinAccept || p.MHDR.MType == JoinRequest || p.MHDR.MType == Proprietary {
+	if p.MHDR.MType == JoinAccept || p.MHDR.MType == JoinRequest || p.MHDR.MType == RejoinRequest || p.MHDR.MType == Proprietary {
 		return nil, ErrInvalidMessageType
 	}
 	count := 0
@@ -167,13 +202,48 @@ func (p *PHYPayload) MarshalBinary() ([]byte, error) {
 // EncodeJoinAccept encodes a complete JoinAccept message, including
 // MIC and encryption. The appKey parameter is the application key.
 func (p *PHYPayload) EncodeJoinAccept(appKey AESKey) ([]byte, error) {
+	return p.encodeJoinAccept(appKey, func(payload []byte) (uint32, error) {
+		return p.CalculateJoinAcceptMIC(appKey, payload)
+	})
+}
+
+// EncodeJoinAccept11 encodes a JoinAccept message for a LoRaWAN 1.1 device.
+// The message is encrypted with the NwkKey and the MIC is calculated with
+// the JSIntKey. The JoinEUI and DevNonce parameters are the values sent in
+// the JoinRequest message [6.2.3].
+func (p *PHYPayload) EncodeJoinAccept11(nwkKey, jsIntKey AESKey, joinEUI EUI, devNonce uint16) ([]byte, error) {
+	return p.encodeJoinAccept(nwkKey, func(payload []byte) (uint32, error) {
+		return p.CalculateJoinAcceptMIC11(jsIntKey, JoinRequestType, joinEUI, devNonce, payload)
+	})
+}
+
+// EncodeRejoinAccept encodes a JoinAccept message sent as a response to a
+// Rejoin-request. The message is encrypted with the JSEncKey and the MIC is
+// calculated with the JSIntKey. The rejoin type and RJcount are the values
+// sent in the Rejoin-request [6.2.3].
+func (p *PHYPayload) EncodeRejoinAccept(jsEncKey, jsIntKey AESKey, rejoinType byte, joinEUI EUI, rjCount uint16) ([]byte, error) {
+	return p.encodeJoinAccept(jsEncKey, func(payload []byte) (uint32, error) {
+		return p.CalculateJoinAcceptMIC11(jsIntKey, rejoinType, joinEUI, rjCountNonce(rjCount), payload)
+	})
+}
+
+// DecodeRejoinAccept decodes a JoinAccept message sent as a response to a
+// Rejoin-request. See EncodeRejoinAccept for the parameters.
+func (p *PHYPayload) DecodeRejoinAccept(jsEncKey, jsIntKey AESKey, rejoinType byte, joinEUI EUI, rjCount uint16, buffer []byte) error {
+	return p.decodeJoinAccept(jsEncKey, buffer, func(payload []byte) (uint32, error) {
+		return p.CalculateJoinAcceptMIC11(jsIntKey, rejoinType, joinEUI, rjCountNonce(rjCount), payload)
+	})
+}
+
+func (p *PHYPayload) encodeJoinAccept(key AESKey, calculateMIC micFunc) ([]byte, error) {
 	if p.MHDR.MType != JoinAccept {
 		return nil, ErrInvalidMessageType
 	}
 	var err error
 
-	// Maximum size of JoinAccept is 3+3+4+1+1+16=28 bytes [6.2.5]
-	buffer := make([]byte, 30)
+	// Maximum size of JoinAccept is 3+3+4+1+1+16=28 bytes [6.2.5] plus MHDR
+	// and MIC
+	buffer := make([]byte, 33)
 	pos := 0
 	if err = p.MHDR.encode(buffer, &pos); err != nil {
 		return nil, err
@@ -182,7 +252,7 @@ func (p *PHYPayload) EncodeJoinAccept(appKey AESKey) ([]byte, error) {
 		return nil, err
 	}
 
-	if p.MIC, err = p.CalculateJoinAcceptMIC(appKey, buffer[0:pos]); err != nil {
+	if p.MIC, err = calculateMIC(buffer[0:pos]); err != nil {
 		return nil, err
 	}
 
@@ -190,13 +260,16 @@ func (p *PHYPayload) EncodeJoinAccept(appKey AESKey) ([]byte, error) {
 	pos += 4
 
 	// Now do the decryption see [6.2.5]
-	cipher, err := aes.NewCipher(appKey.Key[:])
+	cipher, err := aes.NewCipher(key.Key[:])
 	if err != nil {
 		return nil, err
 	}
 	ret := make([]byte, pos)
-	ret[0] = buffer[0]                     // Use MHDR as is
-	cipher.Decrypt(ret[1:], buffer[1:pos]) // and encrypt payload + mic
+	ret[0] = buffer[0] // Use MHDR as is
+	// ...and encrypt payload + mic, one block at a time (ECB mode)
+	for i := 1; i < pos; i += aes.BlockSize {
+		cipher.Decrypt(ret[i:], buffer[i:pos])
+	}
 
 	return ret, nil
 }
@@ -208,8 +281,8 @@ func (p *PHYPayload) EncodeJoinRequest(appKey AESKey) ([]byte, error) {
 	}
 
 	count := 0
-	// JoinRequest is 1 (MHDR) + 18 (JoinRequest) + 5 (MIC) = 24 bytes
-	buf := make([]byte, 24)
+	// JoinRequest is 1 (MHDR) + 18 (JoinRequest) + 4 (MIC) = 23 bytes
+	buf := make([]byte, 23)
 
 	if err := p.MHDR.encode(buf, &count); err != nil {
 		return nil, err
@@ -218,7 +291,7 @@ func (p *PHYPayload) EncodeJoinRequest(appKey AESKey) ([]byte, error) {
 		return nil, err
 	}
 	var err error
-	if p.MIC, err = p.CalculateJoinRequestMIC(appKey, buf); err != nil {
+	if p.MIC, err = p.CalculateJoinRequestMIC(appKey, buf[0:count]); err != nil {
 		return nil, err
 	}
 
@@ -228,6 +301,28 @@ func (p *PHYPayload) EncodeJoinRequest(appKey AESKey) ([]byte, error) {
 	return buf, nil
 }
 
+// EncodeRejoinRequest encodes a Rejoin-request message. Type 0 and 2 requests
+// use the SNwkSIntKey for the MIC and type 1 requests use the JSIntKey [6.2.4].
+func (p *PHYPayload) EncodeRejoinRequest(key AESKey) ([]byte, error) {
+	if p.MHDR.MType != RejoinRequest {
+		return nil, ErrInvalidMessageType
+	}
+	count := 0
+	buf := make([]byte, 1+p.RejoinRequestPayload.Length()+4)
+	if err := p.MHDR.encode(buf, &count); err != nil {
+		return nil, err
+	}
+	if err := p.RejoinRequestPayload.encode(buf, &count); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.MIC, err = p.CalculateRejoinRequestMIC(key, buf[0:count]); err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(buf[count:], p.MIC)
+	return buf, nil
+}
+
 // EncodeMessage encrypts and adds MIC for the message.
 func (p *PHYPayload) EncodeMessage(nwkSKey AESKey, appSKey AESKey) ([]byte, error) {
 	if err := p.encrypt(nwkSKey, appSKey); err != nil {
@@ -236,6 +331,76 @@ func (p *PHYPayload) EncodeMessage(nwkSKey AESKey, appSKey AESKey) ([]byte, erro
 	return p.MarshalBinary()
 }
 
+// EncodeMessage11 encrypts and adds the MIC for downlink messages to LoRaWAN
+// 1.1 devices. The FOpts field and payloads on port 0 are encrypted with the
+// NwkSEncKey. The confFCnt parameter is the frame counter of the
+// uplink acknowledged by the message [4.3.1.6], [4.4].
+func (p *PHYPayload) EncodeMessage11(sNwkSIntKey, nwkSEncKey, appSKey AESKey, confFCnt uint16) ([]byte, error) {
+	if p.MHDR.MType.Uplink() {
+		return nil, ErrInvalidMessageType
+	}
+	p.Decrypt(nwkSEncKey, appSKey)
+	return p.encodeMessage11(sNwkSIntKey, nwkSEncKey, confFCnt)
+}
+
+// EncodeEncryptedMessage adds the MIC for a message where the application
+// payload is already encrypted with the AppSKey by the application, ie when
+// the network server doesn't know the AppSKey. Payloads on port 0 are
+// encrypted with the NwkSKey as usual.
+func (p *PHYPayload) EncodeEncryptedMessage(nwkSKey AESKey) ([]byte, error) {
+	if p.MACPayload.FPort == 0 {
+		p.cryptFRMPayload(nwkSKey)
+	}
+	buf, err := p.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 4 {
+		return nil, ErrBufferTruncated
+	}
+	if p.MIC, err = p.CalculateMIC(nwkSKey, buf[0:len(buf)-4]); err != nil {
+		return nil, err
+	}
+	return p.MarshalBinary()
+}
+
+// EncodeEncryptedMessage11 is the LoRaWAN 1.1 version of
+// EncodeEncryptedMessage.
+func (p *PHYPayload) EncodeEncryptedMessage11(sNwkSIntKey, nwkSEncKey AESKey, confFCnt uint16) ([]byte, error) {
+	if p.MHDR.MType.Uplink() {
+		return nil, ErrInvalidMessageType
+	}
+	if p.MACPayload.FPort == 0 {
+		p.cryptFRMPayload(nwkSEncKey)
+	}
+	return p.encodeMessage11(sNwkSIntKey, nwkSEncKey, confFCnt)
+}
+
+// encodeMessage11 encrypts the FOpts field and adds the MIC for 1.1 downlinks.
+// The FRMPayload must be encrypted at this point.
+func (p *PHYPayload) encodeMessage11(sNwkSIntKey, nwkSEncKey AESKey, confFCnt uint16) ([]byte, error) {
+	buf, err := p.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	// The FOpts field follows the MHDR (1 byte), DevAddr (4), FCtrl (1) and FCnt (2)
+	const fOptsStart = 8
+	fOptsEnd := fOptsStart + int(p.MACPayload.FHDR.FCtrl.FOptsLen)
+	copy(buf[fOptsStart:], p.MACPayload.FHDR.cryptFOpts(nwkSEncKey, false, buf[fOptsStart:fOptsEnd]))
+
+	if p.MIC, err = p.CalculateDownlinkMIC11(sNwkSIntKey, confFCnt, buf[0:len(buf)-4]); err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(buf[len(buf)-4:], p.MIC)
+	return buf, nil
+}
+
+// DecryptFOpts decrypts the FOpts field in messages from LoRaWAN 1.1 devices
+// and decodes the MAC commands in the field [4.3.1.6].
+func (p *PHYPayload) DecryptFOpts(nwkSEncKey AESKey) error {
+	return p.MACPayload.FHDR.decryptFOpts(nwkSEncKey, p.MHDR.MType.Uplink())
+}
+
 // Encrypt encrypts message according to [4.3.3.1]
 func (p *PHYPayload) encrypt(nwkSKey AESKey, appSKey AESKey) error {
 	p.Decrypt(nwkSKey, appSKey)
@@ -253,13 +418,15 @@ func (p *PHYPayload) encrypt(nwkSKey AESKey, appSKey AESKey) error {
 
 // Decrypt decrypts message according to [4.3.3.1]
 func (p *PHYPayload) Decrypt(nwkSKey AESKey, appSKey AESKey) {
-	var key *AESKey
 	if p.MACPayload.FPort == 0 {
-		key = &nwkSKey
+		p.cryptFRMPayload(nwkSKey)
 	} else {
-		key = &appSKey
+		p.cryptFRMPayload(appSKey)
 	}
+}
 
+// cryptFRMPayload encrypts or decrypts the FRMPayload field with the key
+func (p *PHYPayload) cryptFRMPayload(key AESKey) {
 	k := int(math.Ceil(float64(len(p.MACPayload.FRMPayload)) / 16))
 
 	var S []byte
@@ -270,7 +437,7 @@ func (p *PHYPayload) Decrypt(nwkSKey AESKey, appSKey AESKey) {
 			A[5] = 1
 		}
 		binary.LittleEndian.PutUint32(A[6:], p.MACPayload.FHDR.DevAddr.ToUint32())
-		binary.LittleEndian.PutUint32(A[10:], uint32(p.MACPayload.FHDR.FCnt))
+		binary.LittleEndian.PutUint32(A[10:], p.MACPayload.FHDR.FullFCnt())
 
 		A[15] = byte(i + 1)
 
@@ -16,7 +16,9 @@ package protocol
 //limitations under the License.
 //
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"reflect"
 	"testing"
 
@@ -66,6 +68,35 @@ func TestDecodeJoinAccept(t *testing.T) {
 	}
 }
 
+func TestDecodeJoinAcceptWithCFList(t *testing.T) {
+	aesKey, _ := AESKeyFromString("01020304 05060708 01020304 05060708")
+	input := PHYPayload{
+		MHDR: MHDR{MType: JoinAccept, MajorVersion: MaxSupportedVersion},
+		JoinAcceptPayload: JoinAcceptPayload{
+			AppNonce: [3]byte{0, 1, 2},
+			NetID:    0x00010203,
+			DevAddr:  DevAddr{NwkID: 1, NwkAddr: 2},
+			RxDelay:  1,
+			CFList:   CFList{Frequencies: [CFListChannels]uint32{8671000, 8673000, 8675000, 8677000, 8679000}},
+		},
+	}
+	buffer, err := input.EncodeJoinAccept(aesKey)
+	if err != nil {
+		t.Fatal("Got error encoding JoinAccept: ", err)
+	}
+	if len(buffer) != 33 {
+		t.Fatalf("Expected 33 bytes for JoinAccept with CFList but got %d", len(buffer))
+	}
+
+	payload := PHYPayload{MHDR: MHDR{MType: JoinAccept, MajorVersion: MaxSupportedVersion}}
+	if err = payload.DecodeJoinAccept(aesKey, buffer); err != nil {
+		t.Fatal("Got error decoding JoinAccept payload: ", err)
+	}
+	if payload.JoinAcceptPayload != input.JoinAcceptPayload {
+		t.Fatalf("Not the same output as input in: %v out: %v", input.JoinAcceptPayload, payload.JoinAcceptPayload)
+	}
+}
+
 func TestDecodeShortBuffers(t *testing.T) {
 	p := NewPHYPayload(UnconfirmedDataUp)
 
@@ -607,6 +638,206 @@ func TestEncodeJoinAccept(t *testing.T) {
 	}
 }
 
+func TestEncodeJoinAccept11(t *testing.T) {
+	nwkKey, _ := AESKeyFromString("00010203 04050607 00010203 04050607")
+	joinEUI := EUIFromUint64(0x0102030405060708)
+	jsIntKey, _ := JSIntKeyFromDevEUI(nwkKey, EUIFromUint64(0x1112131415161718))
+
+	p := NewPHYPayload(JoinAccept)
+	p.JoinAcceptPayload = JoinAcceptPayload{
+		AppNonce:   [3]byte{0, 0, 1},
+		NetID:      0x010203,
+		DevAddr:    DevAddr{NwkID: 1, NwkAddr: 2},
+		DLSettings: DLSettings{OptNeg: true, RX1DRoffset: 3, RX2DataRate: 4},
+		RxDelay:    5,
+	}
+	buffer, err := p.EncodeJoinAccept11(nwkKey, jsIntKey, joinEUI, 0x0102)
+	if err != nil {
+		t.Fatal("Got error encoding JoinAccept: ", err)
+	}
+
+	p2 := NewPHYPayload(JoinAccept)
+	if err := p2.DecodeJoinAccept11(nwkKey, jsIntKey, joinEUI, 0x0102, buffer); err != nil {
+		t.Fatal("Got error decoding JoinAccept: ", err)
+	}
+	if p2.JoinAcceptPayload != p.JoinAcceptPayload {
+		t.Fatalf("Decoded JoinAccept is different: %+v != %+v", p2.JoinAcceptPayload, p.JoinAcceptPayload)
+	}
+	// The MIC depends on the DevNonce in the request
+	if err := p2.DecodeJoinAccept11(nwkKey, jsIntKey, joinEUI, 0x0103, buffer); err != ErrInvalidMIC {
+		t.Fatal("Expected invalid MIC with a different DevNonce but got ", err)
+	}
+	// ...and 1.0 devices can't verify it
+	if err := p2.DecodeJoinAccept(nwkKey, buffer); err != ErrInvalidMIC {
+		t.Fatal("Expected invalid MIC when decoding as a 1.0 JoinAccept but got ", err)
+	}
+}
+
+func TestRejoinRequestAndAccept(t *testing.T) {
+	nwkKey, _ := AESKeyFromString("00010203 04050607 00010203 04050607")
+	devEUI := EUIFromUint64(0x1112131415161718)
+	joinEUI := EUIFromUint64(0x0102030405060708)
+	jsIntKey, _ := JSIntKeyFromDevEUI(nwkKey, devEUI)
+	jsEncKey, _ := JSEncKeyFromDevEUI(nwkKey, devEUI)
+
+	p := NewPHYPayload(RejoinRequest)
+	p.RejoinRequestPayload = RejoinRequestPayload{RejoinType: RejoinType1, JoinEUI: joinEUI, DevEUI: devEUI, RJCount: 7}
+	buffer, err := p.EncodeRejoinRequest(jsIntKey)
+	if err != nil {
+		t.Fatal("Got error encoding Rejoin-request: ", err)
+	}
+	if len(buffer) != 24 {
+		t.Fatalf("Expected 24 bytes for type 1 rejoin but got %d", len(buffer))
+	}
+	p2 := PHYPayload{}
+	if err := p2.UnmarshalBinary(buffer); err != nil {
+		t.Fatal("Got error decoding Rejoin-request: ", err)
+	}
+	if p2.MHDR.MType != RejoinRequest || p2.RejoinRequestPayload != p.RejoinRequestPayload || p2.MIC != p.MIC {
+		t.Fatalf("Decoded Rejoin-request is different: %+v != %+v", p2, p)
+	}
+	if !p2.MHDR.MType.Uplink() {
+		t.Fatal("Rejoin-request should be an uplink message")
+	}
+	mic, _ := p2.CalculateRejoinRequestMIC(jsIntKey, buffer[0:len(buffer)-4])
+	if mic != p2.MIC {
+		t.Fatal("MIC for Rejoin-request does not match")
+	}
+	if _, err := p2.MarshalBinary(); err != ErrInvalidMessageType {
+		t.Fatal("Expected error when marshaling Rejoin-request as a data message")
+	}
+
+	ja := NewPHYPayload(JoinAccept)
+	ja.JoinAcceptPayload = JoinAcceptPayload{
+		AppNonce:   [3]byte{2, 0, 0},
+		NetID:      0x010203,
+		DevAddr:    DevAddr{NwkID: 1, NwkAddr: 2},
+		DLSettings: DLSettings{OptNeg: true},
+		RxDelay:    1,
+	}
+	buffer, err = ja.EncodeRejoinAccept(jsEncKey, jsIntKey, RejoinType1, joinEUI, 7)
+	if err != nil {
+		t.Fatal("Got error encoding JoinAccept: ", err)
+	}
+	ja2 := NewPHYPayload(JoinAccept)
+	if err := ja2.DecodeRejoinAccept(jsEncKey, jsIntKey, RejoinType1, joinEUI, 7, buffer); err != nil {
+		t.Fatal("Got error decoding JoinAccept: ", err)
+	}
+	if ja2.JoinAcceptPayload != ja.JoinAcceptPayload {
+		t.Fatalf("Decoded JoinAccept is different: %+v != %+v", ja2.JoinAcceptPayload, ja.JoinAcceptPayload)
+	}
+	// The MIC includes the rejoin type
+	if err := ja2.DecodeRejoinAccept(jsEncKey, jsIntKey, RejoinType2, joinEUI, 7, buffer); err != ErrInvalidMIC {
+		t.Fatal("Expected invalid MIC with a different rejoin type but got ", err)
+	}
+}
+
+// Messages to LoRaWAN 1.1 devices have encrypted FOpts fields
+func TestEncodeMessage11(t *testing.T) {
+	sNwkSIntKey, _ := AESKeyFromString("3C5E 5C9F 469E EF3E 02CC D4FF 9531 31BA")
+	nwkSEncKey, _ := AESKeyFromString("E001 2A22 25B8 585E DCEC 7042 4798 C510")
+	appSKey, _ := AESKeyFromString("0102 0304 0506 0708 0102 0304 0506 0708")
+
+	newMessage := func() PHYPayload {
+		p := NewPHYPayload(UnconfirmedDataDown)
+		p.MACPayload.FHDR.DevAddr = DevAddrFromUint32(0x01020304)
+		p.MACPayload.FHDR.SetFullFCnt(0x10002)
+		p.MACPayload.FHDR.FOpts.Add(NewDownlinkMACCommand(DevStatusReq))
+		p.MACPayload.FHDR.FOpts.Add(&MACRekeyConf{macBase{RekeyConf, false}, MACVersion11})
+		p.MACPayload.FPort = 1
+		p.MACPayload.FRMPayload = []byte{1, 2, 3, 4}
+		return p
+	}
+
+	p := newMessage()
+	buffer, err := p.EncodeMessage11(sNwkSIntKey, nwkSEncKey, appSKey, 0)
+	if err != nil {
+		t.Fatal("Got error encoding message: ", err)
+	}
+	plain := newMessage()
+	plainBuffer, _ := plain.MarshalBinary()
+	if string(buffer[8:11]) == string(plainBuffer[8:11]) {
+		t.Fatal("FOpts field isn't encrypted")
+	}
+
+	mic, _ := p.CalculateDownlinkMIC11(sNwkSIntKey, 0, buffer[0:len(buffer)-4])
+	if mic != binary.LittleEndian.Uint32(buffer[len(buffer)-4:]) {
+		t.Fatal("MIC doesn't match")
+	}
+
+	p2 := NewPHYPayload(UnconfirmedDataDown)
+	if err := p2.UnmarshalBinary(buffer); err != nil {
+		t.Fatal("Could not unmarshal message: ", err)
+	}
+	p2.MACPayload.FHDR.FCntMSB = 1
+	if err := p2.DecryptFOpts(nwkSEncKey); err != nil {
+		t.Fatal("Could not decrypt FOpts: ", err)
+	}
+	compareMACCommands("FOpts", &p2.MACPayload.FHDR.FOpts, &plain.MACPayload.FHDR.FOpts, t)
+	p2.Decrypt(nwkSEncKey, appSKey)
+	if string(p2.MACPayload.FRMPayload) != string([]byte{1, 2, 3, 4}) {
+		t.Fatalf("Payload didn't decrypt: %v", p2.MACPayload.FRMPayload)
+	}
+
+	// Uplinks can't be encoded
+	up := NewPHYPayload(UnconfirmedDataUp)
+	if _, err := up.EncodeMessage11(sNwkSIntKey, nwkSEncKey, appSKey, 0); err != ErrInvalidMessageType {
+		t.Fatal("Expected error when encoding uplink")
+	}
+}
+
+// Messages with payloads encrypted by the application should be identical to
+// messages encrypted by the server.
+func TestEncodeEncryptedMessage(t *testing.T) {
+	nwkSKey, _ := AESKeyFromString("3C5E 5C9F 469E EF3E 02CC D4FF 9531 31BA")
+	nwkSEncKey, _ := AESKeyFromString("E001 2A22 25B8 585E DCEC 7042 4798 C510")
+	appSKey, _ := AESKeyFromString("0102 0304 0506 0708 0102 0304 0506 0708")
+
+	newMessage := func() PHYPayload {
+		p := NewPHYPayload(UnconfirmedDataDown)
+		p.MACPayload.FHDR.DevAddr = DevAddrFromUint32(0x01020304)
+		p.MACPayload.FHDR.SetFullFCnt(0x10002)
+		p.MACPayload.FHDR.FOpts.Add(NewDownlinkMACCommand(DevStatusReq))
+		p.MACPayload.FPort = 1
+		p.MACPayload.FRMPayload = []byte{1, 2, 3, 4}
+		return p
+	}
+	// The application encrypts the payload with the AppSKey
+	newEncryptedMessage := func() PHYPayload {
+		p := newMessage()
+		p.Decrypt(nwkSKey, appSKey)
+		return p
+	}
+
+	p := newMessage()
+	expected, err := p.EncodeMessage(nwkSKey, appSKey)
+	if err != nil {
+		t.Fatal("Got error encoding message: ", err)
+	}
+	e := newEncryptedMessage()
+	buffer, err := e.EncodeEncryptedMessage(nwkSKey)
+	if err != nil {
+		t.Fatal("Got error encoding encrypted message: ", err)
+	}
+	if !bytes.Equal(expected, buffer) {
+		t.Fatalf("Encrypted message is %x, expected %x", buffer, expected)
+	}
+
+	p = newMessage()
+	expected, err = p.EncodeMessage11(nwkSKey, nwkSEncKey, appSKey, 0)
+	if err != nil {
+		t.Fatal("Got error encoding 1.1 message: ", err)
+	}
+	e = newEncryptedMessage()
+	buffer, err = e.EncodeEncryptedMessage11(nwkSKey, nwkSEncKey, 0)
+	if err != nil {
+		t.Fatal("Got error encoding encrypted 1.1 message: ", err)
+	}
+	if !bytes.Equal(expected, buffer) {
+		t.Fatalf("Encrypted 1.1 message is %x, expected %x", buffer, expected)
+	}
+}
+
 // Proprietary and JoinAccept/JoinRequest messages can't be marshaled by
 // MarshalBinary
 func TestUnmarshableMessageTypes(t *testing.T) {
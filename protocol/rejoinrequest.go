@@ -0,0 +1,128 @@
+package protocol
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Rejoin-request types. The type is also used as the JoinReqType in the
+// JoinAccept MIC when the JoinAccept is a response to a Rejoin-request [6.2.4].
+const (
+	// RejoinType0 resets the device context, including the radio parameters
+	RejoinType0 byte = 0x00
+	// RejoinType1 restores a lost session context. The message is identical
+	// to the JoinRequest.
+	RejoinType1 byte = 0x01
+	// RejoinType2 rekeys the device. The radio parameters are kept.
+	RejoinType2 byte = 0x02
+)
+
+// RejoinRequestPayload is the payload sent by LoRaWAN 1.1 devices in a
+// Rejoin-request message [6.2.4]. Type 0 and 2 requests include the NetID
+// while type 1 requests include the JoinEUI. The message is not encrypted.
+type RejoinRequestPayload struct {
+	RejoinType byte
+	NetID      uint32 // Type 0 and 2 only
+	JoinEUI    EUI    // Type 1 only
+	DevEUI     EUI
+	RJCount    uint16 // RJcount0 for type 0 and 2, RJcount1 for type 1
+}
+
+// Length returns the length of the encoded payload
+func (r *RejoinRequestPayload) Length() int {
+	if r.RejoinType == RejoinType1 {
+		return 19
+	}
+	return 14
+}
+
+// KeyNonce returns the RJcount in the same form as the DevNonce in the
+// JoinRequest. The RJcount replaces the DevNonce when the session keys and the
+// JoinAccept MIC are calculated and these use the bytes as they are sent by
+// the device.
+func (r *RejoinRequestPayload) KeyNonce() uint16 {
+	return rjCountNonce(r.RJCount)
+}
+
+// rjCountNonce converts a RJcount into the DevNonce form. The DevNonce is
+// decoded big endian while the RJcount is little endian.
+func rjCountNonce(rjCount uint16) uint16 {
+	return bits.ReverseBytes16(rjCount)
+}
+
+// Decode Rejoin-request payload from a byte buffer.
+func (r *RejoinRequestPayload) decode(buffer []byte, pos *int) error {
+	if buffer == nil || pos == nil {
+		return ErrNilError
+	}
+	if len(buffer) <= *pos {
+		return ErrBufferTruncated
+	}
+	r.RejoinType = buffer[*pos]
+	if r.RejoinType > RejoinType2 {
+		return ErrInvalidMessageType
+	}
+	if len(buffer) < (*pos + r.Length()) {
+		return ErrBufferTruncated
+	}
+	*pos++
+	if r.RejoinType == RejoinType1 {
+		r.JoinEUI = EUIFromUint64(binary.LittleEndian.Uint64(buffer[*pos:]))
+		*pos += 8
+	} else {
+		// NetID uses the same byte order as in the JoinAccept message
+		r.NetID = uint32(buffer[*pos+0])<<16 + uint32(buffer[*pos+1])<<8 + uint32(buffer[*pos+2])
+		*pos += 3
+	}
+	r.DevEUI = EUIFromUint64(binary.LittleEndian.Uint64(buffer[*pos:]))
+	*pos += 8
+
+	r.RJCount = binary.LittleEndian.Uint16(buffer[*pos:])
+	*pos += 2
+	return nil
+}
+
+// Encode the Rejoin-request into a buffer
+func (r *RejoinRequestPayload) encode(buffer []byte, pos *int) error {
+	if buffer == nil || pos == nil {
+		return ErrNilError
+	}
+	if r.RejoinType > RejoinType2 {
+		return ErrInvalidMessageType
+	}
+	if len(buffer) < (*pos + r.Length()) {
+		return ErrBufferTruncated
+	}
+	buffer[*pos] = r.RejoinType
+	*pos++
+	if r.RejoinType == RejoinType1 {
+		binary.LittleEndian.PutUint64(buffer[*pos:], r.JoinEUI.ToUint64())
+		*pos += 8
+	} else {
+		buffer[*pos+0] = byte(r.NetID >> 16)
+		buffer[*pos+1] = byte(r.NetID >> 8)
+		buffer[*pos+2] = byte(r.NetID)
+		*pos += 3
+	}
+	binary.LittleEndian.PutUint64(buffer[*pos:], r.DevEUI.ToUint64())
+	*pos += 8
+
+	binary.LittleEndian.PutUint16(buffer[*pos:], r.RJCount)
+	*pos += 2
+	return nil
+}
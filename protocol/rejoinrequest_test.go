@@ -0,0 +1,76 @@
+package protocol
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+)
+
+func TestEncodeDecodeRejoinRequest(t *testing.T) {
+	for _, rr1 := range []RejoinRequestPayload{
+		{RejoinType: RejoinType0, NetID: 0x010203, DevEUI: EUIFromUint64(0x0807060504030201), RJCount: 0x1234},
+		{RejoinType: RejoinType1, JoinEUI: EUIFromUint64(0x0102030405060708), DevEUI: EUIFromUint64(0x0807060504030201), RJCount: 2},
+		{RejoinType: RejoinType2, NetID: 0x030201, DevEUI: EUIFromUint64(0x0807060504030201), RJCount: 0xFFFF},
+	} {
+		buf := make([]byte, rr1.Length())
+		pos := 0
+		if err := rr1.encode(buf, &pos); err != nil {
+			t.Fatalf("Couldn't encode: %v", err)
+		}
+		if pos != rr1.Length() {
+			t.Fatalf("Expected %d bytes but encoded %d for type %d", rr1.Length(), pos, rr1.RejoinType)
+		}
+		pos = 0
+		rr2 := RejoinRequestPayload{}
+		if err := rr2.decode(buf, &pos); err != nil {
+			t.Fatalf("Couldn't decode: %v", err)
+		}
+		if rr1 != rr2 {
+			t.Fatalf("Encoded and decoded aren't equal: %+v != %+v", rr1, rr2)
+		}
+		// Truncated buffers are rejected
+		pos = 0
+		if err := rr2.decode(buf[0:len(buf)-1], &pos); err != ErrBufferTruncated {
+			t.Fatalf("Expected truncated buffer error but got %v", err)
+		}
+	}
+
+	// The RJcount is little endian on the air. The key nonce uses the bytes
+	// as they are sent, like the DevNonce.
+	rr := RejoinRequestPayload{RejoinType: RejoinType0, RJCount: 0x0102}
+	buf := make([]byte, rr.Length())
+	pos := 0
+	if err := rr.encode(buf, &pos); err != nil {
+		t.Fatal(err)
+	}
+	if buf[12] != 0x02 || buf[13] != 0x01 {
+		t.Fatalf("RJcount isn't little endian: %v", buf[12:])
+	}
+	if rr.KeyNonce() != 0x0201 {
+		t.Fatalf("Unexpected key nonce: %04x", rr.KeyNonce())
+	}
+
+	// Unknown rejoin types are rejected
+	pos = 0
+	rr = RejoinRequestPayload{}
+	if err := rr.decode([]byte{3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, &pos); err == nil {
+		t.Fatal("Expected error with rejoin type 3")
+	}
+	pos = 0
+	if err := rr.decode(nil, &pos); err == nil {
+		t.Fatal("Expected error with nil buffer")
+	}
+}
@@ -18,6 +18,8 @@ package restapi
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -27,6 +29,7 @@ import (
 
 	"golang.org/x/net/websocket"
 
+	"github.com/ExploratoryEngineering/congress/frequency"
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
@@ -37,6 +40,38 @@ import (
 // The maximum number of data packets to return from the .../data endpoint
 const defaultMaxDeviceDataCount int = 50
 
+// maxChannelFrequency is the highest frequency (in 100 Hz steps) that can be
+// used for a channel
+const maxChannelFrequency = 1<<24 - 1
+
+// checkChannels validates the channel list for an application. The list can't
+// hold more than the maximum number of channels and the frequencies must fit
+// into the 24 bit frequency fields in the MAC commands [5.6]
+func checkChannels(channels []apiChannel) error {
+	if len(channels) > model.MaxChannels {
+		return fmt.Errorf("channels can't hold more than %d channels", model.MaxChannels)
+	}
+	for _, v := range channels {
+		if v.Frequency < 0 || v.Downlink < 0 {
+			return errors.New("channel frequencies can't be negative")
+		}
+		if frequency.Steps(v.Frequency) > maxChannelFrequency || frequency.Steps(v.Downlink) > maxChannelFrequency {
+			return errors.New("channel frequency is out of range")
+		}
+	}
+	return nil
+}
+
+// checkKEKLabel validates the key encryption key label for applications with
+// end-to-end encryption. The join server wraps the AppSKey with the KEK when
+// devices join. The KEK itself is never stored by the server.
+func checkKEKLabel(label string) error {
+	if label == "" {
+		return errors.New("kekLabel must be set for end-to-end encryption")
+	}
+	return nil
+}
+
 // Read application from request body. Emits error message to client if there's an error
 func (s *Server) readAppFromRequest(w http.ResponseWriter, r *http.Request) (apiApplication, error) {
 	buf, err := ioutil.ReadAll(r.Body)
@@ -62,6 +97,20 @@ func (s *Server) createApplication(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if application.DeviceStatusInterval < 0 {
+		http.Error(w, "deviceStatusInterval can't be negative", http.StatusBadRequest)
+		return
+	}
+	if err := checkChannels(application.Channels); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if application.EndToEnd {
+		if err := checkKEKLabel(application.KEKLabel); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	var overrideEUI bool
 	if application.ApplicationEUI != "" {
@@ -126,7 +175,6 @@ func (s *Server) createApplication(w http.ResponseWriter, r *http.Request) {
 	if application.Tags == nil {
 		application.Tags = make(map[string]string)
 	}
-
 	monitoring.ApplicationCreated.Increment()
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -227,6 +275,49 @@ func (s *Server) applicationInfoHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		if interval, ok := values["deviceStatusInterval"].(float64); ok {
+			if interval < 0 {
+				http.Error(w, "deviceStatusInterval can't be negative", http.StatusBadRequest)
+				return
+			}
+			application.DeviceStatusInterval = time.Duration(interval) * time.Second
+		}
+
+		if value, ok := values["channels"]; ok {
+			var channels []apiChannel
+			buf, err := json.Marshal(value)
+			if err == nil {
+				err = json.Unmarshal(buf, &channels)
+			}
+			if err != nil {
+				http.Error(w, "Invalid channel list", http.StatusBadRequest)
+				return
+			}
+			if err := checkChannels(channels); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			application.Channels = channelsToModel(channels)
+		}
+
+		// Devices that have joined have session keys for the current mode so
+		// it can't be changed. The KEK label can be replaced.
+		if endToEnd, ok := values["endToEnd"].(bool); ok && endToEnd != application.EndToEnd {
+			http.Error(w, "endToEnd can't be changed", http.StatusBadRequest)
+			return
+		}
+		if label, ok := values["kekLabel"].(string); ok {
+			if !application.EndToEnd {
+				http.Error(w, "kekLabel can only be set for applications with end-to-end encryption", http.StatusBadRequest)
+				return
+			}
+			if err := checkKEKLabel(label); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			application.KEKLabel = label
+		}
+
 		if !s.updateTags(&application.Tags, values) {
 			http.Error(w, "Invalid tag value", http.StatusBadRequest)
 			return
@@ -356,6 +447,20 @@ func (s *Server) applicationWebsocketHandler(ws *websocket.Conn) {
 	for {
 		select {
 		case p := <-ch:
+			if status, ok := p.(*server.DownstreamStatus); ok {
+				downstream := newDownstreamMessageFromModel(status.Message)
+				if err := json.NewEncoder(ws).Encode(newWSDownstreamStatus(&downstream)); err != nil {
+					return
+				}
+				continue
+			}
+			if join, ok := p.(*server.JoinMessage); ok {
+				event := newJoinEventFromMessage(join)
+				if err := json.NewEncoder(ws).Encode(newWSJoin(&event)); err != nil {
+					return
+				}
+				continue
+			}
 			message, ok := p.(*server.PayloadMessage)
 			if !ok {
 				logging.Error("Expected type %T on channel but got the type %T. Publisher error?", message, p)
@@ -372,6 +477,10 @@ func (s *Server) applicationWebsocketHandler(ws *websocket.Conn) {
 				Frequency:  message.FrameContext.GatewayContext.Radio.Frequency,
 				DataRate:   message.FrameContext.GatewayContext.Radio.DataRate,
 				GatewayEUI: message.FrameContext.GatewayContext.Gateway.GatewayEUI.String(),
+				Port:       message.Port,
+				FCnt:       message.FCnt,
+				Encrypted:  message.Encrypted,
+				Gateways:   newGatewayReceptions(message.FrameContext.Receptions),
 			},
 			)
 
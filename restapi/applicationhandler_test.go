@@ -233,6 +233,24 @@ func TestApplicationInfoEndpoint(t *testing.T) {
 	genericPutRequest(t, appURL, map[string]interface{}{
 		"tags": map[string]interface{}{"name": true, "value": 12},
 	}, http.StatusBadRequest)
+	genericPutRequest(t, appURL, map[string]interface{}{
+		"deviceStatusInterval": 3600,
+	}, http.StatusOK)
+	genericPutRequest(t, appURL, map[string]interface{}{
+		"deviceStatusInterval": -1,
+	}, http.StatusBadRequest)
+	genericPutRequest(t, appURL, map[string]interface{}{
+		"channels": []map[string]interface{}{{"frequency": 867.1}, {"frequency": 867.3, "downlink": 869.1}},
+	}, http.StatusOK)
+	genericPutRequest(t, appURL, map[string]interface{}{
+		"channels": "867.1",
+	}, http.StatusBadRequest)
+	genericPutRequest(t, appURL, map[string]interface{}{
+		"channels": []map[string]interface{}{{"frequency": -1}},
+	}, http.StatusBadRequest)
+	genericPutRequest(t, appURL, map[string]interface{}{
+		"channels": make([]map[string]interface{}, 17),
+	}, http.StatusBadRequest)
 	testDelete(t, map[string]int{
 		rootURL + "/" + application.ApplicationEUI: http.StatusNoContent,
 		rootURL + "/11-22-33-44-55-66-77-88":       http.StatusNotFound,
@@ -240,6 +258,59 @@ func TestApplicationInfoEndpoint(t *testing.T) {
 
 }
 
+// Applications with end-to-end encryption must have a KEK label and
+// downstream messages must have a frame counter.
+func TestApplicationEndToEnd(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	rootURL := h.loopbackURL() + "/applications"
+	storeApplication(t, apiApplication{EndToEnd: true}, rootURL, http.StatusBadRequest)
+	application := storeApplication(t, apiApplication{EndToEnd: true, KEKLabel: "kek"}, rootURL, http.StatusCreated)
+	if !application.EndToEnd || application.KEKLabel != "kek" {
+		t.Fatalf("Unexpected application: %+v", application)
+	}
+	eui, _ := protocol.EUIFromString(application.ApplicationEUI)
+	stored, err := h.context.Storage.Application.GetByEUI(eui, model.SystemUserID)
+	if err != nil || stored.KEKLabel != "kek" {
+		t.Fatalf("KEK label isn't stored: %+v (err=%v)", stored, err)
+	}
+
+	// The mode can't be changed but the KEK label can
+	appURL := rootURL + "/" + application.ApplicationEUI
+	genericPutRequest(t, appURL, map[string]interface{}{"endToEnd": false}, http.StatusBadRequest)
+	genericPutRequest(t, appURL, map[string]interface{}{"kekLabel": ""}, http.StatusBadRequest)
+	genericPutRequest(t, appURL, map[string]interface{}{"kekLabel": "new"}, http.StatusOK)
+	if stored, _ := h.context.Storage.Application.GetByEUI(eui, model.SystemUserID); stored.KEKLabel != "new" {
+		t.Fatalf("KEK label isn't updated: %+v", stored)
+	}
+	other := storeApplication(t, apiApplication{}, rootURL, http.StatusCreated)
+	genericPutRequest(t, rootURL+"/"+other.ApplicationEUI, map[string]interface{}{"kekLabel": "kek"}, http.StatusBadRequest)
+
+	// Downstream messages are encrypted with a frame counter that can't be
+	// less than the device's frame counter
+	device := storeDevice(t, apiDevice{DeviceType: "ABP"}, appURL+"/devices", http.StatusCreated)
+	deviceEUI, _ := protocol.EUIFromString(device.DeviceEUI)
+	d, _ := h.context.Storage.Device.GetByEUI(deviceEUI)
+	d.FCntDn = 10
+	h.context.Storage.Device.UpdateState(d)
+	queueURL := appURL + "/devices/" + device.DeviceEUI + "/queue"
+	for body, expected := range map[string]int{
+		`{"port": 1, "data": "aa"}`:             http.StatusBadRequest,
+		`{"port": 1, "data": "aa", "fcnt": 9}`:  http.StatusBadRequest,
+		`{"port": 1, "data": "aa", "fcnt": 12}`: http.StatusCreated,
+	} {
+		resp, err := http.Post(queueURL, "application/json", strings.NewReader(body))
+		if err != nil || resp.StatusCode != expected {
+			t.Fatalf("Expected %d for %s but got %v (err=%v)", expected, body, resp, err)
+		}
+	}
+	if msg, _ := h.context.Storage.DeviceData.GetDownstream(deviceEUI); msg.FCnt != 12 {
+		t.Fatalf("Expected frame counter for message: %+v", msg)
+	}
+}
+
 func TestApplicationDataEndpoint(t *testing.T) {
 	h := createTestServer(noAuthConfig)
 	h.Start()
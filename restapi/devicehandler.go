@@ -18,12 +18,15 @@ package restapi
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ExploratoryEngineering/congress/monitoring"
 
@@ -33,7 +36,83 @@ import (
 	"github.com/ExploratoryEngineering/logging"
 )
 
+// checkRXSettings validates the receive window settings for a device. The RX1
+// delay is 0-15 seconds (0 is the same as 1) and the RX1 data rate offset and
+// RX2 data rate must fit in the DLSettings field [5.4], [5.7]. The RX2 data
+// rate can only be set together with the RX2 frequency since the band's
+// default data rate is used when the frequency is 0.
+func checkRXSettings(settings model.RXSettings) error {
+	if settings.RX1Delay > 15 {
+		return errors.New("rx1Delay must be between 0 and 15 seconds")
+	}
+	if settings.RX1DROffset > 7 {
+		return errors.New("rx1DROffset must be between 0 and 7")
+	}
+	if settings.RX2DataRate > 15 {
+		return errors.New("rx2DataRate must be between 0 and 15")
+	}
+	if settings.RX2Frequency < 0 {
+		return errors.New("rx2Frequency can't be negative")
+	}
+	if settings.RX2Frequency == 0 && settings.RX2DataRate != 0 {
+		return errors.New("rx2DataRate requires rx2Frequency")
+	}
+	return nil
+}
+
+// checkPingSlotSettings validates the class B ping slot settings for a device.
+// The data rate must fit in the PingSlotChannelReq command [14.3]
+func checkPingSlotSettings(settings model.PingSlotSettings) error {
+	if settings.DataRate > 15 {
+		return errors.New("pingSlotDataRate must be between 0 and 15")
+	}
+	if settings.Frequency < 0 {
+		return errors.New("pingSlotFrequency can't be negative")
+	}
+	return nil
+}
+
+// readUint8 reads an integer in the range 0-255 from the values in a PUT
+// request. The current value is returned if the value isn't set.
+func readUint8(values map[string]interface{}, name string, current uint8) (uint8, error) {
+	v, ok := values[name]
+	if !ok {
+		return current, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f < 0 || f > 255 || f != math.Trunc(f) {
+		return current, fmt.Errorf("%s must be an integer between 0 and 255", name)
+	}
+	return uint8(f), nil
+}
+
+// readUint32 reads an unsigned 32-bit integer from the values in a PUT
+// request. The current value is returned if the value isn't set.
+func readUint32(values map[string]interface{}, name string, current uint32) (uint32, error) {
+	v, ok := values[name]
+	if !ok {
+		return current, nil
+	}
+	f, ok := v.(float64)
+	if !ok || f < 0 || f > math.MaxUint32 || f != math.Trunc(f) {
+		return current, fmt.Errorf("%s must be an integer between 0 and %d", name, uint32(math.MaxUint32))
+	}
+	return uint32(f), nil
+}
+
 func (s *Server) deviceList(w http.ResponseWriter, r *http.Request, appEUI protocol.EUI) {
+	// The lowBattery parameter filters the list on devices that have reported
+	// a battery level (in percent) at or below the parameter.
+	lowBattery := -1
+	if param := r.URL.Query().Get("lowBattery"); param != "" {
+		level, err := strconv.ParseInt(param, 10, 32)
+		if err != nil || level < 0 || level > 100 {
+			http.Error(w, "lowBattery must be a percentage between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		lowBattery = int(level)
+	}
+
 	devices, err := s.context.Storage.Device.GetByApplicationEUI(appEUI)
 	if err != nil {
 		logging.Warning("Unable to read device list for application %s: %v.", appEUI, err)
@@ -41,6 +120,12 @@ func (s *Server) deviceList(w http.ResponseWriter, r *http.Request, appEUI proto
 	}
 	deviceList := newDeviceList()
 	for device := range devices {
+		if lowBattery >= 0 {
+			level, ok := device.BatteryPercent()
+			if !ok || level > lowBattery {
+				continue
+			}
+		}
 		deviceList.Devices = append(deviceList.Devices, newDeviceFromModel(&device))
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -50,7 +135,8 @@ func (s *Server) deviceList(w http.ResponseWriter, r *http.Request, appEUI proto
 	}
 }
 
-func (s *Server) createDevice(w http.ResponseWriter, r *http.Request, applicationEUI protocol.EUI) {
+func (s *Server) createDevice(w http.ResponseWriter, r *http.Request, application model.Application) {
+	applicationEUI := application.AppEUI
 	// POST methods contains a single JSON struct in the body. Only one device instance is processed.
 	buf, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -116,13 +202,27 @@ func (s *Server) createDevice(w http.ResponseWriter, r *http.Request, applicatio
 		overrideAppKey = true
 	}
 
-	if !overrideAppKey {
+	if !overrideAppKey && !application.EndToEnd {
 		if device.akey, err = protocol.NewAESKey(); err != nil {
 			logging.Warning("Unable to generate AppKey: %v", err)
 			http.Error(w, "Unable to generate application key", http.StatusInternalServerError)
 			return
 		}
 	}
+	// The GenAppKey is the root key for firmware updates on LoRaWAN 1.0
+	// devices. It is generated unless the application is end-to-end encrypted.
+	if device.GenAppKey != "" {
+		if device.gakey, err = protocol.AESKeyFromString(device.GenAppKey); err != nil {
+			http.Error(w, "GenAppKey incorrect format", http.StatusBadRequest)
+			return
+		}
+	} else if !application.EndToEnd {
+		if device.gakey, err = protocol.NewAESKey(); err != nil {
+			logging.Warning("Unable to generate GenAppKey: %v", err)
+			http.Error(w, "Unable to generate application key", http.StatusInternalServerError)
+			return
+		}
+	}
 	if device.AppSKey != "" {
 		if device.askey, err = protocol.AESKeyFromString(device.AppSKey); err != nil {
 			http.Error(w, "AppSKey incorrect format", http.StatusBadRequest)
@@ -137,7 +237,7 @@ func (s *Server) createDevice(w http.ResponseWriter, r *http.Request, applicatio
 		}
 		overrideNwkSKey = true
 	}
-	if !overrideAppSKey {
+	if !overrideAppSKey && !application.EndToEnd {
 		if device.askey, err = protocol.NewAESKey(); err != nil {
 			logging.Warning("Unable to generate AppSKey: %v", err)
 			http.Error(w, "Unable to generate application session key", http.StatusInternalServerError)
@@ -152,12 +252,76 @@ func (s *Server) createDevice(w http.ResponseWriter, r *http.Request, applicatio
 		}
 	}
 
-	if deviceType == model.OverTheAirDevice && (overrideAppSKey || overrideDevAddr || overrideNwkSKey) {
-		http.Error(w, "DevAddr, AppSKey and NwkSKey can only be specified for ABP devices", http.StatusBadRequest)
+	if device.MACVersion != "" {
+		if device.version, err = protocol.MACVersionFromString(device.MACVersion); err != nil {
+			http.Error(w, "Invalid MAC version", http.StatusBadRequest)
+			return
+		}
+	}
+	if device.DeviceClass != "" {
+		if device.class, err = model.DeviceClassFromString(device.DeviceClass); err != nil {
+			http.Error(w, "Invalid device class", http.StatusBadRequest)
+			return
+		}
+	}
+	// The LoRaWAN 1.1 keys are generated for all devices. The device can be
+	// upgraded to LoRaWAN 1.1 later on.
+	var overrideNwkKey, override11SKeys bool
+	if device.NwkKey != "" {
+		if device.nkey, err = protocol.AESKeyFromString(device.NwkKey); err != nil {
+			http.Error(w, "NwkKey incorrect format", http.StatusBadRequest)
+			return
+		}
+		overrideNwkKey = true
+	} else if device.nkey, err = protocol.NewAESKey(); err != nil {
+		logging.Warning("Unable to generate NwkKey: %v", err)
+		http.Error(w, "Unable to generate network key", http.StatusInternalServerError)
+		return
+	}
+	if device.SNwkSIntKey != "" {
+		if device.snikey, err = protocol.AESKeyFromString(device.SNwkSIntKey); err != nil {
+			http.Error(w, "SNwkSIntKey incorrect format", http.StatusBadRequest)
+			return
+		}
+		override11SKeys = true
+	} else if device.snikey, err = protocol.NewAESKey(); err != nil {
+		logging.Warning("Unable to generate SNwkSIntKey: %v", err)
+		http.Error(w, "Unable to generate network session key", http.StatusInternalServerError)
+		return
+	}
+	if device.NwkSEncKey != "" {
+		if device.nsekey, err = protocol.AESKeyFromString(device.NwkSEncKey); err != nil {
+			http.Error(w, "NwkSEncKey incorrect format", http.StatusBadRequest)
+			return
+		}
+		override11SKeys = true
+	} else if device.nsekey, err = protocol.NewAESKey(); err != nil {
+		logging.Warning("Unable to generate NwkSEncKey: %v", err)
+		http.Error(w, "Unable to generate network session key", http.StatusInternalServerError)
+		return
+	}
+
+	if deviceType == model.OverTheAirDevice && (overrideAppSKey || overrideDevAddr || overrideNwkSKey || override11SKeys) {
+		http.Error(w, "DevAddr, AppSKey, NwkSKey, SNwkSIntKey and NwkSEncKey can only be specified for ABP devices", http.StatusBadRequest)
+		return
+	}
+	if deviceType == model.PersonalizedDevice && (overrideAppKey || overrideNwkKey) {
+		http.Error(w, "AppKey and NwkKey can only be specified for OTAA devices", http.StatusBadRequest)
 		return
 	}
-	if deviceType == model.PersonalizedDevice && overrideAppKey {
-		http.Error(w, "AppKey can only be specified for OTAA devices", http.StatusBadRequest)
+	if err := s.checkEndToEndKeys(application, deviceType, overrideAppKey, overrideAppSKey); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if device.RX1Delay == 0 {
+		device.RX1Delay = model.DefaultRXSettings().RX1Delay
+	}
+	if err := checkRXSettings(device.rxSettings()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkPingSlotSettings(device.pingSlotSettings()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -217,7 +381,7 @@ func (s *Server) deviceListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve application, make sure both network and application EUI is correct
-	_, err = s.context.Storage.Application.GetByEUI(applicationEUI, s.connectUserID(r))
+	application, err := s.context.Storage.Application.GetByEUI(applicationEUI, s.connectUserID(r))
 	if err != nil {
 		http.Error(w, "Application not found", http.StatusNotFound)
 		return
@@ -229,7 +393,7 @@ func (s *Server) deviceListHandler(w http.ResponseWriter, r *http.Request) {
 		s.deviceList(w, r, applicationEUI)
 
 	case http.MethodPost:
-		s.createDevice(w, r, applicationEUI)
+		s.createDevice(w, r, application)
 
 	default:
 		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
@@ -237,6 +401,23 @@ func (s *Server) deviceListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkEndToEndKeys checks the application keys for a device. Congress only
+// keeps the network keys for applications with end-to-end encryption so the
+// application keys can't be set and OTAA devices must use an external join
+// server.
+func (s *Server) checkEndToEndKeys(application model.Application, deviceType model.DeviceState, hasAppKey, hasAppSKey bool) error {
+	if !application.EndToEnd {
+		return nil
+	}
+	if hasAppKey || hasAppSKey {
+		return errors.New("appKey and appSKey can't be set for applications with end-to-end encryption")
+	}
+	if deviceType == model.OverTheAirDevice && s.config.JoinServerURL == "" {
+		return errors.New("OTAA devices in applications with end-to-end encryption require an external join server")
+	}
+	return nil
+}
+
 // Get and check EUIs for network, app, device. Returns false if one of the
 // EUIs are malformed
 func (s *Server) getDevice(w http.ResponseWriter, r *http.Request) (
@@ -292,7 +473,14 @@ func (s *Server) deviceInfoHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
 		}
-		var err error
+		application, err := s.context.Storage.Application.GetByEUI(device.AppEUI, model.SystemUserID)
+		if err != nil {
+			logging.Warning("Unable to read application %s for device %s: %v", device.AppEUI, device.DeviceEUI, err)
+			http.Error(w, "Unable to read application", http.StatusInternalServerError)
+			return
+		}
+		_, hasAppKey := values["appKey"]
+		_, hasAppSKey := values["appSKey"]
 		tmp, ok := values["devAddr"].(string)
 		if ok {
 			if device.DevAddr, err = protocol.DevAddrFromString(tmp); err != nil {
@@ -322,6 +510,46 @@ func (s *Server) deviceInfoHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+		if tmp, ok = values["macVersion"].(string); ok {
+			if device.MACVersion, err = protocol.MACVersionFromString(tmp); err != nil {
+				http.Error(w, "Invalid macVersion", http.StatusBadRequest)
+				return
+			}
+		}
+		if tmp, ok = values["deviceClass"].(string); ok {
+			if device.Class, err = model.DeviceClassFromString(tmp); err != nil {
+				http.Error(w, "Invalid deviceClass", http.StatusBadRequest)
+				return
+			}
+		}
+		tmp, ok = values["nwkKey"].(string)
+		if ok {
+			if device.NwkKey, err = protocol.AESKeyFromString(tmp); err != nil {
+				http.Error(w, "Invalid nwkKey", http.StatusBadRequest)
+				return
+			}
+		}
+		tmp, ok = values["sNwkSIntKey"].(string)
+		if ok {
+			if device.SNwkSIntKey, err = protocol.AESKeyFromString(tmp); err != nil {
+				http.Error(w, "Invalid sNwkSIntKey", http.StatusBadRequest)
+				return
+			}
+		}
+		tmp, ok = values["nwkSEncKey"].(string)
+		if ok {
+			if device.NwkSEncKey, err = protocol.AESKeyFromString(tmp); err != nil {
+				http.Error(w, "Invalid nwkSEncKey", http.StatusBadRequest)
+				return
+			}
+		}
+		tmp, ok = values["genAppKey"].(string)
+		if ok {
+			if device.GenAppKey, err = protocol.AESKeyFromString(tmp); err != nil {
+				http.Error(w, "Invalid genAppKey", http.StatusBadRequest)
+				return
+			}
+		}
 		// Even though just the NwkSKey have duplicates we'll have to change
 		// both to reset the flag.
 		if oldApp != device.AppSKey && oldNet != device.NwkSKey {
@@ -331,13 +559,13 @@ func (s *Server) deviceInfoHandler(w http.ResponseWriter, r *http.Request) {
 		if ok {
 			device.RelaxedCounter = rc
 		}
-		fc, ok := values["fCntUp"].(int32)
-		if ok {
-			device.FCntUp = uint16(fc)
+		if device.FCntUp, err = readUint32(values, "fCntUp", device.FCntUp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		fc, ok = values["fCntDn"].(int32)
-		if ok {
-			device.FCntDn = uint16(fc)
+		if device.FCntDn, err = readUint32(values, "fCntDn", device.FCntDn); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 		kw, ok := values["keyWarning"].(bool)
 		if ok {
@@ -347,22 +575,71 @@ func (s *Server) deviceInfoHandler(w http.ResponseWriter, r *http.Request) {
 		if ok {
 			switch strings.ToUpper(devType) {
 			case "OTAA":
-				_, ok := values["appKey"]
-				if !ok {
+				if !hasAppKey && !application.EndToEnd {
 					http.Error(w, "Must specify AppKey when changing device type to OTAA", http.StatusBadRequest)
+					return
 				}
 				device.State = model.OverTheAirDevice
 			case "ABP":
-				_, appS := values["appSKey"]
 				_, nwkS := values["nwkSKey"]
 				_, devA := values["devAddr"]
-				if !appS || !nwkS || !devA {
+				if (!hasAppSKey && !application.EndToEnd) || !nwkS || !devA {
 					http.Error(w, "Must specify NwkSKey, AppSKey and DevAddr when changing device type to ABP", http.StatusBadRequest)
 					return
 				}
 				device.State = model.PersonalizedDevice
 			}
 		}
+		if _, changed := values["deviceType"]; changed || hasAppKey || hasAppSKey {
+			if err := s.checkEndToEndKeys(application, device.State, hasAppKey, hasAppSKey); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		rx := device.RequestedRX
+		if rx.RX1Delay, err = readUint8(values, "rx1Delay", rx.RX1Delay); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if rx.RX1DROffset, err = readUint8(values, "rx1DROffset", rx.RX1DROffset); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if rx.RX2DataRate, err = readUint8(values, "rx2DataRate", rx.RX2DataRate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, exists := values["rx2Frequency"]; exists {
+			freq, ok := values["rx2Frequency"].(float64)
+			if !ok {
+				http.Error(w, "rx2Frequency must be a number", http.StatusBadRequest)
+				return
+			}
+			rx.RX2Frequency = float32(freq)
+		}
+		if err := checkRXSettings(rx); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		device.RequestedRX = rx
+		ping := device.RequestedPing
+		if ping.DataRate, err = readUint8(values, "pingSlotDataRate", ping.DataRate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, exists := values["pingSlotFrequency"]; exists {
+			freq, ok := values["pingSlotFrequency"].(float64)
+			if !ok {
+				http.Error(w, "pingSlotFrequency must be a number", http.StatusBadRequest)
+				return
+			}
+			ping.Frequency = float32(freq)
+		}
+		if err := checkPingSlotSettings(ping); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		device.RequestedPing = ping
 		if !s.updateTags(&(device.Tags), values) {
 			http.Error(w, "Invalid tag value", http.StatusBadRequest)
 			return
@@ -444,31 +721,23 @@ func (s *Server) deviceDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Remove downstream message if exists and completed (sent and/or acked).
-// Returns false if there's an error. The existing message is left as is if
-// it should be kept (ie it isn't sent yet or not acked yet). This might be
-// a bit counter-intuitive but it will fail on the PutDownstream call later on
-// this elminates a few obvious concurrency issues but not all.
-func (s *Server) removeDownstreamIfComplete(w http.ResponseWriter, deviceEUI protocol.EUI) bool {
-	existingMessage, err := s.context.Storage.DeviceData.GetDownstream(deviceEUI)
-	if err == storage.ErrNotFound {
-		return true
-	}
+// removeCompletedDownstream removes the completed messages from the device's
+// queue. Returns false if there's an error.
+func (s *Server) removeCompletedDownstream(w http.ResponseWriter, deviceEUI protocol.EUI) bool {
+	queue, err := s.context.Storage.DeviceData.ListDownstream(deviceEUI)
 	if err != nil {
-		http.Error(w, "unable to verify if there's a scheduled message", http.StatusInternalServerError)
+		http.Error(w, "unable to read the downstream queue", http.StatusInternalServerError)
 		return false
 	}
-
-	if !existingMessage.IsComplete() {
-		http.Error(w, "a message is already scheduled for output", http.StatusConflict)
-		return false
-	}
-
-	if err := s.context.Storage.DeviceData.DeleteDownstream(deviceEUI); err != nil {
-		http.Error(w, "unable to remove scheduled message", http.StatusInternalServerError)
-		return false
+	for _, v := range queue {
+		if !v.IsComplete() {
+			continue
+		}
+		if err := s.context.Storage.DeviceData.DeleteDownstreamMessage(deviceEUI, v.ID); err != nil && err != storage.ErrNotFound {
+			http.Error(w, "unable to remove completed message", http.StatusInternalServerError)
+			return false
+		}
 	}
-
 	return true
 }
 
@@ -511,12 +780,43 @@ func (s *Server) createDownstream(device *model.Device, w http.ResponseWriter, r
 		return
 	}
 
-	if !s.removeDownstreamIfComplete(w, device.DeviceEUI) {
+	priority, ok := outMessage["priority"].(float64)
+	if ok && (priority < 0 || priority > math.MaxUint8) {
+		http.Error(w, "priority must be between 0 and 255", http.StatusBadRequest)
+		return
+	}
+
+	// Payloads encrypted end-to-end are encrypted with the frame counter the
+	// message will be sent with. The frame counter can't go backwards.
+	application, err := s.context.Storage.Application.GetByEUI(device.AppEUI, s.connectUserID(r))
+	if err != nil {
+		logging.Warning("Unable to retrieve application with EUI %s: %v", device.AppEUI, err)
+		http.Error(w, "Unable to retrieve application", http.StatusInternalServerError)
+		return
+	}
+	fcnt, ok := outMessage["fcnt"].(float64)
+	if application.EndToEnd && !ok {
+		http.Error(w, "fcnt must be set for payloads encrypted end-to-end", http.StatusBadRequest)
+		return
+	}
+	if application.EndToEnd && (fcnt < float64(device.FCntDn) || fcnt > math.MaxUint32) {
+		http.Error(w, fmt.Sprintf("fcnt must be between %d and %d", device.FCntDn, uint32(math.MaxUint32)), http.StatusBadRequest)
+		return
+	}
+
+	if !s.removeCompletedDownstream(w, device.DeviceEUI) {
 		return
 	}
 
 	downstreamMsg := model.NewDownstreamMessage(device.DeviceEUI, uint8(port))
 	downstreamMsg.Data = data
+	downstreamMsg.Priority = uint8(priority)
+	if application.EndToEnd {
+		downstreamMsg.FCnt = uint32(fcnt)
+	}
+	if s.config.DownlinkExpiry > 0 {
+		downstreamMsg.ExpiresTime = downstreamMsg.CreatedTime + int64(s.config.DownlinkExpiry/time.Second)
+	}
 
 	ack, ok := outMessage["ack"].(bool)
 	if ok {
@@ -527,6 +827,11 @@ func (s *Server) createDownstream(device *model.Device, w http.ResponseWriter, r
 		http.Error(w, "unable to schedule downstream message", http.StatusInternalServerError)
 		return
 	}
+	// Class B and C devices can receive the message without waiting for an
+	// uplink
+	if device.Class == model.ClassB || device.Class == model.ClassC {
+		s.context.Downlinks.Notify(device.DeviceEUI)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -587,6 +892,94 @@ func (s *Server) deviceSendHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// deviceRejoinHandler schedules rejoin MAC commands for LoRaWAN 1.1 devices.
+// POST sends a ForceRejoinReq to the device and PUT sends a
+// RejoinParamSetupReq with the periodic rejoin settings [5.13], [5.14].
+func (s *Server) deviceRejoinHandler(w http.ResponseWriter, r *http.Request) {
+	_, device := s.getDevice(w, r)
+	if device == nil {
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	if device.MACVersion != protocol.MACVersion11 {
+		http.Error(w, "Rejoins are only supported by LoRaWAN 1.1 devices", http.StatusBadRequest)
+		return
+	}
+
+	var values map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		logging.Info("Unable to decode JSON: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPut {
+		s.setupRejoin(device, values, w)
+		return
+	}
+	cmd := protocol.NewDownlinkMACCommand(protocol.ForceRejoinReq).(*protocol.MACForceRejoinReq)
+	var err error
+	if cmd.RejoinType, err = readUint8(values, "rejoinType", 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cmd.DataRate, err = readUint8(values, "dataRate", 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cmd.Period, err = readUint8(values, "period", 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cmd.MaxRetries, err = readUint8(values, "maxRetries", 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Type 1 rejoins can't be forced by the network
+	if cmd.RejoinType != protocol.RejoinType0 && cmd.RejoinType != protocol.RejoinType2 {
+		http.Error(w, "rejoinType must be 0 or 2", http.StatusBadRequest)
+		return
+	}
+	if cmd.DataRate > 15 || cmd.Period > 7 || cmd.MaxRetries > 7 {
+		http.Error(w, "dataRate must be 0-15, period and maxRetries must be 0-7", http.StatusBadRequest)
+		return
+	}
+	if err := s.context.FrameOutput.AddMACCommand(device.DeviceEUI, cmd); err != nil {
+		logging.Warning("Unable to schedule ForceRejoinReq for device %s: %v", device.DeviceEUI, err)
+		http.Error(w, "Unable to schedule rejoin", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// setupRejoin schedules a RejoinParamSetupReq for the device. The device will
+// send a type 0 Rejoin-request every 2^(maxCountN+4) uplinks or every
+// 2^(maxTimeN+10) seconds.
+func (s *Server) setupRejoin(device *model.Device, values map[string]interface{}, w http.ResponseWriter) {
+	cmd := protocol.NewDownlinkMACCommand(protocol.RejoinParamSetupReq).(*protocol.MACRejoinParamSetupReq)
+	var err error
+	if cmd.MaxTimeN, err = readUint8(values, "maxTimeN", 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cmd.MaxCountN, err = readUint8(values, "maxCountN", 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cmd.MaxTimeN > 15 || cmd.MaxCountN > 15 {
+		http.Error(w, "maxTimeN and maxCountN must be 0-15", http.StatusBadRequest)
+		return
+	}
+	if err := s.context.FrameOutput.AddMACCommand(device.DeviceEUI, cmd); err != nil {
+		logging.Warning("Unable to schedule RejoinParamSetupReq for device %s: %v", device.DeviceEUI, err)
+		http.Error(w, "Unable to schedule rejoin parameters", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func euiToSource(eui protocol.EUI) string {
 	return fmt.Sprintf("0x%02x, 0x%02x, 0x%02x, 0x%02x, 0x%02x, 0x%02x, 0x%02x, 0x%02x",
 		eui.Octets[0], eui.Octets[1], eui.Octets[2], eui.Octets[3],
@@ -22,9 +22,11 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/server"
 )
 
 func storeDevice(t *testing.T, device apiDevice, url string, expectedStatus int) apiDevice {
@@ -209,18 +211,40 @@ func TestDeviceListEndpoint(t *testing.T) {
 		`{"DevAddr": "01020304", "AppSKey": "foo", "DeviceType": "ABP"}`: http.StatusBadRequest,
 		// Invalid network session key
 		`{"DevAddr": "01020304", "AppSKey": "01020304 05060708 01020304 05060708", "NwkSKey": "bar", "DeviceType": "ABP"}`: http.StatusBadRequest,
+		// Invalid RX settings
+		`{"rx1Delay": 20}`: http.StatusBadRequest,
+		// Invalid LoRaWAN version and 1.1 keys
+		`{"macVersion": "2.0"}`: http.StatusBadRequest,
+		`{"nwkKey": "foo"}`:     http.StatusBadRequest,
+		`{"sNwkSIntKey": "01020304 05060708 01020304 05060708"}`:                 http.StatusBadRequest,
+		`{"nwkKey": "01020304 05060708 01020304 05060708", "DeviceType": "ABP"}`: http.StatusBadRequest,
+		`{"deviceClass": "D"}`: http.StatusBadRequest,
 		// This would be OK. All defaults used
 		"{}": http.StatusCreated,
 		// Overriding the EUI should also work
 		`{"DeviceEUI": "01-02-03-04-05-06-07-aa"}`:                                http.StatusCreated,
 		`{"AppSKey": "01020304 05060708 01020304 05060708", "DeviceType": "ABP"}`: http.StatusCreated,
 		`{"NwkSKey": "01020304 05060708 01020304 05060708", "DeviceType": "ABP"}`: http.StatusCreated,
+
+		// LoRaWAN 1.1 devices
+		`{"macVersion": "1.1", "nwkKey": "01020304 05060708 01020304 05060708"}`:                          http.StatusCreated,
+		`{"macVersion": "1.1", "nwkSEncKey": "01020304 05060708 01020304 05060708", "DeviceType": "ABP"}`: http.StatusCreated,
+
+		// Class C devices
+		`{"deviceClass": "C"}`: http.StatusCreated,
+
+		// Class B devices
+		`{"deviceClass": "B", "pingSlotDataRate": 3}`:  http.StatusCreated,
+		`{"deviceClass": "B", "pingSlotDataRate": 16}`: http.StatusBadRequest,
 	}
 
 	invalidGets := map[string]int{
 		rootURL: http.StatusOK,
 		h.loopbackURL() + "/applications/bar/devices/baz": http.StatusBadRequest,
 		h.loopbackURL() + "/applications/bar/devices":     http.StatusBadRequest,
+		rootURL + "?lowBattery=20":                        http.StatusOK,
+		rootURL + "?lowBattery=101":                       http.StatusBadRequest,
+		rootURL + "?lowBattery=foo":                       http.StatusBadRequest,
 	}
 
 	invalidMethods := []string{
@@ -233,6 +257,52 @@ func TestDeviceListEndpoint(t *testing.T) {
 	genericEndpointTest(t, rootURL, invalidGets, invalidPosts, invalidMethods)
 }
 
+func TestDeviceListLowBattery(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	appURL := h.loopbackURL() + "/applications"
+	application := storeApplication(t, apiApplication{}, appURL, http.StatusCreated)
+
+	rootURL := appURL + "/" + application.ApplicationEUI + "/devices"
+
+	// One device with low battery, one with a full battery and one without status
+	levels := []uint8{25, 254, 0}
+	for _, level := range levels {
+		d := storeDevice(t, apiDevice{}, rootURL, http.StatusCreated)
+		if level == 0 {
+			continue
+		}
+		eui, err := protocol.EUIFromString(d.DeviceEUI)
+		if err != nil {
+			t.Fatalf("Invalid device EUI: %v", err)
+		}
+		device, err := h.context.Storage.Device.GetByEUI(eui)
+		if err != nil {
+			t.Fatalf("Unable to read device: %v", err)
+		}
+		device.BatteryLevel = level
+		device.StatusTime = 1
+		if err := h.context.Storage.Device.Update(device); err != nil {
+			t.Fatalf("Unable to update device: %v", err)
+		}
+	}
+
+	resp, err := http.Get(rootURL + "?lowBattery=20")
+	if err != nil {
+		t.Fatalf("Got error retrieving device list: %v", err)
+	}
+	defer resp.Body.Close()
+	list := deviceList{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("Unable to decode device list: %v", err)
+	}
+	if len(list.Devices) != 1 || list.Devices[0].BatteryLevel != 25 {
+		t.Fatalf("Expected a single device with low battery but got %+v", list.Devices)
+	}
+}
+
 func TestDeviceInfoEndpoint(t *testing.T) {
 	h := createTestServer(noAuthConfig)
 	h.Start()
@@ -313,6 +383,71 @@ func TestDeviceInfoEndpoint(t *testing.T) {
 		"fCntUp":         99,
 		"fCntDn":         100,
 	}, http.StatusOK)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"fCntUp": 0x10001,
+		"fCntDn": 0xFFFFFFFF,
+	}, http.StatusOK)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"fCntUp": -1,
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"fCntDn": 1.5,
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"macVersion":  "1.1",
+		"nwkKey":      "0000 1111 2222 3333 4444 5555 6666 bbbb",
+		"sNwkSIntKey": "0000 1111 2222 3333 4444 5555 6666 cccc",
+		"nwkSEncKey":  "0000 1111 2222 3333 4444 5555 6666 dddd",
+	}, http.StatusOK)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"macVersion": "1.2",
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"deviceClass": "c",
+	}, http.StatusOK)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"deviceClass": "X",
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"deviceClass":       "B",
+		"pingSlotDataRate":  3,
+		"pingSlotFrequency": 869.525,
+	}, http.StatusOK)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"pingSlotDataRate": 16,
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"pingSlotFrequency": "high",
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"nwkKey": "abc",
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"rx1Delay":     5,
+		"rx1DROffset":  1,
+		"rx2DataRate":  3,
+		"rx2Frequency": 869.525,
+	}, http.StatusOK)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"rx1Delay": 16,
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"rx1DROffset": -1,
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"rx2Frequency": "foo",
+	}, http.StatusBadRequest)
+	// The band's default RX2 data rate is used when the frequency is unset
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"rx2Frequency": 0,
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"rx2DataRate":  0,
+		"rx2Frequency": 0,
+	}, http.StatusOK)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"rx2DataRate": 3,
+	}, http.StatusBadRequest)
 	genericPutRequest(t, rootURL, map[string]interface{}{
 		"devAddr": "foo",
 	}, http.StatusBadRequest)
@@ -499,7 +634,9 @@ func TestDeviceMessageInput(t *testing.T) {
 }
 
 func TestAutomaticDownstreamMessageRemoval(t *testing.T) {
-	h := createTestServer(noAuthConfig)
+	config := noAuthConfig
+	config.DownlinkExpiry = time.Hour
+	h := createTestServer(config)
 	h.Start()
 	defer h.Shutdown()
 
@@ -518,33 +655,323 @@ func TestAutomaticDownstreamMessageRemoval(t *testing.T) {
 		}
 	}
 
-	updateSentAck := func(sent, ack int64) {
-		eui, _ := protocol.EUIFromString(device.DeviceEUI)
-		if err := h.context.Storage.DeviceData.UpdateDownstream(eui, sent, ack); err != nil {
+	eui, _ := protocol.EUIFromString(device.DeviceEUI)
+	queueLength := func(expected int) []model.DownstreamMessage {
+		queue, err := h.context.Storage.DeviceData.ListDownstream(eui)
+		if err != nil {
 			t.Fatal(err)
 		}
+		if len(queue) != expected {
+			t.Fatalf("Expected %d messages in queue but got %d", expected, len(queue))
+		}
+		return queue
 	}
+
 	// Schedule a new downstream message. Should succeed.
 	createMessage(`{"port": 100, "data": "aabbccdd", "ack": false}`, http.StatusCreated)
 
-	// Schedule another message. Should fail.
-	createMessage(`{"port": 101, "data": "aabbccdd", "ack": false}`, http.StatusConflict)
+	// Schedule another message. Should be queued after the first.
+	createMessage(`{"port": 101, "data": "aabbccdd", "ack": false}`, http.StatusCreated)
+	queue := queueLength(2)
 
-	// Mimic sent status by updating sent field.
-	updateSentAck(12, 0)
-
-	// Schedule another message. Should succeed since the message is sent.
+	// Mimic sent status by updating sent field. The sent message is removed
+	// when the next message is scheduled.
+	if err := h.context.Storage.DeviceData.UpdateDownstream(eui, queue[0].ID, 12, 0); err != nil {
+		t.Fatal(err)
+	}
 	createMessage(`{"port": 102, "data": "aabbccdd", "ack": true}`, http.StatusCreated)
+	queue = queueLength(2)
+	if queue[0].Port != 101 || queue[1].Port != 102 {
+		t.Fatalf("Unexpected queue: %+v", queue)
+	}
 
-	// Mimic sent status
-	updateSentAck(13, 0)
-
-	// Schedule another. Should fail since the message isn't acked
-	createMessage(`{"port": 103, "data": "aabbccdd", "ack": false}`, http.StatusConflict)
+	// Mimic sent status on both. The confirmed message isn't removed until
+	// it is acked.
+	h.context.Storage.DeviceData.UpdateDownstream(eui, queue[0].ID, 13, 0)
+	h.context.Storage.DeviceData.UpdateDownstream(eui, queue[1].ID, 13, 0)
+	createMessage(`{"port": 103, "data": "aabbccdd", "ack": false}`, http.StatusCreated)
+	queue = queueLength(2)
+	if queue[0].Port != 102 {
+		t.Fatalf("Expected confirmed message to remain in queue: %+v", queue)
+	}
 
 	// Mimic ack status
-	updateSentAck(14, 15)
-
-	// Schedule another. Should succeed since the message is acked.
+	h.context.Storage.DeviceData.UpdateDownstream(eui, queue[0].ID, 14, 15)
 	createMessage(`{"port": 104, "data": "aabbccdd", "ack": false}`, http.StatusCreated)
+	queue = queueLength(2)
+
+	// New messages expire
+	if queue[0].ExpiresTime == 0 || queue[1].ExpiresTime == 0 {
+		t.Fatalf("Expected messages to have an expiry time: %+v", queue)
+	}
+
+	// Mimic failed status. Failed messages are removed as well.
+	if err := h.context.Storage.DeviceData.UpdateDownstreamAttempts(eui, queue[0].ID, 8, 16); err != nil {
+		t.Fatal(err)
+	}
+	createMessage(`{"port": 105, "data": "aabbccdd", "ack": false}`, http.StatusCreated)
+	queue = queueLength(2)
+	if queue[0].Port != 104 || queue[1].Port != 105 {
+		t.Fatalf("Unexpected queue: %+v", queue)
+	}
+}
+
+func TestDeviceQueue(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	application := storeApplication(t, apiApplication{}, h.loopbackURL()+"/applications", http.StatusCreated)
+	deviceURL := fmt.Sprintf("%s/applications/%s/devices", h.loopbackURL(), application.ApplicationEUI)
+	device := storeDevice(t, apiDevice{}, deviceURL, http.StatusCreated)
+	queueURL := fmt.Sprintf("%s/%s/queue", deviceURL, device.DeviceEUI)
+
+	invalidPosts := map[string]int{
+		`{"port": 1, "data": "aa", "priority": -1}`:  http.StatusBadRequest,
+		`{"port": 1, "data": "aa", "priority": 256}`: http.StatusBadRequest,
+	}
+	invalidMethods := []string{"HEAD", "PATCH", "PUT"}
+	genericEndpointTest(t, queueURL, map[string]int{}, invalidPosts, invalidMethods)
+
+	listQueue := func() apiDownstreamQueue {
+		resp, err := http.Get(queueURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 OK but got %d", resp.StatusCode)
+		}
+		ret := apiDownstreamQueue{}
+		if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+
+	if q := listQueue(); len(q.Messages) != 0 {
+		t.Fatalf("Expected empty queue but got %+v", q)
+	}
+
+	for _, body := range []string{
+		`{"port": 1, "data": "aa"}`,
+		`{"port": 2, "data": "bb"}`,
+		`{"port": 3, "data": "cc", "priority": 10}`,
+	} {
+		resp, _ := http.Post(queueURL, "application/json", strings.NewReader(body))
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected 201 CREATED but got %d", resp.StatusCode)
+		}
+	}
+
+	q := listQueue()
+	if len(q.Messages) != 3 || q.Messages[0].Port != 3 || q.Messages[1].Port != 1 || q.Messages[2].Port != 2 {
+		t.Fatalf("Unexpected queue order: %+v", q)
+	}
+
+	// Move the last message to the front of the queue
+	itemURL := fmt.Sprintf("%s/%d", queueURL, q.Messages[2].ID)
+	req, _ := http.NewRequest(http.MethodPut, itemURL, strings.NewReader(`{"priority": 20}`))
+	resp, _ := http.DefaultClient.Do(req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 OK when updating priority but got %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequest(http.MethodPut, itemURL, strings.NewReader(`{"priority": 300}`))
+	resp, _ = http.DefaultClient.Do(req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 BAD REQUEST for invalid priority but got %d", resp.StatusCode)
+	}
+
+	q = listQueue()
+	if q.Messages[0].Port != 2 || q.Messages[0].Priority != 20 {
+		t.Fatalf("Expected message to move to the front of the queue: %+v", q)
+	}
+
+	resp, _ = http.Get(itemURL)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 OK for queued message but got %d", resp.StatusCode)
+	}
+
+	// Cancel the message
+	testDelete(t, map[string]int{
+		itemURL:               http.StatusNoContent,
+		queueURL + "/1":       http.StatusNotFound,
+		queueURL + "/invalid": http.StatusBadRequest,
+	})
+	resp, _ = http.Get(itemURL)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 NOT FOUND for cancelled message but got %d", resp.StatusCode)
+	}
+	if q = listQueue(); len(q.Messages) != 2 {
+		t.Fatalf("Expected 2 messages in queue but got %+v", q)
+	}
+
+	// Clear the queue
+	testDelete(t, map[string]int{queueURL: http.StatusNoContent})
+	if q = listQueue(); len(q.Messages) != 0 {
+		t.Fatalf("Expected empty queue but got %+v", q)
+	}
+}
+
+func TestDeviceRejoin(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	application := storeApplication(t, apiApplication{}, h.loopbackURL()+"/applications", http.StatusCreated)
+	appURL := h.loopbackURL() + "/applications/" + application.ApplicationEUI
+	device10 := storeDevice(t, apiDevice{}, appURL+"/devices", http.StatusCreated)
+	device11 := storeDevice(t, apiDevice{MACVersion: "1.1"}, appURL+"/devices", http.StatusCreated)
+
+	invalidPosts := map[string]int{
+		`x`:                                   http.StatusBadRequest,
+		`{"rejoinType": 1}`:                   http.StatusBadRequest,
+		`{"rejoinType": 0, "dataRate": 16}`:   http.StatusBadRequest,
+		`{"rejoinType": 2, "maxRetries": 8}`:  http.StatusBadRequest,
+		`{"rejoinType": 2, "period": -1}`:     http.StatusBadRequest,
+		`{"rejoinType": 2, "period": "many"}`: http.StatusBadRequest,
+	}
+	invalidGets := map[string]int{}
+	invalidMethods := []string{"GET", "DELETE", "PATCH"}
+	genericEndpointTest(t, appURL+"/devices/"+device11.DeviceEUI+"/rejoin", invalidGets, invalidPosts, invalidMethods)
+
+	// LoRaWAN 1.0 devices can't rejoin
+	resp, _ := http.Post(appURL+"/devices/"+device10.DeviceEUI+"/rejoin", "application/json", strings.NewReader(`{}`))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 BAD REQUEST for LoRaWAN 1.0 device but got %d", resp.StatusCode)
+	}
+
+	eui, _ := protocol.EUIFromString(device11.DeviceEUI)
+	stored, _ := h.context.Storage.Device.GetByEUI(eui)
+	getCommands := func() map[protocol.CID]protocol.MACCommand {
+		ret := make(map[protocol.CID]protocol.MACCommand)
+		payload, err := h.context.FrameOutput.GetPHYPayloadForDevice(&stored, &server.FrameContext{Device: stored})
+		if err != nil {
+			return ret
+		}
+		for _, v := range payload.MACPayload.MACCommands.List() {
+			ret[v.ID()] = v
+		}
+		return ret
+	}
+
+	resp, _ = http.Post(appURL+"/devices/"+device11.DeviceEUI+"/rejoin", "application/json",
+		strings.NewReader(`{"rejoinType": 2, "dataRate": 3, "period": 1, "maxRetries": 2}`))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202 ACCEPTED when forcing rejoin but got %d", resp.StatusCode)
+	}
+	force, ok := getCommands()[protocol.ForceRejoinReq].(*protocol.MACForceRejoinReq)
+	if !ok || force.RejoinType != 2 || force.DataRate != 3 || force.Period != 1 || force.MaxRetries != 2 {
+		t.Fatalf("Expected ForceRejoinReq to be scheduled but got %+v", force)
+	}
+
+	genericPutRequest(t, appURL+"/devices/"+device11.DeviceEUI+"/rejoin", map[string]interface{}{
+		"maxTimeN": 16,
+	}, http.StatusBadRequest)
+	genericPutRequest(t, appURL+"/devices/"+device11.DeviceEUI+"/rejoin", map[string]interface{}{
+		"maxTimeN": 5, "maxCountN": 6,
+	}, http.StatusAccepted)
+	setup, ok := getCommands()[protocol.RejoinParamSetupReq].(*protocol.MACRejoinParamSetupReq)
+	if !ok || setup.MaxTimeN != 5 || setup.MaxCountN != 6 {
+		t.Fatalf("Expected RejoinParamSetupReq to be scheduled but got %+v", setup)
+	}
+}
+
+func TestClassBCDownlinkNotification(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	application := storeApplication(t, apiApplication{}, h.loopbackURL()+"/applications", http.StatusCreated)
+	appURL := h.loopbackURL() + "/applications/" + application.ApplicationEUI
+	classA := storeDevice(t, apiDevice{}, appURL+"/devices", http.StatusCreated)
+	classB := storeDevice(t, apiDevice{DeviceClass: "B"}, appURL+"/devices", http.StatusCreated)
+	classC := storeDevice(t, apiDevice{DeviceClass: "C"}, appURL+"/devices", http.StatusCreated)
+	if classA.DeviceClass != "A" || classB.DeviceClass != "B" || classC.DeviceClass != "C" {
+		t.Fatalf("Unexpected device classes: %s, %s and %s", classA.DeviceClass, classB.DeviceClass, classC.DeviceClass)
+	}
+
+	post := func(device apiDevice) {
+		reader := strings.NewReader(`{"port": 1, "data": "01AA"}`)
+		resp, _ := http.Post(appURL+"/devices/"+device.DeviceEUI+"/message", "application/json", reader)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Got status %d posting message to device %s", resp.StatusCode, device.DeviceEUI)
+		}
+	}
+
+	// Class A devices get the message after the next uplink
+	post(classA)
+	select {
+	case eui := <-h.context.Downlinks.Notifications():
+		t.Fatalf("Did not expect notification for class A device but got %s", eui)
+	default:
+	}
+
+	for _, device := range []apiDevice{classB, classC} {
+		post(device)
+		select {
+		case eui := <-h.context.Downlinks.Notifications():
+			if eui.String() != device.DeviceEUI {
+				t.Fatalf("Expected notification for %s but got %s", device.DeviceEUI, eui)
+			}
+		default:
+			t.Fatalf("Expected notification for class %s device", device.DeviceClass)
+		}
+	}
+}
+
+func TestEndToEndDeviceKeys(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	appURL := h.loopbackURL() + "/applications"
+	application := storeApplication(t, apiApplication{
+		EndToEnd: true,
+		KEKLabel: "kek",
+	}, appURL, http.StatusCreated)
+	deviceURL := appURL + "/" + application.ApplicationEUI + "/devices"
+
+	// ABP devices can't have an AppSKey and none is generated
+	storeDevice(t, apiDevice{
+		DeviceType: "ABP",
+		AppSKey:    "01020304050607080102030405060708",
+		NwkSKey:    "01020304050607080102030405060708",
+	}, deviceURL, http.StatusBadRequest)
+	abp := storeDevice(t, apiDevice{
+		DeviceType: "ABP",
+		NwkSKey:    "01020304050607080102030405060708",
+	}, deviceURL, http.StatusCreated)
+	abpEUI, _ := protocol.EUIFromString(abp.DeviceEUI)
+	stored, err := h.context.Storage.Device.GetByEUI(abpEUI)
+	if err != nil {
+		t.Fatal("Unable to read device: ", err)
+	}
+	if stored.AppSKey != (protocol.AESKey{}) || stored.AppKey != (protocol.AESKey{}) {
+		t.Fatalf("Did not expect application keys for device: %+v", stored)
+	}
+
+	rootURL := deviceURL + "/" + abp.DeviceEUI
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"appSKey": "0000 1111 2222 3333 4444 5555 6666 7777",
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"relaxedCounter": true,
+	}, http.StatusOK)
+
+	// OTAA devices require an external join server
+	storeDevice(t, apiDevice{DeviceType: "OTAA"}, deviceURL, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"deviceType": "OTAA",
+	}, http.StatusBadRequest)
+
+	h.config.JoinServerURL = "http://localhost:1234/"
+	storeDevice(t, apiDevice{DeviceType: "OTAA", AppKey: "01020304050607080102030405060708"}, deviceURL, http.StatusBadRequest)
+	otaa := storeDevice(t, apiDevice{DeviceType: "OTAA"}, deviceURL, http.StatusCreated)
+	otaaEUI, _ := protocol.EUIFromString(otaa.DeviceEUI)
+	if stored, _ = h.context.Storage.Device.GetByEUI(otaaEUI); stored.AppKey != (protocol.AESKey{}) {
+		t.Fatalf("Did not expect AppKey for device: %+v", stored)
+	}
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"deviceType": "OTAA",
+	}, http.StatusOK)
 }
@@ -7,6 +7,9 @@ The most relevant endpoints are as follows (GET and POST methods are supported):
    /applications/{EUI}/devices
    /applications/{EUI}/devices/{EUI}
    /gateways
+   /gateways/{EUI}/status
+
+The gateway status reports are kept for one day.
 
 */
 package restapi
@@ -0,0 +1,144 @@
+package restapi
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+	"github.com/ExploratoryEngineering/rest"
+)
+
+// deviceQueueHandler lists, adds and removes messages in the device's
+// downstream queue. The messages are sent to the device in the order they
+// are listed.
+func (s *Server) deviceQueueHandler(w http.ResponseWriter, r *http.Request) {
+	_, device := s.getDevice(w, r)
+	if device == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		queue, err := s.context.Storage.DeviceData.ListDownstream(device.DeviceEUI)
+		if err != nil {
+			logging.Warning("Unable to retrieve downstream queue for device %s: %v", device.DeviceEUI, err)
+			http.Error(w, "Unable to retrieve downstream queue", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(newDownstreamQueueFromModel(queue)); err != nil {
+			logging.Warning("Unable to marshal downstream queue for device %s into JSON: %v", device.DeviceEUI, err)
+		}
+
+	case http.MethodPost:
+		s.createDownstream(device, w, r)
+
+	case http.MethodDelete:
+		s.deleteDownstream(device, w, r)
+
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// getQueuedMessage returns the message in the device's queue with the ID in
+// the request path. An error is written to the response if the message
+// can't be found.
+func (s *Server) getQueuedMessage(w http.ResponseWriter, r *http.Request, device *model.Device) *model.DownstreamMessage {
+	idStr, _ := r.Context().Value(rest.PathParameter("id")).(string)
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return nil
+	}
+	queue, err := s.context.Storage.DeviceData.ListDownstream(device.DeviceEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve downstream queue for device %s: %v", device.DeviceEUI, err)
+		http.Error(w, "Unable to retrieve downstream queue", http.StatusInternalServerError)
+		return nil
+	}
+	for _, v := range queue {
+		if v.ID == id {
+			return &v
+		}
+	}
+	http.Error(w, "Message not found", http.StatusNotFound)
+	return nil
+}
+
+// deviceQueueItemHandler retrieves, reorders and cancels a single message in
+// the device's downstream queue. The message is moved in the queue by
+// changing its priority.
+func (s *Server) deviceQueueItemHandler(w http.ResponseWriter, r *http.Request) {
+	_, device := s.getDevice(w, r)
+	if device == nil {
+		return
+	}
+	msg := s.getQueuedMessage(w, r, device)
+	if msg == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// nothing to do
+
+	case http.MethodPut:
+		values := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+			http.Error(w, "Can't grok JSON", http.StatusBadRequest)
+			return
+		}
+		priority, ok := values["priority"].(float64)
+		if !ok || priority < 0 || priority > math.MaxUint8 {
+			http.Error(w, "priority must be between 0 and 255", http.StatusBadRequest)
+			return
+		}
+		msg.Priority = uint8(priority)
+		if err := s.context.Storage.DeviceData.UpdateDownstreamPriority(device.DeviceEUI, msg.ID, msg.Priority); err != nil {
+			logging.Warning("Unable to update priority for downstream message %d to device %s: %v", msg.ID, device.DeviceEUI, err)
+			http.Error(w, "Unable to update message", http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		err := s.context.Storage.DeviceData.DeleteDownstreamMessage(device.DeviceEUI, msg.ID)
+		if err != nil && err != storage.ErrNotFound {
+			logging.Warning("Unable to remove downstream message %d to device %s: %v", msg.ID, device.DeviceEUI, err)
+			http.Error(w, "Unable to remove message", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(newDownstreamMessageFromModel(*msg)); err != nil {
+		logging.Warning("Unable to marshal downstream message for device %s into JSON: %v", device.DeviceEUI, err)
+	}
+}
@@ -17,11 +17,14 @@ package restapi
 //
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
 	"reflect"
 	"strings"
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/server"
@@ -42,7 +45,7 @@ func appDeviceTemplates() map[string]string {
 		"application-collection": "/applications",
 		"application-data":       "/applications/{aeui}/data{?limit&since}",
 		"application-stream":     "/applications/{aeui}/stream",
-		"device-collection":      "/applications/{aeui}/devices",
+		"device-collection":      "/applications/{aeui}/devices{?lowBattery}",
 		"device-data":            "/applications/{aeui}/devices/{deui}/data{?limit&since}",
 		"gateways":               "/gateways",
 		"gateway-info":           "/gateways/{geui}",
@@ -51,9 +54,38 @@ func appDeviceTemplates() map[string]string {
 
 // apiApplication is the entity used by the REST API for applications
 type apiApplication struct {
-	ApplicationEUI string `json:"applicationEUI"`
-	eui            protocol.EUI
-	Tags           map[string]string `json:"tags"`
+	ApplicationEUI       string       `json:"applicationEUI"`
+	DeviceStatusInterval int64        `json:"deviceStatusInterval"` // Interval in seconds
+	Channels             []apiChannel `json:"channels"`             // Channels in addition to the band's mandatory channels
+	EndToEnd             bool         `json:"endToEnd"`             // Payloads are encrypted end-to-end
+	KEKLabel             string       `json:"kekLabel,omitempty"`   // Label for the KEK the AppSKey is wrapped with
+	eui                  protocol.EUI
+	Tags                 map[string]string `json:"tags"`
+}
+
+// apiChannel is an uplink channel presented by the REST API. Frequencies are
+// in MHz.
+type apiChannel struct {
+	Frequency float32 `json:"frequency"`
+	Downlink  float32 `json:"downlink"` // Frequency for the first receive window. 0 means the uplink frequency
+}
+
+// newChannelsFromModel converts a channel list into API channels
+func newChannelsFromModel(channels model.ChannelList) []apiChannel {
+	ret := make([]apiChannel, 0)
+	for _, v := range channels {
+		ret = append(ret, apiChannel{Frequency: v.Frequency, Downlink: v.Downlink})
+	}
+	return ret
+}
+
+// channelsToModel converts API channels into a model.ChannelList
+func channelsToModel(channels []apiChannel) model.ChannelList {
+	var ret model.ChannelList
+	for _, v := range channels {
+		ret = append(ret, model.Channel{Frequency: v.Frequency, Downlink: v.Downlink})
+	}
+	return ret
 }
 
 // ApplicationList is the list of applications presented by the REST API
@@ -73,9 +105,13 @@ func newApplicationList() applicationList {
 // NewAppFromModel creates a new application from a model.Application instance
 func newAppFromModel(app model.Application) apiApplication {
 	return apiApplication{
-		ApplicationEUI: app.AppEUI.String(),
-		eui:            app.AppEUI,
-		Tags:           app.Tags.Tags(),
+		ApplicationEUI:       app.AppEUI.String(),
+		DeviceStatusInterval: int64(app.DeviceStatusInterval / time.Second),
+		Channels:             newChannelsFromModel(app.Channels),
+		EndToEnd:             app.EndToEnd,
+		KEKLabel:             app.KEKLabel,
+		eui:                  app.AppEUI,
+		Tags:                 app.Tags.Tags(),
 	}
 }
 
@@ -89,13 +125,21 @@ func (a *apiApplication) ToModel() model.Application {
 		tags = &tmp
 	}
 	return model.Application{
-		AppEUI: a.eui,
-		Tags:   *tags,
+		AppEUI:               a.eui,
+		DeviceStatusInterval: time.Duration(a.DeviceStatusInterval) * time.Second,
+		Channels:             channelsToModel(a.Channels),
+		EndToEnd:             a.EndToEnd,
+		KEKLabel:             a.KEKLabel,
+		Tags:                 *tags,
 	}
 }
 
 func (a *apiApplication) equals(other apiApplication) bool {
 	return a.ApplicationEUI == other.ApplicationEUI &&
+		a.DeviceStatusInterval == other.DeviceStatusInterval &&
+		channelsToModel(a.Channels).Equals(channelsToModel(other.Channels)) &&
+		a.EndToEnd == other.EndToEnd &&
+		a.KEKLabel == other.KEKLabel &&
 		reflect.DeepEqual(a.Tags, other.Tags)
 }
 
@@ -107,21 +151,46 @@ const (
 
 // APIDevice is the REST API type used for devices
 type apiDevice struct {
-	DeviceEUI      string `json:"deviceEUI"`
-	DevAddr        string `json:"devAddr"`
-	AppKey         string `json:"appKey"`
-	AppSKey        string `json:"appSKey"`
-	NwkSKey        string `json:"nwkSKey"`
-	FCntUp         uint16 `json:"fCntUp"`
-	FCntDn         uint16 `json:"fCntDn"`
-	RelaxedCounter bool   `json:"relaxedCounter"`
-	DeviceType     string `json:"deviceType"`
-	KeyWarning     bool   `json:"keyWarning"`
+	DeviceEUI      string       `json:"deviceEUI"`
+	DevAddr        string       `json:"devAddr"`
+	AppKey         string       `json:"appKey"`
+	AppSKey        string       `json:"appSKey"`
+	NwkSKey        string       `json:"nwkSKey"`
+	MACVersion     string       `json:"macVersion"`  // LoRaWAN version, "1.0" or "1.1"
+	NwkKey         string       `json:"nwkKey"`      // LoRaWAN 1.1 only
+	SNwkSIntKey    string       `json:"sNwkSIntKey"` // LoRaWAN 1.1 only
+	NwkSEncKey     string       `json:"nwkSEncKey"`  // LoRaWAN 1.1 only
+	GenAppKey      string       `json:"genAppKey"`   // LoRaWAN 1.0 only. Used for firmware updates
+	FCntUp         uint32       `json:"fCntUp"`
+	FCntDn         uint32       `json:"fCntDn"`
+	RelaxedCounter bool         `json:"relaxedCounter"`
+	DeviceType     string       `json:"deviceType"`
+	DeviceClass    string       `json:"deviceClass"` // "A", "B" or "C"
+	KeyWarning     bool         `json:"keyWarning"`
+	BatteryLevel   uint8        `json:"batteryLevel"`
+	DeviceMargin   int8         `json:"deviceMargin"`
+	StatusTime     int64        `json:"statusTime"`
+	RX1Delay       uint8        `json:"rx1Delay"`
+	RX1DROffset    uint8        `json:"rx1DROffset"`
+	RX2DataRate    uint8        `json:"rx2DataRate"`
+	RX2Frequency   float32      `json:"rx2Frequency"`
+	RXPending      bool         `json:"rxPending"`
+	Periodicity    uint8        `json:"pingSlotPeriodicity"` // Class B ping slot periodicity. Read only, set by the device
+	PingSlotDR     uint8        `json:"pingSlotDataRate"`
+	PingSlotFreq   float32      `json:"pingSlotFrequency"`
+	PingPending    bool         `json:"pingSlotPending"`
+	Channels       []apiChannel `json:"channels"` // Channels used by the device. Read only
 	eui            protocol.EUI
 	da             protocol.DevAddr
 	akey           protocol.AESKey
 	askey          protocol.AESKey
 	nskey          protocol.AESKey
+	version        protocol.MACVersion
+	nkey           protocol.AESKey
+	snikey         protocol.AESKey
+	nsekey         protocol.AESKey
+	gakey          protocol.AESKey
+	class          model.DeviceClass
 	Tags           map[string]string `json:"tags"`
 }
 
@@ -142,11 +211,36 @@ func newDeviceFromModel(device *model.Device) apiDevice {
 		askey:          device.AppSKey,
 		NwkSKey:        device.NwkSKey.String(),
 		nskey:          device.NwkSKey,
+		MACVersion:     device.MACVersion.String(),
+		version:        device.MACVersion,
+		NwkKey:         device.NwkKey.String(),
+		nkey:           device.NwkKey,
+		SNwkSIntKey:    device.SNwkSIntKey.String(),
+		snikey:         device.SNwkSIntKey,
+		NwkSEncKey:     device.NwkSEncKey.String(),
+		nsekey:         device.NwkSEncKey,
+		GenAppKey:      device.GenAppKey.String(),
+		gakey:          device.GenAppKey,
 		FCntDn:         device.FCntDn,
 		FCntUp:         device.FCntUp,
 		RelaxedCounter: device.RelaxedCounter,
 		DeviceType:     state,
+		DeviceClass:    device.Class.String(),
+		class:          device.Class,
 		KeyWarning:     device.KeyWarning,
+		BatteryLevel:   device.BatteryLevel,
+		DeviceMargin:   device.DeviceMargin,
+		StatusTime:     ToUnixMillis(device.StatusTime),
+		RX1Delay:       device.RequestedRX.RX1Delay,
+		RX1DROffset:    device.RequestedRX.RX1DROffset,
+		RX2DataRate:    device.RequestedRX.RX2DataRate,
+		RX2Frequency:   device.RequestedRX.RX2Frequency,
+		RXPending:      device.RequestedRX != device.RXSettings,
+		Periodicity:    device.PingSlot.Periodicity,
+		PingSlotDR:     device.RequestedPing.DataRate,
+		PingSlotFreq:   device.RequestedPing.Frequency,
+		PingPending:    device.RequestedPing.DataRate != device.PingSlot.DataRate || device.RequestedPing.Frequency != device.PingSlot.Frequency,
+		Channels:       newChannelsFromModel(device.Channels),
 		Tags:           device.Tags.Tags(),
 	}
 }
@@ -168,16 +262,45 @@ func (d *apiDevice) ToModel(appEUI protocol.EUI) model.Device {
 		AppKey:         d.akey,
 		AppSKey:        d.askey,
 		NwkSKey:        d.nskey,
+		MACVersion:     d.version,
+		NwkKey:         d.nkey,
+		SNwkSIntKey:    d.snikey,
+		NwkSEncKey:     d.nsekey,
+		GenAppKey:      d.gakey,
 		AppEUI:         appEUI,
 		State:          state,
+		Class:          d.class,
 		FCntDn:         d.FCntDn,
 		FCntUp:         d.FCntUp,
 		RelaxedCounter: d.RelaxedCounter,
 		KeyWarning:     d.KeyWarning,
+		NbTrans:        1,
+		RXSettings:     model.DefaultRXSettings(),
+		RequestedRX:    d.rxSettings(),
+		RequestedPing:  d.pingSlotSettings(),
 		Tags:           *tags,
 	}
 }
 
+// rxSettings returns the receive window settings for the device
+func (d *apiDevice) rxSettings() model.RXSettings {
+	return model.RXSettings{
+		RX1Delay:     d.RX1Delay,
+		RX1DROffset:  d.RX1DROffset,
+		RX2DataRate:  d.RX2DataRate,
+		RX2Frequency: d.RX2Frequency,
+	}
+}
+
+// pingSlotSettings returns the requested class B ping slot settings for the
+// device
+func (d *apiDevice) pingSlotSettings() model.PingSlotSettings {
+	return model.PingSlotSettings{
+		DataRate:  d.PingSlotDR,
+		Frequency: d.PingSlotFreq,
+	}
+}
+
 // DeviceList is the list of devices
 type deviceList struct {
 	Devices   []apiDevice       `json:"devices"`
@@ -203,6 +326,13 @@ type apiGateway struct {
 	Tags       map[string]string `json:"tags"`
 	eui        protocol.EUI
 	ipaddr     net.IP
+
+	// Band is the band name, f.e. "EU868". EU868 is used if it is empty
+	Band    string `json:"band"`
+	SubBand uint8  `json:"subBand"`
+
+	// StationToken is the token for LoRa Basics Stations. It is write-only
+	StationToken string `json:"stationToken,omitempty"`
 }
 
 // ToModel converts an APIGateway instance to a model.Gateway
@@ -212,14 +342,23 @@ func (g *apiGateway) ToModel() model.Gateway {
 	if err != nil {
 		logging.Warning("Unable to convert API tags to tags struct: %v", err)
 	}
+	bandType := band.EU868Band
+	if g.Band != "" {
+		if bandType, err = band.ParseBandType(g.Band); err != nil {
+			logging.Warning("Unable to convert API band to band type: %v", err)
+		}
+	}
 	return model.Gateway{
-		GatewayEUI: eui,
-		IP:         net.ParseIP(g.IP),
-		StrictIP:   g.StrictIP,
-		Latitude:   g.Latitude,
-		Longitude:  g.Longitude,
-		Altitude:   g.Altitude,
-		Tags:       *tags,
+		GatewayEUI:   eui,
+		IP:           net.ParseIP(g.IP),
+		StrictIP:     g.StrictIP,
+		Latitude:     g.Latitude,
+		Longitude:    g.Longitude,
+		Altitude:     g.Altitude,
+		Tags:         *tags,
+		Band:         bandType,
+		SubBand:      g.SubBand,
+		StationToken: g.StationToken,
 	}
 }
 
@@ -233,6 +372,8 @@ func newGatewayFromModel(gateway model.Gateway) apiGateway {
 		Longitude:  gateway.Longitude,
 		Altitude:   gateway.Altitude,
 		Tags:       gateway.Tags.Tags(),
+		Band:       gateway.Band.String(),
+		SubBand:    gateway.SubBand,
 	}
 }
 
@@ -273,6 +414,47 @@ func newPublicGatewayFromModel(gateway model.PublicGatewayInfo) apiPublicGateway
 	}
 }
 
+// apiGatewayStatus is a status report from a gateway
+type apiGatewayStatus struct {
+	Received         int64    `json:"received"`              // Time the report was received, ms since epoch
+	GatewayTime      int64    `json:"gatewayTime,omitempty"` // The gateway's system time, ms since epoch
+	RXReceived       uint32   `json:"rxReceived"`
+	RXOK             uint32   `json:"rxOK"`
+	RXForwarded      uint32   `json:"rxForwarded"`
+	ACKRatio         float32  `json:"ackRatio"`
+	DownlinkReceived uint32   `json:"downlinkReceived"`
+	TXEmitted        uint32   `json:"txEmitted"`
+	Latitude         *float32 `json:"latitude,omitempty"`
+	Longitude        *float32 `json:"longitude,omitempty"`
+	Altitude         *float32 `json:"altitude,omitempty"`
+}
+
+// apiGatewayStatusList is a list of status reports from a gateway
+type apiGatewayStatusList struct {
+	Status []apiGatewayStatus `json:"status"`
+}
+
+func newGatewayStatusFromModel(status model.GatewayStatus) apiGatewayStatus {
+	ret := apiGatewayStatus{
+		Received:         ToUnixMillis(status.Received.UnixNano()),
+		RXReceived:       status.RXReceived,
+		RXOK:             status.RXOK,
+		RXForwarded:      status.RXForwarded,
+		ACKRatio:         status.ACKRatio,
+		DownlinkReceived: status.DownlinkReceived,
+		TXEmitted:        status.TXEmitted,
+	}
+	if !status.GatewayTime.IsZero() {
+		ret.GatewayTime = ToUnixMillis(status.GatewayTime.UnixNano())
+	}
+	if status.HasLocation {
+		ret.Latitude = &status.Latitude
+		ret.Longitude = &status.Longitude
+		ret.Altitude = &status.Altitude
+	}
+	return ret
+}
+
 // ToUnixMillis converts a nanosecond timestamp into a millisecond timestamp.
 // the general assumption is that time.Nanosecond = 1 (which it is)
 func ToUnixMillis(unixNanos int64) int64 {
@@ -298,6 +480,38 @@ type apiDeviceData struct {
 	Frequency  float32 `json:"frequency"`
 	GatewayEUI string  `json:"gatewayEUI"`
 	DataRate   string  `json:"dataRate"`
+
+	// The port and frame counter are only set for live data. The application
+	// needs these to decrypt payloads that are encrypted end-to-end.
+	Port      uint8  `json:"port,omitempty"`
+	FCnt      uint32 `json:"fcnt,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+
+	// Gateways lists every gateway that received the frame. This is only
+	// set for live data.
+	Gateways []apiGatewayReception `json:"gateways,omitempty"`
+}
+
+// apiGatewayReception is a single gateway's reception of a frame
+type apiGatewayReception struct {
+	GatewayEUI string  `json:"gatewayEUI"`
+	RSSI       int32   `json:"rssi"`
+	SNR        float32 `json:"snr"`
+	Timestamp  int64   `json:"timestamp"` // Time of reception in ms
+}
+
+// newGatewayReceptions converts the receptions of a frame into API types
+func newGatewayReceptions(receptions []server.GatewayPacket) []apiGatewayReception {
+	var ret []apiGatewayReception
+	for _, v := range receptions {
+		ret = append(ret, apiGatewayReception{
+			GatewayEUI: v.Gateway.GatewayEUI.String(),
+			RSSI:       v.Radio.RSSI,
+			SNR:        v.Radio.SNR,
+			Timestamp:  ToUnixMillis(v.UplinkTime().UnixNano()),
+		})
+	}
+	return ret
 }
 
 // NewDeviceDataFromModel returns an user-friendly version of the DeviceData struct
@@ -330,14 +544,22 @@ func newAPIDataList() apiDataList {
 // is very similar to the existing model entity but for consistency's sake
 // it will be treated like other entities.
 type apiDownstreamMessage struct {
+	ID          uint64 `json:"id"`
+	Priority    uint8  `json:"priority"`
 	DeviceEUI   string `json:"deviceEUI"`
 	Data        string `json:"data"`
 	Port        uint8  `json:"port"`
+	FCnt        uint32 `json:"fcnt"`
 	Ack         bool   `json:"ack"`
 	SentTime    int64  `json:"sentTime"`
 	CreatedTime int64  `json:"createdTime"`
 	AckTime     int64  `json:"ackTime"`
 	State       string `json:"state"`
+	TXError     string `json:"txError,omitempty"`
+	Attempts    uint8  `json:"attempts"`
+	ExpiresTime int64  `json:"expiresTime"`
+	FailedTime  int64  `json:"failedTime"`
+	RXWindow    uint8  `json:"rxWindow"` // Receive window (1 or 2) the message was sent in. Read only
 }
 
 // ToModel converts the end-user message into model.DownstreamMessage
@@ -347,36 +569,77 @@ func (m *apiDownstreamMessage) ToModel() (model.DownstreamMessage, error) {
 		return model.DownstreamMessage{}, err
 	}
 	return model.DownstreamMessage{
+		ID:          m.ID,
+		Priority:    m.Priority,
 		DeviceEUI:   deviceEUI,
 		Data:        m.Data,
 		Port:        m.Port,
+		FCnt:        m.FCnt,
 		Ack:         m.Ack,
 		SentTime:    m.SentTime,
 		CreatedTime: m.CreatedTime,
 		AckTime:     m.AckTime,
+		Attempts:    m.Attempts,
+		ExpiresTime: m.ExpiresTime,
+		FailedTime:  m.FailedTime,
 	}, nil
 }
 
 func newDownstreamMessageFromModel(msg model.DownstreamMessage) apiDownstreamMessage {
-	var state string
-	switch msg.State() {
-	case model.UnsentState:
-		state = "UNSENT"
-	case model.SentState:
-		state = "SENT"
-	case model.AcknowledgedState:
-		state = "ACKNOWLEDGED"
-	}
 	return apiDownstreamMessage{
+		ID:          msg.ID,
+		Priority:    msg.Priority,
 		DeviceEUI:   msg.DeviceEUI.String(),
 		Data:        msg.Data,
 		Port:        msg.Port,
+		FCnt:        msg.FCnt,
 		Ack:         msg.Ack,
 		SentTime:    msg.SentTime,
 		CreatedTime: msg.CreatedTime,
 		AckTime:     msg.AckTime,
-		State:       state,
+		State:       msg.State().String(),
+		TXError:     msg.TXError,
+		Attempts:    msg.Attempts,
+		ExpiresTime: msg.ExpiresTime,
+		FailedTime:  msg.FailedTime,
+		RXWindow:    msg.RXWindow,
+	}
+}
+
+// apiJoinEvent is sent on the websocket when a device in an application with
+// end-to-end encryption joins. The AppSKey is wrapped with the KEK.
+type apiJoinEvent struct {
+	AppEUI    string `json:"appEUI"`
+	DeviceEUI string `json:"deviceEUI"`
+	DevAddr   string `json:"devAddr"`
+	KEKLabel  string `json:"kekLabel"`
+	AppSKey   string `json:"appSKey"`
+}
+
+func newJoinEventFromMessage(msg *server.JoinMessage) apiJoinEvent {
+	return apiJoinEvent{
+		AppEUI:    msg.Application.AppEUI.String(),
+		DeviceEUI: msg.Device.DeviceEUI.String(),
+		DevAddr:   msg.Device.DevAddr.String(),
+		KEKLabel:  msg.KEKLabel,
+		AppSKey:   hex.EncodeToString(msg.WrappedAppSKey),
+	}
+}
+
+// apiDownstreamQueue is the device's downstream messages in the order they
+// are sent
+type apiDownstreamQueue struct {
+	Messages []apiDownstreamMessage `json:"messages"`
+}
+
+// newDownstreamQueueFromModel converts the device's queue into an
+// apiDownstreamQueue
+func newDownstreamQueueFromModel(queue []model.DownstreamMessage) apiDownstreamQueue {
+	ret := apiDownstreamQueue{Messages: make([]apiDownstreamMessage, 0)}
+	for _, v := range queue {
+		ret.Messages = append(ret.Messages, newDownstreamMessageFromModel(v))
 	}
+	return ret
 }
 
 // apiToken is a wrapper type for model.APIToken that omits some of the fields
@@ -458,3 +721,220 @@ func (a *apiAppOutput) ToModel() (model.AppOutput, error) {
 	}
 	return ret, nil
 }
+
+// apiMulticastGroup is a multicast group presented to the client
+type apiMulticastGroup struct {
+	GroupEUI    string            `json:"groupEUI"`
+	AppEUI      string            `json:"appEUI"`
+	McAddr      string            `json:"mcAddr"`
+	McNwkSKey   string            `json:"mcNwkSKey"`
+	McAppSKey   string            `json:"mcAppSKey"`
+	FCntDn      uint32            `json:"fCntDn"`
+	Class       string            `json:"class"` // "B" or "C"
+	DataRate    uint8             `json:"dataRate"`
+	Frequency   float32           `json:"frequency"`
+	Periodicity uint8             `json:"pingSlotPeriodicity"` // Class B groups only
+	Gateways    []string          `json:"gateways"`
+	Tags        map[string]string `json:"tags"`
+}
+
+func newMulticastGroupFromModel(group model.MulticastGroup) apiMulticastGroup {
+	gateways := make([]string, len(group.Gateways))
+	for i, v := range group.Gateways {
+		gateways[i] = v.String()
+	}
+	return apiMulticastGroup{
+		GroupEUI:    group.GroupEUI.String(),
+		AppEUI:      group.AppEUI.String(),
+		McAddr:      group.McAddr.String(),
+		McNwkSKey:   group.McNwkSKey.String(),
+		McAppSKey:   group.McAppSKey.String(),
+		FCntDn:      group.FCntDn,
+		Class:       group.Class.String(),
+		DataRate:    group.DataRate,
+		Frequency:   group.Frequency,
+		Periodicity: group.Periodicity,
+		Gateways:    gateways,
+		Tags:        group.Tags.Tags(),
+	}
+}
+
+// ToModel converts the group into a model.MulticastGroup instance. The EUIs
+// for the group and the application are set by the caller.
+func (g *apiMulticastGroup) ToModel() (model.MulticastGroup, error) {
+	ret := model.NewMulticastGroup()
+	var err error
+	if ret.McAddr, err = protocol.DevAddrFromString(g.McAddr); err != nil {
+		return ret, errors.New("invalid mcAddr")
+	}
+	if ret.McNwkSKey, err = protocol.AESKeyFromString(g.McNwkSKey); err != nil {
+		return ret, errors.New("mcNwkSKey incorrect format")
+	}
+	if ret.McAppSKey, err = protocol.AESKeyFromString(g.McAppSKey); err != nil {
+		return ret, errors.New("mcAppSKey incorrect format")
+	}
+	if ret.Class, err = model.DeviceClassFromString(g.Class); err != nil || ret.Class == model.ClassA {
+		return ret, errors.New("class must be B or C")
+	}
+	if g.Periodicity > protocol.MaxPingPeriodicity {
+		return ret, errors.New("pingSlotPeriodicity must be between 0 and 7")
+	}
+	if g.DataRate > 15 {
+		return ret, errors.New("dataRate must be between 0 and 15")
+	}
+	if g.Frequency < 0 {
+		return ret, errors.New("frequency can't be negative")
+	}
+	for _, v := range g.Gateways {
+		eui, err := protocol.EUIFromString(v)
+		if err != nil {
+			return ret, fmt.Errorf("invalid gateway EUI: %s", v)
+		}
+		ret.Gateways = append(ret.Gateways, eui)
+	}
+	tags, err := model.NewTagsFromMap(g.Tags)
+	if err != nil {
+		return ret, errors.New("invalid tags")
+	}
+	ret.Tags = *tags
+	ret.FCntDn = g.FCntDn
+	ret.DataRate = g.DataRate
+	ret.Frequency = g.Frequency
+	ret.Periodicity = g.Periodicity
+	return ret, nil
+}
+
+// apiMulticastGroupList is a list of multicast groups
+type apiMulticastGroupList struct {
+	Groups []apiMulticastGroup `json:"groups"`
+}
+
+// apiMulticastMessage is a downlink message to a multicast group
+type apiMulticastMessage struct {
+	GroupEUI    string `json:"groupEUI"`
+	Data        string `json:"data"`
+	Port        uint8  `json:"port"`
+	SentTime    int64  `json:"sentTime"`
+	CreatedTime int64  `json:"createdTime"`
+	State       string `json:"state"`
+}
+
+func newMulticastMessageFromModel(msg model.MulticastMessage) apiMulticastMessage {
+	state := "UNSENT"
+	if msg.IsComplete() {
+		state = "SENT"
+	}
+	return apiMulticastMessage{
+		GroupEUI:    msg.GroupEUI.String(),
+		Data:        msg.Data,
+		Port:        msg.Port,
+		SentTime:    msg.SentTime,
+		CreatedTime: msg.CreatedTime,
+		State:       state,
+	}
+}
+
+// apiMulticastDelivery is an attempt to send a message to a multicast group
+// through one of the group's gateways.
+type apiMulticastDelivery struct {
+	GatewayEUI string `json:"gatewayEUI"`
+	FCnt       uint32 `json:"fCnt"`
+	Time       int64  `json:"time"` // Time of the attempt in ms
+	Sent       bool   `json:"sent"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newMulticastDeliveryFromModel(delivery model.MulticastDelivery) apiMulticastDelivery {
+	return apiMulticastDelivery{
+		GatewayEUI: delivery.GatewayEUI.String(),
+		FCnt:       delivery.FCnt,
+		Time:       ToUnixMillis(delivery.Time),
+		Sent:       delivery.Sent(),
+		Error:      delivery.Error,
+	}
+}
+
+// apiMulticastDeliveryList is the delivery log for a multicast group
+type apiMulticastDeliveryList struct {
+	Deliveries []apiMulticastDelivery `json:"deliveries"`
+}
+
+// apiFUOTARequest is the request to start a new firmware update campaign.
+// The firmware image is base64 encoded.
+type apiFUOTARequest struct {
+	GroupEUI   string   `json:"groupEUI"`
+	Devices    []string `json:"devices"`
+	Firmware   []byte   `json:"firmware"`
+	FragSize   uint8    `json:"fragSize"`
+	Redundancy uint16   `json:"redundancy"` // Number of coded fragments. 0 means 10% of the uncoded fragments
+	Descriptor uint32   `json:"descriptor"`
+	Interval   uint16   `json:"interval"`  // Seconds between fragments
+	SetupTime  uint32   `json:"setupTime"` // Seconds before the multicast session starts
+	McGroupID  uint8    `json:"mcGroupID"`
+	FragIndex  uint8    `json:"fragIndex"`
+}
+
+// apiFUOTADevice is the status for a device in a campaign
+type apiFUOTADevice struct {
+	DeviceEUI      string `json:"deviceEUI"`
+	State          string `json:"state"`
+	NbFragReceived uint16 `json:"nbFragReceived"`
+	MissingFrag    uint8  `json:"missingFrag"`
+	Error          string `json:"error,omitempty"`
+	UpdatedTime    int64  `json:"updatedTime"` // Time of the last update in ms
+}
+
+func newFUOTADeviceFromModel(device model.FUOTADevice) apiFUOTADevice {
+	return apiFUOTADevice{
+		DeviceEUI:      device.DeviceEUI.String(),
+		State:          device.State.String(),
+		NbFragReceived: device.NbFragReceived,
+		MissingFrag:    device.MissingFrag,
+		Error:          device.Error,
+		UpdatedTime:    device.UpdatedTime * 1000,
+	}
+}
+
+// apiFUOTACampaign is a firmware update campaign presented to the client. The
+// device list is only included for single campaigns.
+type apiFUOTACampaign struct {
+	CampaignEUI   string           `json:"campaignEUI"`
+	AppEUI        string           `json:"appEUI"`
+	GroupEUI      string           `json:"groupEUI"`
+	McGroupID     uint8            `json:"mcGroupID"`
+	FragIndex     uint8            `json:"fragIndex"`
+	FirmwareSize  int              `json:"firmwareSize"`
+	FragSize      uint8            `json:"fragSize"`
+	Redundancy    uint16           `json:"redundancy"`
+	Descriptor    uint32           `json:"descriptor"`
+	Interval      uint16           `json:"interval"`
+	SessionTime   int64            `json:"sessionTime"` // Start of the multicast session in ms
+	State         string           `json:"state"`
+	FragmentsSent uint16           `json:"fragmentsSent"`
+	CreatedTime   int64            `json:"createdTime"` // Creation time in ms
+	Devices       []apiFUOTADevice `json:"devices,omitempty"`
+}
+
+func newFUOTACampaignFromModel(campaign model.FUOTACampaign) apiFUOTACampaign {
+	return apiFUOTACampaign{
+		CampaignEUI:   campaign.CampaignEUI.String(),
+		AppEUI:        campaign.AppEUI.String(),
+		GroupEUI:      campaign.GroupEUI.String(),
+		McGroupID:     campaign.GroupID,
+		FragIndex:     campaign.FragIndex,
+		FirmwareSize:  len(campaign.Firmware),
+		FragSize:      campaign.FragSize,
+		Redundancy:    campaign.Redundancy,
+		Descriptor:    campaign.Descriptor,
+		Interval:      campaign.Interval,
+		SessionTime:   campaign.SessionTime * 1000,
+		State:         campaign.State.String(),
+		FragmentsSent: campaign.FragmentsSent,
+		CreatedTime:   campaign.CreatedTime * 1000,
+	}
+}
+
+// apiFUOTACampaignList is a list of campaigns
+type apiFUOTACampaignList struct {
+	Campaigns []apiFUOTACampaign `json:"campaigns"`
+}
@@ -0,0 +1,323 @@
+package restapi
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/fuota"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// These are the firmware update (FUOTA) campaign resources for applications.
+
+const (
+	defaultFragSize  = 50
+	maxFragSize      = 239 // The largest payload is 242 bytes; the fragment header is 3 bytes
+	defaultInterval  = 5
+	defaultSetupTime = 1800
+)
+
+// errGroupInUse is returned when the multicast group is used by another campaign
+var errGroupInUse = errors.New("the multicast group is used by another campaign")
+
+func (s *Server) getCampaign(w http.ResponseWriter, r *http.Request, appEUI protocol.EUI) *model.FUOTACampaign {
+	campaignEUI, err := euiFromPathParameter(r, "ceui")
+	if err != nil {
+		http.Error(w, "Malformed campaign EUI", http.StatusBadRequest)
+		return nil
+	}
+	campaign, err := s.context.Storage.FUOTA.GetByEUI(campaignEUI)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			logging.Warning("Unable to retrieve FUOTA campaign %s: %v", campaignEUI, err)
+		}
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return nil
+	}
+	if campaign.AppEUI != appEUI {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return nil
+	}
+	return &campaign
+}
+
+// newCampaign validates the request and creates a new campaign for the
+// application. The multicast group is returned with the campaign.
+func (s *Server) newCampaign(appEUI protocol.EUI, req apiFUOTARequest) (model.FUOTACampaign, model.MulticastGroup, []protocol.EUI, error) {
+	campaign := model.NewFUOTACampaign()
+	campaign.AppEUI = appEUI
+
+	// The FUOTA commands are encrypted with the AppSKey
+	app, err := s.context.Storage.Application.GetByEUI(appEUI, model.SystemUserID)
+	if err != nil {
+		return campaign, model.MulticastGroup{}, nil, errors.New("unknown application")
+	}
+	if app.EndToEnd {
+		return campaign, model.MulticastGroup{}, nil, errors.New("FUOTA isn't supported for applications with end-to-end encryption")
+	}
+
+	groupEUI, err := protocol.EUIFromString(req.GroupEUI)
+	if err != nil {
+		return campaign, model.MulticastGroup{}, nil, errors.New("invalid multicast group EUI")
+	}
+	group, err := s.context.Storage.Multicast.GetByEUI(groupEUI)
+	if err != nil || group.AppEUI != appEUI {
+		return campaign, group, nil, errors.New("unknown multicast group")
+	}
+	if group.Frequency == 0 {
+		return campaign, group, nil, errors.New("the multicast group must have a frequency")
+	}
+	if len(group.Gateways) == 0 {
+		return campaign, group, nil, errors.New("the multicast group has no gateways")
+	}
+
+	if len(req.Devices) == 0 {
+		return campaign, group, nil, errors.New("the campaign must have at least one device")
+	}
+	devices := make([]protocol.EUI, 0, len(req.Devices))
+	seen := make(map[protocol.EUI]bool)
+	for _, v := range req.Devices {
+		deviceEUI, err := protocol.EUIFromString(v)
+		if err != nil {
+			return campaign, group, nil, fmt.Errorf("invalid device EUI: %s", v)
+		}
+		device, err := s.context.Storage.Device.GetByEUI(deviceEUI)
+		if err != nil || device.AppEUI != appEUI {
+			return campaign, group, nil, fmt.Errorf("unknown device: %s", v)
+		}
+		if _, err := fuota.McRootKey(device.GenAppKey, device.AppKey, device.MACVersion); err != nil {
+			return campaign, group, nil, fmt.Errorf("device %s has no key for the multicast keys", v)
+		}
+		if !seen[deviceEUI] {
+			devices = append(devices, deviceEUI)
+			seen[deviceEUI] = true
+		}
+	}
+
+	if len(req.Firmware) == 0 {
+		return campaign, group, nil, errors.New("firmware can't be empty")
+	}
+	if req.FragSize == 0 || req.FragSize > maxFragSize {
+		return campaign, group, nil, fmt.Errorf("fragSize must be between 1 and %d", maxFragSize)
+	}
+	if req.McGroupID > fuota.MaxMcGroupID {
+		return campaign, group, nil, fmt.Errorf("mcGroupID must be between 0 and %d", fuota.MaxMcGroupID)
+	}
+	if req.FragIndex > 3 {
+		return campaign, group, nil, errors.New("fragIndex must be between 0 and 3")
+	}
+	if req.Interval == 0 {
+		return campaign, group, nil, errors.New("interval must be at least 1 second")
+	}
+	nbFrag := (len(req.Firmware) + int(req.FragSize) - 1) / int(req.FragSize)
+	if req.Redundancy == 0 {
+		req.Redundancy = uint16((nbFrag + 9) / 10)
+	}
+	if nbFrag+int(req.Redundancy) > fuota.MaxFragments {
+		return campaign, group, nil, fmt.Errorf("too many fragments (max is %d)", fuota.MaxFragments)
+	}
+
+	campaign.GroupEUI = group.GroupEUI
+	campaign.GroupID = req.McGroupID
+	campaign.FragIndex = req.FragIndex
+	campaign.Firmware = req.Firmware
+	campaign.FragSize = req.FragSize
+	campaign.Redundancy = req.Redundancy
+	campaign.Descriptor = req.Descriptor
+	campaign.Interval = req.Interval
+
+	// Class B sessions start at a beacon. The session lasts long enough to
+	// send all of the fragments.
+	start := time.Now().Add(time.Duration(req.SetupTime) * time.Second)
+	if group.Class == model.ClassB {
+		start = protocol.NextBeacon(start)
+	}
+	campaign.SessionTime = start.Unix()
+	length := time.Duration(nbFrag+int(req.Redundancy)+1) * time.Duration(req.Interval) * time.Second
+	campaign.TimeOut = fuota.SessionTimeOut(length, group.Class == model.ClassB)
+	return campaign, group, devices, nil
+}
+
+// groupInUse checks if there's an active campaign for the multicast group
+func (s *Server) groupInUse(appEUI protocol.EUI, groupEUI protocol.EUI) (bool, error) {
+	campaigns, err := s.context.Storage.FUOTA.GetByApplicationEUI(appEUI)
+	if err != nil {
+		return false, err
+	}
+	inUse := false
+	for v := range campaigns {
+		if v.GroupEUI == groupEUI && v.State.IsActive() {
+			inUse = true
+		}
+	}
+	return inUse, nil
+}
+
+// createCampaign stores the campaign with new keys for the multicast group
+// and launches it.
+func (s *Server) createCampaign(w http.ResponseWriter, r *http.Request, app *model.Application) {
+	req := apiFUOTARequest{FragSize: defaultFragSize, Interval: defaultInterval, SetupTime: defaultSetupTime}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON in request", http.StatusBadRequest)
+		return
+	}
+	campaign, group, devices, err := s.newCampaign(app.AppEUI, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inUse, err := s.groupInUse(app.AppEUI, group.GroupEUI)
+	if err != nil {
+		logging.Warning("Unable to list campaigns for application %s: %v", app.AppEUI, err)
+		http.Error(w, "Unable to check campaigns", http.StatusInternalServerError)
+		return
+	}
+	if inUse {
+		http.Error(w, errGroupInUse.Error(), http.StatusConflict)
+		return
+	}
+
+	// The group gets new session keys derived from the multicast key
+	if campaign.McKey, err = protocol.NewAESKey(); err != nil {
+		logging.Warning("Unable to generate multicast key: %v", err)
+		http.Error(w, "Unable to generate keys", http.StatusInternalServerError)
+		return
+	}
+	if group.McNwkSKey, group.McAppSKey, err = fuota.MulticastSessionKeys(campaign.McKey, group.McAddr); err != nil {
+		logging.Warning("Unable to derive multicast session keys: %v", err)
+		http.Error(w, "Unable to generate keys", http.StatusInternalServerError)
+		return
+	}
+	if err := s.context.Storage.Multicast.Update(group); err != nil {
+		logging.Warning("Unable to update keys for multicast group %s: %v", group.GroupEUI, err)
+		http.Error(w, "Unable to update multicast group", http.StatusInternalServerError)
+		return
+	}
+
+	if campaign.CampaignEUI, err = s.context.KeyGenerator.NewCampaignEUI(); err != nil {
+		http.Error(w, "Key space exhausted", http.StatusInternalServerError)
+		return
+	}
+	if err := s.context.Storage.FUOTA.Put(campaign); err != nil {
+		logging.Warning("Unable to store FUOTA campaign for app with EUI %s: %v", app.AppEUI, err)
+		http.Error(w, "Unable to store campaign", http.StatusInternalServerError)
+		return
+	}
+	ret := newFUOTACampaignFromModel(campaign)
+	for _, v := range devices {
+		device := model.FUOTADevice{CampaignEUI: campaign.CampaignEUI, DeviceEUI: v, State: model.FUOTADeviceGroupSetup}
+		if err := s.context.Storage.FUOTA.PutDevice(device); err != nil {
+			logging.Warning("Unable to store device %s in campaign %s: %v", v, campaign.CampaignEUI, err)
+			http.Error(w, "Unable to store campaign", http.StatusInternalServerError)
+			return
+		}
+		ret.Devices = append(ret.Devices, newFUOTADeviceFromModel(device))
+	}
+	s.context.FUOTA.Start(campaign.CampaignEUI)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(ret); err != nil {
+		logging.Warning("Unable to marshal campaign with EUI %s into JSON: %v", campaign.CampaignEUI, err)
+	}
+}
+
+// fuotaListHandler lists and creates firmware update campaigns in an
+// application
+func (s *Server) fuotaListHandler(w http.ResponseWriter, r *http.Request) {
+	app := s.getApplication(w, r)
+	if app == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ch, err := s.context.Storage.FUOTA.GetByApplicationEUI(app.AppEUI)
+		if err != nil {
+			logging.Warning("Unable to retrieve list of campaigns for app with EUI %s: %v", app.AppEUI, err)
+			http.Error(w, "Unable to retrieve list of campaigns", http.StatusInternalServerError)
+			return
+		}
+		ret := apiFUOTACampaignList{Campaigns: make([]apiFUOTACampaign, 0)}
+		for campaign := range ch {
+			ret.Campaigns = append(ret.Campaigns, newFUOTACampaignFromModel(campaign))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(ret); err != nil {
+			logging.Warning("Unable to marshal campaign list into JSON: %v", err)
+		}
+
+	case http.MethodPost:
+		s.createCampaign(w, r, app)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// fuotaInfoHandler shows the status for a campaign and its devices. Deleting
+// the campaign cancels it if it is running.
+func (s *Server) fuotaInfoHandler(w http.ResponseWriter, r *http.Request) {
+	app := s.getApplication(w, r)
+	if app == nil {
+		return
+	}
+	campaign := s.getCampaign(w, r, app.AppEUI)
+	if campaign == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ret := newFUOTACampaignFromModel(*campaign)
+		ch, err := s.context.Storage.FUOTA.GetDevices(campaign.CampaignEUI)
+		if err != nil {
+			logging.Warning("Unable to retrieve devices for campaign %s: %v", campaign.CampaignEUI, err)
+			http.Error(w, "Unable to retrieve devices", http.StatusInternalServerError)
+			return
+		}
+		ret.Devices = make([]apiFUOTADevice, 0)
+		for v := range ch {
+			ret.Devices = append(ret.Devices, newFUOTADeviceFromModel(v))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(ret); err != nil {
+			logging.Warning("Unable to marshal campaign with EUI %s into JSON: %v", campaign.CampaignEUI, err)
+		}
+
+	case http.MethodDelete:
+		s.context.FUOTA.Cancel(campaign.CampaignEUI)
+		if err := s.context.Storage.FUOTA.Delete(campaign.CampaignEUI); err != nil {
+			logging.Warning("Unable to remove campaign with EUI %s: %v", campaign.CampaignEUI, err)
+			http.Error(w, "Unable to remove campaign", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,112 @@
+package restapi
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func TestFUOTAHandlers(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	app := model.NewApplication()
+	app.AppEUI = makeRandomEUI()
+	h.context.Storage.Application.Put(app, model.SystemUserID)
+
+	group := model.NewMulticastGroup()
+	group.GroupEUI = makeRandomEUI()
+	group.AppEUI = app.AppEUI
+	group.Frequency = 869.525
+	h.context.Storage.Multicast.Put(group)
+
+	device := model.NewDevice()
+	device.DeviceEUI = makeRandomEUI()
+	device.AppEUI = app.AppEUI
+	device.AppKey, _ = protocol.NewAESKey()
+	h.context.Storage.Device.Put(device, app.AppEUI)
+
+	listURL := h.loopbackURL() + "/applications/" + app.AppEUI.String() + "/fuota"
+	firmware := base64.StdEncoding.EncodeToString(make([]byte, 200))
+	body := func(groupEUI string, fragSize int) string {
+		return fmt.Sprintf(`{"groupEUI": "%s", "devices": ["%s"], "firmware": "%s", "fragSize": %d}`,
+			groupEUI, device.DeviceEUI, firmware, fragSize)
+	}
+
+	// The group must have gateways
+	doMulticastRequest(t, http.MethodPost, listURL, body(group.GroupEUI.String(), 50), http.StatusBadRequest)
+	group.Gateways = append(group.Gateways, makeRandomEUI())
+	h.context.Storage.Multicast.Update(group)
+
+	// LoRaWAN 1.0 devices must have a GenAppKey
+	doMulticastRequest(t, http.MethodPost, listURL, body(group.GroupEUI.String(), 50), http.StatusBadRequest)
+	device.GenAppKey, _ = protocol.NewAESKey()
+	h.context.Storage.Device.Update(device)
+
+	doMulticastRequest(t, http.MethodPost, listURL, `{`, http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, listURL, body(makeRandomEUI().String(), 50), http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, listURL, body(group.GroupEUI.String(), 240), http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, listURL, `{"groupEUI": "`+group.GroupEUI.String()+`", "devices": [], "firmware": "`+firmware+`"}`, http.StatusBadRequest)
+
+	buf := doMulticastRequest(t, http.MethodPost, listURL, body(group.GroupEUI.String(), 50), http.StatusCreated)
+	var campaign apiFUOTACampaign
+	if err := json.Unmarshal(buf, &campaign); err != nil {
+		t.Fatalf("Could not unmarshal campaign: %v", err)
+	}
+	if campaign.FirmwareSize != 200 || campaign.Redundancy != 1 || campaign.State != "SETUP" || len(campaign.Devices) != 1 {
+		t.Fatalf("Unexpected campaign returned: %+v", campaign)
+	}
+	updated, _ := h.context.Storage.Multicast.GetByEUI(group.GroupEUI)
+	if updated.McNwkSKey == group.McNwkSKey {
+		t.Fatal("Expected new session keys for the multicast group")
+	}
+
+	// The group can only be used by one campaign at a time
+	doMulticastRequest(t, http.MethodPost, listURL, body(group.GroupEUI.String(), 50), http.StatusConflict)
+
+	buf = doMulticastRequest(t, http.MethodGet, listURL, "", http.StatusOK)
+	var list apiFUOTACampaignList
+	if err := json.Unmarshal(buf, &list); err != nil {
+		t.Fatalf("Could not unmarshal campaign list: %v", err)
+	}
+	if len(list.Campaigns) != 1 || list.Campaigns[0].CampaignEUI != campaign.CampaignEUI {
+		t.Fatalf("Unexpected campaign list: %+v", list)
+	}
+
+	campaignURL := listURL + "/" + campaign.CampaignEUI
+	buf = doMulticastRequest(t, http.MethodGet, campaignURL, "", http.StatusOK)
+	var info apiFUOTACampaign
+	if err := json.Unmarshal(buf, &info); err != nil {
+		t.Fatalf("Could not unmarshal campaign: %v", err)
+	}
+	if len(info.Devices) != 1 || info.Devices[0].DeviceEUI != device.DeviceEUI.String() {
+		t.Fatalf("Unexpected campaign devices: %+v", info)
+	}
+	doMulticastRequest(t, http.MethodGet, listURL+"/00-00-00-00-00-00-00-01", "", http.StatusNotFound)
+	doMulticastRequest(t, http.MethodGet, listURL+"/foo", "", http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPut, campaignURL, "", http.StatusMethodNotAllowed)
+
+	doMulticastRequest(t, http.MethodDelete, campaignURL, "", http.StatusNoContent)
+	doMulticastRequest(t, http.MethodGet, campaignURL, "", http.StatusNotFound)
+}
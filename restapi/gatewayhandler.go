@@ -23,6 +23,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/events/gwevents"
 	"github.com/ExploratoryEngineering/congress/monitoring"
 	"github.com/ExploratoryEngineering/congress/protocol"
@@ -90,6 +91,17 @@ func (s *Server) createGateway(w http.ResponseWriter, r *http.Request) {
 			http.StatusBadRequest)
 		return
 	}
+	bandType := band.EU868Band
+	if gateway.Band != "" {
+		if bandType, err = band.ParseBandType(gateway.Band); err != nil {
+			http.Error(w, "Unknown band", http.StatusBadRequest)
+			return
+		}
+	}
+	if gateway.SubBand > bandType.SubBands() {
+		http.Error(w, "Invalid sub-band for band", http.StatusBadRequest)
+		return
+	}
 
 	modelGw := gateway.ToModel()
 	if err = s.context.Storage.Gateway.Put(gateway.ToModel(), s.connectUserID(r)); err != nil {
@@ -101,6 +113,9 @@ func (s *Server) createGateway(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unable to store gateway", http.StatusInternalServerError)
 		return
 	}
+	// Unknown gateways are cached with the default band when the gateway
+	// checks are disabled
+	s.context.GatewayBands.Remove(modelGw.GatewayEUI)
 
 	monitoring.GatewayCreated.Increment()
 	w.Header().Set("Content-Type", "application/json")
@@ -177,6 +192,29 @@ func (s *Server) gatewayInfoHandler(w http.ResponseWriter, r *http.Request) {
 		if ok {
 			modelGateway.StrictIP = strict
 		}
+		bandName, ok := values["band"].(string)
+		if ok {
+			if modelGateway.Band, err = band.ParseBandType(bandName); err != nil {
+				http.Error(w, "Unknown band", http.StatusBadRequest)
+				return
+			}
+		}
+		subBand, ok := values["subBand"].(float64)
+		if ok {
+			if subBand < 0 || subBand > 255 {
+				http.Error(w, "Invalid sub-band", http.StatusBadRequest)
+				return
+			}
+			modelGateway.SubBand = uint8(subBand)
+		}
+		if modelGateway.SubBand > modelGateway.Band.SubBands() {
+			http.Error(w, "Invalid sub-band for band", http.StatusBadRequest)
+			return
+		}
+		token, ok := values["stationToken"].(string)
+		if ok {
+			modelGateway.StationToken = token
+		}
 
 		if !s.updateTags(&(modelGateway.Tags), values) {
 			http.Error(w, "Invalid tag name or value", http.StatusBadRequest)
@@ -187,6 +225,7 @@ func (s *Server) gatewayInfoHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Unable to update gateway", http.StatusInternalServerError)
 			return
 		}
+		s.context.GatewayBands.Remove(modelGateway.GatewayEUI)
 		monitoring.GatewayUpdated.Increment()
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -200,6 +239,7 @@ func (s *Server) gatewayInfoHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Unable to remove gateway", http.StatusInternalServerError)
 			return
 		}
+		s.context.GatewayBands.Remove(eui)
 		monitoring.GatewayRemoved.Increment()
 		monitoring.RemoveGatewayCounters(eui)
 		w.WriteHeader(http.StatusNoContent)
@@ -263,6 +303,47 @@ func (s *Server) gatewayStatsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(monitoring.GetGatewayCounters(eui))
 }
 
+// gatewayStatusHandler returns the status reports received from the gateway.
+// The oldest report is first.
+func (s *Server) gatewayStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	eui, err := euiFromPathParameter(r, "geui")
+	if err != nil {
+		http.Error(w, "Invalid EUI", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.context.Storage.Gateway.Get(eui, s.connectUserID(r)); err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(w, "Gateway not found", http.StatusNotFound)
+			return
+		}
+		logging.Warning("Unable to read gateway with EUI %s: %v", eui, err)
+		http.Error(w, "Unable to read gateway", http.StatusInternalServerError)
+		return
+	}
+
+	reports, err := s.context.Storage.Gateway.GetStatus(eui)
+	if err != nil {
+		logging.Warning("Unable to read status for gateway with EUI %s: %v", eui, err)
+		http.Error(w, "Unable to read gateway status", http.StatusInternalServerError)
+		return
+	}
+	list := apiGatewayStatusList{Status: make([]apiGatewayStatus, 0)}
+	for _, status := range reports {
+		list.Status = append(list.Status, newGatewayStatusFromModel(status))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		logging.Warning("Unable to marshal status list for gateway %s: %v", eui, err)
+	}
+}
+
 func (s *Server) gatewayPublicList(w http.ResponseWriter, r *http.Request) {
 	gateways, err := s.context.Storage.Gateway.ListAll()
 	if err != nil {
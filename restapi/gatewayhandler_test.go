@@ -22,7 +22,9 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 )
@@ -157,6 +159,9 @@ func TestGatewayListEndpoint(t *testing.T) {
 		`{"gatewayEUI": "01-02-03-04-05-06-07-09", "ip": "127.0.0.1", "latitude": 90.0, "longitude": 180.0}`:  http.StatusCreated,
 		`{"gatewayEUI": "01-02-03-04-05-06-07-10", "ip": "127.0.0.1", "latitude": 900.0, "longitude": 180.0}`: http.StatusBadRequest,
 		`{"gatewayEUI": "01-02-03-04-05-06-07-11", "ip": "127.0.0.1", "latitude": 90.0, "longitude": 1800.0}`: http.StatusBadRequest,
+		`{"gatewayEUI": "01-02-03-04-05-06-07-12", "ip": "127.0.0.1", "band": "US915", "subBand": 2}`:         http.StatusCreated,
+		`{"gatewayEUI": "01-02-03-04-05-06-07-13", "ip": "127.0.0.1", "band": "XY123"}`:                       http.StatusBadRequest,
+		`{"gatewayEUI": "01-02-03-04-05-06-07-14", "ip": "127.0.0.1", "band": "EU868", "subBand": 2}`:         http.StatusBadRequest,
 	}
 
 	invalidGets := map[string]int{
@@ -229,6 +234,29 @@ func TestGatewayInfoEndpoint(t *testing.T) {
 	genericPutRequest(t, rootURL, map[string]interface{}{
 		"ip": "10.10x10.10",
 	}, http.StatusBadRequest)
+	// The forwarders read the gateway again after an update
+	h.context.GatewayBands.Put(gw, nil)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"band":    "AU915",
+		"subBand": 3,
+	}, http.StatusOK)
+	updated, _ := h.context.Storage.Gateway.Get(eui, model.SystemUserID)
+	if updated.Band != band.AU915Band || updated.SubBand != 3 {
+		t.Fatalf("Band wasn't updated: %+v", updated)
+	}
+	if _, _, cached := h.context.GatewayBands.Get(eui); cached {
+		t.Fatal("Gateway should be removed from the band cache")
+	}
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"band": "XY123",
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"band": "EU868",
+	}, http.StatusBadRequest)
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"band":    "EU868",
+		"subBand": 0,
+	}, http.StatusOK)
 	genericPutRequest(t, rootURL, map[string]interface{}{
 		"tags": map[string]string{"name": "value"},
 	}, http.StatusOK)
@@ -239,6 +267,24 @@ func TestGatewayInfoEndpoint(t *testing.T) {
 		"tags": map[string]interface{}{"name": true, "value": 12},
 	}, http.StatusBadRequest)
 
+	// The station token is write-only
+	genericPutRequest(t, rootURL, map[string]interface{}{
+		"stationToken": "secret",
+	}, http.StatusOK)
+	updated, _ = h.context.Storage.Gateway.Get(eui, model.SystemUserID)
+	if updated.StationToken != "secret" {
+		t.Fatalf("Station token wasn't updated: %+v", updated)
+	}
+	resp, err := http.Get(rootURL)
+	if err != nil {
+		t.Fatal("Couldn't get gateway: ", err)
+	}
+	buf, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(buf), "secret") {
+		t.Fatalf("Station token is returned by the API: %s", string(buf))
+	}
+
 	genericEndpointTest(t, rootURL, invalidGets, invalidPosts, invalidMethods)
 	testDelete(t, map[string]int{
 		h.loopbackURL() + "/gateways/01-02":                   http.StatusBadRequest,
@@ -247,3 +293,57 @@ func TestGatewayInfoEndpoint(t *testing.T) {
 	})
 
 }
+
+func TestGatewayStatusEndpoint(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	eui, _ := protocol.EUIFromString("01-23-45-67-89-AB-CD-EE")
+	if err := h.context.Storage.Gateway.Put(model.Gateway{GatewayEUI: eui, Tags: model.NewTags()}, model.SystemUserID); err != nil {
+		t.Fatal("Couldn't create gw: ", err)
+	}
+	rootURL := h.loopbackURL() + "/gateways/" + eui.String() + "/status"
+
+	getList := func() apiGatewayStatusList {
+		resp, err := http.Get(rootURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 OK but got %d", resp.StatusCode)
+		}
+		list := apiGatewayStatusList{}
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	if list := getList(); list.Status == nil || len(list.Status) != 0 {
+		t.Fatalf("Expected empty list but got %+v", list)
+	}
+
+	received := time.Date(2018, time.March, 1, 12, 0, 0, 0, time.UTC)
+	h.context.Storage.Gateway.PutStatus(eui, model.GatewayStatus{Received: received, RXReceived: 4, ACKRatio: 50})
+	h.context.Storage.Gateway.PutStatus(eui, model.GatewayStatus{Received: received.Add(30 * time.Second), GatewayTime: received, HasLocation: true, Latitude: 63.43, Longitude: 10.39})
+
+	list := getList()
+	if len(list.Status) != 2 {
+		t.Fatalf("Expected 2 reports but got %d", len(list.Status))
+	}
+	if list.Status[0].Received != ToUnixMillis(received.UnixNano()) || list.Status[0].RXReceived != 4 ||
+		list.Status[0].ACKRatio != 50 || list.Status[0].GatewayTime != 0 || list.Status[0].Latitude != nil {
+		t.Fatalf("Unexpected report: %+v", list.Status[0])
+	}
+	if list.Status[1].GatewayTime != ToUnixMillis(received.UnixNano()) || list.Status[1].Latitude == nil || *list.Status[1].Latitude != 63.43 {
+		t.Fatalf("Unexpected report: %+v", list.Status[1])
+	}
+
+	invalidGets := map[string]int{
+		h.loopbackURL() + "/gateways/01-02/status":                   http.StatusBadRequest,
+		h.loopbackURL() + "/gateways/01-02-03-04-01-02-03-04/status": http.StatusNotFound,
+	}
+	genericEndpointTest(t, rootURL, invalidGets, map[string]int{}, []string{"HEAD", "PATCH", "POST", "PUT", "DELETE"})
+}
@@ -0,0 +1,318 @@
+package restapi
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// These are the multicast group resources for applications.
+
+// defaultMaxDeliveryCount is the default number of entries returned from the
+// delivery log
+const defaultMaxDeliveryCount = 100
+
+func (s *Server) getMulticastGroup(w http.ResponseWriter, r *http.Request, appEUI protocol.EUI) *model.MulticastGroup {
+	groupEUI, err := euiFromPathParameter(r, "meui")
+	if err != nil {
+		http.Error(w, "Malformed multicast group EUI", http.StatusBadRequest)
+		return nil
+	}
+	group, err := s.context.Storage.Multicast.GetByEUI(groupEUI)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			logging.Warning("Unable to retrieve multicast group %s: %v", groupEUI, err)
+		}
+		http.Error(w, "Multicast group not found", http.StatusNotFound)
+		return nil
+	}
+	if group.AppEUI != appEUI {
+		http.Error(w, "Multicast group not found", http.StatusNotFound)
+		return nil
+	}
+	return &group
+}
+
+// newDefaultMulticastGroup creates a multicast group with a random address
+// and new session keys.
+func newDefaultMulticastGroup() (model.MulticastGroup, error) {
+	ret := model.NewMulticastGroup()
+	ret.McAddr = protocol.NewDevAddr()
+	var err error
+	if ret.McNwkSKey, err = protocol.NewAESKey(); err != nil {
+		return ret, err
+	}
+	ret.McAppSKey, err = protocol.NewAESKey()
+	return ret, err
+}
+
+func writeMulticastGroup(w http.ResponseWriter, group model.MulticastGroup, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(newMulticastGroupFromModel(group)); err != nil {
+		logging.Warning("Unable to marshal multicast group with EUI %s into JSON: %v", group.GroupEUI, err)
+	}
+}
+
+// multicastListHandler lists and creates multicast groups in an application
+func (s *Server) multicastListHandler(w http.ResponseWriter, r *http.Request) {
+	app := s.getApplication(w, r)
+	if app == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ch, err := s.context.Storage.Multicast.GetByApplicationEUI(app.AppEUI)
+		if err != nil {
+			logging.Warning("Unable to retrieve list of multicast groups for app with EUI %s: %v", app.AppEUI, err)
+			http.Error(w, "Unable to retrieve list of multicast groups", http.StatusInternalServerError)
+			return
+		}
+		ret := apiMulticastGroupList{Groups: make([]apiMulticastGroup, 0)}
+		for group := range ch {
+			ret.Groups = append(ret.Groups, newMulticastGroupFromModel(group))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(ret); err != nil {
+			logging.Warning("Unable to marshal multicast group list into JSON: %v", err)
+		}
+
+	case http.MethodPost:
+		defaults, err := newDefaultMulticastGroup()
+		if err != nil {
+			logging.Warning("Unable to generate keys for multicast group: %v", err)
+			http.Error(w, "Unable to generate session keys", http.StatusInternalServerError)
+			return
+		}
+		newItem := newMulticastGroupFromModel(defaults)
+		if err := json.NewDecoder(r.Body).Decode(&newItem); err != nil {
+			http.Error(w, "Invalid JSON in request", http.StatusBadRequest)
+			return
+		}
+		group, err := newItem.ToModel()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		group.AppEUI = app.AppEUI
+		if group.GroupEUI, err = s.context.KeyGenerator.NewMulticastEUI(); err != nil {
+			http.Error(w, "Key space exhausted", http.StatusInternalServerError)
+			return
+		}
+		if err := s.context.Storage.Multicast.Put(group); err != nil {
+			logging.Warning("Unable to store multicast group for app with EUI %s: %v", app.AppEUI, err)
+			http.Error(w, "Unable to store multicast group", http.StatusInternalServerError)
+			return
+		}
+		writeMulticastGroup(w, group, http.StatusCreated)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// multicastInfoHandler shows, updates and removes a single multicast group
+func (s *Server) multicastInfoHandler(w http.ResponseWriter, r *http.Request) {
+	app := s.getApplication(w, r)
+	if app == nil {
+		return
+	}
+	group := s.getMulticastGroup(w, r, app.AppEUI)
+	if group == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeMulticastGroup(w, *group, http.StatusOK)
+
+	case http.MethodPut:
+		// Fields that aren't set in the request keep their current value.
+		// The frame counter can't be changed.
+		updatedItem := newMulticastGroupFromModel(*group)
+		if err := json.NewDecoder(r.Body).Decode(&updatedItem); err != nil {
+			http.Error(w, "Invalid JSON in request", http.StatusBadRequest)
+			return
+		}
+		updated, err := updatedItem.ToModel()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updated.GroupEUI = group.GroupEUI
+		updated.AppEUI = group.AppEUI
+		updated.FCntDn = group.FCntDn
+		if err := s.context.Storage.Multicast.Update(updated); err != nil {
+			logging.Warning("Unable to update multicast group with EUI %s: %v", group.GroupEUI, err)
+			http.Error(w, "Unable to update multicast group", http.StatusInternalServerError)
+			return
+		}
+		writeMulticastGroup(w, updated, http.StatusOK)
+
+	case http.MethodDelete:
+		if err := s.context.Storage.Multicast.Delete(group.GroupEUI); err != nil {
+			logging.Warning("Unable to remove multicast group with EUI %s: %v", group.GroupEUI, err)
+			http.Error(w, "Unable to remove multicast group", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// multicastSendHandler schedules, shows and cancels the downlink message to a
+// multicast group. There's only one message per group. New messages can be
+// scheduled when the previous message is sent.
+func (s *Server) multicastSendHandler(w http.ResponseWriter, r *http.Request) {
+	app := s.getApplication(w, r)
+	if app == nil {
+		return
+	}
+	group := s.getMulticastGroup(w, r, app.AppEUI)
+	if group == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.createMulticastMessage(group, w, r)
+
+	case http.MethodGet:
+		msg, err := s.context.Storage.Multicast.GetDownstream(group.GroupEUI)
+		if err == storage.ErrNotFound {
+			http.Error(w, "No message scheduled for multicast group", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logging.Warning("Unable to retrieve message for multicast group %s: %v", group.GroupEUI, err)
+			http.Error(w, "Unable to retrieve message", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(newMulticastMessageFromModel(msg)); err != nil {
+			logging.Warning("Unable to marshal message for multicast group %s into JSON: %v", group.GroupEUI, err)
+		}
+
+	case http.MethodDelete:
+		if err := s.context.Storage.Multicast.DeleteDownstream(group.GroupEUI); err != nil && err != storage.ErrNotFound {
+			logging.Warning("Unable to remove message for multicast group %s: %v", group.GroupEUI, err)
+			http.Error(w, "Unable to remove message", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) createMulticastMessage(group *model.MulticastGroup, w http.ResponseWriter, r *http.Request) {
+	newMessage := apiMulticastMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&newMessage); err != nil {
+		http.Error(w, "Invalid JSON in request", http.StatusBadRequest)
+		return
+	}
+	if newMessage.Port < 1 || newMessage.Port > 223 {
+		http.Error(w, "port must be between 1 and 223", http.StatusBadRequest)
+		return
+	}
+	payload, err := hex.DecodeString(newMessage.Data)
+	if err != nil {
+		http.Error(w, "Invalid data encoding. data should be encoded as a hex string", http.StatusBadRequest)
+		return
+	}
+	if len(payload) == 0 {
+		http.Error(w, "data cannot be zero bytes", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := s.context.Storage.Multicast.GetDownstream(group.GroupEUI)
+	if err == nil && !existing.IsComplete() {
+		http.Error(w, "a message is already scheduled for the multicast group", http.StatusConflict)
+		return
+	}
+	if err == nil {
+		if err := s.context.Storage.Multicast.DeleteDownstream(group.GroupEUI); err != nil {
+			http.Error(w, "unable to remove sent message", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	msg := model.NewMulticastMessage(group.GroupEUI, newMessage.Port)
+	msg.Data = newMessage.Data
+	if err := s.context.Storage.Multicast.PutDownstream(group.GroupEUI, msg); err != nil {
+		logging.Warning("Unable to store message for multicast group %s: %v", group.GroupEUI, err)
+		http.Error(w, "unable to schedule message", http.StatusInternalServerError)
+		return
+	}
+	s.context.Downlinks.NotifyMulticast(group.GroupEUI)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newMulticastMessageFromModel(msg)); err != nil {
+		logging.Warning("Unable to marshal message for multicast group %s into JSON: %v", group.GroupEUI, err)
+	}
+}
+
+// multicastDeliveryHandler shows the latest delivery attempts for a multicast
+// group
+func (s *Server) multicastDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	app := s.getApplication(w, r)
+	if app == nil {
+		return
+	}
+	group := s.getMulticastGroup(w, r, app.AppEUI)
+	if group == nil {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 32)
+	if err != nil || limit <= 0 {
+		limit = defaultMaxDeliveryCount
+	}
+	ch, err := s.context.Storage.Multicast.GetDeliveries(group.GroupEUI, int(limit))
+	if err != nil {
+		logging.Warning("Unable to retrieve deliveries for multicast group %s: %v", group.GroupEUI, err)
+		http.Error(w, "Unable to retrieve deliveries", http.StatusInternalServerError)
+		return
+	}
+	ret := apiMulticastDeliveryList{Deliveries: make([]apiMulticastDelivery, 0)}
+	for v := range ch {
+		ret.Deliveries = append(ret.Deliveries, newMulticastDeliveryFromModel(v))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(ret); err != nil {
+		logging.Warning("Unable to marshal delivery list into JSON: %v", err)
+	}
+}
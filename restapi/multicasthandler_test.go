@@ -0,0 +1,136 @@
+package restapi
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+func doMulticastRequest(t *testing.T, method, url, body string, expectedStatus int) []byte {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Could not create request: %v", err)
+	}
+	request.Header.Add("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Got error doing %s on %s: %v", method, url, err)
+	}
+	buf, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != expectedStatus {
+		t.Fatalf("Expected %d from %s on %s but got %d (body is %s)", expectedStatus, method, url, resp.StatusCode, string(buf))
+	}
+	return buf
+}
+
+func TestMulticastHandlers(t *testing.T) {
+	h := createTestServer(noAuthConfig)
+	h.Start()
+	defer h.Shutdown()
+
+	app := model.NewApplication()
+	app.AppEUI = makeRandomEUI()
+	h.context.Storage.Application.Put(app, model.SystemUserID)
+
+	listURL := h.loopbackURL() + "/applications/" + app.AppEUI.String() + "/multicast"
+
+	// Invalid groups are rejected
+	doMulticastRequest(t, http.MethodPost, listURL, `{"class": "A"}`, http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, listURL, `{"class": "B", "pingSlotPeriodicity": 8}`, http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, listURL, `{"gateways": ["foo"]}`, http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, listURL, `{`, http.StatusBadRequest)
+
+	buf := doMulticastRequest(t, http.MethodPost, listURL, `{"class": "C", "frequency": 869.525, "tags": {"name": "all"}}`, http.StatusCreated)
+	var group apiMulticastGroup
+	if err := json.Unmarshal(buf, &group); err != nil {
+		t.Fatalf("Could not unmarshal group: %v", err)
+	}
+	if group.AppEUI != app.AppEUI.String() || group.Class != "C" || group.McNwkSKey == "" || group.Tags["name"] != "all" {
+		t.Fatalf("Unexpected group returned: %+v", group)
+	}
+
+	buf = doMulticastRequest(t, http.MethodGet, listURL, "", http.StatusOK)
+	var list apiMulticastGroupList
+	if err := json.Unmarshal(buf, &list); err != nil {
+		t.Fatalf("Could not unmarshal group list: %v", err)
+	}
+	if len(list.Groups) != 1 || list.Groups[0].GroupEUI != group.GroupEUI {
+		t.Fatalf("Unexpected group list: %+v", list)
+	}
+
+	groupURL := listURL + "/" + group.GroupEUI
+	doMulticastRequest(t, http.MethodGet, groupURL, "", http.StatusOK)
+	doMulticastRequest(t, http.MethodGet, listURL+"/00-00-00-00-00-00-00-01", "", http.StatusNotFound)
+	doMulticastRequest(t, http.MethodGet, listURL+"/foo", "", http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPatch, groupURL, "", http.StatusMethodNotAllowed)
+
+	buf = doMulticastRequest(t, http.MethodPut, groupURL, `{"class": "B", "pingSlotPeriodicity": 3}`, http.StatusOK)
+	var updated apiMulticastGroup
+	if err := json.Unmarshal(buf, &updated); err != nil {
+		t.Fatalf("Could not unmarshal group: %v", err)
+	}
+	if updated.Class != "B" || updated.Periodicity != 3 || updated.McAddr != group.McAddr || updated.GroupEUI != group.GroupEUI {
+		t.Fatalf("Group not updated: %+v", updated)
+	}
+
+	// Messages
+	msgURL := groupURL + "/message"
+	doMulticastRequest(t, http.MethodGet, msgURL, "", http.StatusNotFound)
+	doMulticastRequest(t, http.MethodPost, msgURL, `{"port": 0, "data": "aabb"}`, http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, msgURL, `{"port": 1, "data": "xx"}`, http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, msgURL, `{"port": 1, "data": ""}`, http.StatusBadRequest)
+	doMulticastRequest(t, http.MethodPost, msgURL, `{"port": 1, "data": "aabb"}`, http.StatusCreated)
+	doMulticastRequest(t, http.MethodPost, msgURL, `{"port": 1, "data": "ccdd"}`, http.StatusConflict)
+
+	buf = doMulticastRequest(t, http.MethodGet, msgURL, "", http.StatusOK)
+	var msg apiMulticastMessage
+	if err := json.Unmarshal(buf, &msg); err != nil {
+		t.Fatalf("Could not unmarshal message: %v", err)
+	}
+	if msg.Data != "aabb" || msg.Port != 1 || msg.State != "UNSENT" {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+
+	// A sent message is replaced by a new one
+	groupEUI, _ := protocol.EUIFromString(group.GroupEUI)
+	h.context.Storage.Multicast.UpdateDownstream(groupEUI, 1)
+	doMulticastRequest(t, http.MethodPost, msgURL, `{"port": 2, "data": "ccdd"}`, http.StatusCreated)
+	doMulticastRequest(t, http.MethodDelete, msgURL, "", http.StatusNoContent)
+	doMulticastRequest(t, http.MethodGet, msgURL, "", http.StatusNotFound)
+
+	// Deliveries
+	h.context.Storage.Multicast.AddDelivery(model.MulticastDelivery{GroupEUI: groupEUI, GatewayEUI: makeRandomEUI(), FCnt: 1, Time: 1000000})
+	h.context.Storage.Multicast.AddDelivery(model.MulticastDelivery{GroupEUI: groupEUI, GatewayEUI: makeRandomEUI(), FCnt: 1, Time: 2000000, Error: "busy"})
+	buf = doMulticastRequest(t, http.MethodGet, groupURL+"/deliveries?limit=1", "", http.StatusOK)
+	var deliveries apiMulticastDeliveryList
+	if err := json.Unmarshal(buf, &deliveries); err != nil {
+		t.Fatalf("Could not unmarshal deliveries: %v", err)
+	}
+	if len(deliveries.Deliveries) != 1 || deliveries.Deliveries[0].Sent || deliveries.Deliveries[0].Time != 2 {
+		t.Fatalf("Unexpected delivery list: %+v", deliveries)
+	}
+
+	doMulticastRequest(t, http.MethodDelete, groupURL, "", http.StatusNoContent)
+	doMulticastRequest(t, http.MethodGet, groupURL, "", http.StatusNotFound)
+}
@@ -188,6 +188,9 @@ func (h *Server) handler() http.HandlerFunc {
 	router.AddRoute("/applications/{aeui}/devices", h.deviceListHandler)
 	router.AddRoute("/applications/{aeui}/devices/{deui}", h.deviceInfoHandler)
 	router.AddRoute("/applications/{aeui}/devices/{deui}/message", h.deviceSendHandler)
+	router.AddRoute("/applications/{aeui}/devices/{deui}/queue", h.deviceQueueHandler)
+	router.AddRoute("/applications/{aeui}/devices/{deui}/queue/{id}", h.deviceQueueItemHandler)
+	router.AddRoute("/applications/{aeui}/devices/{deui}/rejoin", h.deviceRejoinHandler)
 	router.AddRoute("/applications/{aeui}/devices/{deui}/data", h.deviceDataHandler)
 	router.AddRoute("/applications/{aeui}/devices/{deui}/source", h.deviceSourceHandler)
 	router.AddRoute("/applications/{aeui}/devices/{deui}/tags", h.deviceTagHandler)
@@ -205,12 +208,19 @@ func (h *Server) handler() http.HandlerFunc {
 	router.AddRoute("/gateways/{geui}/tags/{name}", h.gatewayTagNameHandler)
 	router.AddRoute("/gateways/{geui}/stream", websocket.Handler(h.gatewayWebsocketHandler).ServeHTTP)
 	router.AddRoute("/gateways/{geui}/stats", h.gatewayStatsHandler)
+	router.AddRoute("/gateways/{geui}/status", h.gatewayStatusHandler)
 	router.AddRoute("/tokens", h.tokenListHandler)
 	router.AddRoute("/tokens/{token}", h.tokenInfoHandler)
 	router.AddRoute("/tokens/{token}/tags", h.tokenTagHandler)
 	router.AddRoute("/tokens/{token}/tags/{name}", h.tokenTagNameHandler)
 	router.AddRoute("/applications/{aeui}/outputs", h.outputHandler)
 	router.AddRoute("/applications/{aeui}/outputs/{oeui}", h.outputInfoHandler)
+	router.AddRoute("/applications/{aeui}/multicast", h.multicastListHandler)
+	router.AddRoute("/applications/{aeui}/multicast/{meui}", h.multicastInfoHandler)
+	router.AddRoute("/applications/{aeui}/multicast/{meui}/message", h.multicastSendHandler)
+	router.AddRoute("/applications/{aeui}/multicast/{meui}/deliveries", h.multicastDeliveryHandler)
+	router.AddRoute("/applications/{aeui}/fuota", h.fuotaListHandler)
+	router.AddRoute("/applications/{aeui}/fuota/{ceui}", h.fuotaInfoHandler)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		router.GetHandler(r.RequestURI).ServeHTTP(w, r)
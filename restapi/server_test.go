@@ -47,6 +47,8 @@ func createTestServer(config server.Configuration) *Server {
 	keygen, _ := server.NewEUIKeyGenerator(ma, netID, store.Sequence)
 
 	fob := server.NewFrameOutputBuffer()
+	downlinks := server.NewDownlinkNotifier()
+	gatewayBands := server.NewGatewayBands()
 
 	appRouter := pubsub.NewEventRouter(5)
 	context := &server.Context{
@@ -56,6 +58,8 @@ func createTestServer(config server.Configuration) *Server {
 		AppRouter:    &appRouter,
 		AppOutput:    server.NewAppOutputManager(&appRouter),
 		Config:       &config,
+		Downlinks:    &downlinks,
+		GatewayBands: &gatewayBands,
 	}
 
 	server, _ := NewServer(true, context, &config)
@@ -20,14 +20,23 @@ type wsMessage struct {
 	Type    string         `json:"type"`
 	Message string         `json:"message,omitempty"`
 	Data    *apiDeviceData `json:"data,omitempty"`
+
+	Downstream *apiDownstreamMessage `json:"downstream,omitempty"`
+	Join       *apiJoinEvent         `json:"join,omitempty"`
 }
 
 func newWSKeepAlive() wsMessage {
-	return wsMessage{"KeepAlive", "", nil}
+	return wsMessage{"KeepAlive", "", nil, nil, nil}
 }
 func newWSError(errMsg string) wsMessage {
-	return wsMessage{"Error", errMsg, nil}
+	return wsMessage{"Error", errMsg, nil, nil, nil}
 }
 func newWSData(data *apiDeviceData) wsMessage {
-	return wsMessage{"DeviceData", "", data}
+	return wsMessage{"DeviceData", "", data, nil, nil}
+}
+func newWSDownstreamStatus(msg *apiDownstreamMessage) wsMessage {
+	return wsMessage{"DownstreamStatus", "", nil, msg, nil}
+}
+func newWSJoin(join *apiJoinEvent) wsMessage {
+	return wsMessage{"Join", "", nil, nil, join}
 }
@@ -0,0 +1,67 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// ActiveGateway is a gateway that has forwarded uplinks to the server
+type ActiveGateway struct {
+	Gateway  GatewayContext     // The gateway's context
+	Band     band.FrequencyPlan // The band the gateway uses
+	LastSeen time.Time          // The last time the gateway forwarded an uplink
+}
+
+// ActiveGateways keeps track of the gateways that have forwarded uplinks.
+// Downlinks that aren't a response to an uplink (like multicast downlinks)
+// use this to find the gateway's address and band.
+type ActiveGateways struct {
+	mutex    *sync.Mutex
+	gateways map[protocol.EUI]ActiveGateway
+}
+
+// NewActiveGateways creates a new ActiveGateways instance
+func NewActiveGateways() ActiveGateways {
+	return ActiveGateways{mutex: &sync.Mutex{}, gateways: make(map[protocol.EUI]ActiveGateway)}
+}
+
+// Update registers the gateway as active. The forwarders call this every time
+// they receive an uplink.
+func (a *ActiveGateways) Update(gateway GatewayContext, plan band.FrequencyPlan) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.gateways[gateway.GatewayEUI] = ActiveGateway{Gateway: gateway, Band: plan, LastSeen: time.Now()}
+}
+
+// Get returns the gateway. The boolean flag is set to false if the gateway
+// hasn't forwarded any uplinks.
+func (a *ActiveGateways) Get(gatewayEUI protocol.EUI) (ActiveGateway, bool) {
+	if a == nil {
+		return ActiveGateway{}, false
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	gw, ok := a.gateways[gatewayEUI]
+	return gw, ok
+}
@@ -0,0 +1,41 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+)
+
+func TestActiveGateways(t *testing.T) {
+	var nilGateways *ActiveGateways
+	nilGateways.Update(GatewayContext{}, nil)
+	if _, ok := nilGateways.Get(makeRandomEUI()); ok {
+		t.Fatal("Nil instance should be empty")
+	}
+
+	a := NewActiveGateways()
+	gw := GatewayContext{GatewayEUI: makeRandomEUI(), GatewayHost: "127.0.0.1"}
+	if _, ok := a.Get(gw.GatewayEUI); ok {
+		t.Fatal("Unknown gateway should not be active")
+	}
+	a.Update(gw, nil)
+	gw.GatewayHost = "127.0.0.2"
+	a.Update(gw, nil)
+	active, ok := a.Get(gw.GatewayEUI)
+	if !ok || active.Gateway != gw || active.LastSeen.IsZero() {
+		t.Fatalf("Expected the last update for the gateway but got %+v", active)
+	}
+}
@@ -115,24 +115,46 @@ func (a *awsiotTransport) close(l *MemoryLogger) {
 // the new state of the shadow
 type awsiotMessage struct {
 	State struct {
-		Desired *deviceData `json:"desired"`
+		Desired interface{} `json:"desired"`
 	} `json:"state"`
 }
 
+// awsiotDownstream is the desired state for downstream status messages. The
+// status is kept in a separate field to leave the device data untouched.
+type awsiotDownstream struct {
+	Downstream *downstreamStatus `json:"downstream"`
+}
+
+// awsiotJoin is the desired state for join events
+type awsiotJoin struct {
+	Join *joinEvent `json:"join"`
+}
+
 func (a *awsiotTransport) send(msg interface{}, logger *MemoryLogger) bool {
-	dataMsg, ok := msg.(*PayloadMessage)
-	if !ok {
-		logging.Warning("Didn't receive a PayloadMessage type on channel but got %T. Dropping it.", msg)
+	var awsMsg awsiotMessage
+	var deviceEUI string
+	switch m := msg.(type) {
+	case *PayloadMessage:
+		dataOutput := newDeviceDataFromPayloadMessage(m)
+		deviceEUI = dataOutput.DeviceEUI
+		awsMsg.State.Desired = dataOutput
+	case *DownstreamStatus:
+		status := newDownstreamStatus(m)
+		deviceEUI = status.DeviceEUI
+		awsMsg.State.Desired = &awsiotDownstream{status}
+	case *JoinMessage:
+		join := newJoinEvent(m)
+		deviceEUI = join.DeviceEUI
+		awsMsg.State.Desired = &awsiotJoin{join}
+	default:
+		logging.Warning("Didn't receive a PayloadMessage, DownstreamStatus or JoinMessage type on channel but got %T. Dropping it.", msg)
 		return true
 	}
 
-	dataOutput := newDeviceDataFromPayloadMessage(dataMsg)
-	topicName := fmt.Sprintf("$aws/things/%s/shadow/update", dataOutput.DeviceEUI)
+	topicName := fmt.Sprintf("$aws/things/%s/shadow/update", deviceEUI)
 	qos := byte(1)
 	retained := false
 
-	var awsMsg awsiotMessage
-	awsMsg.State.Desired = dataOutput
 	messageBytes, err := json.Marshal(&awsMsg)
 	if err != nil {
 		logging.Warning("Unable to marshal AWS message: %v. Ignoring it.", err)
@@ -140,7 +162,7 @@ func (a *awsiotTransport) send(msg interface{}, logger *MemoryLogger) bool {
 	}
 
 	if token := a.client.Publish(topicName, qos, retained, messageBytes); token.Wait() && token.Error() != nil {
-		logging.Info("Unable to forward message for device %s to AWS IoT: %v", dataOutput.DeviceEUI, token.Error())
+		logging.Info("Unable to forward message for device %s to AWS IoT: %v", deviceEUI, token.Error())
 		logger.Append(NewLogEntry(token.Error().Error()))
 		return false
 	}
@@ -19,6 +19,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,6 +36,7 @@ type Configuration struct {
 	MA                    string // String representation of MA
 	DBConnectionString    string
 	PrintSchema           bool
+	PrintMigration        bool
 	Syslog                bool
 	DisableGatewayChecks  bool
 	ConnectHost           string
@@ -59,9 +62,21 @@ type Configuration struct {
 	DBMaxConnections      int
 	DBIdleConnections     int
 	DBConnLifetime        time.Duration
+	DedupWindow           time.Duration
+	DownlinkRetries       int
+	DownlinkExpiry        time.Duration
+	JoinServerURL         string
 	ACMECert              bool   // AutoCert via Let's Encrypt
 	ACMEHost              string // AutoCert hostname
 	ACMESecretDir         string
+
+	// Basics Station LNS endpoint. The endpoint is disabled if the port is 0.
+	// The stations authenticate with the gateway's station token.
+	StationPort int
+
+	// Additional UDP ports for the packet forwarders as a comma-separated
+	// list. The gateways can use any of the ports.
+	ExtraGatewayPorts string
 }
 
 // This is the default configuration
@@ -77,6 +92,11 @@ const (
 	DefaultMaxConns        = 200
 	DefaultIdleConns       = 100
 	DefaultConnLifetime    = 10 * time.Minute
+	DefaultDedupWindow     = 100 * time.Millisecond
+	MaxDedupWindow         = 500 * time.Millisecond
+	DefaultDownlinkRetries = 7
+	MaxDownlinkRetries     = 254
+	DefaultDownlinkExpiry  = 24 * time.Hour
 )
 
 // NewDefaultConfig returns the default configuration. Note that this configuration
@@ -93,6 +113,9 @@ func NewDefaultConfig() *Configuration {
 		DBMaxConnections:  DefaultMaxConns,
 		DBConnLifetime:    DefaultConnLifetime,
 		DBIdleConnections: DefaultIdleConns,
+		DedupWindow:       DefaultDedupWindow,
+		DownlinkRetries:   DefaultDownlinkRetries,
+		DownlinkExpiry:    DefaultDownlinkExpiry,
 	}
 }
 
@@ -120,6 +143,28 @@ func (cfg *Configuration) RootMA() protocol.MA {
 	return ret
 }
 
+// GatewayPorts returns the UDP ports for the packet forwarders. The gateway
+// port is the first port in the list, followed by the additional ports.
+func (cfg *Configuration) GatewayPorts() ([]int, error) {
+	ret := []int{cfg.GatewayPort}
+	if strings.TrimSpace(cfg.ExtraGatewayPorts) == "" {
+		return ret, nil
+	}
+	for _, v := range strings.Split(cfg.ExtraGatewayPorts, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid gateway port: %s", v)
+		}
+		for _, existing := range ret {
+			if existing == port {
+				return nil, fmt.Errorf("gateway port %d is used more than once", port)
+			}
+		}
+		ret = append(ret, port)
+	}
+	return ret, nil
+}
+
 // Validate checks the configuration for inconsistencies and errors. This
 // function logs the warnings using the logger package as well.
 func (cfg *Configuration) Validate() error {
@@ -155,6 +200,35 @@ func (cfg *Configuration) Validate() error {
 	if cfg.MemoryMaxLatencyMs > 0 && cfg.MemoryMinLatencyMs == cfg.MemoryMaxLatencyMs {
 		return errors.New("min and max memory latency cannot be equal")
 	}
+	// The window delays all downlinks. The first receive window opens 1
+	// second after the uplink by default.
+	if cfg.DedupWindow < 0 || cfg.DedupWindow > MaxDedupWindow {
+		return fmt.Errorf("the deduplication window must be between 0 and %v", MaxDedupWindow)
+	}
+	if cfg.DownlinkRetries < 0 || cfg.DownlinkRetries > MaxDownlinkRetries {
+		return fmt.Errorf("the number of downlink retries must be between 0 and %d", MaxDownlinkRetries)
+	}
+	if cfg.DownlinkExpiry < 0 {
+		return errors.New("the downlink expiry time can't be negative")
+	}
+	if cfg.JoinServerURL != "" {
+		u, err := url.Parse(cfg.JoinServerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid join server URL: %s", cfg.JoinServerURL)
+		}
+	}
+	if _, err := cfg.GatewayPorts(); err != nil {
+		return err
+	}
+	if cfg.StationPort < 0 || cfg.StationPort > 65535 {
+		return fmt.Errorf("invalid Basics Station port: %d", cfg.StationPort)
+	}
+	if cfg.StationPort != 0 && cfg.StationPort == cfg.HTTPServerPort {
+		return errors.New("the Basics Station port can't be the same as the HTTP port")
+	}
+	if cfg.StationPort != 0 && cfg.DisableGatewayChecks {
+		logging.Warning("Basics Station gateways that aren't registered connect without authentication")
+	}
 	if cfg.ACMECert && cfg.ACMEHost == "" {
 		return errors.New("ACME hostname must be set if ACME certs are used")
 	}
@@ -19,6 +19,7 @@ import (
 	"encoding/hex"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ExploratoryEngineering/congress/protocol"
 )
@@ -70,6 +71,36 @@ func TestValidConfiguration(t *testing.T) {
 		t.Fatalf("Shouldn't get an error with TLS cert file and key file set")
 	}
 
+	config.DedupWindow = time.Second
+	if err := config.Validate(); err == nil {
+		t.Fatalf("Expected error with deduplication window > %v", MaxDedupWindow)
+	}
+	config.DedupWindow = DefaultDedupWindow
+
+	config.DownlinkRetries = MaxDownlinkRetries + 1
+	if err := config.Validate(); err == nil {
+		t.Fatalf("Expected error with downlink retries > %d", MaxDownlinkRetries)
+	}
+	config.DownlinkRetries = DefaultDownlinkRetries
+
+	config.DownlinkExpiry = -time.Second
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected error with negative downlink expiry")
+	}
+	config.DownlinkExpiry = DefaultDownlinkExpiry
+
+	for _, v := range []string{"localhost:8090", "ftp://localhost/join", "http://"} {
+		config.JoinServerURL = v
+		if err := config.Validate(); err == nil {
+			t.Fatalf("Expected error with join server URL %s", v)
+		}
+	}
+	config.JoinServerURL = "https://js.example.com/join"
+	if err := config.Validate(); err != nil {
+		t.Fatal("Expected join server URL to be valid: ", err)
+	}
+	config.JoinServerURL = ""
+
 	config.DBConnectionString = ""
 	config.MemoryDB = false
 	if err := config.Validate(); err == nil {
@@ -146,3 +177,41 @@ func TestInvalidACMEConfig(t *testing.T) {
 		t.Fatal("Did not expect error when ACME host name is set: ", err)
 	}
 }
+
+func TestStationConfig(t *testing.T) {
+	config := NewDefaultConfig()
+	config.MemoryDB = true
+	config.StationPort = -1
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected error with negative station port")
+	}
+	config.StationPort = config.HTTPServerPort
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected error when station port is the HTTP port")
+	}
+	config.StationPort = 6090
+	if err := config.Validate(); err != nil {
+		t.Fatal("Did not expect error with valid station port: ", err)
+	}
+}
+
+func TestGatewayPorts(t *testing.T) {
+	config := NewMemoryNoAuthConfig()
+	config.GatewayPort = 8000
+	if ports, err := config.GatewayPorts(); err != nil || len(ports) != 1 || ports[0] != 8000 {
+		t.Fatalf("Expected the gateway port only but got %v (err=%v)", ports, err)
+	}
+	config.ExtraGatewayPorts = "1700, 1701"
+	if ports, err := config.GatewayPorts(); err != nil || len(ports) != 3 || ports[1] != 1700 || ports[2] != 1701 {
+		t.Fatalf("Expected three ports but got %v (err=%v)", ports, err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected valid configuration but got %v", err)
+	}
+	for _, v := range []string{"foo", "1700,", "0", "65536", "8000", "1700,1700"} {
+		config.ExtraGatewayPorts = v
+		if err := config.Validate(); err == nil {
+			t.Fatalf("Expected error for gateway ports %q", v)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// downlinkQueueSize is the number of notifications that can be waiting for
+// the scheduler.
+const downlinkQueueSize = 100
+
+// DownlinkNotifier notifies the scheduler when there's a new downlink message
+// for a device or a multicast group. Class A devices get their messages after
+// the next uplink so this is only used for class B and C devices.
+type DownlinkNotifier struct {
+	notifications chan protocol.EUI
+	multicast     chan protocol.EUI
+}
+
+// NewDownlinkNotifier creates a new DownlinkNotifier instance
+func NewDownlinkNotifier() DownlinkNotifier {
+	return DownlinkNotifier{
+		notifications: make(chan protocol.EUI, downlinkQueueSize),
+		multicast:     make(chan protocol.EUI, downlinkQueueSize),
+	}
+}
+
+// Notify sends a notification for the device. The notification is dropped if
+// the queue is full.
+func (d *DownlinkNotifier) Notify(deviceEUI protocol.EUI) {
+	if d == nil {
+		return
+	}
+	select {
+	case d.notifications <- deviceEUI:
+	default:
+		logging.Warning("Downlink notification queue is full. Dropping notification for device %s", deviceEUI)
+	}
+}
+
+// Notifications returns the channel with notifications. The channel is nil if
+// the notifier is nil.
+func (d *DownlinkNotifier) Notifications() <-chan protocol.EUI {
+	if d == nil {
+		return nil
+	}
+	return d.notifications
+}
+
+// NotifyMulticast sends a notification for the multicast group. The
+// notification is dropped if the queue is full.
+func (d *DownlinkNotifier) NotifyMulticast(groupEUI protocol.EUI) {
+	if d == nil {
+		return
+	}
+	select {
+	case d.multicast <- groupEUI:
+	default:
+		logging.Warning("Downlink notification queue is full. Dropping notification for multicast group %s", groupEUI)
+	}
+}
+
+// MulticastNotifications returns the channel with notifications for multicast
+// groups. The channel is nil if the notifier is nil.
+func (d *DownlinkNotifier) MulticastNotifications() <-chan protocol.EUI {
+	if d == nil {
+		return nil
+	}
+	return d.multicast
+}
@@ -54,6 +54,8 @@ type KeyGenerator struct {
 	appEUIdispatcher    keyDispatcher
 	deviceEUIdispatcher keyDispatcher
 	outputEUIdispatcher keyDispatcher
+	groupEUIdispatcher  keyDispatcher
+	fuotaEUIdispatcher  keyDispatcher
 	mutex               *sync.Mutex
 	sequences           map[string]*keyDispatcher
 }
@@ -124,6 +126,30 @@ func (k *KeyGenerator) NewOutputEUI() (protocol.EUI, error) {
 	return protocol.NewApplicationEUI(k.ma, k.netID, uint32(newID&0xFFFFFFFF)), err
 }
 
+// NewMulticastEUI generates a new EUI for a multicast group. It uses the same
+// scope as device EUIs.
+func (k *KeyGenerator) NewMulticastEUI() (protocol.EUI, error) {
+	k.groupEUIdispatcher.acquire <- true
+	newID := <-k.groupEUIdispatcher.response
+	var err error
+	if newID > maxID {
+		err = errors.New("key space is exhausted for multicast group EUI")
+	}
+	return protocol.NewDeviceEUI(k.ma, k.netID, uint32(newID&0xFFFFFFFF)), err
+}
+
+// NewCampaignEUI generates a new EUI for a firmware update campaign. It uses
+// the same scope as application EUIs.
+func (k *KeyGenerator) NewCampaignEUI() (protocol.EUI, error) {
+	k.fuotaEUIdispatcher.acquire <- true
+	newID := <-k.fuotaEUIdispatcher.response
+	var err error
+	if newID > maxID {
+		err = errors.New("key space is exhausted for campaign EUI")
+	}
+	return protocol.NewApplicationEUI(k.ma, k.netID, uint32(newID&0xFFFFFFFF)), err
+}
+
 func (d *keyDispatcher) dispatch() {
 	for {
 		<-d.acquire
@@ -175,11 +201,15 @@ func NewEUIKeyGenerator(ma protocol.MA, netID uint32, keyStorage storage.KeySequ
 		appEUIdispatcher:    newDispatcher(10, fmt.Sprintf("%s/%04x/appeui", ma.String(), netID), keyStorage),
 		deviceEUIdispatcher: newDispatcher(100, fmt.Sprintf("%s/%04x/deveui", ma.String(), netID), keyStorage),
 		outputEUIdispatcher: newDispatcher(10, fmt.Sprintf("%s/%04x/outputeui", ma.String(), netID), keyStorage),
+		groupEUIdispatcher:  newDispatcher(10, fmt.Sprintf("%s/%04x/multicasteui", ma.String(), netID), keyStorage),
+		fuotaEUIdispatcher:  newDispatcher(10, fmt.Sprintf("%s/%04x/fuotaeui", ma.String(), netID), keyStorage),
 		sequences:           make(map[string]*keyDispatcher),
 		mutex:               &sync.Mutex{},
 	}
 	go ret.appEUIdispatcher.dispatch()
 	go ret.deviceEUIdispatcher.dispatch()
 	go ret.outputEUIdispatcher.dispatch()
+	go ret.groupEUIdispatcher.dispatch()
+	go ret.fuotaEUIdispatcher.dispatch()
 	return ret, nil
 }
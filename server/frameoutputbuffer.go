@@ -32,11 +32,14 @@ type frameOutput struct {
 	MType             protocol.MType
 	ADR               bool                   // ADR enabled/disabled
 	ACK               bool                   // Ack packet
-	FCnt              uint16                 // Frame counter downstream
+	FCnt              uint32                 // Frame counter downstream
 	Port              uint8                  // Port for output. The port is set as the same time as the payload
 	Payload           []byte                 // The (application) payload. Note: This does not include any MAC commands
 	MACCommands       protocol.MACCommandSet // The MAC commands in the packet
 	JoinAcceptPayload protocol.JoinAcceptPayload
+	Downstream        *model.DownstreamMessage // The downstream message the payload is from. Nil for other payloads
+	Pending           bool                     // More downstream messages are queued for the device
+	JoinAccept        []byte                   // The encrypted JoinAccept message
 }
 
 func newFrameOutput(mtype protocol.MType) frameOutput {
@@ -104,6 +107,7 @@ func (d *FrameOutputBuffer) SetPayload(deviceEUI protocol.EUI, payload []byte, p
 	}
 
 	// Invariant: Payload already exists. Replace (or set it)
+	fd.Downstream = nil
 	fd.Payload = payload
 	fd.Port = port
 	fd.MType = protocol.UnconfirmedDataDown
@@ -113,8 +117,38 @@ func (d *FrameOutputBuffer) SetPayload(deviceEUI protocol.EUI, payload []byte, p
 	d.frameData[deviceEUI] = fd
 }
 
-// SetJoinAcceptPayload sets the JoinAccept payload that should be sent to the device.
-func (d *FrameOutputBuffer) SetJoinAcceptPayload(deviceEUI protocol.EUI, payload protocol.JoinAcceptPayload) {
+// SetDownstreamMessage sets (or overwrites) the existing payload with the
+// payload from the downstream message. The message is set in the frame context
+// when the payload is sent.
+func (d *FrameOutputBuffer) SetDownstreamMessage(msg model.DownstreamMessage) {
+	d.SetPayload(msg.DeviceEUI, msg.Payload(), msg.Port, msg.Ack)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	fd := d.frameData[msg.DeviceEUI]
+	fd.Downstream = &msg
+	d.frameData[msg.DeviceEUI] = fd
+}
+
+// SetFramePendingFlag sets the frame pending flag for the device. The flag
+// signals that there are more downstream messages queued and the device
+// should send an uplink as soon as possible to receive them [4.3.1.7].
+func (d *FrameOutputBuffer) SetFramePendingFlag(deviceEUI protocol.EUI, pending bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	fd, exists := d.frameData[deviceEUI]
+	if !exists {
+		fd = newFrameOutput(protocol.UnconfirmedDataDown)
+	}
+
+	fd.Pending = pending
+	d.frameData[deviceEUI] = fd
+}
+
+// SetJoinAcceptPayload sets the JoinAccept payload that should be sent to the
+// device. The encrypted message is created by the join server.
+func (d *FrameOutputBuffer) SetJoinAcceptPayload(deviceEUI protocol.EUI, payload protocol.JoinAcceptPayload, encrypted []byte) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
@@ -126,6 +160,7 @@ func (d *FrameOutputBuffer) SetJoinAcceptPayload(deviceEUI protocol.EUI, payload
 	}
 
 	fd.JoinAcceptPayload = payload
+	fd.JoinAccept = encrypted
 	fd.Port = 0
 	fd.MType = protocol.JoinAccept
 	d.frameData[deviceEUI] = fd
@@ -173,7 +208,7 @@ func (d *FrameOutputBuffer) GetPHYPayloadForDevice(device *model.Device, context
 	ret.MHDR = mhdr
 	ret.MACPayload.FHDR.DevAddr = device.DevAddr
 	ret.MACPayload.FHDR.FCtrl = fctrl
-	ret.MACPayload.FHDR.FCnt = fd.FCnt
+	ret.MACPayload.FHDR.SetFullFCnt(fd.FCnt)
 	ret.MACPayload.FPort = fd.Port
 	ret.JoinAcceptPayload = fd.JoinAcceptPayload
 
@@ -198,6 +233,10 @@ func (d *FrameOutputBuffer) GetPHYPayloadForDevice(device *model.Device, context
 			ret.MACPayload.FRMPayload = fd.Payload[:]
 			fd.Payload = make([]byte, 0)
 		}
+		// The downstream message is sent when the first part of the payload
+		// is sent.
+		context.Downstream = fd.Downstream
+		fd.Downstream = nil
 
 		// Put MAC commands into the FOpts array
 		list := fd.MACCommands.List()
@@ -210,14 +249,17 @@ func (d *FrameOutputBuffer) GetPHYPayloadForDevice(device *model.Device, context
 		fd.MACCommands.Clear()
 	}
 
-	if len(fd.Payload) > 0 || fd.MACCommands.Size() > 0 {
+	if len(fd.Payload) > 0 || fd.MACCommands.Size() > 0 || fd.Pending {
 		ret.MACPayload.FHDR.FCtrl.FPending = true
 	}
+	fd.Pending = false
 
 	if fd.MType == protocol.JoinAccept {
 		// JoinAccept message is sent. There will be no more frames
+		context.JoinAccept = fd.JoinAccept
 		fd.MType = protocol.UnconfirmedDataDown
 		fd.JoinAcceptPayload = protocol.JoinAcceptPayload{}
+		fd.JoinAccept = nil
 	}
 
 	d.frameData[device.DeviceEUI] = fd
@@ -241,3 +283,18 @@ func (d *FrameOutputBuffer) SetMessageAckFlag(deviceEUI protocol.EUI, ackFlag bo
 	fd.ACK = ackFlag
 	d.frameData[deviceEUI] = fd
 }
+
+// SetADRFlag sets the ADR flag for frames sent to the device. The flag signals
+// that the network server controls the data rate and TX power of the device.
+func (d *FrameOutputBuffer) SetADRFlag(deviceEUI protocol.EUI, adrFlag bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	fd, exists := d.frameData[deviceEUI]
+	if !exists {
+		fd = newFrameOutput(protocol.UnconfirmedDataDown)
+	}
+
+	fd.ADR = adrFlag
+	d.frameData[deviceEUI] = fd
+}
@@ -0,0 +1,564 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/fuota"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// fcntMargin is the number of extra multicast frame counters the devices
+// accept after the fragments. Other messages might be sent to the multicast
+// group while the campaign is running.
+const fcntMargin = 256
+
+// fuotaStatusTimeout is how long the campaign waits for the device status
+// after the multicast session has ended.
+const fuotaStatusTimeout = 24 * time.Hour
+
+// fuotaPriority is the priority for FUOTA commands in the downstream queue.
+// The commands are sent before the application's messages.
+const fuotaPriority = 255
+
+// FUOTAManager runs the firmware update campaigns. Each active campaign runs
+// in a separate goroutine that sets up the devices, sends the fragments to the
+// multicast group and collects the status from the devices.
+type FUOTAManager struct {
+	context   *Context
+	mutex     *sync.Mutex
+	campaigns map[protocol.EUI]*fuotaRunner
+}
+
+// NewFUOTAManager creates a new FUOTA manager
+func NewFUOTAManager(context *Context) *FUOTAManager {
+	return &FUOTAManager{
+		context:   context,
+		mutex:     &sync.Mutex{},
+		campaigns: make(map[protocol.EUI]*fuotaRunner),
+	}
+}
+
+// LoadCampaigns resumes all of the active campaigns in the storage
+func (f *FUOTAManager) LoadCampaigns() {
+	campaigns, err := f.context.Storage.FUOTA.ListActive()
+	if err != nil {
+		logging.Error("Unable to load FUOTA campaigns: %v", err)
+		return
+	}
+	count := 0
+	for v := range campaigns {
+		f.launch(v)
+		count++
+	}
+	logging.Info("Launched %d FUOTA campaigns", count)
+}
+
+// Start launches the campaign. The campaign and its devices must be stored
+// before the campaign is started.
+func (f *FUOTAManager) Start(campaignEUI protocol.EUI) {
+	if f == nil {
+		return
+	}
+	campaign, err := f.context.Storage.FUOTA.GetByEUI(campaignEUI)
+	if err != nil {
+		logging.Warning("Unable to retrieve FUOTA campaign %s: %v", campaignEUI, err)
+		return
+	}
+	f.launch(campaign)
+}
+
+func (f *FUOTAManager) launch(campaign model.FUOTACampaign) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, exists := f.campaigns[campaign.CampaignEUI]; exists {
+		return
+	}
+	runner, err := newFUOTARunner(f.context, campaign)
+	if err != nil {
+		logging.Warning("Unable to launch FUOTA campaign %s: %v", campaign.CampaignEUI, err)
+		campaign.State = model.FUOTAFailed
+		if err := f.context.Storage.FUOTA.Update(campaign); err != nil {
+			logging.Warning("Unable to update FUOTA campaign %s: %v", campaign.CampaignEUI, err)
+		}
+		return
+	}
+	f.campaigns[campaign.CampaignEUI] = runner
+	go func() {
+		runner.run()
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		delete(f.campaigns, campaign.CampaignEUI)
+	}()
+}
+
+// Cancel stops a running campaign. The devices are told to remove the
+// fragmentation session.
+func (f *FUOTAManager) Cancel(campaignEUI protocol.EUI) {
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	runner, exists := f.campaigns[campaignEUI]
+	f.mutex.Unlock()
+	if !exists {
+		return
+	}
+	select {
+	case runner.cancel <- true:
+	case <-runner.done:
+	}
+	<-runner.done
+}
+
+// Shutdown stops all of the running campaigns. The campaigns are resumed the
+// next time LoadCampaigns is called.
+func (f *FUOTAManager) Shutdown() {
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	runners := make([]*fuotaRunner, 0, len(f.campaigns))
+	for _, v := range f.campaigns {
+		runners = append(runners, v)
+	}
+	f.mutex.Unlock()
+	for _, v := range runners {
+		select {
+		case v.terminate <- true:
+		case <-v.done:
+		}
+		<-v.done
+	}
+}
+
+// fuotaRunner runs a single campaign
+type fuotaRunner struct {
+	context   *Context
+	campaign  model.FUOTACampaign
+	group     model.MulticastGroup
+	devices   map[protocol.EUI]*model.FUOTADevice
+	fragments [][]byte
+	nbFrag    int
+	padding   int
+	cancel    chan bool
+	terminate chan bool
+	done      chan bool
+}
+
+func newFUOTARunner(context *Context, campaign model.FUOTACampaign) (*fuotaRunner, error) {
+	ret := &fuotaRunner{
+		context:   context,
+		campaign:  campaign,
+		devices:   make(map[protocol.EUI]*model.FUOTADevice),
+		cancel:    make(chan bool),
+		terminate: make(chan bool),
+		done:      make(chan bool),
+	}
+	var err error
+	if ret.group, err = context.Storage.Multicast.GetByEUI(campaign.GroupEUI); err != nil {
+		return nil, err
+	}
+	if ret.fragments, ret.nbFrag, ret.padding, err = fuota.Fragments(campaign.Firmware, int(campaign.FragSize), int(campaign.Redundancy)); err != nil {
+		return nil, err
+	}
+	devices, err := context.Storage.FUOTA.GetDevices(campaign.CampaignEUI)
+	if err != nil {
+		return nil, err
+	}
+	for v := range devices {
+		device := v
+		ret.devices[v.DeviceEUI] = &device
+	}
+	return ret, nil
+}
+
+// run runs the campaign until it is completed, cancelled or terminated.
+func (r *fuotaRunner) run() {
+	defer close(r.done)
+
+	uplinks := r.context.AppRouter.Subscribe(r.campaign.AppEUI)
+	defer r.context.AppRouter.Unsubscribe(uplinks)
+
+	if r.campaign.State == model.FUOTASetup {
+		for _, v := range r.devices {
+			r.sendSetupCommand(v)
+		}
+	}
+	sessionStart := time.After(time.Until(time.Unix(r.campaign.SessionTime, 0)))
+	interval := time.Duration(r.campaign.Interval) * time.Second
+	if interval == 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logging.Info("FUOTA campaign %s is running (%d fragments, %d devices)", r.campaign.CampaignEUI, len(r.fragments), len(r.devices))
+	for r.campaign.State.IsActive() {
+		select {
+		case p := <-uplinks:
+			switch msg := p.(type) {
+			case *PayloadMessage:
+				r.processUplink(msg)
+			case *DownstreamStatus, *JoinMessage:
+				// The campaign only needs the uplink payloads
+			default:
+				logging.Error("Unexpected type %T on channel. Publisher error?", p)
+			}
+
+		case <-sessionStart:
+			if r.campaign.State == model.FUOTASetup {
+				r.startSession()
+			}
+
+		case <-ticker.C:
+			r.tick()
+
+		case <-r.cancel:
+			r.cancelCampaign()
+			return
+
+		case <-r.terminate:
+			return
+		}
+	}
+	logging.Info("FUOTA campaign %s ended with state %s", r.campaign.CampaignEUI, r.campaign.State)
+}
+
+func (r *fuotaRunner) setState(state model.FUOTAState) {
+	r.campaign.State = state
+	if err := r.context.Storage.FUOTA.Update(r.campaign); err != nil {
+		logging.Warning("Unable to update FUOTA campaign %s: %v", r.campaign.CampaignEUI, err)
+	}
+}
+
+func (r *fuotaRunner) setDeviceState(device *model.FUOTADevice, state model.FUOTADeviceState) {
+	device.State = state
+	device.UpdatedTime = time.Now().Unix()
+	if err := r.context.Storage.FUOTA.UpdateDevice(*device); err != nil {
+		logging.Warning("Unable to update device %s in FUOTA campaign %s: %v", device.DeviceEUI, r.campaign.CampaignEUI, err)
+	}
+}
+
+func (r *fuotaRunner) failDevice(device *model.FUOTADevice, err error) {
+	logging.Info("Device %s failed in FUOTA campaign %s: %v", device.DeviceEUI, r.campaign.CampaignEUI, err)
+	device.Error = err.Error()
+	r.setDeviceState(device, model.FUOTADeviceFailed)
+}
+
+// queueCommand queues the command at the head of the device's downstream
+// queue. Earlier FUOTA commands in the queue are replaced.
+func (r *fuotaRunner) queueCommand(deviceEUI protocol.EUI, port uint8, command []byte) error {
+	queue, err := r.context.Storage.DeviceData.ListDownstream(deviceEUI)
+	if err != nil {
+		return err
+	}
+	for _, v := range queue {
+		if v.Port != fuota.MulticastSetupPort && v.Port != fuota.FragmentationPort {
+			continue
+		}
+		if err := r.context.Storage.DeviceData.DeleteDownstreamMessage(deviceEUI, v.ID); err != nil && err != storage.ErrNotFound {
+			return err
+		}
+	}
+	msg := model.NewDownstreamMessage(deviceEUI, port)
+	msg.Data = hex.EncodeToString(command)
+	msg.Priority = fuotaPriority
+	if err := r.context.Storage.DeviceData.PutDownstream(deviceEUI, msg); err != nil {
+		return err
+	}
+	r.context.Downlinks.Notify(deviceEUI)
+	return nil
+}
+
+// sessionTime returns the start of the multicast session as GPS time
+func (r *fuotaRunner) sessionTime() uint32 {
+	return uint32(protocol.GPSTime(time.Unix(r.campaign.SessionTime, 0)) / time.Second)
+}
+
+// sendSetupCommand sends the next setup command to the device. The device is
+// set up with McGroupSetupReq, FragSessionSetupReq and finally the class B or
+// class C session request.
+func (r *fuotaRunner) sendSetupCommand(device *model.FUOTADevice) {
+	var port uint8
+	var command []byte
+	switch device.State {
+	case model.FUOTADeviceGroupSetup:
+		d, err := r.context.Storage.Device.GetByEUI(device.DeviceEUI)
+		if err != nil {
+			r.failDevice(device, err)
+			return
+		}
+		rootKey, err := fuota.McRootKey(d.GenAppKey, d.AppKey, d.MACVersion)
+		if err != nil {
+			r.failDevice(device, err)
+			return
+		}
+		encrypted, err := fuota.EncryptMcKey(rootKey, r.campaign.McKey)
+		if err != nil {
+			r.failDevice(device, err)
+			return
+		}
+		port = fuota.MulticastSetupPort
+		command = fuota.McGroupSetupReq{
+			GroupID:        r.campaign.GroupID,
+			McAddr:         r.group.McAddr,
+			McKeyEncrypted: encrypted,
+			MinFCnt:        r.group.FCntDn,
+			MaxFCnt:        r.group.FCntDn + uint32(len(r.fragments)) + fcntMargin,
+		}.Encode()
+
+	case model.FUOTADeviceFragSetup:
+		port = fuota.FragmentationPort
+		command = fuota.FragSessionSetupReq{
+			FragIndex:   r.campaign.FragIndex,
+			McGroupMask: 1 << r.campaign.GroupID,
+			NbFrag:      uint16(r.nbFrag),
+			FragSize:    r.campaign.FragSize,
+			Padding:     uint8(r.padding),
+			Descriptor:  r.campaign.Descriptor,
+		}.Encode()
+
+	case model.FUOTADeviceSessionSetup:
+		port = fuota.MulticastSetupPort
+		command = fuota.McSessionReq{
+			ClassB:      r.group.Class == model.ClassB,
+			GroupID:     r.campaign.GroupID,
+			SessionTime: r.sessionTime(),
+			TimeOut:     r.campaign.TimeOut,
+			Periodicity: r.group.Periodicity,
+			Frequency:   r.group.Frequency,
+			DataRate:    r.group.DataRate,
+		}.Encode()
+
+	default:
+		return
+	}
+	if err := r.queueCommand(device.DeviceEUI, port, command); err != nil {
+		logging.Warning("Unable to schedule FUOTA command for device %s: %v", device.DeviceEUI, err)
+	}
+}
+
+// processUplink processes the answers from the devices
+func (r *fuotaRunner) processUplink(msg *PayloadMessage) {
+	device, ok := r.devices[msg.Device.DeviceEUI]
+	if !ok {
+		return
+	}
+	var answers []fuota.Answer
+	var err error
+	switch msg.Port {
+	case fuota.MulticastSetupPort:
+		answers, err = fuota.DecodeMulticastSetupAnswers(msg.Payload)
+	case fuota.FragmentationPort:
+		answers, err = fuota.DecodeFragmentationAnswers(msg.Payload)
+	default:
+		return
+	}
+	if err != nil {
+		logging.Info("Unable to decode FUOTA answer from device %s: %v", device.DeviceEUI, err)
+	}
+	for _, v := range answers {
+		r.processAnswer(device, v)
+	}
+}
+
+func (r *fuotaRunner) processAnswer(device *model.FUOTADevice, answer fuota.Answer) {
+	switch ans := answer.(type) {
+	case *fuota.McGroupSetupAns:
+		if device.State != model.FUOTADeviceGroupSetup || ans.GroupID != r.campaign.GroupID {
+			return
+		}
+		if err := ans.Err(); err != nil {
+			r.failDevice(device, err)
+			return
+		}
+		r.setDeviceState(device, model.FUOTADeviceFragSetup)
+		r.sendSetupCommand(device)
+
+	case *fuota.FragSessionSetupAns:
+		if device.State != model.FUOTADeviceFragSetup || ans.FragIndex != r.campaign.FragIndex {
+			return
+		}
+		if err := ans.Err(); err != nil {
+			r.failDevice(device, err)
+			return
+		}
+		r.setDeviceState(device, model.FUOTADeviceSessionSetup)
+		r.sendSetupCommand(device)
+
+	case *fuota.McSessionAns:
+		if device.State != model.FUOTADeviceSessionSetup || ans.GroupID != r.campaign.GroupID {
+			return
+		}
+		if err := ans.Err(); err != nil {
+			r.failDevice(device, err)
+			return
+		}
+		r.setDeviceState(device, model.FUOTADeviceReady)
+
+	case *fuota.FragSessionStatusAns:
+		if ans.FragIndex != r.campaign.FragIndex || device.State < model.FUOTADeviceReady || device.State == model.FUOTADeviceFailed {
+			return
+		}
+		device.NbFragReceived = ans.NbFragReceived
+		device.MissingFrag = ans.MissingFrag
+		if err := ans.Err(); err != nil {
+			r.failDevice(device, err)
+			return
+		}
+		switch {
+		case ans.MissingFrag == 0 && ans.NbFragReceived > 0:
+			r.setDeviceState(device, model.FUOTADeviceComplete)
+		case r.campaign.State == model.FUOTAStatus:
+			r.setDeviceState(device, model.FUOTADeviceIncomplete)
+		default:
+			r.setDeviceState(device, device.State)
+		}
+
+	case *fuota.PackageVersionAns:
+		logging.Debug("Device %s supports version %d of package %d", device.DeviceEUI, ans.Version, ans.Package)
+
+	default:
+		if err := answer.Err(); err != nil {
+			logging.Info("Device %s rejected FUOTA command: %v", device.DeviceEUI, err)
+		}
+	}
+}
+
+// startSession starts sending fragments when the multicast session starts.
+// Devices that aren't set up are dropped from the campaign.
+func (r *fuotaRunner) startSession() {
+	ready := 0
+	for _, v := range r.devices {
+		switch v.State {
+		case model.FUOTADeviceReady:
+			ready++
+		case model.FUOTADeviceGroupSetup, model.FUOTADeviceFragSetup, model.FUOTADeviceSessionSetup:
+			r.failDevice(v, errors.New("device wasn't set up before the session started"))
+		}
+	}
+	if ready == 0 {
+		logging.Warning("No devices are ready for FUOTA campaign %s", r.campaign.CampaignEUI)
+		r.setState(model.FUOTAFailed)
+		return
+	}
+	r.setState(model.FUOTAFragmenting)
+}
+
+// tick sends the next fragment while the campaign is fragmenting and
+// completes the campaign when all of the devices have reported their status.
+func (r *fuotaRunner) tick() {
+	sessionEnd := r.campaign.SessionEnd(r.group.Class)
+	switch r.campaign.State {
+	case model.FUOTAFragmenting:
+		if time.Now().After(sessionEnd) {
+			logging.Warning("Multicast session for FUOTA campaign %s ended before all fragments were sent", r.campaign.CampaignEUI)
+			r.startStatus()
+			return
+		}
+		r.sendFragment()
+
+	case model.FUOTAStatus:
+		for _, v := range r.devices {
+			if v.State == model.FUOTADeviceReady && time.Now().Before(sessionEnd.Add(fuotaStatusTimeout)) {
+				return
+			}
+		}
+		r.setState(model.FUOTAComplete)
+	}
+}
+
+// sendFragment schedules the next fragment as the multicast group's downlink
+// message. Fragments that couldn't be sent are retried.
+func (r *fuotaRunner) sendFragment() {
+	groupEUI := r.campaign.GroupEUI
+	msg, err := r.context.Storage.Multicast.GetDownstream(groupEUI)
+	if err == nil {
+		if !msg.IsComplete() {
+			r.context.Downlinks.NotifyMulticast(groupEUI)
+			return
+		}
+		if err := r.context.Storage.Multicast.DeleteDownstream(groupEUI); err != nil {
+			logging.Warning("Unable to remove sent message for multicast group %s: %v", groupEUI, err)
+			return
+		}
+	}
+	if int(r.campaign.FragmentsSent) >= len(r.fragments) {
+		r.startStatus()
+		return
+	}
+	n := r.campaign.FragmentsSent + 1
+	msg = model.NewMulticastMessage(groupEUI, fuota.FragmentationPort)
+	msg.Data = hex.EncodeToString(fuota.EncodeDataFragment(r.campaign.FragIndex, n, r.fragments[n-1]))
+	if err := r.context.Storage.Multicast.PutDownstream(groupEUI, msg); err != nil {
+		logging.Warning("Unable to schedule fragment %d for multicast group %s: %v", n, groupEUI, err)
+		return
+	}
+	r.context.Downlinks.NotifyMulticast(groupEUI)
+	r.campaign.FragmentsSent = n
+	r.setState(r.campaign.State)
+}
+
+// startStatus asks the devices for the status of the fragmentation session
+func (r *fuotaRunner) startStatus() {
+	r.removeFragment()
+	command := fuota.FragSessionStatusReq{FragIndex: r.campaign.FragIndex, AllParticipants: true}.Encode()
+	for _, v := range r.devices {
+		if v.State != model.FUOTADeviceReady {
+			continue
+		}
+		if err := r.queueCommand(v.DeviceEUI, fuota.FragmentationPort, command); err != nil {
+			logging.Warning("Unable to schedule FragSessionStatusReq for device %s: %v", v.DeviceEUI, err)
+		}
+	}
+	r.setState(model.FUOTAStatus)
+}
+
+// removeFragment removes the fragment scheduled for the multicast group if it
+// hasn't been sent
+func (r *fuotaRunner) removeFragment() {
+	msg, err := r.context.Storage.Multicast.GetDownstream(r.campaign.GroupEUI)
+	if err == nil && !msg.IsComplete() && msg.Port == fuota.FragmentationPort {
+		if err := r.context.Storage.Multicast.DeleteDownstream(r.campaign.GroupEUI); err != nil {
+			logging.Warning("Unable to remove fragment for multicast group %s: %v", r.campaign.GroupEUI, err)
+		}
+	}
+}
+
+// cancelCampaign stops the campaign and removes the fragmentation session
+// from the devices that have set it up.
+func (r *fuotaRunner) cancelCampaign() {
+	r.removeFragment()
+	command := fuota.FragSessionDeleteReq{FragIndex: r.campaign.FragIndex}.Encode()
+	for _, v := range r.devices {
+		switch v.State {
+		case model.FUOTADeviceSessionSetup, model.FUOTADeviceReady, model.FUOTADeviceIncomplete:
+			if err := r.queueCommand(v.DeviceEUI, fuota.FragmentationPort, command); err != nil {
+				logging.Warning("Unable to schedule FragSessionDeleteReq for device %s: %v", v.DeviceEUI, err)
+			}
+		}
+	}
+	r.setState(model.FUOTACancelled)
+	logging.Info("FUOTA campaign %s is cancelled", r.campaign.CampaignEUI)
+}
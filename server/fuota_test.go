@@ -0,0 +1,205 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/fuota"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+	"github.com/ExploratoryEngineering/pubsub"
+)
+
+// waitForCommand waits for a FUOTA command to be scheduled for the device
+func waitForCommand(t *testing.T, context *Context, deviceEUI protocol.EUI, port uint8, command uint8) []byte {
+	for i := 0; i < 100; i++ {
+		msg, err := context.Storage.DeviceData.GetDownstream(deviceEUI)
+		if err == nil && msg.Port == port && len(msg.Payload()) > 0 && msg.Payload()[0] == command {
+			return msg.Payload()
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Command %d on port %d not scheduled for device", command, port)
+	return nil
+}
+
+func waitForDeviceState(t *testing.T, context *Context, campaignEUI protocol.EUI, state model.FUOTADeviceState) {
+	for i := 0; i < 100; i++ {
+		ch, _ := context.Storage.FUOTA.GetDevices(campaignEUI)
+		for v := range ch {
+			if v.State == state {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Device never reached state %s", state)
+}
+
+func TestFUOTACampaign(t *testing.T) {
+	datastore := memstore.CreateMemoryStorage(0, 0)
+	router := pubsub.NewEventRouter(5)
+	downlinks := NewDownlinkNotifier()
+	context := &Context{Storage: &datastore, AppRouter: &router, Downlinks: &downlinks}
+
+	app := model.NewApplication()
+	app.AppEUI = makeRandomEUI()
+	datastore.Application.Put(app, model.SystemUserID)
+
+	device := model.NewDevice()
+	device.DeviceEUI = makeRandomEUI()
+	device.AppEUI = app.AppEUI
+	device.AppKey, _ = protocol.NewAESKey()
+	device.GenAppKey, _ = protocol.NewAESKey()
+	device.DevAddr = protocol.DevAddrFromUint32(0x01020304)
+	if err := datastore.Device.Put(device, app.AppEUI); err != nil {
+		t.Fatal(err)
+	}
+
+	group := model.NewMulticastGroup()
+	group.GroupEUI = makeRandomEUI()
+	group.AppEUI = app.AppEUI
+	group.McAddr = protocol.DevAddrFromUint32(0x0A0B0C0D)
+	group.Frequency = 869.525
+	group.FCntDn = 10
+	datastore.Multicast.Put(group)
+
+	campaign := model.NewFUOTACampaign()
+	campaign.CampaignEUI = makeRandomEUI()
+	campaign.AppEUI = app.AppEUI
+	campaign.GroupEUI = group.GroupEUI
+	campaign.McKey, _ = protocol.NewAESKey()
+	campaign.Firmware = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	campaign.FragSize = 5
+	campaign.Redundancy = 1
+	campaign.Interval = 1
+	campaign.SessionTime = time.Now().Add(2 * time.Second).Unix()
+	campaign.TimeOut = 6
+	datastore.FUOTA.Put(campaign)
+	datastore.FUOTA.PutDevice(model.FUOTADevice{CampaignEUI: campaign.CampaignEUI, DeviceEUI: device.DeviceEUI})
+
+	manager := NewFUOTAManager(context)
+	manager.Start(campaign.CampaignEUI)
+	defer manager.Shutdown()
+
+	answer := func(port uint8, payload ...byte) {
+		router.Publish(app.AppEUI, &PayloadMessage{Device: device, Port: port, Payload: payload})
+	}
+
+	// The device is set up
+	setup := waitForCommand(t, context, device.DeviceEUI, fuota.MulticastSetupPort, fuota.McGroupSetup)
+	if len(setup) != 30 || !bytes.Equal(setup[22:26], []byte{10, 0, 0, 0}) {
+		t.Fatalf("Unexpected McGroupSetupReq: %x", setup)
+	}
+	answer(fuota.MulticastSetupPort, fuota.McGroupSetup, 0x00)
+	fragSetup := waitForCommand(t, context, device.DeviceEUI, fuota.FragmentationPort, fuota.FragSessionSetup)
+	if fragSetup[2] != 2 || fragSetup[4] != 5 || fragSetup[6] != 0 {
+		t.Fatalf("Unexpected FragSessionSetupReq: %x", fragSetup)
+	}
+	answer(fuota.FragmentationPort, fuota.FragSessionSetup, 0x00)
+	waitForCommand(t, context, device.DeviceEUI, fuota.MulticastSetupPort, fuota.McClassCSession)
+	answer(fuota.MulticastSetupPort, fuota.McClassCSession, 0x00, 0x01, 0x00, 0x00)
+	waitForDeviceState(t, context, campaign.CampaignEUI, model.FUOTADeviceReady)
+
+	// The fragments are sent to the group when the session starts. Mark
+	// each fragment as sent.
+	var received [][]byte
+	for i := 0; i < 200 && len(received) < 3; i++ {
+		msg, err := datastore.Multicast.GetDownstream(group.GroupEUI)
+		if err == nil && !msg.IsComplete() {
+			received = append(received, msg.Payload())
+			datastore.Multicast.UpdateDownstream(group.GroupEUI, time.Now().Unix())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(received) != 3 {
+		t.Fatalf("Expected 3 fragments but got %d", len(received))
+	}
+	if !bytes.Equal(received[0], []byte{fuota.DataFragment, 1, 0, 1, 2, 3, 4, 5}) ||
+		!bytes.Equal(received[1], []byte{fuota.DataFragment, 2, 0, 6, 7, 8, 9, 10}) {
+		t.Fatalf("Unexpected fragments: %x", received)
+	}
+
+	// ...and the device is asked for the status
+	waitForCommand(t, context, device.DeviceEUI, fuota.FragmentationPort, fuota.FragSessionStatus)
+	answer(fuota.FragmentationPort, fuota.FragSessionStatus, 0x03, 0x00, 0x00, 0x00)
+	waitForDeviceState(t, context, campaign.CampaignEUI, model.FUOTADeviceComplete)
+
+	for i := 0; i < 100; i++ {
+		stored, _ := datastore.FUOTA.GetByEUI(campaign.CampaignEUI)
+		if stored.State == model.FUOTAComplete {
+			if stored.FragmentsSent != 3 {
+				t.Fatalf("Expected 3 fragments sent but got %d", stored.FragmentsSent)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("Campaign did not complete")
+}
+
+func TestFUOTACancel(t *testing.T) {
+	var nilManager *FUOTAManager
+	nilManager.Start(makeRandomEUI())
+	nilManager.Cancel(makeRandomEUI())
+	nilManager.Shutdown()
+
+	datastore := memstore.CreateMemoryStorage(0, 0)
+	router := pubsub.NewEventRouter(5)
+	context := &Context{Storage: &datastore, AppRouter: &router}
+
+	group := model.NewMulticastGroup()
+	group.GroupEUI = makeRandomEUI()
+	datastore.Multicast.Put(group)
+
+	campaign := model.NewFUOTACampaign()
+	campaign.CampaignEUI = makeRandomEUI()
+	campaign.GroupEUI = group.GroupEUI
+	campaign.Firmware = []byte{1, 2, 3}
+	campaign.FragSize = 1
+	campaign.Interval = 1
+	campaign.SessionTime = time.Now().Add(time.Hour).Unix()
+	datastore.FUOTA.Put(campaign)
+	deviceEUI := makeRandomEUI()
+	datastore.FUOTA.PutDevice(model.FUOTADevice{CampaignEUI: campaign.CampaignEUI, DeviceEUI: deviceEUI, State: model.FUOTADeviceReady})
+
+	manager := NewFUOTAManager(context)
+	manager.Start(campaign.CampaignEUI)
+	manager.Cancel(campaign.CampaignEUI)
+
+	stored, _ := datastore.FUOTA.GetByEUI(campaign.CampaignEUI)
+	if stored.State != model.FUOTACancelled {
+		t.Fatalf("Expected campaign to be cancelled but state is %s", stored.State)
+	}
+	msg, err := datastore.DeviceData.GetDownstream(deviceEUI)
+	if err != nil || msg.Port != fuota.FragmentationPort || msg.Payload()[0] != fuota.FragSessionDelete {
+		t.Fatalf("Expected FragSessionDeleteReq for device but got %+v (err=%v)", msg, err)
+	}
+
+	// Campaigns with missing multicast groups fail
+	campaign.CampaignEUI = makeRandomEUI()
+	campaign.GroupEUI = makeRandomEUI()
+	datastore.FUOTA.Put(campaign)
+	manager.Start(campaign.CampaignEUI)
+	stored, _ = datastore.FUOTA.GetByEUI(campaign.CampaignEUI)
+	if stored.State != model.FUOTAFailed {
+		t.Fatalf("Expected campaign to fail but state is %s", stored.State)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sync"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// gatewayBand is a cached gateway and the band it uses
+type gatewayBand struct {
+	gateway model.Gateway
+	plan    band.FrequencyPlan
+}
+
+// GatewayBands caches the gateways and their bands so the forwarders don't
+// have to read the gateway from the storage and create the band for every
+// packet. The gateway is removed from the cache when it is created, updated
+// or removed through the API and the next packet reads it again.
+type GatewayBands struct {
+	mutex    *sync.Mutex
+	gateways map[protocol.EUI]gatewayBand
+}
+
+// NewGatewayBands creates a new GatewayBands instance
+func NewGatewayBands() GatewayBands {
+	return GatewayBands{mutex: &sync.Mutex{}, gateways: make(map[protocol.EUI]gatewayBand)}
+}
+
+// Get returns the cached gateway and its band. The boolean flag is false if
+// the gateway isn't cached.
+func (g *GatewayBands) Get(gatewayEUI protocol.EUI) (model.Gateway, band.FrequencyPlan, bool) {
+	if g == nil {
+		return model.Gateway{}, nil, false
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	gw, ok := g.gateways[gatewayEUI]
+	return gw.gateway, gw.plan, ok
+}
+
+// Put caches the gateway and its band
+func (g *GatewayBands) Put(gateway model.Gateway, plan band.FrequencyPlan) {
+	if g == nil {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.gateways[gateway.GatewayEUI] = gatewayBand{gateway: gateway, plan: plan}
+}
+
+// Remove removes the gateway from the cache
+func (g *GatewayBands) Remove(gatewayEUI protocol.EUI) {
+	if g == nil {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.gateways, gatewayEUI)
+}
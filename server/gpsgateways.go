@@ -0,0 +1,90 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// gpsGatewayTimeout is the time a gateway is considered to be GPS synchronized
+// after the last time stamped packet.
+const gpsGatewayTimeout = time.Hour
+
+// GPSGateway is a gateway with a GPS synchronized clock
+type GPSGateway struct {
+	Gateway  GatewayContext     // The gateway's context
+	Band     band.FrequencyPlan // The band the gateway uses
+	LastSeen time.Time          // The last time the gateway reported a GPS time stamp
+}
+
+// GPSGateways keeps track of the gateways with a GPS synchronized clock. Only
+// these gateways can send class B beacons and downlinks in ping slots since
+// the transmissions must be scheduled at an absolute time.
+type GPSGateways struct {
+	mutex    *sync.Mutex
+	gateways map[protocol.EUI]GPSGateway
+}
+
+// NewGPSGateways creates a new GPSGateways instance
+func NewGPSGateways() GPSGateways {
+	return GPSGateways{mutex: &sync.Mutex{}, gateways: make(map[protocol.EUI]GPSGateway)}
+}
+
+// Update registers the gateway as GPS synchronized. The forwarders call this
+// every time they receive a packet with a GPS time stamp.
+func (g *GPSGateways) Update(gateway GatewayContext, plan band.FrequencyPlan) {
+	if g == nil {
+		return
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.gateways[gateway.GatewayEUI] = GPSGateway{Gateway: gateway, Band: plan, LastSeen: time.Now()}
+}
+
+// Synchronized returns true if the gateway has reported a GPS time stamp
+// recently.
+func (g *GPSGateways) Synchronized(gatewayEUI protocol.EUI) bool {
+	if g == nil {
+		return false
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	gw, ok := g.gateways[gatewayEUI]
+	return ok && time.Since(gw.LastSeen) < gpsGatewayTimeout
+}
+
+// List returns the gateways that have reported a GPS time stamp recently.
+// Gateways that haven't been heard from are removed from the list.
+func (g *GPSGateways) List() []GPSGateway {
+	if g == nil {
+		return nil
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var ret []GPSGateway
+	for eui, gw := range g.gateways {
+		if time.Since(gw.LastSeen) >= gpsGatewayTimeout {
+			delete(g.gateways, eui)
+			continue
+		}
+		ret = append(ret, gw)
+	}
+	return ret
+}
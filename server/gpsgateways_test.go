@@ -0,0 +1,60 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+)
+
+func TestGPSGateways(t *testing.T) {
+	var nilGateways *GPSGateways
+	nilGateways.Update(GatewayContext{}, nil)
+	if nilGateways.Synchronized(makeRandomEUI()) || len(nilGateways.List()) != 0 {
+		t.Fatal("Nil instance should be empty")
+	}
+
+	g := NewGPSGateways()
+	gw1 := GatewayContext{GatewayEUI: makeRandomEUI()}
+	gw2 := GatewayContext{GatewayEUI: makeRandomEUI()}
+	if g.Synchronized(gw1.GatewayEUI) {
+		t.Fatal("Unknown gateway should not be synchronized")
+	}
+	g.Update(gw1, nil)
+	g.Update(gw2, nil)
+	g.Update(gw1, nil)
+	if !g.Synchronized(gw1.GatewayEUI) || !g.Synchronized(gw2.GatewayEUI) {
+		t.Fatal("Gateways should be synchronized")
+	}
+	if len(g.List()) != 2 {
+		t.Fatalf("Expected 2 gateways but got %d", len(g.List()))
+	}
+
+	// Expire the 2nd gateway
+	g.mutex.Lock()
+	expired := g.gateways[gw2.GatewayEUI]
+	expired.LastSeen = time.Now().Add(-gpsGatewayTimeout)
+	g.gateways[gw2.GatewayEUI] = expired
+	g.mutex.Unlock()
+
+	if g.Synchronized(gw2.GatewayEUI) {
+		t.Fatal("Gateway should have expired")
+	}
+	list := g.List()
+	if len(list) != 1 || list[0].Gateway != gw1 {
+		t.Fatalf("Expected only the first gateway but got %v", list)
+	}
+}
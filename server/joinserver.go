@@ -0,0 +1,259 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"errors"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+)
+
+// JoinRequest is the request the network server sends to the join server
+// when a device joins or rejoins the network. It is modelled on the JoinReq
+// message in the LoRaWAN Backend Interfaces specification.
+type JoinRequest struct {
+	NetID      uint32              // The network ID of the network server
+	MACVersion protocol.MACVersion // The LoRaWAN version of the device
+	PHYPayload []byte              // The JoinRequest or Rejoin-request as received from the device
+	DevEUI     protocol.EUI        // The device EUI
+	JoinEUI    protocol.EUI        // The JoinEUI (aka AppEUI) for the device
+	DevAddr    protocol.DevAddr    // The device address assigned by the network server
+	DLSettings protocol.DLSettings // Downlink settings for the JoinAccept message
+	RxDelay    uint8               // RX1 delay for the JoinAccept message
+	CFList     protocol.CFList     // Channel list for the JoinAccept message. Empty lists are omitted
+}
+
+// JoinAnswer is the join server's response to a JoinRequest. It is modelled
+// on the JoinAns message in the LoRaWAN Backend Interfaces specification.
+type JoinAnswer struct {
+	PHYPayload  []byte          // The encrypted JoinAccept message
+	NwkSKey     protocol.AESKey // NwkSKey for LoRaWAN 1.0 devices, FNwkSIntKey for LoRaWAN 1.1 devices
+	SNwkSIntKey protocol.AESKey // LoRaWAN 1.1 only
+	NwkSEncKey  protocol.AESKey // LoRaWAN 1.1 only
+	AppSKey     protocol.AESKey // The application session key
+
+	// The AppSKey is wrapped with a key encryption key (KEK) when the
+	// payloads are encrypted end-to-end. The AppSKey field isn't set then.
+	KEKLabel       string
+	WrappedAppSKey []byte
+}
+
+// Wrapped returns true if the AppSKey is wrapped with a KEK
+func (j *JoinAnswer) Wrapped() bool {
+	return len(j.WrappedAppSKey) > 0
+}
+
+// WrapAppSKey wraps the AppSKey with the KEK and clears the AppSKey field.
+// This is used by join servers that hold the application's KEK.
+func (j *JoinAnswer) WrapAppSKey(kekLabel string, kek protocol.AESKey) error {
+	wrapped, err := protocol.WrapKey(kek, j.AppSKey)
+	if err != nil {
+		return err
+	}
+	j.KEKLabel = kekLabel
+	j.WrappedAppSKey = wrapped
+	j.AppSKey = protocol.AESKey{}
+	return nil
+}
+
+// JoinServer handles the root keys for OTAA devices. It verifies the
+// JoinRequest and Rejoin-request messages from the devices, generates the
+// session keys and encrypts the JoinAccept message.
+type JoinServer interface {
+	// Join processes the request and returns the JoinAccept message and the
+	// session keys for the device.
+	Join(req JoinRequest) (JoinAnswer, error)
+}
+
+// Errors returned by the join servers. These map to the result codes in the
+// JoinAns message.
+var (
+	ErrUnknownDevice    = errors.New("unknown device")
+	ErrMICFailed        = errors.New("invalid MIC")
+	ErrFrameReplayed    = errors.New("nonce has been used before")
+	ErrMalformedRequest = errors.New("malformed join request")
+)
+
+// localJoinServer is the built-in join server. It uses the root keys in the
+// device storage. It doesn't have the key encryption keys so it can't be used
+// for applications with end-to-end encryption.
+type localJoinServer struct {
+	storage *storage.Storage
+}
+
+// NewLocalJoinServer creates the built-in join server. The AppKey and NwkKey
+// for the devices are read from the storage.
+func NewLocalJoinServer(store *storage.Storage) JoinServer {
+	return &localJoinServer{storage: store}
+}
+
+func (l *localJoinServer) Join(req JoinRequest) (JoinAnswer, error) {
+	payload := protocol.PHYPayload{}
+	if err := payload.UnmarshalBinary(req.PHYPayload); err != nil {
+		return JoinAnswer{}, ErrMalformedRequest
+	}
+	device, err := l.storage.Device.GetByEUI(req.DevEUI)
+	if err != nil || device.State != model.OverTheAirDevice || device.AppEUI != req.JoinEUI {
+		return JoinAnswer{}, ErrUnknownDevice
+	}
+	switch payload.MHDR.MType {
+	case protocol.JoinRequest:
+		return l.join(device, req, payload)
+	case protocol.RejoinRequest:
+		return l.rejoin(device, req, payload)
+	default:
+		return JoinAnswer{}, ErrMalformedRequest
+	}
+}
+
+// join handles JoinRequest messages. The message is signed with the NwkKey
+// for LoRaWAN 1.1 devices and the AppKey for LoRaWAN 1.0 devices. The
+// DevNonce can't be reused by the device [6.2.4].
+func (l *localJoinServer) join(device model.Device, req JoinRequest, payload protocol.PHYPayload) (JoinAnswer, error) {
+	joinRequest := payload.JoinRequestPayload
+	if joinRequest.DevEUI != device.DeviceEUI || joinRequest.AppEUI != device.AppEUI {
+		return JoinAnswer{}, ErrUnknownDevice
+	}
+	key := device.AppKey
+	if device.MACVersion == protocol.MACVersion11 {
+		key = device.NwkKey
+	}
+	mic, err := payload.CalculateJoinRequestMIC(key, req.PHYPayload[:len(req.PHYPayload)-4])
+	if err != nil {
+		return JoinAnswer{}, err
+	}
+	if mic != payload.MIC {
+		return JoinAnswer{}, ErrMICFailed
+	}
+	if device.HasDevNonce(joinRequest.DevNonce) {
+		return JoinAnswer{}, ErrFrameReplayed
+	}
+	if err := l.storage.Device.AddDevNonce(device, joinRequest.DevNonce); err != nil {
+		return JoinAnswer{}, err
+	}
+
+	answer, nonce, err := l.generateSessionKeys(&device, req.NetID, joinRequest.DevNonce)
+	if err != nil {
+		return answer, err
+	}
+	joinAccept := newJoinAcceptPayload(req, nonce)
+	if device.MACVersion != protocol.MACVersion11 {
+		answer.PHYPayload, err = joinAccept.EncodeJoinAccept(device.AppKey)
+		return answer, err
+	}
+	jsIntKey, err := protocol.JSIntKeyFromDevEUI(device.NwkKey, device.DeviceEUI)
+	if err != nil {
+		return answer, err
+	}
+	answer.PHYPayload, err = joinAccept.EncodeJoinAccept11(device.NwkKey, jsIntKey, device.AppEUI, joinRequest.DevNonce)
+	return answer, err
+}
+
+// rejoin handles Rejoin-requests. Type 1 requests are signed with the
+// JSIntKey. Type 0 and 2 requests are signed with the SNwkSIntKey and are
+// verified by the network server [6.2.4.4]. The JoinAccept message is
+// encrypted with the JSEncKey [6.2.3].
+func (l *localJoinServer) rejoin(device model.Device, req JoinRequest, payload protocol.PHYPayload) (JoinAnswer, error) {
+	rejoin := payload.RejoinRequestPayload
+	if device.MACVersion != protocol.MACVersion11 || rejoin.DevEUI != device.DeviceEUI {
+		return JoinAnswer{}, ErrUnknownDevice
+	}
+	jsIntKey, err := protocol.JSIntKeyFromDevEUI(device.NwkKey, device.DeviceEUI)
+	if err != nil {
+		return JoinAnswer{}, err
+	}
+	if rejoin.RejoinType == protocol.RejoinType1 {
+		if rejoin.JoinEUI != device.AppEUI {
+			return JoinAnswer{}, ErrUnknownDevice
+		}
+		mic, err := payload.CalculateRejoinRequestMIC(jsIntKey, req.PHYPayload[:len(req.PHYPayload)-4])
+		if err != nil {
+			return JoinAnswer{}, err
+		}
+		if mic != payload.MIC {
+			return JoinAnswer{}, ErrMICFailed
+		}
+	}
+
+	// The RJcount replaces the DevNonce when the keys are generated
+	answer, nonce, err := l.generateSessionKeys(&device, req.NetID, rejoin.KeyNonce())
+	if err != nil {
+		return answer, err
+	}
+	jsEncKey, err := protocol.JSEncKeyFromDevEUI(device.NwkKey, device.DeviceEUI)
+	if err != nil {
+		return answer, err
+	}
+	joinAccept := newJoinAcceptPayload(req, nonce)
+	answer.PHYPayload, err = joinAccept.EncodeRejoinAccept(jsEncKey, jsIntKey, rejoin.RejoinType, device.AppEUI, rejoin.RJCount)
+	return answer, err
+}
+
+// generateSessionKeys generates the session keys for the device and returns
+// the nonce to send in the JoinAccept message. LoRaWAN 1.0 devices get a
+// random AppNonce while LoRaWAN 1.1 devices get the next value of the
+// device's JoinNonce counter [6.2.3].
+func (l *localJoinServer) generateSessionKeys(device *model.Device, netID uint32, devNonce uint16) (JoinAnswer, [3]byte, error) {
+	ret := JoinAnswer{}
+	if device.MACVersion != protocol.MACVersion11 {
+		var joinAccept protocol.JoinAcceptPayload
+		appNonce, err := joinAccept.GenerateAppNonce()
+		if err != nil {
+			return ret, appNonce, err
+		}
+		if ret.NwkSKey, err = protocol.NwkSKeyFromNonces(device.AppKey, appNonce, netID, devNonce); err != nil {
+			return ret, appNonce, err
+		}
+		ret.AppSKey, err = protocol.AppSKeyFromNonces(device.AppKey, appNonce, netID, devNonce)
+		return ret, appNonce, err
+	}
+
+	device.JoinNonce = (device.JoinNonce + 1) & 0xFFFFFF
+	joinNonce := [3]byte{byte(device.JoinNonce), byte(device.JoinNonce >> 8), byte(device.JoinNonce >> 16)}
+	if err := l.storage.Device.Update(*device); err != nil {
+		return ret, joinNonce, err
+	}
+	joinEUI := device.AppEUI
+	var err error
+	if ret.NwkSKey, err = protocol.FNwkSIntKeyFromJoinNonce(device.NwkKey, joinNonce, joinEUI, devNonce); err != nil {
+		return ret, joinNonce, err
+	}
+	if ret.SNwkSIntKey, err = protocol.SNwkSIntKeyFromJoinNonce(device.NwkKey, joinNonce, joinEUI, devNonce); err != nil {
+		return ret, joinNonce, err
+	}
+	if ret.NwkSEncKey, err = protocol.NwkSEncKeyFromJoinNonce(device.NwkKey, joinNonce, joinEUI, devNonce); err != nil {
+		return ret, joinNonce, err
+	}
+	ret.AppSKey, err = protocol.AppSKeyFromJoinNonce(device.AppKey, joinNonce, joinEUI, devNonce)
+	return ret, joinNonce, err
+}
+
+// newJoinAcceptPayload creates the (unencrypted) JoinAccept message for the
+// request.
+func newJoinAcceptPayload(req JoinRequest, nonce [3]byte) protocol.PHYPayload {
+	ret := protocol.NewPHYPayload(protocol.JoinAccept)
+	ret.JoinAcceptPayload = protocol.JoinAcceptPayload{
+		AppNonce:   nonce,
+		NetID:      req.NetID,
+		DevAddr:    req.DevAddr,
+		DLSettings: req.DLSettings,
+		RxDelay:    req.RxDelay,
+		CFList:     req.CFList,
+	}
+	return ret
+}
@@ -0,0 +1,341 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// The JoinAccept must be sent within JOIN_ACCEPT_DELAY1 (5 seconds) of the
+// JoinRequest. Leave some time for the rest of the pipeline.
+const joinServerTimeout = 2 * time.Second
+
+// The JSON messages below are a subset of the messages in the LoRaWAN
+// Backend Interfaces specification. The session keys aren't wrapped so the
+// join server should use TLS.
+const (
+	backendProtocolVersion = "1.0"
+	backendJoinReq         = "JoinReq"
+	backendJoinAns         = "JoinAns"
+	backendSuccess         = "Success"
+	backendOther           = "Other"
+)
+
+// resultCodes maps the errors from the join server to the result codes in the
+// JoinAns message.
+var resultCodes = map[error]string{
+	ErrUnknownDevice:    "UnknownDevEUI",
+	ErrMICFailed:        "MICFailed",
+	ErrFrameReplayed:    "FrameReplayed",
+	ErrMalformedRequest: "MalformedRequest",
+}
+
+type backendHeader struct {
+	ProtocolVersion string
+	SenderID        string
+	ReceiverID      string
+	TransactionID   uint32
+	MessageType     string
+}
+
+type backendJoinRequest struct {
+	backendHeader
+	MACVersion string
+	PHYPayload string
+	DevEUI     string
+	DevAddr    string
+	DLSettings string
+	RxDelay    uint8
+	CFList     string `json:",omitempty"`
+}
+
+type backendKeyEnvelope struct {
+	KEKLabel string
+	AESKey   string
+}
+
+type backendResult struct {
+	ResultCode  string
+	Description string `json:",omitempty"`
+}
+
+type backendJoinAnswer struct {
+	backendHeader
+	Result      backendResult
+	PHYPayload  string              `json:",omitempty"`
+	NwkSKey     *backendKeyEnvelope `json:",omitempty"`
+	FNwkSIntKey *backendKeyEnvelope `json:",omitempty"`
+	SNwkSIntKey *backendKeyEnvelope `json:",omitempty"`
+	NwkSEncKey  *backendKeyEnvelope `json:",omitempty"`
+	AppSKey     *backendKeyEnvelope `json:",omitempty"`
+}
+
+func newKeyEnvelope(key protocol.AESKey) *backendKeyEnvelope {
+	return &backendKeyEnvelope{AESKey: key.String()}
+}
+
+// newWrappedKeyEnvelope creates a key envelope for a key wrapped with the KEK
+func newWrappedKeyEnvelope(kekLabel string, wrapped []byte) *backendKeyEnvelope {
+	return &backendKeyEnvelope{KEKLabel: kekLabel, AESKey: hex.EncodeToString(wrapped)}
+}
+
+func (k *backendKeyEnvelope) key() (protocol.AESKey, error) {
+	if k == nil {
+		return protocol.AESKey{}, errors.New("missing session key")
+	}
+	return protocol.AESKeyFromString(k.AESKey)
+}
+
+func euiToHex(eui protocol.EUI) string {
+	return hex.EncodeToString(eui.Octets[:])
+}
+
+func newBackendJoinRequest(req JoinRequest, transactionID uint32) (backendJoinRequest, error) {
+	dlSettings, err := req.DLSettings.MarshalBinary()
+	if err != nil {
+		return backendJoinRequest{}, err
+	}
+	ret := backendJoinRequest{
+		backendHeader: backendHeader{
+			ProtocolVersion: backendProtocolVersion,
+			SenderID:        fmt.Sprintf("%06x", req.NetID),
+			ReceiverID:      euiToHex(req.JoinEUI),
+			TransactionID:   transactionID,
+			MessageType:     backendJoinReq,
+		},
+		MACVersion: req.MACVersion.String(),
+		PHYPayload: hex.EncodeToString(req.PHYPayload),
+		DevEUI:     euiToHex(req.DevEUI),
+		DevAddr:    req.DevAddr.String(),
+		DLSettings: hex.EncodeToString(dlSettings),
+		RxDelay:    req.RxDelay,
+	}
+	if !req.CFList.Empty() {
+		cfList, err := req.CFList.MarshalBinary()
+		if err != nil {
+			return ret, err
+		}
+		ret.CFList = hex.EncodeToString(cfList)
+	}
+	return ret, nil
+}
+
+// toJoinRequest converts the JoinReq message into a JoinRequest
+func (b *backendJoinRequest) toJoinRequest() (JoinRequest, error) {
+	var ret JoinRequest
+	if b.MessageType != backendJoinReq {
+		return ret, fmt.Errorf("unexpected message type %s", b.MessageType)
+	}
+	netID, err := strconv.ParseUint(b.SenderID, 16, 24)
+	if err != nil {
+		return ret, err
+	}
+	ret.NetID = uint32(netID)
+	if ret.MACVersion, err = protocol.MACVersionFromString(b.MACVersion); err != nil {
+		return ret, err
+	}
+	if ret.PHYPayload, err = hex.DecodeString(b.PHYPayload); err != nil {
+		return ret, err
+	}
+	if ret.DevEUI, err = protocol.EUIFromString(b.DevEUI); err != nil {
+		return ret, err
+	}
+	if ret.JoinEUI, err = protocol.EUIFromString(b.ReceiverID); err != nil {
+		return ret, err
+	}
+	devAddr, err := strconv.ParseUint(b.DevAddr, 16, 32)
+	if err != nil {
+		return ret, err
+	}
+	ret.DevAddr = protocol.DevAddrFromUint32(uint32(devAddr))
+	dlSettings, err := hex.DecodeString(b.DLSettings)
+	if err != nil {
+		return ret, err
+	}
+	if err := ret.DLSettings.UnmarshalBinary(dlSettings); err != nil {
+		return ret, err
+	}
+	ret.RxDelay = b.RxDelay
+	if b.CFList != "" {
+		cfList, err := hex.DecodeString(b.CFList)
+		if err != nil {
+			return ret, err
+		}
+		if err := ret.CFList.UnmarshalBinary(cfList); err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// toJoinAnswer converts the JoinAns message into a JoinAnswer. The errors
+// returned by the join server are mapped back into the corresponding errors.
+func (b *backendJoinAnswer) toJoinAnswer(macVersion protocol.MACVersion) (JoinAnswer, error) {
+	var ret JoinAnswer
+	if b.MessageType != backendJoinAns {
+		return ret, fmt.Errorf("unexpected message type %s", b.MessageType)
+	}
+	if b.Result.ResultCode != backendSuccess {
+		for err, code := range resultCodes {
+			if code == b.Result.ResultCode {
+				return ret, err
+			}
+		}
+		return ret, fmt.Errorf("join server returned %s: %s", b.Result.ResultCode, b.Result.Description)
+	}
+	var err error
+	if ret.PHYPayload, err = hex.DecodeString(b.PHYPayload); err != nil {
+		return ret, err
+	}
+	// Keys with a KEK label are wrapped and passed on as is
+	if b.AppSKey != nil && b.AppSKey.KEKLabel != "" {
+		ret.KEKLabel = b.AppSKey.KEKLabel
+		if ret.WrappedAppSKey, err = hex.DecodeString(b.AppSKey.AESKey); err != nil {
+			return ret, err
+		}
+	} else if ret.AppSKey, err = b.AppSKey.key(); err != nil {
+		return ret, err
+	}
+	if macVersion != protocol.MACVersion11 {
+		ret.NwkSKey, err = b.NwkSKey.key()
+		return ret, err
+	}
+	if ret.NwkSKey, err = b.FNwkSIntKey.key(); err != nil {
+		return ret, err
+	}
+	if ret.SNwkSIntKey, err = b.SNwkSIntKey.key(); err != nil {
+		return ret, err
+	}
+	ret.NwkSEncKey, err = b.NwkSEncKey.key()
+	return ret, err
+}
+
+// httpJoinServer is a client for an external join server. The requests are
+// sent as JSON to the join server's URL.
+type httpJoinServer struct {
+	url           string
+	client        *http.Client
+	transactionID uint32
+}
+
+// NewHTTPJoinServer creates a client for the join server at the URL. The root
+// keys for the devices are kept by the join server.
+func NewHTTPJoinServer(url string) JoinServer {
+	return &httpJoinServer{
+		url:    url,
+		client: &http.Client{Timeout: joinServerTimeout},
+	}
+}
+
+func (h *httpJoinServer) Join(req JoinRequest) (JoinAnswer, error) {
+	joinReq, err := newBackendJoinRequest(req, atomic.AddUint32(&h.transactionID, 1))
+	if err != nil {
+		return JoinAnswer{}, err
+	}
+	buf, err := json.Marshal(&joinReq)
+	if err != nil {
+		return JoinAnswer{}, err
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return JoinAnswer{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return JoinAnswer{}, fmt.Errorf("join server returned status %d", resp.StatusCode)
+	}
+	joinAns := backendJoinAnswer{}
+	if err := json.NewDecoder(resp.Body).Decode(&joinAns); err != nil {
+		return JoinAnswer{}, err
+	}
+	if joinAns.TransactionID != joinReq.TransactionID {
+		return JoinAnswer{}, fmt.Errorf("expected transaction ID %d but got %d", joinReq.TransactionID, joinAns.TransactionID)
+	}
+	return joinAns.toJoinAnswer(req.MACVersion)
+}
+
+// NewJoinServerHandler returns a HTTP handler that serves the requests from
+// the HTTP join server client with the join server. This can be used to run
+// a stand-alone join server.
+func NewJoinServerHandler(joinServer JoinServer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+			return
+		}
+		joinReq := backendJoinRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&joinReq); err != nil {
+			http.Error(w, "Can't grok JSON", http.StatusBadRequest)
+			return
+		}
+		joinAns := backendJoinAnswer{
+			backendHeader: backendHeader{
+				ProtocolVersion: backendProtocolVersion,
+				SenderID:        joinReq.ReceiverID,
+				ReceiverID:      joinReq.SenderID,
+				TransactionID:   joinReq.TransactionID,
+				MessageType:     backendJoinAns,
+			},
+			Result: backendResult{ResultCode: backendSuccess},
+		}
+		req, err := joinReq.toJoinRequest()
+		if err != nil {
+			err = ErrMalformedRequest
+		}
+		var answer JoinAnswer
+		if err == nil {
+			answer, err = joinServer.Join(req)
+		}
+		if err != nil {
+			code, ok := resultCodes[err]
+			if !ok {
+				logging.Warning("Unable to process JoinReq for device %s: %v", joinReq.DevEUI, err)
+				code = backendOther
+			}
+			joinAns.Result = backendResult{ResultCode: code, Description: err.Error()}
+		}
+		if err == nil {
+			joinAns.PHYPayload = hex.EncodeToString(answer.PHYPayload)
+			joinAns.AppSKey = newKeyEnvelope(answer.AppSKey)
+			if answer.Wrapped() {
+				joinAns.AppSKey = newWrappedKeyEnvelope(answer.KEKLabel, answer.WrappedAppSKey)
+			}
+			if req.MACVersion != protocol.MACVersion11 {
+				joinAns.NwkSKey = newKeyEnvelope(answer.NwkSKey)
+			} else {
+				joinAns.FNwkSIntKey = newKeyEnvelope(answer.NwkSKey)
+				joinAns.SNwkSIntKey = newKeyEnvelope(answer.SNwkSIntKey)
+				joinAns.NwkSEncKey = newKeyEnvelope(answer.NwkSEncKey)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(&joinAns); err != nil {
+			logging.Warning("Unable to marshal JoinAns into JSON: %v", err)
+		}
+	})
+}
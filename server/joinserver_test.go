@@ -0,0 +1,242 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/congress/storage/memstore"
+)
+
+var testCFList = protocol.CFList{Frequencies: [protocol.CFListChannels]uint32{8671000, 8673000, 8675000, 8677000, 8679000}}
+
+func newTestJoinRequest(t *testing.T, device model.Device, devNonce uint16, key protocol.AESKey) JoinRequest {
+	payload := protocol.NewPHYPayload(protocol.JoinRequest)
+	payload.JoinRequestPayload = protocol.JoinRequestPayload{
+		DevEUI:   device.DeviceEUI,
+		AppEUI:   device.AppEUI,
+		DevNonce: devNonce,
+	}
+	buf, err := payload.EncodeJoinRequest(key)
+	if err != nil {
+		t.Fatal("Unable to encode JoinRequest: ", err)
+	}
+	return JoinRequest{
+		NetID:      0x13,
+		MACVersion: device.MACVersion,
+		PHYPayload: buf,
+		DevEUI:     device.DeviceEUI,
+		JoinEUI:    device.AppEUI,
+		DevAddr:    device.DevAddr,
+		DLSettings: protocol.DLSettings{RX1DRoffset: 1, RX2DataRate: 2, OptNeg: device.MACVersion == protocol.MACVersion11},
+		RxDelay:    3,
+		CFList:     testCFList,
+	}
+}
+
+// testJoinServer runs the same set of tests on the join server. The devices
+// are stored in the storage used by the built-in join server.
+func testJoinServer(t *testing.T, joinServer JoinServer, store *storage.Storage) {
+	app := model.NewApplication()
+	app.AppEUI = protocol.EUIFromUint64(2)
+	store.Application.Put(app, model.SystemUserID)
+
+	device10 := model.NewDevice()
+	device10.DeviceEUI = protocol.EUIFromUint64(1)
+	device10.AppEUI = protocol.EUIFromUint64(2)
+	device10.DevAddr = protocol.DevAddr{NwkID: 1, NwkAddr: 2}
+	device10.State = model.OverTheAirDevice
+	device10.AppKey = protocol.AESKey{Key: [16]byte{1}}
+	store.Device.Put(device10, device10.AppEUI)
+
+	device11 := device10
+	device11.DeviceEUI = protocol.EUIFromUint64(3)
+	device11.MACVersion = protocol.MACVersion11
+	device11.AppKey = protocol.AESKey{Key: [16]byte{3}}
+	device11.NwkKey = protocol.AESKey{Key: [16]byte{4}}
+	store.Device.Put(device11, device11.AppEUI)
+
+	// LoRaWAN 1.0 devices use the AppKey
+	req := newTestJoinRequest(t, device10, 1, device10.AppKey)
+	answer, err := joinServer.Join(req)
+	if err != nil {
+		t.Fatal("Got error joining LoRaWAN 1.0 device: ", err)
+	}
+	joinAccept := protocol.NewPHYPayload(protocol.JoinAccept)
+	if err := joinAccept.DecodeJoinAccept(device10.AppKey, answer.PHYPayload); err != nil {
+		t.Fatal("Unable to decode JoinAccept: ", err)
+	}
+	ja := joinAccept.JoinAcceptPayload
+	if ja.NetID != req.NetID || ja.DevAddr != req.DevAddr || ja.DLSettings != req.DLSettings || ja.RxDelay != req.RxDelay || ja.CFList != req.CFList {
+		t.Fatalf("Unexpected JoinAccept: %+v", ja)
+	}
+	nwkSKey, _ := protocol.NwkSKeyFromNonces(device10.AppKey, ja.AppNonce, req.NetID, 1)
+	appSKey, _ := protocol.AppSKeyFromNonces(device10.AppKey, ja.AppNonce, req.NetID, 1)
+	if answer.NwkSKey != nwkSKey || answer.AppSKey != appSKey {
+		t.Fatalf("Unexpected session keys: %+v", answer)
+	}
+
+	// DevNonces can't be reused, the MIC must be valid and the device must
+	// exist.
+	if _, err := joinServer.Join(req); err != ErrFrameReplayed {
+		t.Fatal("Expected ErrFrameReplayed for used DevNonce but got ", err)
+	}
+	if _, err := joinServer.Join(newTestJoinRequest(t, device10, 2, device11.AppKey)); err != ErrMICFailed {
+		t.Fatal("Expected ErrMICFailed for invalid MIC but got ", err)
+	}
+	req.DevEUI = protocol.EUIFromUint64(99)
+	if _, err := joinServer.Join(req); err != ErrUnknownDevice {
+		t.Fatal("Expected ErrUnknownDevice for unknown device but got ", err)
+	}
+	req.DevEUI = device10.DeviceEUI
+	req.PHYPayload = req.PHYPayload[:10]
+	if _, err := joinServer.Join(req); err != ErrMalformedRequest {
+		t.Fatal("Expected ErrMalformedRequest for truncated payload but got ", err)
+	}
+
+	// LoRaWAN 1.1 devices use the NwkKey and the JoinNonce is incremented
+	answer, err = joinServer.Join(newTestJoinRequest(t, device11, 1, device11.NwkKey))
+	if err != nil {
+		t.Fatal("Got error joining LoRaWAN 1.1 device: ", err)
+	}
+	jsIntKey, _ := protocol.JSIntKeyFromDevEUI(device11.NwkKey, device11.DeviceEUI)
+	if err := joinAccept.DecodeJoinAccept11(device11.NwkKey, jsIntKey, device11.AppEUI, 1, answer.PHYPayload); err != nil {
+		t.Fatal("Unable to decode JoinAccept: ", err)
+	}
+	joinNonce := [3]byte{1, 0, 0}
+	if joinAccept.JoinAcceptPayload.AppNonce != joinNonce {
+		t.Fatalf("Unexpected JoinNonce in JoinAccept: %v", joinAccept.JoinAcceptPayload.AppNonce)
+	}
+	fNwkSIntKey, _ := protocol.FNwkSIntKeyFromJoinNonce(device11.NwkKey, joinNonce, device11.AppEUI, 1)
+	sNwkSIntKey, _ := protocol.SNwkSIntKeyFromJoinNonce(device11.NwkKey, joinNonce, device11.AppEUI, 1)
+	nwkSEncKey, _ := protocol.NwkSEncKeyFromJoinNonce(device11.NwkKey, joinNonce, device11.AppEUI, 1)
+	appSKey, _ = protocol.AppSKeyFromJoinNonce(device11.AppKey, joinNonce, device11.AppEUI, 1)
+	if answer.NwkSKey != fNwkSIntKey || answer.SNwkSIntKey != sNwkSIntKey || answer.NwkSEncKey != nwkSEncKey || answer.AppSKey != appSKey {
+		t.Fatalf("Unexpected session keys: %+v", answer)
+	}
+
+	// Type 1 Rejoin-requests are signed with the JSIntKey
+	newRejoin := func(key protocol.AESKey) JoinRequest {
+		payload := protocol.NewPHYPayload(protocol.RejoinRequest)
+		payload.RejoinRequestPayload = protocol.RejoinRequestPayload{
+			RejoinType: protocol.RejoinType1,
+			JoinEUI:    device11.AppEUI,
+			DevEUI:     device11.DeviceEUI,
+			RJCount:    5,
+		}
+		ret := newTestJoinRequest(t, device11, 0, device11.NwkKey)
+		ret.PHYPayload, _ = payload.EncodeRejoinRequest(key)
+		return ret
+	}
+	if _, err := joinServer.Join(newRejoin(device11.NwkKey)); err != ErrMICFailed {
+		t.Fatal("Expected ErrMICFailed for invalid MIC but got ", err)
+	}
+	answer, err = joinServer.Join(newRejoin(jsIntKey))
+	if err != nil {
+		t.Fatal("Got error for Rejoin-request: ", err)
+	}
+	jsEncKey, _ := protocol.JSEncKeyFromDevEUI(device11.NwkKey, device11.DeviceEUI)
+	if err := joinAccept.DecodeRejoinAccept(jsEncKey, jsIntKey, protocol.RejoinType1, device11.AppEUI, 5, answer.PHYPayload); err != nil {
+		t.Fatal("Unable to decode JoinAccept for Rejoin-request: ", err)
+	}
+	if joinAccept.JoinAcceptPayload.AppNonce != [3]byte{2, 0, 0} {
+		t.Fatalf("Unexpected JoinNonce in JoinAccept: %v", joinAccept.JoinAcceptPayload.AppNonce)
+	}
+	if stored, _ := store.Device.GetByEUI(device11.DeviceEUI); stored.JoinNonce != 2 {
+		t.Fatalf("Expected JoinNonce to be stored but it is %d", stored.JoinNonce)
+	}
+
+}
+
+// kekJoinServer wraps the AppSKey with a KEK like an external join server
+// does for applications with end-to-end encryption.
+type kekJoinServer struct {
+	JoinServer
+	label string
+	kek   protocol.AESKey
+}
+
+func (k *kekJoinServer) Join(req JoinRequest) (JoinAnswer, error) {
+	answer, err := k.JoinServer.Join(req)
+	if err != nil {
+		return answer, err
+	}
+	return answer, answer.WrapAppSKey(k.label, k.kek)
+}
+
+func TestLocalJoinServer(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	testJoinServer(t, NewLocalJoinServer(&store), &store)
+}
+
+// The HTTP client is tested against a stand-in join server that uses the
+// built-in join server.
+func TestHTTPJoinServer(t *testing.T) {
+	store := memstore.CreateMemoryStorage(0, 0)
+	js := httptest.NewServer(NewJoinServerHandler(NewLocalJoinServer(&store)))
+	defer js.Close()
+
+	testJoinServer(t, NewHTTPJoinServer(js.URL), &store)
+
+	// Wrapped AppSKeys are passed on with the KEK label
+	kek := protocol.AESKey{Key: [16]byte{5}}
+	kekServer := httptest.NewServer(NewJoinServerHandler(&kekJoinServer{NewLocalJoinServer(&store), "kek", kek}))
+	defer kekServer.Close()
+	device, _ := store.Device.GetByEUI(protocol.EUIFromUint64(1))
+	answer, err := NewHTTPJoinServer(kekServer.URL).Join(newTestJoinRequest(t, device, 10, device.AppKey))
+	if err != nil {
+		t.Fatal("Got error joining device with end-to-end encryption: ", err)
+	}
+	if !answer.Wrapped() || answer.KEKLabel != "kek" || answer.AppSKey != (protocol.AESKey{}) {
+		t.Fatalf("Expected AppSKey to be wrapped: %+v", answer)
+	}
+	joinAccept := protocol.NewPHYPayload(protocol.JoinAccept)
+	if err := joinAccept.DecodeJoinAccept(device.AppKey, answer.PHYPayload); err != nil {
+		t.Fatal("Unable to decode JoinAccept: ", err)
+	}
+	appSKey, _ := protocol.AppSKeyFromNonces(device.AppKey, joinAccept.JoinAcceptPayload.AppNonce, 0x13, 10)
+	if unwrapped, err := protocol.UnwrapKey(kek, answer.WrappedAppSKey); err != nil || unwrapped != appSKey {
+		t.Fatalf("Wrapped AppSKey doesn't match (err=%v)", err)
+	}
+
+	resp, err := http.Get(js.URL)
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405 METHOD NOT ALLOWED but got %v (err=%v)", resp, err)
+	}
+	resp, err = http.Post(js.URL, "application/json", strings.NewReader(`x`))
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 BAD REQUEST but got %v (err=%v)", resp, err)
+	}
+	resp, err = http.Post(js.URL, "application/json", strings.NewReader(`{"MessageType": "JoinReq", "SenderID": "x"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 OK but got %v (err=%v)", resp, err)
+	}
+
+	// Join servers that return errors are errors
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Oops", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if _, err := NewHTTPJoinServer(failing.URL).Join(newTestJoinRequest(t, model.NewDevice(), 1, protocol.AESKey{})); err == nil {
+		t.Fatal("Expected error from failing join server")
+	}
+}
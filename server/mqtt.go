@@ -162,15 +162,14 @@ func (m *mqttTransport) send(msg interface{}, logger *MemoryLogger) bool {
 
 	// Payload is an data structure. Convert into same format as the websocket
 	// output (apiDeviceData) and pass on.
-	dataMsg, ok := msg.(*PayloadMessage)
+	dataOutput, ok := newTransportMessage(msg)
 	if !ok {
-		logging.Warning("Didn't receive a PayloadMessage type on channel but got %T. Silently dropping it.", msg)
+		logging.Warning("Didn't receive a PayloadMessage, DownstreamStatus or JoinMessage type on channel but got %T. Silently dropping it.", msg)
 		return true
 	}
-	dataOutput := newDeviceDataFromPayloadMessage(dataMsg)
 	bytes, err := json.Marshal(dataOutput)
 	if err != nil {
-		logging.Warning("Unable to marshal %T into JSON: %v. Silently dropping it.", dataMsg, err)
+		logging.Warning("Unable to marshal %T into JSON: %v. Silently dropping it.", msg, err)
 		return true
 	}
 	token := m.client.Publish(m.topicName, qos, retained, bytes)
@@ -38,6 +38,14 @@ type Context struct {
 	GwEventRouter *pubsub.EventRouter // Router for GW events
 	AppRouter     *pubsub.EventRouter // Router for app data
 	AppOutput     *AppOutputManager
+	UplinkHistory *UplinkHistory    // Radio metrics for uplinks. Common instance for processors.
+	Downlinks     *DownlinkNotifier // Notifications for new downlink messages
+	GPSGateways   *GPSGateways      // Gateways with a GPS synchronized clock
+	Gateways      *ActiveGateways   // Gateways that have forwarded uplinks
+	GatewayBands  *GatewayBands     // Cached gateways and bands for the forwarders
+	FUOTA         *FUOTAManager     // Firmware update campaigns
+	TXAcks        *TXAckNotifier    // Downlinks rejected by the gateways
+	JoinServer    JoinServer        // Join server for OTAA devices
 }
 
 // RadioContext - metadata for radio stats and settings
@@ -51,8 +59,24 @@ type RadioContext struct {
 	RX2Delay  uint8              // RX2Delay - set during decoding
 	RSSI      int32              // RSSI for device - set by GW IF
 	SNR       float32            // SNR for device - set by GW IF
+	TXPower   uint8              // Transmit power (in dBm) for downlinks. 0 is the band's default power
+	RXWindow  band.RXWindowType  // Receive window for class A downlinks - set by scheduler
 }
 
+// RXDelay returns the delay from the end of the uplink until the receive
+// window for the downlink opens.
+func (r RadioContext) RXDelay() time.Duration {
+	if r.RXWindow == band.RX2 {
+		return time.Duration(r.RX2Delay) * time.Second
+	}
+	return time.Duration(r.RX1Delay) * time.Second
+}
+
+// AssumedGatewayLatency is the assumed network latency between the server and
+// the gateways. This is roughly what we can expect in Europe. Norway -> Ireland
+// is about 50 ms; further south it is easily 100ms (or more).
+const AssumedGatewayLatency = 200 * time.Millisecond
+
 // GatewayContext - metadata for gateway; used when responding
 type GatewayContext struct {
 	GatewayEUI      protocol.EUI // The reported EUI
@@ -60,13 +84,26 @@ type GatewayContext struct {
 	GatewayPort     int          // The originating port
 	GatewayClock    uint32       // Clock ticks reported by gateway
 	ProtocolVersion uint8        // Protocol version (wrt packet forwarder)
+
+	// Basics Station gateways identify the uplink with these. They are
+	// echoed back in the downlinks.
+	XTime int64 // The station's internal time (xtime) for the uplink
+	RCtx  int64 // The station's radio context (rctx) for the uplink
+
+	// SubBand is the sub-band the gateway listens to in bands with sub-bands.
+	// 0 means all channels.
+	SubBand uint8
 }
 
 // FrameContext is the context for each frame received (frequency, encoding, data rate rx1 offset and so on)
 type FrameContext struct {
-	Device         model.Device      // The decoded Device. Nil if it haven't been decoded yet.
-	Application    model.Application // The decoded application. Nil if it haven't been resolved yet.
-	GatewayContext GatewayPacket     // Context for gateway'
+	Device         model.Device                   // The decoded Device. Nil if it haven't been decoded yet.
+	Application    model.Application              // The decoded application. Nil if it haven't been resolved yet.
+	GatewayContext GatewayPacket                  // Context for gateway'
+	Receptions     []GatewayPacket                // All of the gateways that received the frame, best link quality first
+	Rejoin         *protocol.RejoinRequestPayload // The Rejoin-request the JoinAccept is a response to. Nil for JoinRequests
+	Downstream     *model.DownstreamMessage       // The downstream message sent in the frame. Nil if there's none
+	JoinAccept     []byte                         // The encrypted JoinAccept message from the join server
 }
 
 // GatewayPacket contains a byte buffer plus radio statistics.
@@ -75,10 +112,25 @@ type GatewayPacket struct {
 	Radio        RadioContext
 	Gateway      GatewayContext
 	ReceivedAt   time.Time
+	GatewayTime  time.Time // Receive time from the gateway's GPS synchronized clock. Zero if the gateway isn't synchronized
 	SectionTimer monitoring.Timer
 	InTimer      monitoring.Timer // processing from gw -> scheduler, waiting for send
 	OutTimer     monitoring.Timer // processing from scheduler -> gw, sending
 	Deadline     float64          // Send deadline for packet (in seconds)
+	Immediate    bool             // Send the packet immediately. Used for class C downlinks
+	TXTime       time.Time        // Send the packet at this (GPS synchronized) time. Used for beacons and class B downlinks
+	Beacon       bool             // The packet is a class B beacon
+	Fallback     *TXFallback      // Alternatives if the gateway rejects the downlink. Nil if there are none
+}
+
+// UplinkTime returns the time the packet was received. The gateway's GPS
+// synchronized time is used if it is available, otherwise the time the packet
+// was received by the server.
+func (g GatewayPacket) UplinkTime() time.Time {
+	if !g.GatewayTime.IsZero() {
+		return g.GatewayTime
+	}
+	return g.ReceivedAt
 }
 
 // LoRaMessage contains the decoded LoRa message
@@ -87,11 +139,35 @@ type LoRaMessage struct {
 	FrameContext FrameContext        // Frame context; set for each frame that arrives
 }
 
-// PayloadMessage contains the decrypted and verified payload
+// PayloadMessage contains the decrypted and verified payload. The payload is
+// encrypted with the AppSKey if the application's payloads are encrypted
+// end-to-end. The application uses the frame counter to decrypt it.
 type PayloadMessage struct {
 	Payload      []byte                // Unencrypted from the PHYPayload struct
+	Encrypted    bool                  // Payload is encrypted with the AppSKey
+	Port         uint8                 // The port the payload was sent on
+	FCnt         uint32                // The (full) frame counter for the message
 	Device       model.Device          // The device that the payload was received from (or will be sent to)
 	Application  model.Application     // The device's application.
 	MACCommands  []protocol.MACCommand // MAC Commands received from/sent to the device
 	FrameContext FrameContext          // The context the packet is received in
 }
+
+// JoinMessage is published to the application outputs when a device in an
+// application with end-to-end encryption joins the network. The AppSKey for
+// the device is wrapped with the application's KEK.
+type JoinMessage struct {
+	Device         model.Device      // The device that joined
+	Application    model.Application // The device's application
+	KEKLabel       string            // Label for the KEK
+	WrappedAppSKey []byte            // The AppSKey wrapped with the KEK
+}
+
+// DownstreamStatus is published to the application outputs when the server
+// gives up sending a downstream message to a device, ie when the message
+// expires or the device doesn't acknowledge it.
+type DownstreamStatus struct {
+	Device      model.Device            // The device the message was sent to
+	Application model.Application       // The device's application
+	Message     model.DownstreamMessage // The downstream message
+}
@@ -69,6 +69,86 @@ type deviceData struct {
 	Frequency  float32 `json:"frequency"`
 	GatewayEUI string  `json:"gatewayEUI"`
 	DataRate   string  `json:"dataRate"`
+	Port       uint8   `json:"port"`
+	FCnt       uint32  `json:"fcnt"`
+	Encrypted  bool    `json:"encrypted,omitempty"` // Data is encrypted with the AppSKey
+
+	// Gateways lists every gateway that received the frame
+	Gateways []gatewayData `json:"gateways,omitempty"`
+}
+
+// gatewayData is a single gateway's reception of a frame
+type gatewayData struct {
+	GatewayEUI string  `json:"gatewayEUI"`
+	RSSI       int32   `json:"rssi"`
+	SNR        float32 `json:"snr"`
+	Timestamp  int64   `json:"timestamp"`
+}
+
+// downstreamStatus is the status for a downstream message that the server has
+// given up sending.
+type downstreamStatus struct {
+	AppEUI      string `json:"appEUI"`
+	DeviceEUI   string `json:"deviceEUI"`
+	Data        string `json:"data"`
+	Port        uint8  `json:"port"`
+	Ack         bool   `json:"ack"`
+	State       string `json:"state"`
+	Attempts    uint8  `json:"attempts"`
+	CreatedTime int64  `json:"createdTime"`
+	FailedTime  int64  `json:"failedTime"`
+}
+
+// joinEvent is sent when a device in an application with end-to-end
+// encryption joins. The application unwraps the AppSKey with its KEK.
+type joinEvent struct {
+	AppEUI    string `json:"appEUI"`
+	DeviceEUI string `json:"deviceEUI"`
+	DevAddr   string `json:"devAddr"`
+	KEKLabel  string `json:"kekLabel"`
+	AppSKey   string `json:"appSKey"` // Wrapped AppSKey
+}
+
+// newJoinEvent converts a JoinMessage into a joinEvent struct
+func newJoinEvent(message *JoinMessage) *joinEvent {
+	return &joinEvent{
+		AppEUI:    message.Application.AppEUI.String(),
+		DeviceEUI: message.Device.DeviceEUI.String(),
+		DevAddr:   message.Device.DevAddr.String(),
+		KEKLabel:  message.KEKLabel,
+		AppSKey:   hex.EncodeToString(message.WrappedAppSKey),
+	}
+}
+
+// newDownstreamStatus converts a DownstreamStatus message into a
+// downstreamStatus struct
+func newDownstreamStatus(message *DownstreamStatus) *downstreamStatus {
+	return &downstreamStatus{
+		AppEUI:      message.Application.AppEUI.String(),
+		DeviceEUI:   message.Device.DeviceEUI.String(),
+		Data:        message.Message.Data,
+		Port:        message.Message.Port,
+		Ack:         message.Message.Ack,
+		State:       message.Message.State().String(),
+		Attempts:    message.Message.Attempts,
+		CreatedTime: message.Message.CreatedTime,
+		FailedTime:  message.Message.FailedTime,
+	}
+}
+
+// newTransportMessage converts a message from the application router into
+// the data structure the transports send. Unknown message types return false.
+func newTransportMessage(msg interface{}) (interface{}, bool) {
+	switch m := msg.(type) {
+	case *PayloadMessage:
+		return newDeviceDataFromPayloadMessage(m), true
+	case *DownstreamStatus:
+		return newDownstreamStatus(m), true
+	case *JoinMessage:
+		return newJoinEvent(m), true
+	default:
+		return nil, false
+	}
 }
 
 // NewDeviceDataFromPayloadMessage converts a payload message into a DeviceData
@@ -77,6 +157,15 @@ type deviceData struct {
 // TODO: Merge with code in websocket handler. Requires PayloadMessage to be
 // moved into its own package.
 func newDeviceDataFromPayloadMessage(message *PayloadMessage) *deviceData {
+	var gateways []gatewayData
+	for _, v := range message.FrameContext.Receptions {
+		gateways = append(gateways, gatewayData{
+			GatewayEUI: v.Gateway.GatewayEUI.String(),
+			RSSI:       v.Radio.RSSI,
+			SNR:        v.Radio.SNR,
+			Timestamp:  v.UplinkTime().Unix(),
+		})
+	}
 	return &deviceData{
 		DevAddr:    message.Device.DevAddr.String(),
 		Timestamp:  message.FrameContext.GatewayContext.ReceivedAt.Unix(),
@@ -88,5 +177,9 @@ func newDeviceDataFromPayloadMessage(message *PayloadMessage) *deviceData {
 		Frequency:  message.FrameContext.GatewayContext.Radio.Frequency,
 		DataRate:   message.FrameContext.GatewayContext.Radio.DataRate,
 		GatewayEUI: message.FrameContext.GatewayContext.Gateway.GatewayEUI.String(),
+		Port:       message.Port,
+		FCnt:       message.FCnt,
+		Encrypted:  message.Encrypted,
+		Gateways:   gateways,
 	}
 }
@@ -0,0 +1,75 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+// txAckQueueSize is the number of failed downlinks that can be waiting for
+// the processor.
+const txAckQueueSize = 100
+
+// TXFallback holds the alternatives for a class A downlink if the gateway
+// can't send it. Each retry uses one of the alternatives.
+type TXFallback struct {
+	DeviceEUI  protocol.EUI             // The device the downlink is sent to
+	RX2        *RadioContext            // Radio settings for the second receive window. Nil if the downlink is in RX2 or RX2 can't be used
+	Receptions []GatewayPacket          // The other gateways that received the uplink, best link quality first
+	Downstream *model.DownstreamMessage // The downstream message in the downlink. Nil if there's none
+}
+
+// TXAck is a downlink the gateway has rejected. The error is the error code
+// reported by the gateway, f.e. TOO_LATE or COLLISION_PACKET.
+type TXAck struct {
+	Packet GatewayPacket
+	Error  string
+}
+
+// TXAckNotifier forwards downlinks rejected by the gateways to the
+// processor.
+type TXAckNotifier struct {
+	acks chan TXAck
+}
+
+// NewTXAckNotifier creates a new TXAckNotifier instance
+func NewTXAckNotifier() TXAckNotifier {
+	return TXAckNotifier{acks: make(chan TXAck, txAckQueueSize)}
+}
+
+// Notify sends a notification for the rejected downlink. The notification is
+// dropped if the queue is full.
+func (t *TXAckNotifier) Notify(ack TXAck) {
+	if t == nil {
+		return
+	}
+	select {
+	case t.acks <- ack:
+	default:
+		logging.Warning("TX ack queue is full. Dropping %s error from gateway %s", ack.Error, ack.Packet.Gateway.GatewayEUI)
+	}
+}
+
+// Acks returns the channel with the rejected downlinks. The channel is nil if
+// the notifier is nil.
+func (t *TXAckNotifier) Acks() <-chan TXAck {
+	if t == nil {
+		return nil
+	}
+	return t.acks
+}
@@ -0,0 +1,125 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"sync"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/band"
+	"github.com/ExploratoryEngineering/congress/protocol"
+)
+
+// UplinkSample holds the radio metrics for a single uplink frame. If the
+// frame is received by more than one gateway the best SNR and RSSI is kept.
+type UplinkSample struct {
+	FCnt     uint32             // Frame counter for the uplink
+	DataRate string             // Data rate (as reported by the gateway)
+	SNR      float32            // Best SNR for the frame
+	RSSI     int32              // Best RSSI for the frame
+	Gateways int                // Number of gateways that received the frame
+	Received time.Time          // Time of first reception
+	Gateway  GatewayContext     // The gateway with the best SNR for the frame
+	Band     band.FrequencyPlan // The band used by the gateway
+}
+
+// UplinkHistory keeps a rolling window of uplink samples for each device. The
+// samples are kept in memory only.
+type UplinkHistory struct {
+	size    int
+	samples map[protocol.EUI][]UplinkSample
+	mutex   *sync.Mutex
+}
+
+// NewUplinkHistory creates a new UplinkHistory instance that keeps (at most)
+// size samples per device.
+func NewUplinkHistory(size int) UplinkHistory {
+	return UplinkHistory{
+		size:    size,
+		samples: make(map[protocol.EUI][]UplinkSample),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// Add adds a new sample for the device. Samples with the same frame counter as
+// the last sample are merged into the last sample.
+func (h *UplinkHistory) Add(deviceEUI protocol.EUI, fcnt uint32, packet GatewayPacket) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	radio := packet.Radio
+	list := h.samples[deviceEUI]
+	if len(list) > 0 && list[len(list)-1].FCnt == fcnt {
+		last := &list[len(list)-1]
+		last.Gateways++
+		if radio.SNR > last.SNR {
+			last.SNR = radio.SNR
+			last.Gateway = packet.Gateway
+			last.Band = radio.Band
+		}
+		if radio.RSSI > last.RSSI {
+			last.RSSI = radio.RSSI
+		}
+		return
+	}
+
+	list = append(list, UplinkSample{
+		FCnt:     fcnt,
+		DataRate: radio.DataRate,
+		SNR:      radio.SNR,
+		RSSI:     radio.RSSI,
+		Gateways: 1,
+		Received: packet.ReceivedAt,
+		Gateway:  packet.Gateway,
+		Band:     radio.Band,
+	})
+	if len(list) > h.size {
+		list = list[len(list)-h.size:]
+	}
+	h.samples[deviceEUI] = list
+}
+
+// Samples returns a copy of the samples for the device, oldest sample first.
+func (h *UplinkHistory) Samples(deviceEUI protocol.EUI) []UplinkSample {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ret := make([]UplinkSample, len(h.samples[deviceEUI]))
+	copy(ret, h.samples[deviceEUI])
+	return ret
+}
+
+// Last returns the last sample for the device. The boolean flag is set to
+// false if there are no samples for the device.
+func (h *UplinkHistory) Last(deviceEUI protocol.EUI) (UplinkSample, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	list := h.samples[deviceEUI]
+	if len(list) == 0 {
+		return UplinkSample{}, false
+	}
+	return list[len(list)-1], true
+}
+
+// Clear removes all of the samples for the device.
+func (h *UplinkHistory) Clear(deviceEUI protocol.EUI) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.samples, deviceEUI)
+}
@@ -0,0 +1,62 @@
+package server
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+//
+import (
+	"testing"
+	"time"
+)
+
+func TestUplinkHistory(t *testing.T) {
+	h := NewUplinkHistory(4)
+	eui := makeRandomEUI()
+
+	if _, ok := h.Last(eui); ok {
+		t.Fatal("Should not have any samples for new device")
+	}
+
+	for i := 0; i < 6; i++ {
+		h.Add(eui, uint32(i), GatewayPacket{Radio: RadioContext{DataRate: "SF7BW125", SNR: float32(i), RSSI: int32(-100 + i)}, ReceivedAt: time.Now()})
+	}
+	samples := h.Samples(eui)
+	if len(samples) != 4 {
+		t.Fatalf("Expected 4 samples but got %d", len(samples))
+	}
+	if samples[0].FCnt != 2 || samples[3].FCnt != 5 {
+		t.Fatalf("Expected the oldest samples to be dropped but got %v", samples)
+	}
+
+	// Duplicates from other gateways should be merged into the last sample
+	best := GatewayContext{GatewayEUI: makeRandomEUI()}
+	h.Add(eui, 5, GatewayPacket{Radio: RadioContext{DataRate: "SF7BW125", SNR: 10, RSSI: -50}, Gateway: best, ReceivedAt: time.Now()})
+	h.Add(eui, 5, GatewayPacket{Radio: RadioContext{DataRate: "SF7BW125", SNR: -10, RSSI: -120}, ReceivedAt: time.Now()})
+	last, ok := h.Last(eui)
+	if !ok {
+		t.Fatal("Expected last sample")
+	}
+	if last.Gateways != 3 || last.SNR != 10 || last.RSSI != -50 || last.Gateway != best {
+		t.Fatalf("Duplicate was not merged properly: %+v", last)
+	}
+	if len(h.Samples(eui)) != 4 {
+		t.Fatal("Duplicate should not add a new sample")
+	}
+
+	h.Clear(eui)
+	if len(h.Samples(eui)) != 0 {
+		t.Fatal("Samples should be removed")
+	}
+}
@@ -41,6 +41,19 @@ func CreateSchema(db *sql.DB) {
 	logging.Info("PostgreSQL schema created")
 }
 
+// MigrateSchema upgrades an existing schema to the current version. The
+// migration is applied by CreateStorage on startup.
+func MigrateSchema(db *sql.DB) error {
+	commands := MigrationCommandList()
+	for _, v := range commands {
+		if _, err := db.Exec(v); err != nil {
+			return fmt.Errorf("unable to migrate PostgreSQL schema: %v (while running %s)", err, v)
+		}
+	}
+	logging.Info("PostgreSQL schema migrated")
+	return nil
+}
+
 // dbStore is the base type for all the backend storage implementations
 type dbStore struct {
 	db             *sql.DB
@@ -101,6 +114,10 @@ func CreateStorage(connectionString string, maxConn, idleConn int, maxConnLifeti
 	db.SetMaxOpenConns(maxConn)
 	db.SetConnMaxLifetime(maxConnLifetime)
 
+	if err := MigrateSchema(db); err != nil {
+		return storage.Storage{}, err
+	}
+
 	userManagement, err := NewDBUserManagement(db)
 
 	var appStorage storage.ApplicationStorage
@@ -137,6 +154,16 @@ func CreateStorage(connectionString string, maxConn, idleConn int, maxConnLifeti
 	if outputStorage, err = NewDBOutputStorage(db, userManagement); err != nil {
 		return storage.Storage{}, fmt.Errorf("unable to create output storage: %v", err)
 	}
+
+	var multicastStorage storage.MulticastStorage
+	if multicastStorage, err = NewDBMulticastStorage(db, userManagement); err != nil {
+		return storage.Storage{}, fmt.Errorf("unable to create multicast storage: %v", err)
+	}
+
+	var fuotaStorage storage.FUOTAStorage
+	if fuotaStorage, err = NewDBFUOTAStorage(db, userManagement); err != nil {
+		return storage.Storage{}, fmt.Errorf("unable to create FUOTA storage: %v", err)
+	}
 	return storage.Storage{
 		Application:    appStorage,
 		Device:         devStorage,
@@ -145,6 +172,8 @@ func CreateStorage(connectionString string, maxConn, idleConn int, maxConnLifeti
 		Gateway:        gatewayStorage,
 		Token:          tokenStorage,
 		UserManagement: userManagement,
-		AppOutput:      outputStorage}, nil
+		AppOutput:      outputStorage,
+		Multicast:      multicastStorage,
+		FUOTA:          fuotaStorage}, nil
 
 }
@@ -18,6 +18,7 @@ package dbstore
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
@@ -57,11 +58,19 @@ func NewDBApplicationStorage(db *sql.DB, userManagement storage.UserManagement)
 			lora_application (
 				eui,
 				owner_id,
-				tags)
+				tags,
+				device_status_interval,
+				channels,
+				end_to_end,
+				kek_label)
 		VALUES (
 			$1,
 			$2,
-			$3)`
+			$3,
+			$4,
+			$5,
+			$6,
+			$7)`
 	if ret.putStatement, err = db.Prepare(sqlInsert); err != nil {
 		return nil, fmt.Errorf("unable to prepare insert statement: %v", err)
 	}
@@ -69,7 +78,11 @@ func NewDBApplicationStorage(db *sql.DB, userManagement storage.UserManagement)
 	sqlSelect := `
 		SELECT
 			a.eui,
-			a.tags
+			a.tags,
+			a.device_status_interval,
+			a.channels,
+			a.end_to_end,
+			a.kek_label
 		FROM
 			lora_application a,
 			lora_owner o
@@ -82,7 +95,11 @@ func NewDBApplicationStorage(db *sql.DB, userManagement storage.UserManagement)
 	sqlList := `
 		SELECT
 			a.eui,
-			a.tags
+			a.tags,
+			a.device_status_interval,
+			a.channels,
+			a.end_to_end,
+			a.kek_label
 		FROM
 			lora_application a, lora_owner o
 		WHERE
@@ -104,7 +121,11 @@ func NewDBApplicationStorage(db *sql.DB, userManagement storage.UserManagement)
 	sqlSystemGet := `
 		SELECT
 			a.eui,
-			a.tags
+			a.tags,
+			a.device_status_interval,
+			a.channels,
+			a.end_to_end,
+			a.kek_label
 		FROM
 			lora_application a
 		WHERE
@@ -117,11 +138,15 @@ func NewDBApplicationStorage(db *sql.DB, userManagement storage.UserManagement)
 		UPDATE
 			lora_application a
 		SET
-			tags = $1
+			tags = $1,
+			device_status_interval = $2,
+			channels = $3,
+			end_to_end = $4,
+			kek_label = $5
 		FROM
 			lora_owner o
 		WHERE
-			a.eui = $2 AND a.owner_id = o.owner_id AND o.user_id = $3`
+			a.eui = $6 AND a.owner_id = o.owner_id AND o.user_id = $7`
 	if ret.updateStatement, err = db.Prepare(sqlUpdate); err != nil {
 		return nil, fmt.Errorf("unable to prepare app update statement: %v", err)
 	}
@@ -132,10 +157,13 @@ func (d *dbApplicationStorage) readApplication(rows *sql.Rows) (model.Applicatio
 	var appEUI string
 	var err error
 	var tagBuffer []byte
+	var statusInterval int64
+	var channelBuffer []byte
 	ret := model.NewApplication()
-	if err = rows.Scan(&appEUI, &tagBuffer); err != nil {
+	if err = rows.Scan(&appEUI, &tagBuffer, &statusInterval, &channelBuffer, &ret.EndToEnd, &ret.KEKLabel); err != nil {
 		return ret, err
 	}
+	ret.DeviceStatusInterval = time.Duration(statusInterval) * time.Second
 
 	if ret.AppEUI, err = protocol.EUIFromString(appEUI); err != nil {
 		return ret, fmt.Errorf("invalid App EUI for application: %v (eui=%s)", err, appEUI)
@@ -146,6 +174,10 @@ func (d *dbApplicationStorage) readApplication(rows *sql.Rows) (model.Applicatio
 		return ret, fmt.Errorf("invalid tag buffer for application: %v (eui=%s)", err, appEUI)
 	}
 	ret.Tags = *tags
+
+	if ret.Channels, err = model.NewChannelListFromBuffer(channelBuffer); err != nil {
+		return ret, fmt.Errorf("invalid channel list for application: %v (eui=%s)", err, appEUI)
+	}
 	return ret, nil
 }
 
@@ -197,7 +229,11 @@ func (d *dbApplicationStorage) Put(application model.Application, userID model.U
 	return d.doSQLExecWithOwner(d.putStatement, func(s *sql.Stmt, ownerID uint64) (sql.Result, error) {
 		return s.Exec(application.AppEUI.String(),
 			ownerID,
-			application.Tags.TagJSON())
+			application.Tags.TagJSON(),
+			int64(application.DeviceStatusInterval/time.Second),
+			application.Channels.JSON(),
+			application.EndToEnd,
+			application.KEKLabel)
 	}, userID)
 }
 
@@ -210,6 +246,7 @@ func (d *dbApplicationStorage) Delete(eui protocol.EUI, userID model.UserID) err
 func (d *dbApplicationStorage) Update(application model.Application, userID model.UserID) error {
 	tagBuffer := application.TagJSON()
 	return d.doSQLExecWithOwner(d.updateStatement, func(s *sql.Stmt, ownerID uint64) (sql.Result, error) {
-		return s.Exec(tagBuffer, application.AppEUI.String(), string(userID))
+		return s.Exec(tagBuffer, int64(application.DeviceStatusInterval/time.Second), application.Channels.JSON(),
+			application.EndToEnd, application.KEKLabel, application.AppEUI.String(), string(userID))
 	}, userID)
 }
@@ -71,7 +71,36 @@ func NewDBDeviceStorage(db *sql.DB, userManagement storage.UserManagement) (stor
 				fcnt_dn,
 				relaxed_counter,
 				key_warning,
-				tags)
+				tags,
+				data_rate,
+				tx_power,
+				nb_trans,
+				battery_level,
+				device_margin,
+				status_time,
+				rx1_delay,
+				rx1_dr_offset,
+				rx2_data_rate,
+				rx2_frequency,
+				req_rx1_delay,
+				req_rx1_offset,
+				req_rx2_dr,
+				req_rx2_freq,
+				channels,
+				mac_version,
+				nwk_key,
+				snwksint_key,
+				nwksenc_key,
+				join_nonce,
+				rj_count0,
+				rj_count1,
+				device_class,
+				ping_periodicity,
+				ping_dr,
+				ping_freq,
+				req_ping_dr,
+				req_ping_freq,
+				gen_app_key)
 		VALUES (
 			$1,
 			$2,
@@ -84,7 +113,36 @@ func NewDBDeviceStorage(db *sql.DB, userManagement storage.UserManagement) (stor
 			$9,
 			$10,
 			$11,
-			$12)`
+			$12,
+			$13,
+			$14,
+			$15,
+			$16,
+			$17,
+			$18,
+			$19,
+			$20,
+			$21,
+			$22,
+			$23,
+			$24,
+			$25,
+			$26,
+			$27,
+			$28,
+			$29,
+			$30,
+			$31,
+			$32,
+			$33,
+			$34,
+			$35,
+			$36,
+			$37,
+			$38,
+			$39,
+			$40,
+			$41)`
 	if ret.putStatement, err = db.Prepare(sqlInsert); err != nil {
 		return nil, fmt.Errorf("unable to prepare insert statement: %v", err)
 	}
@@ -102,7 +160,37 @@ func NewDBDeviceStorage(db *sql.DB, userManagement storage.UserManagement) (stor
 			fcnt_dn,
 			relaxed_counter,
 			key_warning,
-			tags
+			tags,
+			data_rate,
+			tx_power,
+			nb_trans,
+			battery_level,
+			device_margin,
+			status_time,
+			rx1_delay,
+			rx1_dr_offset,
+			rx2_data_rate,
+			rx2_frequency,
+			req_rx1_delay,
+			req_rx1_offset,
+			req_rx2_dr,
+			req_rx2_freq,
+			channels,
+			mac_version,
+			nwk_key,
+			snwksint_key,
+			nwksenc_key,
+			join_nonce,
+			rj_count0,
+			rj_count1,
+			device_class,
+			ping_periodicity,
+			ping_dr,
+			ping_freq,
+			req_ping_dr,
+			req_ping_freq,
+			pending_session,
+			gen_app_key
 		FROM
 			lora_device
 		WHERE
@@ -124,7 +212,37 @@ func NewDBDeviceStorage(db *sql.DB, userManagement storage.UserManagement) (stor
 			fcnt_dn,
 			relaxed_counter,
 			key_warning,
-			tags
+			tags,
+			data_rate,
+			tx_power,
+			nb_trans,
+			battery_level,
+			device_margin,
+			status_time,
+			rx1_delay,
+			rx1_dr_offset,
+			rx2_data_rate,
+			rx2_frequency,
+			req_rx1_delay,
+			req_rx1_offset,
+			req_rx2_dr,
+			req_rx2_freq,
+			channels,
+			mac_version,
+			nwk_key,
+			snwksint_key,
+			nwksenc_key,
+			join_nonce,
+			rj_count0,
+			rj_count1,
+			device_class,
+			ping_periodicity,
+			ping_dr,
+			ping_freq,
+			req_ping_dr,
+			req_ping_freq,
+			pending_session,
+			gen_app_key
 		FROM
 			lora_device
 		WHERE
@@ -147,7 +265,37 @@ func NewDBDeviceStorage(db *sql.DB, userManagement storage.UserManagement) (stor
 			fcnt_dn,
 			relaxed_counter,
 			key_warning,
-			tags
+			tags,
+			data_rate,
+			tx_power,
+			nb_trans,
+			battery_level,
+			device_margin,
+			status_time,
+			rx1_delay,
+			rx1_dr_offset,
+			rx2_data_rate,
+			rx2_frequency,
+			req_rx1_delay,
+			req_rx1_offset,
+			req_rx2_dr,
+			req_rx2_freq,
+			channels,
+			mac_version,
+			nwk_key,
+			snwksint_key,
+			nwksenc_key,
+			join_nonce,
+			rj_count0,
+			rj_count1,
+			device_class,
+			ping_periodicity,
+			ping_dr,
+			ping_freq,
+			req_ping_dr,
+			req_ping_freq,
+			pending_session,
+			gen_app_key
 		FROM
 			lora_device
 		WHERE
@@ -190,8 +338,38 @@ func NewDBDeviceStorage(db *sql.DB, userManagement storage.UserManagement) (stor
 			fcnt_dn = $7,
 			relaxed_counter = $8,
 			key_warning = $9,
-			tags = $10
-		WHERE eui = $11`
+			tags = $10,
+			data_rate = $11,
+			tx_power = $12,
+			nb_trans = $13,
+			battery_level = $14,
+			device_margin = $15,
+			status_time = $16,
+			rx1_delay = $17,
+			rx1_dr_offset = $18,
+			rx2_data_rate = $19,
+			rx2_frequency = $20,
+			req_rx1_delay = $21,
+			req_rx1_offset = $22,
+			req_rx2_dr = $23,
+			req_rx2_freq = $24,
+			channels = $25,
+			mac_version = $26,
+			nwk_key = $27,
+			snwksint_key = $28,
+			nwksenc_key = $29,
+			join_nonce = $30,
+			rj_count0 = $31,
+			rj_count1 = $32,
+			device_class = $33,
+			ping_periodicity = $34,
+			ping_dr = $35,
+			ping_freq = $36,
+			req_ping_dr = $37,
+			req_ping_freq = $38,
+			pending_session = $39,
+			gen_app_key = $40
+		WHERE eui = $41`
 	if ret.updateStatement, err = db.Prepare(update); err != nil {
 		return nil, fmt.Errorf("unable to prepare device update statement: %v", err)
 	}
@@ -221,8 +399,9 @@ func (d *dbDeviceStorage) retrieveNonces(device *model.Device) error {
 func (d *dbDeviceStorage) readDevice(row *sql.Rows) (model.Device, error) {
 	ret := model.Device{}
 	var devEUIStr, devAddrStr, appEUIStr, appKeyStr, appSkeyStr, nwkSkeyStr string
+	var nwkKeyStr, sNwkSIntKeyStr, nwkSEncKeyStr, genAppKeyStr string
 	var err error
-	var tagBuffer []byte
+	var tagBuffer, channelBuffer, pendingBuffer []byte
 	if err = row.Scan(
 		&devEUIStr,
 		&devAddrStr,
@@ -235,7 +414,37 @@ func (d *dbDeviceStorage) readDevice(row *sql.Rows) (model.Device, error) {
 		&ret.FCntDn,
 		&ret.RelaxedCounter,
 		&ret.KeyWarning,
-		&tagBuffer); err != nil {
+		&tagBuffer,
+		&ret.DataRate,
+		&ret.TXPower,
+		&ret.NbTrans,
+		&ret.BatteryLevel,
+		&ret.DeviceMargin,
+		&ret.StatusTime,
+		&ret.RXSettings.RX1Delay,
+		&ret.RXSettings.RX1DROffset,
+		&ret.RXSettings.RX2DataRate,
+		&ret.RXSettings.RX2Frequency,
+		&ret.RequestedRX.RX1Delay,
+		&ret.RequestedRX.RX1DROffset,
+		&ret.RequestedRX.RX2DataRate,
+		&ret.RequestedRX.RX2Frequency,
+		&channelBuffer,
+		&ret.MACVersion,
+		&nwkKeyStr,
+		&sNwkSIntKeyStr,
+		&nwkSEncKeyStr,
+		&ret.JoinNonce,
+		&ret.RJCount0,
+		&ret.RJCount1,
+		&ret.Class,
+		&ret.PingSlot.Periodicity,
+		&ret.PingSlot.DataRate,
+		&ret.PingSlot.Frequency,
+		&ret.RequestedPing.DataRate,
+		&ret.RequestedPing.Frequency,
+		&pendingBuffer,
+		&genAppKeyStr); err != nil {
 		return ret, err
 	}
 
@@ -257,12 +466,31 @@ func (d *dbDeviceStorage) readDevice(row *sql.Rows) (model.Device, error) {
 	if ret.NwkSKey, err = protocol.AESKeyFromString(nwkSkeyStr); err != nil {
 		return ret, fmt.Errorf("invalid NwkSKey: %v (key=%s)", err, nwkSkeyStr)
 	}
+	if ret.NwkKey, err = protocol.AESKeyFromString(nwkKeyStr); err != nil {
+		return ret, fmt.Errorf("invalid NwkKey: %v (key=%s)", err, nwkKeyStr)
+	}
+	if ret.GenAppKey, err = protocol.AESKeyFromString(genAppKeyStr); err != nil {
+		return ret, fmt.Errorf("invalid GenAppKey: %v (key=%s)", err, genAppKeyStr)
+	}
+	if ret.SNwkSIntKey, err = protocol.AESKeyFromString(sNwkSIntKeyStr); err != nil {
+		return ret, fmt.Errorf("invalid SNwkSIntKey: %v (key=%s)", err, sNwkSIntKeyStr)
+	}
+	if ret.NwkSEncKey, err = protocol.AESKeyFromString(nwkSEncKeyStr); err != nil {
+		return ret, fmt.Errorf("invalid NwkSEncKey: %v (key=%s)", err, nwkSEncKeyStr)
+	}
 
 	tags, err := model.NewTagsFromBuffer(tagBuffer)
 	if err != nil {
 		return ret, fmt.Errorf("invalid tag buffer: %v (key=%s)", err, devEUIStr)
 	}
 	ret.Tags = *tags
+
+	if ret.Channels, err = model.NewChannelListFromBuffer(channelBuffer); err != nil {
+		return ret, fmt.Errorf("invalid channel list: %v (key=%s)", err, devEUIStr)
+	}
+	if ret.PendingSession, err = model.NewPendingSessionFromBuffer(pendingBuffer); err != nil {
+		return ret, fmt.Errorf("invalid pending session: %v (key=%s)", err, devEUIStr)
+	}
 	return ret, d.retrieveNonces(&ret)
 }
 
@@ -331,7 +559,36 @@ func (d *dbDeviceStorage) Put(device model.Device, appEUI protocol.EUI) error {
 			device.FCntDn,
 			device.RelaxedCounter,
 			device.KeyWarning,
-			device.Tags.TagJSON())
+			device.Tags.TagJSON(),
+			device.DataRate,
+			device.TXPower,
+			device.NbTrans,
+			device.BatteryLevel,
+			device.DeviceMargin,
+			device.StatusTime,
+			device.RXSettings.RX1Delay,
+			device.RXSettings.RX1DROffset,
+			device.RXSettings.RX2DataRate,
+			device.RXSettings.RX2Frequency,
+			device.RequestedRX.RX1Delay,
+			device.RequestedRX.RX1DROffset,
+			device.RequestedRX.RX2DataRate,
+			device.RequestedRX.RX2Frequency,
+			device.Channels.JSON(),
+			uint8(device.MACVersion),
+			device.NwkKey.String(),
+			device.SNwkSIntKey.String(),
+			device.NwkSEncKey.String(),
+			device.JoinNonce,
+			device.RJCount0,
+			device.RJCount1,
+			uint8(device.Class),
+			device.PingSlot.Periodicity,
+			device.PingSlot.DataRate,
+			device.PingSlot.Frequency,
+			device.RequestedPing.DataRate,
+			device.RequestedPing.Frequency,
+			device.GenAppKey.String())
 	})
 }
 
@@ -366,6 +623,36 @@ func (d *dbDeviceStorage) Update(device model.Device) error {
 			device.RelaxedCounter,
 			device.KeyWarning,
 			device.Tags.TagJSON(),
+			device.DataRate,
+			device.TXPower,
+			device.NbTrans,
+			device.BatteryLevel,
+			device.DeviceMargin,
+			device.StatusTime,
+			device.RXSettings.RX1Delay,
+			device.RXSettings.RX1DROffset,
+			device.RXSettings.RX2DataRate,
+			device.RXSettings.RX2Frequency,
+			device.RequestedRX.RX1Delay,
+			device.RequestedRX.RX1DROffset,
+			device.RequestedRX.RX2DataRate,
+			device.RequestedRX.RX2Frequency,
+			device.Channels.JSON(),
+			uint8(device.MACVersion),
+			device.NwkKey.String(),
+			device.SNwkSIntKey.String(),
+			device.NwkSEncKey.String(),
+			device.JoinNonce,
+			device.RJCount0,
+			device.RJCount1,
+			uint8(device.Class),
+			device.PingSlot.Periodicity,
+			device.PingSlot.DataRate,
+			device.PingSlot.Frequency,
+			device.RequestedPing.DataRate,
+			device.RequestedPing.Frequency,
+			device.PendingSession.JSON(),
+			device.GenAppKey.String(),
 			device.DeviceEUI.String())
 	})
 }
@@ -36,7 +36,13 @@ type dbDataStorage struct {
 	putDownstream    *sql.Stmt
 	deleteDownstream *sql.Stmt
 	updateDownstream *sql.Stmt
-	getDownstream    *sql.Stmt
+	failDownstream   *sql.Stmt
+	listDownstream   *sql.Stmt
+	listExpired      *sql.Stmt
+	updateAttempts   *sql.Stmt
+	deleteMessage    *sql.Stmt
+	updatePriority   *sql.Stmt
+	updateWindow     *sql.Stmt
 }
 
 // Close closes the resources opened by the DBDataStorage instance
@@ -47,12 +53,18 @@ func (d *dbDataStorage) Close() {
 	d.putDownstream.Close()
 	d.deleteDownstream.Close()
 	d.updateDownstream.Close()
-	d.getDownstream.Close()
+	d.failDownstream.Close()
+	d.listDownstream.Close()
+	d.listExpired.Close()
+	d.updateAttempts.Close()
+	d.deleteMessage.Close()
+	d.updatePriority.Close()
+	d.updateWindow.Close()
 }
 
 // NewDBDataStorage creates a new DataStorage instance.
 func NewDBDataStorage(db *sql.DB, userManagement storage.UserManagement) (storage.DataStorage, error) {
-	ret := dbDataStorage{dbStore{db: db, userManagement: userManagement}, nil, nil, nil, nil, nil, nil, nil}
+	ret := dbDataStorage{dbStore{db: db, userManagement: userManagement}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}
 	var err error
 
 	sqlInsert := `
@@ -109,12 +121,19 @@ func NewDBDataStorage(db *sql.DB, userManagement storage.UserManagement) (storag
 	sqlPutDownstream := `
 		INSERT INTO lora_downstream_message (
 			device_eui,
+			id,
+			priority,
 			data,
 			port,
 			ack,
 			created_time,
 			sent_time,
-			ack_time)
+			ack_time,
+			attempts,
+			expires_time,
+			failed_time,
+			fcnt,
+			rx_window)
 		VALUES (
 			$1,
 			$2,
@@ -122,7 +141,14 @@ func NewDBDataStorage(db *sql.DB, userManagement storage.UserManagement) (storag
 			$4,
 			$5,
 			$6,
-			$7)
+			$7,
+			$8,
+			$9,
+			$10,
+			$11,
+			$12,
+			$13,
+			$14)
 	`
 	if ret.putDownstream, err = db.Prepare(sqlPutDownstream); err != nil {
 		return nil, fmt.Errorf("unable to prepare downstream put statement: %v", err)
@@ -138,34 +164,128 @@ func NewDBDataStorage(db *sql.DB, userManagement storage.UserManagement) (storag
 		return nil, fmt.Errorf("unable to prepare downstream delete statement: %v", err)
 	}
 
+	sqlDeleteMessage := `
+		DELETE FROM
+			lora_downstream_message
+		WHERE
+			device_eui = $1 AND id = $2
+	`
+	if ret.deleteMessage, err = db.Prepare(sqlDeleteMessage); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream message delete statement: %v", err)
+	}
+
 	sqlUpdateDownstream := `
 		UPDATE lora_downstream_message
 			SET
 				sent_time = $1,
 				ack_time = $2
 			WHERE
-				device_eui = $3
+				device_eui = $3 AND id = $4
 	`
 	if ret.updateDownstream, err = db.Prepare(sqlUpdateDownstream); err != nil {
 		return nil, fmt.Errorf("unable to prepare downstream update statement")
 	}
 
-	sqlGetDownstream := `
+	sqlFailDownstream := `
+		UPDATE lora_downstream_message
+			SET
+				sent_time = 0,
+				rx_window = 0,
+				tx_error = $1,
+				attempts = GREATEST(attempts - 1, 0)
+			WHERE
+				device_eui = $2 AND id = $3
+	`
+	if ret.failDownstream, err = db.Prepare(sqlFailDownstream); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream fail statement")
+	}
+
+	sqlUpdateAttempts := `
+		UPDATE lora_downstream_message
+			SET
+				attempts = $1,
+				failed_time = $2
+			WHERE
+				device_eui = $3 AND id = $4
+	`
+	if ret.updateAttempts, err = db.Prepare(sqlUpdateAttempts); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream attempts statement")
+	}
+
+	sqlUpdatePriority := `
+		UPDATE lora_downstream_message
+			SET
+				priority = $1
+			WHERE
+				device_eui = $2 AND id = $3
+	`
+	if ret.updatePriority, err = db.Prepare(sqlUpdatePriority); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream priority statement")
+	}
+
+	sqlUpdateWindow := `
+		UPDATE lora_downstream_message
+			SET
+				rx_window = $1
+			WHERE
+				device_eui = $2 AND id = $3
+	`
+	if ret.updateWindow, err = db.Prepare(sqlUpdateWindow); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream window statement")
+	}
+
+	sqlListDownstream := `
 		SELECT
+			id,
+			priority,
 			data,
 			port,
 			ack,
 			created_time,
 			sent_time,
-			ack_time
+			ack_time,
+			tx_error,
+			attempts,
+			expires_time,
+			failed_time,
+			fcnt,
+			rx_window
 		FROM
 			lora_downstream_message
 		WHERE
 			device_eui = $1
 	`
-	if ret.getDownstream, err = db.Prepare(sqlGetDownstream); err != nil {
+	if ret.listDownstream, err = db.Prepare(sqlListDownstream); err != nil {
 		return nil, fmt.Errorf("unable to prepare downstream select statement")
 	}
+
+	sqlListExpired := `
+		SELECT
+			device_eui,
+			id,
+			priority,
+			data,
+			port,
+			ack,
+			created_time,
+			sent_time,
+			ack_time,
+			tx_error,
+			attempts,
+			expires_time,
+			failed_time,
+			fcnt,
+			rx_window
+		FROM
+			lora_downstream_message
+		WHERE
+			failed_time = 0 AND
+			expires_time <> 0 AND expires_time <= $1 AND
+			(sent_time = 0 OR (ack AND ack_time = 0))
+	`
+	if ret.listExpired, err = db.Prepare(sqlListExpired); err != nil {
+		return nil, fmt.Errorf("unable to prepare expired downstream select statement")
+	}
 	return &ret, nil
 }
 
@@ -242,12 +362,19 @@ func (d *dbDataStorage) PutDownstream(deviceEUI protocol.EUI, message model.Down
 	return d.doSQLExec(d.putDownstream, func(s *sql.Stmt) (sql.Result, error) {
 		return s.Exec(
 			deviceEUI.String(),
+			int64(message.ID),
+			message.Priority,
 			message.Data,
 			message.Port,
 			message.Ack,
 			message.CreatedTime,
 			message.SentTime,
-			message.AckTime)
+			message.AckTime,
+			message.Attempts,
+			message.ExpiresTime,
+			message.FailedTime,
+			int64(message.FCnt),
+			message.RXWindow)
 	})
 }
 
@@ -257,28 +384,110 @@ func (d *dbDataStorage) DeleteDownstream(deviceEUI protocol.EUI) error {
 	})
 }
 
+func (d *dbDataStorage) DeleteDownstreamMessage(deviceEUI protocol.EUI, id uint64) error {
+	return d.doSQLExec(d.deleteMessage, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(deviceEUI.String(), int64(id))
+	})
+}
+
 func (d *dbDataStorage) GetDownstream(deviceEUI protocol.EUI) (model.DownstreamMessage, error) {
-	ret := model.NewDownstreamMessage(deviceEUI, 0)
+	queue, err := d.ListDownstream(deviceEUI)
+	if err != nil {
+		return model.DownstreamMessage{}, err
+	}
+	if len(queue) == 0 {
+		return model.DownstreamMessage{}, storage.ErrNotFound
+	}
+	return queue[0], nil
+}
 
-	rows, err := d.getDownstream.Query(deviceEUI.String())
+func (d *dbDataStorage) ListDownstream(deviceEUI protocol.EUI) ([]model.DownstreamMessage, error) {
+	rows, err := d.listDownstream.Query(deviceEUI.String())
 	if err != nil {
-		return ret, fmt.Errorf("unable to query for downstream message: %v", err)
+		return nil, fmt.Errorf("unable to query for downstream messages: %v", err)
 	}
 	defer rows.Close()
-	if !rows.Next() {
-		return ret, storage.ErrNotFound
+	ret := make([]model.DownstreamMessage, 0)
+	for rows.Next() {
+		msg := model.DownstreamMessage{DeviceEUI: deviceEUI}
+		var id int64
+		if err := rows.Scan(&id, &msg.Priority, &msg.Data, &msg.Port, &msg.Ack, &msg.CreatedTime, &msg.SentTime, &msg.AckTime, &msg.TXError, &msg.Attempts, &msg.ExpiresTime, &msg.FailedTime, &msg.FCnt, &msg.RXWindow); err != nil {
+			return nil, fmt.Errorf("unable to read fields from downstream result: %v", err)
+		}
+		msg.ID = uint64(id)
+		ret = append(ret, msg)
+	}
+	model.SortDownstreamQueue(ret)
+	return ret, nil
+}
+
+func (d *dbDataStorage) ListExpiredDownstream(now int64) ([]model.DownstreamMessage, error) {
+	rows, err := d.listExpired.Query(now)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query for expired downstream messages: %v", err)
 	}
-	if err := rows.Scan(&ret.Data, &ret.Port, &ret.Ack, &ret.CreatedTime, &ret.SentTime, &ret.AckTime); err != nil {
-		return ret, fmt.Errorf("unable to read fields from downstream result: %v", err)
+	defer rows.Close()
+	ret := make([]model.DownstreamMessage, 0)
+	for rows.Next() {
+		msg := model.DownstreamMessage{}
+		var id int64
+		var deviceEUI string
+		if err := rows.Scan(&deviceEUI, &id, &msg.Priority, &msg.Data, &msg.Port, &msg.Ack, &msg.CreatedTime, &msg.SentTime, &msg.AckTime, &msg.TXError, &msg.Attempts, &msg.ExpiresTime, &msg.FailedTime, &msg.FCnt, &msg.RXWindow); err != nil {
+			return nil, fmt.Errorf("unable to read fields from expired downstream result: %v", err)
+		}
+		if msg.DeviceEUI, err = protocol.EUIFromString(deviceEUI); err != nil {
+			logging.Warning("Invalid device EUI for downstream message (eui=%s): %v", deviceEUI, err)
+			continue
+		}
+		msg.ID = uint64(id)
+		ret = append(ret, msg)
 	}
 	return ret, nil
 }
 
-func (d *dbDataStorage) UpdateDownstream(deviceEUI protocol.EUI, sentTime int64, ackTime int64) error {
+func (d *dbDataStorage) UpdateDownstream(deviceEUI protocol.EUI, id uint64, sentTime int64, ackTime int64) error {
 	return d.doSQLExec(d.updateDownstream, func(s *sql.Stmt) (sql.Result, error) {
 		return s.Exec(
 			sentTime,
 			ackTime,
-			deviceEUI.String())
+			deviceEUI.String(),
+			int64(id))
+	})
+}
+
+func (d *dbDataStorage) FailDownstream(deviceEUI protocol.EUI, id uint64, txError string) error {
+	return d.doSQLExec(d.failDownstream, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			txError,
+			deviceEUI.String(),
+			int64(id))
+	})
+}
+
+func (d *dbDataStorage) UpdateDownstreamAttempts(deviceEUI protocol.EUI, id uint64, attempts uint8, failedTime int64) error {
+	return d.doSQLExec(d.updateAttempts, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			attempts,
+			failedTime,
+			deviceEUI.String(),
+			int64(id))
+	})
+}
+
+func (d *dbDataStorage) UpdateDownstreamPriority(deviceEUI protocol.EUI, id uint64, priority uint8) error {
+	return d.doSQLExec(d.updatePriority, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			priority,
+			deviceEUI.String(),
+			int64(id))
+	})
+}
+
+func (d *dbDataStorage) UpdateDownstreamWindow(deviceEUI protocol.EUI, id uint64, rxWindow uint8) error {
+	return d.doSQLExec(d.updateWindow, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			rxWindow,
+			deviceEUI.String(),
+			int64(id))
 	})
 }
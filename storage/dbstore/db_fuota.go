@@ -0,0 +1,300 @@
+package dbstore
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+type dbFUOTAStorage struct {
+	dbStore
+	putStatement          *sql.Stmt
+	getStatement          *sql.Stmt
+	appListStatement      *sql.Stmt
+	activeListStatement   *sql.Stmt
+	updateStatement       *sql.Stmt
+	deleteStatement       *sql.Stmt
+	putDeviceStatement    *sql.Stmt
+	updateDeviceStatement *sql.Stmt
+	deviceListStatement   *sql.Stmt
+}
+
+func (d *dbFUOTAStorage) Close() {
+	d.putStatement.Close()
+	d.getStatement.Close()
+	d.appListStatement.Close()
+	d.activeListStatement.Close()
+	d.updateStatement.Close()
+	d.deleteStatement.Close()
+	d.putDeviceStatement.Close()
+	d.updateDeviceStatement.Close()
+	d.deviceListStatement.Close()
+}
+
+// NewDBFUOTAStorage creates a new FUOTAStorage instance backed by a database
+func NewDBFUOTAStorage(db *sql.DB, userManagement storage.UserManagement) (storage.FUOTAStorage, error) {
+	ret := dbFUOTAStorage{dbStore: dbStore{db: db, userManagement: userManagement}}
+
+	const campaignFields = `eui, application_eui, group_eui, mc_key, group_id, frag_index,
+		firmware, frag_size, redundancy, descriptor, frag_interval, session_time,
+		session_timeout, state, fragments_sent, created_time`
+
+	var err error
+	sqlInsert := `INSERT INTO lora_fuota_campaign (` + campaignFields + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`
+	if ret.putStatement, err = db.Prepare(sqlInsert); err != nil {
+		return nil, fmt.Errorf("unable to prepare insert statement: %v", err)
+	}
+
+	sqlSelect := `SELECT ` + campaignFields + ` FROM lora_fuota_campaign WHERE eui = $1`
+	if ret.getStatement, err = db.Prepare(sqlSelect); err != nil {
+		return nil, fmt.Errorf("unable to prepare select statement: %v", err)
+	}
+
+	sqlAppList := `SELECT ` + campaignFields + ` FROM lora_fuota_campaign WHERE application_eui = $1`
+	if ret.appListStatement, err = db.Prepare(sqlAppList); err != nil {
+		return nil, fmt.Errorf("unable to prepare application statement: %v", err)
+	}
+
+	sqlActiveList := `SELECT ` + campaignFields + ` FROM lora_fuota_campaign WHERE state IN ($1, $2, $3)`
+	if ret.activeListStatement, err = db.Prepare(sqlActiveList); err != nil {
+		return nil, fmt.Errorf("unable to prepare active list statement: %v", err)
+	}
+
+	sqlUpdate := `UPDATE lora_fuota_campaign SET state = $1, fragments_sent = $2 WHERE eui = $3`
+	if ret.updateStatement, err = db.Prepare(sqlUpdate); err != nil {
+		return nil, fmt.Errorf("unable to prepare update statement: %v", err)
+	}
+
+	sqlDelete := `DELETE FROM lora_fuota_campaign WHERE eui = $1`
+	if ret.deleteStatement, err = db.Prepare(sqlDelete); err != nil {
+		return nil, fmt.Errorf("unable to prepare delete statement: %v", err)
+	}
+
+	sqlPutDevice := `INSERT INTO lora_fuota_device (campaign_eui, device_eui, state, nb_frag_received, missing_frag, error, updated_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if ret.putDeviceStatement, err = db.Prepare(sqlPutDevice); err != nil {
+		return nil, fmt.Errorf("unable to prepare device insert statement: %v", err)
+	}
+
+	sqlUpdateDevice := `UPDATE lora_fuota_device SET
+			state = $1,
+			nb_frag_received = $2,
+			missing_frag = $3,
+			error = $4,
+			updated_time = $5
+		WHERE campaign_eui = $6 AND device_eui = $7`
+	if ret.updateDeviceStatement, err = db.Prepare(sqlUpdateDevice); err != nil {
+		return nil, fmt.Errorf("unable to prepare device update statement: %v", err)
+	}
+
+	sqlDeviceList := `SELECT campaign_eui, device_eui, state, nb_frag_received, missing_frag, error, updated_time
+		FROM lora_fuota_device
+		WHERE campaign_eui = $1
+		ORDER BY device_eui`
+	if ret.deviceListStatement, err = db.Prepare(sqlDeviceList); err != nil {
+		return nil, fmt.Errorf("unable to prepare device list statement: %v", err)
+	}
+
+	return &ret, nil
+}
+
+func (d *dbFUOTAStorage) readCampaign(rows *sql.Rows) (model.FUOTACampaign, error) {
+	ret := model.FUOTACampaign{}
+	var campaignEUIStr, appEUIStr, groupEUIStr, mcKeyStr string
+	var descriptor int64
+	if err := rows.Scan(
+		&campaignEUIStr,
+		&appEUIStr,
+		&groupEUIStr,
+		&mcKeyStr,
+		&ret.GroupID,
+		&ret.FragIndex,
+		&ret.Firmware,
+		&ret.FragSize,
+		&ret.Redundancy,
+		&descriptor,
+		&ret.Interval,
+		&ret.SessionTime,
+		&ret.TimeOut,
+		&ret.State,
+		&ret.FragmentsSent,
+		&ret.CreatedTime); err != nil {
+		return ret, err
+	}
+	var err error
+	if ret.CampaignEUI, err = protocol.EUIFromString(campaignEUIStr); err != nil {
+		return ret, fmt.Errorf("invalid campaign EUI: %v (eui=%s)", err, campaignEUIStr)
+	}
+	if ret.AppEUI, err = protocol.EUIFromString(appEUIStr); err != nil {
+		return ret, fmt.Errorf("invalid App EUI: %v (eui=%s)", err, appEUIStr)
+	}
+	if ret.GroupEUI, err = protocol.EUIFromString(groupEUIStr); err != nil {
+		return ret, fmt.Errorf("invalid group EUI: %v (eui=%s)", err, groupEUIStr)
+	}
+	if ret.McKey, err = protocol.AESKeyFromString(mcKeyStr); err != nil {
+		return ret, fmt.Errorf("invalid McKey: %v (key=%s)", err, mcKeyStr)
+	}
+	ret.Descriptor = uint32(descriptor)
+	return ret, nil
+}
+
+func (d *dbFUOTAStorage) Put(campaign model.FUOTACampaign) error {
+	return d.doSQLExec(d.putStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			campaign.CampaignEUI.String(),
+			campaign.AppEUI.String(),
+			campaign.GroupEUI.String(),
+			campaign.McKey.String(),
+			campaign.GroupID,
+			campaign.FragIndex,
+			campaign.Firmware,
+			campaign.FragSize,
+			campaign.Redundancy,
+			int64(campaign.Descriptor),
+			campaign.Interval,
+			campaign.SessionTime,
+			campaign.TimeOut,
+			uint8(campaign.State),
+			campaign.FragmentsSent,
+			campaign.CreatedTime)
+	})
+}
+
+func (d *dbFUOTAStorage) GetByEUI(campaignEUI protocol.EUI) (model.FUOTACampaign, error) {
+	rows, err := d.getStatement.Query(campaignEUI.String())
+	if err != nil {
+		return model.FUOTACampaign{}, fmt.Errorf("unable to query for campaign: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return model.FUOTACampaign{}, storage.ErrNotFound
+	}
+	return d.readCampaign(rows)
+}
+
+// campaignList returns the campaigns in the result set on a channel
+func (d *dbFUOTAStorage) campaignList(rows *sql.Rows) <-chan model.FUOTACampaign {
+	ret := make(chan model.FUOTACampaign)
+	go func() {
+		defer rows.Close()
+		defer close(ret)
+		for rows.Next() {
+			campaign, err := d.readCampaign(rows)
+			if err != nil {
+				logging.Warning("Unable to read campaign from storage: %v", err)
+				continue
+			}
+			select {
+			case ret <- campaign:
+			case <-time.After(1 * time.Second):
+				continue
+			}
+		}
+	}()
+	return ret
+}
+
+func (d *dbFUOTAStorage) GetByApplicationEUI(appEUI protocol.EUI) (<-chan model.FUOTACampaign, error) {
+	rows, err := d.appListStatement.Query(appEUI.String())
+	if err != nil {
+		return nil, err
+	}
+	return d.campaignList(rows), nil
+}
+
+func (d *dbFUOTAStorage) ListActive() (<-chan model.FUOTACampaign, error) {
+	rows, err := d.activeListStatement.Query(uint8(model.FUOTASetup), uint8(model.FUOTAFragmenting), uint8(model.FUOTAStatus))
+	if err != nil {
+		return nil, err
+	}
+	return d.campaignList(rows), nil
+}
+
+func (d *dbFUOTAStorage) Update(campaign model.FUOTACampaign) error {
+	return d.doSQLExec(d.updateStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(uint8(campaign.State), campaign.FragmentsSent, campaign.CampaignEUI.String())
+	})
+}
+
+func (d *dbFUOTAStorage) Delete(campaignEUI protocol.EUI) error {
+	return d.doSQLExec(d.deleteStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(campaignEUI.String())
+	})
+}
+
+func (d *dbFUOTAStorage) PutDevice(device model.FUOTADevice) error {
+	return d.doSQLExec(d.putDeviceStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			device.CampaignEUI.String(),
+			device.DeviceEUI.String(),
+			uint8(device.State),
+			device.NbFragReceived,
+			device.MissingFrag,
+			device.Error,
+			device.UpdatedTime)
+	})
+}
+
+func (d *dbFUOTAStorage) UpdateDevice(device model.FUOTADevice) error {
+	return d.doSQLExec(d.updateDeviceStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			uint8(device.State),
+			device.NbFragReceived,
+			device.MissingFrag,
+			device.Error,
+			device.UpdatedTime,
+			device.CampaignEUI.String(),
+			device.DeviceEUI.String())
+	})
+}
+
+func (d *dbFUOTAStorage) GetDevices(campaignEUI protocol.EUI) (<-chan model.FUOTADevice, error) {
+	rows, err := d.deviceListStatement.Query(campaignEUI.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(chan model.FUOTADevice)
+	go func() {
+		defer rows.Close()
+		defer close(ret)
+		for rows.Next() {
+			var campaignEUIStr, deviceEUIStr string
+			device := model.FUOTADevice{}
+			if err := rows.Scan(&campaignEUIStr, &deviceEUIStr, &device.State, &device.NbFragReceived,
+				&device.MissingFrag, &device.Error, &device.UpdatedTime); err != nil {
+				logging.Warning("Unable to read FUOTA device from storage: %v", err)
+				continue
+			}
+			device.CampaignEUI, _ = protocol.EUIFromString(campaignEUIStr)
+			device.DeviceEUI, _ = protocol.EUIFromString(deviceEUIStr)
+			select {
+			case ret <- device:
+			case <-time.After(1 * time.Second):
+				continue
+			}
+		}
+	}()
+	return ret, nil
+}
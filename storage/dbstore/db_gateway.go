@@ -17,11 +17,13 @@ package dbstore
 //
 import (
 	"fmt"
+	"time"
 
 	"database/sql"
 
 	"net"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/storage"
@@ -36,7 +38,13 @@ type dbGatewayStorage struct {
 	getStatement        *sql.Stmt // Prepare statement for select
 	getSysStatement     *sql.Stmt // Prepare statement for system get (ie all gateways)
 	updateStatement     *sql.Stmt // Prepare statement for gatway update
+	locationStatement   *sql.Stmt // Prepare statement for location update
 	publicListStatement *sql.Stmt
+
+	// Status reports from the gateways
+	putStatusStatement    *sql.Stmt
+	getStatusStatement    *sql.Stmt
+	deleteStatusStatement *sql.Stmt
 }
 
 func (d *dbGatewayStorage) Close() {
@@ -46,12 +54,16 @@ func (d *dbGatewayStorage) Close() {
 	d.getStatement.Close()
 	d.getSysStatement.Close()
 	d.updateStatement.Close()
+	d.locationStatement.Close()
 	d.publicListStatement.Close()
+	d.putStatusStatement.Close()
+	d.getStatusStatement.Close()
+	d.deleteStatusStatement.Close()
 }
 
 // NewDBGatewayStorage returns a DB-backed GatewayStorage implementation.
 func NewDBGatewayStorage(db *sql.DB, userManagement storage.UserManagement) (storage.GatewayStorage, error) {
-	ret := dbGatewayStorage{dbStore{db: db, userManagement: userManagement}, nil, nil, nil, nil, nil, nil, nil}
+	ret := dbGatewayStorage{dbStore: dbStore{db: db, userManagement: userManagement}}
 
 	var err error
 	sqlSelect := `
@@ -62,7 +74,10 @@ func NewDBGatewayStorage(db *sql.DB, userManagement storage.UserManagement) (sto
 			gw.altitude,
 			gw.ip,
 			gw.strict_ip,
-			gw.tags
+			gw.tags,
+			gw.band,
+			gw.sub_band,
+			gw.station_token
 		FROM
 			lora_gateway gw,
 			lora_owner o
@@ -82,8 +97,11 @@ func NewDBGatewayStorage(db *sql.DB, userManagement storage.UserManagement) (sto
 			ip,
 			strict_ip,
 			owner_id,
-			tags)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+			tags,
+			band,
+			sub_band,
+			station_token)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 	if ret.putStatement, err = db.Prepare(sqlInsert); err != nil {
 		return nil, fmt.Errorf("unable to prepare insert statement: %v", err)
 	}
@@ -107,7 +125,10 @@ func NewDBGatewayStorage(db *sql.DB, userManagement storage.UserManagement) (sto
 			gw.altitude,
 			gw.ip,
 			gw.strict_ip,
-			gw.tags
+			gw.tags,
+			gw.band,
+			gw.sub_band,
+			gw.station_token
 		FROM
 			lora_gateway gw,
 			lora_owner o
@@ -125,7 +146,10 @@ func NewDBGatewayStorage(db *sql.DB, userManagement storage.UserManagement) (sto
 			gw.altitude,
 			gw.ip,
 			gw.strict_ip,
-			gw.tags
+			gw.tags,
+			gw.band,
+			gw.sub_band,
+			gw.station_token
 		FROM
 			lora_gateway gw
 		WHERE
@@ -138,20 +162,82 @@ func NewDBGatewayStorage(db *sql.DB, userManagement storage.UserManagement) (sto
 		UPDATE
 			lora_gateway gw
 		SET
-			latitude = $1, longitude = $2, altitude = $3, ip = $4, strict_ip = $5, tags = $6
+			latitude = $1, longitude = $2, altitude = $3, ip = $4, strict_ip = $5, tags = $6, band = $7, sub_band = $8,
+			station_token = $9
 		FROM
 			lora_owner o
 		WHERE
-			gw.gateway_eui = $7 AND gw.owner_id = o.owner_id AND o.user_id = $8
+			gw.gateway_eui = $10 AND gw.owner_id = o.owner_id AND o.user_id = $11
 	`
 	if ret.updateStatement, err = db.Prepare(updateStatement); err != nil {
 		return nil, fmt.Errorf("unable to prepare update statement: %v", err)
 	}
 
+	locationStatement := `
+		UPDATE
+			lora_gateway
+		SET
+			latitude = $1, longitude = $2, altitude = COALESCE($3, altitude)
+		WHERE
+			gateway_eui = $4
+	`
+	if ret.locationStatement, err = db.Prepare(locationStatement); err != nil {
+		return nil, fmt.Errorf("unable to prepare location update statement: %v", err)
+	}
+
 	publicListStatement := `SELECT gateway_eui, latitude, longitude, altitude FROM lora_gateway`
 	if ret.publicListStatement, err = db.Prepare(publicListStatement); err != nil {
 		return nil, fmt.Errorf("unable to prepare public gateway statement: %v", err)
 	}
+
+	putStatusStatement := `
+		INSERT INTO lora_gateway_status (
+			gateway_eui,
+			received_time,
+			gateway_time,
+			rx_received,
+			rx_ok,
+			rx_forwarded,
+			ack_ratio,
+			downlink_received,
+			tx_emitted,
+			has_location,
+			latitude,
+			longitude,
+			altitude)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+	if ret.putStatusStatement, err = db.Prepare(putStatusStatement); err != nil {
+		return nil, fmt.Errorf("unable to prepare status insert statement: %v", err)
+	}
+
+	getStatusStatement := `
+		SELECT
+			received_time,
+			gateway_time,
+			rx_received,
+			rx_ok,
+			rx_forwarded,
+			ack_ratio,
+			downlink_received,
+			tx_emitted,
+			has_location,
+			latitude,
+			longitude,
+			altitude
+		FROM
+			lora_gateway_status
+		WHERE
+			gateway_eui = $1
+		ORDER BY
+			received_time ASC, id ASC`
+	if ret.getStatusStatement, err = db.Prepare(getStatusStatement); err != nil {
+		return nil, fmt.Errorf("unable to prepare status select statement: %v", err)
+	}
+
+	deleteStatusStatement := `DELETE FROM lora_gateway_status WHERE gateway_eui = $1 AND received_time < $2`
+	if ret.deleteStatusStatement, err = db.Prepare(deleteStatusStatement); err != nil {
+		return nil, fmt.Errorf("unable to prepare status delete statement: %v", err)
+	}
 	return &ret, nil
 }
 
@@ -159,14 +245,17 @@ func (d *dbGatewayStorage) readGateway(rows *sql.Rows) (model.Gateway, error) {
 	var euiStr, ipStr string
 	var err error
 	var json []uint8
+	var bandType, subBand uint8
 	gw := model.NewGateway()
-	if err := rows.Scan(&euiStr, &gw.Latitude, &gw.Longitude, &gw.Altitude, &ipStr, &gw.StrictIP, &json); err != nil {
+	if err := rows.Scan(&euiStr, &gw.Latitude, &gw.Longitude, &gw.Altitude, &ipStr, &gw.StrictIP, &json, &bandType, &subBand, &gw.StationToken); err != nil {
 		return gw, err
 	}
 	if gw.GatewayEUI, err = protocol.EUIFromString(euiStr); err != nil {
 		return gw, err
 	}
 	gw.IP = net.ParseIP(ipStr)
+	gw.Band = band.FrequencyBandType(bandType)
+	gw.SubBand = subBand
 	tags, err := model.NewTagsFromBuffer(json[:])
 	if err != nil {
 		return gw, err
@@ -254,7 +343,10 @@ func (d *dbGatewayStorage) Put(gateway model.Gateway, userID model.UserID) error
 			gateway.IP.String(),
 			gateway.StrictIP,
 			ownerID,
-			gateway.TagJSON())
+			gateway.TagJSON(),
+			gateway.Band,
+			gateway.SubBand,
+			gateway.StationToken)
 	}, userID)
 }
 
@@ -267,6 +359,77 @@ func (d *dbGatewayStorage) Delete(eui protocol.EUI, userID model.UserID) error {
 func (d *dbGatewayStorage) Update(gateway model.Gateway, userID model.UserID) error {
 	return d.doSQLExecWithOwner(d.updateStatement, func(s *sql.Stmt, ownerID uint64) (sql.Result, error) {
 		return s.Exec(gateway.Latitude, gateway.Longitude, gateway.Altitude,
-			gateway.IP.String(), gateway.StrictIP, gateway.Tags.TagJSON(), gateway.GatewayEUI.String(), string(userID))
+			gateway.IP.String(), gateway.StrictIP, gateway.Tags.TagJSON(), gateway.Band, gateway.SubBand,
+			gateway.StationToken, gateway.GatewayEUI.String(), string(userID))
 	}, userID)
 }
+
+func (d *dbGatewayStorage) UpdateLocation(eui protocol.EUI, latitude, longitude float32, altitude *float32) error {
+	return d.doSQLExec(d.locationStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(latitude, longitude, altitude, eui.String())
+	})
+}
+
+func (d *dbGatewayStorage) PutStatus(eui protocol.EUI, status model.GatewayStatus) error {
+	var gatewayTime int64
+	if !status.GatewayTime.IsZero() {
+		gatewayTime = status.GatewayTime.UnixNano()
+	}
+	err := d.doSQLExec(d.putStatusStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			eui.String(),
+			status.Received.UnixNano(),
+			gatewayTime,
+			status.RXReceived,
+			status.RXOK,
+			status.RXForwarded,
+			status.ACKRatio,
+			status.DownlinkReceived,
+			status.TXEmitted,
+			status.HasLocation,
+			status.Latitude,
+			status.Longitude,
+			status.Altitude)
+	})
+	if err == storage.ErrDeleteConstraint {
+		// The gateway doesn't exist
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+func (d *dbGatewayStorage) GetStatus(eui protocol.EUI) ([]model.GatewayStatus, error) {
+	rows, err := d.getStatusStatement.Query(eui.String())
+	if err != nil {
+		return nil, fmt.Errorf("unable to query gateway status: %v", err)
+	}
+	defer rows.Close()
+
+	ret := make([]model.GatewayStatus, 0)
+	for rows.Next() {
+		var received, gatewayTime int64
+		status := model.GatewayStatus{}
+		if err := rows.Scan(&received, &gatewayTime, &status.RXReceived, &status.RXOK, &status.RXForwarded,
+			&status.ACKRatio, &status.DownlinkReceived, &status.TXEmitted, &status.HasLocation,
+			&status.Latitude, &status.Longitude, &status.Altitude); err != nil {
+			return nil, fmt.Errorf("unable to read gateway status: %v", err)
+		}
+		status.Received = time.Unix(0, received)
+		if gatewayTime != 0 {
+			status.GatewayTime = time.Unix(0, gatewayTime).UTC()
+		}
+		ret = append(ret, status)
+	}
+	return ret, nil
+}
+
+func (d *dbGatewayStorage) DeleteStatus(eui protocol.EUI, before time.Time) error {
+	err := d.doSQLExec(d.deleteStatusStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(eui.String(), before.UnixNano())
+	})
+	if err == storage.ErrNotFound {
+		// Nothing to remove
+		return nil
+	}
+	return err
+}
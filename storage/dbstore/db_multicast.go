@@ -0,0 +1,391 @@
+package dbstore
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+	"github.com/ExploratoryEngineering/logging"
+)
+
+type dbMulticastStorage struct {
+	dbStore
+	putStatement              *sql.Stmt
+	getStatement              *sql.Stmt
+	appListStatement          *sql.Stmt
+	updateStatement           *sql.Stmt
+	updateFCntStatement       *sql.Stmt
+	deleteStatement           *sql.Stmt
+	putDownstreamStatement    *sql.Stmt
+	getDownstreamStatement    *sql.Stmt
+	deleteDownstreamStatement *sql.Stmt
+	updateDownstreamStatement *sql.Stmt
+	addDeliveryStatement      *sql.Stmt
+	deliveryListStatement     *sql.Stmt
+}
+
+func (d *dbMulticastStorage) Close() {
+	d.putStatement.Close()
+	d.getStatement.Close()
+	d.appListStatement.Close()
+	d.updateStatement.Close()
+	d.updateFCntStatement.Close()
+	d.deleteStatement.Close()
+	d.putDownstreamStatement.Close()
+	d.getDownstreamStatement.Close()
+	d.deleteDownstreamStatement.Close()
+	d.updateDownstreamStatement.Close()
+	d.addDeliveryStatement.Close()
+	d.deliveryListStatement.Close()
+}
+
+// NewDBMulticastStorage creates a new MulticastStorage instance backed by a
+// database
+func NewDBMulticastStorage(db *sql.DB, userManagement storage.UserManagement) (storage.MulticastStorage, error) {
+	ret := dbMulticastStorage{dbStore: dbStore{db: db, userManagement: userManagement}}
+
+	const groupFields = `eui, application_eui, mc_addr, mc_nwks_key, mc_apps_key, fcnt_dn,
+		device_class, data_rate, frequency, periodicity, gateways, tags`
+
+	var err error
+	sqlInsert := `INSERT INTO lora_multicast_group (` + groupFields + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	if ret.putStatement, err = db.Prepare(sqlInsert); err != nil {
+		return nil, fmt.Errorf("unable to prepare insert statement: %v", err)
+	}
+
+	sqlSelect := `SELECT ` + groupFields + ` FROM lora_multicast_group WHERE eui = $1`
+	if ret.getStatement, err = db.Prepare(sqlSelect); err != nil {
+		return nil, fmt.Errorf("unable to prepare select statement: %v", err)
+	}
+
+	sqlAppList := `SELECT ` + groupFields + ` FROM lora_multicast_group WHERE application_eui = $1`
+	if ret.appListStatement, err = db.Prepare(sqlAppList); err != nil {
+		return nil, fmt.Errorf("unable to prepare application statement: %v", err)
+	}
+
+	sqlUpdate := `UPDATE lora_multicast_group SET
+			mc_addr = $1,
+			mc_nwks_key = $2,
+			mc_apps_key = $3,
+			device_class = $4,
+			data_rate = $5,
+			frequency = $6,
+			periodicity = $7,
+			gateways = $8,
+			tags = $9
+		WHERE eui = $10`
+	if ret.updateStatement, err = db.Prepare(sqlUpdate); err != nil {
+		return nil, fmt.Errorf("unable to prepare update statement: %v", err)
+	}
+
+	sqlUpdateFCnt := `UPDATE lora_multicast_group SET fcnt_dn = $1 WHERE eui = $2`
+	if ret.updateFCntStatement, err = db.Prepare(sqlUpdateFCnt); err != nil {
+		return nil, fmt.Errorf("unable to prepare frame counter update statement: %v", err)
+	}
+
+	sqlDelete := `DELETE FROM lora_multicast_group WHERE eui = $1`
+	if ret.deleteStatement, err = db.Prepare(sqlDelete); err != nil {
+		return nil, fmt.Errorf("unable to prepare delete statement: %v", err)
+	}
+
+	sqlPutDownstream := `INSERT INTO lora_multicast_message (group_eui, data, port, created_time, sent_time)
+		VALUES ($1, $2, $3, $4, $5)`
+	if ret.putDownstreamStatement, err = db.Prepare(sqlPutDownstream); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream insert statement: %v", err)
+	}
+
+	sqlGetDownstream := `SELECT data, port, created_time, sent_time FROM lora_multicast_message WHERE group_eui = $1`
+	if ret.getDownstreamStatement, err = db.Prepare(sqlGetDownstream); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream select statement: %v", err)
+	}
+
+	sqlDeleteDownstream := `DELETE FROM lora_multicast_message WHERE group_eui = $1`
+	if ret.deleteDownstreamStatement, err = db.Prepare(sqlDeleteDownstream); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream delete statement: %v", err)
+	}
+
+	sqlUpdateDownstream := `UPDATE lora_multicast_message SET sent_time = $1 WHERE group_eui = $2`
+	if ret.updateDownstreamStatement, err = db.Prepare(sqlUpdateDownstream); err != nil {
+		return nil, fmt.Errorf("unable to prepare downstream update statement: %v", err)
+	}
+
+	sqlAddDelivery := `INSERT INTO lora_multicast_delivery (group_eui, gateway_eui, fcnt, delivery_time, error)
+		VALUES ($1, $2, $3, $4, $5)`
+	if ret.addDeliveryStatement, err = db.Prepare(sqlAddDelivery); err != nil {
+		return nil, fmt.Errorf("unable to prepare delivery insert statement: %v", err)
+	}
+
+	sqlDeliveryList := `SELECT group_eui, gateway_eui, fcnt, delivery_time, error
+		FROM lora_multicast_delivery
+		WHERE group_eui = $1
+		ORDER BY delivery_time DESC
+		LIMIT $2`
+	if ret.deliveryListStatement, err = db.Prepare(sqlDeliveryList); err != nil {
+		return nil, fmt.Errorf("unable to prepare delivery list statement: %v", err)
+	}
+
+	return &ret, nil
+}
+
+// gatewayJSON returns the group's gateway list as a JSON buffer
+func gatewayJSON(group model.MulticastGroup) ([]byte, error) {
+	list := make([]string, len(group.Gateways))
+	for i, v := range group.Gateways {
+		list[i] = v.String()
+	}
+	return json.Marshal(list)
+}
+
+func (d *dbMulticastStorage) readGroup(rows *sql.Rows) (model.MulticastGroup, error) {
+	ret := model.NewMulticastGroup()
+	var groupEUIStr, appEUIStr, mcAddrStr, mcNwkSKeyStr, mcAppSKeyStr string
+	var fcntDn int64
+	var gatewayBuffer, tagBuffer []byte
+	if err := rows.Scan(
+		&groupEUIStr,
+		&appEUIStr,
+		&mcAddrStr,
+		&mcNwkSKeyStr,
+		&mcAppSKeyStr,
+		&fcntDn,
+		&ret.Class,
+		&ret.DataRate,
+		&ret.Frequency,
+		&ret.Periodicity,
+		&gatewayBuffer,
+		&tagBuffer); err != nil {
+		return ret, err
+	}
+	var err error
+	if ret.GroupEUI, err = protocol.EUIFromString(groupEUIStr); err != nil {
+		return ret, fmt.Errorf("invalid group EUI: %v (eui=%s)", err, groupEUIStr)
+	}
+	if ret.AppEUI, err = protocol.EUIFromString(appEUIStr); err != nil {
+		return ret, fmt.Errorf("invalid App EUI: %v (eui=%s)", err, appEUIStr)
+	}
+	if ret.McAddr, err = protocol.DevAddrFromString(mcAddrStr); err != nil {
+		return ret, fmt.Errorf("invalid McAddr for group with EUI %s (mcaddr=%s)", ret.GroupEUI, mcAddrStr)
+	}
+	if ret.McNwkSKey, err = protocol.AESKeyFromString(mcNwkSKeyStr); err != nil {
+		return ret, fmt.Errorf("invalid McNwkSKey: %v (key=%s)", err, mcNwkSKeyStr)
+	}
+	if ret.McAppSKey, err = protocol.AESKeyFromString(mcAppSKeyStr); err != nil {
+		return ret, fmt.Errorf("invalid McAppSKey: %v (key=%s)", err, mcAppSKeyStr)
+	}
+	ret.FCntDn = uint32(fcntDn)
+
+	if len(gatewayBuffer) > 0 {
+		var list []string
+		if err := json.Unmarshal(gatewayBuffer, &list); err != nil {
+			return ret, fmt.Errorf("invalid gateway list: %v (eui=%s)", err, groupEUIStr)
+		}
+		for _, v := range list {
+			eui, err := protocol.EUIFromString(v)
+			if err != nil {
+				return ret, fmt.Errorf("invalid gateway EUI: %v (eui=%s)", err, v)
+			}
+			ret.Gateways = append(ret.Gateways, eui)
+		}
+	}
+
+	tags, err := model.NewTagsFromBuffer(tagBuffer)
+	if err != nil {
+		return ret, fmt.Errorf("invalid tag buffer: %v (eui=%s)", err, groupEUIStr)
+	}
+	ret.Tags = *tags
+	return ret, nil
+}
+
+func (d *dbMulticastStorage) Put(group model.MulticastGroup) error {
+	return d.doSQLExec(d.putStatement, func(s *sql.Stmt) (sql.Result, error) {
+		gateways, err := gatewayJSON(group)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(
+			group.GroupEUI.String(),
+			group.AppEUI.String(),
+			group.McAddr.String(),
+			group.McNwkSKey.String(),
+			group.McAppSKey.String(),
+			int64(group.FCntDn),
+			uint8(group.Class),
+			group.DataRate,
+			group.Frequency,
+			group.Periodicity,
+			gateways,
+			group.Tags.TagJSON())
+	})
+}
+
+func (d *dbMulticastStorage) GetByEUI(groupEUI protocol.EUI) (model.MulticastGroup, error) {
+	rows, err := d.getStatement.Query(groupEUI.String())
+	if err != nil {
+		return model.MulticastGroup{}, fmt.Errorf("unable to query for multicast group: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return model.MulticastGroup{}, storage.ErrNotFound
+	}
+	return d.readGroup(rows)
+}
+
+func (d *dbMulticastStorage) GetByApplicationEUI(appEUI protocol.EUI) (<-chan model.MulticastGroup, error) {
+	rows, err := d.appListStatement.Query(appEUI.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(chan model.MulticastGroup)
+	go func() {
+		defer rows.Close()
+		defer close(ret)
+		for rows.Next() {
+			group, err := d.readGroup(rows)
+			if err != nil {
+				logging.Warning("Unable to read multicast group from storage: %v", err)
+				continue
+			}
+			select {
+			case ret <- group:
+			case <-time.After(1 * time.Second):
+				continue
+			}
+		}
+	}()
+	return ret, nil
+}
+
+func (d *dbMulticastStorage) Update(group model.MulticastGroup) error {
+	return d.doSQLExec(d.updateStatement, func(s *sql.Stmt) (sql.Result, error) {
+		gateways, err := gatewayJSON(group)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(
+			group.McAddr.String(),
+			group.McNwkSKey.String(),
+			group.McAppSKey.String(),
+			uint8(group.Class),
+			group.DataRate,
+			group.Frequency,
+			group.Periodicity,
+			gateways,
+			group.Tags.TagJSON(),
+			group.GroupEUI.String())
+	})
+}
+
+func (d *dbMulticastStorage) UpdateFCnt(groupEUI protocol.EUI, fcntDn uint32) error {
+	return d.doSQLExec(d.updateFCntStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(int64(fcntDn), groupEUI.String())
+	})
+}
+
+func (d *dbMulticastStorage) Delete(groupEUI protocol.EUI) error {
+	return d.doSQLExec(d.deleteStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(groupEUI.String())
+	})
+}
+
+func (d *dbMulticastStorage) PutDownstream(groupEUI protocol.EUI, message model.MulticastMessage) error {
+	return d.doSQLExec(d.putDownstreamStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			groupEUI.String(),
+			message.Data,
+			message.Port,
+			message.CreatedTime,
+			message.SentTime)
+	})
+}
+
+func (d *dbMulticastStorage) GetDownstream(groupEUI protocol.EUI) (model.MulticastMessage, error) {
+	ret := model.NewMulticastMessage(groupEUI, 0)
+
+	rows, err := d.getDownstreamStatement.Query(groupEUI.String())
+	if err != nil {
+		return ret, fmt.Errorf("unable to query for multicast message: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return ret, storage.ErrNotFound
+	}
+	if err := rows.Scan(&ret.Data, &ret.Port, &ret.CreatedTime, &ret.SentTime); err != nil {
+		return ret, fmt.Errorf("unable to read fields from multicast message result: %v", err)
+	}
+	return ret, nil
+}
+
+func (d *dbMulticastStorage) DeleteDownstream(groupEUI protocol.EUI) error {
+	return d.doSQLExec(d.deleteDownstreamStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(groupEUI.String())
+	})
+}
+
+func (d *dbMulticastStorage) UpdateDownstream(groupEUI protocol.EUI, sentTime int64) error {
+	return d.doSQLExec(d.updateDownstreamStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(sentTime, groupEUI.String())
+	})
+}
+
+func (d *dbMulticastStorage) AddDelivery(delivery model.MulticastDelivery) error {
+	return d.doSQLExec(d.addDeliveryStatement, func(s *sql.Stmt) (sql.Result, error) {
+		return s.Exec(
+			delivery.GroupEUI.String(),
+			delivery.GatewayEUI.String(),
+			int64(delivery.FCnt),
+			delivery.Time,
+			delivery.Error)
+	})
+}
+
+func (d *dbMulticastStorage) GetDeliveries(groupEUI protocol.EUI, limit int) (<-chan model.MulticastDelivery, error) {
+	rows, err := d.deliveryListStatement.Query(groupEUI.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(chan model.MulticastDelivery)
+	go func() {
+		defer rows.Close()
+		defer close(ret)
+		for rows.Next() {
+			var groupEUIStr, gatewayEUIStr string
+			var fcnt int64
+			delivery := model.MulticastDelivery{}
+			if err := rows.Scan(&groupEUIStr, &gatewayEUIStr, &fcnt, &delivery.Time, &delivery.Error); err != nil {
+				logging.Warning("Unable to read multicast delivery from storage: %v", err)
+				continue
+			}
+			delivery.GroupEUI, _ = protocol.EUIFromString(groupEUIStr)
+			delivery.GatewayEUI, _ = protocol.EUIFromString(gatewayEUIStr)
+			delivery.FCnt = uint32(fcnt)
+			select {
+			case ret <- delivery:
+			case <-time.After(1 * time.Second):
+				continue
+			}
+		}
+	}()
+	return ret, nil
+}
@@ -77,6 +77,13 @@ func createSchemaAndDB() (*postgresEmbedded, *sql.DB) {
 		os.Exit(1)
 	}
 	CreateSchema(db)
+	// The migration should be a no-op on a new schema
+	if err := MigrateSchema(db); err != nil {
+		pgdb.Stop()
+		db.Close()
+		logging.Error("Couldn't migrate the schema: %v", err)
+		os.Exit(1)
+	}
 	return pgdb, db
 }
 
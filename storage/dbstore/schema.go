@@ -108,9 +108,13 @@ CREATE TABLE lora_org_member (
 -- change rarely.
 -- **************************************************************************
 CREATE TABLE lora_application (
-    eui         CHAR(23)     NOT NULL,
-    owner_id    BIGINT       NOT NULL REFERENCES lora_owner (owner_id),
-    tags        JSONB        NULL,
+    eui                    CHAR(23)     NOT NULL,
+    owner_id               BIGINT       NOT NULL REFERENCES lora_owner (owner_id),
+    tags                   JSONB        NULL,
+    device_status_interval INTEGER      NOT NULL DEFAULT 0, -- seconds between DevStatusReq commands
+    channels               JSONB        NULL,                -- channels added to the band's mandatory channels
+    end_to_end             BOOLEAN      NOT NULL DEFAULT false, -- payloads are encrypted end-to-end
+    kek_label              VARCHAR(64)  NOT NULL DEFAULT '',    -- label for the key encryption key
 
     CONSTRAINT lora_application_pk PRIMARY KEY (eui)
 );
@@ -128,11 +132,41 @@ CREATE TABLE lora_device (
     nwks_key        CHAR(32)  NOT NULL,
     application_eui CHAR(23)  NOT NULL REFERENCES lora_application(eui),
     state           SMALLINT  NOT NULL,
-    fcnt_up         INTEGER   NOT NULL DEFAULT 0,
-    fcnt_dn         INTEGER   NOT NULL DEFAULT 0,
+    fcnt_up         BIGINT    NOT NULL DEFAULT 0, -- 32-bit unsigned frame counter
+    fcnt_dn         BIGINT    NOT NULL DEFAULT 0, -- 32-bit unsigned frame counter
     relaxed_counter BOOLEAN   NOT NULL DEFAULT false,
     key_warning     BOOLEAN   NOT NULL DEFAULT false,
     tags            JSONB     NULL,
+    data_rate       SMALLINT  NOT NULL DEFAULT 0,
+    tx_power        SMALLINT  NOT NULL DEFAULT 0,
+    nb_trans        SMALLINT  NOT NULL DEFAULT 1,
+    battery_level   SMALLINT  NOT NULL DEFAULT 0,
+    device_margin   SMALLINT  NOT NULL DEFAULT 0,
+    status_time     BIGINT    NOT NULL DEFAULT 0,
+    rx1_delay       SMALLINT  NOT NULL DEFAULT 1,
+    rx1_dr_offset   SMALLINT  NOT NULL DEFAULT 0,
+    rx2_data_rate   SMALLINT  NOT NULL DEFAULT 0,
+    rx2_frequency   REAL      NOT NULL DEFAULT 0,
+    req_rx1_delay   SMALLINT  NOT NULL DEFAULT 1,
+    req_rx1_offset  SMALLINT  NOT NULL DEFAULT 0,
+    req_rx2_dr      SMALLINT  NOT NULL DEFAULT 0,
+    req_rx2_freq    REAL      NOT NULL DEFAULT 0,
+    channels        JSONB     NULL,
+    mac_version     SMALLINT  NOT NULL DEFAULT 0, -- minor LoRaWAN version, ie 0 for 1.0.x and 1 for 1.1
+    nwk_key         CHAR(32)  NOT NULL DEFAULT '00000000000000000000000000000000',
+    snwksint_key    CHAR(32)  NOT NULL DEFAULT '00000000000000000000000000000000',
+    nwksenc_key     CHAR(32)  NOT NULL DEFAULT '00000000000000000000000000000000',
+    join_nonce      INTEGER   NOT NULL DEFAULT 0,
+    rj_count0       INTEGER   NOT NULL DEFAULT 0,
+    rj_count1       INTEGER   NOT NULL DEFAULT 0,
+    device_class    SMALLINT  NOT NULL DEFAULT 0, -- 0 for class A, 1 for class B, 2 for class C
+    ping_periodicity SMALLINT NOT NULL DEFAULT 0,
+    ping_dr         SMALLINT  NOT NULL DEFAULT 0,
+    ping_freq       REAL      NOT NULL DEFAULT 0,
+    req_ping_dr     SMALLINT  NOT NULL DEFAULT 0,
+    req_ping_freq   REAL      NOT NULL DEFAULT 0,
+    pending_session JSONB     NULL, -- session sent in a JoinAccept for a Rejoin-request
+    gen_app_key     CHAR(32)  NOT NULL DEFAULT '00000000000000000000000000000000', -- root key for the multicast keys of LoRaWAN 1.0 devices
 
     CONSTRAINT lora_device_pk PRIMARY KEY (eui)
 );
@@ -192,18 +226,44 @@ CREATE INDEX lora_sequence_identifier ON lora_sequence(identifier);
 -- Gateways. The gateways are fairly self explanatory.
 -- **************************************************************************
 CREATE TABLE lora_gateway (
-    gateway_eui CHAR(23)      NOT NULL,
-    latitude    NUMERIC(12,8) NULL,
-    longitude   NUMERIC(12,8) NULL,
-    altitude    NUMERIC(8,3)  NULL,
-    ip          VARCHAR(64)   NOT NULL,
-    strict_ip   BOOL          NOT NULL,
-    owner_id    BIGINT        NOT NULL REFERENCES lora_owner (owner_id),
-    tags        JSONB         NULL,
+    gateway_eui   CHAR(23)      NOT NULL,
+    latitude      NUMERIC(12,8) NULL,
+    longitude     NUMERIC(12,8) NULL,
+    altitude      NUMERIC(8,3)  NULL,
+    ip            VARCHAR(64)   NOT NULL,
+    strict_ip     BOOL          NOT NULL,
+    owner_id      BIGINT        NOT NULL REFERENCES lora_owner (owner_id),
+    tags          JSONB         NULL,
+    band          SMALLINT      NOT NULL DEFAULT 0,
+    sub_band      SMALLINT      NOT NULL DEFAULT 0,
+    station_token VARCHAR(128)  NOT NULL DEFAULT '',
 
     CONSTRAINT lora_gateway_pk PRIMARY KEY (gateway_eui)
 );
 
+-- **************************************************************************
+-- Gateway status reports. Times are in nanoseconds since epoch. The id
+-- keeps the order of reports received at the same time.
+-- **************************************************************************
+CREATE TABLE lora_gateway_status (
+    id                BIGSERIAL     NOT NULL,
+    gateway_eui       CHAR(23)      NOT NULL REFERENCES lora_gateway(gateway_eui) ON DELETE CASCADE,
+    received_time     BIGINT        NOT NULL,
+    gateway_time      BIGINT        NOT NULL DEFAULT 0,
+    rx_received       BIGINT        NOT NULL DEFAULT 0,
+    rx_ok             BIGINT        NOT NULL DEFAULT 0,
+    rx_forwarded      BIGINT        NOT NULL DEFAULT 0,
+    ack_ratio         REAL          NOT NULL DEFAULT 0,
+    downlink_received BIGINT        NOT NULL DEFAULT 0,
+    tx_emitted        BIGINT        NOT NULL DEFAULT 0,
+    has_location      BOOL          NOT NULL DEFAULT false,
+    latitude          NUMERIC(12,8) NOT NULL DEFAULT 0,
+    longitude         NUMERIC(12,8) NOT NULL DEFAULT 0,
+    altitude          NUMERIC(8,3)  NOT NULL DEFAULT 0
+);
+
+CREATE INDEX lora_gateway_status_eui ON lora_gateway_status(gateway_eui, received_time);
+
 -- Set up initial system user.
 INSERT INTO lora_user (user_id, name, email) VALUES ('system', 'System user', 'ee@telenordigital.com');
 INSERT INTO lora_owner (owner_id, user_id, org_id) VALUES (0, 'system', null);
@@ -226,20 +286,299 @@ CREATE INDEX lora_output_app_eui ON lora_output(application_eui);
 -- **************************************************************************
 CREATE TABLE lora_downstream_message (
     device_eui   CHAR(23) NOT NULL REFERENCES lora_device(eui) ON DELETE CASCADE,
+    id           BIGINT NOT NULL DEFAULT 0,
+    priority     SMALLINT NOT NULL DEFAULT 0,
     data         VARCHAR(256) NOT NULL,
     port         INTEGER NOT NULL,
     ack          BOOLEAN NOT NULL DEFAULT false,
     created_time INTEGER NOT NULL,
     sent_time    INTEGER DEFAULT 0,
     ack_time     INTEGER DEFAULT 0,
+    tx_error     VARCHAR(32) NOT NULL DEFAULT '',
+    attempts     SMALLINT NOT NULL DEFAULT 0,
+    expires_time INTEGER NOT NULL DEFAULT 0,
+    failed_time  INTEGER NOT NULL DEFAULT 0,
+    fcnt         BIGINT NOT NULL DEFAULT 0, -- frame counter for payloads encrypted end-to-end
+    rx_window    SMALLINT NOT NULL DEFAULT 0, -- receive window (1 or 2) the message was sent in
+
+    CONSTRAINT lora_downstream_message_pk PRIMARY KEY (device_eui, id)
+);
+
+-- **************************************************************************
+-- Multicast groups
+-- **************************************************************************
+CREATE TABLE lora_multicast_group (
+    eui             CHAR(23)  NOT NULL,
+    application_eui CHAR(23)  NOT NULL REFERENCES lora_application(eui) ON DELETE CASCADE,
+    mc_addr         CHAR(8)   NOT NULL,
+    mc_nwks_key     CHAR(32)  NOT NULL,
+    mc_apps_key     CHAR(32)  NOT NULL,
+    fcnt_dn         BIGINT    NOT NULL DEFAULT 0, -- 32-bit unsigned frame counter
+    device_class    SMALLINT  NOT NULL DEFAULT 2,
+    data_rate       SMALLINT  NOT NULL DEFAULT 0,
+    frequency       REAL      NOT NULL DEFAULT 0,
+    periodicity     SMALLINT  NOT NULL DEFAULT 0,
+    gateways        JSONB     NULL,
+    tags            JSONB     NULL,
+
+    CONSTRAINT lora_multicast_group_pk PRIMARY KEY (eui)
+);
+
+CREATE INDEX lora_multicast_group_app_eui ON lora_multicast_group(application_eui);
+
+CREATE TABLE lora_multicast_message (
+    group_eui    CHAR(23) NOT NULL REFERENCES lora_multicast_group(eui) ON DELETE CASCADE,
+    data         VARCHAR(256) NOT NULL,
+    port         INTEGER NOT NULL,
+    created_time INTEGER NOT NULL,
+    sent_time    INTEGER DEFAULT 0,
+
+    CONSTRAINT lora_multicast_message_pk PRIMARY KEY (group_eui)
+);
+
+CREATE TABLE lora_multicast_delivery (
+    group_eui     CHAR(23) NOT NULL REFERENCES lora_multicast_group(eui) ON DELETE CASCADE,
+    gateway_eui   CHAR(23) NOT NULL,
+    fcnt          BIGINT NOT NULL,
+    delivery_time BIGINT NOT NULL,
+    error         VARCHAR(256) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX lora_multicast_delivery_group_eui ON lora_multicast_delivery(group_eui, delivery_time);
+
+-- **************************************************************************
+-- Firmware update campaigns
+-- **************************************************************************
+CREATE TABLE lora_fuota_campaign (
+    eui             CHAR(23) NOT NULL,
+    application_eui CHAR(23) NOT NULL REFERENCES lora_application(eui) ON DELETE CASCADE,
+    group_eui       CHAR(23) NOT NULL,
+    mc_key          CHAR(32) NOT NULL,
+    group_id        SMALLINT NOT NULL,
+    frag_index      SMALLINT NOT NULL,
+    firmware        BYTEA    NOT NULL,
+    frag_size       SMALLINT NOT NULL,
+    redundancy      INTEGER  NOT NULL,
+    descriptor      BIGINT   NOT NULL,
+    frag_interval   INTEGER  NOT NULL,
+    session_time    BIGINT   NOT NULL,
+    session_timeout SMALLINT NOT NULL,
+    state           SMALLINT NOT NULL DEFAULT 0,
+    fragments_sent  INTEGER  NOT NULL DEFAULT 0,
+    created_time    BIGINT   NOT NULL,
+
+    CONSTRAINT lora_fuota_campaign_pk PRIMARY KEY (eui)
+);
+
+CREATE INDEX lora_fuota_campaign_app_eui ON lora_fuota_campaign(application_eui);
+
+CREATE TABLE lora_fuota_device (
+    campaign_eui     CHAR(23) NOT NULL REFERENCES lora_fuota_campaign(eui) ON DELETE CASCADE,
+    device_eui       CHAR(23) NOT NULL,
+    state            SMALLINT NOT NULL DEFAULT 0,
+    nb_frag_received INTEGER  NOT NULL DEFAULT 0,
+    missing_frag     SMALLINT NOT NULL DEFAULT 0,
+    error            VARCHAR(256) NOT NULL DEFAULT '',
+    updated_time     BIGINT NOT NULL DEFAULT 0,
+
+    CONSTRAINT lora_fuota_device_pk PRIMARY KEY (campaign_eui, device_eui)
+);
+
+`
+
+// DBMigration contains the commands to upgrade an existing database to the
+// current schema. The commands can be applied more than once. The migration
+// runs on every start so changes that rewrite a table or an index check the
+// current schema first.
+const DBMigration = `
+-- **************************************************************************
+-- Frame counters are 32 bits. INTEGER is signed so these are stored as BIGINT
+-- **************************************************************************
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = 'lora_device'
+            AND column_name IN ('fcnt_up', 'fcnt_dn') AND data_type <> 'bigint') THEN
+        ALTER TABLE lora_device ALTER COLUMN fcnt_up TYPE BIGINT, ALTER COLUMN fcnt_dn TYPE BIGINT;
+    END IF;
+END
+$$;
+
+-- **************************************************************************
+-- Columns added to the application and device tables
+-- **************************************************************************
+ALTER TABLE lora_application ADD COLUMN IF NOT EXISTS device_status_interval INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE lora_application ADD COLUMN IF NOT EXISTS channels JSONB NULL;
+ALTER TABLE lora_application ADD COLUMN IF NOT EXISTS end_to_end BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE lora_application ADD COLUMN IF NOT EXISTS kek_label VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE lora_application DROP COLUMN IF EXISTS kek;
+
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS data_rate SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS tx_power SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS nb_trans SMALLINT NOT NULL DEFAULT 1;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS battery_level SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS device_margin SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS status_time BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS rx1_delay SMALLINT NOT NULL DEFAULT 1;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS rx1_dr_offset SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS rx2_data_rate SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS rx2_frequency REAL NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS req_rx1_delay SMALLINT NOT NULL DEFAULT 1;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS req_rx1_offset SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS req_rx2_dr SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS req_rx2_freq REAL NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS channels JSONB NULL;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS mac_version SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS nwk_key CHAR(32) NOT NULL DEFAULT '00000000000000000000000000000000';
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS snwksint_key CHAR(32) NOT NULL DEFAULT '00000000000000000000000000000000';
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS nwksenc_key CHAR(32) NOT NULL DEFAULT '00000000000000000000000000000000';
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS join_nonce INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS rj_count0 INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS rj_count1 INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS device_class SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS ping_periodicity SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS ping_dr SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS ping_freq REAL NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS req_ping_dr SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS req_ping_freq REAL NOT NULL DEFAULT 0;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS pending_session JSONB NULL;
+ALTER TABLE lora_device ADD COLUMN IF NOT EXISTS gen_app_key CHAR(32) NOT NULL DEFAULT '00000000000000000000000000000000';
+
+ALTER TABLE lora_gateway ADD COLUMN IF NOT EXISTS band SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_gateway ADD COLUMN IF NOT EXISTS sub_band SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_gateway ADD COLUMN IF NOT EXISTS station_token VARCHAR(128) NOT NULL DEFAULT '';
+
+ALTER TABLE lora_downstream_message ADD COLUMN IF NOT EXISTS tx_error VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE lora_downstream_message ADD COLUMN IF NOT EXISTS attempts SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_downstream_message ADD COLUMN IF NOT EXISTS expires_time INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE lora_downstream_message ADD COLUMN IF NOT EXISTS failed_time INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE lora_downstream_message ADD COLUMN IF NOT EXISTS id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_downstream_message ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_downstream_message ADD COLUMN IF NOT EXISTS fcnt BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE lora_downstream_message ADD COLUMN IF NOT EXISTS rx_window SMALLINT NOT NULL DEFAULT 0;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint
+            WHERE conname = 'lora_downstream_message_pk' AND conrelid = 'lora_downstream_message'::regclass
+            AND array_length(conkey, 1) = 2) THEN
+        ALTER TABLE lora_downstream_message DROP CONSTRAINT IF EXISTS lora_downstream_message_pk;
+        ALTER TABLE lora_downstream_message ADD CONSTRAINT lora_downstream_message_pk PRIMARY KEY (device_eui, id);
+    END IF;
+END
+$$;
 
-    CONSTRAINT lora_downstream_message_pk PRIMARY KEY (device_eui)
+-- **************************************************************************
+-- Multicast groups
+-- **************************************************************************
+CREATE TABLE IF NOT EXISTS lora_multicast_group (
+    eui             CHAR(23)  NOT NULL,
+    application_eui CHAR(23)  NOT NULL REFERENCES lora_application(eui) ON DELETE CASCADE,
+    mc_addr         CHAR(8)   NOT NULL,
+    mc_nwks_key     CHAR(32)  NOT NULL,
+    mc_apps_key     CHAR(32)  NOT NULL,
+    fcnt_dn         BIGINT    NOT NULL DEFAULT 0, -- 32-bit unsigned frame counter
+    device_class    SMALLINT  NOT NULL DEFAULT 2,
+    data_rate       SMALLINT  NOT NULL DEFAULT 0,
+    frequency       REAL      NOT NULL DEFAULT 0,
+    periodicity     SMALLINT  NOT NULL DEFAULT 0,
+    gateways        JSONB     NULL,
+    tags            JSONB     NULL,
+
+    CONSTRAINT lora_multicast_group_pk PRIMARY KEY (eui)
+);
+
+CREATE INDEX IF NOT EXISTS lora_multicast_group_app_eui ON lora_multicast_group(application_eui);
+
+CREATE TABLE IF NOT EXISTS lora_multicast_message (
+    group_eui    CHAR(23) NOT NULL REFERENCES lora_multicast_group(eui) ON DELETE CASCADE,
+    data         VARCHAR(256) NOT NULL,
+    port         INTEGER NOT NULL,
+    created_time INTEGER NOT NULL,
+    sent_time    INTEGER DEFAULT 0,
+
+    CONSTRAINT lora_multicast_message_pk PRIMARY KEY (group_eui)
+);
+
+CREATE TABLE IF NOT EXISTS lora_multicast_delivery (
+    group_eui     CHAR(23) NOT NULL REFERENCES lora_multicast_group(eui) ON DELETE CASCADE,
+    gateway_eui   CHAR(23) NOT NULL,
+    fcnt          BIGINT NOT NULL,
+    delivery_time BIGINT NOT NULL,
+    error         VARCHAR(256) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS lora_multicast_delivery_group_eui ON lora_multicast_delivery(group_eui, delivery_time);
+
+-- **************************************************************************
+-- Firmware update campaigns
+-- **************************************************************************
+CREATE TABLE IF NOT EXISTS lora_fuota_campaign (
+    eui             CHAR(23) NOT NULL,
+    application_eui CHAR(23) NOT NULL REFERENCES lora_application(eui) ON DELETE CASCADE,
+    group_eui       CHAR(23) NOT NULL,
+    mc_key          CHAR(32) NOT NULL,
+    group_id        SMALLINT NOT NULL,
+    frag_index      SMALLINT NOT NULL,
+    firmware        BYTEA    NOT NULL,
+    frag_size       SMALLINT NOT NULL,
+    redundancy      INTEGER  NOT NULL,
+    descriptor      BIGINT   NOT NULL,
+    frag_interval   INTEGER  NOT NULL,
+    session_time    BIGINT   NOT NULL,
+    session_timeout SMALLINT NOT NULL,
+    state           SMALLINT NOT NULL DEFAULT 0,
+    fragments_sent  INTEGER  NOT NULL DEFAULT 0,
+    created_time    BIGINT   NOT NULL,
+
+    CONSTRAINT lora_fuota_campaign_pk PRIMARY KEY (eui)
 );
 
+CREATE INDEX IF NOT EXISTS lora_fuota_campaign_app_eui ON lora_fuota_campaign(application_eui);
+
+CREATE TABLE IF NOT EXISTS lora_fuota_device (
+    campaign_eui     CHAR(23) NOT NULL REFERENCES lora_fuota_campaign(eui) ON DELETE CASCADE,
+    device_eui       CHAR(23) NOT NULL,
+    state            SMALLINT NOT NULL DEFAULT 0,
+    nb_frag_received INTEGER  NOT NULL DEFAULT 0,
+    missing_frag     SMALLINT NOT NULL DEFAULT 0,
+    error            VARCHAR(256) NOT NULL DEFAULT '',
+    updated_time     BIGINT NOT NULL DEFAULT 0,
+
+    CONSTRAINT lora_fuota_device_pk PRIMARY KEY (campaign_eui, device_eui)
+);
+
+-- **************************************************************************
+-- Gateway status reports. Times are in nanoseconds since epoch. The id
+-- keeps the order of reports received at the same time.
+-- **************************************************************************
+CREATE TABLE IF NOT EXISTS lora_gateway_status (
+    id                BIGSERIAL     NOT NULL,
+    gateway_eui       CHAR(23)      NOT NULL REFERENCES lora_gateway(gateway_eui) ON DELETE CASCADE,
+    received_time     BIGINT        NOT NULL,
+    gateway_time      BIGINT        NOT NULL DEFAULT 0,
+    rx_received       BIGINT        NOT NULL DEFAULT 0,
+    rx_ok             BIGINT        NOT NULL DEFAULT 0,
+    rx_forwarded      BIGINT        NOT NULL DEFAULT 0,
+    ack_ratio         REAL          NOT NULL DEFAULT 0,
+    downlink_received BIGINT        NOT NULL DEFAULT 0,
+    tx_emitted        BIGINT        NOT NULL DEFAULT 0,
+    has_location      BOOL          NOT NULL DEFAULT false,
+    latitude          NUMERIC(12,8) NOT NULL DEFAULT 0,
+    longitude         NUMERIC(12,8) NOT NULL DEFAULT 0,
+    altitude          NUMERIC(8,3)  NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS lora_gateway_status_eui ON lora_gateway_status(gateway_eui, received_time);
+
 `
 
 // Commands to purge the database
 const purgeCommands string = `
+DROP TABLE lora_fuota_device;
+DROP TABLE lora_fuota_campaign;
+DROP TABLE lora_multicast_delivery;
+DROP TABLE lora_multicast_message;
+DROP TABLE lora_multicast_group;
 DROP TABLE lora_downstream_message;
 DROP TABLE lora_device_data;
 DROP TABLE lora_device_nonce;
@@ -276,16 +615,34 @@ func removeComments(schema string) string {
 	return ret
 }
 
-// SchemaCommandList returns a list of the DDL commands to create a schema.
-func SchemaCommandList() []string {
+// commandList splits a set of DDL commands into a list
+func commandList(schema string) []string {
 	var ret []string
 
-	commands := strings.Split(removeComments(DBSchema), ";")
-	for _, v := range commands {
-		if len(strings.TrimSpace(v)) > 0 {
-
-			ret = append(ret, strings.TrimSpace(v))
+	command := ""
+	for _, v := range strings.Split(removeComments(schema), ";") {
+		command += v
+		// Semicolons inside dollar-quoted blocks (ie DO $$ ... $$) don't end
+		// the command
+		if strings.Count(command, "$$")%2 == 1 {
+			command += ";"
+			continue
 		}
+		if len(strings.TrimSpace(command)) > 0 {
+			ret = append(ret, strings.TrimSpace(command))
+		}
+		command = ""
 	}
 	return ret
 }
+
+// SchemaCommandList returns a list of the DDL commands to create a schema.
+func SchemaCommandList() []string {
+	return commandList(DBSchema)
+}
+
+// MigrationCommandList returns a list of the DDL commands to upgrade an
+// existing schema.
+func MigrationCommandList() []string {
+	return commandList(DBMigration)
+}
@@ -32,6 +32,8 @@ func CreateMemoryStorage(minLatencyMs, maxLatencyMs int) storage.Storage {
 		Token:          NewMemoryTokenStorage(),
 		UserManagement: NewMemoryUserManagement(),
 		AppOutput:      NewMemoryOutput(),
+		Multicast:      NewMemoryMulticastStorage(),
+		FUOTA:          NewMemoryFUOTAStorage(),
 	}
 
 }
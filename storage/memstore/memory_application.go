@@ -122,6 +122,10 @@ func (m *memoryApplicationStorage) Update(application model.Application, userID
 		return storage.ErrNotFound
 	}
 	app.app.Tags = application.Tags
+	app.app.DeviceStatusInterval = application.DeviceStatusInterval
+	app.app.Channels = append(model.ChannelList(nil), application.Channels...)
+	app.app.EndToEnd = application.EndToEnd
+	app.app.KEKLabel = application.KEKLabel
 	m.applications[application.AppEUI] = app
 	return nil
 }
@@ -165,10 +165,35 @@ func (m *memoryDeviceStorage) Update(device model.Device) error {
 
 	existingDevice.AppSKey = device.AppSKey
 	existingDevice.NwkSKey = device.NwkSKey
+	existingDevice.NwkKey = device.NwkKey
+	existingDevice.GenAppKey = device.GenAppKey
+	existingDevice.SNwkSIntKey = device.SNwkSIntKey
+	existingDevice.NwkSEncKey = device.NwkSEncKey
+	existingDevice.MACVersion = device.MACVersion
+	existingDevice.JoinNonce = device.JoinNonce
+	existingDevice.RJCount0 = device.RJCount0
+	existingDevice.RJCount1 = device.RJCount1
+	existingDevice.Class = device.Class
+	existingDevice.PingSlot = device.PingSlot
+	existingDevice.RequestedPing = device.RequestedPing
+	existingDevice.PendingSession = nil
+	if device.PendingSession != nil {
+		pending := *device.PendingSession
+		existingDevice.PendingSession = &pending
+	}
 	existingDevice.DevAddr = device.DevAddr
 	existingDevice.FCntDn = device.FCntDn
 	existingDevice.FCntUp = device.FCntUp
 	existingDevice.RelaxedCounter = device.RelaxedCounter
+	existingDevice.DataRate = device.DataRate
+	existingDevice.TXPower = device.TXPower
+	existingDevice.NbTrans = device.NbTrans
+	existingDevice.BatteryLevel = device.BatteryLevel
+	existingDevice.DeviceMargin = device.DeviceMargin
+	existingDevice.StatusTime = device.StatusTime
+	existingDevice.RXSettings = device.RXSettings
+	existingDevice.RequestedRX = device.RequestedRX
+	existingDevice.Channels = append(model.ChannelList(nil), device.Channels...)
 	existingDevice.Tags = device.Tags
 	m.devices[existingDevice.DeviceEUI] = existingDevice
 	return nil
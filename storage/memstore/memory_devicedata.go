@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
@@ -27,7 +28,7 @@ import (
 
 type memoryDataList map[int64]model.DeviceData
 type memoryDeviceData map[protocol.EUI]memoryDataList
-type downstreamMessages map[protocol.EUI]model.DownstreamMessage
+type downstreamMessages map[protocol.EUI][]model.DownstreamMessage
 
 // memoryDataStorage implements a backend storage
 type memoryDataStorage struct {
@@ -155,47 +156,128 @@ func (m *memoryDataStorage) PutDownstream(deviceEUI protocol.EUI, message model.
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	_, exists := m.downstream[deviceEUI]
-	if exists {
+	if m.findDownstream(deviceEUI, message.ID) >= 0 {
 		return storage.ErrAlreadyExists
 	}
-	m.downstream[deviceEUI] = message
+	m.downstream[deviceEUI] = append(m.downstream[deviceEUI], message)
 	return nil
 }
 
+// findDownstream returns the index of the message in the device's queue or
+// -1 if it doesn't exist. The mutex must be locked when calling this.
+func (m *memoryDataStorage) findDownstream(deviceEUI protocol.EUI, id uint64) int {
+	for i, v := range m.downstream[deviceEUI] {
+		if v.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
 func (m *memoryDataStorage) DeleteDownstream(deviceEUI protocol.EUI) error {
 	m.RandomDelay()
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	_, exists := m.downstream[deviceEUI]
-	if !exists {
+	if len(m.downstream[deviceEUI]) == 0 {
 		return storage.ErrNotFound
 	}
 	delete(m.downstream, deviceEUI)
 	return nil
 }
 
-func (m *memoryDataStorage) GetDownstream(deviceEUI protocol.EUI) (model.DownstreamMessage, error) {
+func (m *memoryDataStorage) DeleteDownstreamMessage(deviceEUI protocol.EUI, id uint64) error {
 	m.RandomDelay()
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	existing, exists := m.downstream[deviceEUI]
-	if !exists {
-		return existing, storage.ErrNotFound
+	i := m.findDownstream(deviceEUI, id)
+	if i < 0 {
+		return storage.ErrNotFound
 	}
-	return existing, nil
+	queue := m.downstream[deviceEUI]
+	m.downstream[deviceEUI] = append(queue[:i:i], queue[i+1:]...)
+	return nil
 }
 
-func (m *memoryDataStorage) UpdateDownstream(deviceEUI protocol.EUI, sentTime int64, ackTime int64) error {
+func (m *memoryDataStorage) GetDownstream(deviceEUI protocol.EUI) (model.DownstreamMessage, error) {
+	queue, _ := m.ListDownstream(deviceEUI)
+	if len(queue) == 0 {
+		return model.DownstreamMessage{}, storage.ErrNotFound
+	}
+	return queue[0], nil
+}
+
+func (m *memoryDataStorage) ListDownstream(deviceEUI protocol.EUI) ([]model.DownstreamMessage, error) {
 	m.RandomDelay()
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	existing, exists := m.downstream[deviceEUI]
-	if !exists {
+	ret := make([]model.DownstreamMessage, len(m.downstream[deviceEUI]))
+	copy(ret, m.downstream[deviceEUI])
+	model.SortDownstreamQueue(ret)
+	return ret, nil
+}
+
+// updateDownstream applies the update function to a message in the device's
+// queue. ErrNotFound is returned if the message doesn't exist.
+func (m *memoryDataStorage) updateDownstream(deviceEUI protocol.EUI, id uint64, update func(*model.DownstreamMessage)) error {
+	m.RandomDelay()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.findDownstream(deviceEUI, id)
+	if i < 0 {
 		return storage.ErrNotFound
 	}
-	existing.SentTime = sentTime
-	existing.AckTime = ackTime
-	m.downstream[deviceEUI] = existing
+	update(&m.downstream[deviceEUI][i])
 	return nil
 }
+
+func (m *memoryDataStorage) UpdateDownstream(deviceEUI protocol.EUI, id uint64, sentTime int64, ackTime int64) error {
+	return m.updateDownstream(deviceEUI, id, func(msg *model.DownstreamMessage) {
+		msg.SentTime = sentTime
+		msg.AckTime = ackTime
+	})
+}
+
+func (m *memoryDataStorage) FailDownstream(deviceEUI protocol.EUI, id uint64, txError string) error {
+	return m.updateDownstream(deviceEUI, id, func(msg *model.DownstreamMessage) {
+		msg.SentTime = 0
+		msg.RXWindow = 0
+		msg.TXError = txError
+		if msg.Attempts > 0 {
+			msg.Attempts--
+		}
+	})
+}
+
+func (m *memoryDataStorage) ListExpiredDownstream(now int64) ([]model.DownstreamMessage, error) {
+	m.RandomDelay()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ret := make([]model.DownstreamMessage, 0)
+	for _, queue := range m.downstream {
+		for _, msg := range queue {
+			if !msg.IsComplete() && msg.HasExpired(time.Unix(now, 0)) {
+				ret = append(ret, msg)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (m *memoryDataStorage) UpdateDownstreamAttempts(deviceEUI protocol.EUI, id uint64, attempts uint8, failedTime int64) error {
+	return m.updateDownstream(deviceEUI, id, func(msg *model.DownstreamMessage) {
+		msg.Attempts = attempts
+		msg.FailedTime = failedTime
+	})
+}
+
+func (m *memoryDataStorage) UpdateDownstreamPriority(deviceEUI protocol.EUI, id uint64, priority uint8) error {
+	return m.updateDownstream(deviceEUI, id, func(msg *model.DownstreamMessage) {
+		msg.Priority = priority
+	})
+}
+
+func (m *memoryDataStorage) UpdateDownstreamWindow(deviceEUI protocol.EUI, id uint64, rxWindow uint8) error {
+	return m.updateDownstream(deviceEUI, id, func(msg *model.DownstreamMessage) {
+		msg.RXWindow = rxWindow
+	})
+}
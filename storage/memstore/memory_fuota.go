@@ -0,0 +1,169 @@
+package memstore
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sync"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+)
+
+// memoryFUOTAStorage implements FUOTAStorage
+type memoryFUOTAStorage struct {
+	mutex     *sync.Mutex
+	campaigns map[protocol.EUI]model.FUOTACampaign
+	devices   map[protocol.EUI][]model.FUOTADevice
+}
+
+// NewMemoryFUOTAStorage creates a new memory-backed FUOTA storage
+func NewMemoryFUOTAStorage() storage.FUOTAStorage {
+	return &memoryFUOTAStorage{
+		mutex:     &sync.Mutex{},
+		campaigns: make(map[protocol.EUI]model.FUOTACampaign),
+		devices:   make(map[protocol.EUI][]model.FUOTADevice),
+	}
+}
+
+func (m *memoryFUOTAStorage) Put(campaign model.FUOTACampaign) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.campaigns[campaign.CampaignEUI]; exists {
+		return storage.ErrAlreadyExists
+	}
+	m.campaigns[campaign.CampaignEUI] = campaign
+	return nil
+}
+
+func (m *memoryFUOTAStorage) GetByEUI(campaignEUI protocol.EUI) (model.FUOTACampaign, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	campaign, exists := m.campaigns[campaignEUI]
+	if !exists {
+		return campaign, storage.ErrNotFound
+	}
+	return campaign, nil
+}
+
+// listCampaigns returns the campaigns matching the filter on a channel
+func (m *memoryFUOTAStorage) listCampaigns(filter func(model.FUOTACampaign) bool) <-chan model.FUOTACampaign {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := make([]model.FUOTACampaign, 0)
+	for _, v := range m.campaigns {
+		if filter(v) {
+			list = append(list, v)
+		}
+	}
+	ret := make(chan model.FUOTACampaign)
+	go func() {
+		defer close(ret)
+		for _, v := range list {
+			ret <- v
+		}
+	}()
+	return ret
+}
+
+func (m *memoryFUOTAStorage) GetByApplicationEUI(appEUI protocol.EUI) (<-chan model.FUOTACampaign, error) {
+	return m.listCampaigns(func(c model.FUOTACampaign) bool {
+		return c.AppEUI == appEUI
+	}), nil
+}
+
+func (m *memoryFUOTAStorage) ListActive() (<-chan model.FUOTACampaign, error) {
+	return m.listCampaigns(func(c model.FUOTACampaign) bool {
+		return c.State.IsActive()
+	}), nil
+}
+
+func (m *memoryFUOTAStorage) Update(campaign model.FUOTACampaign) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.campaigns[campaign.CampaignEUI]
+	if !exists {
+		return storage.ErrNotFound
+	}
+	existing.State = campaign.State
+	existing.FragmentsSent = campaign.FragmentsSent
+	m.campaigns[campaign.CampaignEUI] = existing
+	return nil
+}
+
+func (m *memoryFUOTAStorage) Delete(campaignEUI protocol.EUI) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.campaigns[campaignEUI]; !exists {
+		return storage.ErrNotFound
+	}
+	delete(m.campaigns, campaignEUI)
+	delete(m.devices, campaignEUI)
+	return nil
+}
+
+func (m *memoryFUOTAStorage) PutDevice(device model.FUOTADevice) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.campaigns[device.CampaignEUI]; !exists {
+		return storage.ErrNotFound
+	}
+	for _, v := range m.devices[device.CampaignEUI] {
+		if v.DeviceEUI == device.DeviceEUI {
+			return storage.ErrAlreadyExists
+		}
+	}
+	m.devices[device.CampaignEUI] = append(m.devices[device.CampaignEUI], device)
+	return nil
+}
+
+func (m *memoryFUOTAStorage) UpdateDevice(device model.FUOTADevice) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, v := range m.devices[device.CampaignEUI] {
+		if v.DeviceEUI == device.DeviceEUI {
+			m.devices[device.CampaignEUI][i] = device
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (m *memoryFUOTAStorage) GetDevices(campaignEUI protocol.EUI) (<-chan model.FUOTADevice, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := append([]model.FUOTADevice{}, m.devices[campaignEUI]...)
+	ret := make(chan model.FUOTADevice)
+	go func() {
+		defer close(ret)
+		for _, v := range list {
+			ret <- v
+		}
+	}()
+	return ret, nil
+}
+
+func (m *memoryFUOTAStorage) Close() {
+	// Nothing to do
+}
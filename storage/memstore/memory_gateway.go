@@ -16,7 +16,9 @@ package memstore
 //limitations under the License.
 //
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
@@ -32,6 +34,7 @@ type memGateway struct {
 type memoryGatewayStorage struct {
 	mutex    *sync.Mutex
 	gateways map[protocol.EUI]memGateway
+	status   map[protocol.EUI][]model.GatewayStatus
 }
 
 func (m *memoryGatewayStorage) Put(gateway model.Gateway, userID model.UserID) error {
@@ -56,6 +59,7 @@ func (m *memoryGatewayStorage) Delete(eui protocol.EUI, userID model.UserID) err
 		return storage.ErrNotFound
 	}
 	delete(m.gateways, eui)
+	delete(m.status, eui)
 	return nil
 }
 
@@ -108,7 +112,7 @@ func (m *memoryGatewayStorage) Get(eui protocol.EUI, userID model.UserID) (model
 	defer m.mutex.Unlock()
 
 	gw, exists := m.gateways[eui]
-	if !exists || gw.userID != userID {
+	if !exists || (gw.userID != userID && userID != model.SystemUserID) {
 		return model.Gateway{}, storage.ErrNotFound
 	}
 
@@ -130,11 +134,69 @@ func (m *memoryGatewayStorage) Update(gateway model.Gateway, userID model.UserID
 	existing.gw.Longitude = gateway.Longitude
 	existing.gw.StrictIP = gateway.StrictIP
 	existing.gw.Tags = gateway.Tags
+	existing.gw.Band = gateway.Band
+	existing.gw.SubBand = gateway.SubBand
+	existing.gw.StationToken = gateway.StationToken
 
 	m.gateways[gateway.GatewayEUI] = existing
 
 	return nil
 }
+
+func (m *memoryGatewayStorage) UpdateLocation(eui protocol.EUI, latitude, longitude float32, altitude *float32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.gateways[eui]
+	if !exists {
+		return storage.ErrNotFound
+	}
+	existing.gw.Latitude = latitude
+	existing.gw.Longitude = longitude
+	if altitude != nil {
+		existing.gw.Altitude = *altitude
+	}
+	m.gateways[eui] = existing
+	return nil
+}
+
+func (m *memoryGatewayStorage) PutStatus(eui protocol.EUI, status model.GatewayStatus) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.gateways[eui]; !exists {
+		return storage.ErrNotFound
+	}
+	m.status[eui] = append(m.status[eui], status)
+	return nil
+}
+
+func (m *memoryGatewayStorage) GetStatus(eui protocol.EUI) ([]model.GatewayStatus, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ret := make([]model.GatewayStatus, len(m.status[eui]))
+	copy(ret, m.status[eui])
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Received.Before(ret[j].Received)
+	})
+	return ret, nil
+}
+
+func (m *memoryGatewayStorage) DeleteStatus(eui protocol.EUI, before time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var list []model.GatewayStatus
+	for _, v := range m.status[eui] {
+		if !v.Received.Before(before) {
+			list = append(list, v)
+		}
+	}
+	m.status[eui] = list
+	return nil
+}
+
 func (m *memoryGatewayStorage) Close() {
 
 }
@@ -144,5 +206,6 @@ func NewMemoryGatewayStorage() storage.GatewayStorage {
 	return &memoryGatewayStorage{
 		mutex:    &sync.Mutex{},
 		gateways: make(map[protocol.EUI]memGateway),
+		status:   make(map[protocol.EUI][]model.GatewayStatus),
 	}
 }
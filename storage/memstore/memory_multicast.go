@@ -0,0 +1,207 @@
+package memstore
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"sync"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+)
+
+// memoryMulticastStorage implements MulticastStorage
+type memoryMulticastStorage struct {
+	mutex      *sync.Mutex
+	groups     map[protocol.EUI]model.MulticastGroup
+	downstream map[protocol.EUI]model.MulticastMessage
+	deliveries map[protocol.EUI][]model.MulticastDelivery
+}
+
+// NewMemoryMulticastStorage creates a new memory-backed multicast storage
+func NewMemoryMulticastStorage() storage.MulticastStorage {
+	return &memoryMulticastStorage{
+		mutex:      &sync.Mutex{},
+		groups:     make(map[protocol.EUI]model.MulticastGroup),
+		downstream: make(map[protocol.EUI]model.MulticastMessage),
+		deliveries: make(map[protocol.EUI][]model.MulticastDelivery),
+	}
+}
+
+func (m *memoryMulticastStorage) Put(group model.MulticastGroup) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.groups[group.GroupEUI]; exists {
+		return storage.ErrAlreadyExists
+	}
+	m.groups[group.GroupEUI] = group
+	return nil
+}
+
+func (m *memoryMulticastStorage) GetByEUI(groupEUI protocol.EUI) (model.MulticastGroup, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	group, exists := m.groups[groupEUI]
+	if !exists {
+		return group, storage.ErrNotFound
+	}
+	return group, nil
+}
+
+func (m *memoryMulticastStorage) GetByApplicationEUI(appEUI protocol.EUI) (<-chan model.MulticastGroup, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := make([]model.MulticastGroup, 0)
+	for _, v := range m.groups {
+		if v.AppEUI == appEUI {
+			list = append(list, v)
+		}
+	}
+	ret := make(chan model.MulticastGroup)
+	go func() {
+		defer close(ret)
+		for _, v := range list {
+			ret <- v
+		}
+	}()
+	return ret, nil
+}
+
+func (m *memoryMulticastStorage) Update(group model.MulticastGroup) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.groups[group.GroupEUI]
+	if !exists {
+		return storage.ErrNotFound
+	}
+	group.FCntDn = existing.FCntDn
+	group.AppEUI = existing.AppEUI
+	m.groups[group.GroupEUI] = group
+	return nil
+}
+
+func (m *memoryMulticastStorage) UpdateFCnt(groupEUI protocol.EUI, fcntDn uint32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.groups[groupEUI]
+	if !exists {
+		return storage.ErrNotFound
+	}
+	existing.FCntDn = fcntDn
+	m.groups[groupEUI] = existing
+	return nil
+}
+
+func (m *memoryMulticastStorage) Delete(groupEUI protocol.EUI) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.groups[groupEUI]; !exists {
+		return storage.ErrNotFound
+	}
+	delete(m.groups, groupEUI)
+	delete(m.downstream, groupEUI)
+	delete(m.deliveries, groupEUI)
+	return nil
+}
+
+func (m *memoryMulticastStorage) PutDownstream(groupEUI protocol.EUI, message model.MulticastMessage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.groups[groupEUI]; !exists {
+		return storage.ErrNotFound
+	}
+	if _, exists := m.downstream[groupEUI]; exists {
+		return storage.ErrAlreadyExists
+	}
+	m.downstream[groupEUI] = message
+	return nil
+}
+
+func (m *memoryMulticastStorage) GetDownstream(groupEUI protocol.EUI) (model.MulticastMessage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.downstream[groupEUI]
+	if !exists {
+		return existing, storage.ErrNotFound
+	}
+	return existing, nil
+}
+
+func (m *memoryMulticastStorage) DeleteDownstream(groupEUI protocol.EUI) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.downstream[groupEUI]; !exists {
+		return storage.ErrNotFound
+	}
+	delete(m.downstream, groupEUI)
+	return nil
+}
+
+func (m *memoryMulticastStorage) UpdateDownstream(groupEUI protocol.EUI, sentTime int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.downstream[groupEUI]
+	if !exists {
+		return storage.ErrNotFound
+	}
+	existing.SentTime = sentTime
+	m.downstream[groupEUI] = existing
+	return nil
+}
+
+func (m *memoryMulticastStorage) AddDelivery(delivery model.MulticastDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.groups[delivery.GroupEUI]; !exists {
+		return storage.ErrNotFound
+	}
+	m.deliveries[delivery.GroupEUI] = append(m.deliveries[delivery.GroupEUI], delivery)
+	return nil
+}
+
+func (m *memoryMulticastStorage) GetDeliveries(groupEUI protocol.EUI, limit int) (<-chan model.MulticastDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := make([]model.MulticastDelivery, 0)
+	existing := m.deliveries[groupEUI]
+	for i := len(existing) - 1; i >= 0 && len(list) < limit; i-- {
+		list = append(list, existing[i])
+	}
+	ret := make(chan model.MulticastDelivery)
+	go func() {
+		defer close(ret)
+		for _, v := range list {
+			ret <- v
+		}
+	}()
+	return ret, nil
+}
+
+func (m *memoryMulticastStorage) Close() {
+	// Nothing to do
+}
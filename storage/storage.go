@@ -16,6 +16,8 @@ package storage
 //limitations under the License.
 //
 import (
+	"time"
+
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 )
@@ -95,20 +97,56 @@ type DataStorage interface {
 	// is called it cannot do any additional operations.
 	Close()
 
-	// PutDownstream stores a new downstream message for given device. ErrConflict is returned
-	// if there's already downstream message for that device
+	// PutDownstream adds a new downstream message to the device's queue.
+	// ErrAlreadyExists is returned if there's already a message with the same
+	// ID in the queue.
 	PutDownstream(deviceEUI protocol.EUI, message model.DownstreamMessage) error
 
-	// DeleteDownstream removes (ie cancels) the downstream message for the specified device
+	// DeleteDownstream removes (ie cancels) all of the downstream messages
+	// for the specified device. ErrNotFound is returned if the queue is empty.
 	DeleteDownstream(deviceEUI protocol.EUI) error
 
-	// GetDownstream retrieves a downstream message. ErrNotFound is returned
-	// if there's no downstream message for that device
+	// DeleteDownstreamMessage removes (ie cancels) a single message in the
+	// device's queue. ErrNotFound is returned if the message doesn't exist.
+	DeleteDownstreamMessage(deviceEUI protocol.EUI, id uint64) error
+
+	// GetDownstream retrieves the message at the head of the device's queue.
+	// ErrNotFound is returned if there's no downstream message for that device
 	GetDownstream(deviceEUI protocol.EUI) (model.DownstreamMessage, error)
 
+	// ListDownstream returns the device's downstream messages in the order
+	// they are sent. The list is empty if there are no messages.
+	ListDownstream(deviceEUI protocol.EUI) ([]model.DownstreamMessage, error)
+
 	// Update time stamps on downstream message. ErrNotFound is returned if there's no
-	// downstream message for that device.
-	UpdateDownstream(deviceEUI protocol.EUI, sentTime int64, ackTime int64) error
+	// such downstream message for that device.
+	UpdateDownstream(deviceEUI protocol.EUI, id uint64, sentTime int64, ackTime int64) error
+
+	// FailDownstream resets the sent time and the receive window for the
+	// downstream message and records the error from the gateway. The message wasn't sent so the
+	// attempt isn't counted. The message is sent again later. ErrNotFound is
+	// returned if the message doesn't exist.
+	FailDownstream(deviceEUI protocol.EUI, id uint64, txError string) error
+
+	// ListExpiredDownstream returns the downstream messages for all devices
+	// that have expired at the specified time (in seconds since epoch) but
+	// aren't completed yet.
+	ListExpiredDownstream(now int64) ([]model.DownstreamMessage, error)
+
+	// UpdateDownstreamAttempts updates the number of attempts and the failed
+	// time for the downstream message. ErrNotFound is returned if the message
+	// doesn't exist.
+	UpdateDownstreamAttempts(deviceEUI protocol.EUI, id uint64, attempts uint8, failedTime int64) error
+
+	// UpdateDownstreamPriority changes the priority, ie the position in the
+	// queue, for the downstream message. ErrNotFound is returned if the
+	// message doesn't exist.
+	UpdateDownstreamPriority(deviceEUI protocol.EUI, id uint64, priority uint8) error
+
+	// UpdateDownstreamWindow records the receive window (1 or 2) the
+	// downstream message was sent in. ErrNotFound is returned if the message
+	// doesn't exist.
+	UpdateDownstreamWindow(deviceEUI protocol.EUI, id uint64, rxWindow uint8) error
 }
 
 // GatewayStorage is used to store and retrieve gateways
@@ -126,12 +164,30 @@ type GatewayStorage interface {
 	// ListAll lists all available gateways
 	ListAll() (chan model.PublicGatewayInfo, error)
 
-	// Get returns the gateway with the specified EUI.
+	// Get returns the gateway with the specified EUI. The system user can
+	// read all gateways.
 	Get(eui protocol.EUI, userID model.UserID) (model.Gateway, error)
 
-	// Update updates fields (and tags) on the gateway
+	// Update updates fields (and tags) on the gateway.
 	Update(gateway model.Gateway, userID model.UserID) error
 
+	// UpdateLocation updates the location of the gateway and leaves the other
+	// fields as they are. The altitude is kept if it is nil. This is used
+	// when the gateway reports its GPS position.
+	UpdateLocation(eui protocol.EUI, latitude, longitude float32, altitude *float32) error
+
+	// PutStatus stores a status report for the gateway. If the gateway isn't
+	// found it will return ErrNotFound.
+	PutStatus(eui protocol.EUI, status model.GatewayStatus) error
+
+	// GetStatus returns the status reports for the gateway, oldest report
+	// first.
+	GetStatus(eui protocol.EUI) ([]model.GatewayStatus, error)
+
+	// DeleteStatus removes the gateway's status reports received before the
+	// specified time.
+	DeleteStatus(eui protocol.EUI, before time.Time) error
+
 	// Close closes the storage and releases allocated resources. Once Close()
 	// is called it cannot do any additional operations.
 	Close()
@@ -170,6 +226,8 @@ type Storage struct {
 	Token          TokenStorage
 	UserManagement UserManagement
 	AppOutput      AppOutputStorage
+	Multicast      MulticastStorage
+	FUOTA          FUOTAStorage
 }
 
 // Close closes all of the storage instances.
@@ -195,6 +253,12 @@ func (s *Storage) Close() {
 	if s.UserManagement != nil {
 		s.UserManagement.Close()
 	}
+	if s.Multicast != nil {
+		s.Multicast.Close()
+	}
+	if s.FUOTA != nil {
+		s.FUOTA.Close()
+	}
 }
 
 // KeySequenceStorage is a storage interface for key sequences. Sequences of
@@ -255,3 +319,95 @@ type AppOutputStorage interface {
 	// List lists all of the application outputs
 	ListAll() (<-chan model.AppOutput, error)
 }
+
+// MulticastStorage is used to store and retrieve multicast groups, their
+// downlink messages and the log of delivery attempts.
+type MulticastStorage interface {
+	// Put stores a new multicast group.
+	Put(group model.MulticastGroup) error
+
+	// GetByEUI returns the multicast group with the matching EUI. If the group
+	// doesn't exist it will return ErrNotFound.
+	GetByEUI(groupEUI protocol.EUI) (model.MulticastGroup, error)
+
+	// GetByApplicationEUI returns all of the multicast groups within the given
+	// application.
+	GetByApplicationEUI(appEUI protocol.EUI) (<-chan model.MulticastGroup, error)
+
+	// Update updates the fields (and tags) on the group. The frame counter
+	// isn't updated.
+	Update(group model.MulticastGroup) error
+
+	// UpdateFCnt updates the downlink frame counter for the group.
+	UpdateFCnt(groupEUI protocol.EUI, fcntDn uint32) error
+
+	// Delete removes the multicast group from the backend store. The group's
+	// downlink message and delivery log is removed as well. If the group isn't
+	// found it will return ErrNotFound.
+	Delete(groupEUI protocol.EUI) error
+
+	// PutDownstream stores a new downlink message for the group.
+	// ErrAlreadyExists is returned if there's already a message for the group.
+	PutDownstream(groupEUI protocol.EUI, message model.MulticastMessage) error
+
+	// GetDownstream retrieves the downlink message for the group. ErrNotFound
+	// is returned if there's no message for the group.
+	GetDownstream(groupEUI protocol.EUI) (model.MulticastMessage, error)
+
+	// DeleteDownstream removes (ie cancels) the downlink message for the group.
+	DeleteDownstream(groupEUI protocol.EUI) error
+
+	// UpdateDownstream updates the sent time for the group's downlink message.
+	// ErrNotFound is returned if there's no message for the group.
+	UpdateDownstream(groupEUI protocol.EUI, sentTime int64) error
+
+	// AddDelivery adds a delivery attempt to the group's delivery log.
+	AddDelivery(delivery model.MulticastDelivery) error
+
+	// GetDeliveries returns the latest delivery attempts for the group, newest
+	// first.
+	GetDeliveries(groupEUI protocol.EUI, limit int) (<-chan model.MulticastDelivery, error)
+
+	// Close closes the storage and releases allocated resources. Once Close()
+	// is called it cannot do any additional operations.
+	Close()
+}
+
+// FUOTAStorage is used to store and retrieve firmware update campaigns and the
+// status for the devices in the campaigns.
+type FUOTAStorage interface {
+	// Put stores a new campaign.
+	Put(campaign model.FUOTACampaign) error
+
+	// GetByEUI returns the campaign with the matching EUI. If the campaign
+	// doesn't exist it will return ErrNotFound.
+	GetByEUI(campaignEUI protocol.EUI) (model.FUOTACampaign, error)
+
+	// GetByApplicationEUI returns all of the campaigns within the given
+	// application.
+	GetByApplicationEUI(appEUI protocol.EUI) (<-chan model.FUOTACampaign, error)
+
+	// ListActive returns all of the campaigns that are running.
+	ListActive() (<-chan model.FUOTACampaign, error)
+
+	// Update updates the state and the number of fragments sent for the
+	// campaign.
+	Update(campaign model.FUOTACampaign) error
+
+	// Delete removes the campaign and the device status from the backend
+	// store. If the campaign isn't found it will return ErrNotFound.
+	Delete(campaignEUI protocol.EUI) error
+
+	// PutDevice adds a device to the campaign.
+	PutDevice(device model.FUOTADevice) error
+
+	// UpdateDevice updates the status of a device in the campaign.
+	UpdateDevice(device model.FUOTADevice) error
+
+	// GetDevices returns the status for all of the devices in the campaign.
+	GetDevices(campaignEUI protocol.EUI) (<-chan model.FUOTADevice, error)
+
+	// Close closes the storage and releases allocated resources. Once Close()
+	// is called it cannot do any additional operations.
+	Close()
+}
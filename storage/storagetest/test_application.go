@@ -76,6 +76,10 @@ func testApplicationStorage(
 
 	// Update application
 	application.Tags.SetTag("Foo", "Bar")
+	application.DeviceStatusInterval = 6 * time.Hour
+	application.Channels = model.ChannelList{{Frequency: 867.1}, {Frequency: 867.3, Downlink: 869.1}}
+	application.EndToEnd = true
+	application.KEKLabel = "app-kek"
 	if err := appStorage.Update(application, userID); err != nil {
 		t.Fatalf("Couldn't update app: %v", err)
 	}
@@ -91,6 +95,15 @@ func testApplicationStorage(
 	if foo1 != foo2 {
 		t.Fatalf("App isn't updated properly. Updated app is %v but should be %v", updatedApp, application)
 	}
+	if updatedApp.DeviceStatusInterval != application.DeviceStatusInterval {
+		t.Fatalf("Device status interval isn't updated. Expected %v but got %v", application.DeviceStatusInterval, updatedApp.DeviceStatusInterval)
+	}
+	if !updatedApp.Channels.Equals(application.Channels) {
+		t.Fatalf("Channels aren't updated. Expected %v but got %v", application.Channels, updatedApp.Channels)
+	}
+	if updatedApp.EndToEnd != application.EndToEnd || updatedApp.KEKLabel != application.KEKLabel {
+		t.Fatalf("End-to-end encryption settings aren't updated. Expected %v but got %v", application, updatedApp)
+	}
 
 	// Update app that doesn't exist
 	unknownApp := model.NewApplication()
@@ -150,8 +150,8 @@ func testDeviceStorage(
 		t.Error("No channel 2 returned!")
 		return
 	}
-	app1Count := uint16(0)
-	app2Count := uint16(0)
+	app1Count := uint32(0)
+	app2Count := uint32(0)
 	for i := 0; i < 4; i++ {
 		select {
 		case dev := <-deviceChan1:
@@ -241,8 +241,30 @@ func testDeviceStorage(
 
 	updatedDevice.DevAddr = protocol.DevAddrFromUint32(0x01020304)
 	updatedDevice.RelaxedCounter = true
-	updatedDevice.FCntDn = 99
-	updatedDevice.FCntUp = 100
+	// Frame counters are 32 bits
+	updatedDevice.FCntDn = 0x10063
+	updatedDevice.FCntUp = 0xFFFFFF00
+	updatedDevice.DataRate = 5
+	updatedDevice.TXPower = 3
+	updatedDevice.NbTrans = 2
+	updatedDevice.BatteryLevel = 200
+	updatedDevice.DeviceMargin = -12
+	updatedDevice.StatusTime = time.Now().UnixNano()
+	updatedDevice.RXSettings = model.RXSettings{RX1Delay: 2, RX1DROffset: 1, RX2DataRate: 3, RX2Frequency: 869.525}
+	updatedDevice.RequestedRX = model.RXSettings{RX1Delay: 5, RX1DROffset: 2, RX2DataRate: 0, RX2Frequency: 0}
+	updatedDevice.Channels = model.ChannelList{{Frequency: 868.1}, {Frequency: 868.3}, {Frequency: 868.5}, {}, {Frequency: 867.3}}
+	updatedDevice.MACVersion = protocol.MACVersion11
+	updatedDevice.NwkKey, _ = protocol.AESKeyFromString("0000 1111 2222 3333 4444 5555 6666 7777")
+	updatedDevice.SNwkSIntKey, _ = protocol.AESKeyFromString("1111 2222 3333 4444 5555 6666 7777 8888")
+	updatedDevice.NwkSEncKey, _ = protocol.AESKeyFromString("2222 3333 4444 5555 6666 7777 8888 9999")
+	updatedDevice.JoinNonce = 0x123456
+	updatedDevice.GenAppKey, _ = protocol.AESKeyFromString("3333 4444 5555 6666 7777 8888 9999 aaaa")
+	updatedDevice.RJCount0 = 0x1234
+	updatedDevice.RJCount1 = 0xFFFE
+	updatedDevice.Class = model.ClassC
+	updatedDevice.PingSlot = model.PingSlotSettings{Periodicity: 3, DataRate: 2, Frequency: 869.1}
+	updatedDevice.RequestedPing = model.PingSlotSettings{DataRate: 4, Frequency: 869.3}
+	updatedDevice.PendingSession = &model.PendingSession{SNwkSIntKey: updatedDevice.NwkSEncKey, ResetRadio: true, DataRate: 2}
 	updatedDevice.AppSKey, _ = protocol.AESKeyFromString("aaaa bbbb cccc dddd eeee ffff 0000 1111")
 	updatedDevice.NwkSKey, _ = protocol.AESKeyFromString("1111 bbbb 2222 dddd eeee ffff 0000 1111")
 	if err := devStorage.Update(updatedDevice); err != nil {
@@ -252,6 +274,39 @@ func testDeviceStorage(
 	if tmp.AppSKey.String() != updatedDevice.AppSKey.String() || tmp.NwkSKey.String() != updatedDevice.NwkSKey.String() || tmp.DevAddr.String() != updatedDevice.DevAddr.String() || tmp.RelaxedCounter != updatedDevice.RelaxedCounter || tmp.FCntDn != updatedDevice.FCntDn || tmp.FCntUp != updatedDevice.FCntUp {
 		t.Fatalf("Device did not update correctly %v != %v", tmp, updatedDevice)
 	}
+	if tmp.DataRate != updatedDevice.DataRate || tmp.TXPower != updatedDevice.TXPower || tmp.NbTrans != updatedDevice.NbTrans {
+		t.Fatalf("Device did not update ADR settings correctly %v != %v", tmp, updatedDevice)
+	}
+	if tmp.BatteryLevel != updatedDevice.BatteryLevel || tmp.DeviceMargin != updatedDevice.DeviceMargin || tmp.StatusTime != updatedDevice.StatusTime {
+		t.Fatalf("Device did not update device status correctly %v != %v", tmp, updatedDevice)
+	}
+	if tmp.RXSettings != updatedDevice.RXSettings || tmp.RequestedRX != updatedDevice.RequestedRX {
+		t.Fatalf("Device did not update RX settings correctly %v != %v", tmp, updatedDevice)
+	}
+	if !tmp.Channels.Equals(updatedDevice.Channels) {
+		t.Fatalf("Device did not update channels correctly %v != %v", tmp.Channels, updatedDevice.Channels)
+	}
+	if tmp.MACVersion != updatedDevice.MACVersion || tmp.NwkKey != updatedDevice.NwkKey || tmp.SNwkSIntKey != updatedDevice.SNwkSIntKey || tmp.NwkSEncKey != updatedDevice.NwkSEncKey || tmp.JoinNonce != updatedDevice.JoinNonce ||
+		tmp.RJCount0 != updatedDevice.RJCount0 || tmp.RJCount1 != updatedDevice.RJCount1 ||
+		tmp.Class != updatedDevice.Class {
+		t.Fatalf("Device did not update LoRaWAN 1.1 settings correctly %v != %v", tmp, updatedDevice)
+	}
+	if tmp.GenAppKey != updatedDevice.GenAppKey {
+		t.Fatalf("Device did not update GenAppKey correctly %v != %v", tmp.GenAppKey, updatedDevice.GenAppKey)
+	}
+	if tmp.PingSlot != updatedDevice.PingSlot || tmp.RequestedPing != updatedDevice.RequestedPing {
+		t.Fatalf("Device did not update ping slot settings correctly %v != %v", tmp, updatedDevice)
+	}
+	if tmp.PendingSession == nil || tmp.PendingSession.SNwkSIntKey != updatedDevice.NwkSEncKey || !tmp.PendingSession.ResetRadio || tmp.PendingSession.DataRate != 2 {
+		t.Fatalf("Device did not update pending session correctly %v != %v", tmp.PendingSession, updatedDevice.PendingSession)
+	}
+	updatedDevice.PendingSession = nil
+	if err := devStorage.Update(updatedDevice); err != nil {
+		t.Fatal("Got error updating device: ", err)
+	}
+	if tmp, _ = devStorage.GetByEUI(updatedDevice.DeviceEUI); tmp.PendingSession != nil {
+		t.Fatalf("Expected pending session to be removed: %+v", tmp.PendingSession)
+	}
 
 	// Attempt delete on application - should fail since there's devices
 	if err := appStorage.Delete(app1.AppEUI, userID); err == nil {
@@ -37,52 +37,79 @@ func testDownstreamStorage(s *storage.Storage, t *testing.T) {
 	testDevice.NwkSKey = makeRandomKey()
 	s.Device.Put(testDevice, application.AppEUI)
 
+	if _, err := s.DeviceData.GetDownstream(testDevice.DeviceEUI); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for empty queue but got %v", err)
+	}
+	if queue, err := s.DeviceData.ListDownstream(testDevice.DeviceEUI); err != nil || len(queue) != 0 {
+		t.Fatalf("Expected empty queue but got %v (err=%v)", queue, err)
+	}
+
 	downstreamMsg := model.NewDownstreamMessage(testDevice.DeviceEUI, 42)
 	downstreamMsg.Ack = false
 	downstreamMsg.Data = "aabbccddeeff"
 	if err := s.DeviceData.PutDownstream(testDevice.DeviceEUI, downstreamMsg); err != nil {
 		t.Fatal("Couldn't store downstream message: ", err)
 	}
+	if err := s.DeviceData.PutDownstream(testDevice.DeviceEUI, downstreamMsg); err != storage.ErrAlreadyExists {
+		t.Fatalf("Shouldn't be able to store the same message twice but got %v", err)
+	}
 
 	newDownstreamMsg := model.NewDownstreamMessage(testDevice.DeviceEUI, 43)
 	newDownstreamMsg.Ack = false
 	newDownstreamMsg.Data = "aabbccddeeff"
-	if err := s.DeviceData.PutDownstream(testDevice.DeviceEUI, newDownstreamMsg); err == nil {
-		t.Fatal("Shouldn't be able to store another downstream message")
+	newDownstreamMsg.ExpiresTime = time.Now().Add(time.Hour).Unix()
+	newDownstreamMsg.FCnt = 0x10001
+	if err := s.DeviceData.PutDownstream(testDevice.DeviceEUI, newDownstreamMsg); err != nil {
+		t.Fatal("Should be able to queue another downstream message: ", err)
 	}
 
-	if err := s.DeviceData.DeleteDownstream(testDevice.DeviceEUI); err != nil {
-		t.Fatalf("Couldn't remove downstream message: %v", err)
+	queue, err := s.DeviceData.ListDownstream(testDevice.DeviceEUI)
+	if err != nil || len(queue) != 2 || queue[0] != downstreamMsg || queue[1] != newDownstreamMsg {
+		t.Fatalf("Queue isn't in the expected order: %+v (err=%v)", queue, err)
 	}
 
-	if err := s.DeviceData.DeleteDownstream(testDevice.DeviceEUI); err != storage.ErrNotFound {
-		t.Fatalf("Should get ErrNotFound when removing message but got: %v", err)
+	// Increase the priority of the last message. It should move to the head
+	// of the queue.
+	if err := s.DeviceData.UpdateDownstreamPriority(testDevice.DeviceEUI, newDownstreamMsg.ID, 10); err != nil {
+		t.Fatal("Got error updating priority for downstream message: ", err)
 	}
-
-	if _, err := s.DeviceData.GetDownstream(testDevice.DeviceEUI); err != storage.ErrNotFound {
-		t.Fatalf("Expected ErrNotFound but got %v", err)
+	newDownstreamMsg.Priority = 10
+	head, err := s.DeviceData.GetDownstream(testDevice.DeviceEUI)
+	if err != nil || head != newDownstreamMsg {
+		t.Fatalf("Expected %+v at the head of the queue but got %+v (err=%v)", newDownstreamMsg, head, err)
 	}
 
-	if err := s.DeviceData.PutDownstream(testDevice.DeviceEUI, newDownstreamMsg); err != nil {
-		t.Fatalf("Should be able to store the new downstream message but got %v: ", err)
+	if err := s.DeviceData.DeleteDownstreamMessage(testDevice.DeviceEUI, downstreamMsg.ID); err != nil {
+		t.Fatalf("Couldn't remove downstream message: %v", err)
+	}
+	if err := s.DeviceData.DeleteDownstreamMessage(testDevice.DeviceEUI, downstreamMsg.ID); err != storage.ErrNotFound {
+		t.Fatalf("Should get ErrNotFound when removing message but got: %v", err)
+	}
+	if queue, err := s.DeviceData.ListDownstream(testDevice.DeviceEUI); err != nil || len(queue) != 1 {
+		t.Fatalf("Expected one message in queue but got %+v (err=%v)", queue, err)
 	}
 
 	time2 := time.Now().Unix()
-	if err := s.DeviceData.UpdateDownstream(testDevice.DeviceEUI, time2, 0); err != nil {
+	if err := s.DeviceData.UpdateDownstream(testDevice.DeviceEUI, newDownstreamMsg.ID, time2, 0); err != nil {
 		t.Fatal("Should be able to update sent time but got error: ", err)
 	}
 
+	if err := s.DeviceData.UpdateDownstreamWindow(testDevice.DeviceEUI, newDownstreamMsg.ID, 2); err != nil {
+		t.Fatal("Should be able to update receive window but got error: ", err)
+	}
+
 	newDownstreamMsg.SentTime = time2
+	newDownstreamMsg.RXWindow = 2
 	stored, err := s.DeviceData.GetDownstream(testDevice.DeviceEUI)
 	if err != nil {
 		t.Fatal("Got error retrieving downstream message: ", err)
 	}
 	if stored != newDownstreamMsg {
-		t.Fatalf("Sent time isn't updated properly. Got %+v but expected %+v", stored, newDownstreamMsg)
+		t.Fatalf("Sent time and receive window aren't updated properly. Got %+v but expected %+v", stored, newDownstreamMsg)
 	}
 
 	time3 := time.Now().Unix()
-	if err := s.DeviceData.UpdateDownstream(testDevice.DeviceEUI, 0, time3); err != nil {
+	if err := s.DeviceData.UpdateDownstream(testDevice.DeviceEUI, newDownstreamMsg.ID, 0, time3); err != nil {
 		t.Fatal("Got error updating downstream message: ", err)
 	}
 
@@ -94,12 +121,82 @@ func testDownstreamStorage(s *storage.Storage, t *testing.T) {
 		t.Fatalf("Ack time isn't updated properly. Got %d but expected %d", stored.AckTime, time3)
 	}
 
+	if err := s.DeviceData.UpdateDownstreamAttempts(testDevice.DeviceEUI, newDownstreamMsg.ID, 2, 0); err != nil {
+		t.Fatal("Got error updating attempts for downstream message: ", err)
+	}
+	if err := s.DeviceData.FailDownstream(testDevice.DeviceEUI, newDownstreamMsg.ID, "TOO_LATE"); err != nil {
+		t.Fatal("Got error failing downstream message: ", err)
+	}
+
+	stored, err = s.DeviceData.GetDownstream(testDevice.DeviceEUI)
+	if err != nil {
+		t.Fatal("Got error retrieving downstream message: ", err)
+	}
+	if stored.SentTime != 0 || stored.RXWindow != 0 || stored.TXError != "TOO_LATE" || stored.Attempts != 1 || stored.State() != model.UnsentState {
+		t.Fatalf("Failure isn't recorded properly: %+v", stored)
+	}
+
+	// The message is listed as expired once the expiry time has passed
+	hasExpired := func(now int64) bool {
+		expired, err := s.DeviceData.ListExpiredDownstream(now)
+		if err != nil {
+			t.Fatal("Got error listing expired downstream messages: ", err)
+		}
+		for _, v := range expired {
+			if v.DeviceEUI == testDevice.DeviceEUI && v.ID == newDownstreamMsg.ID {
+				return true
+			}
+		}
+		return false
+	}
+	if hasExpired(time.Now().Unix()) {
+		t.Fatal("Message shouldn't expire before the expiry time")
+	}
+	if !hasExpired(newDownstreamMsg.ExpiresTime) {
+		t.Fatal("Message should expire at the expiry time")
+	}
+
+	failedTime := time.Now().Unix()
+	if err := s.DeviceData.UpdateDownstreamAttempts(testDevice.DeviceEUI, newDownstreamMsg.ID, 3, failedTime); err != nil {
+		t.Fatal("Got error updating attempts for downstream message: ", err)
+	}
+
+	stored, err = s.DeviceData.GetDownstream(testDevice.DeviceEUI)
+	if err != nil {
+		t.Fatal("Got error retrieving downstream message: ", err)
+	}
+	if stored.Attempts != 3 || stored.FailedTime != failedTime || stored.ExpiresTime != newDownstreamMsg.ExpiresTime || stored.State() != model.FailedState {
+		t.Fatalf("Attempts aren't updated properly: %+v", stored)
+	}
+	if hasExpired(newDownstreamMsg.ExpiresTime) {
+		t.Fatal("Failed messages shouldn't be listed as expired")
+	}
+
 	if err := s.DeviceData.DeleteDownstream(testDevice.DeviceEUI); err != nil {
 		t.Fatalf("Did not expect error when deleting downstream but got %v", err)
 	}
 
-	if err := s.DeviceData.UpdateDownstream(testDevice.DeviceEUI, 0, 0); err != storage.ErrNotFound {
+	if err := s.DeviceData.DeleteDownstream(testDevice.DeviceEUI); err != storage.ErrNotFound {
+		t.Fatalf("Should get ErrNotFound when removing empty queue but got: %v", err)
+	}
+
+	if err := s.DeviceData.UpdateDownstream(testDevice.DeviceEUI, newDownstreamMsg.ID, 0, 0); err != storage.ErrNotFound {
 		t.Fatalf("Expected ErrNotFound when updating nonexisting message but got %v", err)
 	}
 
+	if err := s.DeviceData.FailDownstream(testDevice.DeviceEUI, newDownstreamMsg.ID, "TOO_LATE"); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound when failing nonexisting message but got %v", err)
+	}
+
+	if err := s.DeviceData.UpdateDownstreamAttempts(testDevice.DeviceEUI, newDownstreamMsg.ID, 1, 0); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound when updating attempts for nonexisting message but got %v", err)
+	}
+
+	if err := s.DeviceData.UpdateDownstreamPriority(testDevice.DeviceEUI, newDownstreamMsg.ID, 1); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound when updating priority for nonexisting message but got %v", err)
+	}
+
+	if err := s.DeviceData.UpdateDownstreamWindow(testDevice.DeviceEUI, newDownstreamMsg.ID, 1); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound when updating receive window for nonexisting message but got %v", err)
+	}
 }
@@ -0,0 +1,146 @@
+package storagetest
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/storage"
+)
+
+func testFUOTAStorage(s *storage.Storage, t *testing.T) {
+	application := model.NewApplication()
+	application.AppEUI = makeRandomEUI()
+	s.Application.Put(application, model.SystemUserID)
+
+	campaign := model.NewFUOTACampaign()
+	campaign.CampaignEUI = makeRandomEUI()
+	campaign.AppEUI = application.AppEUI
+	campaign.GroupEUI = makeRandomEUI()
+	campaign.McKey = makeRandomKey()
+	campaign.GroupID = 1
+	campaign.FragIndex = 2
+	campaign.Firmware = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}
+	campaign.FragSize = 4
+	campaign.Redundancy = 3
+	campaign.Descriptor = 0xFFFFFFFF
+	campaign.Interval = 5
+	campaign.SessionTime = 1000
+	campaign.TimeOut = 9
+	if err := s.FUOTA.Put(campaign); err != nil {
+		t.Fatal("Got error storing campaign: ", err)
+	}
+	if err := s.FUOTA.Put(campaign); err != storage.ErrAlreadyExists {
+		t.Fatalf("Expected ErrAlreadyExists when storing campaign twice but got %v", err)
+	}
+
+	stored, err := s.FUOTA.GetByEUI(campaign.CampaignEUI)
+	if err != nil {
+		t.Fatal("Got error retrieving campaign: ", err)
+	}
+	if stored.AppEUI != campaign.AppEUI || stored.GroupEUI != campaign.GroupEUI || stored.McKey != campaign.McKey ||
+		stored.GroupID != 1 || stored.FragIndex != 2 || !bytes.Equal(stored.Firmware, campaign.Firmware) ||
+		stored.FragSize != 4 || stored.Redundancy != 3 || stored.Descriptor != 0xFFFFFFFF || stored.Interval != 5 ||
+		stored.SessionTime != 1000 || stored.TimeOut != 9 || stored.State != model.FUOTASetup ||
+		stored.CreatedTime != campaign.CreatedTime {
+		t.Fatalf("Stored campaign doesn't match. Got %+v but expected %+v", stored, campaign)
+	}
+	if _, err := s.FUOTA.GetByEUI(makeRandomEUI()); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for unknown campaign but got %v", err)
+	}
+
+	countCampaigns := func(ch <-chan model.FUOTACampaign, err error) int {
+		if err != nil {
+			t.Fatal("Got error listing campaigns: ", err)
+		}
+		count := 0
+		for v := range ch {
+			if v.CampaignEUI == campaign.CampaignEUI {
+				count++
+			}
+		}
+		return count
+	}
+	if n := countCampaigns(s.FUOTA.GetByApplicationEUI(application.AppEUI)); n != 1 {
+		t.Fatalf("Expected 1 campaign in application but got %d", n)
+	}
+	if n := countCampaigns(s.FUOTA.ListActive()); n != 1 {
+		t.Fatalf("Expected campaign to be active but got %d", n)
+	}
+
+	campaign.State = model.FUOTAComplete
+	campaign.FragmentsSent = 6
+	if err := s.FUOTA.Update(campaign); err != nil {
+		t.Fatal("Got error updating campaign: ", err)
+	}
+	stored, _ = s.FUOTA.GetByEUI(campaign.CampaignEUI)
+	if stored.State != model.FUOTAComplete || stored.FragmentsSent != 6 {
+		t.Fatalf("Campaign isn't updated: %+v", stored)
+	}
+	if n := countCampaigns(s.FUOTA.ListActive()); n != 0 {
+		t.Fatal("Completed campaign is listed as active")
+	}
+
+	// Device status
+	device := model.FUOTADevice{CampaignEUI: campaign.CampaignEUI, DeviceEUI: makeRandomEUI(), State: model.FUOTADeviceGroupSetup}
+	if err := s.FUOTA.PutDevice(device); err != nil {
+		t.Fatal("Got error storing device: ", err)
+	}
+	if err := s.FUOTA.PutDevice(device); err != storage.ErrAlreadyExists {
+		t.Fatalf("Expected ErrAlreadyExists when storing device twice but got %v", err)
+	}
+	device.State = model.FUOTADeviceIncomplete
+	device.NbFragReceived = 4
+	device.MissingFrag = 2
+	device.Error = "missing fragments"
+	device.UpdatedTime = 2000
+	if err := s.FUOTA.UpdateDevice(device); err != nil {
+		t.Fatal("Got error updating device: ", err)
+	}
+	other := device
+	other.DeviceEUI = makeRandomEUI()
+	if err := s.FUOTA.UpdateDevice(other); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound when updating unknown device but got %v", err)
+	}
+
+	ch, err := s.FUOTA.GetDevices(campaign.CampaignEUI)
+	if err != nil {
+		t.Fatal("Got error listing devices: ", err)
+	}
+	count := 0
+	for v := range ch {
+		if v != device {
+			t.Fatalf("Device status doesn't match. Got %+v but expected %+v", v, device)
+		}
+		count++
+	}
+	if count != 1 {
+		t.Fatalf("Expected 1 device but got %d", count)
+	}
+
+	if err := s.FUOTA.Delete(campaign.CampaignEUI); err != nil {
+		t.Fatal("Got error removing campaign: ", err)
+	}
+	if err := s.FUOTA.Delete(campaign.CampaignEUI); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound when removing campaign twice but got %v", err)
+	}
+	ch, _ = s.FUOTA.GetDevices(campaign.CampaignEUI)
+	for range ch {
+		t.Fatal("Device status isn't removed with the campaign")
+	}
+}
@@ -18,7 +18,9 @@ package storagetest
 import (
 	"net"
 	"testing"
+	"time"
 
+	"github.com/ExploratoryEngineering/congress/band"
 	"github.com/ExploratoryEngineering/congress/model"
 	"github.com/ExploratoryEngineering/congress/protocol"
 	"github.com/ExploratoryEngineering/congress/storage"
@@ -61,13 +63,16 @@ func testGatewayStorage(gwStorage storage.GatewayStorage, userID model.UserID, t
 	// ...and another one
 	gw2EUI, _ := protocol.EUIFromString("aa-01-02-03-04-05-06-07")
 	gateway2 := model.Gateway{
-		GatewayEUI: gw2EUI,
-		IP:         net.ParseIP("127.0.0.2"),
-		StrictIP:   true,
-		Latitude:   -63.0,
-		Longitude:  -10.0,
-		Altitude:   0.0,
-		Tags:       model.NewTags(),
+		GatewayEUI:   gw2EUI,
+		IP:           net.ParseIP("127.0.0.2"),
+		StrictIP:     true,
+		Latitude:     -63.0,
+		Longitude:    -10.0,
+		Altitude:     0.0,
+		Tags:         model.NewTags(),
+		Band:         band.US915Band,
+		SubBand:      2,
+		StationToken: "token",
 	}
 
 	gateway2.Tags.SetTag("Name", "Value")
@@ -131,13 +136,37 @@ func testGatewayStorage(gwStorage storage.GatewayStorage, userID model.UserID, t
 	gateway1.Longitude = 333
 	gateway1.IP = net.ParseIP("10.10.10.10")
 	gateway1.StrictIP = true
+	gateway1.Band = band.AS923Band2
+	gateway1.SubBand = 0
+	gateway1.StationToken = "new-token"
 	if err := gwStorage.Update(gateway1, userID); err != nil {
 		t.Fatalf("Got error updating gateway: %v", err)
 	}
 	updatedGw, _ := gwStorage.Get(gateway1.GatewayEUI, userID)
-	if updatedGw.Altitude != gateway1.Altitude || updatedGw.Longitude != gateway1.Longitude || updatedGw.IP.String() != gateway1.IP.String() || updatedGw.StrictIP != gateway1.StrictIP {
+	if updatedGw.Altitude != gateway1.Altitude || updatedGw.Longitude != gateway1.Longitude || updatedGw.IP.String() != gateway1.IP.String() || updatedGw.StrictIP != gateway1.StrictIP || updatedGw.Band != gateway1.Band || updatedGw.StationToken != gateway1.StationToken {
 		t.Fatalf("Gateways doesn't match! %v != %v", updatedGw, gateway1)
 	}
+
+	// The location can be updated separately. The altitude is optional.
+	if err := gwStorage.UpdateLocation(gateway1.GatewayEUI, 63.4, 10.4, nil); err != nil {
+		t.Fatalf("Got error updating location: %v", err)
+	}
+	if updatedGw, err := gwStorage.Get(gateway1.GatewayEUI, model.SystemUserID); err != nil || updatedGw.Latitude != 63.4 ||
+		updatedGw.Longitude != 10.4 || updatedGw.Altitude != gateway1.Altitude || updatedGw.Band != gateway1.Band || !updatedGw.StrictIP {
+		t.Fatalf("Location isn't updated: %v (err=%v)", updatedGw, err)
+	}
+	altitude := float32(50)
+	if err := gwStorage.UpdateLocation(gateway1.GatewayEUI, 63.4, 10.4, &altitude); err != nil {
+		t.Fatalf("Got error updating location: %v", err)
+	}
+	if updatedGw, _ := gwStorage.Get(gateway1.GatewayEUI, model.SystemUserID); updatedGw.Altitude != 50 {
+		t.Fatalf("Altitude isn't updated: %v", updatedGw)
+	}
+	if err := gwStorage.UpdateLocation(protocol.EUIFromUint64(0xdead), 1, 2, nil); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for unknown gateway but got %v", err)
+	}
+	testGatewayStatus(gwStorage, gateway1.GatewayEUI, t)
+
 	// Remove both
 	if err := gwStorage.Delete(gateway1.GatewayEUI, userID); err != nil {
 		t.Fatalf("Got error removing gateway #1: %v", err)
@@ -164,10 +193,75 @@ func testGatewayStorage(gwStorage storage.GatewayStorage, userID model.UserID, t
 		t.Fatalf("Got more than 0 elements (got %d)", count)
 	}
 
+	// The status reports are removed with the gateway
+	if list, err := gwStorage.GetStatus(gateway1.GatewayEUI); err != nil || len(list) != 0 {
+		t.Fatalf("Expected no status reports for removed gateway but got %v (err=%v)", list, err)
+	}
+
 	testAllGateways(gwStorage, t)
 
 }
 
+func testGatewayStatus(gwStorage storage.GatewayStorage, eui protocol.EUI, t *testing.T) {
+	if list, err := gwStorage.GetStatus(eui); err != nil || len(list) != 0 {
+		t.Fatalf("Expected empty status list but got %v (err=%v)", list, err)
+	}
+
+	received := time.Unix(1500000000, 0)
+	status1 := model.GatewayStatus{
+		Received:         received,
+		RXReceived:       10,
+		RXOK:             9,
+		RXForwarded:      8,
+		ACKRatio:         50,
+		DownlinkReceived: 2,
+		TXEmitted:        1,
+	}
+	status2 := model.GatewayStatus{
+		Received:    received.Add(30 * time.Second),
+		GatewayTime: received.Add(29 * time.Second).UTC(),
+		RXReceived:  5,
+		HasLocation: true,
+		Latitude:    63.5,
+		Longitude:   10.5,
+		Altitude:    12,
+	}
+	// Store them out of order. The list is sorted on the received time.
+	if err := gwStorage.PutStatus(eui, status2); err != nil {
+		t.Fatalf("Got error storing status: %v", err)
+	}
+	if err := gwStorage.PutStatus(eui, status1); err != nil {
+		t.Fatalf("Got error storing status: %v", err)
+	}
+	if err := gwStorage.PutStatus(protocol.EUIFromUint64(0xdead), status1); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for unknown gateway but got %v", err)
+	}
+
+	list, err := gwStorage.GetStatus(eui)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("Expected two status reports but got %v (err=%v)", list, err)
+	}
+	if !list[0].Received.Equal(status1.Received) || list[0].RXReceived != 10 || list[0].RXOK != 9 ||
+		list[0].RXForwarded != 8 || list[0].ACKRatio != 50 || list[0].DownlinkReceived != 2 ||
+		list[0].TXEmitted != 1 || !list[0].GatewayTime.IsZero() || list[0].HasLocation {
+		t.Fatalf("First status doesn't match: %+v", list[0])
+	}
+	if !list[1].Received.Equal(status2.Received) || !list[1].GatewayTime.Equal(status2.GatewayTime) ||
+		!list[1].HasLocation || list[1].Latitude != 63.5 || list[1].Longitude != 10.5 || list[1].Altitude != 12 {
+		t.Fatalf("Second status doesn't match: %+v", list[1])
+	}
+
+	if err := gwStorage.DeleteStatus(eui, received.Add(time.Second)); err != nil {
+		t.Fatalf("Got error removing status: %v", err)
+	}
+	if err := gwStorage.DeleteStatus(eui, received.Add(time.Second)); err != nil {
+		t.Fatalf("Got error when there's no status to remove: %v", err)
+	}
+	if list, err := gwStorage.GetStatus(eui); err != nil || len(list) != 1 || list[0].RXReceived != 5 {
+		t.Fatalf("Expected one status report but got %v (err=%v)", list, err)
+	}
+}
+
 func testAllGateways(gwStorage storage.GatewayStorage, t *testing.T) {
 	list, err := gwStorage.ListAll()
 	if err != nil {
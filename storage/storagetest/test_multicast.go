@@ -0,0 +1,155 @@
+package storagetest
+
+//
+//Copyright 2018 Telenor Digital AS
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+//
+import (
+	"testing"
+	"time"
+
+	"github.com/ExploratoryEngineering/congress/model"
+	"github.com/ExploratoryEngineering/congress/protocol"
+	"github.com/ExploratoryEngineering/congress/storage"
+)
+
+func testMulticastStorage(s *storage.Storage, t *testing.T) {
+	application := model.NewApplication()
+	application.AppEUI = makeRandomEUI()
+	s.Application.Put(application, model.SystemUserID)
+
+	group := model.NewMulticastGroup()
+	group.GroupEUI = makeRandomEUI()
+	group.AppEUI = application.AppEUI
+	group.McAddr = protocol.DevAddrFromUint32(0x01020304)
+	group.McNwkSKey = makeRandomKey()
+	group.McAppSKey = makeRandomKey()
+	group.FCntDn = 10
+	group.Gateways = []protocol.EUI{makeRandomEUI(), makeRandomEUI()}
+	group.SetTag("name", "value")
+	if err := s.Multicast.Put(group); err != nil {
+		t.Fatal("Got error storing multicast group: ", err)
+	}
+	if err := s.Multicast.Put(group); err != storage.ErrAlreadyExists {
+		t.Fatalf("Expected ErrAlreadyExists when storing group twice but got %v", err)
+	}
+
+	stored, err := s.Multicast.GetByEUI(group.GroupEUI)
+	if err != nil {
+		t.Fatal("Got error retrieving multicast group: ", err)
+	}
+	if stored.McAddr != group.McAddr || stored.McNwkSKey != group.McNwkSKey || stored.McAppSKey != group.McAppSKey ||
+		stored.FCntDn != group.FCntDn || stored.Class != model.ClassC || len(stored.Gateways) != 2 ||
+		stored.Gateways[1] != group.Gateways[1] || !stored.Tags.Equals(group.Tags) {
+		t.Fatalf("Stored group doesn't match. Got %+v but expected %+v", stored, group)
+	}
+
+	// Update doesn't change the frame counter
+	group.Class = model.ClassB
+	group.Periodicity = 3
+	group.DataRate = 2
+	group.Frequency = 869.1
+	group.FCntDn = 0
+	group.Gateways = group.Gateways[:1]
+	if err := s.Multicast.Update(group); err != nil {
+		t.Fatal("Got error updating multicast group: ", err)
+	}
+	if err := s.Multicast.UpdateFCnt(group.GroupEUI, 11); err != nil {
+		t.Fatal("Got error updating frame counter: ", err)
+	}
+	stored, _ = s.Multicast.GetByEUI(group.GroupEUI)
+	if stored.Class != model.ClassB || stored.Periodicity != 3 || stored.DataRate != 2 ||
+		stored.Frequency != 869.1 || stored.FCntDn != 11 || len(stored.Gateways) != 1 {
+		t.Fatalf("Group isn't updated properly: %+v", stored)
+	}
+
+	ch, err := s.Multicast.GetByApplicationEUI(application.AppEUI)
+	if err != nil {
+		t.Fatal("Got error listing multicast groups: ", err)
+	}
+	count := 0
+	for v := range ch {
+		if v.GroupEUI != group.GroupEUI {
+			t.Fatal("Found unknown group: ", v)
+		}
+		count++
+	}
+	if count != 1 {
+		t.Fatalf("Expected 1 group but got %d", count)
+	}
+
+	// Downstream messages
+	msg := model.NewMulticastMessage(group.GroupEUI, 42)
+	msg.Data = "aabbccddeeff"
+	if err := s.Multicast.PutDownstream(group.GroupEUI, msg); err != nil {
+		t.Fatal("Couldn't store multicast message: ", err)
+	}
+	if err := s.Multicast.PutDownstream(group.GroupEUI, msg); err == nil {
+		t.Fatal("Shouldn't be able to store another multicast message")
+	}
+	sentTime := time.Now().Unix()
+	if err := s.Multicast.UpdateDownstream(group.GroupEUI, sentTime); err != nil {
+		t.Fatal("Got error updating multicast message: ", err)
+	}
+	msg.SentTime = sentTime
+	if storedMsg, err := s.Multicast.GetDownstream(group.GroupEUI); err != nil || storedMsg != msg {
+		t.Fatalf("Stored message doesn't match. Got %+v (err=%v) but expected %+v", storedMsg, err, msg)
+	}
+	if err := s.Multicast.DeleteDownstream(group.GroupEUI); err != nil {
+		t.Fatal("Couldn't remove multicast message: ", err)
+	}
+	if _, err := s.Multicast.GetDownstream(group.GroupEUI); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound but got %v", err)
+	}
+	if err := s.Multicast.UpdateDownstream(group.GroupEUI, 0); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound when updating nonexisting message but got %v", err)
+	}
+
+	// Delivery log. The newest attempt is returned first
+	for i := 0; i < 3; i++ {
+		delivery := model.MulticastDelivery{
+			GroupEUI:   group.GroupEUI,
+			GatewayEUI: group.Gateways[0],
+			FCnt:       uint32(i),
+			Time:       int64(i + 1),
+		}
+		if i == 2 {
+			delivery.Error = "gateway is busy"
+		}
+		if err := s.Multicast.AddDelivery(delivery); err != nil {
+			t.Fatal("Got error adding delivery: ", err)
+		}
+	}
+	deliveries, err := s.Multicast.GetDeliveries(group.GroupEUI, 2)
+	if err != nil {
+		t.Fatal("Got error listing deliveries: ", err)
+	}
+	var list []model.MulticastDelivery
+	for v := range deliveries {
+		list = append(list, v)
+	}
+	if len(list) != 2 || list[0].FCnt != 2 || list[0].Error == "" || list[1].FCnt != 1 || list[1].GatewayEUI != group.Gateways[0] {
+		t.Fatalf("Unexpected delivery log: %+v", list)
+	}
+
+	if err := s.Multicast.Delete(group.GroupEUI); err != nil {
+		t.Fatal("Got error deleting multicast group: ", err)
+	}
+	if err := s.Multicast.Delete(group.GroupEUI); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound when deleting group twice but got %v", err)
+	}
+	if _, err := s.Multicast.GetByEUI(group.GroupEUI); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for deleted group but got %v", err)
+	}
+}
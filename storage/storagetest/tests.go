@@ -102,6 +102,12 @@ func DoStorageTests(storageCollection *storage.Storage, t *testing.T) {
 	if storageCollection.UserManagement == nil {
 		t.Fatal("Missing user management storage")
 	}
+	if storageCollection.Multicast == nil {
+		t.Fatal("Missing multicast storage")
+	}
+	if storageCollection.FUOTA == nil {
+		t.Fatal("Missing FUOTA storage")
+	}
 
 	userID := model.UserID("01")
 	storeUser(userID, storageCollection.UserManagement, t)
@@ -119,6 +125,8 @@ func DoStorageTests(storageCollection *storage.Storage, t *testing.T) {
 	testConcurrentSequences(storageCollection.Sequence, t)
 	testOutputStorage(storageCollection, t)
 	testDownstreamStorage(storageCollection, t)
+	testMulticastStorage(storageCollection, t)
+	testFUOTAStorage(storageCollection, t)
 
 	testMultipleOpenClose(storageCollection.UserManagement, storageCollection.Gateway, t)
 }